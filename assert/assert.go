@@ -5,9 +5,40 @@ import (
 	"github.com/bloeys/nmage/logging"
 )
 
+var (
+	diagnosticsFunc func() string
+)
+
+// SetDiagnostics sets a function whose output is logged when an assert fails, just before panicking.
+// This is opt-in, and is useful for dumping state that explains the failure (e.g. engine.GlStateDump).
+// Pass nil to disable
+func SetDiagnostics(f func() string) {
+	diagnosticsFunc = f
+}
+
 func T(check bool, msg string, args ...any) {
 
 	if consts.Debug && !check {
+
+		if diagnosticsFunc != nil {
+			logging.ErrLog.Println("Assert failure diagnostics:\n" + diagnosticsFunc())
+		}
+
 		logging.ErrLog.Panicf("Assert failed: "+msg, args...)
 	}
 }
+
+// Must panics if err isn't nil. Unlike T it also panics in release builds, and is for code that can't continue without
+// what failed (e.g. a framebuffer the game renders into), so that APIs can return errors for the code that can handle them
+func Must(err error) {
+
+	if err != nil {
+		logging.ErrLog.Panicln("Must failed: " + err.Error())
+	}
+}
+
+// MustGet returns v, or panics like Must if err isn't nil
+func MustGet[T any](v T, err error) T {
+	Must(err)
+	return v
+}
@@ -1,10 +1,26 @@
 package assets
 
+import "path/filepath"
+
 var (
 	Textures     = make(map[uint32]Texture)
 	TexturePaths = make(map[string]uint32)
+
+	// Root is the folder relative asset paths are loaded from. Empty means relative to the working directory
+	Root string
 )
 
+// ResolvePath returns the path on disk of an asset path. Absolute paths are returned unchanged,
+// while relative paths are considered relative to the asset root
+func ResolvePath(assetPath string) string {
+
+	if Root == "" || filepath.IsAbs(assetPath) {
+		return assetPath
+	}
+
+	return filepath.Join(Root, assetPath)
+}
+
 func AddTextureToCache(t Texture) {
 
 	if t.Path != "" {
@@ -21,6 +37,12 @@ func AddTextureToCache(t Texture) {
 	TexturePaths[t.Path] = t.TexID
 }
 
+// ClearTextureCache removes all textures from the cache without deleting them, which is needed when the GL context that owned them was lost
+func ClearTextureCache() {
+	clear(Textures)
+	clear(TexturePaths)
+}
+
 func GetTextureFromCacheID(texID uint32) (Texture, bool) {
 	tex, ok := Textures[texID]
 	return tex, ok
@@ -0,0 +1,14 @@
+package assets
+
+// Destroyer is implemented by types that own GPU objects (textures, buffers, framebuffers, etc.), which aren't freed by the garbage collector.
+// Delete must be safe to call more than once, and the object must not be used after it
+type Destroyer interface {
+	Delete()
+}
+
+var (
+	_ Destroyer = &Texture{}
+	_ Destroyer = &Cubemap{}
+	_ Destroyer = &TextureArray{}
+	_ Destroyer = &LightmapAtlas{}
+)
@@ -0,0 +1,104 @@
+package assets
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/leakcheck"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// LightmapAtlas is the baked lighting of a scene packed into one texture, where every lightmapped object
+// samples its own region of the texture using the second uv channel of its mesh.
+//
+// Atlases are described by a json file like:
+//
+//	{
+//	    "texture": "lightmaps/level-1.png",
+//	    "regions": {
+//	        "ground": [0.5, 1, 0, 0],
+//	        "chair": [0.5, 0.5, 0.5, 0]
+//	    }
+//	}
+//
+// where each region is [scaleX, scaleY, offsetX, offsetY], applied in the shader as uv1*scale+offset.
+// The texture is expected in sRGB like other color textures
+type LightmapAtlas struct {
+	Path    string
+	Texture Texture
+
+	// Regions are the scale and offsets of the objects in the atlas by name, ready to be passed to renderer.Render.DrawMeshLightmapped
+	Regions map[string]gglm.Vec4
+}
+
+type lightmapAtlasFile struct {
+	Texture string                `json:"texture"`
+	Regions map[string][4]float32 `json:"regions"`
+}
+
+// Region returns the scale and offset of the named object, or false if the object isn't in the atlas
+func (la *LightmapAtlas) Region(name string) (gglm.Vec4, bool) {
+	r, ok := la.Regions[name]
+	return r, ok
+}
+
+func (la *LightmapAtlas) Delete() {
+	leakcheck.Untrack(leakcheck.ResourceType_Texture, la.Texture.TexID)
+	gl.DeleteTextures(1, &la.Texture.TexID)
+	la.Texture = Texture{}
+	la.Regions = nil
+}
+
+// LoadLightmapAtlas loads the json description of an atlas and its texture, which can be a png or a jpeg
+func LoadLightmapAtlas(file string) (LightmapAtlas, error) {
+
+	fileBytes, err := os.ReadFile(ResolvePath(file))
+	if err != nil {
+		return LightmapAtlas{}, err
+	}
+
+	atlasFile := lightmapAtlasFile{}
+	if err := json.Unmarshal(fileBytes, &atlasFile); err != nil {
+		return LightmapAtlas{}, fmt.Errorf("failed to parse lightmap atlas '%s'. Err: %w", file, err)
+	}
+
+	if atlasFile.Texture == "" {
+		return LightmapAtlas{}, fmt.Errorf("lightmap atlas '%s' has no texture", file)
+	}
+
+	// Lightmaps are sampled once per pixel without minification most of the time, so mipmaps aren't worth the memory
+	var tex Texture
+	ext := strings.ToLower(path.Ext(atlasFile.Texture))
+	if ext == ".jpg" || ext == ".jpeg" {
+		tex, err = LoadTextureJpeg(atlasFile.Texture, &TextureLoadOptions{})
+	} else if ext == ".png" {
+		tex, err = LoadTexturePNG(atlasFile.Texture, &TextureLoadOptions{})
+	} else {
+		return LightmapAtlas{}, fmt.Errorf("lightmap atlas '%s' has texture with unknown image extension: %s. Expected one of: .jpg, .jpeg, .png", file, ext)
+	}
+
+	if err != nil {
+		return LightmapAtlas{}, fmt.Errorf("failed to load texture of lightmap atlas '%s'. Err: %w", file, err)
+	}
+
+	// Regions are usually packed edge to edge, so wrapping would bleed the opposite edge of the atlas into objects
+	gl.BindTexture(gl.TEXTURE_2D, tex.TexID)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+
+	la := LightmapAtlas{
+		Path:    file,
+		Texture: tex,
+		Regions: make(map[string]gglm.Vec4, len(atlasFile.Regions)),
+	}
+
+	for name, r := range atlasFile.Regions {
+		la.Regions[name] = gglm.NewVec4(r[0], r[1], r[2], r[3])
+	}
+
+	return la, nil
+}
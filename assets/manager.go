@@ -0,0 +1,182 @@
+package assets
+
+import (
+	"fmt"
+)
+
+// Handle refers to an asset loaded by a Manager. Handles stay valid until the asset is unloaded, after which Get returns
+// the zero asset, and ids are never reused so an old handle can't point to a newer asset. The zero handle is invalid
+type Handle[T Destroyer] struct {
+	id uint32
+}
+
+func (h Handle[T]) IsValid() bool {
+	return h.id != 0
+}
+
+type managedAsset struct {
+	key      string
+	refCount int32
+	asset    Destroyer
+}
+
+// Manager loads each asset once no matter how many times it is asked for, and deletes it when nothing references it anymore.
+//
+// Assets are identified by a key, which is usually their path with a prefix for the kind of asset (e.g. 'texture:'), so the
+// same file loaded as different things gets different assets. Every Acquire adds a reference that is removed with Release.
+// Other packages add their own loaders on top of Acquire (e.g. meshes.LoadManagedMesh and shaders.LoadManagedCombinedShader),
+// since they can't be imported from here
+type Manager struct {
+	assets map[uint32]*managedAsset
+	keyIds map[string]uint32
+	lastId uint32
+}
+
+// Acquire returns a handle to the asset with the passed key and adds a reference to it. The asset is loaded with load if it isn't loaded yet,
+// otherwise load isn't called. Returns an error if load fails or the key is loaded as a different type
+func Acquire[T Destroyer](m *Manager, key string, load func() (T, error)) (Handle[T], error) {
+
+	if id, ok := m.keyIds[key]; ok {
+
+		ma := m.assets[id]
+		if _, isT := ma.asset.(T); !isT {
+			return Handle[T]{}, fmt.Errorf("failed to acquire asset '%s' as %T because it was loaded as %T", key, *new(T), ma.asset)
+		}
+
+		ma.refCount++
+		return Handle[T]{id: id}, nil
+	}
+
+	asset, err := load()
+	if err != nil {
+		return Handle[T]{}, fmt.Errorf("failed to load asset '%s'. Err: %w", key, err)
+	}
+
+	m.lastId++
+	m.assets[m.lastId] = &managedAsset{
+		key:      key,
+		refCount: 1,
+		asset:    asset,
+	}
+	m.keyIds[key] = m.lastId
+
+	return Handle[T]{id: m.lastId}, nil
+}
+
+// Get returns the asset of the handle, or the zero T if the handle is invalid or its asset was unloaded
+func Get[T Destroyer](m *Manager, h Handle[T]) T {
+
+	ma, ok := m.assets[h.id]
+	if !ok {
+		var zero T
+		return zero
+	}
+
+	return ma.asset.(T)
+}
+
+// Release removes a reference added by Acquire, and unloads the asset once no references are left.
+// Returns true if the asset was unloaded
+func Release[T Destroyer](m *Manager, h Handle[T]) bool {
+
+	ma, ok := m.assets[h.id]
+	if !ok {
+		return false
+	}
+
+	ma.refCount--
+	if ma.refCount > 0 {
+		return false
+	}
+
+	m.unload(h.id, ma)
+	return true
+}
+
+// Unload deletes the asset of the handle whatever its reference count, which invalidates all handles to it
+func Unload[T Destroyer](m *Manager, h Handle[T]) {
+
+	if ma, ok := m.assets[h.id]; ok {
+		m.unload(h.id, ma)
+	}
+}
+
+func (m *Manager) unload(id uint32, ma *managedAsset) {
+	ma.asset.Delete()
+	delete(m.assets, id)
+	delete(m.keyIds, ma.key)
+}
+
+// UnloadAll deletes every asset of the manager, e.g. when changing levels
+func (m *Manager) UnloadAll() {
+
+	for id, ma := range m.assets {
+		m.unload(id, ma)
+	}
+}
+
+// RefCount returns the number of references to the asset with the passed key, which is zero if it isn't loaded
+func (m *Manager) RefCount(key string) int32 {
+
+	if id, ok := m.keyIds[key]; ok {
+		return m.assets[id].refCount
+	}
+
+	return 0
+}
+
+// Len returns the number of loaded assets
+func (m *Manager) Len() int {
+	return len(m.assets)
+}
+
+// LoadTexturePNG is the package level LoadTexturePNG through the manager, so a file loaded with the same options is uploaded once
+func (m *Manager) LoadTexturePNG(file string, loadOptions *TextureLoadOptions) (Handle[*Texture], error) {
+
+	opts := managedTextureLoadOptions(loadOptions)
+	return Acquire(m, textureKey(file, &opts), func() (*Texture, error) {
+		tex, err := LoadTexturePNG(file, &opts)
+		return &tex, err
+	})
+}
+
+// LoadTextureJpeg is the package level LoadTextureJpeg through the manager, so a file loaded with the same options is uploaded once
+func (m *Manager) LoadTextureJpeg(file string, loadOptions *TextureLoadOptions) (Handle[*Texture], error) {
+
+	opts := managedTextureLoadOptions(loadOptions)
+	return Acquire(m, textureKey(file, &opts), func() (*Texture, error) {
+		tex, err := LoadTextureJpeg(file, &opts)
+		return &tex, err
+	})
+}
+
+// managedTextureLoadOptions copies the options without the texture cache, since the manager deletes its textures
+// once released and must not take over or leave behind a cached one
+func managedTextureLoadOptions(loadOptions *TextureLoadOptions) TextureLoadOptions {
+
+	if loadOptions == nil {
+		return TextureLoadOptions{}
+	}
+
+	opts := *loadOptions
+	opts.TryLoadFromCache = false
+	opts.WriteToCache = false
+	return opts
+}
+
+// textureKey includes the options that change the uploaded texture, since a file loaded as linear and as sRGB are different textures
+func textureKey(file string, loadOptions *TextureLoadOptions) string {
+
+	if loadOptions == nil {
+		loadOptions = &TextureLoadOptions{}
+	}
+
+	return fmt.Sprintf("texture:%s:linear=%t:mips=%t", file, loadOptions.NoSrgba, loadOptions.GenMipMaps)
+}
+
+func NewManager() Manager {
+	return Manager{
+		assets: make(map[uint32]*managedAsset),
+		keyIds: make(map[string]uint32),
+	}
+}
@@ -0,0 +1,193 @@
+package assets
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"unsafe"
+
+	"github.com/bloeys/nmage/leakcheck"
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/mandykoh/prism"
+)
+
+// TextureArray packs same-sized RGBA textures into the layers of a 2D array texture.
+//
+// Objects whose only difference is their texture can then share one material and be instanced or batched together,
+// with each instance picking its layer (e.g. through renderer.InstanceData.MaterialIndex) and sampling with:
+//
+//	uniform sampler2DArray diffuseTexArray;
+//	texture(diffuseTexArray, vec3(uv, layer))
+type TextureArray struct {
+	TexID uint32
+
+	// Width and Height are the size of every layer in pixels
+	Width  int32
+	Height int32
+
+	// LayerCapacity is how many layers were allocated, and LayerCount is how many are used
+	LayerCapacity int32
+	LayerCount    int32
+
+	// layerByPath allows adding the same file more than once without using extra layers
+	layerByPath map[string]int32
+}
+
+// AddLayer uploads RGBA8 pixels (stored bottom row first, like Texture.Pixels) into the next free layer and returns its index.
+// The pixels must be exactly the size of the array's layers
+func (ta *TextureArray) AddLayer(pixels []byte, width, height int32) (layer int32, err error) {
+
+	if width != ta.Width || height != ta.Height {
+		return -1, fmt.Errorf("failed to add texture array layer because its size (%dx%d) isn't the array's layer size (%dx%d)", width, height, ta.Width, ta.Height)
+	}
+
+	if len(pixels) != int(width*height*4) {
+		return -1, fmt.Errorf("failed to add texture array layer because it has %d bytes of pixels but a %dx%d RGBA8 layer needs %d", len(pixels), width, height, width*height*4)
+	}
+
+	if ta.LayerCount >= ta.LayerCapacity {
+		return -1, fmt.Errorf("failed to add texture array layer because the array is full. Capacity=%d", ta.LayerCapacity)
+	}
+
+	layer = ta.LayerCount
+	ta.LayerCount++
+
+	gl.BindTexture(gl.TEXTURE_2D_ARRAY, ta.TexID)
+	gl.TexSubImage3D(gl.TEXTURE_2D_ARRAY, 0, 0, 0, layer, width, height, 1, gl.RGBA, gl.UNSIGNED_BYTE, unsafe.Pointer(&pixels[0]))
+
+	return layer, nil
+}
+
+// AddLayerFromFile loads a png or jpeg image into the next free layer and returns its index.
+// Adding the same file again returns the layer it was added to the first time
+func (ta *TextureArray) AddLayerFromFile(file string) (layer int32, err error) {
+
+	if layer, ok := ta.layerByPath[file]; ok {
+		return layer, nil
+	}
+
+	var imgDecoder func(r io.Reader) (image.Image, error)
+	ext := strings.ToLower(path.Ext(file))
+	if ext == ".jpg" || ext == ".jpeg" {
+		imgDecoder = jpeg.Decode
+	} else if ext == ".png" {
+		imgDecoder = png.Decode
+	} else {
+		return -1, fmt.Errorf("unknown image extension: %s. Expected one of: .jpg, .jpeg, .png", ext)
+	}
+
+	//Load from disk
+	fileBytes, err := os.ReadFile(ResolvePath(file))
+	if err != nil {
+		return -1, err
+	}
+
+	img, err := imgDecoder(bytes.NewReader(fileBytes))
+	if err != nil {
+		return -1, err
+	}
+
+	nrgbaImg := prism.ConvertImageToNRGBA(img, 2)
+	width := int32(nrgbaImg.Bounds().Dx())
+	height := int32(nrgbaImg.Bounds().Dy())
+	flipImgPixelsVertically(nrgbaImg.Pix, int(width), int(height), 4)
+
+	layer, err = ta.AddLayer(nrgbaImg.Pix, width, height)
+	if err != nil {
+		return -1, fmt.Errorf("failed to add '%s' to texture array. Err: %w", file, err)
+	}
+
+	ta.layerByPath[file] = layer
+	return layer, nil
+}
+
+// AddTexture copies a texture into the next free layer and returns its index.
+// The texture must still have its pixels in memory (see TextureLoadOptions.KeepPixelsInMem)
+func (ta *TextureArray) AddTexture(tex *Texture) (layer int32, err error) {
+
+	if len(tex.Pixels) == 0 {
+		return -1, fmt.Errorf("failed to add texture (id=%d; path='%s') to texture array because its pixels aren't in memory", tex.TexID, tex.Path)
+	}
+
+	if tex.Path != "" {
+		if layer, ok := ta.layerByPath[tex.Path]; ok {
+			return layer, nil
+		}
+	}
+
+	layer, err = ta.AddLayer(tex.Pixels, tex.Width, tex.Height)
+	if err != nil {
+		return -1, err
+	}
+
+	if tex.Path != "" {
+		ta.layerByPath[tex.Path] = layer
+	}
+
+	return layer, nil
+}
+
+// GenMipMaps generates mipmaps for all layers. Call after adding layers
+func (ta *TextureArray) GenMipMaps() {
+	gl.BindTexture(gl.TEXTURE_2D_ARRAY, ta.TexID)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_MIN_FILTER, gl.LINEAR_MIPMAP_LINEAR)
+	gl.GenerateMipmap(gl.TEXTURE_2D_ARRAY)
+}
+
+func (ta *TextureArray) Delete() {
+	leakcheck.Untrack(leakcheck.ResourceType_Texture, ta.TexID)
+	gl.DeleteTextures(1, &ta.TexID)
+	ta.TexID = 0
+	ta.LayerCount = 0
+	ta.layerByPath = nil
+}
+
+// NewTextureArray allocates an array texture of layerCapacity layers of width x height. Only the 'NoSrgba' and 'GenMipMaps' options are used,
+// where GenMipMaps only allocates the mip levels and GenMipMaps must be called on the array after its layers are added
+func NewTextureArray(width, height, layerCapacity int32, loadOptions *TextureLoadOptions) TextureArray {
+
+	if loadOptions == nil {
+		loadOptions = &TextureLoadOptions{}
+	}
+
+	ta := TextureArray{
+		Width:         width,
+		Height:        height,
+		LayerCapacity: layerCapacity,
+		layerByPath:   make(map[string]int32, layerCapacity),
+	}
+
+	internalFormat := int32(gl.SRGB8_ALPHA8)
+	if loadOptions.NoSrgba {
+		internalFormat = gl.RGBA8
+	}
+
+	gl.GenTextures(1, &ta.TexID)
+	leakcheck.Track(leakcheck.ResourceType_Texture, ta.TexID)
+	gl.BindTexture(gl.TEXTURE_2D_ARRAY, ta.TexID)
+
+	mipLevels := int32(1)
+	if loadOptions.GenMipMaps {
+		for size := max(width, height); size > 1; size /= 2 {
+			mipLevels++
+		}
+	}
+
+	for level := int32(0); level < mipLevels; level++ {
+		gl.TexImage3D(gl.TEXTURE_2D_ARRAY, level, internalFormat, max(width>>level, 1), max(height>>level, 1), layerCapacity, 0, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+	}
+
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_MAX_LEVEL, mipLevels-1)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_WRAP_S, gl.REPEAT)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_WRAP_T, gl.REPEAT)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+
+	return ta
+}
@@ -12,6 +12,7 @@ import (
 	"strings"
 	"unsafe"
 
+	"github.com/bloeys/nmage/leakcheck"
 	"github.com/go-gl/gl/v4.1-core/gl"
 	"github.com/mandykoh/prism"
 )
@@ -82,7 +83,7 @@ func LoadTexturePNG(file string, loadOptions *TextureLoadOptions) (Texture, erro
 	}
 
 	//Load from disk
-	fileBytes, err := os.ReadFile(file)
+	fileBytes, err := os.ReadFile(ResolvePath(file))
 	if err != nil {
 		return Texture{}, err
 	}
@@ -104,6 +105,7 @@ func LoadTexturePNG(file string, loadOptions *TextureLoadOptions) (Texture, erro
 
 	//Prepare opengl stuff
 	gl.GenTextures(1, &tex.TexID)
+	leakcheck.Track(leakcheck.ResourceType_Texture, tex.TexID)
 	gl.BindTexture(gl.TEXTURE_2D, tex.TexID)
 
 	// set the texture wrapping/filtering options (on the currently bound texture object)
@@ -152,6 +154,7 @@ func LoadTextureInMemPngImg(img image.Image, loadOptions *TextureLoadOptions) (T
 
 	//Prepare opengl stuff
 	gl.GenTextures(1, &tex.TexID)
+	leakcheck.Track(leakcheck.ResourceType_Texture, tex.TexID)
 	gl.BindTexture(gl.TEXTURE_2D, tex.TexID)
 
 	// set the texture wrapping/filtering options (on the currently bound texture object)
@@ -183,6 +186,33 @@ func LoadTextureInMemPngImg(img image.Image, loadOptions *TextureLoadOptions) (T
 	return tex, nil
 }
 
+// NewTextureRGBA32F creates a float texture from width*height RGBA pixels stored row by row (bottom row first).
+// It is linearly filtered and clamped to its edges, which suits lookup tables rather than images
+func NewTextureRGBA32F(pixels []float32, width, height int32) (Texture, error) {
+
+	if len(pixels) != int(width*height*4) {
+		return Texture{}, fmt.Errorf("failed to create float texture because it has %d floats but a %dx%d RGBA texture needs %d", len(pixels), width, height, width*height*4)
+	}
+
+	tex := Texture{
+		Width:  width,
+		Height: height,
+	}
+
+	gl.GenTextures(1, &tex.TexID)
+	leakcheck.Track(leakcheck.ResourceType_Texture, tex.TexID)
+	gl.BindTexture(gl.TEXTURE_2D, tex.TexID)
+
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA32F, width, height, 0, gl.RGBA, gl.FLOAT, unsafe.Pointer(&pixels[0]))
+
+	return tex, nil
+}
+
 func LoadTextureJpeg(file string, loadOptions *TextureLoadOptions) (Texture, error) {
 
 	if loadOptions == nil {
@@ -196,7 +226,7 @@ func LoadTextureJpeg(file string, loadOptions *TextureLoadOptions) (Texture, err
 	}
 
 	//Load from disk
-	fileBytes, err := os.ReadFile(file)
+	fileBytes, err := os.ReadFile(ResolvePath(file))
 	if err != nil {
 		return Texture{}, err
 	}
@@ -217,6 +247,7 @@ func LoadTextureJpeg(file string, loadOptions *TextureLoadOptions) (Texture, err
 
 	//Prepare opengl stuff
 	gl.GenTextures(1, &tex.TexID)
+	leakcheck.Track(leakcheck.ResourceType_Texture, tex.TexID)
 	gl.BindTexture(gl.TEXTURE_2D, tex.TexID)
 
 	// set the texture wrapping/filtering options (on the currently bound texture object)
@@ -275,6 +306,7 @@ func LoadCubemapTextures(rightTex, leftTex, topTex, botTex, frontTex, backTex st
 	}
 
 	gl.GenTextures(1, &cmap.TexID)
+	leakcheck.Track(leakcheck.ResourceType_Texture, cmap.TexID)
 	gl.BindTexture(gl.TEXTURE_CUBE_MAP, cmap.TexID)
 
 	// The order here matters
@@ -284,7 +316,7 @@ func LoadCubemapTextures(rightTex, leftTex, topTex, botTex, frontTex, backTex st
 		fPath := texturePaths[i]
 
 		//Load from disk
-		fileBytes, err := os.ReadFile(fPath)
+		fileBytes, err := os.ReadFile(ResolvePath(fPath))
 		if err != nil {
 			return Cubemap{}, err
 		}
@@ -316,6 +348,35 @@ func LoadCubemapTextures(rightTex, leftTex, topTex, botTex, frontTex, backTex st
 	return cmap, nil
 }
 
+// Delete deletes the texture and removes it from the texture cache
+func (t *Texture) Delete() {
+
+	if t.TexID == 0 {
+		return
+	}
+
+	if cachedId, ok := TexturePaths[t.Path]; ok && cachedId == t.TexID {
+		delete(TexturePaths, t.Path)
+	}
+	delete(Textures, t.TexID)
+
+	leakcheck.Untrack(leakcheck.ResourceType_Texture, t.TexID)
+	gl.DeleteTextures(1, &t.TexID)
+	t.TexID = 0
+	t.Pixels = nil
+}
+
+func (c *Cubemap) Delete() {
+
+	if c.TexID == 0 {
+		return
+	}
+
+	leakcheck.Untrack(leakcheck.ResourceType_Texture, c.TexID)
+	gl.DeleteTextures(1, &c.TexID)
+	c.TexID = 0
+}
+
 func flipImgPixelsVertically(bytes []byte, width, height, bytesPerPixel int) {
 
 	// Flip the image vertically such that (e.g. in an image of 10 rows) rows 0<->9, 1<->8, 2<->7 etc are swapped.
@@ -0,0 +1,198 @@
+package audio
+
+import (
+	"fmt"
+
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/camera"
+	"github.com/bloeys/nmage/logging"
+	"github.com/veandco/go-sdl2/mix"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+const (
+	// MaxChannels is the max number of sounds that can play at the same time
+	MaxChannels = 32
+
+	// allChannels tells SDL_mixer to apply an operation to all channels
+	allChannels = -1
+)
+
+type Listener struct {
+	Pos     gglm.Vec3
+	Forward gglm.Vec3
+	Up      gglm.Vec3
+}
+
+var (
+	isInited = false
+
+	listener = Listener{
+		Forward: gglm.NewVec3(0, 0, -1),
+		Up:      gglm.NewVec3(0, 1, 0),
+	}
+
+	// channelSources holds the source currently playing on each channel, or nil
+	channelSources [MaxChannels]*Source
+)
+
+// Init opens the default audio device and allocates the mixing channels.
+// Must be called after engine.Init
+func Init() error {
+
+	if isInited {
+		return nil
+	}
+
+	err := sdl.InitSubSystem(sdl.INIT_AUDIO)
+	if err != nil {
+		return fmt.Errorf("failed to init SDL audio subsystem. Err: %w", err)
+	}
+
+	// WAV support is built in, so OGG is the only format we need to explicitly init
+	err = mix.Init(mix.INIT_OGG)
+	if err != nil {
+		sdl.QuitSubSystem(sdl.INIT_AUDIO)
+		return fmt.Errorf("failed to init SDL_mixer with OGG support. Err: %w", err)
+	}
+
+	// MP3 is only used for music so we can run without it
+	err = mix.Init(mix.INIT_OGG | mix.INIT_MP3)
+	if err != nil {
+		logging.WarnLog.Printf("SDL_mixer MP3 support not available, so MP3 music will fail to load. Err: %v\n", err)
+	}
+
+	err = mix.OpenAudio(mix.DEFAULT_FREQUENCY, mix.DEFAULT_FORMAT, 2, 1024)
+	if err != nil {
+		mix.Quit()
+		sdl.QuitSubSystem(sdl.INIT_AUDIO)
+		return fmt.Errorf("failed to open audio device. Err: %w", err)
+	}
+
+	mix.AllocateChannels(MaxChannels)
+	initDsp()
+
+	isInited = true
+	return nil
+}
+
+func IsInited() bool {
+	return isInited
+}
+
+func DeInit() {
+
+	if !isInited {
+		return
+	}
+
+	mix.HaltChannel(allChannels)
+	for i := 0; i < len(channelSources); i++ {
+		releaseChannel(i)
+	}
+
+	cancelNextMusic()
+	freeCancelledMusicLoads(true)
+	if musicState.currentIsOwned {
+		musicState.current.Delete()
+	}
+	mix.HaltMusic()
+
+	mix.CloseAudio()
+
+	// Closing the device drops all registered effects
+	channelHasDsp = [MaxChannels]bool{}
+
+	mix.Quit()
+	sdl.QuitSubSystem(sdl.INIT_AUDIO)
+	isInited = false
+}
+
+// SetListener sets the position and orientation that 3D sources are heard from.
+// Forward and up don't have to be normalized
+func SetListener(pos, forward, up *gglm.Vec3) {
+	listener.Pos = *pos
+	listener.Forward = *forward
+	listener.Up = *up
+}
+
+// SetListenerFromCamera makes 3D sources heard from the position and orientation of the camera.
+// This is usually called every frame with the active camera
+func SetListenerFromCamera(cam *camera.Camera) {
+	SetListener(&cam.Pos, &cam.Forward, &cam.WorldUp)
+}
+
+func GetListener() Listener {
+	return listener
+}
+
+// Update frees finished channels, updates the volume and panning of playing 3D sources
+// based on the listener, advances music crossfades and updates effects. Called by the engine every frame
+func Update() {
+
+	if !isInited {
+		return
+	}
+
+	updateMusic()
+	updateReverbZones()
+	updateDsp()
+
+	listenerRight := listenerRightDir()
+	for i := 0; i < len(channelSources); i++ {
+
+		s := channelSources[i]
+		if s == nil {
+			continue
+		}
+
+		if mix.Playing(i) == 0 {
+			releaseChannel(i)
+			continue
+		}
+
+		s.applyVolumeAndPan(&listenerRight)
+	}
+}
+
+// claimChannel makes the source the owner of the channel, and makes the previous owner (if any) stop tracking it
+func claimChannel(channel int, s *Source) {
+
+	if channel < 0 || channel >= MaxChannels {
+		logging.WarnLog.Printf("Audio channel %d is outside the range of managed channels\n", channel)
+		return
+	}
+
+	releaseChannel(channel)
+	channelSources[channel] = s
+	s.channel = channel
+
+	attachChannelDsp(channel, s.Bus)
+
+	if isBusPausedEffective(s.Bus) {
+		mix.Pause(channel)
+	}
+}
+
+func releaseChannel(channel int) {
+
+	s := channelSources[channel]
+	if s == nil {
+		return
+	}
+
+	s.channel = -1
+	channelSources[channel] = nil
+
+	detachChannelDsp(channel)
+}
+
+func listenerRightDir() gglm.Vec3 {
+
+	right := gglm.Cross(&listener.Forward, &listener.Up)
+	if right.SqrMag() > 0 {
+		right.Normalize()
+	}
+
+	return right
+}
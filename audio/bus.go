@@ -0,0 +1,167 @@
+package audio
+
+import (
+	"github.com/bloeys/nmage/assert"
+	"github.com/veandco/go-sdl2/mix"
+)
+
+// BusId identifies a mixer bus. Every source plays through a bus, and every bus goes through the master bus.
+type BusId uint8
+
+const (
+	BusId_Master BusId = iota
+	BusId_Music
+	BusId_Sfx
+
+	busCount
+)
+
+func (b BusId) String() string {
+	switch b {
+	case BusId_Master:
+		return "Master"
+	case BusId_Music:
+		return "Music"
+	case BusId_Sfx:
+		return "Sfx"
+	default:
+		return "Unknown"
+	}
+}
+
+// Bus groups sounds so their volume, pausing and effects can be controlled together.
+//
+// Effects (low-pass and reverb) are supported on the master and sfx buses. Music in SDL_mixer doesn't play on a
+// channel so it can't have its own effects, but it is still affected by the effects of the master bus.
+type Bus struct {
+	// Volume is in the range [0,1]
+	Volume   float32
+	IsPaused bool
+
+	// LowPassCutoffHz removes frequencies above the cutoff (e.g. for an underwater effect). Zero disables the filter
+	LowPassCutoffHz float32
+
+	Reverb ReverbSettings
+}
+
+var (
+	buses = [busCount]Bus{
+		BusId_Master: {Volume: 1},
+		BusId_Music:  {Volume: 1},
+		BusId_Sfx:    {Volume: 1},
+	}
+
+	// areBusEffectsDirty is set when bus effect params change so they get sent to the audio thread in the next update
+	areBusEffectsDirty = true
+)
+
+func GetBus(id BusId) Bus {
+	return buses[id]
+}
+
+// SetBusVolume sets the volume of the bus in the range [0,1]
+func SetBusVolume(id BusId, volume float32) {
+
+	buses[id].Volume = volume
+
+	if id == BusId_Master || id == BusId_Music {
+		applyMusicVolume()
+	}
+
+	applyAllSourceVolumes()
+}
+
+func GetBusVolume(id BusId) float32 {
+	return buses[id].Volume
+}
+
+// PauseBus pauses all sounds playing on the bus. Pausing the master bus pauses everything
+func PauseBus(id BusId) {
+
+	buses[id].IsPaused = true
+
+	for i := 0; i < len(channelSources); i++ {
+
+		s := channelSources[i]
+		if s == nil || (id != BusId_Master && s.Bus != id) {
+			continue
+		}
+
+		mix.Pause(i)
+	}
+
+	if id == BusId_Master || id == BusId_Music {
+		mix.PauseMusic()
+	}
+}
+
+// ResumeBus resumes sounds on the bus, except ones that were paused by calling Pause on their source directly,
+// or ones whose other bus (i.e. the master bus or their own bus) is still paused
+func ResumeBus(id BusId) {
+
+	buses[id].IsPaused = false
+
+	for i := 0; i < len(channelSources); i++ {
+
+		s := channelSources[i]
+		if s == nil || s.isUserPaused || isBusPausedEffective(s.Bus) {
+			continue
+		}
+
+		mix.Resume(i)
+	}
+
+	if !isBusPausedEffective(BusId_Music) && !musicState.isUserPaused {
+		mix.ResumeMusic()
+	}
+}
+
+func IsBusPaused(id BusId) bool {
+	return buses[id].IsPaused
+}
+
+// SetBusLowPass sets the low-pass cutoff frequency of the bus. Zero disables the filter
+func SetBusLowPass(id BusId, cutoffHz float32) {
+	assert.T(id != BusId_Music, "Effects are not supported on the music bus")
+	buses[id].LowPassCutoffHz = cutoffHz
+	areBusEffectsDirty = true
+}
+
+func SetBusReverb(id BusId, reverb ReverbSettings) {
+	assert.T(id != BusId_Music, "Effects are not supported on the music bus")
+	buses[id].Reverb = reverb
+	areBusEffectsDirty = true
+}
+
+// busVolumeEffective returns the volume of the bus multiplied by the master volume
+func busVolumeEffective(id BusId) float32 {
+
+	if id == BusId_Master {
+		return buses[BusId_Master].Volume
+	}
+
+	return buses[id].Volume * buses[BusId_Master].Volume
+}
+
+// busHasEffects also considers reverb zones
+func busHasEffects(id BusId) bool {
+	return buses[id].LowPassCutoffHz > 0 || effectiveBusReverb(id).Wet > 0
+}
+
+func isBusPausedEffective(id BusId) bool {
+	return buses[id].IsPaused || buses[BusId_Master].IsPaused
+}
+
+func applyAllSourceVolumes() {
+
+	listenerRight := listenerRightDir()
+	for i := 0; i < len(channelSources); i++ {
+
+		s := channelSources[i]
+		if s == nil {
+			continue
+		}
+
+		s.applyVolumeAndPan(&listenerRight)
+	}
+}
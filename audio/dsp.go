@@ -0,0 +1,350 @@
+package audio
+
+import (
+	"math"
+	"unsafe"
+
+	"github.com/bloeys/nmage/logging"
+	"github.com/veandco/go-sdl2/mix"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// ReverbSettings controls a simple Schroeder/Freeverb style reverb
+type ReverbSettings struct {
+	// Wet is how much of the reverb is mixed into the output in the range [0,1]. Zero disables reverb
+	Wet float32
+
+	// RoomSize controls how long the reverb tail is in the range [0,1]
+	RoomSize float32
+
+	// Damping controls how fast high frequencies die out in the range [0,1]
+	Damping float32
+}
+
+func DefaultReverbSettings() ReverbSettings {
+	return ReverbSettings{
+		Wet:      0.3,
+		RoomSize: 0.8,
+		Damping:  0.3,
+	}
+}
+
+const (
+	// maxSpeakers is the max number of output channels the effects handle.
+	// 8 covers everything up to 7.1
+	maxSpeakers = 8
+
+	// Reverb is only applied on the first two speakers (i.e. front left and right)
+	reverbSpeakers = 2
+
+	// Extra delay added to the right speaker's filters to make the reverb stereo
+	reverbStereoSpread = 23
+)
+
+// Freeverb tunings in samples at 44.1kHz
+var (
+	reverbCombLengths    = [...]int{1116, 1188, 1277, 1356}
+	reverbAllPassLengths = [...]int{556, 441}
+)
+
+type delayLine struct {
+	buf   []float32
+	index int
+
+	// filterStore is the state of the low-pass filter inside comb filters
+	filterStore float32
+}
+
+func (d *delayLine) comb(x, feedback, damp float32) float32 {
+
+	out := d.buf[d.index]
+	d.filterStore = out*(1-damp) + d.filterStore*damp
+	d.buf[d.index] = x + d.filterStore*feedback
+
+	d.index++
+	if d.index == len(d.buf) {
+		d.index = 0
+	}
+
+	return out
+}
+
+func (d *delayLine) allPass(x float32) float32 {
+
+	const allPassFeedback = 0.5
+
+	bufOut := d.buf[d.index]
+	d.buf[d.index] = x + bufOut*allPassFeedback
+
+	d.index++
+	if d.index == len(d.buf) {
+		d.index = 0
+	}
+
+	return bufOut - x
+}
+
+type reverbState struct {
+	combs     [reverbSpeakers][len(reverbCombLengths)]delayLine
+	allPasses [reverbSpeakers][len(reverbAllPassLengths)]delayLine
+}
+
+func newReverbState(frequency int) *reverbState {
+
+	scale := float64(frequency) / 44100
+	r := &reverbState{}
+	for spk := 0; spk < reverbSpeakers; spk++ {
+
+		for i := 0; i < len(reverbCombLengths); i++ {
+			r.combs[spk][i].buf = make([]float32, int(float64(reverbCombLengths[i]+spk*reverbStereoSpread)*scale))
+		}
+
+		for i := 0; i < len(reverbAllPassLengths); i++ {
+			r.allPasses[spk][i].buf = make([]float32, int(float64(reverbAllPassLengths[i]+spk*reverbStereoSpread)*scale))
+		}
+	}
+
+	return r
+}
+
+// dspParams are the effect params of a bus as seen by the audio thread
+type dspParams struct {
+	// lowPassAlpha is the smoothing factor of the one pole low-pass filter. Zero means disabled
+	lowPassAlpha float32
+
+	reverbWet      float32
+	reverbFeedback float32
+	reverbDamp     float32
+}
+
+func (p *dspParams) isEnabled() bool {
+	return p.lowPassAlpha > 0 || p.reverbWet > 0
+}
+
+// dspState is the state of the effects of one channel (or of the final mix)
+type dspState struct {
+	bus BusId
+
+	// enabled is false when the channel has our effect registered but its current source doesn't need it
+	enabled      bool
+	lowPassStore [maxSpeakers]float32
+
+	// reverb is only allocated once needed because it's relatively large
+	reverb *reverbState
+}
+
+func (d *dspState) reset() {
+
+	d.lowPassStore = [maxSpeakers]float32{}
+
+	if d.reverb == nil {
+		return
+	}
+
+	for spk := 0; spk < reverbSpeakers; spk++ {
+
+		for i := 0; i < len(d.reverb.combs[spk]); i++ {
+			clear(d.reverb.combs[spk][i].buf)
+			d.reverb.combs[spk][i].filterStore = 0
+		}
+
+		for i := 0; i < len(d.reverb.allPasses[spk]); i++ {
+			clear(d.reverb.allPasses[spk][i].buf)
+		}
+	}
+}
+
+// process applies the effects on a stream of interleaved signed 16-bit samples
+func (d *dspState) process(p *dspParams, stream []byte) {
+
+	if !p.isEnabled() || len(stream) < 2 {
+		return
+	}
+
+	samples := unsafe.Slice((*int16)(unsafe.Pointer(&stream[0])), len(stream)/2)
+	speakers := min(outputSpec.speakers, maxSpeakers)
+
+	for i := 0; i+speakers <= len(samples); i += speakers {
+		for spk := 0; spk < speakers; spk++ {
+
+			x := float32(samples[i+spk]) / math.MaxInt16
+
+			if p.lowPassAlpha > 0 {
+				d.lowPassStore[spk] += p.lowPassAlpha * (x - d.lowPassStore[spk])
+				x = d.lowPassStore[spk]
+			}
+
+			if p.reverbWet > 0 && spk < reverbSpeakers && d.reverb != nil {
+				x = d.applyReverb(p, spk, x)
+			}
+
+			samples[i+spk] = int16(max(-1, min(x, 1)) * math.MaxInt16)
+		}
+	}
+}
+
+func (d *dspState) applyReverb(p *dspParams, spk int, x float32) float32 {
+
+	// Input gain as used by Freeverb to keep the combined combs from clipping
+	const reverbInputGain = 0.015
+
+	in := x * reverbInputGain
+
+	var out float32
+	combs := &d.reverb.combs[spk]
+	for i := 0; i < len(combs); i++ {
+		out += combs[i].comb(in, p.reverbFeedback, p.reverbDamp)
+	}
+
+	allPasses := &d.reverb.allPasses[spk]
+	for i := 0; i < len(allPasses); i++ {
+		out = allPasses[i].allPass(out)
+	}
+
+	return x*(1-p.reverbWet) + out*p.reverbWet
+}
+
+var (
+	outputSpec struct {
+		frequency int
+		speakers  int
+		isS16     bool
+	}
+
+	// busDspParams and channelDsp are read by the audio thread, so they must only be changed with the audio device locked
+	busDspParams [busCount]dspParams
+	channelDsp   [MaxChannels]dspState
+	masterDsp    dspState
+
+	// channelHasDsp tracks which channels have our effect registered. SDL_mixer removes effects when a channel finishes
+	// or is halted, so it is cleared when a source releases the channel
+	channelHasDsp [MaxChannels]bool
+)
+
+func initDsp() {
+
+	frequency, format, speakers, _, err := mix.QuerySpec()
+	if err != nil {
+		logging.ErrLog.Printf("Failed to query audio spec, so audio effects will be disabled. Err: %v\n", err)
+		return
+	}
+
+	outputSpec.frequency = frequency
+	outputSpec.speakers = speakers
+	outputSpec.isS16 = format == sdl.AUDIO_S16SYS
+	if !outputSpec.isS16 {
+		logging.WarnLog.Printf("Audio effects only support signed 16-bit samples, but audio device format is %d, so audio effects will be disabled\n", format)
+		return
+	}
+
+	// The master bus effects are applied once on the final mix, which includes music
+	masterDsp.bus = BusId_Master
+	err = mix.RegisterEffect(mix.CHANNEL_POST, func(channel int, stream []byte) {
+		masterDsp.process(&busDspParams[BusId_Master], stream)
+	}, func(channel int) {})
+	if err != nil {
+		logging.ErrLog.Printf("Failed to register master bus audio effects. Err: %v\n", err)
+	}
+}
+
+// updateDsp sends changed bus effect params to the audio thread and adds effects to playing channels that need them
+func updateDsp() {
+
+	if !outputSpec.isS16 || !areBusEffectsDirty {
+		return
+	}
+
+	areBusEffectsDirty = false
+
+	sdl.LockAudio()
+	defer sdl.UnlockAudio()
+
+	for i := BusId(0); i < busCount; i++ {
+
+		b := &buses[i]
+		p := &busDspParams[i]
+
+		p.lowPassAlpha = 0
+		if b.LowPassCutoffHz > 0 {
+			p.lowPassAlpha = 1 - float32(math.Exp(-2*math.Pi*float64(b.LowPassCutoffHz)/float64(outputSpec.frequency)))
+		}
+
+		// Feedback and damp ranges are from Freeverb
+		reverb := effectiveBusReverb(i)
+		p.reverbWet = reverb.Wet
+		p.reverbFeedback = 0.7 + reverb.RoomSize*0.28
+		p.reverbDamp = reverb.Damping * 0.4
+
+		if p.reverbWet > 0 {
+
+			if i == BusId_Master && masterDsp.reverb == nil {
+				masterDsp.reverb = newReverbState(outputSpec.frequency)
+			}
+
+			for c := 0; c < len(channelDsp); c++ {
+				if channelDsp[c].enabled && channelDsp[c].bus == i && channelDsp[c].reverb == nil {
+					channelDsp[c].reverb = newReverbState(outputSpec.frequency)
+				}
+			}
+		}
+	}
+
+	for c := 0; c < len(channelSources); c++ {
+
+		s := channelSources[c]
+		if s != nil && !channelDsp[c].enabled && busHasEffects(s.Bus) {
+			attachChannelDspLocked(c, s.Bus)
+		}
+	}
+}
+
+// attachChannelDsp enables our effect on the channel if its bus currently has effects enabled.
+// If effects get enabled later updateDsp will attach them then.
+//
+// The effect is only registered while the channel plays a source that needs it, because go-sdl2 keeps every registered effect function forever
+func attachChannelDsp(channel int, bus BusId) {
+
+	if !outputSpec.isS16 || bus == BusId_Master || !busHasEffects(bus) {
+		return
+	}
+
+	sdl.LockAudio()
+	attachChannelDspLocked(channel, bus)
+	sdl.UnlockAudio()
+}
+
+func attachChannelDspLocked(channel int, bus BusId) {
+
+	d := &channelDsp[channel]
+	d.bus = bus
+	d.reset()
+
+	if busDspParams[bus].reverbWet > 0 && d.reverb == nil {
+		d.reverb = newReverbState(outputSpec.frequency)
+	}
+
+	if !channelHasDsp[channel] {
+
+		err := mix.RegisterEffect(channel, func(channel int, stream []byte) {
+			d := &channelDsp[channel]
+			if d.enabled {
+				d.process(&busDspParams[d.bus], stream)
+			}
+		}, func(channel int) {})
+		if err != nil {
+			logging.ErrLog.Printf("Failed to register audio effects on channel %d. Err: %v\n", channel, err)
+			return
+		}
+
+		channelHasDsp[channel] = true
+	}
+
+	d.enabled = true
+}
+
+// detachChannelDsp forgets our effect on a channel that stopped playing, since SDL_mixer removed it then.
+// The next source that needs effects on the channel registers it again. The audio thread no longer reads the channel's state, so no lock is needed
+func detachChannelDsp(channel int) {
+	channelHasDsp[channel] = false
+	channelDsp[channel].enabled = false
+}
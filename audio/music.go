@@ -0,0 +1,369 @@
+package audio
+
+import (
+	"fmt"
+
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/assets"
+	"github.com/bloeys/nmage/logging"
+	"github.com/veandco/go-sdl2/mix"
+)
+
+// Music is a long audio track (e.g. background music) that is streamed from disk in small chunks
+// while playing instead of being fully decoded into memory like a Sound.
+//
+// Only one music track can play at a time.
+type Music struct {
+	Path string
+	mus  *mix.Music
+}
+
+func (m *Music) IsLoaded() bool {
+	return m.mus != nil
+}
+
+func (m *Music) Delete() {
+
+	if m.mus == nil {
+		return
+	}
+
+	if musicState.current == m {
+		mix.HaltMusic()
+		musicState.current = nil
+		musicState.currentIsOwned = false
+	}
+
+	m.mus.Free()
+	m.mus = nil
+}
+
+// LoadMusic opens an OGG, MP3 or WAV file for streaming. Only the headers are read here,
+// and the rest of the file is decoded in chunks as it plays
+func LoadMusic(path string) (Music, error) {
+
+	if !isInited {
+		return Music{}, fmt.Errorf("failed to load music '%s' because audio is not initialized", path)
+	}
+
+	return loadMusicFile(path)
+}
+
+// loadMusicFile is LoadMusic without the init check, which lets it run on other goroutines
+func loadMusicFile(path string) (Music, error) {
+
+	mus, err := mix.LoadMUS(assets.ResolvePath(path))
+	if err != nil {
+		return Music{}, fmt.Errorf("failed to load music '%s'. Err: %w", path, err)
+	}
+
+	return Music{
+		Path: path,
+		mus:  mus,
+	}, nil
+}
+
+type musicLoadResult struct {
+	music Music
+	err   error
+}
+
+type pendingMusic struct {
+	music      *Music
+	loops      int
+	fadeInMs   int
+	loadResult chan musicLoadResult
+
+	// isOwned is true when the audio package loaded the music and so is responsible for deleting it
+	isOwned bool
+}
+
+var musicState struct {
+	current        *Music
+	currentIsOwned bool
+
+	// Volume is in the range [0,1]
+	volume       float32
+	isUserPaused bool
+
+	// next starts playing once the current track has faded out
+	next        *pendingMusic
+	isFadingOut bool
+
+	// cancelledLoads are async loads whose track got replaced before it arrived, and are freed once they arrive
+	cancelledLoads []chan musicLoadResult
+}
+
+func init() {
+	musicState.volume = 1
+}
+
+// PlayMusic stops any playing music and plays the new track loops+1 times, or forever if loops is LoopForever.
+// A fadeInSec of zero starts at full volume immediately
+func PlayMusic(m *Music, loops int, fadeInSec float32) error {
+
+	if !isInited {
+		return fmt.Errorf("failed to play music because audio is not initialized")
+	}
+
+	if m == nil || !m.IsLoaded() {
+		return fmt.Errorf("failed to play music because it is not loaded")
+	}
+
+	cancelNextMusic()
+	musicState.isFadingOut = false
+
+	var err error
+	if fadeInSec > 0 {
+		err = m.mus.FadeIn(loops, secToMs(fadeInSec))
+	} else {
+		err = m.mus.Play(loops)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to play music '%s'. Err: %w", m.Path, err)
+	}
+
+	musicState.isUserPaused = false
+	if isBusPausedEffective(BusId_Music) {
+		mix.PauseMusic()
+	}
+
+	old := musicState.current
+	oldIsOwned := musicState.currentIsOwned
+	musicState.current = m
+	musicState.currentIsOwned = false
+
+	// Music loaded by us is only used once, so free it once it gets replaced. This is done after the new track
+	// becomes current, since deleting the current track halts the music
+	if oldIsOwned && old != m {
+		old.Delete()
+	}
+
+	return nil
+}
+
+// CrossfadeMusic fades out the current track and then fades in the new one, with each taking half of durationSec.
+// If no music is playing the new track just fades in.
+//
+// SDL_mixer can only stream one music track at a time, which is why the fades happen one after another
+// instead of overlapping.
+func CrossfadeMusic(m *Music, loops int, durationSec float32) error {
+
+	if !isInited {
+		return fmt.Errorf("failed to crossfade music because audio is not initialized")
+	}
+
+	if m == nil || !m.IsLoaded() {
+		return fmt.Errorf("failed to crossfade music because it is not loaded")
+	}
+
+	halfMs := secToMs(durationSec * 0.5)
+	if !mix.PlayingMusic() {
+		return PlayMusic(m, loops, durationSec*0.5)
+	}
+
+	cancelNextMusic()
+	musicState.next = &pendingMusic{
+		music:    m,
+		loops:    loops,
+		fadeInMs: halfMs,
+	}
+
+	startMusicFadeOut(halfMs)
+	return nil
+}
+
+// CrossfadeMusicAsync is like CrossfadeMusic, but opens the file on a background goroutine so that
+// disk access doesn't stall the frame. The current track keeps playing until the new one is ready.
+//
+// The loaded music is owned by the audio package and is deleted when another track replaces it.
+func CrossfadeMusicAsync(path string, loops int, durationSec float32) error {
+
+	if !isInited {
+		return fmt.Errorf("failed to crossfade music '%s' because audio is not initialized", path)
+	}
+
+	p := &pendingMusic{
+		loops:      loops,
+		fadeInMs:   secToMs(durationSec * 0.5),
+		loadResult: make(chan musicLoadResult, 1),
+		isOwned:    true,
+	}
+
+	cancelNextMusic()
+	musicState.next = p
+
+	// isInited is already checked and must not be read from the goroutine
+	go func() {
+		m, err := loadMusicFile(path)
+		p.loadResult <- musicLoadResult{music: m, err: err}
+	}()
+
+	return nil
+}
+
+// StopMusic fades out the current track over fadeOutSec and cancels any pending crossfade
+func StopMusic(fadeOutSec float32) {
+
+	cancelNextMusic()
+	if !mix.PlayingMusic() {
+		return
+	}
+
+	if fadeOutSec <= 0 {
+		mix.HaltMusic()
+		return
+	}
+
+	startMusicFadeOut(secToMs(fadeOutSec))
+}
+
+func PauseMusic() {
+	musicState.isUserPaused = true
+	mix.PauseMusic()
+}
+
+// ResumeMusic continues playing music, unless the music bus is paused in which case it will continue when the bus is resumed
+func ResumeMusic() {
+
+	musicState.isUserPaused = false
+	if !isBusPausedEffective(BusId_Music) {
+		mix.ResumeMusic()
+	}
+}
+
+func IsMusicPlaying() bool {
+	return mix.PlayingMusic() && !mix.PausedMusic()
+}
+
+// SetMusicVolume sets the volume of music in the range [0,1]. The final volume is also affected by the volume of the music bus
+func SetMusicVolume(volume float32) {
+	musicState.volume = volume
+	applyMusicVolume()
+}
+
+func GetMusicVolume() float32 {
+	return musicState.volume
+}
+
+func startMusicFadeOut(ms int) {
+
+	// Fading out an already fading track restarts the fade and can cause a jump in volume
+	if musicState.isFadingOut {
+		return
+	}
+
+	musicState.isFadingOut = mix.FadeOutMusic(ms)
+}
+
+// cancelNextMusic removes the pending track, and deletes it if it was loaded by us.
+// If it is still loading it is freed by freeCancelledMusicLoads once it arrives
+func cancelNextMusic() {
+
+	next := musicState.next
+	if next == nil {
+		return
+	}
+
+	musicState.next = nil
+
+	if next.loadResult != nil {
+		musicState.cancelledLoads = append(musicState.cancelledLoads, next.loadResult)
+		return
+	}
+
+	if next.isOwned {
+		next.music.Delete()
+	}
+}
+
+// freeCancelledMusicLoads frees the music of cancelled async loads that arrived. If wait is true it blocks until all of them arrive
+func freeCancelledMusicLoads(wait bool) {
+
+	kept := musicState.cancelledLoads[:0]
+	for i := 0; i < len(musicState.cancelledLoads); i++ {
+
+		loadResult := musicState.cancelledLoads[i]
+
+		var res musicLoadResult
+		if wait {
+			res = <-loadResult
+		} else {
+			select {
+			case res = <-loadResult:
+			default:
+				kept = append(kept, loadResult)
+				continue
+			}
+		}
+
+		if res.err == nil {
+			res.music.Delete()
+		}
+	}
+
+	clear(musicState.cancelledLoads[len(kept):])
+	musicState.cancelledLoads = kept
+}
+
+// updateMusic starts pending tracks once they are loaded and the previous track has finished fading out
+func updateMusic() {
+
+	freeCancelledMusicLoads(false)
+
+	next := musicState.next
+	if next == nil {
+		return
+	}
+
+	if next.music == nil {
+
+		select {
+		case res := <-next.loadResult:
+
+			if res.err != nil {
+				logging.ErrLog.Printf("Failed to crossfade music. Err: %v\n", res.err)
+				musicState.next = nil
+				return
+			}
+
+			next.music = &res.music
+			next.loadResult = nil
+			startMusicFadeOut(next.fadeInMs)
+
+		default:
+			return
+		}
+	}
+
+	if mix.PlayingMusic() {
+		return
+	}
+
+	musicState.isFadingOut = false
+
+	// Removed first so PlayMusic doesn't cancel and delete the track it is playing
+	musicState.next = nil
+	err := PlayMusic(next.music, next.loops, float32(next.fadeInMs)/1000)
+	if err != nil {
+
+		if next.isOwned {
+			next.music.Delete()
+		}
+
+		logging.ErrLog.Printf("Failed to crossfade music. Err: %v\n", err)
+		return
+	}
+
+	musicState.currentIsOwned = next.isOwned
+}
+
+func applyMusicVolume() {
+	volume := gglm.Clamp(musicState.volume*busVolumeEffective(BusId_Music), 0, 1)
+	mix.VolumeMusic(int(volume * mix.MAX_VOLUME))
+}
+
+func secToMs(sec float32) int {
+	return int(sec * 1000)
+}
@@ -0,0 +1,98 @@
+package audio
+
+import (
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/registry"
+)
+
+const (
+	MaxReverbZones = 64
+)
+
+// ReverbZone is a sphere that applies reverb on the sfx bus while the listener is inside it (e.g. a cave or a hall).
+//
+// The reverb fades in over FadeDistance when the listener approaches from outside the radius,
+// and when zones overlap the one with the strongest effect is used.
+type ReverbZone struct {
+	Pos          gglm.Vec3
+	Radius       float32
+	FadeDistance float32
+	Settings     ReverbSettings
+}
+
+// Weight returns how strongly the zone affects a listener at the given position in the range [0,1]
+func (z *ReverbZone) Weight(listenerPos *gglm.Vec3) float32 {
+
+	dist := gglm.DistVec3(&z.Pos, listenerPos)
+	if dist <= z.Radius {
+		return 1
+	}
+
+	if z.FadeDistance <= 0 || dist >= z.Radius+z.FadeDistance {
+		return 0
+	}
+
+	return 1 - (dist-z.Radius)/z.FadeDistance
+}
+
+var (
+	reverbZones = registry.NewRegistry[ReverbZone](MaxReverbZones)
+
+	// zoneReverb is the reverb caused by the zones the listener is in, and has zero wetness when not in any zone
+	zoneReverb ReverbSettings
+)
+
+func AddReverbZone(z ReverbZone) registry.Handle {
+	newZone, handle := reverbZones.New()
+	*newZone = z
+	return handle
+}
+
+// GetReverbZone returns nil if the handle is not valid (e.g. the zone was removed)
+func GetReverbZone(h registry.Handle) *ReverbZone {
+	return reverbZones.Get(h)
+}
+
+func RemoveReverbZone(h registry.Handle) {
+	reverbZones.Free(h)
+}
+
+// effectiveBusReverb returns the reverb of the bus after considering reverb zones.
+// Zones only affect the sfx bus, and while in a zone the zone overrides the bus reverb
+func effectiveBusReverb(id BusId) ReverbSettings {
+
+	if id == BusId_Sfx && zoneReverb.Wet > 0 {
+		return zoneReverb
+	}
+
+	return buses[id].Reverb
+}
+
+func updateReverbZones() {
+
+	var bestWeight, bestStrength float32
+	var bestZone *ReverbZone
+
+	it := reverbZones.NewIterator()
+	for z, _ := it.Next(); !it.IsDone(); z, _ = it.Next() {
+
+		w := z.Weight(&listener.Pos)
+		strength := w * z.Settings.Wet
+		if strength > bestStrength {
+			bestWeight = w
+			bestStrength = strength
+			bestZone = z
+		}
+	}
+
+	newZoneReverb := ReverbSettings{}
+	if bestZone != nil {
+		newZoneReverb = bestZone.Settings
+		newZoneReverb.Wet *= bestWeight
+	}
+
+	if newZoneReverb != zoneReverb {
+		zoneReverb = newZoneReverb
+		areBusEffectsDirty = true
+	}
+}
@@ -0,0 +1,59 @@
+package audio
+
+import (
+	"fmt"
+
+	"github.com/bloeys/nmage/assets"
+	"github.com/veandco/go-sdl2/mix"
+)
+
+// Sound is audio data fully decoded into memory, which makes it cheap to play many times.
+// Good for short sound effects
+type Sound struct {
+	Path  string
+	chunk *mix.Chunk
+}
+
+func (s *Sound) IsLoaded() bool {
+	return s.chunk != nil
+}
+
+func (s *Sound) Delete() {
+
+	if s.chunk == nil {
+		return
+	}
+
+	// Stop any channels still playing this sound before freeing the data they read from
+	for i := 0; i < len(channelSources); i++ {
+
+		src := channelSources[i]
+		if src == nil || src.Sound != s {
+			continue
+		}
+
+		mix.HaltChannel(i)
+		releaseChannel(i)
+	}
+
+	s.chunk.Free()
+	s.chunk = nil
+}
+
+// LoadSound loads a WAV or OGG file. audio.Init must be called first
+func LoadSound(path string) (Sound, error) {
+
+	if !isInited {
+		return Sound{}, fmt.Errorf("failed to load sound '%s' because audio is not initialized", path)
+	}
+
+	chunk, err := mix.LoadWAV(assets.ResolvePath(path))
+	if err != nil {
+		return Sound{}, fmt.Errorf("failed to load sound '%s'. Err: %w", path, err)
+	}
+
+	return Sound{
+		Path:  path,
+		chunk: chunk,
+	}, nil
+}
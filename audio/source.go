@@ -0,0 +1,186 @@
+package audio
+
+import (
+	"fmt"
+
+	"github.com/bloeys/gglm/gglm"
+	"github.com/veandco/go-sdl2/mix"
+)
+
+const (
+	// LoopForever can be passed as the loop count to keep playing until stopped
+	LoopForever = -1
+)
+
+// Source plays a sound, either in 2D (same volume on both ears) or in 3D where
+// volume and panning depend on the position of the source relative to the listener.
+//
+// A source plays on at most one channel at a time, so playing it again while it's already playing restarts it.
+type Source struct {
+	Sound *Sound
+	Bus   BusId
+
+	// Volume is in the range [0,1]. The final volume is also affected by the volume of the bus
+	Volume float32
+
+	Is3D bool
+	Pos  gglm.Vec3
+
+	// MinDistance is the distance under which the source is heard at full volume
+	MinDistance float32
+
+	// MaxDistance is the distance at which the source stops being heard
+	MaxDistance float32
+
+	// Rolloff controls how fast volume drops between min and max distance. Higher values drop faster
+	Rolloff float32
+
+	channel      int
+	isUserPaused bool
+}
+
+// Play plays the source's sound loops+1 times, or forever if loops is LoopForever
+func (s *Source) Play(loops int) error {
+
+	if !isInited {
+		return fmt.Errorf("failed to play audio source because audio is not initialized")
+	}
+
+	if s.Sound == nil || !s.Sound.IsLoaded() {
+		return fmt.Errorf("failed to play audio source because its sound is not loaded")
+	}
+
+	s.Stop()
+	s.isUserPaused = false
+
+	// Start silent so the first frame doesn't play with the wrong volume/panning
+	channel, err := s.Sound.chunk.Play(-1, loops)
+	if err != nil {
+		return fmt.Errorf("failed to play sound '%s'. Err: %w", s.Sound.Path, err)
+	}
+	mix.Volume(channel, 0)
+
+	claimChannel(channel, s)
+
+	listenerRight := listenerRightDir()
+	s.applyVolumeAndPan(&listenerRight)
+	return nil
+}
+
+func (s *Source) Stop() {
+
+	if !s.ownsChannel() {
+		return
+	}
+
+	mix.HaltChannel(s.channel)
+	releaseChannel(s.channel)
+}
+
+func (s *Source) Pause() {
+
+	s.isUserPaused = true
+	if s.ownsChannel() {
+		mix.Pause(s.channel)
+	}
+}
+
+// Resume continues playing the source, unless its bus is paused in which case it will continue when the bus is resumed
+func (s *Source) Resume() {
+
+	s.isUserPaused = false
+	if s.ownsChannel() && !isBusPausedEffective(s.Bus) {
+		mix.Resume(s.channel)
+	}
+}
+
+func (s *Source) IsPlaying() bool {
+	return s.ownsChannel() && mix.Playing(s.channel) != 0
+}
+
+// ownsChannel is used instead of checking channel against -1 so that zero value sources (channel=0) are handled correctly
+func (s *Source) ownsChannel() bool {
+	return s.channel >= 0 && s.channel < MaxChannels && channelSources[s.channel] == s
+}
+
+// Attenuation returns the volume multiplier in the range [0,1] caused by the distance to the listener
+func (s *Source) Attenuation(listenerPos *gglm.Vec3) float32 {
+
+	if !s.Is3D {
+		return 1
+	}
+
+	dist := gglm.DistVec3(&s.Pos, listenerPos)
+	if dist <= s.MinDistance {
+		return 1
+	}
+
+	if dist >= s.MaxDistance {
+		return 0
+	}
+
+	// Inverse distance rolloff that is faded out linearly so it reaches exactly zero at max distance
+	inverse := s.MinDistance / (s.MinDistance + s.Rolloff*(dist-s.MinDistance))
+	fade := 1 - (dist-s.MinDistance)/(s.MaxDistance-s.MinDistance)
+	return inverse * fade
+}
+
+func (s *Source) applyVolumeAndPan(listenerRight *gglm.Vec3) {
+
+	volume := gglm.Clamp(s.Volume*busVolumeEffective(s.Bus)*s.Attenuation(&listener.Pos), 0, 1)
+	mix.Volume(s.channel, int(volume*mix.MAX_VOLUME))
+
+	if !s.Is3D {
+		// Full volume on both sides unregisters the panning effect
+		mix.SetPanning(s.channel, 255, 255)
+		return
+	}
+
+	toSource := gglm.SubVec3(&s.Pos, &listener.Pos)
+	if toSource.SqrMag() < 0.0001 {
+		mix.SetPanning(s.channel, 255, 255)
+		return
+	}
+	toSource.Normalize()
+
+	// Constant power panning, normalized so that the louder side is always at full volume
+	pan := gglm.Clamp(gglm.DotVec3(&toSource, listenerRight), -1, 1)
+	angle := (pan + 1) * 0.25 * gglm.Pi
+	left := gglm.Cos32(angle)
+	right := gglm.Sin32(angle)
+
+	maxGain := max(left, right)
+	mix.SetPanning(s.channel, uint8(left/maxGain*255), uint8(right/maxGain*255))
+}
+
+// NewSource creates a 2D source on the sfx bus. Set Is3D and Pos to make it a 3D source
+func NewSource(sound *Sound, volume float32) Source {
+	return Source{
+		Sound:       sound,
+		Bus:         BusId_Sfx,
+		Volume:      volume,
+		MinDistance: 1,
+		MaxDistance: 50,
+		Rolloff:     1,
+		channel:     -1,
+	}
+}
+
+func NewSource3D(sound *Sound, volume float32, pos *gglm.Vec3) Source {
+	s := NewSource(sound, volume)
+	s.Is3D = true
+	s.Pos = *pos
+	return s
+}
+
+// PlayOneShot plays a sound once in 2D. Useful for UI and other non-positional sounds
+func PlayOneShot(sound *Sound, volume float32) error {
+	s := NewSource(sound, volume)
+	return s.Play(0)
+}
+
+// PlayOneShot3D plays a sound once at a fixed position in the world
+func PlayOneShot3D(sound *Sound, volume float32, pos *gglm.Vec3) error {
+	s := NewSource3D(sound, volume, pos)
+	return s.Play(0)
+}
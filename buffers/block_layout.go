@@ -0,0 +1,157 @@
+package buffers
+
+import (
+	"github.com/bloeys/nmage/assert"
+)
+
+// BlockLayout is the memory layout rules used to place the fields of a GLSL interface block in a buffer.
+//
+// Full rules in section 7.6.2.2 'Standard Uniform Block Layout' of the OpenGL 4.6 spec
+type BlockLayout uint8
+
+const (
+	// BlockLayout_Std140 is the only layout allowed for uniform blocks.
+	// Arrays and structs are always padded to 16 bytes, so an array of floats uses 16 bytes per float
+	BlockLayout_Std140 BlockLayout = iota
+
+	// BlockLayout_Std430 is only allowed for shader storage blocks (SSBOs).
+	// It is like std140 but arrays and structs are not padded to 16 bytes, which can save a lot of memory
+	BlockLayout_Std430
+)
+
+func (l BlockLayout) String() string {
+	switch l {
+	case BlockLayout_Std140:
+		return "std140"
+	case BlockLayout_Std430:
+		return "std430"
+	default:
+		return "Unknown"
+	}
+}
+
+// BaseAlignment returns the alignment of a single (non-array) field of the type. Structs are handled by structAlignment
+func (l BlockLayout) BaseAlignment(dt ElementType) uint16 {
+
+	switch dt {
+
+	case DataTypeUint32:
+		fallthrough
+	case DataTypeFloat32:
+		fallthrough
+	case DataTypeInt32:
+		return 4
+
+	case DataTypeVec2:
+		return 8
+
+	case DataTypeVec3:
+		fallthrough
+	case DataTypeVec4:
+		return 16
+
+	// Matrices are aligned like an array of their column vectors
+	case DataTypeMat2:
+		fallthrough
+	case DataTypeMat3:
+		fallthrough
+	case DataTypeMat4:
+		return l.MatrixColumnStride(dt)
+
+	case DataTypeStruct:
+		return 16
+
+	default:
+		assert.T(false, "Unknown data type passed. DataType '%d'", dt)
+		return 0
+	}
+}
+
+// MatrixColumnStride returns the distance in bytes between the columns of a matrix.
+// Columns are stored like an array of vectors, so in std140 every column takes 16 bytes,
+// while in std430 mat2 columns are packed at 8 bytes
+func (l BlockLayout) MatrixColumnStride(dt ElementType) uint16 {
+
+	if l == BlockLayout_Std430 && dt == DataTypeMat2 {
+		return 8
+	}
+
+	return 16
+}
+
+// SizeBytes returns the bytes a single (non-array, non-struct) field of the type takes, including the padding between matrix columns
+func (l BlockLayout) SizeBytes(dt ElementType) uint16 {
+
+	switch dt {
+
+	case DataTypeMat2:
+		return 2 * l.MatrixColumnStride(dt)
+	case DataTypeMat3:
+		return 3 * l.MatrixColumnStride(dt)
+	case DataTypeMat4:
+		return 4 * l.MatrixColumnStride(dt)
+
+	default:
+		return uint16(dt.Size())
+	}
+}
+
+// ArrayStride returns the distance in bytes between elements of an array of the (non-struct) type
+func (l BlockLayout) ArrayStride(dt ElementType) uint16 {
+
+	alignment := l.BaseAlignment(dt)
+	if l == BlockLayout_Std140 {
+		alignment = 16
+	}
+
+	return roundUpToMultiple(l.SizeBytes(dt), alignment)
+}
+
+// arrayAlignment returns the alignment of an array of the (non-struct) type.
+// In std140 arrays are always aligned to 16 bytes
+func (l BlockLayout) arrayAlignment(dt ElementType) uint16 {
+
+	if l == BlockLayout_Std140 {
+		return 16
+	}
+
+	return l.BaseAlignment(dt)
+}
+
+// structAlignment returns the alignment of a struct with the passed fields.
+// In std140 structs are always aligned to 16 bytes, while in std430 they are aligned to their largest field alignment
+func (l BlockLayout) structAlignment(fields []UniformBufferFieldInput) uint16 {
+
+	if l == BlockLayout_Std140 {
+		return 16
+	}
+
+	var alignment uint16 = 4
+	for i := 0; i < len(fields); i++ {
+
+		f := &fields[i]
+
+		var fieldAlignment uint16
+		if f.Type == DataTypeStruct {
+			fieldAlignment = l.structAlignment(f.Subfields)
+		} else if f.Count > 1 {
+			fieldAlignment = l.arrayAlignment(f.Type)
+		} else {
+			fieldAlignment = l.BaseAlignment(f.Type)
+		}
+
+		alignment = max(alignment, fieldAlignment)
+	}
+
+	return alignment
+}
+
+func roundUpToMultiple[T uint16 | uint32](val, multiple T) T {
+
+	alignmentError := val % multiple
+	if alignmentError != 0 {
+		val += multiple - alignmentError
+	}
+
+	return val
+}
@@ -244,6 +244,43 @@ func (dt ElementType) GlStd140AlignmentBoundary() uint16 {
 	}
 }
 
+// GlUniformType returns the type OpenGL reports for a uniform of this type (e.g. GL_FLOAT_VEC3 for Vec3),
+// which differs from GLType that returns the component type
+func (dt ElementType) GlUniformType() uint32 {
+
+	switch dt {
+
+	case DataTypeUint32:
+		return gl.UNSIGNED_INT
+	case DataTypeInt32:
+		return gl.INT
+	case DataTypeFloat32:
+		return gl.FLOAT
+
+	case DataTypeVec2:
+		return gl.FLOAT_VEC2
+	case DataTypeVec3:
+		return gl.FLOAT_VEC3
+	case DataTypeVec4:
+		return gl.FLOAT_VEC4
+
+	case DataTypeMat2:
+		return gl.FLOAT_MAT2
+	case DataTypeMat3:
+		return gl.FLOAT_MAT3
+	case DataTypeMat4:
+		return gl.FLOAT_MAT4
+
+	case DataTypeStruct:
+		logging.ErrLog.Fatalf("ElementType.GlUniformType of DataTypeStruct is not supported")
+		return 0
+
+	default:
+		assert.T(false, "Unknown data type passed. DataType '%d'", dt)
+		return 0
+	}
+}
+
 func (dt ElementType) String() string {
 
 	switch dt {
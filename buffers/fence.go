@@ -0,0 +1,102 @@
+package buffers
+
+import (
+	"time"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+type FenceWaitResult uint8
+
+const (
+	// FenceWaitResult_Signaled means the GPU finished all commands issued before the fence
+	FenceWaitResult_Signaled FenceWaitResult = iota
+	// FenceWaitResult_Timeout means the timeout passed before the GPU reached the fence
+	FenceWaitResult_Timeout
+	// FenceWaitResult_Failed means the wait failed (e.g. the fence is invalid), and a GL error is generated
+	FenceWaitResult_Failed
+)
+
+func (r FenceWaitResult) String() string {
+	switch r {
+	case FenceWaitResult_Signaled:
+		return "Signaled"
+	case FenceWaitResult_Timeout:
+		return "Timeout"
+	case FenceWaitResult_Failed:
+		return "Failed"
+	default:
+		return "Unknown"
+	}
+}
+
+// Fence is a GPU sync object that is signaled once the GPU finishes all commands issued before it was created.
+//
+// Place a fence after the commands that read a region of a buffer, then wait on it before writing to that region again.
+// This is how streaming paths that reuse memory (ring buffers, mapped buffers, PBO uploads) know the GPU is done with it.
+// The zero value is an invalid fence, and all its functions act as if it is already signaled
+type Fence struct {
+	sync uintptr
+}
+
+func (f *Fence) IsValid() bool {
+	return f.sync != 0
+}
+
+// IsSignaled returns true if the GPU has reached the fence, without waiting
+func (f *Fence) IsSignaled() bool {
+
+	if f.sync == 0 {
+		return true
+	}
+
+	var status int32
+	gl.GetSynciv(f.sync, gl.SYNC_STATUS, 1, nil, &status)
+	return status == gl.SIGNALED
+}
+
+// ClientWait blocks the CPU until the GPU reaches the fence or the timeout passes.
+// Pending commands are flushed so the fence is guaranteed to be reached eventually
+func (f *Fence) ClientWait(timeout time.Duration) FenceWaitResult {
+
+	if f.sync == 0 {
+		return FenceWaitResult_Signaled
+	}
+
+	switch gl.ClientWaitSync(f.sync, gl.SYNC_FLUSH_COMMANDS_BIT, uint64(max(timeout, 0))) {
+	case gl.ALREADY_SIGNALED, gl.CONDITION_SATISFIED:
+		return FenceWaitResult_Signaled
+	case gl.TIMEOUT_EXPIRED:
+		return FenceWaitResult_Timeout
+	default:
+		return FenceWaitResult_Failed
+	}
+}
+
+// GpuWait makes the GPU wait for the fence before running commands issued after this call, without blocking the CPU.
+// Useful when the fence was created on another context that shares objects with this one
+func (f *Fence) GpuWait() {
+
+	if f.sync == 0 {
+		return
+	}
+
+	gl.WaitSync(f.sync, 0, gl.TIMEOUT_IGNORED)
+}
+
+func (f *Fence) Delete() {
+
+	if f.sync == 0 {
+		return
+	}
+
+	gl.DeleteSync(f.sync)
+	f.sync = 0
+}
+
+// NewFence places a fence after all commands issued so far
+func NewFence() Fence {
+	return Fence{
+		sync: gl.FenceSync(gl.SYNC_GPU_COMMANDS_COMPLETE, 0),
+	}
+}
@@ -1,7 +1,11 @@
 package buffers
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/bloeys/nmage/assert"
+	"github.com/bloeys/nmage/leakcheck"
 	"github.com/bloeys/nmage/logging"
 	"github.com/go-gl/gl/v4.1-core/gl"
 )
@@ -137,6 +141,9 @@ type FramebufferAttachment struct {
 	Id     uint32
 	Type   FramebufferAttachmentType
 	Format FramebufferAttachmentDataFormat
+
+	// Name is set by Framebuffer.Attach, and is empty for attachments created otherwise
+	Name string
 }
 
 type Framebuffer struct {
@@ -199,29 +206,135 @@ func (fbo *Framebuffer) HasDepthAttachment() bool {
 	return false
 }
 
+// ColorTex returns the id of the color attachment at index (i.e. the one drawn to with COLOR_ATTACHMENT0+index),
+// or zero if there is none
+func (fbo *Framebuffer) ColorTex(index uint32) uint32 {
+
+	colorIndex := uint32(0)
+	for i := 0; i < len(fbo.Attachments); i++ {
+
+		a := &fbo.Attachments[i]
+		if !a.Format.IsColorFormat() {
+			continue
+		}
+
+		if colorIndex == index {
+			return a.Id
+		}
+
+		colorIndex++
+	}
+
+	assert.T(false, "ColorTex called with index %d but the framebuffer has %d color attachments", index, fbo.ColorAttachmentsCount)
+	return 0
+}
+
+// DepthTex returns the id of the depth (or depth-stencil) attachment, or zero if there is none
+func (fbo *Framebuffer) DepthTex() uint32 {
+
+	if a := fbo.depthAttachment(); a != nil {
+		return a.Id
+	}
+
+	return 0
+}
+
+func (fbo *Framebuffer) depthAttachment() *FramebufferAttachment {
+
+	for i := 0; i < len(fbo.Attachments); i++ {
+
+		a := &fbo.Attachments[i]
+		if a.Format.IsDepthFormat() {
+			return a
+		}
+	}
+
+	return nil
+}
+
+// Tex returns the id of the attachment named with Attach, or zero if there is none
+func (fbo *Framebuffer) Tex(name string) uint32 {
+
+	if a := fbo.Attachment(name); a != nil {
+		return a.Id
+	}
+
+	return 0
+}
+
+// Attachment returns the attachment named with Attach, or nil if there is none
+func (fbo *Framebuffer) Attachment(name string) *FramebufferAttachment {
+
+	for i := 0; i < len(fbo.Attachments); i++ {
+		if fbo.Attachments[i].Name == name {
+			return &fbo.Attachments[i]
+		}
+	}
+
+	return nil
+}
+
+// Attach creates a named attachment whose id can be got with Tex(name), which unlike indexing Attachments
+// doesn't break when attachments are added in a different order.
+//
+// Color formats create a color attachment (see NewColorAttachment), FramebufferAttachmentDataFormat_Depth24Stencil8 a depth-stencil
+// attachment (see NewDepthStencilAttachment), and other depth formats a depth attachment (see NewDepthAttachment).
+// Array attachments need a layer count, so they are created with their own functions and are found with DepthTex
+func (fbo *Framebuffer) Attach(name string, attachType FramebufferAttachmentType, attachFormat FramebufferAttachmentDataFormat) error {
+
+	if name == "" {
+		return errors.New("failed creating named framebuffer attachment because the name is empty")
+	}
+
+	if fbo.Attachment(name) != nil {
+		return fmt.Errorf("failed creating framebuffer attachment '%s' because an attachment with that name already exists", name)
+	}
+
+	var err error
+	switch {
+	case attachFormat.IsColorFormat():
+		err = fbo.NewColorAttachment(attachType, attachFormat)
+	case attachFormat == FramebufferAttachmentDataFormat_Depth24Stencil8:
+		err = fbo.NewDepthStencilAttachment(attachType, attachFormat)
+	default:
+		err = fbo.NewDepthAttachment(attachType, attachFormat)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed creating framebuffer attachment '%s'. Err: %w", name, err)
+	}
+
+	fbo.Attachments[len(fbo.Attachments)-1].Name = name
+	return nil
+}
+
 func (fbo *Framebuffer) NewColorAttachment(
 	attachType FramebufferAttachmentType,
 	attachFormat FramebufferAttachmentDataFormat,
-) {
+) error {
 
 	if fbo.ColorAttachmentsCount == 8 {
-		logging.ErrLog.Fatalf("failed creating color attachment for framebuffer due it already having %d attached\n", fbo.ColorAttachmentsCount)
+		return fmt.Errorf("failed creating color attachment for framebuffer due it already having %d attached", fbo.ColorAttachmentsCount)
 	}
 
 	if !attachType.IsValid() {
-		logging.ErrLog.Fatalf("failed creating color attachment for framebuffer due to unknown attachment type. Type=%d\n", attachType)
+		return fmt.Errorf("failed creating color attachment for framebuffer due to unknown attachment type. Type=%d", attachType)
 	}
 
-	if attachType == FramebufferAttachmentType_Cubemap || attachType == FramebufferAttachmentType_Cubemap_Array {
-		logging.ErrLog.Fatalf("failed creating color attachment because cubemaps can not be color attachments (at least in this implementation. You might be able to do it manually)\n")
+	if attachType == FramebufferAttachmentType_Cubemap_Array {
+		return errors.New("failed creating color attachment because cubemap arrays can not be color attachments (at least in this implementation. You might be able to do it manually)")
+	}
+
+	if attachType == FramebufferAttachmentType_Cubemap && fbo.Width != fbo.Height {
+		return fmt.Errorf("failed creating cubemap color attachment because cubemap faces must be square, but the framebuffer is %dx%d", fbo.Width, fbo.Height)
 	}
 
 	if attachType == FramebufferAttachmentType_Texture_Array {
-		logging.ErrLog.Fatalf("failed creating color attachment because texture arrays can not be color attachments (implementation can be updated to support it or you can do it manually)\n")
+		return errors.New("failed creating color attachment because texture arrays can not be color attachments (implementation can be updated to support it or you can do it manually)")
 	}
 
 	if !attachFormat.IsColorFormat() {
-		logging.ErrLog.Fatalf("failed creating color attachment for framebuffer due to attachment data format not being a valid color type. Data format=%d\n", attachFormat)
+		return fmt.Errorf("failed creating color attachment for framebuffer due to attachment data format not being a valid color type. Data format=%d", attachFormat)
 	}
 
 	a := FramebufferAttachment{
@@ -235,8 +348,10 @@ func (fbo *Framebuffer) NewColorAttachment(
 
 		// Create texture
 		gl.GenTextures(1, &a.Id)
+		leakcheck.Track(leakcheck.ResourceType_Texture, a.Id)
 		if a.Id == 0 {
-			logging.ErrLog.Fatalf("failed to generate texture for framebuffer. GlError=%d\n", gl.GetError())
+			fbo.UnBind()
+			return fmt.Errorf("failed to generate texture for framebuffer. GlError=%d", gl.GetError())
 		}
 
 		gl.BindTexture(gl.TEXTURE_2D, a.Id)
@@ -252,8 +367,14 @@ func (fbo *Framebuffer) NewColorAttachment(
 			nil,
 		)
 
-		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
-		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+		// Integer textures can't be filtered, and aren't complete with linear filtering
+		filter := int32(gl.LINEAR)
+		if attachFormat == FramebufferAttachmentDataFormat_R32Int {
+			filter = gl.NEAREST
+		}
+
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, filter)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, filter)
 		gl.BindTexture(gl.TEXTURE_2D, 0)
 
 		// Attach to fbo
@@ -263,8 +384,10 @@ func (fbo *Framebuffer) NewColorAttachment(
 
 		// Create rbo
 		gl.GenRenderbuffers(1, &a.Id)
+		leakcheck.Track(leakcheck.ResourceType_Renderbuffer, a.Id)
 		if a.Id == 0 {
-			logging.ErrLog.Fatalf("failed to generate render buffer for framebuffer. GlError=%d\n", gl.GetError())
+			fbo.UnBind()
+			return fmt.Errorf("failed to generate render buffer for framebuffer. GlError=%d", gl.GetError())
 		}
 
 		gl.BindRenderbuffer(gl.RENDERBUFFER, a.Id)
@@ -273,12 +396,60 @@ func (fbo *Framebuffer) NewColorAttachment(
 
 		// Attach to fbo
 		gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0+fbo.ColorAttachmentsCount, gl.RENDERBUFFER, a.Id)
+
+	} else if attachType == FramebufferAttachmentType_Cubemap {
+
+		// Create cubemap
+		gl.GenTextures(1, &a.Id)
+		leakcheck.Track(leakcheck.ResourceType_Texture, a.Id)
+		if a.Id == 0 {
+			fbo.UnBind()
+			return fmt.Errorf("failed to generate texture for framebuffer. GlError=%d", gl.GetError())
+		}
+
+		gl.BindTexture(gl.TEXTURE_CUBE_MAP, a.Id)
+		for i := 0; i < 6; i++ {
+			gl.TexImage2D(
+				uint32(gl.TEXTURE_CUBE_MAP_POSITIVE_X+i),
+				0,
+				attachFormat.GlInternalFormat(),
+				int32(fbo.Width),
+				int32(fbo.Height),
+				0,
+				attachFormat.GlFormat(),
+				attachFormat.GlComponentType(),
+				nil,
+			)
+		}
+
+		filter := int32(gl.LINEAR)
+		if attachFormat == FramebufferAttachmentDataFormat_R32Int {
+			filter = gl.NEAREST
+		}
+
+		gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_MIN_FILTER, filter)
+		gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_MAG_FILTER, filter)
+		gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+		gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+		gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_WRAP_R, gl.CLAMP_TO_EDGE)
+		gl.BindTexture(gl.TEXTURE_CUBE_MAP, 0)
+
+		// Attach the first face to fbo. Faces are drawn one at a time (see SetCubemapFace)
+		gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0+fbo.ColorAttachmentsCount, gl.TEXTURE_CUBE_MAP_POSITIVE_X, a.Id, 0)
 	}
 
+	// Only the first color attachment is drawn to by default, so fbos with multiple render targets (e.g. G-buffers) must list all of them
+	var drawBuffers [8]uint32
+	for i := uint32(0); i <= fbo.ColorAttachmentsCount; i++ {
+		drawBuffers[i] = gl.COLOR_ATTACHMENT0 + i
+	}
+	gl.DrawBuffers(int32(fbo.ColorAttachmentsCount+1), &drawBuffers[0])
+
 	fbo.UnBind()
 	fbo.ColorAttachmentsCount++
 	fbo.ClearFlags |= gl.COLOR_BUFFER_BIT
 	fbo.Attachments = append(fbo.Attachments, a)
+	return nil
 }
 
 // SetNoColorBuffer sets the read and draw buffers of this fbo to 'NONE',
@@ -288,41 +459,42 @@ func (fbo *Framebuffer) NewColorAttachment(
 // doing this we get marked as complete even without one.
 //
 // Usually used when you only care about some other buffer, like a depth buffer.
-func (fbo *Framebuffer) SetNoColorBuffer() {
+func (fbo *Framebuffer) SetNoColorBuffer() error {
 
 	if fbo.HasColorAttachment() {
-		logging.ErrLog.Fatalf("failed SetNoColorBuffer because framebuffer already has a color attachment\n")
+		return errors.New("failed SetNoColorBuffer because framebuffer already has a color attachment")
 	}
 
 	fbo.Bind()
 	gl.DrawBuffer(gl.NONE)
 	gl.ReadBuffer(gl.NONE)
 	fbo.UnBind()
+	return nil
 }
 
 func (fbo *Framebuffer) NewDepthAttachment(
 	attachType FramebufferAttachmentType,
 	attachFormat FramebufferAttachmentDataFormat,
-) {
+) error {
 
 	if fbo.HasDepthAttachment() {
-		logging.ErrLog.Fatalf("failed creating depth attachment for framebuffer because a depth attachment already exists\n")
+		return errors.New("failed creating depth attachment for framebuffer because a depth attachment already exists")
 	}
 
 	if !attachType.IsValid() {
-		logging.ErrLog.Fatalf("failed creating depth attachment for framebuffer due to unknown attachment type. Type=%d\n", attachType)
+		return fmt.Errorf("failed creating depth attachment for framebuffer due to unknown attachment type. Type=%d", attachType)
 	}
 
 	if !attachFormat.IsDepthFormat() {
-		logging.ErrLog.Fatalf("failed creating depth attachment for framebuffer due to attachment data format not being a valid depth-stencil type. Data format=%d\n", attachFormat)
+		return fmt.Errorf("failed creating depth attachment for framebuffer due to attachment data format not being a valid depth-stencil type. Data format=%d", attachFormat)
 	}
 
 	if attachType == FramebufferAttachmentType_Cubemap_Array {
-		logging.ErrLog.Fatalf("failed creating cubemap array depth attachment because 'NewDepthCubemapArrayAttachment' must be used for that\n")
+		return errors.New("failed creating cubemap array depth attachment because 'NewDepthCubemapArrayAttachment' must be used for that")
 	}
 
 	if attachType == FramebufferAttachmentType_Texture_Array {
-		logging.ErrLog.Fatalf("failed creating texture array depth attachment because 'NewDepthTextureArrayAttachment' must be used for that\n")
+		return errors.New("failed creating texture array depth attachment because 'NewDepthTextureArrayAttachment' must be used for that")
 	}
 
 	a := FramebufferAttachment{
@@ -336,8 +508,10 @@ func (fbo *Framebuffer) NewDepthAttachment(
 
 		// Create texture
 		gl.GenTextures(1, &a.Id)
+		leakcheck.Track(leakcheck.ResourceType_Texture, a.Id)
 		if a.Id == 0 {
-			logging.ErrLog.Fatalf("failed to generate texture for framebuffer. GlError=%d\n", gl.GetError())
+			fbo.UnBind()
+			return fmt.Errorf("failed to generate texture for framebuffer. GlError=%d", gl.GetError())
 		}
 
 		gl.BindTexture(gl.TEXTURE_2D, a.Id)
@@ -373,8 +547,10 @@ func (fbo *Framebuffer) NewDepthAttachment(
 
 		// Create rbo
 		gl.GenRenderbuffers(1, &a.Id)
+		leakcheck.Track(leakcheck.ResourceType_Renderbuffer, a.Id)
 		if a.Id == 0 {
-			logging.ErrLog.Fatalf("failed to generate render buffer for framebuffer. GlError=%d\n", gl.GetError())
+			fbo.UnBind()
+			return fmt.Errorf("failed to generate render buffer for framebuffer. GlError=%d", gl.GetError())
 		}
 
 		gl.BindRenderbuffer(gl.RENDERBUFFER, a.Id)
@@ -388,8 +564,10 @@ func (fbo *Framebuffer) NewDepthAttachment(
 
 		// Create cubemap
 		gl.GenTextures(1, &a.Id)
+		leakcheck.Track(leakcheck.ResourceType_Texture, a.Id)
 		if a.Id == 0 {
-			logging.ErrLog.Fatalf("failed to generate texture for framebuffer. GlError=%d\n", gl.GetError())
+			fbo.UnBind()
+			return fmt.Errorf("failed to generate texture for framebuffer. GlError=%d", gl.GetError())
 		}
 
 		gl.BindTexture(gl.TEXTURE_CUBE_MAP, a.Id)
@@ -422,19 +600,20 @@ func (fbo *Framebuffer) NewDepthAttachment(
 	fbo.UnBind()
 	fbo.ClearFlags |= gl.DEPTH_BUFFER_BIT
 	fbo.Attachments = append(fbo.Attachments, a)
+	return nil
 }
 
 func (fbo *Framebuffer) NewDepthCubemapArrayAttachment(
 	attachFormat FramebufferAttachmentDataFormat,
 	numCubemaps int32,
-) {
+) error {
 
 	if fbo.HasDepthAttachment() {
-		logging.ErrLog.Fatalf("failed creating cubemap array depth attachment for framebuffer because a depth attachment already exists\n")
+		return errors.New("failed creating cubemap array depth attachment for framebuffer because a depth attachment already exists")
 	}
 
 	if !attachFormat.IsDepthFormat() {
-		logging.ErrLog.Fatalf("failed creating depth attachment for framebuffer due to attachment data format not being a valid depth-stencil type. Data format=%d\n", attachFormat)
+		return fmt.Errorf("failed creating depth attachment for framebuffer due to attachment data format not being a valid depth-stencil type. Data format=%d", attachFormat)
 	}
 
 	a := FramebufferAttachment{
@@ -446,8 +625,10 @@ func (fbo *Framebuffer) NewDepthCubemapArrayAttachment(
 
 	// Create cubemap array
 	gl.GenTextures(1, &a.Id)
+	leakcheck.Track(leakcheck.ResourceType_Texture, a.Id)
 	if a.Id == 0 {
-		logging.ErrLog.Fatalf("failed to generate texture for framebuffer. GlError=%d\n", gl.GetError())
+		fbo.UnBind()
+		return fmt.Errorf("failed to generate texture for framebuffer. GlError=%d", gl.GetError())
 	}
 
 	gl.BindTexture(gl.TEXTURE_CUBE_MAP_ARRAY, a.Id)
@@ -479,19 +660,20 @@ func (fbo *Framebuffer) NewDepthCubemapArrayAttachment(
 	fbo.UnBind()
 	fbo.ClearFlags |= gl.DEPTH_BUFFER_BIT
 	fbo.Attachments = append(fbo.Attachments, a)
+	return nil
 }
 
 func (fbo *Framebuffer) NewDepthTextureArrayAttachment(
 	attachFormat FramebufferAttachmentDataFormat,
 	numTextures int32,
-) {
+) error {
 
 	if fbo.HasDepthAttachment() {
-		logging.ErrLog.Fatalf("failed creating texture array depth attachment for framebuffer because a depth attachment already exists\n")
+		return errors.New("failed creating texture array depth attachment for framebuffer because a depth attachment already exists")
 	}
 
 	if !attachFormat.IsDepthFormat() {
-		logging.ErrLog.Fatalf("failed creating depth attachment for framebuffer due to attachment data format not being a valid depth-stencil type. Data format=%d\n", attachFormat)
+		return fmt.Errorf("failed creating depth attachment for framebuffer due to attachment data format not being a valid depth-stencil type. Data format=%d", attachFormat)
 	}
 
 	a := FramebufferAttachment{
@@ -503,8 +685,10 @@ func (fbo *Framebuffer) NewDepthTextureArrayAttachment(
 
 	// Create cubemap array
 	gl.GenTextures(1, &a.Id)
+	leakcheck.Track(leakcheck.ResourceType_Texture, a.Id)
 	if a.Id == 0 {
-		logging.ErrLog.Fatalf("failed to generate texture for framebuffer. GlError=%d\n", gl.GetError())
+		fbo.UnBind()
+		return fmt.Errorf("failed to generate texture for framebuffer. GlError=%d", gl.GetError())
 	}
 
 	gl.BindTexture(gl.TEXTURE_2D_ARRAY, a.Id)
@@ -541,23 +725,24 @@ func (fbo *Framebuffer) NewDepthTextureArrayAttachment(
 	fbo.UnBind()
 	fbo.ClearFlags |= gl.DEPTH_BUFFER_BIT
 	fbo.Attachments = append(fbo.Attachments, a)
+	return nil
 }
 
 func (fbo *Framebuffer) NewDepthStencilAttachment(
 	attachType FramebufferAttachmentType,
 	attachFormat FramebufferAttachmentDataFormat,
-) {
+) error {
 
 	if fbo.HasDepthAttachment() {
-		logging.ErrLog.Fatalf("failed creating depth-stencil attachment for framebuffer because a depth-stencil attachment already exists\n")
+		return errors.New("failed creating depth-stencil attachment for framebuffer because a depth-stencil attachment already exists")
 	}
 
 	if !attachType.IsValid() {
-		logging.ErrLog.Fatalf("failed creating depth-stencil attachment for framebuffer due to unknown attachment type. Type=%d\n", attachType)
+		return fmt.Errorf("failed creating depth-stencil attachment for framebuffer due to unknown attachment type. Type=%d", attachType)
 	}
 
 	if !attachFormat.IsDepthFormat() {
-		logging.ErrLog.Fatalf("failed creating depth-stencil attachment for framebuffer due to attachment data format not being a valid depth-stencil type. Data format=%d\n", attachFormat)
+		return fmt.Errorf("failed creating depth-stencil attachment for framebuffer due to attachment data format not being a valid depth-stencil type. Data format=%d", attachFormat)
 	}
 
 	a := FramebufferAttachment{
@@ -571,8 +756,10 @@ func (fbo *Framebuffer) NewDepthStencilAttachment(
 
 		// Create texture
 		gl.GenTextures(1, &a.Id)
+		leakcheck.Track(leakcheck.ResourceType_Texture, a.Id)
 		if a.Id == 0 {
-			logging.ErrLog.Fatalf("failed to generate texture for framebuffer. GlError=%d\n", gl.GetError())
+			fbo.UnBind()
+			return fmt.Errorf("failed to generate texture for framebuffer. GlError=%d", gl.GetError())
 		}
 
 		gl.BindTexture(gl.TEXTURE_2D, a.Id)
@@ -599,8 +786,10 @@ func (fbo *Framebuffer) NewDepthStencilAttachment(
 
 		// Create rbo
 		gl.GenRenderbuffers(1, &a.Id)
+		leakcheck.Track(leakcheck.ResourceType_Renderbuffer, a.Id)
 		if a.Id == 0 {
-			logging.ErrLog.Fatalf("failed to generate render buffer for framebuffer. GlError=%d\n", gl.GetError())
+			fbo.UnBind()
+			return fmt.Errorf("failed to generate render buffer for framebuffer. GlError=%d", gl.GetError())
 		}
 
 		gl.BindRenderbuffer(gl.RENDERBUFFER, a.Id)
@@ -614,6 +803,7 @@ func (fbo *Framebuffer) NewDepthStencilAttachment(
 	fbo.UnBind()
 	fbo.ClearFlags |= gl.DEPTH_BUFFER_BIT | gl.STENCIL_BUFFER_BIT
 	fbo.Attachments = append(fbo.Attachments, a)
+	return nil
 }
 
 // SetCubemapArrayLayerFace 'binds' a single face of a cubemap from the cubemap
@@ -638,17 +828,136 @@ func (fbo *Framebuffer) SetCubemapArrayLayerFace(layerFace int32) {
 	logging.ErrLog.Fatalf("SetCubemapFromArray failed because no cubemap array attachment was found on fbo. Fbo=%+v\n", *fbo)
 }
 
+// SetCubemapFace attaches one face of every cubemap attachment (color and depth) to the fbo, so rendering only draws into that face.
+// Faces are in the OpenGL face order (+x, -x, +y, -y, +z, -z). The fbo must be bound.
+//
+// Depth cubemaps are attached with all their faces when created, which a geometry shader can draw into in one pass,
+// and only have one face attached after this is called
+func (fbo *Framebuffer) SetCubemapFace(face int32) {
+
+	assert.T(face >= 0 && face < 6, "SetCubemapFace called with invalid face %d", face)
+
+	colorIndex := uint32(0)
+	for i := 0; i < len(fbo.Attachments); i++ {
+
+		a := &fbo.Attachments[i]
+		isColor := a.Format.IsColorFormat()
+		if a.Type != FramebufferAttachmentType_Cubemap {
+			if isColor {
+				colorIndex++
+			}
+			continue
+		}
+
+		attachment := uint32(gl.DEPTH_ATTACHMENT)
+		if isColor {
+			attachment = gl.COLOR_ATTACHMENT0 + colorIndex
+			colorIndex++
+		}
+
+		gl.FramebufferTexture2D(gl.FRAMEBUFFER, attachment, uint32(gl.TEXTURE_CUBE_MAP_POSITIVE_X+face), a.Id, 0)
+	}
+}
+
+// ClearCubemapArrayCubemap clears the six faces of one cubemap of the depth cubemap array attachment, leaving the other cubemaps as they are.
+// The fbo must be bound, and the whole array is attached again afterwards
+func (fbo *Framebuffer) ClearCubemapArrayCubemap(cubemapIndex int32) {
+
+	for i := 0; i < len(fbo.Attachments); i++ {
+
+		a := &fbo.Attachments[i]
+		if a.Type != FramebufferAttachmentType_Cubemap_Array {
+			continue
+		}
+
+		for face := int32(0); face < 6; face++ {
+			gl.FramebufferTextureLayer(gl.FRAMEBUFFER, gl.DEPTH_ATTACHMENT, a.Id, 0, cubemapIndex*6+face)
+			gl.Clear(gl.DEPTH_BUFFER_BIT)
+		}
+
+		gl.FramebufferTexture(gl.FRAMEBUFFER, gl.DEPTH_ATTACHMENT, a.Id, 0)
+		return
+	}
+
+	logging.ErrLog.Fatalf("ClearCubemapArrayCubemap failed because no cubemap array attachment was found on fbo. Fbo=%+v\n", *fbo)
+}
+
+// CopyDepthLayersFrom copies the depth attachment of src into the depth attachment of fbo, which must have the same size, type and format
+// (e.g. to start a shadow map from a cached one). For texture and cubemap arrays only the layers [firstLayer, firstLayer+layerCount) are
+// copied, where the layers of cubemap arrays are cubemapIndex*6+face, while other attachments ignore the layers.
+//
+// Copies are done with blits, which are affected by the scissor test. fbo is left bound
+func (fbo *Framebuffer) CopyDepthLayersFrom(src *Framebuffer, firstLayer, layerCount int32) {
+
+	srcDepth, dstDepth := src.depthAttachment(), fbo.depthAttachment()
+	assert.T(srcDepth != nil && dstDepth != nil, "CopyDepthLayersFrom needs both framebuffers to have a depth attachment")
+	assert.T(src.Width == fbo.Width && src.Height == fbo.Height && srcDepth.Type == dstDepth.Type && srcDepth.Format == dstDepth.Format,
+		"CopyDepthLayersFrom needs depth attachments of the same size, type and format. Src=%dx%d %+v, Dst=%dx%d %+v", src.Width, src.Height, *srcDepth, fbo.Width, fbo.Height, *dstDepth)
+
+	attachPoint := uint32(gl.DEPTH_ATTACHMENT)
+	blitMask := uint32(gl.DEPTH_BUFFER_BIT)
+	if dstDepth.Format == FramebufferAttachmentDataFormat_Depth24Stencil8 {
+		attachPoint = gl.DEPTH_STENCIL_ATTACHMENT
+		blitMask |= gl.STENCIL_BUFFER_BIT
+	}
+
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, src.Id)
+	gl.BindFramebuffer(gl.DRAW_FRAMEBUFFER, fbo.Id)
+
+	w, h := int32(fbo.Width), int32(fbo.Height)
+	if dstDepth.Type != FramebufferAttachmentType_Texture_Array && dstDepth.Type != FramebufferAttachmentType_Cubemap_Array {
+		gl.BlitFramebuffer(0, 0, w, h, 0, 0, w, h, blitMask, gl.NEAREST)
+		fbo.Bind()
+		return
+	}
+
+	// Blits only read and write the first layer of layered attachments, so each layer is attached on its own
+	for layer := firstLayer; layer < firstLayer+layerCount; layer++ {
+		gl.FramebufferTextureLayer(gl.READ_FRAMEBUFFER, attachPoint, srcDepth.Id, 0, layer)
+		gl.FramebufferTextureLayer(gl.DRAW_FRAMEBUFFER, attachPoint, dstDepth.Id, 0, layer)
+		gl.BlitFramebuffer(0, 0, w, h, 0, 0, w, h, blitMask, gl.NEAREST)
+	}
+
+	gl.FramebufferTexture(gl.READ_FRAMEBUFFER, attachPoint, srcDepth.Id, 0)
+	gl.FramebufferTexture(gl.DRAW_FRAMEBUFFER, attachPoint, dstDepth.Id, 0)
+	fbo.Bind()
+}
+
+// Delete deletes the framebuffer along with the textures and renderbuffers of its attachments
 func (fbo *Framebuffer) Delete() {
 
+	for i := 0; i < len(fbo.Attachments); i++ {
+
+		a := &fbo.Attachments[i]
+		if a.Id == 0 {
+			continue
+		}
+
+		if a.Type == FramebufferAttachmentType_Renderbuffer {
+			leakcheck.Untrack(leakcheck.ResourceType_Renderbuffer, a.Id)
+			gl.DeleteRenderbuffers(1, &a.Id)
+		} else {
+			leakcheck.Untrack(leakcheck.ResourceType_Texture, a.Id)
+			gl.DeleteTextures(1, &a.Id)
+		}
+
+		a.Id = 0
+	}
+
+	fbo.Attachments = fbo.Attachments[:0]
+	fbo.ColorAttachmentsCount = 0
+
 	if fbo.Id == 0 {
 		return
 	}
 
+	leakcheck.Untrack(leakcheck.ResourceType_Framebuffer, fbo.Id)
 	gl.DeleteFramebuffers(1, &fbo.Id)
 	fbo.Id = 0
 }
 
-func NewFramebuffer(width, height uint32) Framebuffer {
+// NewFramebuffer creates a framebuffer without attachments, whose attachments will all be width*height
+func NewFramebuffer(width, height uint32) (Framebuffer, error) {
 
 	// It is allowed to have attachments of differnt sizes in one FBO,
 	// but that complicates things (e.g. which size to use for gl.viewport) and I don't see much use
@@ -659,9 +968,10 @@ func NewFramebuffer(width, height uint32) Framebuffer {
 	}
 
 	gl.GenFramebuffers(1, &fbo.Id)
+	leakcheck.Track(leakcheck.ResourceType_Framebuffer, fbo.Id)
 	if fbo.Id == 0 {
-		logging.ErrLog.Fatalf("failed to generate framebuffer. GlError=%d\n", gl.GetError())
+		return Framebuffer{}, fmt.Errorf("failed to generate framebuffer. GlError=%d", gl.GetError())
 	}
 
-	return fbo
+	return fbo, nil
 }
@@ -1,14 +1,18 @@
 package buffers
 
 import (
+	"github.com/bloeys/nmage/assert"
+	"github.com/bloeys/nmage/leakcheck"
 	"github.com/bloeys/nmage/logging"
 	"github.com/go-gl/gl/v4.1-core/gl"
 )
 
 type IndexBuffer struct {
 	Id uint32
-	// IndexBufCount is the number of elements in the index buffer. Updated in IndexBuffer.SetData
+	// IndexBufCount is the number of elements in the index buffer. Updated in IndexBuffer.SetData and IndexBuffer.OrphanAndSet
 	IndexBufCount int32
+	// Size is the allocated memory in bytes on the GPU for this buffer. Updated in SetData and OrphanAndSet
+	Size int
 }
 
 func (ib *IndexBuffer) Bind() {
@@ -25,6 +29,7 @@ func (ib *IndexBuffer) SetData(values []uint32) {
 
 	sizeInBytes := len(values) * 4
 	ib.IndexBufCount = int32(len(values))
+	ib.Size = sizeInBytes
 
 	if sizeInBytes == 0 {
 		gl.BufferData(gl.ELEMENT_ARRAY_BUFFER, 0, gl.Ptr(nil), BufUsage_Static_Draw.ToGL())
@@ -33,11 +38,55 @@ func (ib *IndexBuffer) SetData(values []uint32) {
 	}
 }
 
+// SetSubData updates part of the buffer starting at offsetBytes without reallocating it or changing IndexBufCount.
+// The range must fit within the current size of the buffer
+func (ib *IndexBuffer) SetSubData(offsetBytes int, values []uint32) {
+
+	if len(values) == 0 {
+		return
+	}
+
+	sizeInBytes := len(values) * 4
+	assert.T(offsetBytes >= 0 && offsetBytes+sizeInBytes <= ib.Size, "IndexBuffer.SetSubData range is outside the buffer. Offset=%d, Size=%d, Buffer size=%d", offsetBytes, sizeInBytes, ib.Size)
+
+	ib.Bind()
+	gl.BufferSubData(gl.ELEMENT_ARRAY_BUFFER, offsetBytes, sizeInBytes, gl.Ptr(&values[0]))
+}
+
+// OrphanAndSet replaces the contents of an index buffer that is rewritten often, without waiting on the GPU.
+// See VertexBuffer.OrphanAndSet for details
+func (ib *IndexBuffer) OrphanAndSet(values []uint32) {
+
+	ib.IndexBufCount = int32(len(values))
+	ib.Size = max(ib.Size, len(values)*4)
+
+	ib.Bind()
+	gl.BufferData(gl.ELEMENT_ARRAY_BUFFER, ib.Size, gl.Ptr(nil), BufUsage_Stream_Draw.ToGL())
+
+	if len(values) > 0 {
+		gl.BufferSubData(gl.ELEMENT_ARRAY_BUFFER, 0, len(values)*4, gl.Ptr(&values[0]))
+	}
+}
+
+func (ib *IndexBuffer) Delete() {
+
+	if ib.Id == 0 {
+		return
+	}
+
+	leakcheck.Untrack(leakcheck.ResourceType_Buffer, ib.Id)
+	gl.DeleteBuffers(1, &ib.Id)
+	ib.Id = 0
+	ib.Size = 0
+	ib.IndexBufCount = 0
+}
+
 func NewIndexBuffer() IndexBuffer {
 
 	ib := IndexBuffer{}
 
 	gl.GenBuffers(1, &ib.Id)
+	leakcheck.Track(leakcheck.ResourceType_Buffer, ib.Id)
 	if ib.Id == 0 {
 		logging.ErrLog.Println("Failed to create OpenGL buffer")
 	}
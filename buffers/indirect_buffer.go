@@ -0,0 +1,108 @@
+package buffers
+
+import (
+	"errors"
+	"unsafe"
+
+	"github.com/bloeys/nmage/glutil"
+	"github.com/bloeys/nmage/leakcheck"
+	"github.com/bloeys/nmage/logging"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// DrawElementsIndirectCommand is one indexed draw in an indirect buffer, laid out the way OpenGL reads it
+type DrawElementsIndirectCommand struct {
+	Count         uint32
+	InstanceCount uint32
+	FirstIndex    uint32
+	BaseVertex    int32
+	BaseInstance  uint32
+}
+
+// DrawElementsIndirectCommandSize is the size in bytes of one command in an indirect buffer
+const DrawElementsIndirectCommandSize = uint32(unsafe.Sizeof(DrawElementsIndirectCommand{}))
+
+// IndirectBuffer holds draw commands that the GPU reads when drawing with gl.DrawElementsIndirect.
+// Since it is also bindable as a storage buffer, compute shaders can write the commands (e.g. instance counts after culling)
+// without the CPU reading anything back.
+//
+// In a shader the commands are declared as:
+//
+//	struct DrawCommand { uint count; uint instanceCount; uint firstIndex; int baseVertex; uint baseInstance; };
+//	layout(std430, binding=0) buffer Commands { DrawCommand commands[]; };
+type IndirectBuffer struct {
+	Id uint32
+	// Size is the allocated memory in bytes on the GPU for this buffer
+	Size  uint32
+	Usage BufUsage
+	// Count is the number of commands written by the last SetCommands
+	Count uint32
+}
+
+func (ib *IndirectBuffer) Bind() {
+	gl.BindBuffer(gl.DRAW_INDIRECT_BUFFER, ib.Id)
+}
+
+func (ib *IndirectBuffer) UnBind() {
+	gl.BindBuffer(gl.DRAW_INDIRECT_BUFFER, 0)
+}
+
+// SetCommands uploads the commands to the start of the buffer, growing the buffer if it is too small.
+// Growing loses the previous contents of the buffer
+func (ib *IndirectBuffer) SetCommands(cmds []DrawElementsIndirectCommand) {
+
+	ib.Bind()
+
+	size := uint32(len(cmds)) * DrawElementsIndirectCommandSize
+	if size > ib.Size {
+		ib.Size = size
+		gl.BufferData(gl.DRAW_INDIRECT_BUFFER, int(ib.Size), gl.Ptr(nil), ib.Usage.ToGL())
+	}
+
+	if len(cmds) > 0 {
+		gl.BufferSubData(gl.DRAW_INDIRECT_BUFFER, 0, int(size), gl.Ptr(&cmds[0]))
+	}
+
+	ib.Count = uint32(len(cmds))
+}
+
+// BindBase binds the whole buffer to a storage block binding point, so compute shaders can write commands into it
+func (ib *IndirectBuffer) BindBase(bindPointIndex uint32) {
+	gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, bindPointIndex, ib.Id)
+}
+
+func (ib *IndirectBuffer) Delete() {
+	leakcheck.Untrack(leakcheck.ResourceType_Buffer, ib.Id)
+	gl.DeleteBuffers(1, &ib.Id)
+	ib.Id = 0
+	ib.Size = 0
+	ib.Count = 0
+}
+
+// NewIndirectBuffer creates an indirect buffer with room for capacity commands.
+// Returns an error if indirect draws aren't supported, which needs OpenGL 4.0 or GL_ARB_draw_indirect
+func NewIndirectBuffer(capacity uint32, usage BufUsage) (IndirectBuffer, error) {
+
+	var major int32
+	gl.GetIntegerv(gl.MAJOR_VERSION, &major)
+	if major < 4 && !glutil.HasGlExtension("GL_ARB_draw_indirect") {
+		return IndirectBuffer{}, errors.New("failed to create indirect buffer because indirect draws need OpenGL 4.0 or GL_ARB_draw_indirect")
+	}
+
+	ib := IndirectBuffer{
+		Size:  capacity * DrawElementsIndirectCommandSize,
+		Usage: usage,
+	}
+
+	gl.GenBuffers(1, &ib.Id)
+	leakcheck.Track(leakcheck.ResourceType_Buffer, ib.Id)
+	if ib.Id == 0 {
+		logging.ErrLog.Panicln("Failed to create OpenGL buffer for an indirect buffer")
+	}
+
+	ib.Bind()
+	gl.BufferData(gl.DRAW_INDIRECT_BUFFER, int(ib.Size), gl.Ptr(nil), usage.ToGL())
+	ib.UnBind()
+
+	return ib, nil
+}
@@ -0,0 +1,243 @@
+package buffers
+
+import (
+	"github.com/bloeys/nmage/assert"
+	"github.com/bloeys/nmage/leakcheck"
+	"github.com/bloeys/nmage/logging"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+type QueryType uint8
+
+const (
+	QueryType_Unknown QueryType = iota
+	// QueryType_SamplesPassed counts the samples that passed the depth and stencil tests, used for occlusion culling
+	QueryType_SamplesPassed
+	// QueryType_AnySamplesPassed is 1 if any sample passed the depth and stencil tests and 0 otherwise.
+	// Can be faster than QueryType_SamplesPassed when the count isn't needed
+	QueryType_AnySamplesPassed
+	// QueryType_PrimitivesGenerated counts the primitives sent to the rasterizer (or captured by transform feedback)
+	QueryType_PrimitivesGenerated
+	// QueryType_TimeElapsed is the GPU time in nanoseconds taken by the commands between Begin and End
+	QueryType_TimeElapsed
+)
+
+func (t QueryType) ToGL() uint32 {
+
+	switch t {
+	case QueryType_SamplesPassed:
+		return gl.SAMPLES_PASSED
+	case QueryType_AnySamplesPassed:
+		return gl.ANY_SAMPLES_PASSED
+	case QueryType_PrimitivesGenerated:
+		return gl.PRIMITIVES_GENERATED
+	case QueryType_TimeElapsed:
+		return gl.TIME_ELAPSED
+
+	default:
+		assert.T(false, "Unknown query type passed. QueryType '%d'", t)
+		return 0
+	}
+}
+
+func (t QueryType) String() string {
+	switch t {
+	case QueryType_SamplesPassed:
+		return "SamplesPassed"
+	case QueryType_AnySamplesPassed:
+		return "AnySamplesPassed"
+	case QueryType_PrimitivesGenerated:
+		return "PrimitivesGenerated"
+	case QueryType_TimeElapsed:
+		return "TimeElapsed"
+	default:
+		return "Unknown"
+	}
+}
+
+// Query measures something about the GPU commands issued between Begin and End.
+//
+// Results are only ready a few frames later since the GPU runs behind the CPU, and reading
+// them early stalls until the GPU catches up. Use TryResult or a QueryPool to avoid that.
+// Only one query of each type can be active at a time
+type Query struct {
+	Id   uint32
+	Type QueryType
+}
+
+func (q *Query) Begin() {
+	gl.BeginQuery(q.Type.ToGL(), q.Id)
+}
+
+func (q *Query) End() {
+	gl.EndQuery(q.Type.ToGL())
+}
+
+// IsResultAvailable returns true if the result can be read without waiting on the GPU
+func (q *Query) IsResultAvailable() bool {
+
+	var available uint32
+	gl.GetQueryObjectuiv(q.Id, gl.QUERY_RESULT_AVAILABLE, &available)
+	return available == gl.TRUE
+}
+
+// TryResult returns the result and true if it is available, otherwise returns false without waiting
+func (q *Query) TryResult() (result uint64, ok bool) {
+
+	if !q.IsResultAvailable() {
+		return 0, false
+	}
+
+	gl.GetQueryObjectui64v(q.Id, gl.QUERY_RESULT, &result)
+	return result, true
+}
+
+// Result returns the result, waiting for the GPU to finish the queried commands if needed
+func (q *Query) Result() uint64 {
+
+	var result uint64
+	gl.GetQueryObjectui64v(q.Id, gl.QUERY_RESULT, &result)
+	return result
+}
+
+func (q *Query) Delete() {
+
+	if q.Id == 0 {
+		return
+	}
+
+	leakcheck.Untrack(leakcheck.ResourceType_Query, q.Id)
+	gl.DeleteQueries(1, &q.Id)
+	q.Id = 0
+}
+
+func NewQuery(queryType QueryType) Query {
+
+	q := Query{
+		Type: queryType,
+	}
+
+	gl.GenQueries(1, &q.Id)
+	leakcheck.Track(leakcheck.ResourceType_Query, q.Id)
+	if q.Id == 0 {
+		logging.ErrLog.Panicln("Failed to create OpenGL query")
+	}
+
+	return q
+}
+
+// QueryResult is a finished query of a QueryPool along with the tag passed to QueryPool.Begin
+type QueryResult struct {
+	Tag   uint64
+	Value uint64
+}
+
+type pendingQuery struct {
+	query Query
+	tag   uint64
+}
+
+// QueryPool reuses queries of one type and collects their results without stalling.
+//
+// Each Begin/End pair is tagged (e.g. with an entity id for occlusion culling, or a profiler scope id),
+// and Poll returns the tags whose results became available since the last Poll.
+// Queries are only returned to the pool once their result is read, so the pool grows to however many
+// queries are in flight and then stays at that size
+type QueryPool struct {
+	Type QueryType
+
+	free    []Query
+	pending []pendingQuery
+	results []QueryResult
+
+	isActive bool
+}
+
+// Begin starts a query with the passed tag. Must be followed by End before another Begin on this pool
+func (qp *QueryPool) Begin(tag uint64) {
+
+	if qp.isActive {
+		logging.ErrLog.Panicf("QueryPool.Begin called while a query of type %s is already active\n", qp.Type.String())
+	}
+
+	var q Query
+	if len(qp.free) > 0 {
+		q = qp.free[len(qp.free)-1]
+		qp.free = qp.free[:len(qp.free)-1]
+	} else {
+		q = NewQuery(qp.Type)
+	}
+
+	qp.isActive = true
+	qp.pending = append(qp.pending, pendingQuery{query: q, tag: tag})
+	q.Begin()
+}
+
+func (qp *QueryPool) End() {
+
+	if !qp.isActive {
+		logging.ErrLog.Panicf("QueryPool.End called while no query of type %s is active\n", qp.Type.String())
+	}
+
+	qp.isActive = false
+	qp.pending[len(qp.pending)-1].query.End()
+}
+
+// Poll returns the results that are available without waiting, in the order their queries were started.
+// The returned slice is reused and is only valid until the next call to Poll
+func (qp *QueryPool) Poll() []QueryResult {
+
+	qp.results = qp.results[:0]
+
+	// The active query (always the last one) can't be read until it ends
+	pendingCount := len(qp.pending)
+	if qp.isActive {
+		pendingCount--
+	}
+
+	stillPending := qp.pending[:0]
+	for i := 0; i < len(qp.pending); i++ {
+
+		p := qp.pending[i]
+		if i < pendingCount {
+
+			if val, ok := p.query.TryResult(); ok {
+				qp.results = append(qp.results, QueryResult{Tag: p.tag, Value: val})
+				qp.free = append(qp.free, p.query)
+				continue
+			}
+		}
+
+		stillPending = append(stillPending, p)
+	}
+	qp.pending = stillPending
+
+	return qp.results
+}
+
+// PendingCount returns the number of queries whose results weren't read yet
+func (qp *QueryPool) PendingCount() int {
+	return len(qp.pending)
+}
+
+func (qp *QueryPool) Delete() {
+
+	for i := 0; i < len(qp.free); i++ {
+		qp.free[i].Delete()
+	}
+
+	for i := 0; i < len(qp.pending); i++ {
+		qp.pending[i].query.Delete()
+	}
+
+	qp.free = nil
+	qp.pending = nil
+	qp.results = nil
+	qp.isActive = false
+}
+
+func NewQueryPool(queryType QueryType) QueryPool {
+	return QueryPool{
+		Type: queryType,
+	}
+}
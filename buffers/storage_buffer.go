@@ -0,0 +1,186 @@
+package buffers
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/bloeys/nmage/glutil"
+	"github.com/bloeys/nmage/leakcheck"
+	"github.com/bloeys/nmage/logging"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+var (
+	storageBufferSupportOnce sync.Once
+	isStorageBufferSupported bool
+)
+
+// IsStorageBufferSupported returns true if the context supports shader storage buffers, which needs
+// GL 4.3 or the ARB_shader_storage_buffer_object extension. Some platforms (e.g. macOS) stop at GL 4.1 and never support them.
+// Must be called after a GL context is created
+func IsStorageBufferSupported() bool {
+
+	storageBufferSupportOnce.Do(func() {
+
+		var major, minor int32
+		gl.GetIntegerv(gl.MAJOR_VERSION, &major)
+		gl.GetIntegerv(gl.MINOR_VERSION, &minor)
+		if major > 4 || (major == 4 && minor >= 3) {
+			isStorageBufferSupported = true
+			return
+		}
+
+		isStorageBufferSupported = glutil.HasGlExtension("GL_ARB_shader_storage_buffer_object")
+	})
+
+	return isStorageBufferSupported
+}
+
+// StorageBuffer is a shader storage buffer object (SSBO). Unlike uniform buffers they can be very large and
+// are indexed dynamically in shaders, which makes them a good fit for per-instance data.
+//
+// Shaders must declare the block with an explicit binding, e.g. 'layout(std430, binding=0) buffer Instances { ... };'
+type StorageBuffer struct {
+	Id uint32
+	// Size is the allocated memory in bytes on the GPU for this buffer
+	Size  uint32
+	Usage BufUsage
+}
+
+func (sb *StorageBuffer) Bind() {
+	gl.BindBuffer(gl.SHADER_STORAGE_BUFFER, sb.Id)
+}
+
+func (sb *StorageBuffer) UnBind() {
+	gl.BindBuffer(gl.SHADER_STORAGE_BUFFER, 0)
+}
+
+// SetData uploads data to the start of the buffer, growing the buffer if it is too small.
+// Growing loses the previous contents of the buffer
+func (sb *StorageBuffer) SetData(data []byte) {
+
+	sb.Bind()
+
+	if uint32(len(data)) > sb.Size {
+		sb.Size = uint32(len(data))
+		gl.BufferData(gl.SHADER_STORAGE_BUFFER, int(sb.Size), gl.Ptr(nil), sb.Usage.ToGL())
+	}
+
+	if len(data) > 0 {
+		gl.BufferSubData(gl.SHADER_STORAGE_BUFFER, 0, len(data), gl.Ptr(&data[0]))
+	}
+}
+
+// BindBase binds the whole buffer to a storage block binding point
+func (sb *StorageBuffer) BindBase(bindPointIndex uint32) {
+	gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, bindPointIndex, sb.Id)
+}
+
+// BindRange binds part of the buffer to a storage block binding point.
+// The offset must be a multiple of GL_SHADER_STORAGE_BUFFER_OFFSET_ALIGNMENT
+func (sb *StorageBuffer) BindRange(bindPointIndex uint32, offsetBytes, sizeBytes uint32) {
+	gl.BindBufferRange(gl.SHADER_STORAGE_BUFFER, bindPointIndex, sb.Id, int(offsetBytes), int(sizeBytes))
+}
+
+func (sb *StorageBuffer) Delete() {
+	leakcheck.Untrack(leakcheck.ResourceType_Buffer, sb.Id)
+	gl.DeleteBuffers(1, &sb.Id)
+	sb.Id = 0
+	sb.Size = 0
+}
+
+// NewStorageBuffer creates a storage buffer of sizeBytes. Returns an error if storage buffers aren't supported
+func NewStorageBuffer(sizeBytes uint32, usage BufUsage) (StorageBuffer, error) {
+
+	if !IsStorageBufferSupported() {
+		return StorageBuffer{}, errors.New("failed to create storage buffer because shader storage buffers need OpenGL 4.3 or GL_ARB_shader_storage_buffer_object")
+	}
+
+	sb := StorageBuffer{
+		Size:  sizeBytes,
+		Usage: usage,
+	}
+
+	gl.GenBuffers(1, &sb.Id)
+	leakcheck.Track(leakcheck.ResourceType_Buffer, sb.Id)
+	if sb.Id == 0 {
+		logging.ErrLog.Panicln("Failed to create OpenGL buffer for a storage buffer")
+	}
+
+	sb.Bind()
+	gl.BufferData(gl.SHADER_STORAGE_BUFFER, int(sizeBytes), gl.Ptr(nil), usage.ToGL())
+	sb.UnBind()
+
+	return sb, nil
+}
+
+// InstanceDataBuffer stores one T per instance in a storage buffer using the std430 layout, so instanced draws
+// can give each instance its own parameters (e.g. color, uv offset, material index) by indexing with gl_InstanceID.
+//
+// T follows the same rules as UniformBufferFieldsFor. In the shader, T is a struct and the block is an unsized array of it:
+//
+//	struct InstanceData { ... };
+//	layout(std430, binding=0) buffer Instances { InstanceData instances[]; };
+type InstanceDataBuffer[T any] struct {
+	Ssbo StorageBuffer
+	// Layout is the std430 layout of one T
+	Layout UniformBuffer
+	// Stride is the distance in bytes between instances in the buffer
+	Stride uint32
+	// Count is the number of instances written by the last Set
+	Count uint32
+
+	scratch []byte
+}
+
+// Set writes all instances to the buffer, growing it if needed
+func (ib *InstanceDataBuffer[T]) Set(instances []T) {
+
+	size := uint32(len(instances)) * ib.Stride
+	if uint32(len(ib.scratch)) < size {
+		ib.scratch = make([]byte, size)
+	}
+
+	buf := ib.scratch[:size]
+	for i := 0; i < len(instances); i++ {
+		offset := uint32(i) * ib.Stride
+		ib.Layout.WriteStruct(buf[offset:offset+ib.Layout.Size], &instances[i])
+	}
+
+	ib.Ssbo.SetData(buf)
+	ib.Count = uint32(len(instances))
+}
+
+// BindBase binds the instance data to the storage block binding point used by the shader
+func (ib *InstanceDataBuffer[T]) BindBase(bindPointIndex uint32) {
+	ib.Ssbo.BindBase(bindPointIndex)
+}
+
+func (ib *InstanceDataBuffer[T]) Delete() {
+	ib.Ssbo.Delete()
+	ib.scratch = nil
+	ib.Count = 0
+}
+
+// NewInstanceDataBuffer creates an instance data buffer with room for capacity instances.
+// Returns an error if storage buffers aren't supported
+func NewInstanceDataBuffer[T any](capacity uint32, usage BufUsage) (InstanceDataBuffer[T], error) {
+
+	fields := UniformBufferFieldsFor[T]()
+
+	ib := InstanceDataBuffer[T]{
+		Layout: NewUniformBufferLayout(fields, BlockLayout_Std430),
+	}
+
+	// Elements of a struct array are placed at multiples of the struct's alignment
+	ib.Stride = roundUpToMultiple(ib.Layout.Size, uint32(BlockLayout_Std430.structAlignment(fields)))
+
+	ssbo, err := NewStorageBuffer(capacity*ib.Stride, usage)
+	if err != nil {
+		return InstanceDataBuffer[T]{}, fmt.Errorf("failed to create instance data buffer. Err: %w", err)
+	}
+
+	ib.Ssbo = ssbo
+	return ib, nil
+}
@@ -0,0 +1,208 @@
+package buffers
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/bloeys/nmage/glutil"
+	"github.com/bloeys/nmage/leakcheck"
+	"github.com/bloeys/nmage/logging"
+	"github.com/bloeys/nmage/materials"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+const (
+	// textureHandleStride is the std140 array stride of a uvec2, which is how handles are read in shaders
+	textureHandleStride = 16
+)
+
+var (
+	bindlessSupportOnce        sync.Once
+	isBindlessTextureSupported bool
+)
+
+// IsBindlessTextureSupported returns true if the context supports ARB_bindless_texture. Must be called after a GL context is created
+func IsBindlessTextureSupported() bool {
+
+	bindlessSupportOnce.Do(func() {
+		isBindlessTextureSupported = glutil.HasGlExtension("GL_ARB_bindless_texture")
+	})
+
+	return isBindlessTextureSupported
+}
+
+// MaterialTextureIndices are the indices of a material's textures in a TextureHandleBuffer, or -1 for textures the material doesn't have
+type MaterialTextureIndices struct {
+	Diffuse  int32
+	Specular int32
+	Normal   int32
+	Emission int32
+}
+
+// TextureHandleBuffer is a uniform buffer of resident bindless texture handles, so shaders can sample any texture
+// in it by index without the texture being bound to a slot. This removes the per-draw glActiveTexture/glBindTexture
+// calls of Material.Bind.
+//
+// Shaders enable the extension and read handles as uvec2s, where the block's array size must match the buffer capacity:
+//
+//	#extension GL_ARB_bindless_texture : require
+//	layout (std140) uniform TextureHandles { uvec2 textureHandles[CAPACITY]; };
+//	...
+//	texture(sampler2D(textureHandles[material.diffuseIndex]), uv)
+//
+// Only available when IsBindlessTextureSupported is true. Otherwise materials keep using texture slots
+type TextureHandleBuffer struct {
+	Id       uint32
+	Capacity uint32
+
+	// handles are the handle of each index, where zero means the index is free
+	handles    []uint64
+	indexByTex map[uint32]uint32
+	isDirty    bool
+	scratch    []byte
+}
+
+// AddTexture makes the texture resident and returns its index in the buffer. Adding the same texture again returns the same index
+func (thb *TextureHandleBuffer) AddTexture(texId uint32) uint32 {
+
+	if index, ok := thb.indexByTex[texId]; ok {
+		return index
+	}
+
+	index := uint32(0)
+	for index < uint32(len(thb.handles)) && thb.handles[index] != 0 {
+		index++
+	}
+
+	if index >= thb.Capacity {
+		logging.ErrLog.Panicf("texture handle buffer is full. Capacity=%d\n", thb.Capacity)
+	}
+
+	handle := gl.GetTextureHandleARB(texId)
+	if handle == 0 {
+		logging.ErrLog.Panicf("failed to get bindless handle of texture id=%d\n", texId)
+	}
+
+	// Once a texture has a handle its state can't change (e.g. no new mipmaps or filtering), so add textures after they are fully set up
+	gl.MakeTextureHandleResidentARB(handle)
+
+	if index == uint32(len(thb.handles)) {
+		thb.handles = append(thb.handles, handle)
+	} else {
+		thb.handles[index] = handle
+	}
+
+	thb.indexByTex[texId] = index
+	thb.isDirty = true
+	return index
+}
+
+// RemoveTexture makes the texture non-resident and frees its index. Shaders must not use the index after this
+func (thb *TextureHandleBuffer) RemoveTexture(texId uint32) {
+
+	index, ok := thb.indexByTex[texId]
+	if !ok {
+		return
+	}
+
+	gl.MakeTextureHandleNonResidentARB(thb.handles[index])
+	thb.handles[index] = 0
+	delete(thb.indexByTex, texId)
+	thb.isDirty = true
+}
+
+// AddMaterial adds the diffuse, specular, normal and emission textures of the material and sets MaterialSettings_BindlessTextures on it,
+// so it stops binding them to slots. The returned indices are usually passed to the material's shader as uniforms once at startup
+func (thb *TextureHandleBuffer) AddMaterial(mat *materials.Material) MaterialTextureIndices {
+
+	indexOrNone := func(texId uint32) int32 {
+		if texId == 0 {
+			return -1
+		}
+		return int32(thb.AddTexture(texId))
+	}
+
+	indices := MaterialTextureIndices{
+		Diffuse:  indexOrNone(mat.DiffuseTex),
+		Specular: indexOrNone(mat.SpecularTex),
+		Normal:   indexOrNone(mat.NormalTex),
+		Emission: indexOrNone(mat.EmissionTex),
+	}
+
+	mat.Settings.Set(materials.MaterialSettings_BindlessTextures)
+	return indices
+}
+
+// Upload writes changed handles to the GPU. Call once after adding or removing textures, before drawing
+func (thb *TextureHandleBuffer) Upload() {
+
+	if !thb.isDirty {
+		return
+	}
+
+	size := len(thb.handles) * textureHandleStride
+	if len(thb.scratch) < size {
+		thb.scratch = make([]byte, size)
+	}
+
+	for i := 0; i < len(thb.handles); i++ {
+		binary.LittleEndian.PutUint64(thb.scratch[i*textureHandleStride:], thb.handles[i])
+	}
+
+	if size > 0 {
+		gl.BindBuffer(gl.UNIFORM_BUFFER, thb.Id)
+		gl.BufferSubData(gl.UNIFORM_BUFFER, 0, size, gl.Ptr(&thb.scratch[0]))
+		gl.BindBuffer(gl.UNIFORM_BUFFER, 0)
+	}
+
+	thb.isDirty = false
+}
+
+// BindBase binds the buffer to the uniform block binding point of the TextureHandles block
+func (thb *TextureHandleBuffer) BindBase(bindPointIndex uint32) {
+	gl.BindBufferBase(gl.UNIFORM_BUFFER, bindPointIndex, thb.Id)
+}
+
+// Delete makes all textures non-resident and deletes the buffer
+func (thb *TextureHandleBuffer) Delete() {
+
+	for i := 0; i < len(thb.handles); i++ {
+		if thb.handles[i] != 0 {
+			gl.MakeTextureHandleNonResidentARB(thb.handles[i])
+		}
+	}
+
+	leakcheck.Untrack(leakcheck.ResourceType_Buffer, thb.Id)
+	gl.DeleteBuffers(1, &thb.Id)
+	thb.Id = 0
+	thb.handles = nil
+	thb.indexByTex = nil
+}
+
+// NewTextureHandleBuffer creates a buffer that can hold capacity texture handles.
+// Returns an error if bindless textures aren't supported, in which case materials should keep using texture slots
+func NewTextureHandleBuffer(capacity uint32) (TextureHandleBuffer, error) {
+
+	if !IsBindlessTextureSupported() {
+		return TextureHandleBuffer{}, errors.New("failed to create texture handle buffer because bindless textures need GL_ARB_bindless_texture")
+	}
+
+	thb := TextureHandleBuffer{
+		Capacity:   capacity,
+		handles:    make([]uint64, 0, capacity),
+		indexByTex: make(map[uint32]uint32, capacity),
+	}
+
+	gl.GenBuffers(1, &thb.Id)
+	leakcheck.Track(leakcheck.ResourceType_Buffer, thb.Id)
+	if thb.Id == 0 {
+		logging.ErrLog.Panicln("Failed to create OpenGL buffer for a texture handle buffer")
+	}
+
+	gl.BindBuffer(gl.UNIFORM_BUFFER, thb.Id)
+	gl.BufferData(gl.UNIFORM_BUFFER, int(capacity)*textureHandleStride, gl.Ptr(nil), gl.STATIC_DRAW)
+	gl.BindBuffer(gl.UNIFORM_BUFFER, 0)
+
+	return thb, nil
+}
@@ -0,0 +1,160 @@
+package buffers
+
+import (
+	"github.com/bloeys/nmage/assert"
+	"github.com/bloeys/nmage/leakcheck"
+	"github.com/bloeys/nmage/logging"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+type TransformFeedbackPrimitive int32
+
+const (
+	TransformFeedbackPrimitive_Points TransformFeedbackPrimitive = iota
+	TransformFeedbackPrimitive_Lines
+	TransformFeedbackPrimitive_Triangles
+)
+
+func (p TransformFeedbackPrimitive) ToGL() uint32 {
+
+	switch p {
+	case TransformFeedbackPrimitive_Points:
+		return gl.POINTS
+	case TransformFeedbackPrimitive_Lines:
+		return gl.LINES
+	case TransformFeedbackPrimitive_Triangles:
+		return gl.TRIANGLES
+
+	default:
+		assert.T(false, "Unknown transform feedback primitive passed. Primitive '%d'", p)
+		return 0
+	}
+}
+
+// TransformFeedback captures the outputs of the vertex (or geometry) shader into vertex buffers.
+//
+// GL 4.1 has no compute shaders, so this is how GPU-side simulations (e.g. particles) and vertex processing are done:
+// draw with a program created by shaders.LoadAndCompileTransformFeedbackShader while capturing into a buffer,
+// then use that buffer as the input of the next frame (ping-ponging between two buffers).
+// The captured varyings must match the primitive passed to Begin/Capture and the mode used for the draws
+type TransformFeedback struct {
+	Id uint32
+	// Buffers are the vertex buffers bound as capture targets, where index i is TRANSFORM_FEEDBACK_BUFFER binding i
+	Buffers  []*VertexBuffer
+	isActive bool
+}
+
+func (tf *TransformFeedback) Bind() {
+	gl.BindTransformFeedback(gl.TRANSFORM_FEEDBACK, tf.Id)
+}
+
+func (tf *TransformFeedback) UnBind() {
+	gl.BindTransformFeedback(gl.TRANSFORM_FEEDBACK, 0)
+}
+
+// SetBuffer sets the vertex buffer varyings are written to at the passed index.
+// With interleaved capture only index 0 is used, while with separate capture each varying uses its own index.
+// The buffer must already have enough memory for everything captured (see VertexBuffer.Allocate)
+func (tf *TransformFeedback) SetBuffer(index uint32, vb *VertexBuffer) {
+	tf.SetBufferRange(index, vb, 0, vb.Size)
+}
+
+// SetBufferRange is like SetBuffer but only captures into sizeBytes starting at offsetBytes.
+// The offset must be a multiple of 4
+func (tf *TransformFeedback) SetBufferRange(index uint32, vb *VertexBuffer, offsetBytes, sizeBytes int) {
+
+	assert.T(!tf.isActive, "Can't change transform feedback buffers while capture is active")
+	assert.T(offsetBytes%4 == 0, "Transform feedback buffer offset must be a multiple of 4, but got %d", offsetBytes)
+	assert.T(offsetBytes >= 0 && offsetBytes+sizeBytes <= vb.Size, "Transform feedback buffer range is outside the buffer. Offset=%d, Size=%d, Buffer size=%d", offsetBytes, sizeBytes, vb.Size)
+
+	for uint32(len(tf.Buffers)) <= index {
+		tf.Buffers = append(tf.Buffers, nil)
+	}
+	tf.Buffers[index] = vb
+
+	tf.Bind()
+	gl.BindBufferRange(gl.TRANSFORM_FEEDBACK_BUFFER, index, vb.Id, offsetBytes, sizeBytes)
+}
+
+// Begin starts capturing. The transform feedback object and a program with transform feedback varyings
+// must be bound, and all draws until End must use primitives matching the passed primitive
+// (e.g. triangle strips are fine with TransformFeedbackPrimitive_Triangles)
+func (tf *TransformFeedback) Begin(primitive TransformFeedbackPrimitive) {
+
+	assert.T(!tf.isActive, "Transform feedback Begin called while capture is already active")
+
+	tf.isActive = true
+	gl.BeginTransformFeedback(primitive.ToGL())
+}
+
+func (tf *TransformFeedback) End() {
+
+	assert.T(tf.isActive, "Transform feedback End called while capture is not active")
+
+	tf.isActive = false
+	gl.EndTransformFeedback()
+}
+
+// Pause stops capturing without ending it, so other draws can happen in between. Must be followed by Resume or End
+func (tf *TransformFeedback) Pause() {
+	gl.PauseTransformFeedback()
+}
+
+func (tf *TransformFeedback) Resume() {
+	gl.ResumeTransformFeedback()
+}
+
+// Capture binds the transform feedback, runs draw while capturing into the bound buffers, then unbinds.
+//
+// If discardRasterizer is true nothing is rasterized, which is what you want when only the captured data is needed
+// (e.g. updating particles). The program with the captured varyings must be bound before calling this
+func (tf *TransformFeedback) Capture(primitive TransformFeedbackPrimitive, discardRasterizer bool, draw func()) {
+
+	if discardRasterizer {
+		gl.Enable(gl.RASTERIZER_DISCARD)
+	}
+
+	tf.Bind()
+	tf.Begin(primitive)
+
+	draw()
+
+	tf.End()
+	tf.UnBind()
+
+	if discardRasterizer {
+		gl.Disable(gl.RASTERIZER_DISCARD)
+	}
+}
+
+// Draw draws the vertices captured by the last capture of this transform feedback, without needing to
+// read back how many vertices were written. The VAO that reads the captured buffer must be bound
+func (tf *TransformFeedback) Draw(mode uint32) {
+	gl.DrawTransformFeedback(mode, tf.Id)
+}
+
+func (tf *TransformFeedback) Delete() {
+
+	if tf.Id == 0 {
+		return
+	}
+
+	leakcheck.Untrack(leakcheck.ResourceType_TransformFeedback, tf.Id)
+	gl.DeleteTransformFeedbacks(1, &tf.Id)
+	tf.Id = 0
+	tf.Buffers = nil
+	tf.isActive = false
+}
+
+func NewTransformFeedback() TransformFeedback {
+
+	tf := TransformFeedback{}
+
+	gl.GenTransformFeedbacks(1, &tf.Id)
+	leakcheck.Track(leakcheck.ResourceType_TransformFeedback, tf.Id)
+	if tf.Id == 0 {
+		logging.ErrLog.Panicln("Failed to create OpenGL transform feedback object")
+	}
+
+	return tf
+}
@@ -3,13 +3,21 @@ package buffers
 import (
 	"math"
 	"reflect"
+	"unsafe"
 
 	"github.com/bloeys/gglm/gglm"
 	"github.com/bloeys/nmage/assert"
+	"github.com/bloeys/nmage/color"
+	"github.com/bloeys/nmage/leakcheck"
 	"github.com/bloeys/nmage/logging"
 	"github.com/go-gl/gl/v4.1-core/gl"
 )
 
+var (
+	// colorType is accepted in vec3 fields (RGB is written) and vec4 fields (RGBA is written)
+	colorType = reflect.TypeOf(color.Color{})
+)
+
 type UniformBufferFieldInput struct {
 	Id   uint16
 	Type ElementType
@@ -28,10 +36,15 @@ type UniformBufferField struct {
 	// Count should be set in case this field is an array of type `[Count]Type`.
 	// Count=0 is valid and is equivalent to Count=1, which means the type is NOT an array, but a single field.
 	Count uint16
-	Type  ElementType
+	// ArrayStride is the distance in bytes between elements when Count > 1
+	ArrayStride uint16
+	Type        ElementType
 
 	// Subfields is used when type is a struct, in which case it holds the fields of the struct.
 	// Ids do not have to be unique across structs.
+	//
+	// Subfields are flattened, so a struct inside this struct is followed by its own subfields,
+	// which is the same order used by UniformBuffer.Fields
 	Subfields []UniformBufferField
 }
 
@@ -40,6 +53,16 @@ type UniformBuffer struct {
 	// Size is the allocated memory in bytes on the GPU for this uniform buffer
 	Size   uint32
 	Fields []UniformBufferField
+	// Layout is the memory layout used to compute field offsets, which must match the layout declared in the shader
+	Layout BlockLayout
+
+	// shadowBuf is a CPU copy of what was uploaded, which SetStruct compares against to only upload changed bytes.
+	// It is nil until the first SetStruct
+	shadowBuf  []byte
+	scratchBuf []byte
+
+	// copyPlan is built on the first SetStruct/WriteStruct with a struct pointer, and rebuilt if the struct type changes
+	copyPlan *copyPlan
 }
 
 func (ub *UniformBuffer) Bind() {
@@ -54,7 +77,7 @@ func (ub *UniformBuffer) SetBindPoint(bindPointIndex uint32) {
 	gl.BindBufferBase(gl.UNIFORM_BUFFER, bindPointIndex, ub.Id)
 }
 
-func addUniformBufferFieldsToArray(startAlignedOffset uint16, arrayToAddTo *[]UniformBufferField, fieldsToAdd []UniformBufferFieldInput) (totalSize uint32) {
+func addUniformBufferFieldsToArray(layout BlockLayout, startAlignedOffset uint16, arrayToAddTo *[]UniformBufferField, fieldsToAdd []UniformBufferFieldInput) (totalSize uint32) {
 
 	if len(fieldsToAdd) == 0 {
 		return 0
@@ -87,71 +110,64 @@ func addUniformBufferFieldsToArray(startAlignedOffset uint16, arrayToAddTo *[]Un
 		// To get the nearest boundary larger than the offset we can:
 		// offset + (boundary - alignErr) == 100 + (16 - 4) == 112; 112 % 16 == 0, meaning its a boundary
 		//
-		// Note that arrays of scalars/vectors are always aligned to 16 bytes, like a vec4
+		// Note that in std140 arrays of scalars/vectors are always aligned to 16 bytes, like a vec4,
+		// while in std430 they keep the alignment of their element type
 		//
 		// Official spec and full details in subsection 'Standard Uniform Block Layout' at http://www.opengl.org/registry/specs/ARB/uniform_buffer_object.txt
-		var alignmentBoundary uint16 = 16
-		if f.Count == 1 {
-			alignmentBoundary = f.Type.GlStd140AlignmentBoundary()
+		var alignmentBoundary uint16
+		if f.Type == DataTypeStruct {
+			alignmentBoundary = layout.structAlignment(f.Subfields)
+		} else if f.Count > 1 {
+			alignmentBoundary = layout.arrayAlignment(f.Type)
+		} else {
+			alignmentBoundary = layout.BaseAlignment(f.Type)
 		}
 
-		alignmentError := alignedOffset % alignmentBoundary
-		if alignmentError != 0 {
-			alignedOffset += alignmentBoundary - alignmentError
-		}
+		alignedOffset = roundUpToMultiple(alignedOffset, alignmentBoundary)
 
 		newField := UniformBufferField{Id: f.Id, Type: f.Type, AlignedOffset: startAlignedOffset + alignedOffset, Count: f.Count}
 		*arrayToAddTo = append(*arrayToAddTo, newField)
+		newFieldIndex := len(*arrayToAddTo) - 1
 
-		// Prepare aligned offset for the next field.
-		//
-		// Matrices are treated as an array of column vectors, where each column is a vec4,
-		// that's why we have a multiplier depending on how many columns we have when calculating
-		// the offset
-		multiplier := uint16(1)
-		if f.Type == DataTypeMat2 {
-			multiplier = 2
-		} else if f.Type == DataTypeMat3 {
-			multiplier = 3
-		} else if f.Type == DataTypeMat4 {
-			multiplier = 4
-		}
-
+		// Prepare aligned offset for the next field
 		if f.Type == DataTypeStruct {
 
-			subfieldsAlignedOffset := uint16(addUniformBufferFieldsToArray(startAlignedOffset+alignedOffset, arrayToAddTo, f.Subfields))
+			subfieldsAlignedOffset := uint16(addUniformBufferFieldsToArray(layout, startAlignedOffset+alignedOffset, arrayToAddTo, f.Subfields))
+
+			// Structs are padded to their alignment, which means fields after a struct are always aligned to it
+			structStride := roundUpToMultiple(subfieldsAlignedOffset, alignmentBoundary)
+			(*arrayToAddTo)[newFieldIndex].ArrayStride = structStride
+
+			subfieldsEnd := len(*arrayToAddTo)
+			(*arrayToAddTo)[newFieldIndex].Subfields = (*arrayToAddTo)[newFieldIndex+1 : subfieldsEnd : subfieldsEnd]
+			alignedOffset += structStride * f.Count
+
+		} else if f.Count > 1 {
 
-			// Pad structs to 16 byte boundary
-			padTo16Boundary(&subfieldsAlignedOffset)
-			alignedOffset += subfieldsAlignedOffset * f.Count
+			arrayStride := layout.ArrayStride(f.Type)
+			(*arrayToAddTo)[newFieldIndex].ArrayStride = arrayStride
+			alignedOffset += arrayStride * f.Count
 
 		} else {
 
 			// Elements advance the alignedOffset by their actual byte size.
 			// Aligned offset is padded if the place its at is not aligned to the boundary required by the next element.
 			//
-			// The exception is structs, because fields after a struct field are always aligned at a 16 byte boundary.
-			//
 			// For example, a vec3 starting at offset 80, taking 12 bytes, would put the aligned offset at 92.
 			// If the next element is a float32 (alignment boundary = 4) then no padding is required and
 			// the float will start at 92 and end at 96.
 			// However, if the element after the vec3 is a vec3 (alignment boundary = 16), then it would require
 			// a padding of 4 bytes so that it can start at 96, which is aligned to 16. In this case the second vec3
 			// would start at 96 and end at 96+12=108.
-			alignedOffset = newField.AlignedOffset + uint16(f.Type.GlStd140SizeBytes())*f.Count*multiplier - startAlignedOffset
+			//
+			// Matrices are treated as an array of column vectors, so their size includes the padding between columns
+			alignedOffset += layout.SizeBytes(f.Type)
 		}
 	}
 
 	return uint32(alignedOffset)
 }
 
-func padTo16Boundary[T uint16 | int | int32](val *T) {
-	alignmentError := *val % 16
-	if alignmentError != 0 {
-		*val += 16 - alignmentError
-	}
-}
-
 func (ub *UniformBuffer) getField(fieldId uint16, fieldType ElementType) UniformBufferField {
 
 	for i := 0; i < len(ub.Fields); i++ {
@@ -174,56 +190,161 @@ func (ub *UniformBuffer) getField(fieldId uint16, fieldType ElementType) Uniform
 func (ub *UniformBuffer) SetInt32(fieldId uint16, val int32) {
 
 	f := ub.getField(fieldId, DataTypeInt32)
-	gl.BufferSubData(gl.UNIFORM_BUFFER, int(f.AlignedOffset), 4, gl.Ptr(&val))
+	ub.subData(int(f.AlignedOffset), 4, unsafe.Pointer(&val))
 }
 
 func (ub *UniformBuffer) SetUint32(fieldId uint16, val uint32) {
 
 	f := ub.getField(fieldId, DataTypeUint32)
-	gl.BufferSubData(gl.UNIFORM_BUFFER, int(f.AlignedOffset), 4, gl.Ptr(&val))
+	ub.subData(int(f.AlignedOffset), 4, unsafe.Pointer(&val))
 }
 
 func (ub *UniformBuffer) SetFloat32(fieldId uint16, val float32) {
 
 	f := ub.getField(fieldId, DataTypeFloat32)
-	gl.BufferSubData(gl.UNIFORM_BUFFER, int(f.AlignedOffset), 4, gl.Ptr(&val))
+	ub.subData(int(f.AlignedOffset), 4, unsafe.Pointer(&val))
 }
 
 func (ub *UniformBuffer) SetVec2(fieldId uint16, val *gglm.Vec2) {
 	f := ub.getField(fieldId, DataTypeVec2)
-	gl.BufferSubData(gl.UNIFORM_BUFFER, int(f.AlignedOffset), 4*2, gl.Ptr(&val.Data[0]))
+	ub.subData(int(f.AlignedOffset), 4*2, unsafe.Pointer(&val.Data[0]))
 }
 
 func (ub *UniformBuffer) SetVec3(fieldId uint16, val *gglm.Vec3) {
 	f := ub.getField(fieldId, DataTypeVec3)
-	gl.BufferSubData(gl.UNIFORM_BUFFER, int(f.AlignedOffset), 4*3, gl.Ptr(&val.Data[0]))
+	ub.subData(int(f.AlignedOffset), 4*3, unsafe.Pointer(&val.Data[0]))
 }
 
 func (ub *UniformBuffer) SetVec4(fieldId uint16, val *gglm.Vec4) {
 	f := ub.getField(fieldId, DataTypeVec4)
-	gl.BufferSubData(gl.UNIFORM_BUFFER, int(f.AlignedOffset), 4*4, gl.Ptr(&val.Data[0]))
+	ub.subData(int(f.AlignedOffset), 4*4, unsafe.Pointer(&val.Data[0]))
 }
 
+// SetMat2 writes the matrix with its columns padded according to the buffer's layout
 func (ub *UniformBuffer) SetMat2(fieldId uint16, val *gglm.Mat2) {
+
 	f := ub.getField(fieldId, DataTypeMat2)
-	gl.BufferSubData(gl.UNIFORM_BUFFER, int(f.AlignedOffset), 4*4, gl.Ptr(&val.Data[0][0]))
+	size := int(ub.Layout.SizeBytes(DataTypeMat2))
+
+	var buf [2 * 16]byte
+	bytesWritten := 0
+	WriteMat2SliceToByteBufWithAlignment(buf[:], &bytesWritten, size, []gglm.Mat2{*val})
+	ub.subData(int(f.AlignedOffset), size, unsafe.Pointer(&buf[0]))
 }
 
+// SetMat3 writes the matrix with each column padded to 16 bytes, as required by both layouts
 func (ub *UniformBuffer) SetMat3(fieldId uint16, val *gglm.Mat3) {
+
 	f := ub.getField(fieldId, DataTypeMat3)
-	gl.BufferSubData(gl.UNIFORM_BUFFER, int(f.AlignedOffset), 4*9, gl.Ptr(&val.Data[0][0]))
+	size := int(ub.Layout.SizeBytes(DataTypeMat3))
+
+	var buf [3 * 16]byte
+	bytesWritten := 0
+	WriteMat3SliceToByteBufWithAlignment(buf[:], &bytesWritten, size, []gglm.Mat3{*val})
+	ub.subData(int(f.AlignedOffset), size, unsafe.Pointer(&buf[0]))
 }
 
 func (ub *UniformBuffer) SetMat4(fieldId uint16, val *gglm.Mat4) {
 	f := ub.getField(fieldId, DataTypeMat4)
-	gl.BufferSubData(gl.UNIFORM_BUFFER, int(f.AlignedOffset), 4*16, gl.Ptr(&val.Data[0][0]))
+	ub.subData(int(f.AlignedOffset), 4*16, unsafe.Pointer(&val.Data[0][0]))
 }
 
+// WriteStruct writes inputStruct into buf using the buffer's layout without uploading anything.
+// buf must be at least Size bytes, and bytes that are padding are left untouched.
+//
+// inputStruct can be a struct or a pointer to a struct. Pointers use a precomputed copy plan that doesn't use
+// reflection or allocate, so prefer them for per frame updates. Structs with pointer fields always use reflection
+func (ub *UniformBuffer) WriteStruct(buf []byte, inputStruct any) {
+
+	// Not using assert.T because its arguments would allocate on every call
+	if len(buf) < int(ub.Size) {
+		logging.ErrLog.Panicf("WriteStruct needs a buffer of at least %d bytes, but got %d\n", ub.Size, len(buf))
+	}
+
+	if ub.writeStructFast(buf, inputStruct) {
+		return
+	}
+
+	val := reflect.ValueOf(inputStruct)
+	if val.Kind() == reflect.Pointer {
+		inputStruct = val.Elem().Interface()
+	}
+
+	setStruct(ub.Layout, ub.Fields, buf, inputStruct, 1000_000, true, 0)
+}
+
+// subData uploads size bytes at offset and keeps the shadow copy in sync, so SetStruct diffs against the right data
+func (ub *UniformBuffer) subData(offset, size int, ptr unsafe.Pointer) {
+
+	gl.BufferSubData(gl.UNIFORM_BUFFER, offset, size, ptr)
+
+	if ub.shadowBuf != nil {
+		copy(ub.shadowBuf[offset:offset+size], unsafe.Slice((*byte)(ptr), size))
+	}
+}
+
+// SetStruct writes all fields of inputStruct, whose fields must match the uniform buffer fields in order and type.
+// Pass a pointer to the struct to avoid reflection and allocations (see WriteStruct).
+//
+// Only the byte ranges that changed since the last upload are sent to the GPU, so changing one
+// point light in a large lights struct only uploads that light's bytes
 func (ub *UniformBuffer) SetStruct(inputStruct any) {
-	setStruct(ub.Fields, make([]byte, ub.Size), inputStruct, 1000_000, false, 0)
+
+	if ub.Size == 0 {
+		return
+	}
+
+	if len(ub.scratchBuf) != int(ub.Size) {
+		ub.scratchBuf = make([]byte, ub.Size)
+	}
+
+	ub.WriteStruct(ub.scratchBuf, inputStruct)
+
+	if ub.shadowBuf == nil {
+		gl.BufferSubData(gl.UNIFORM_BUFFER, 0, len(ub.scratchBuf), gl.Ptr(&ub.scratchBuf[0]))
+		ub.shadowBuf = make([]byte, ub.Size)
+	} else {
+		ub.uploadDirtyRanges()
+	}
+
+	// Padding bytes are never written by setStruct, so swapping keeps both buffers identical in the padding
+	ub.shadowBuf, ub.scratchBuf = ub.scratchBuf, ub.shadowBuf
 }
 
-func setStruct(fields []UniformBufferField, buf []byte, inputStruct any, maxFieldsToConsume int, onlyBufWrite bool, writeOffset int) (bytesWritten, fieldsConsumed int) {
+// uploadDirtyRanges uploads the parts of scratchBuf that differ from shadowBuf.
+// Comparison is done in 16 byte blocks (the std140 vec4 size), and nearby dirty blocks are merged
+// into one upload since many small BufferSubData calls cost more than uploading a few extra bytes
+func (ub *UniformBuffer) uploadDirtyRanges() {
+
+	const blockSize = 16
+	const maxGapBytes = 64
+
+	dirtyStart := -1
+	dirtyEnd := -1
+	for blockStart := 0; blockStart < len(ub.scratchBuf); blockStart += blockSize {
+
+		blockEnd := min(blockStart+blockSize, len(ub.scratchBuf))
+		if string(ub.scratchBuf[blockStart:blockEnd]) == string(ub.shadowBuf[blockStart:blockEnd]) {
+			continue
+		}
+
+		if dirtyStart != -1 && blockStart-dirtyEnd > maxGapBytes {
+			gl.BufferSubData(gl.UNIFORM_BUFFER, dirtyStart, dirtyEnd-dirtyStart, gl.Ptr(&ub.scratchBuf[dirtyStart]))
+			dirtyStart = -1
+		}
+
+		if dirtyStart == -1 {
+			dirtyStart = blockStart
+		}
+		dirtyEnd = blockEnd
+	}
+
+	if dirtyStart != -1 {
+		gl.BufferSubData(gl.UNIFORM_BUFFER, dirtyStart, dirtyEnd-dirtyStart, gl.Ptr(&ub.scratchBuf[dirtyStart]))
+	}
+}
+
+func setStruct(layout BlockLayout, fields []UniformBufferField, buf []byte, inputStruct any, maxFieldsToConsume int, onlyBufWrite bool, writeOffset int) (bytesWritten, fieldsConsumed int) {
 
 	if len(fields) == 0 {
 		return
@@ -238,9 +359,16 @@ func setStruct(fields []UniformBufferField, buf []byte, inputStruct any, maxFiel
 		logging.ErrLog.Panicf("UniformBuffer.SetStruct called with a value that is not a struct. Val=%v\n", inputStruct)
 	}
 
+	// Arrays can only be sliced if they are addressable, which values passed by copy are not
+	if !structVal.CanAddr() {
+		addressableVal := reflect.New(structVal.Type()).Elem()
+		addressableVal.Set(structVal)
+		structVal = addressableVal
+	}
+
 	// Needed because fieldIndex can move faster than struct fields in case of struct fields
 	structFieldIndex := 0
-	for fieldIndex := 0; fieldIndex < len(fields) && fieldIndex < maxFieldsToConsume; fieldIndex++ {
+	for fieldIndex := 0; fieldIndex < len(fields) && structFieldIndex < maxFieldsToConsume; fieldIndex++ {
 
 		ubField := &fields[fieldIndex]
 		valField := structVal.Field(structFieldIndex)
@@ -267,6 +395,7 @@ func setStruct(fields []UniformBufferField, buf []byte, inputStruct any, maxFiel
 		}
 
 		typeMatches := false
+		arrayStride := int(ubField.ArrayStride)
 		bytesWritten = int(ubField.AlignedOffset) + writeOffset
 
 		switch ubField.Type {
@@ -277,7 +406,7 @@ func setStruct(fields []UniformBufferField, buf []byte, inputStruct any, maxFiel
 			if typeMatches {
 
 				if isArray {
-					Write32BitIntegerSliceToByteBufWithAlignment(buf, &bytesWritten, 16, valField.Slice(0, valField.Len()).Interface().([]uint32))
+					Write32BitIntegerSliceToByteBufWithAlignment(buf, &bytesWritten, arrayStride, valField.Slice(0, valField.Len()).Interface().([]uint32))
 				} else {
 					Write32BitIntegerToByteBuf(buf, &bytesWritten, uint32(valField.Uint()))
 				}
@@ -289,7 +418,7 @@ func setStruct(fields []UniformBufferField, buf []byte, inputStruct any, maxFiel
 			if typeMatches {
 
 				if isArray {
-					WriteF32SliceToByteBufWithAlignment(buf, &bytesWritten, 16, valField.Slice(0, valField.Len()).Interface().([]float32))
+					WriteF32SliceToByteBufWithAlignment(buf, &bytesWritten, arrayStride, valField.Slice(0, valField.Len()).Interface().([]float32))
 				} else {
 					WriteF32ToByteBuf(buf, &bytesWritten, float32(valField.Float()))
 				}
@@ -301,7 +430,7 @@ func setStruct(fields []UniformBufferField, buf []byte, inputStruct any, maxFiel
 			if typeMatches {
 
 				if isArray {
-					Write32BitIntegerSliceToByteBufWithAlignment(buf, &bytesWritten, 16, valField.Slice(0, valField.Len()).Interface().([]int32))
+					Write32BitIntegerSliceToByteBufWithAlignment(buf, &bytesWritten, arrayStride, valField.Slice(0, valField.Len()).Interface().([]int32))
 				} else {
 					Write32BitIntegerToByteBuf(buf, &bytesWritten, uint32(valField.Int()))
 				}
@@ -314,7 +443,7 @@ func setStruct(fields []UniformBufferField, buf []byte, inputStruct any, maxFiel
 			if typeMatches {
 
 				if isArray {
-					WriteVec2SliceToByteBufWithAlignment(buf, &bytesWritten, 16, valField.Slice(0, valField.Len()).Interface().([]gglm.Vec2))
+					WriteVec2SliceToByteBufWithAlignment(buf, &bytesWritten, arrayStride, valField.Slice(0, valField.Len()).Interface().([]gglm.Vec2))
 				} else {
 					v2 := valField.Interface().(gglm.Vec2)
 					WriteF32SliceToByteBuf(buf, &bytesWritten, v2.Data[:])
@@ -328,11 +457,21 @@ func setStruct(fields []UniformBufferField, buf []byte, inputStruct any, maxFiel
 			if typeMatches {
 
 				if isArray {
-					WriteVec3SliceToByteBufWithAlignment(buf, &bytesWritten, 16, valField.Slice(0, valField.Len()).Interface().([]gglm.Vec3))
+					WriteVec3SliceToByteBufWithAlignment(buf, &bytesWritten, arrayStride, valField.Slice(0, valField.Len()).Interface().([]gglm.Vec3))
 				} else {
 					v3 := valField.Interface().(gglm.Vec3)
 					WriteF32SliceToByteBuf(buf, &bytesWritten, v3.Data[:])
 				}
+			} else if elementType == colorType {
+
+				// Colors in vec3 fields only write RGB
+				typeMatches = true
+				if isArray {
+					WriteColorSliceToByteBufWithAlignment(buf, &bytesWritten, arrayStride, false, valField.Slice(0, valField.Len()).Interface().([]color.Color))
+				} else {
+					c := valField.Interface().(color.Color)
+					WriteF32SliceToByteBuf(buf, &bytesWritten, c.Data[:3])
+				}
 			}
 
 		case DataTypeVec4:
@@ -342,11 +481,20 @@ func setStruct(fields []UniformBufferField, buf []byte, inputStruct any, maxFiel
 			if typeMatches {
 
 				if isArray {
-					WriteVec4SliceToByteBufWithAlignment(buf, &bytesWritten, 16, valField.Slice(0, valField.Len()).Interface().([]gglm.Vec4))
+					WriteVec4SliceToByteBufWithAlignment(buf, &bytesWritten, arrayStride, valField.Slice(0, valField.Len()).Interface().([]gglm.Vec4))
 				} else {
 					v3 := valField.Interface().(gglm.Vec4)
 					WriteF32SliceToByteBuf(buf, &bytesWritten, v3.Data[:])
 				}
+			} else if elementType == colorType {
+
+				typeMatches = true
+				if isArray {
+					WriteColorSliceToByteBufWithAlignment(buf, &bytesWritten, arrayStride, true, valField.Slice(0, valField.Len()).Interface().([]color.Color))
+				} else {
+					c := valField.Interface().(color.Color)
+					WriteF32SliceToByteBuf(buf, &bytesWritten, c.Data[:])
+				}
 			}
 
 		case DataTypeMat2:
@@ -356,12 +504,11 @@ func setStruct(fields []UniformBufferField, buf []byte, inputStruct any, maxFiel
 			if typeMatches {
 
 				if isArray {
-					m2Arr := valField.Interface().([]gglm.Mat2)
-					WriteMat2SliceToByteBufWithAlignment(buf, &bytesWritten, 16*2, m2Arr)
+					m2Arr := valField.Slice(0, valField.Len()).Interface().([]gglm.Mat2)
+					WriteMat2SliceToByteBufWithAlignment(buf, &bytesWritten, arrayStride, m2Arr)
 				} else {
 					m := valField.Interface().(gglm.Mat2)
-					WriteF32SliceToByteBuf(buf, &bytesWritten, m.Data[0][:])
-					WriteF32SliceToByteBuf(buf, &bytesWritten, m.Data[1][:])
+					WriteMat2SliceToByteBufWithAlignment(buf, &bytesWritten, int(layout.SizeBytes(DataTypeMat2)), []gglm.Mat2{m})
 				}
 			}
 
@@ -372,13 +519,11 @@ func setStruct(fields []UniformBufferField, buf []byte, inputStruct any, maxFiel
 			if typeMatches {
 
 				if isArray {
-					m3Arr := valField.Interface().([]gglm.Mat3)
-					WriteMat3SliceToByteBufWithAlignment(buf, &bytesWritten, 16*3, m3Arr)
+					m3Arr := valField.Slice(0, valField.Len()).Interface().([]gglm.Mat3)
+					WriteMat3SliceToByteBufWithAlignment(buf, &bytesWritten, arrayStride, m3Arr)
 				} else {
 					m := valField.Interface().(gglm.Mat3)
-					WriteF32SliceToByteBuf(buf, &bytesWritten, m.Data[0][:])
-					WriteF32SliceToByteBuf(buf, &bytesWritten, m.Data[1][:])
-					WriteF32SliceToByteBuf(buf, &bytesWritten, m.Data[2][:])
+					WriteMat3SliceToByteBufWithAlignment(buf, &bytesWritten, int(layout.SizeBytes(DataTypeMat3)), []gglm.Mat3{m})
 				}
 			}
 
@@ -389,8 +534,8 @@ func setStruct(fields []UniformBufferField, buf []byte, inputStruct any, maxFiel
 			if typeMatches {
 
 				if isArray {
-					m4Arr := valField.Interface().([]gglm.Mat4)
-					WriteMat4SliceToByteBufWithAlignment(buf, &bytesWritten, 16*4, m4Arr)
+					m4Arr := valField.Slice(0, valField.Len()).Interface().([]gglm.Mat4)
+					WriteMat4SliceToByteBufWithAlignment(buf, &bytesWritten, arrayStride, m4Arr)
 				} else {
 					m := valField.Interface().(gglm.Mat4)
 					WriteF32SliceToByteBuf(buf, &bytesWritten, m.Data[0][:])
@@ -408,18 +553,13 @@ func setStruct(fields []UniformBufferField, buf []byte, inputStruct any, maxFiel
 
 				if isArray {
 
-					offset := 0
 					arrSize := valField.Len()
 					fieldsToUse := fields[fieldIndex+1:]
 					for i := 0; i < arrSize; i++ {
 
-						setStructBytesWritten, setStructFieldsConsumed := setStruct(fieldsToUse, buf, valField.Index(i).Interface(), elementType.NumField(), true, offset*i)
-
-						if offset == 0 {
-							offset = setStructBytesWritten
-							padTo16Boundary(&offset)
+						_, setStructFieldsConsumed := setStruct(layout, fieldsToUse, buf, valField.Index(i).Interface(), elementType.NumField(), true, writeOffset+arrayStride*i)
 
-							bytesWritten += offset * arrSize
+						if i == 0 {
 
 							// Tracking consumed fields is needed because if we have a struct inside another struct
 							// elementType.NumField() will only give us the fields consumed by the first struct,
@@ -429,9 +569,11 @@ func setStruct(fields []UniformBufferField, buf []byte, inputStruct any, maxFiel
 						}
 					}
 
+					bytesWritten += arrayStride * arrSize
+
 				} else {
 
-					setStructBytesWritten, setStructFieldsConsumed := setStruct(fields[fieldIndex+1:], buf, valField.Interface(), valField.NumField(), true, writeOffset)
+					setStructBytesWritten, setStructFieldsConsumed := setStruct(layout, fields[fieldIndex+1:], buf, valField.Interface(), valField.NumField(), true, writeOffset)
 
 					bytesWritten += setStructBytesWritten
 					fieldIndex += setStructFieldsConsumed
@@ -588,6 +730,24 @@ func WriteVec3SliceToByteBufWithAlignment(buf []byte, startIndex *int, alignment
 	}
 }
 
+// WriteColorSliceToByteBufWithAlignment writes the linear RGB or RGBA values of the colors, with each color starting at a multiple of alignmentPerVector
+func WriteColorSliceToByteBufWithAlignment(buf []byte, startIndex *int, alignmentPerVector int, writeAlpha bool, vals []color.Color) {
+
+	assert.T(*startIndex+len(vals)*alignmentPerVector <= len(buf), "failed to write slice of color.Color with custom alignment=%d to buffer because the buffer doesn't have enough space. Start index=%d, Buffer length=%d, but needs %d bytes free", alignmentPerVector, *startIndex, len(buf), len(vals)*alignmentPerVector)
+
+	channels := 3
+	if writeAlpha {
+		channels = 4
+	}
+
+	for i := 0; i < len(vals); i++ {
+
+		writeIndex := *startIndex
+		WriteF32SliceToByteBuf(buf, &writeIndex, vals[i].Data[:channels])
+		*startIndex += alignmentPerVector
+	}
+}
+
 func WriteVec4SliceToByteBufWithAlignment(buf []byte, startIndex *int, alignmentPerVector int, vals []gglm.Vec4) {
 
 	assert.T(*startIndex+len(vals)*alignmentPerVector <= len(buf), "failed to write slice of gglm.Vec4 with custom alignment=%d to buffer because the buffer doesn't have enough space. Start index=%d, Buffer length=%d, but needs %d bytes free", alignmentPerVector, *startIndex, len(buf), len(vals)*alignmentPerVector)
@@ -634,7 +794,7 @@ func WriteMat2SliceToByteBufWithAlignment(buf []byte, startIndex *int, alignment
 		WriteVec2SliceToByteBufWithAlignment(
 			buf,
 			startIndex,
-			16,
+			alignmentPerMatrix/2,
 			[]gglm.Vec2{
 				{Data: m.Data[0]},
 				{Data: m.Data[1]},
@@ -654,7 +814,7 @@ func WriteMat3SliceToByteBufWithAlignment(buf []byte, startIndex *int, alignment
 		WriteVec3SliceToByteBufWithAlignment(
 			buf,
 			startIndex,
-			16,
+			alignmentPerMatrix/3,
 			[]gglm.Vec3{
 				{Data: m.Data[0]},
 				{Data: m.Data[1]},
@@ -675,7 +835,7 @@ func WriteMat4SliceToByteBufWithAlignment(buf []byte, startIndex *int, alignment
 		WriteVec4SliceToByteBufWithAlignment(
 			buf,
 			startIndex,
-			16,
+			alignmentPerMatrix/4,
 			[]gglm.Vec4{
 				{Data: m.Data[0]},
 				{Data: m.Data[1]},
@@ -708,10 +868,10 @@ func ReflectValueMatchesUniformBufferField(v reflect.Value, ubField *UniformBuff
 		return ok
 	case DataTypeVec3:
 		_, ok := v.Interface().(gglm.Vec3)
-		return ok
+		return ok || v.Type() == colorType
 	case DataTypeVec4:
 		_, ok := v.Interface().(gglm.Vec4)
-		return ok
+		return ok || v.Type() == colorType
 	case DataTypeMat2:
 		_, ok := v.Interface().(gglm.Mat2)
 		return ok
@@ -728,13 +888,26 @@ func ReflectValueMatchesUniformBufferField(v reflect.Value, ubField *UniformBuff
 	}
 }
 
+func (ub *UniformBuffer) Delete() {
+
+	if ub.Id == 0 {
+		return
+	}
+
+	leakcheck.Untrack(leakcheck.ResourceType_Buffer, ub.Id)
+	gl.DeleteBuffers(1, &ub.Id)
+	ub.Id = 0
+}
+
+// NewUniformBuffer creates a uniform buffer using the std140 layout, which is the only layout uniform blocks support
 func NewUniformBuffer(fields []UniformBufferFieldInput, usage BufUsage) UniformBuffer {
 
 	ub := UniformBuffer{}
 
-	ub.Size = addUniformBufferFieldsToArray(0, &ub.Fields, fields)
+	ub.Size = addUniformBufferFieldsToArray(ub.Layout, 0, &ub.Fields, fields)
 
 	gl.GenBuffers(1, &ub.Id)
+	leakcheck.Track(leakcheck.ResourceType_Buffer, ub.Id)
 	if ub.Id == 0 {
 		logging.ErrLog.Panicln("Failed to create OpenGL buffer for a uniform buffer")
 	}
@@ -745,3 +918,14 @@ func NewUniformBuffer(fields []UniformBufferFieldInput, usage BufUsage) UniformB
 
 	return ub
 }
+
+// NewUniformBufferLayout computes the field offsets using the passed layout without creating a GPU buffer.
+// Useful for data written through a UniformRingBuffer, or std430 data for shader storage buffers,
+// where WriteStruct produces the bytes to upload
+func NewUniformBufferLayout(fields []UniformBufferFieldInput, layout BlockLayout) UniformBuffer {
+
+	ub := UniformBuffer{Layout: layout}
+	ub.Size = addUniformBufferFieldsToArray(layout, 0, &ub.Fields, fields)
+
+	return ub
+}
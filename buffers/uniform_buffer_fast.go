@@ -0,0 +1,217 @@
+package buffers
+
+import (
+	"reflect"
+	"unsafe"
+
+	"github.com/bloeys/nmage/logging"
+)
+
+var (
+	// isLittleEndian is needed because copy plans copy memory as is, while buffers must be little endian
+	isLittleEndian = func() bool {
+		x := uint16(1)
+		return *(*byte)(unsafe.Pointer(&x)) == 1
+	}()
+)
+
+// copyOp copies size bytes from an offset in a Go struct to an offset in a buffer
+type copyOp struct {
+	src  uintptr
+	dst  int
+	size int
+}
+
+// copyPlan is a list of memory copies that writes a struct type into a buffer with the same result as setStruct,
+// but without per field reflection. Plans are built once per struct type
+type copyPlan struct {
+	structType reflect.Type
+	ops        []copyOp
+	// ok is false if the struct type can't be copied directly (e.g. it has pointer fields), in which case reflection is used
+	ok bool
+}
+
+// writeStructFast writes inputStruct using a cached copy plan. inputStruct must be a pointer to a struct.
+// Returns false if the fast path can't be used, in which case nothing is written
+func (ub *UniformBuffer) writeStructFast(buf []byte, inputStruct any) bool {
+
+	if !isLittleEndian {
+		return false
+	}
+
+	t := reflect.TypeOf(inputStruct)
+	if t == nil || t.Kind() != reflect.Pointer || t.Elem().Kind() != reflect.Struct {
+		return false
+	}
+
+	t = t.Elem()
+	if ub.copyPlan == nil || ub.copyPlan.structType != t {
+		ub.copyPlan = newCopyPlan(ub.Layout, ub.Fields, t)
+	}
+
+	if !ub.copyPlan.ok {
+		return false
+	}
+
+	src := reflect.ValueOf(inputStruct).UnsafePointer()
+	if src == nil {
+		logging.ErrLog.Panicf("UniformBuffer.SetStruct called with a nil pointer of type %s\n", t.String())
+	}
+
+	ops := ub.copyPlan.ops
+	for i := 0; i < len(ops); i++ {
+		op := &ops[i]
+		copy(buf[op.dst:op.dst+op.size], unsafe.Slice((*byte)(unsafe.Add(src, op.src)), op.size))
+	}
+
+	return true
+}
+
+func newCopyPlan(layout BlockLayout, fields []UniformBufferField, t reflect.Type) *copyPlan {
+
+	plan := &copyPlan{
+		structType: t,
+		ops:        make([]copyOp, 0, len(fields)),
+	}
+
+	_, plan.ok = planStruct(layout, fields, t, 0, 0, &plan.ops)
+	if !plan.ok {
+		plan.ops = nil
+		return plan
+	}
+
+	// Merge copies that are next to each other in both the struct and the buffer (e.g. float fields that follow each other)
+	merged := plan.ops[:0]
+	for i := 0; i < len(plan.ops); i++ {
+
+		op := plan.ops[i]
+		if len(merged) > 0 {
+
+			last := &merged[len(merged)-1]
+			if last.src+uintptr(last.size) == op.src && last.dst+last.size == op.dst {
+				last.size += op.size
+				continue
+			}
+		}
+
+		merged = append(merged, op)
+	}
+	plan.ops = merged
+
+	return plan
+}
+
+// planStruct adds the copies needed for struct type t, where srcBase is the offset of the struct in the input and
+// dstOffset is added to field offsets, which is non-zero for elements of struct arrays.
+// Walks fields the same way setStruct does, and panics on the same type mismatches
+func planStruct(layout BlockLayout, fields []UniformBufferField, t reflect.Type, srcBase uintptr, dstOffset int, ops *[]copyOp) (fieldsConsumed int, ok bool) {
+
+	structFieldIndex := 0
+	for fieldIndex := 0; fieldIndex < len(fields) && structFieldIndex < t.NumField(); fieldIndex++ {
+
+		ubField := &fields[fieldIndex]
+		sf := t.Field(structFieldIndex)
+
+		fieldsConsumed++
+		structFieldIndex++
+
+		fieldType := sf.Type
+		count := 1
+		if fieldType.Kind() == reflect.Array {
+			count = fieldType.Len()
+			fieldType = fieldType.Elem()
+		}
+
+		// Pointers can't be followed without knowing the value, and slices can change length
+		if fieldType.Kind() == reflect.Pointer || fieldType.Kind() == reflect.Slice {
+			return fieldsConsumed, false
+		}
+
+		if count > 1 && count != int(ubField.Count) {
+			logging.ErrLog.Panicf("ubo field of id=%d is an array/slice field of length=%d but got input of length=%d\n", ubField.Id, ubField.Count, count)
+		}
+
+		src := srcBase + sf.Offset
+		dst := dstOffset + int(ubField.AlignedOffset)
+		elemSize := fieldType.Size()
+		arrayStride := int(ubField.ArrayStride)
+
+		typeMatches := false
+		switch ubField.Type {
+
+		case DataTypeUint32:
+			typeMatches = fieldType.Kind() == reflect.Uint32
+		case DataTypeInt32:
+			typeMatches = fieldType.Kind() == reflect.Int32
+		case DataTypeFloat32:
+			typeMatches = fieldType.Kind() == reflect.Float32
+
+		case DataTypeVec2:
+			typeMatches = fieldType == vec2Type
+		case DataTypeVec3:
+			typeMatches = fieldType == vec3Type || fieldType == colorType
+		case DataTypeVec4:
+			typeMatches = fieldType == vec4Type || fieldType == colorType
+
+		case DataTypeMat2:
+			typeMatches = fieldType == mat2Type
+		case DataTypeMat3:
+			typeMatches = fieldType == mat3Type
+		case DataTypeMat4:
+			typeMatches = fieldType == mat4Type
+
+		case DataTypeStruct:
+
+			typeMatches = fieldType.Kind() == reflect.Struct
+			if !typeMatches {
+				break
+			}
+
+			subfieldsConsumed := 0
+			for i := 0; i < count; i++ {
+
+				subfieldsConsumed, ok = planStruct(layout, fields[fieldIndex+1:], fieldType, src+uintptr(i)*elemSize, dstOffset+i*arrayStride, ops)
+				if !ok {
+					return fieldsConsumed, false
+				}
+			}
+
+			fieldIndex += subfieldsConsumed
+			fieldsConsumed += subfieldsConsumed
+			continue
+		}
+
+		if !typeMatches {
+			logging.ErrLog.Panicf("Struct field ordering and types must match uniform buffer fields, but at field index %d got UniformBufferField=%v but a struct field of type %s\n", fieldIndex, *ubField, sf.Type.String())
+		}
+
+		for i := 0; i < count; i++ {
+
+			elemSrc := src + uintptr(i)*elemSize
+			elemDst := dst + i*arrayStride
+
+			// Matrices are copied per column since columns may be padded in the buffer
+			switch ubField.Type {
+			case DataTypeMat2:
+				addColumnCopies(ops, elemSrc, elemDst, 2, int(layout.MatrixColumnStride(DataTypeMat2)))
+			case DataTypeMat3:
+				addColumnCopies(ops, elemSrc, elemDst, 3, int(layout.MatrixColumnStride(DataTypeMat3)))
+			case DataTypeMat4:
+				addColumnCopies(ops, elemSrc, elemDst, 4, int(layout.MatrixColumnStride(DataTypeMat4)))
+			default:
+				// Colors in vec3 fields only copy RGB, which are the first 12 bytes of the color
+				*ops = append(*ops, copyOp{src: elemSrc, dst: elemDst, size: int(ubField.Type.Size())})
+			}
+		}
+	}
+
+	return fieldsConsumed, true
+}
+
+func addColumnCopies(ops *[]copyOp, src uintptr, dst, columns, columnStride int) {
+
+	columnSize := columns * 4
+	for c := 0; c < columns; c++ {
+		*ops = append(*ops, copyOp{src: src + uintptr(c*columnSize), dst: dst + c*columnStride, size: columnSize})
+	}
+}
@@ -0,0 +1,184 @@
+package buffers
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/logging"
+)
+
+var (
+	vec2Type = reflect.TypeOf(gglm.Vec2{})
+	vec3Type = reflect.TypeOf(gglm.Vec3{})
+	vec4Type = reflect.TypeOf(gglm.Vec4{})
+	mat2Type = reflect.TypeOf(gglm.Mat2{})
+	mat3Type = reflect.TypeOf(gglm.Mat3{})
+	mat4Type = reflect.TypeOf(gglm.Mat4{})
+)
+
+// NewUniformBufferFor creates a std140 uniform buffer whose fields are derived from the struct T,
+// which can then be written with SetStruct(T{...}). See UniformBufferFieldsFor for how fields are derived
+func NewUniformBufferFor[T any](usage BufUsage) UniformBuffer {
+	return NewUniformBuffer(UniformBufferFieldsFor[T](), usage)
+}
+
+// NewUniformBufferLayoutFor is like NewUniformBufferLayout but derives the fields from the struct T
+func NewUniformBufferLayoutFor[T any](layout BlockLayout) UniformBuffer {
+	return NewUniformBufferLayout(UniformBufferFieldsFor[T](), layout)
+}
+
+// UniformBufferFieldsFor derives uniform buffer fields from the struct T, where struct fields must be in the same order as the shader block.
+//
+// Supported field types are uint32, int32, float32, gglm vectors and matrices, color.Color, structs of supported types,
+// and fixed size arrays of all of these. Pointers are followed. All fields must be exported.
+//
+// Field ids are assigned in declaration order starting at zero (nested fields included), for use with UniformBuffer.SetX functions.
+//
+// Fields can have a `ubo:"..."` tag with comma separated options:
+//   - id=N: Use N as the field id instead of the automatic one
+//   - type=vec3|vec4: The shader type of a color.Color field. Colors are vec4 by default, and type=vec3 only writes RGB
+func UniformBufferFieldsFor[T any]() []UniformBufferFieldInput {
+
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Struct {
+		logging.ErrLog.Panicf("UniformBufferFieldsFor called with a type that is not a struct. Type=%s\n", t.String())
+	}
+
+	nextId := uint16(0)
+	return uniformBufferFieldsForStruct(t, &nextId)
+}
+
+func uniformBufferFieldsForStruct(t reflect.Type, nextId *uint16) []UniformBufferFieldInput {
+
+	fields := make([]UniformBufferFieldInput, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			logging.ErrLog.Panicf("uniform buffer struct fields must be exported, but field '%s' of struct '%s' is not\n", sf.Name, t.String())
+		}
+
+		f := UniformBufferFieldInput{Id: *nextId}
+		*nextId++
+
+		tag := parseUboTag(t, &sf)
+		if tag.hasId {
+			f.Id = tag.id
+		}
+
+		fieldType := sf.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+
+		switch fieldType.Kind() {
+		case reflect.Array:
+			f.Count = uint16(fieldType.Len())
+			fieldType = fieldType.Elem()
+		case reflect.Slice:
+			logging.ErrLog.Panicf("uniform buffer struct fields can't be slices because their length isn't known, use an array instead. Field '%s' of struct '%s'\n", sf.Name, t.String())
+		}
+
+		if fieldType.Kind() == reflect.Struct && !isUboValueType(fieldType) {
+			f.Type = DataTypeStruct
+			f.Subfields = uniformBufferFieldsForStruct(fieldType, nextId)
+			fields = append(fields, f)
+			continue
+		}
+
+		f.Type = elementTypeForReflectType(fieldType, tag.typeName)
+		if f.Type == DataTypeUnknown {
+			logging.ErrLog.Panicf("unsupported uniform buffer field type '%s' (tag type='%s') on field '%s' of struct '%s'\n", fieldType.String(), tag.typeName, sf.Name, t.String())
+		}
+
+		fields = append(fields, f)
+	}
+
+	return fields
+}
+
+// isUboValueType returns true for struct types that map to a single shader type (e.g. gglm.Vec3) rather than a shader struct
+func isUboValueType(t reflect.Type) bool {
+	return t == vec2Type || t == vec3Type || t == vec4Type || t == mat2Type || t == mat3Type || t == mat4Type || t == colorType
+}
+
+func elementTypeForReflectType(t reflect.Type, tagTypeName string) ElementType {
+
+	switch t {
+	case vec2Type:
+		return DataTypeVec2
+	case vec3Type:
+		return DataTypeVec3
+	case vec4Type:
+		return DataTypeVec4
+	case mat2Type:
+		return DataTypeMat2
+	case mat3Type:
+		return DataTypeMat3
+	case mat4Type:
+		return DataTypeMat4
+
+	case colorType:
+		switch tagTypeName {
+		case "", "vec4":
+			return DataTypeVec4
+		case "vec3":
+			return DataTypeVec3
+		default:
+			return DataTypeUnknown
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Uint32:
+		return DataTypeUint32
+	case reflect.Int32:
+		return DataTypeInt32
+	case reflect.Float32:
+		return DataTypeFloat32
+	default:
+		return DataTypeUnknown
+	}
+}
+
+type uboTag struct {
+	hasId    bool
+	id       uint16
+	typeName string
+}
+
+func parseUboTag(structType reflect.Type, sf *reflect.StructField) (tag uboTag) {
+
+	tagStr, ok := sf.Tag.Lookup("ubo")
+	if !ok {
+		return tag
+	}
+
+	for _, opt := range strings.Split(tagStr, ",") {
+
+		key, val, _ := strings.Cut(strings.TrimSpace(opt), "=")
+		switch key {
+
+		case "id":
+			id, err := strconv.ParseUint(val, 10, 16)
+			if err != nil {
+				logging.ErrLog.Panicf("invalid id in ubo tag '%s' on field '%s' of struct '%s'. Err: %v\n", tagStr, sf.Name, structType.String(), err)
+			}
+
+			tag.hasId = true
+			tag.id = uint16(id)
+
+		case "type":
+			tag.typeName = val
+
+		case "":
+
+		default:
+			logging.ErrLog.Panicf("unknown option '%s' in ubo tag '%s' on field '%s' of struct '%s'\n", key, tagStr, sf.Name, structType.String())
+		}
+	}
+
+	return tag
+}
@@ -0,0 +1,190 @@
+package buffers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bloeys/nmage/materials"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// uboLeaf is a non-struct field of a uniform buffer at its final offset, with struct arrays expanded per element
+type uboLeaf struct {
+	Id          uint16
+	Offset      uint32
+	Type        ElementType
+	Count       uint16
+	ArrayStride uint16
+	matched     bool
+}
+
+// shaderBlockUniform is a uniform inside a uniform block, as reported by the shader program
+type shaderBlockUniform struct {
+	Name         string
+	Offset       int32
+	GlType       int32
+	Size         int32
+	ArrayStride  int32
+	MatrixStride int32
+}
+
+// ValidateAgainst compares the computed offsets, types and sizes of the uniform buffer fields with
+// the uniform block blockName as laid out by the shader compiler of the material.
+//
+// Misaligned fields don't cause GL errors but produce wrong values in the shader, so call this at startup
+// to catch them. Returns an error describing every mismatch found, or nil if the layouts match
+func (ub *UniformBuffer) ValidateAgainst(mat *materials.Material, blockName string) error {
+
+	progId := mat.ShaderProg.Id
+	blockIndex := gl.GetUniformBlockIndex(progId, gl.Str(blockName+"\x00"))
+	if blockIndex == gl.INVALID_INDEX {
+		return fmt.Errorf("failed to validate uniform buffer because uniform block '%s' wasn't found in material '%s'", blockName, mat.Name)
+	}
+
+	var blockDataSize int32
+	gl.GetActiveUniformBlockiv(progId, blockIndex, gl.UNIFORM_BLOCK_DATA_SIZE, &blockDataSize)
+
+	shaderUniforms := getShaderBlockUniforms(progId, blockIndex)
+
+	leaves := make([]uboLeaf, 0, len(ub.Fields))
+	collectUboLeaves(ub.Fields, 0, &leaves)
+
+	leafIndexByOffset := make(map[uint32]int, len(leaves))
+	for i := 0; i < len(leaves); i++ {
+		leafIndexByOffset[leaves[i].Offset] = i
+	}
+
+	mismatches := make([]string, 0)
+	if roundUpToMultiple(ub.Size, 16) != roundUpToMultiple(uint32(blockDataSize), 16) {
+		mismatches = append(mismatches, fmt.Sprintf("buffer size is %d bytes but the shader block is %d bytes", ub.Size, blockDataSize))
+	}
+
+	for i := 0; i < len(shaderUniforms); i++ {
+
+		u := &shaderUniforms[i]
+
+		leafIndex, ok := leafIndexByOffset[uint32(u.Offset)]
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("shader uniform '%s' is at offset %d but no buffer field is at that offset", u.Name, u.Offset))
+			continue
+		}
+
+		leaf := &leaves[leafIndex]
+		leaf.matched = true
+
+		if leaf.Type.GlUniformType() != uint32(u.GlType) {
+			mismatches = append(mismatches, fmt.Sprintf("shader uniform '%s' at offset %d has GL type 0x%X but buffer field id=%d has type %s", u.Name, u.Offset, u.GlType, leaf.Id, leaf.Type.String()))
+			continue
+		}
+
+		count := int32(max(leaf.Count, 1))
+		if count != u.Size {
+			mismatches = append(mismatches, fmt.Sprintf("shader uniform '%s' at offset %d is an array of %d elements but buffer field id=%d has %d", u.Name, u.Offset, u.Size, leaf.Id, count))
+			continue
+		}
+
+		if count > 1 && int32(leaf.ArrayStride) != u.ArrayStride {
+			mismatches = append(mismatches, fmt.Sprintf("shader uniform '%s' at offset %d has an array stride of %d but buffer field id=%d has %d", u.Name, u.Offset, u.ArrayStride, leaf.Id, leaf.ArrayStride))
+		}
+
+		if u.MatrixStride != 0 && int32(ub.Layout.MatrixColumnStride(leaf.Type)) != u.MatrixStride {
+			mismatches = append(mismatches, fmt.Sprintf("shader uniform '%s' at offset %d has a matrix stride of %d but buffer field id=%d has %d", u.Name, u.Offset, u.MatrixStride, leaf.Id, ub.Layout.MatrixColumnStride(leaf.Type)))
+		}
+	}
+
+	// All members of std140 blocks are active even if unused, so every field should have been matched
+	for i := 0; i < len(leaves); i++ {
+
+		leaf := &leaves[i]
+		if !leaf.matched {
+			mismatches = append(mismatches, fmt.Sprintf("buffer field id=%d of type %s at offset %d has no shader uniform at that offset", leaf.Id, leaf.Type.String(), leaf.Offset))
+		}
+	}
+
+	if len(mismatches) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("uniform buffer doesn't match uniform block '%s' of material '%s':\n\t%s", blockName, mat.Name, strings.Join(mismatches, "\n\t"))
+}
+
+// collectUboLeaves adds the non-struct fields of the flattened fields list, with struct arrays expanded into one set of fields per element
+func collectUboLeaves(fields []UniformBufferField, baseOffset uint32, leaves *[]uboLeaf) {
+
+	for i := 0; i < len(fields); i++ {
+
+		f := &fields[i]
+		if f.Type != DataTypeStruct {
+			*leaves = append(*leaves, uboLeaf{
+				Id:          f.Id,
+				Offset:      baseOffset + uint32(f.AlignedOffset),
+				Type:        f.Type,
+				Count:       f.Count,
+				ArrayStride: f.ArrayStride,
+			})
+			continue
+		}
+
+		// Subfield offsets are relative to the start of the buffer and include the offset of the first element,
+		// so each element only adds its distance from the first one
+		for elem := uint32(0); elem < uint32(max(f.Count, 1)); elem++ {
+			collectUboLeaves(f.Subfields, baseOffset+elem*uint32(f.ArrayStride), leaves)
+		}
+
+		// Subfields are also in the flattened list right after the struct, and were handled above
+		i += len(f.Subfields)
+	}
+}
+
+func getShaderBlockUniforms(progId, blockIndex uint32) []shaderBlockUniform {
+
+	var activeUniformCount int32
+	gl.GetActiveUniformBlockiv(progId, blockIndex, gl.UNIFORM_BLOCK_ACTIVE_UNIFORMS, &activeUniformCount)
+	if activeUniformCount <= 0 {
+		return nil
+	}
+
+	indices := make([]int32, activeUniformCount)
+	gl.GetActiveUniformBlockiv(progId, blockIndex, gl.UNIFORM_BLOCK_ACTIVE_UNIFORM_INDICES, &indices[0])
+
+	uindices := make([]uint32, activeUniformCount)
+	for i := 0; i < len(indices); i++ {
+		uindices[i] = uint32(indices[i])
+	}
+
+	getParam := func(pname uint32) []int32 {
+		params := make([]int32, activeUniformCount)
+		gl.GetActiveUniformsiv(progId, activeUniformCount, &uindices[0], pname, &params[0])
+		return params
+	}
+
+	offsets := getParam(gl.UNIFORM_OFFSET)
+	types := getParam(gl.UNIFORM_TYPE)
+	sizes := getParam(gl.UNIFORM_SIZE)
+	arrayStrides := getParam(gl.UNIFORM_ARRAY_STRIDE)
+	matrixStrides := getParam(gl.UNIFORM_MATRIX_STRIDE)
+
+	nameBuf := make([]uint8, 256)
+	uniforms := make([]shaderBlockUniform, activeUniformCount)
+	for i := 0; i < len(uniforms); i++ {
+
+		var nameLen int32
+		gl.GetActiveUniformName(progId, uindices[i], int32(len(nameBuf)), &nameLen, &nameBuf[0])
+
+		uniforms[i] = shaderBlockUniform{
+			Name:         string(nameBuf[:nameLen]),
+			Offset:       offsets[i],
+			GlType:       types[i],
+			Size:         sizes[i],
+			ArrayStride:  arrayStrides[i],
+			MatrixStride: matrixStrides[i],
+		}
+	}
+
+	sort.Slice(uniforms, func(i, j int) bool {
+		return uniforms[i].Offset < uniforms[j].Offset
+	})
+
+	return uniforms
+}
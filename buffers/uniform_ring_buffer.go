@@ -0,0 +1,314 @@
+package buffers
+
+import (
+	"time"
+	"unsafe"
+
+	"github.com/bloeys/nmage/assert"
+	"github.com/bloeys/nmage/leakcheck"
+	"github.com/bloeys/nmage/logging"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+const (
+	DefaultUniformRingFramesInFlight = 3
+
+	// uniformRingFenceTimeout is long enough that hitting it means something is very wrong with the GPU
+	uniformRingFenceTimeout = 1 * time.Second
+)
+
+// UniformRingRange is a sub-allocation within a UniformRingBuffer, which is only valid for the frame it was allocated in
+type UniformRingRange struct {
+	// BufferId is the buffer the range is in, which changes when the ring grows
+	BufferId uint32
+	Offset   uint32
+	Size     uint32
+}
+
+// retiredUniformBuffer is a buffer replaced by a bigger one when a ring grew, which is deleted
+// once the frames that used it are done on the GPU
+type retiredUniformBuffer struct {
+	id         uint32
+	framesLeft uint32
+}
+
+// UniformRingBuffer is one large uniform buffer split into one region per frame in flight,
+// where each frame sub-allocates ranges from its region and binds them with glBindBufferRange.
+//
+// Rewriting the same uniform buffer every frame (e.g. with BufferSubData) can stall the CPU until the GPU
+// is done with the previous frame's data. With a ring, each frame writes to a region the GPU stopped using
+// FramesInFlight frames ago, so writes are unsynchronized and rarely wait on the GPU.
+// A fence is placed at the end of each frame so that if the GPU falls behind, BeginFrame waits instead of overwriting data in use.
+//
+// Usage per frame: BeginFrame once, then SetStruct/Write for each block, then BindRange the returned ranges, then EndFrame after the draws.
+// Data must be written every frame it is used, because old ranges get overwritten when the ring wraps around.
+//
+// When a frame needs more than FrameSize the ring grows into a new buffer with bigger frames. Ranges given out before that stay
+// valid for the frame, since the old buffer is only deleted once the GPU is done with it
+type UniformRingBuffer struct {
+	Id uint32
+	// Size is the allocated memory in bytes on the GPU, which is FrameSize*FramesInFlight
+	Size uint32
+	// FrameSize is the memory in bytes available to each frame
+	FrameSize      uint32
+	FramesInFlight uint32
+
+	// offsetAlignment is GL_UNIFORM_BUFFER_OFFSET_ALIGNMENT, which all bound ranges must start at a multiple of
+	offsetAlignment uint32
+	frameIndex      uint32
+	// head is the offset of the next allocation relative to the start of the current frame's region
+	head uint32
+
+	scratch []byte
+	// fences has one fence per frame region, signaled when the GPU is done with the region
+	fences []Fence
+
+	// regionsWritten is true for the regions written since their last BeginFrame, so a region whose frame
+	// never called EndFrame is known to have no fence guarding it
+	regionsWritten []bool
+
+	// isRegionSafe is true when the GPU is known to be done with the current frame's region, which is what
+	// allows writes to skip the driver's synchronization
+	isRegionSafe bool
+
+	retired []retiredUniformBuffer
+}
+
+func (rb *UniformRingBuffer) Bind() {
+	gl.BindBuffer(gl.UNIFORM_BUFFER, rb.Id)
+}
+
+func (rb *UniformRingBuffer) UnBind() {
+	gl.BindBuffer(gl.UNIFORM_BUFFER, 0)
+}
+
+// BeginFrame moves to the next frame's region, and must be called once at the start of every frame.
+// If the GPU is still using that region this waits for it
+func (rb *UniformRingBuffer) BeginFrame() {
+
+	rb.frameIndex = (rb.frameIndex + 1) % rb.FramesInFlight
+	rb.head = 0
+
+	// A region written without a fence after it (EndFrame wasn't called) might still be in use
+	rb.isRegionSafe = !rb.regionsWritten[rb.frameIndex]
+	rb.regionsWritten[rb.frameIndex] = false
+
+	fence := &rb.fences[rb.frameIndex]
+	if fence.IsValid() {
+
+		rb.isRegionSafe = true
+		if fence.ClientWait(uniformRingFenceTimeout) != FenceWaitResult_Signaled {
+			rb.isRegionSafe = false
+			logging.ErrLog.Printf("uniform ring buffer (id=%d) timed out waiting for the GPU to finish with frame region %d\n", rb.Id, rb.frameIndex)
+		}
+
+		fence.Delete()
+	}
+
+	rb.deleteRetiredBuffers()
+}
+
+// deleteRetiredBuffers deletes the buffers replaced by growing once FramesInFlight frames started after they were replaced,
+// at which point BeginFrame waited for the fence of the frame that replaced them
+func (rb *UniformRingBuffer) deleteRetiredBuffers() {
+
+	kept := rb.retired[:0]
+	for i := 0; i < len(rb.retired); i++ {
+
+		b := &rb.retired[i]
+		b.framesLeft--
+		if b.framesLeft > 0 {
+			kept = append(kept, *b)
+			continue
+		}
+
+		leakcheck.Untrack(leakcheck.ResourceType_Buffer, b.id)
+		gl.DeleteBuffers(1, &b.id)
+	}
+
+	rb.retired = kept
+}
+
+// EndFrame places a fence after all the draws of this frame, and must be called once after the last draw that uses this frame's ranges
+func (rb *UniformRingBuffer) EndFrame() {
+	rb.fences[rb.frameIndex].Delete()
+	rb.fences[rb.frameIndex] = NewFence()
+}
+
+// Alloc reserves size bytes in the current frame's region, aligned so it can be bound with BindRange
+func (rb *UniformRingBuffer) Alloc(size uint32) UniformRingRange {
+
+	alignmentError := rb.head % rb.offsetAlignment
+	if alignmentError != 0 {
+		rb.head += rb.offsetAlignment - alignmentError
+	}
+
+	if rb.head+size > rb.FrameSize {
+		rb.grow(size)
+	}
+
+	r := UniformRingRange{
+		BufferId: rb.Id,
+		Offset:   rb.frameIndex*rb.FrameSize + rb.head,
+		Size:     size,
+	}
+	rb.head += size
+
+	return r
+}
+
+// grow replaces the buffer with one whose frames are at least twice as big and fit minFrameSize, and leaves it bound.
+// The rest of the frame is written to the new buffer, while the old one is kept until the GPU is done with it
+func (rb *UniformRingBuffer) grow(minFrameSize uint32) {
+
+	newFrameSize := rb.FrameSize * 2
+	for newFrameSize < minFrameSize {
+		newFrameSize *= 2
+	}
+
+	logging.WarnLog.Printf("uniform ring buffer (id=%d) is out of space for this frame, so its frame size grows from %d to %d bytes\n", rb.Id, rb.FrameSize, newFrameSize)
+
+	rb.retired = append(rb.retired, retiredUniformBuffer{id: rb.Id, framesLeft: rb.FramesInFlight})
+
+	rb.FrameSize = newFrameSize
+	rb.Size = newFrameSize * rb.FramesInFlight
+	rb.Id = newUniformRingGlBuffer(rb.Size)
+	rb.head = 0
+
+	// The GPU has never used the new buffer
+	rb.isRegionSafe = true
+	clear(rb.regionsWritten)
+}
+
+// Write allocates a range and uploads data to it. The ring buffer must be bound
+func (rb *UniformRingBuffer) Write(data []byte) UniformRingRange {
+
+	r := rb.Alloc(uint32(len(data)))
+	if len(data) == 0 {
+		return r
+	}
+
+	// Unsynchronized when BeginFrame made sure the GPU is done with this frame's region, otherwise the driver
+	// waits for the GPU if needed, which is slower but never overwrites data being read
+	mapFlags := uint32(gl.MAP_WRITE_BIT | gl.MAP_INVALIDATE_RANGE_BIT)
+	if rb.isRegionSafe {
+		mapFlags |= gl.MAP_UNSYNCHRONIZED_BIT
+	}
+
+	rb.regionsWritten[rb.frameIndex] = true
+	ptr := gl.MapBufferRange(gl.UNIFORM_BUFFER, int(r.Offset), len(data), mapFlags)
+	if ptr == nil {
+		logging.ErrLog.Panicf("failed to map uniform ring buffer range. Offset=%d, Size=%d\n", r.Offset, len(data))
+	}
+
+	copy(unsafe.Slice((*byte)(ptr), len(data)), data)
+	gl.UnmapBuffer(gl.UNIFORM_BUFFER)
+
+	return r
+}
+
+// SetStruct writes inputStruct using the fields of layout (see UniformBuffer.SetStruct), then uploads it to a new range.
+// The ring buffer must be bound
+func (rb *UniformRingBuffer) SetStruct(layout *UniformBuffer, inputStruct any) UniformRingRange {
+
+	if uint32(len(rb.scratch)) < layout.Size {
+		rb.scratch = make([]byte, layout.Size)
+	}
+
+	buf := rb.scratch[:layout.Size]
+	layout.WriteStruct(buf, inputStruct)
+
+	return rb.Write(buf)
+}
+
+// OffsetAlignment returns the alignment all ranges are allocated at. Data packed at multiples of this
+// within one range can be bound as separate sub-ranges
+func (rb *UniformRingBuffer) OffsetAlignment() uint32 {
+	return rb.offsetAlignment
+}
+
+// BindRange binds a range to a uniform block binding point, which is how shaders see the data
+func (rb *UniformRingBuffer) BindRange(bindPointIndex uint32, r UniformRingRange) {
+	gl.BindBufferRange(gl.UNIFORM_BUFFER, bindPointIndex, r.BufferId, int(r.Offset), int(r.Size))
+}
+
+func (rb *UniformRingBuffer) Delete() {
+
+	for i := 0; i < len(rb.fences); i++ {
+		rb.fences[i].Delete()
+	}
+
+	for i := 0; i < len(rb.retired); i++ {
+		leakcheck.Untrack(leakcheck.ResourceType_Buffer, rb.retired[i].id)
+		gl.DeleteBuffers(1, &rb.retired[i].id)
+	}
+	rb.retired = nil
+
+	leakcheck.Untrack(leakcheck.ResourceType_Buffer, rb.Id)
+	gl.DeleteBuffers(1, &rb.Id)
+	rb.Id = 0
+}
+
+// Recreate replaces the buffer with a new one of the same size after the GL context that owned it was lost.
+// The objects of the lost context are dropped without deleting them, since they are gone with it
+func (rb *UniformRingBuffer) Recreate() {
+
+	clear(rb.fences)
+	clear(rb.regionsWritten)
+	rb.retired = nil
+	rb.frameIndex = 0
+	rb.head = 0
+	rb.isRegionSafe = false
+
+	rb.Id = newUniformRingGlBuffer(rb.Size)
+	rb.UnBind()
+}
+
+// NewUniformRingBuffer creates a ring with frameSize bytes per frame. The frame size is rounded up to the
+// uniform offset alignment so every frame's region starts aligned
+func NewUniformRingBuffer(frameSize, framesInFlight uint32) UniformRingBuffer {
+
+	assert.T(framesInFlight > 0, "uniform ring buffer must have at least one frame in flight")
+
+	var offsetAlignment int32
+	gl.GetIntegerv(gl.UNIFORM_BUFFER_OFFSET_ALIGNMENT, &offsetAlignment)
+	if offsetAlignment <= 0 {
+		offsetAlignment = 256
+	}
+
+	rb := UniformRingBuffer{
+		FramesInFlight:  framesInFlight,
+		offsetAlignment: uint32(offsetAlignment),
+		fences:          make([]Fence, framesInFlight),
+		regionsWritten:  make([]bool, framesInFlight),
+	}
+
+	alignmentError := frameSize % rb.offsetAlignment
+	if alignmentError != 0 {
+		frameSize += rb.offsetAlignment - alignmentError
+	}
+
+	rb.FrameSize = frameSize
+	rb.Size = frameSize * framesInFlight
+
+	rb.Id = newUniformRingGlBuffer(rb.Size)
+	rb.UnBind()
+
+	return rb
+}
+
+// newUniformRingGlBuffer creates a uniform buffer of size bytes and leaves it bound
+func newUniformRingGlBuffer(size uint32) uint32 {
+
+	var id uint32
+	gl.GenBuffers(1, &id)
+	leakcheck.Track(leakcheck.ResourceType_Buffer, id)
+	if id == 0 {
+		logging.ErrLog.Panicln("Failed to create OpenGL buffer for a uniform ring buffer")
+	}
+
+	gl.BindBuffer(gl.UNIFORM_BUFFER, id)
+	gl.BufferData(gl.UNIFORM_BUFFER, int(size), gl.Ptr(nil), gl.STREAM_DRAW)
+
+	return id
+}
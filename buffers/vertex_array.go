@@ -1,6 +1,8 @@
 package buffers
 
 import (
+	"github.com/bloeys/nmage/assert"
+	"github.com/bloeys/nmage/leakcheck"
 	"github.com/bloeys/nmage/logging"
 	"github.com/go-gl/gl/v4.1-core/gl"
 )
@@ -9,6 +11,16 @@ type VertexArray struct {
 	Id          uint32
 	Vbos        []VertexBuffer
 	IndexBuffer IndexBuffer
+
+	// Layout is the shared layout of the vertex buffers added with AddVertexBuffersWithLayout, and is nil otherwise
+	Layout *VertexLayout
+
+	// layoutVboIndex is the index in Vbos of the buffer of the first stream of Layout
+	layoutVboIndex int
+
+	// InstanceLayout and InstanceVboId are the layout and buffer set by SetInstanceBufferWithLayout, and are nil/zero otherwise
+	InstanceLayout *VertexLayout
+	InstanceVboId  uint32
 }
 
 func (va *VertexArray) Bind() {
@@ -25,6 +37,7 @@ func (va *VertexArray) AddVertexBuffer(vbo VertexBuffer) {
 
 	va.Bind()
 	vbo.Bind()
+	va.Vbos = append(va.Vbos, vbo)
 
 	for i := 0; i < len(vbo.layout); i++ {
 
@@ -35,17 +48,134 @@ func (va *VertexArray) AddVertexBuffer(vbo VertexBuffer) {
 	}
 }
 
+// AddVertexBufferWithLayout adds a vertex buffer whose vertices are in the passed single stream layout, with each attribute read
+// from its location in the layout. The layout is kept in Layout and must not be changed while the vertex array uses it
+func (va *VertexArray) AddVertexBufferWithLayout(vbo VertexBuffer, layout *VertexLayout) {
+	va.AddVertexBuffersWithLayout([]VertexBuffer{vbo}, layout)
+}
+
+// AddVertexBuffersWithLayout adds one vertex buffer per stream of the layout, where vbos[i] has the attributes of stream i,
+// with each attribute read from its location in the layout. The buffers of each stream can be got with StreamBuffer.
+// The layout is kept in Layout and must not be changed while the vertex array uses it
+func (va *VertexArray) AddVertexBuffersWithLayout(vbos []VertexBuffer, layout *VertexLayout) {
+
+	assert.T(uint32(len(vbos)) == layout.StreamCount(), "Vertex layout '%s' has %d streams, but %d vertex buffers were passed", layout.Name, layout.StreamCount(), len(vbos))
+
+	va.Bind()
+	va.Layout = layout
+	va.layoutVboIndex = len(va.Vbos)
+
+	for i := 0; i < len(vbos); i++ {
+		vbos[i].SetLayout(layout.StreamElements(uint32(i))...)
+		va.Vbos = append(va.Vbos, vbos[i])
+	}
+
+	for i := 0; i < len(layout.Attribs); i++ {
+
+		a := &layout.Attribs[i]
+		stride := layout.Strides[a.Stream]
+
+		// The buffer bound while setting the pointer is the one the attribute reads from
+		vbos[a.Stream].Bind()
+		gl.EnableVertexAttribArray(a.Loc)
+
+		// Integer attributes are read as integers, since VertexAttribPointer would convert them to floats
+		if a.ElementType == DataTypeInt32 || a.ElementType == DataTypeUint32 {
+			gl.VertexAttribIPointerWithOffset(a.Loc, a.ElementType.CompCount(), a.ElementType.GLType(), stride, uintptr(a.Offset))
+		} else {
+			gl.VertexAttribPointerWithOffset(a.Loc, a.ElementType.CompCount(), a.ElementType.GLType(), false, stride, uintptr(a.Offset))
+		}
+	}
+}
+
+// SetInstanceBufferWithLayout makes the attributes of the single stream layout be read from vbo once per instance instead of once per vertex
+// (e.g. per instance model matrices), replacing the previous instance buffer. Matrix attributes take one location per column starting at their Loc.
+//
+// The buffer isn't owned by the vertex array, so it isn't added to Vbos and isn't deleted by Delete. This allows one instance buffer to be
+// shared by many vertex arrays, as long as it outlives them
+func (va *VertexArray) SetInstanceBufferWithLayout(vbo *VertexBuffer, layout *VertexLayout) {
+
+	assert.T(layout.StreamCount() == 1, "Instance vertex layout '%s' must have one stream, but has %d", layout.Name, layout.StreamCount())
+
+	va.Bind()
+	vbo.Bind()
+	va.InstanceLayout = layout
+	va.InstanceVboId = vbo.Id
+
+	for i := 0; i < len(layout.Attribs); i++ {
+
+		a := &layout.Attribs[i]
+		colCount, colType := matrixColumns(a.ElementType)
+		for col := int32(0); col < colCount; col++ {
+
+			loc := a.Loc + uint32(col)
+			offset := uintptr(a.Offset) + uintptr(col*colType.Size())
+
+			gl.EnableVertexAttribArray(loc)
+			if colType == DataTypeInt32 || colType == DataTypeUint32 {
+				gl.VertexAttribIPointerWithOffset(loc, colType.CompCount(), colType.GLType(), layout.Strides[0], offset)
+			} else {
+				gl.VertexAttribPointerWithOffset(loc, colType.CompCount(), colType.GLType(), false, layout.Strides[0], offset)
+			}
+			gl.VertexAttribDivisor(loc, 1)
+		}
+	}
+}
+
+// matrixColumns returns the number of locations a vertex attribute of the type takes and the type of each,
+// which is one location of the type itself for everything except matrices
+func matrixColumns(dt ElementType) (colCount int32, colType ElementType) {
+
+	switch dt {
+	case DataTypeMat2:
+		return 2, DataTypeVec2
+	case DataTypeMat3:
+		return 3, DataTypeVec3
+	case DataTypeMat4:
+		return 4, DataTypeVec4
+	default:
+		return 1, dt
+	}
+}
+
+// StreamBuffer returns the vertex buffer of a stream of Layout, which can be updated without touching the other streams
+func (va *VertexArray) StreamBuffer(stream uint32) *VertexBuffer {
+	assert.T(va.Layout != nil && stream < va.Layout.StreamCount(), "Vertex array has no stream %d", stream)
+	return &va.Vbos[va.layoutVboIndex+int(stream)]
+}
+
 func (va *VertexArray) SetIndexBuffer(ib IndexBuffer) {
 	va.Bind()
 	ib.Bind()
 	va.IndexBuffer = ib
 }
 
+// Delete deletes the vertex array along with the vertex buffers and index buffer added to it, since the vertex array is considered their owner.
+// Buffers shared with other vertex arrays must be removed from Vbos/IndexBuffer before calling this
+func (va *VertexArray) Delete() {
+
+	for i := 0; i < len(va.Vbos); i++ {
+		va.Vbos[i].Delete()
+	}
+	va.Vbos = nil
+
+	va.IndexBuffer.Delete()
+
+	if va.Id == 0 {
+		return
+	}
+
+	leakcheck.Untrack(leakcheck.ResourceType_VertexArray, va.Id)
+	gl.DeleteVertexArrays(1, &va.Id)
+	va.Id = 0
+}
+
 func NewVertexArray() VertexArray {
 
 	vao := VertexArray{}
 
 	gl.GenVertexArrays(1, &vao.Id)
+	leakcheck.Track(leakcheck.ResourceType_VertexArray, vao.Id)
 	if vao.Id == 0 {
 		logging.ErrLog.Println("Failed to create OpenGL vertex array object")
 	}
@@ -1,6 +1,8 @@
 package buffers
 
 import (
+	"github.com/bloeys/nmage/assert"
+	"github.com/bloeys/nmage/leakcheck"
 	"github.com/bloeys/nmage/logging"
 	"github.com/go-gl/gl/v4.1-core/gl"
 )
@@ -8,6 +10,9 @@ import (
 type VertexBuffer struct {
 	Id     uint32
 	Stride int32
+	// Size is the allocated memory in bytes on the GPU for this buffer. Updated in SetData and OrphanAndSet
+	Size   int
+	Usage  BufUsage
 	layout []Element
 }
 
@@ -24,6 +29,9 @@ func (vb *VertexBuffer) SetData(values []float32, usage BufUsage) {
 	vb.Bind()
 
 	sizeInBytes := len(values) * 4
+	vb.Size = sizeInBytes
+	vb.Usage = usage
+
 	if sizeInBytes == 0 {
 		gl.BufferData(gl.ARRAY_BUFFER, 0, gl.Ptr(nil), usage.ToGL())
 	} else {
@@ -31,6 +39,53 @@ func (vb *VertexBuffer) SetData(values []float32, usage BufUsage) {
 	}
 }
 
+// Allocate sets the buffer size without uploading any data, which is useful for buffers written by the GPU
+// (e.g. transform feedback targets). Previous contents are lost
+func (vb *VertexBuffer) Allocate(sizeInBytes int, usage BufUsage) {
+
+	vb.Size = sizeInBytes
+	vb.Usage = usage
+
+	vb.Bind()
+	gl.BufferData(gl.ARRAY_BUFFER, sizeInBytes, gl.Ptr(nil), usage.ToGL())
+}
+
+// SetSubData updates part of the buffer starting at offsetBytes without reallocating it.
+// The range must fit within the current size of the buffer
+func (vb *VertexBuffer) SetSubData(offsetBytes int, values []float32) {
+
+	if len(values) == 0 {
+		return
+	}
+
+	sizeInBytes := len(values) * 4
+	assert.T(offsetBytes >= 0 && offsetBytes+sizeInBytes <= vb.Size, "VertexBuffer.SetSubData range is outside the buffer. Offset=%d, Size=%d, Buffer size=%d", offsetBytes, sizeInBytes, vb.Size)
+
+	vb.Bind()
+	gl.BufferSubData(gl.ARRAY_BUFFER, offsetBytes, sizeInBytes, gl.Ptr(&values[0]))
+}
+
+// OrphanAndSet replaces the contents of a buffer that is rewritten often (e.g. debug lines or particles).
+//
+// Writing to a buffer the GPU is still drawing from makes the CPU wait. Orphaning re-specifies the buffer's store first,
+// so the driver gives us fresh memory while the GPU keeps using the old one until it's done with it.
+// The store never shrinks, so streaming different amounts each frame doesn't reallocate every time
+func (vb *VertexBuffer) OrphanAndSet(values []float32) {
+
+	if vb.Usage == BufUsage_Unknown {
+		vb.Usage = BufUsage_Stream_Draw
+	}
+
+	vb.Size = max(vb.Size, len(values)*4)
+
+	vb.Bind()
+	gl.BufferData(gl.ARRAY_BUFFER, vb.Size, gl.Ptr(nil), vb.Usage.ToGL())
+
+	if len(values) > 0 {
+		gl.BufferSubData(gl.ARRAY_BUFFER, 0, len(values)*4, gl.Ptr(&values[0]))
+	}
+}
+
 func (vb *VertexBuffer) GetLayout() []Element {
 	e := make([]Element, len(vb.layout))
 	copy(e, vb.layout)
@@ -49,11 +104,24 @@ func (vb *VertexBuffer) SetLayout(layout ...Element) {
 	}
 }
 
+func (vb *VertexBuffer) Delete() {
+
+	if vb.Id == 0 {
+		return
+	}
+
+	leakcheck.Untrack(leakcheck.ResourceType_Buffer, vb.Id)
+	gl.DeleteBuffers(1, &vb.Id)
+	vb.Id = 0
+	vb.Size = 0
+}
+
 func NewVertexBuffer(layout ...Element) VertexBuffer {
 
 	vb := VertexBuffer{}
 
 	gl.GenBuffers(1, &vb.Id)
+	leakcheck.Track(leakcheck.ResourceType_Buffer, vb.Id)
 	if vb.Id == 0 {
 		logging.ErrLog.Panicln("Failed to create OpenGL buffer")
 	}
@@ -0,0 +1,244 @@
+package buffers
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bloeys/nmage/assert"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// VertexAttrib is an attribute of a VertexLayout, which shaders read with 'layout(location=Loc)'
+type VertexAttrib struct {
+	Loc uint32
+
+	// Stream is the index of the vertex buffer the attribute is in. Attributes of the same stream are interleaved
+	Stream uint32
+
+	// Name is only used to describe the attribute in errors
+	Name string
+
+	Element
+}
+
+// VertexLayout is how the attributes of a vertex are stored in vertex buffers, and which shader locations read them.
+//
+// Attributes are split into streams, where each stream is its own vertex buffer with its attributes interleaved.
+// One stream holding everything is the fastest to draw, while separate streams allow updating some attributes (e.g. positions
+// for CPU skinning) without uploading the rest, and let passes that only read positions (e.g. depth) fetch less memory.
+//
+// A layout is defined once and shared by all vertex arrays of that vertex format (see VertexArray.AddVertexBuffersWithLayout),
+// and Validate checks a shader can draw it. Vertex arrays with the same layout pointer have the same format
+type VertexLayout struct {
+	Name    string
+	Attribs []VertexAttrib
+
+	// Strides has the size in bytes of one vertex in each stream
+	Strides []int32
+
+	// DefaultedLocs are locations shaders may read even though the layout doesn't have them, which OpenGL reads as (0, 0, 0, 1).
+	// Used by shaders that handle optional attributes (e.g. vertex colors) without needing a variant per layout
+	DefaultedLocs []uint32
+}
+
+// StreamCount returns how many vertex buffers the layout is stored in
+func (l *VertexLayout) StreamCount() uint32 {
+	return uint32(len(l.Strides))
+}
+
+// StreamElements returns the elements of the attributes of a stream in buffer order, as used by VertexBuffer.SetLayout
+func (l *VertexLayout) StreamElements(stream uint32) []Element {
+
+	elements := make([]Element, 0, len(l.Attribs))
+	for i := 0; i < len(l.Attribs); i++ {
+		if l.Attribs[i].Stream == stream {
+			elements = append(elements, l.Attribs[i].Element)
+		}
+	}
+
+	return elements
+}
+
+// AttribAtLoc returns the attribute read from loc, or nil if the layout has none there
+func (l *VertexLayout) AttribAtLoc(loc uint32) *VertexAttrib {
+
+	for i := 0; i < len(l.Attribs); i++ {
+		if l.Attribs[i].Loc == loc {
+			return &l.Attribs[i]
+		}
+	}
+
+	return nil
+}
+
+// Equal returns true if both layouts have the same attributes at the same locations
+func (l *VertexLayout) Equal(other *VertexLayout) bool {
+
+	if l == other {
+		return true
+	}
+
+	if len(l.Attribs) != len(other.Attribs) || !slices.Equal(l.Strides, other.Strides) {
+		return false
+	}
+
+	for i := 0; i < len(l.Attribs); i++ {
+
+		a, b := &l.Attribs[i], &other.Attribs[i]
+		if a.Loc != b.Loc || a.Stream != b.Stream || a.ElementType != b.ElementType || a.Offset != b.Offset {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (l *VertexLayout) String() string {
+
+	var sb strings.Builder
+	sb.WriteString(l.Name + "{")
+	for i := 0; i < len(l.Attribs); i++ {
+
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+
+		a := &l.Attribs[i]
+		fmt.Fprintf(&sb, "%d: %s %s", a.Loc, a.Name, a.ElementType.String())
+		if len(l.Strides) > 1 {
+			fmt.Fprintf(&sb, " (stream %d)", a.Stream)
+		}
+	}
+	sb.WriteString("}")
+
+	return sb.String()
+}
+
+// Validate checks the vertex inputs of a linked shader program against the layout using introspection, and returns an error
+// describing the first input that the layout doesn't have (and isn't in DefaultedLocs), or that has a different kind of data
+// (e.g. the shader reads ints while the layout has floats).
+//
+// Shaders may read fewer or more components than an attribute has, since OpenGL fills missing components from (0, 0, 0, 1)
+func (l *VertexLayout) Validate(shaderProgId uint32) error {
+	return l.ValidateWithInstances(shaderProgId, nil)
+}
+
+// ValidateWithInstances is Validate for instanced draws, where the inputs the layout doesn't have may also come from instanceLayout
+// (see VertexArray.SetInstanceBufferWithLayout). A nil instanceLayout is the same as Validate
+func (l *VertexLayout) ValidateWithInstances(shaderProgId uint32, instanceLayout *VertexLayout) error {
+
+	var attribCount, maxNameLen int32
+	gl.GetProgramiv(shaderProgId, gl.ACTIVE_ATTRIBUTES, &attribCount)
+	gl.GetProgramiv(shaderProgId, gl.ACTIVE_ATTRIBUTE_MAX_LENGTH, &maxNameLen)
+	if attribCount == 0 {
+		return nil
+	}
+
+	nameBuf := make([]uint8, maxNameLen+1)
+	for i := int32(0); i < attribCount; i++ {
+
+		var nameLen, arraySize int32
+		var glType uint32
+		gl.GetActiveAttrib(shaderProgId, uint32(i), int32(len(nameBuf)), &nameLen, &arraySize, &glType, &nameBuf[0])
+
+		// Built in inputs like gl_VertexID don't come from buffers
+		name := string(nameBuf[:nameLen])
+		if strings.HasPrefix(name, "gl_") {
+			continue
+		}
+
+		loc := gl.GetAttribLocation(shaderProgId, gl.Str(name+"\x00"))
+		if loc < 0 {
+			continue
+		}
+
+		attrib := l.AttribAtLoc(uint32(loc))
+		if attrib == nil && instanceLayout != nil {
+			attrib = instanceLayout.AttribAtLoc(uint32(loc))
+		}
+
+		if attrib == nil {
+
+			if slices.Contains(l.DefaultedLocs, uint32(loc)) {
+				continue
+			}
+
+			return fmt.Errorf("shader vertex input '%s' at location %d isn't in vertex layout %s", name, loc, l.String())
+		}
+
+		shaderKind, isKnown := glAttribTypeKind(glType)
+		if !isKnown {
+			continue
+		}
+
+		if layoutKind := attribElementKind(attrib.ElementType); shaderKind != layoutKind {
+			return fmt.Errorf("shader vertex input '%s' at location %d reads %s data, but attribute '%s' of vertex layout %s is %s data", name, loc, shaderKind, attrib.Name, l.Name, layoutKind)
+		}
+	}
+
+	return nil
+}
+
+// NewVertexLayout returns a layout with the attributes of each stream interleaved in the passed order.
+// Streams must be used without gaps, so a layout with streams 0 and 2 must also have 1
+func NewVertexLayout(name string, attribs ...VertexAttrib) VertexLayout {
+
+	l := VertexLayout{
+		Name:    name,
+		Attribs: attribs,
+	}
+
+	for i := 0; i < len(l.Attribs); i++ {
+
+		a := &l.Attribs[i]
+		for uint32(len(l.Strides)) <= a.Stream {
+			l.Strides = append(l.Strides, 0)
+		}
+
+		a.Offset = int(l.Strides[a.Stream])
+		l.Strides[a.Stream] += a.ElementType.Size()
+	}
+
+	for i := 0; i < len(l.Strides); i++ {
+		assert.T(l.Strides[i] > 0, "Vertex layout '%s' has no attributes in stream %d, but has attributes in later streams", name, i)
+	}
+
+	return l
+}
+
+// NewSeparateVertexLayout returns a layout where each attribute is in its own stream, in the passed order
+func NewSeparateVertexLayout(name string, attribs ...VertexAttrib) VertexLayout {
+
+	for i := 0; i < len(attribs); i++ {
+		attribs[i].Stream = uint32(i)
+	}
+
+	return NewVertexLayout(name, attribs...)
+}
+
+func glAttribTypeKind(glType uint32) (kind string, isKnown bool) {
+
+	switch glType {
+	case gl.FLOAT, gl.FLOAT_VEC2, gl.FLOAT_VEC3, gl.FLOAT_VEC4, gl.FLOAT_MAT2, gl.FLOAT_MAT3, gl.FLOAT_MAT4:
+		return "float", true
+	case gl.INT, gl.INT_VEC2, gl.INT_VEC3, gl.INT_VEC4:
+		return "int", true
+	case gl.UNSIGNED_INT, gl.UNSIGNED_INT_VEC2, gl.UNSIGNED_INT_VEC3, gl.UNSIGNED_INT_VEC4:
+		return "uint", true
+	default:
+		return "", false
+	}
+}
+
+func attribElementKind(dt ElementType) string {
+
+	switch dt {
+	case DataTypeInt32:
+		return "int"
+	case DataTypeUint32:
+		return "uint"
+	default:
+		return "float"
+	}
+}
@@ -29,6 +29,9 @@ type Camera struct {
 	// Ortho data
 	Left, Right, Top, Bottom float32
 
+	// CullingMask is the layers drawn by the camera, where zero draws all layers (see LayerMask)
+	CullingMask LayerMask
+
 	// Matrices
 	ViewMat gglm.Mat4
 	ProjMat gglm.Mat4
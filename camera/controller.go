@@ -0,0 +1,455 @@
+package camera
+
+import (
+	"math"
+
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/input"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+var (
+	_ Controller = &FlyController{}
+	_ Controller = &OrbitController{}
+	_ Controller = &PanController2D{}
+)
+
+const (
+	// maxMouseMove ignores huge mouse jumps (e.g. when the window regains focus) so the camera doesn't spin around
+	maxMouseMove = 300
+)
+
+// Controller moves a camera from the player's input (see the input package).
+// Input captured by the UI is ignored, so the camera doesn't move while typing in a text box or dragging a window
+type Controller interface {
+	// Update applies this frame's input to the camera and updates its matrices, where dt is the frame time in seconds.
+	// Cameras usually use timing.UnscaledDT so they can still be moved while the game is paused
+	Update(cam *Camera, dt float32)
+}
+
+// smoothFactor returns how far to move towards a target this frame, where smoothing is roughly the seconds it takes
+// to get most of the way there. Zero smoothing moves all the way at once, and the result doesn't depend on the frame rate
+func smoothFactor(smoothing, dt float32) float32 {
+
+	if smoothing <= 0 {
+		return 1
+	}
+
+	return 1 - float32(math.Exp(float64(-dt/smoothing)))
+}
+
+func lerp(a, b, t float32) float32 {
+	return a + (b-a)*t
+}
+
+func lerpVec3(a, b *gglm.Vec3, t float32) {
+	a.Set(lerp(a.X(), b.X(), t), lerp(a.Y(), b.Y(), t), lerp(a.Z(), b.Z(), t))
+}
+
+// approach moves current towards target by t, snapping to target once close enough so smoothing settles.
+// Returns false if current was already at target
+func approach(current *float32, target, t float32) bool {
+
+	if *current == target {
+		return false
+	}
+
+	*current = lerp(*current, target, t)
+	if gglm.Abs32(target-*current) < 1e-5 {
+		*current = target
+	}
+
+	return true
+}
+
+// approachVec3 is approach for vectors
+func approachVec3(current, target *gglm.Vec3, t float32) {
+
+	lerpVec3(current, target, t)
+	diff := gglm.SubVec3(target, current)
+	if diff.SqrMag() < 1e-8 {
+		*current = *target
+	}
+}
+
+// mouseMotion returns the clamped mouse motion of the frame
+func mouseMotion() (x, y float32) {
+	mouseX, mouseY := input.GetMouseMotion()
+	return float32(gglm.Clamp(mouseX, -maxMouseMove, maxMouseMove)), float32(gglm.Clamp(mouseY, -maxMouseMove, maxMouseMove))
+}
+
+// mouseDownOrNone returns true if the mouse button is down, or if button is zero
+func mouseDownOrNone(button int) bool {
+	return button == 0 || input.MouseDown(button)
+}
+
+// keyAxis returns 1 if positive is down, -1 if negative is down, and 0 if both or neither are
+func keyAxis(positive, negative sdl.Keycode) float32 {
+
+	axis := float32(0)
+	if positive != sdl.K_UNKNOWN && input.KeyDown(positive) {
+		axis++
+	}
+
+	if negative != sdl.K_UNKNOWN && input.KeyDown(negative) {
+		axis--
+	}
+
+	return axis
+}
+
+// forwardFromYawPitch returns the direction UpdateRotation makes from yaw and pitch
+func forwardFromYawPitch(yaw, pitch float32) gglm.Vec3 {
+	return gglm.NewVec3(gglm.Cos32(yaw)*gglm.Cos32(pitch), gglm.Sin32(pitch), gglm.Sin32(yaw)*gglm.Cos32(pitch))
+}
+
+// yawPitchFromForward is the inverse of forwardFromYawPitch
+func yawPitchFromForward(forward *gglm.Vec3) (yaw, pitch float32) {
+
+	f := *forward.Clone().Normalize()
+	return gglm.Atan232(f.X(), f.Z()), gglm.Asin32(gglm.Clamp(f.Y(), -1, 1))
+}
+
+// rightAndUp returns the right and up directions of a camera looking along forward
+func rightAndUp(forward, worldUp *gglm.Vec3) (right, up gglm.Vec3) {
+
+	right = gglm.Cross(forward, worldUp)
+	if right.SqrMag() < 1e-8 {
+		right = gglm.NewVec3(1, 0, 0)
+	}
+	right.Normalize()
+
+	up = gglm.Cross(&right, forward)
+	up.Normalize()
+	return right, up
+}
+
+// FlyKeys are the keys of a FlyController. sdl.K_UNKNOWN disables a key
+type FlyKeys struct {
+	Forward  sdl.Keycode
+	Backward sdl.Keycode
+	Left     sdl.Keycode
+	Right    sdl.Keycode
+	Up       sdl.Keycode
+	Down     sdl.Keycode
+
+	// Fast multiplies the move speed by FlyController.FastMultiplier while held
+	Fast sdl.Keycode
+}
+
+// DefaultFlyKeys are WASD to move, E and Q to go up and down, and left shift to move faster
+func DefaultFlyKeys() FlyKeys {
+	return FlyKeys{
+		Forward:  sdl.K_w,
+		Backward: sdl.K_s,
+		Left:     sdl.K_a,
+		Right:    sdl.K_d,
+		Up:       sdl.K_e,
+		Down:     sdl.K_q,
+		Fast:     sdl.K_LSHIFT,
+	}
+}
+
+// FlyController is a free flying first person camera, that looks around with the mouse and moves where it looks with the keys
+type FlyController struct {
+	Keys FlyKeys
+
+	// LookButton is the mouse button held to look around (e.g. sdl.BUTTON_RIGHT), where zero looks whenever the mouse moves
+	LookButton int
+
+	// MoveSpeed is in world units per second
+	MoveSpeed      float32
+	FastMultiplier float32
+
+	// Sensitivity is the rotation in radians per pixel of mouse motion
+	Sensitivity float32
+
+	// MaxPitch is the furthest in radians the camera can look up or down, which must be less than 90 degrees
+	MaxPitch float32
+
+	// Smoothing is roughly the seconds the camera takes to reach the rotation and speed asked by the input, where zero is instant
+	Smoothing float32
+
+	// Yaw and Pitch are the rotation in radians the camera is turning towards (see Camera.UpdateRotation)
+	Yaw   float32
+	Pitch float32
+
+	smoothYaw   float32
+	smoothPitch float32
+	velocity    gglm.Vec3
+}
+
+// Update only changes the camera while it is moving or turning, so other code can still move the camera
+// (e.g. a debug UI), after which SyncFromCamera should be called so the controller doesn't turn it back
+func (fc *FlyController) Update(cam *Camera, dt float32) {
+
+	if mouseDownOrNone(fc.LookButton) {
+		mouseX, mouseY := mouseMotion()
+		fc.Yaw += mouseX * fc.Sensitivity
+		fc.Pitch = gglm.Clamp(fc.Pitch-mouseY*fc.Sensitivity, -fc.MaxPitch, fc.MaxPitch)
+	}
+
+	speed := fc.MoveSpeed
+	if fc.Keys.Fast != sdl.K_UNKNOWN && input.KeyDown(fc.Keys.Fast) {
+		speed *= fc.FastMultiplier
+	}
+
+	right, _ := rightAndUp(&cam.Forward, &cam.WorldUp)
+	targetVelocity := *cam.Forward.Clone().Scale(keyAxis(fc.Keys.Forward, fc.Keys.Backward))
+	targetVelocity.Add(right.Scale(keyAxis(fc.Keys.Right, fc.Keys.Left)))
+	targetVelocity.Add(cam.WorldUp.Clone().Scale(keyAxis(fc.Keys.Up, fc.Keys.Down)))
+	if targetVelocity.SqrMag() > 0 {
+		targetVelocity.Normalize().Scale(speed)
+	}
+
+	t := smoothFactor(fc.Smoothing, dt)
+	approachVec3(&fc.velocity, &targetVelocity, t)
+	yawChanged := approach(&fc.smoothYaw, fc.Yaw, t)
+	pitchChanged := approach(&fc.smoothPitch, fc.Pitch, t)
+
+	moving := fc.velocity.SqrMag() > 0
+	if moving {
+		cam.Pos.Add(fc.velocity.Clone().Scale(dt))
+	}
+
+	if yawChanged || pitchChanged {
+		cam.UpdateRotation(fc.smoothPitch, fc.smoothYaw)
+	} else if moving {
+		cam.Update()
+	}
+}
+
+// SyncFromCamera makes the controller continue from the camera's current rotation and stops its movement,
+// which should be called after the camera is rotated by something other than the controller
+func (fc *FlyController) SyncFromCamera(cam *Camera) {
+
+	fc.Yaw, fc.Pitch = yawPitchFromForward(&cam.Forward)
+	fc.smoothYaw = fc.Yaw
+	fc.smoothPitch = fc.Pitch
+	fc.velocity = gglm.Vec3{}
+}
+
+// NewFlyController returns a fly controller starting at the camera's current rotation,
+// with the default keys and the mouse always looking around
+func NewFlyController(cam *Camera) FlyController {
+
+	fc := FlyController{
+		Keys:           DefaultFlyKeys(),
+		MoveSpeed:      10,
+		FastMultiplier: 2,
+		Sensitivity:    0.005,
+		MaxPitch:       1.5,
+	}
+	fc.SyncFromCamera(cam)
+
+	return fc
+}
+
+// OrbitController circles the camera around a target, like model viewers and editors.
+// Dragging with OrbitButton rotates around the target, dragging with PanButton moves the target, and the mouse wheel zooms
+type OrbitController struct {
+	Target   gglm.Vec3
+	Distance float32
+
+	MinDistance float32
+	MaxDistance float32
+
+	// OrbitButton and PanButton are the mouse buttons dragged to orbit and pan. Zero disables them
+	OrbitButton int
+	PanButton   int
+
+	// Sensitivity is the rotation in radians per pixel of mouse motion
+	Sensitivity float32
+
+	// PanSpeed is how far a pixel of mouse motion pans, as a fraction of the distance, so panning feels the same at any zoom
+	PanSpeed float32
+
+	// ZoomSpeed is the fraction of the distance zoomed per mouse wheel step
+	ZoomSpeed float32
+
+	// MaxPitch is the furthest in radians the camera can go above or below the target, which must be less than 90 degrees
+	MaxPitch float32
+
+	// Smoothing is roughly the seconds the camera takes to reach where the input moved it, where zero is instant
+	Smoothing float32
+
+	// Yaw and Pitch are the direction the camera looks at the target from (see Camera.UpdateRotation)
+	Yaw   float32
+	Pitch float32
+
+	smoothYaw      float32
+	smoothPitch    float32
+	smoothDistance float32
+	smoothTarget   gglm.Vec3
+}
+
+func (oc *OrbitController) Update(cam *Camera, dt float32) {
+
+	mouseX, mouseY := mouseMotion()
+	if oc.OrbitButton != 0 && input.MouseDown(oc.OrbitButton) {
+		oc.Yaw += mouseX * oc.Sensitivity
+		oc.Pitch = gglm.Clamp(oc.Pitch-mouseY*oc.Sensitivity, -oc.MaxPitch, oc.MaxPitch)
+	}
+
+	if oc.PanButton != 0 && input.MouseDown(oc.PanButton) {
+
+		// Panning drags the scene with the mouse, so the target moves the other way
+		right, up := rightAndUp(&cam.Forward, &cam.WorldUp)
+		panScale := oc.PanSpeed * oc.Distance
+		oc.Target.Add(right.Scale(-mouseX * panScale))
+		oc.Target.Add(up.Scale(mouseY * panScale))
+	}
+
+	if wheel := input.GetMouseWheelYNorm(); wheel != 0 {
+		oc.Distance *= 1 - float32(wheel)*oc.ZoomSpeed
+	}
+	oc.Distance = gglm.Clamp(oc.Distance, oc.MinDistance, oc.MaxDistance)
+
+	t := smoothFactor(oc.Smoothing, dt)
+	approach(&oc.smoothYaw, oc.Yaw, t)
+	approach(&oc.smoothPitch, oc.Pitch, t)
+	approach(&oc.smoothDistance, oc.Distance, t)
+	approachVec3(&oc.smoothTarget, &oc.Target, t)
+
+	forward := forwardFromYawPitch(oc.smoothYaw, oc.smoothPitch)
+	cam.Forward = forward
+	cam.Pos = *oc.smoothTarget.Clone().Sub(forward.Scale(oc.smoothDistance))
+	cam.Update()
+}
+
+// NewOrbitController returns an orbit controller around target that keeps the camera's current position,
+// orbiting with the left mouse button and panning with the middle one
+func NewOrbitController(cam *Camera, target *gglm.Vec3) OrbitController {
+
+	toTarget := gglm.SubVec3(target, &cam.Pos)
+	distance := toTarget.Mag()
+	if distance < 1e-4 {
+		toTarget = cam.Forward
+		distance = 1
+	}
+
+	yaw, pitch := yawPitchFromForward(&toTarget)
+	return OrbitController{
+		Target:         *target,
+		Distance:       distance,
+		MinDistance:    0.1,
+		MaxDistance:    1000,
+		OrbitButton:    sdl.BUTTON_LEFT,
+		PanButton:      sdl.BUTTON_MIDDLE,
+		Sensitivity:    0.005,
+		PanSpeed:       0.002,
+		ZoomSpeed:      0.1,
+		MaxPitch:       1.5,
+		Yaw:            yaw,
+		Pitch:          pitch,
+		smoothYaw:      yaw,
+		smoothPitch:    pitch,
+		smoothDistance: distance,
+		smoothTarget:   *target,
+	}
+}
+
+// PanKeys are the keys of a PanController2D. sdl.K_UNKNOWN disables a key
+type PanKeys struct {
+	Up    sdl.Keycode
+	Down  sdl.Keycode
+	Left  sdl.Keycode
+	Right sdl.Keycode
+}
+
+// DefaultPanKeys are the arrow keys
+func DefaultPanKeys() PanKeys {
+	return PanKeys{
+		Up:    sdl.K_UP,
+		Down:  sdl.K_DOWN,
+		Left:  sdl.K_LEFT,
+		Right: sdl.K_RIGHT,
+	}
+}
+
+// PanController2D moves an orthographic camera in its view plane and zooms it by scaling its extents, like 2D games and map views.
+// The camera's rotation is never changed
+type PanController2D struct {
+	Keys PanKeys
+
+	// DragButton is the mouse button dragged to pan, which keeps the point under the mouse under it. Zero disables dragging
+	DragButton int
+
+	// ViewportHeight is the height in pixels of the area the camera draws to, which turns mouse drags into world units.
+	// Should be updated when the window is resized
+	ViewportHeight float32
+
+	// KeySpeed is how fast the keys pan, in view heights per second so panning feels the same at any zoom
+	KeySpeed float32
+
+	// Zoom divides the extents the controller was made with, so 2 shows half as much. ZoomSpeed is the fraction
+	// zoomed per mouse wheel step
+	Zoom      float32
+	MinZoom   float32
+	MaxZoom   float32
+	ZoomSpeed float32
+
+	// Smoothing is roughly the seconds the camera takes to reach where the input moved it, where zero is instant
+	Smoothing float32
+
+	// Pos is the position the camera is moving towards
+	Pos gglm.Vec3
+
+	baseLeft, baseRight, baseTop, baseBottom float32
+
+	smoothZoom float32
+}
+
+func (pc *PanController2D) Update(cam *Camera, dt float32) {
+
+	if wheel := input.GetMouseWheelYNorm(); wheel != 0 {
+		pc.Zoom *= 1 + float32(wheel)*pc.ZoomSpeed
+	}
+	pc.Zoom = gglm.Clamp(pc.Zoom, pc.MinZoom, pc.MaxZoom)
+
+	viewHeight := gglm.Abs32(pc.baseTop-pc.baseBottom) / pc.Zoom
+	right, up := rightAndUp(&cam.Forward, &cam.WorldUp)
+
+	keyMove := pc.KeySpeed * viewHeight * dt
+	pc.Pos.Add(right.Clone().Scale(keyAxis(pc.Keys.Right, pc.Keys.Left) * keyMove))
+	pc.Pos.Add(up.Clone().Scale(keyAxis(pc.Keys.Up, pc.Keys.Down) * keyMove))
+
+	if pc.DragButton != 0 && pc.ViewportHeight > 0 && input.MouseDown(pc.DragButton) {
+
+		mouseX, mouseY := mouseMotion()
+		unitsPerPixel := viewHeight / pc.ViewportHeight
+		pc.Pos.Add(right.Scale(-mouseX * unitsPerPixel))
+		pc.Pos.Add(up.Scale(mouseY * unitsPerPixel))
+	}
+
+	t := smoothFactor(pc.Smoothing, dt)
+	approach(&pc.smoothZoom, pc.Zoom, t)
+	approachVec3(&cam.Pos, &pc.Pos, t)
+
+	cam.Left = pc.baseLeft / pc.smoothZoom
+	cam.Right = pc.baseRight / pc.smoothZoom
+	cam.Top = pc.baseTop / pc.smoothZoom
+	cam.Bottom = pc.baseBottom / pc.smoothZoom
+	cam.Update()
+}
+
+// NewPanController2D returns a pan controller for an orthographic camera, whose current extents are used as zoom 1.
+// Panning uses the arrow keys and dragging with the middle mouse button
+func NewPanController2D(cam *Camera, viewportHeight float32) PanController2D {
+	return PanController2D{
+		Keys:           DefaultPanKeys(),
+		DragButton:     sdl.BUTTON_MIDDLE,
+		ViewportHeight: viewportHeight,
+		KeySpeed:       1,
+		Zoom:           1,
+		MinZoom:        0.05,
+		MaxZoom:        50,
+		ZoomSpeed:      0.1,
+		Pos:            cam.Pos,
+		baseLeft:       cam.Left,
+		baseRight:      cam.Right,
+		baseTop:        cam.Top,
+		baseBottom:     cam.Bottom,
+		smoothZoom:     1,
+	}
+}
@@ -0,0 +1,48 @@
+package camera
+
+import "github.com/bloeys/gglm/gglm"
+
+// CubemapFaceCount is the number of faces of a cubemap
+const CubemapFaceCount = 6
+
+// CubemapFaces are the look directions and up vectors of the cubemap faces in the OpenGL face order (+x, -x, +y, -y, +z, -z).
+// The up vectors are the orientation OpenGL samples the faces with
+var CubemapFaces = [CubemapFaceCount]struct{ Forward, Up gglm.Vec3 }{
+	{gglm.NewVec3(1, 0, 0), gglm.NewVec3(0, -1, 0)},
+	{gglm.NewVec3(-1, 0, 0), gglm.NewVec3(0, -1, 0)},
+	{gglm.NewVec3(0, 1, 0), gglm.NewVec3(0, 0, 1)},
+	{gglm.NewVec3(0, -1, 0), gglm.NewVec3(0, 0, -1)},
+	{gglm.NewVec3(0, 0, 1), gglm.NewVec3(0, -1, 0)},
+	{gglm.NewVec3(0, 0, -1), gglm.NewVec3(0, -1, 0)},
+}
+
+// CubemapViewMats returns the view matrices looking out of pos through each cubemap face, to be used with CubemapProjMat
+func CubemapViewMats(pos *gglm.Vec3) [CubemapFaceCount]gglm.Mat4 {
+
+	viewMats := [CubemapFaceCount]gglm.Mat4{}
+	for i := 0; i < len(CubemapFaces); i++ {
+		target := pos.Clone().Add(&CubemapFaces[i].Forward)
+		viewMats[i] = gglm.LookAtRH(pos, target, &CubemapFaces[i].Up).Mat4
+	}
+
+	return viewMats
+}
+
+// CubemapProjMat returns the 90 degree square projection that makes the views of CubemapViewMats into a cubemap
+func CubemapProjMat(nearPlane, farPlane float32) gglm.Mat4 {
+	return gglm.Perspective(90*gglm.Deg2Rad, 1, nearPlane, farPlane)
+}
+
+// CubemapProjViewMats returns the projection of CubemapProjMat times each of the views of CubemapViewMats
+func CubemapProjViewMats(pos *gglm.Vec3, nearPlane, farPlane float32) [CubemapFaceCount]gglm.Mat4 {
+
+	projMat := CubemapProjMat(nearPlane, farPlane)
+	viewMats := CubemapViewMats(pos)
+
+	projViewMats := [CubemapFaceCount]gglm.Mat4{}
+	for i := 0; i < len(viewMats); i++ {
+		projViewMats[i] = *projMat.Clone().Mul(&viewMats[i])
+	}
+
+	return projViewMats
+}
@@ -0,0 +1,72 @@
+package camera
+
+import (
+	"github.com/bloeys/gglm/gglm"
+)
+
+// Frustum is the six planes bounding the volume a projection sees.
+// Each plane is stored as (normal, distance) with the normal pointing into the frustum
+type Frustum struct {
+	Planes [6]gglm.Vec4
+}
+
+// NewFrustum extracts the frustum planes from a projection*view matrix.
+// Based on: https://www.gamedevs.org/uploads/fast-extraction-viewing-frustum-planes-from-world-view-projection-matrix.pdf
+func NewFrustum(projViewMat *gglm.Mat4) Frustum {
+
+	m := projViewMat
+	row := func(r int) gglm.Vec4 {
+		return gglm.NewVec4(m.Get(r, 0), m.Get(r, 1), m.Get(r, 2), m.Get(r, 3))
+	}
+
+	r0 := row(0)
+	r1 := row(1)
+	r2 := row(2)
+	r3 := row(3)
+
+	f := Frustum{
+		Planes: [6]gglm.Vec4{
+			*r3.Clone().Add(&r0), // Left
+			*r3.Clone().Sub(&r0), // Right
+			*r3.Clone().Add(&r1), // Bottom
+			*r3.Clone().Sub(&r1), // Top
+			*r3.Clone().Add(&r2), // Near
+			*r3.Clone().Sub(&r2), // Far
+		},
+	}
+
+	// Normalize so that plane distances are in world units, which sphere tests need
+	for i := 0; i < len(f.Planes); i++ {
+
+		p := &f.Planes[i]
+		normal := gglm.NewVec3(p.X(), p.Y(), p.Z())
+		normalLen := normal.Mag()
+		if normalLen > 0 {
+			p.Scale(1 / normalLen)
+		}
+	}
+
+	return f
+}
+
+// SphereVisible reports whether any part of the sphere is inside the frustum.
+// The test is conservative, so some spheres near the frustum corners are reported visible even though they are not
+func (f *Frustum) SphereVisible(center *gglm.Vec3, radius float32) bool {
+
+	for i := 0; i < len(f.Planes); i++ {
+
+		p := &f.Planes[i]
+		dist := p.X()*center.X() + p.Y()*center.Y() + p.Z()*center.Z() + p.W()
+		if dist < -radius {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Frustum returns the frustum of the camera using its current matrices
+func (c *Camera) Frustum() Frustum {
+	projViewMat := gglm.MulMat4(&c.ProjMat, &c.ViewMat)
+	return NewFrustum(&projViewMat)
+}
@@ -0,0 +1,68 @@
+package camera
+
+import "math"
+
+// MaxLayers is the number of render layers, one per bit of LayerMask
+const MaxLayers = 32
+
+// LayerMask is a set of render layers, where layer i is bit i.
+//
+// Objects are on one or more layers, while cameras and lights have a culling mask of the layers they draw or affect
+// (e.g. a minimap camera that skips particles, or a light that only lights characters). A zero mask has a meaning that
+// keeps zero valued objects, cameras and lights working as if there were no layers: objects with a zero mask are on
+// LayerMask_Default, and cameras and lights with a zero culling mask see all layers
+type LayerMask uint32
+
+const (
+	LayerMask_None    LayerMask = 0
+	LayerMask_Default LayerMask = 1 << 0
+	LayerMask_All     LayerMask = math.MaxUint32
+)
+
+// Layer returns the mask of the single layer index, which must be less than MaxLayers
+func Layer(index uint8) LayerMask {
+	return 1 << index
+}
+
+func (lm *LayerMask) Set(layers LayerMask) {
+	*lm |= layers
+}
+
+func (lm *LayerMask) Remove(layers LayerMask) {
+	*lm &= ^layers
+}
+
+func (lm *LayerMask) Has(layers LayerMask) bool {
+	return *lm&layers == layers
+}
+
+// AsObjectLayers returns the layers of an object with this mask, which are LayerMask_Default for a zero mask
+func (lm LayerMask) AsObjectLayers() LayerMask {
+
+	if lm == LayerMask_None {
+		return LayerMask_Default
+	}
+
+	return lm
+}
+
+// AsCullingMask returns the layers seen by a camera or light with this culling mask, which are all layers for a zero mask
+func (lm LayerMask) AsCullingMask() LayerMask {
+
+	if lm == LayerMask_None {
+		return LayerMask_All
+	}
+
+	return lm
+}
+
+// Sees returns true if a camera or light with this culling mask draws or affects objects on objectLayers
+func (lm LayerMask) Sees(objectLayers LayerMask) bool {
+	return lm.AsCullingMask()&objectLayers.AsObjectLayers() != 0
+}
+
+// SeesAnyOf returns true if a camera or light with this culling mask sees any layer of the culling mask other,
+// e.g. to skip lights that only light layers the camera doesn't draw
+func (lm LayerMask) SeesAnyOf(other LayerMask) bool {
+	return lm.AsCullingMask()&other.AsCullingMask() != 0
+}
@@ -0,0 +1,280 @@
+package color
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/bloeys/gglm/gglm"
+)
+
+// Color is an RGBA color in LINEAR space, which is what lighting math and shaders expect.
+//
+// Colors picked in image editors, color pickers and hex codes are almost always sRGB,
+// so create colors from those using FromSrgb, FromSrgb8, FromHex or FromHsv, which convert to linear.
+// Use NewLinear only when the values are already linear (e.g. physically based light values).
+//
+// Alpha is never gamma encoded, so it is the same in both spaces
+type Color struct {
+	Data [4]float32
+}
+
+func (c *Color) R() float32 {
+	return c.Data[0]
+}
+
+func (c *Color) G() float32 {
+	return c.Data[1]
+}
+
+func (c *Color) B() float32 {
+	return c.Data[2]
+}
+
+func (c *Color) A() float32 {
+	return c.Data[3]
+}
+
+func (c *Color) SetA(a float32) {
+	c.Data[3] = a
+}
+
+// Vec3 returns the linear RGB values
+func (c *Color) Vec3() gglm.Vec3 {
+	return gglm.NewVec3(c.Data[0], c.Data[1], c.Data[2])
+}
+
+// Vec4 returns the linear RGBA values
+func (c *Color) Vec4() gglm.Vec4 {
+	return gglm.NewVec4(c.Data[0], c.Data[1], c.Data[2], c.Data[3])
+}
+
+// Srgb returns the color's RGB values gamma encoded to sRGB space, for display in UI and color pickers
+func (c *Color) Srgb() (r, g, b float32) {
+	return LinearToSrgb(c.Data[0]), LinearToSrgb(c.Data[1]), LinearToSrgb(c.Data[2])
+}
+
+// Srgb8 returns the color in sRGB space as 0-255 values, clamping values outside [0,1]
+func (c *Color) Srgb8() (r, g, b, a uint8) {
+	sr, sg, sb := c.Srgb()
+	return toUint8(sr), toUint8(sg), toUint8(sb), toUint8(c.Data[3])
+}
+
+// Hex returns the color in sRGB space as '#RRGGBBAA', or '#RRGGBB' if it is fully opaque
+func (c *Color) Hex() string {
+
+	r, g, b, a := c.Srgb8()
+	if a == 255 {
+		return fmt.Sprintf("#%02X%02X%02X", r, g, b)
+	}
+
+	return fmt.Sprintf("#%02X%02X%02X%02X", r, g, b, a)
+}
+
+// Hsv returns the hue in degrees [0,360), and saturation and value in [0,1], computed from the sRGB values
+func (c *Color) Hsv() (h, s, v float32) {
+
+	r, g, b := c.Srgb()
+
+	maxC := max(r, g, b)
+	minC := min(r, g, b)
+	delta := maxC - minC
+
+	v = maxC
+	if maxC > 0 {
+		s = delta / maxC
+	}
+
+	if delta == 0 {
+		return 0, s, v
+	}
+
+	switch maxC {
+	case r:
+		h = 60 * float32(math.Mod(float64((g-b)/delta), 6))
+	case g:
+		h = 60 * ((b-r)/delta + 2)
+	default:
+		h = 60 * ((r-g)/delta + 4)
+	}
+
+	if h < 0 {
+		h += 360
+	}
+
+	return h, s, v
+}
+
+// Luminance returns the relative luminance of the linear color (Rec. 709 weights)
+func (c *Color) Luminance() float32 {
+	return 0.2126*c.Data[0] + 0.7152*c.Data[1] + 0.0722*c.Data[2]
+}
+
+// Scale multiplies the RGB values (but not alpha), which is useful for light intensities.
+// Returns the same color for chaining
+func (c *Color) Scale(x float32) *Color {
+	c.Data[0] *= x
+	c.Data[1] *= x
+	c.Data[2] *= x
+	return c
+}
+
+func (c *Color) Eq(c2 *Color) bool {
+	return c.Data == c2.Data
+}
+
+func (c *Color) String() string {
+	return fmt.Sprintf("Color(linear: %.3f, %.3f, %.3f, %.3f; srgb: %s)", c.Data[0], c.Data[1], c.Data[2], c.Data[3], c.Hex())
+}
+
+// Lerp interpolates in linear space, which is the physically correct way of mixing light
+func Lerp(c1, c2 *Color, t float32) Color {
+	return Color{Data: [4]float32{
+		c1.Data[0] + (c2.Data[0]-c1.Data[0])*t,
+		c1.Data[1] + (c2.Data[1]-c1.Data[1])*t,
+		c1.Data[2] + (c2.Data[2]-c1.Data[2])*t,
+		c1.Data[3] + (c2.Data[3]-c1.Data[3])*t,
+	}}
+}
+
+// NewLinear creates an opaque color from values that are already in linear space
+func NewLinear(r, g, b float32) Color {
+	return Color{Data: [4]float32{r, g, b, 1}}
+}
+
+// NewLinearA creates a color from values that are already in linear space
+func NewLinearA(r, g, b, a float32) Color {
+	return Color{Data: [4]float32{r, g, b, a}}
+}
+
+// FromSrgb creates an opaque color from sRGB values in [0,1], like the ones from color pickers
+func FromSrgb(r, g, b float32) Color {
+	return Color{Data: [4]float32{SrgbToLinear(r), SrgbToLinear(g), SrgbToLinear(b), 1}}
+}
+
+// FromSrgb8 creates a color from sRGB values in [0,255], like the ones from image editors
+func FromSrgb8(r, g, b, a uint8) Color {
+	return Color{Data: [4]float32{
+		SrgbToLinear(float32(r) / 255),
+		SrgbToLinear(float32(g) / 255),
+		SrgbToLinear(float32(b) / 255),
+		float32(a) / 255,
+	}}
+}
+
+// FromHex parses sRGB hex colors in the forms 'RGB', 'RRGGBB' and 'RRGGBBAA', with an optional '#' prefix
+func FromHex(hex string) (Color, error) {
+
+	s := strings.TrimPrefix(hex, "#")
+
+	// Expand short form so 'F80' becomes 'FF8800'
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+
+	if len(s) == 6 {
+		s += "FF"
+	}
+
+	if len(s) != 8 {
+		return Color{}, fmt.Errorf("invalid hex color '%s'. Expected a hex color in the form RGB, RRGGBB or RRGGBBAA", hex)
+	}
+
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return Color{}, fmt.Errorf("invalid hex color '%s'. Err: %w", hex, err)
+	}
+
+	return FromSrgb8(uint8(v>>24), uint8(v>>16), uint8(v>>8), uint8(v)), nil
+}
+
+// FromHsv creates an opaque color from a hue in degrees, and saturation and value in [0,1].
+// HSV is defined on sRGB values, so the result is converted to linear
+func FromHsv(h, s, v float32) Color {
+
+	h = float32(math.Mod(float64(h), 360))
+	if h < 0 {
+		h += 360
+	}
+
+	chroma := v * s
+	x := chroma * (1 - gglm.Abs32(float32(math.Mod(float64(h/60), 2))-1))
+	m := v - chroma
+
+	var r, g, b float32
+	switch {
+	case h < 60:
+		r, g, b = chroma, x, 0
+	case h < 120:
+		r, g, b = x, chroma, 0
+	case h < 180:
+		r, g, b = 0, chroma, x
+	case h < 240:
+		r, g, b = 0, x, chroma
+	case h < 300:
+		r, g, b = x, 0, chroma
+	default:
+		r, g, b = chroma, 0, x
+	}
+
+	return FromSrgb(r+m, g+m, b+m)
+}
+
+// FromTemperature creates an opaque color for black body radiation at the given temperature in Kelvin,
+// which is useful for lights (e.g. candle ~1900K, incandescent bulb ~2700K, daylight ~6500K).
+// Valid for 1000K to 40000K, and values outside that range are clamped.
+//
+// Based on: https://tannerhelland.com/2012/09/18/convert-temperature-rgb-algorithm-code.html
+func FromTemperature(kelvin float32) Color {
+
+	t := float64(gglm.Clamp(kelvin, 1000, 40000)) / 100
+
+	var r, g, b float64
+	if t <= 66 {
+		r = 255
+		g = 99.4708025861*math.Log(t) - 161.1195681661
+	} else {
+		r = 329.698727446 * math.Pow(t-60, -0.1332047592)
+		g = 288.1221695283 * math.Pow(t-60, -0.0755148492)
+	}
+
+	if t >= 66 {
+		b = 255
+	} else if t <= 19 {
+		b = 0
+	} else {
+		b = 138.5177312231*math.Log(t-10) - 305.0447927307
+	}
+
+	// The algorithm produces sRGB values
+	return FromSrgb(
+		gglm.Clamp(float32(r/255), 0, 1),
+		gglm.Clamp(float32(g/255), 0, 1),
+		gglm.Clamp(float32(b/255), 0, 1),
+	)
+}
+
+// SrgbToLinear decodes one sRGB channel value in [0,1] to linear space
+func SrgbToLinear(x float32) float32 {
+
+	if x <= 0.04045 {
+		return x / 12.92
+	}
+
+	return float32(math.Pow((float64(x)+0.055)/1.055, 2.4))
+}
+
+// LinearToSrgb encodes one linear channel value to sRGB space
+func LinearToSrgb(x float32) float32 {
+
+	if x <= 0.0031308 {
+		return x * 12.92
+	}
+
+	return float32(1.055*math.Pow(float64(x), 1/2.4) - 0.055)
+}
+
+func toUint8(x float32) uint8 {
+	return uint8(gglm.Clamp(x, 0, 1)*255 + 0.5)
+}
@@ -0,0 +1,289 @@
+// The culling package culls instances on the GPU, so scenes with huge instance counts (e.g. grass, rocks, crowds)
+// are drawn without the CPU touching each instance every frame
+package culling
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/buffers"
+	"github.com/bloeys/nmage/camera"
+	"github.com/bloeys/nmage/leakcheck"
+	"github.com/bloeys/nmage/meshes"
+	"github.com/bloeys/nmage/shaders"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// Storage block binding points used by the culling shader. Cull binds its buffers here, so instance data
+// using the same points must be bound again after culling
+const (
+	CullBindPoint_Bounds   uint32 = 0
+	CullBindPoint_Visible  uint32 = 1
+	CullBindPoint_Commands uint32 = 2
+)
+
+const cullGroupSize = 64
+
+const cullShader = `
+#version 430
+layout(local_size_x = 64) in;
+
+struct DrawCommand {
+    uint count;
+    uint instanceCount;
+    uint firstIndex;
+    int baseVertex;
+    uint baseInstance;
+};
+
+// xyz is the world space center of the instance's bounding sphere, and w its radius
+layout(std430, binding=0) readonly buffer Bounds { vec4 bounds[]; };
+layout(std430, binding=1) writeonly buffer Visible { uint visibleInstances[]; };
+layout(std430, binding=2) buffer Commands { DrawCommand commands[]; };
+
+uniform uint instanceCount;
+uniform uint commandCount;
+uniform vec4 frustumPlanes[6];
+
+uniform int useHiZ;
+uniform mat4 viewMat;
+uniform mat4 projMat;
+uniform sampler2D hizTex;
+uniform ivec2 hizSize;
+uniform int hizMipCount;
+
+bool isOccluded(vec3 center, float radius)
+{
+    // The camera looks down -Z, so the point of the sphere nearest to the camera has the biggest z
+    vec3 viewCenter = (viewMat * vec4(center, 1)).xyz;
+    float nearZ = viewCenter.z + radius;
+
+    // Spheres around the camera can't be projected, and are always visible anyway
+    if (nearZ >= -0.0001)
+        return false;
+
+    // The sphere's view space box is projected at its nearest depth, which gives a rect that contains the
+    // sphere on screen, so the test stays conservative
+    vec2 scale = vec2(projMat[0][0], projMat[1][1]) / -nearZ;
+    vec2 uvMin = clamp((viewCenter.xy - radius) * scale * 0.5 + 0.5, 0.0, 1.0);
+    vec2 uvMax = clamp((viewCenter.xy + radius) * scale * 0.5 + 0.5, 0.0, 1.0);
+
+    vec4 nearClip = projMat * vec4(viewCenter.xy, nearZ, 1);
+    float sphereDepth = nearClip.z / nearClip.w * 0.5 + 0.5;
+
+    // At this mip the rect is at most one texel wide, so it touches at most 2x2 texels
+    vec2 rectSize = (uvMax - uvMin) * vec2(hizSize);
+    int level = clamp(int(ceil(log2(max(max(rectSize.x, rectSize.y), 1.0)))), 0, hizMipCount - 1);
+
+    ivec2 levelSize = max(hizSize >> level, ivec2(1));
+    ivec2 minTexel = clamp(ivec2(uvMin * vec2(levelSize)), ivec2(0), levelSize - 1);
+    ivec2 maxTexel = clamp(ivec2(uvMax * vec2(levelSize)), ivec2(0), levelSize - 1);
+
+    float farthest = max(
+        max(texelFetch(hizTex, minTexel, level).r, texelFetch(hizTex, ivec2(maxTexel.x, minTexel.y), level).r),
+        max(texelFetch(hizTex, ivec2(minTexel.x, maxTexel.y), level).r, texelFetch(hizTex, maxTexel, level).r)
+    );
+
+    return sphereDepth > farthest;
+}
+
+void main()
+{
+    uint i = gl_GlobalInvocationID.x;
+    if (i >= instanceCount)
+        return;
+
+    vec4 b = bounds[i];
+    for (int p = 0; p < 6; p++)
+    {
+        if (dot(frustumPlanes[p].xyz, b.xyz) + frustumPlanes[p].w < -b.w)
+            return;
+    }
+
+    if (useHiZ != 0 && isOccluded(b.xyz, b.w))
+        return;
+
+    // All submeshes draw the same instances, so the first command hands out the slots and the rest just count
+    uint slot = atomicAdd(commands[0].instanceCount, 1);
+    for (uint c = 1; c < commandCount; c++)
+        atomicAdd(commands[c].instanceCount, 1);
+
+    visibleInstances[slot] = i;
+}
+`
+
+// GpuCuller tests the bounding sphere of every instance against the camera frustum, and optionally a HiZBuffer,
+// in a compute shader. The instances that pass are compacted into Visible and counted in the commands of Indirect,
+// which is then drawn with Render.DrawMeshIndirect.
+//
+// Since only visible instances are drawn, the vertex shader finds the instance data through Visible:
+//
+//	layout(std430, binding=1) readonly buffer Visible { uint visibleInstances[]; };
+//	...
+//	InstanceData inst = instances[visibleInstances[gl_InstanceID]];
+type GpuCuller struct {
+	// Indirect has one command per submesh of the mesh passed to SetMesh
+	Indirect buffers.IndirectBuffer
+	// Visible has the index of every instance that passed the last Cull
+	Visible buffers.StorageBuffer
+	// Bounds has a vec4 per instance, where xyz is the world space center of its bounding sphere and w the radius
+	Bounds buffers.StorageBuffer
+
+	// InstanceCount is the number of bounds written by the last SetBounds
+	InstanceCount uint32
+
+	cmds []buffers.DrawElementsIndirectCommand
+	prog shaders.ShaderProgram
+
+	instanceCountLoc int32
+	commandCountLoc  int32
+	frustumPlanesLoc int32
+	useHiZLoc        int32
+	viewMatLoc       int32
+	projMatLoc       int32
+	hizSizeLoc       int32
+	hizMipCountLoc   int32
+}
+
+// SetMesh sets the mesh whose submeshes get a draw command each
+func (c *GpuCuller) SetMesh(mesh *meshes.Mesh) {
+
+	c.cmds = c.cmds[:0]
+	for i := 0; i < len(mesh.SubMeshes); i++ {
+		sm := &mesh.SubMeshes[i]
+		c.cmds = append(c.cmds, buffers.DrawElementsIndirectCommand{
+			Count:      uint32(sm.IndexCount),
+			FirstIndex: sm.BaseIndex,
+			BaseVertex: sm.BaseVertex,
+		})
+	}
+
+	c.Indirect.SetCommands(c.cmds)
+}
+
+// SetBounds uploads the bounding spheres of all instances (see Bounds). It only needs calling when instances move
+func (c *GpuCuller) SetBounds(bounds []gglm.Vec4) {
+
+	c.InstanceCount = uint32(len(bounds))
+	if len(bounds) == 0 {
+		return
+	}
+
+	c.Bounds.SetData(unsafe.Slice((*byte)(unsafe.Pointer(&bounds[0].Data[0])), len(bounds)*16))
+
+	visibleSize := uint32(len(bounds)) * 4
+	if visibleSize > c.Visible.Size {
+		c.Visible.SetData(make([]byte, visibleSize))
+	}
+}
+
+// Cull resets the instance counts of the commands then culls all instances. hiz is optional, and when set it must be
+// built from a depth buffer rendered with the same projection.
+//
+// Cull binds its buffers to the CullBindPoint_* storage binding points, and ends with a barrier so the following
+// indirect draws and storage reads see its results
+func (c *GpuCuller) Cull(viewMat, projMat *gglm.Mat4, hiz *HiZBuffer) {
+
+	// Instance counts are reset by uploading the commands again, which the GPU orders before the dispatch
+	c.Indirect.SetCommands(c.cmds)
+	c.Indirect.UnBind()
+
+	if c.InstanceCount == 0 || len(c.cmds) == 0 {
+		return
+	}
+
+	projViewMat := gglm.MulMat4(projMat, viewMat)
+	frustum := camera.NewFrustum(&projViewMat)
+
+	gl.ProgramUniform1ui(c.prog.Id, c.instanceCountLoc, c.InstanceCount)
+	gl.ProgramUniform1ui(c.prog.Id, c.commandCountLoc, uint32(len(c.cmds)))
+	gl.ProgramUniform4fv(c.prog.Id, c.frustumPlanesLoc, int32(len(frustum.Planes)), &frustum.Planes[0].Data[0])
+
+	if hiz != nil {
+		gl.ProgramUniform1i(c.prog.Id, c.useHiZLoc, 1)
+		gl.ProgramUniformMatrix4fv(c.prog.Id, c.viewMatLoc, 1, false, &viewMat.Data[0][0])
+		gl.ProgramUniformMatrix4fv(c.prog.Id, c.projMatLoc, 1, false, &projMat.Data[0][0])
+		gl.ProgramUniform2i(c.prog.Id, c.hizSizeLoc, hiz.Width, hiz.Height)
+		gl.ProgramUniform1i(c.prog.Id, c.hizMipCountLoc, hiz.MipCount)
+
+		gl.ActiveTexture(gl.TEXTURE0 + cullTextureUnit)
+		gl.BindTexture(gl.TEXTURE_2D, hiz.TexID)
+	} else {
+		gl.ProgramUniform1i(c.prog.Id, c.useHiZLoc, 0)
+	}
+
+	c.Bounds.BindBase(CullBindPoint_Bounds)
+	c.Visible.BindBase(CullBindPoint_Visible)
+	c.Indirect.BindBase(CullBindPoint_Commands)
+
+	c.prog.Dispatch((c.InstanceCount+cullGroupSize-1)/cullGroupSize, 1, 1)
+	gl.MemoryBarrier(gl.COMMAND_BARRIER_BIT | gl.SHADER_STORAGE_BARRIER_BIT)
+
+	if hiz != nil {
+		gl.BindTexture(gl.TEXTURE_2D, 0)
+	}
+}
+
+// BindVisible binds Visible to the storage binding point the drawing shader reads it from
+func (c *GpuCuller) BindVisible(bindPointIndex uint32) {
+	c.Visible.BindBase(bindPointIndex)
+}
+
+func (c *GpuCuller) Delete() {
+
+	c.Indirect.Delete()
+	c.Visible.Delete()
+	c.Bounds.Delete()
+
+	leakcheck.Untrack(leakcheck.ResourceType_Program, c.prog.Id)
+	gl.DeleteProgram(c.prog.Id)
+	c.prog.Id = 0
+}
+
+// NewGpuCuller creates a culler with room for capacity instances. Returns an error if compute shaders or storage buffers aren't supported
+func NewGpuCuller(capacity uint32) (GpuCuller, error) {
+
+	if !buffers.IsStorageBufferSupported() {
+		return GpuCuller{}, errors.New("failed to create GPU culler because shader storage buffers need OpenGL 4.3 or GL_ARB_shader_storage_buffer_object")
+	}
+
+	prog, err := shaders.LoadAndCompileComputeShaderSrc([]byte(cullShader))
+	if err != nil {
+		return GpuCuller{}, fmt.Errorf("failed to create GPU culler. Err: %w", err)
+	}
+
+	c := GpuCuller{
+		prog:             prog,
+		instanceCountLoc: gl.GetUniformLocation(prog.Id, gl.Str("instanceCount\x00")),
+		commandCountLoc:  gl.GetUniformLocation(prog.Id, gl.Str("commandCount\x00")),
+		frustumPlanesLoc: gl.GetUniformLocation(prog.Id, gl.Str("frustumPlanes\x00")),
+		useHiZLoc:        gl.GetUniformLocation(prog.Id, gl.Str("useHiZ\x00")),
+		viewMatLoc:       gl.GetUniformLocation(prog.Id, gl.Str("viewMat\x00")),
+		projMatLoc:       gl.GetUniformLocation(prog.Id, gl.Str("projMat\x00")),
+		hizSizeLoc:       gl.GetUniformLocation(prog.Id, gl.Str("hizSize\x00")),
+		hizMipCountLoc:   gl.GetUniformLocation(prog.Id, gl.Str("hizMipCount\x00")),
+	}
+	gl.ProgramUniform1i(prog.Id, gl.GetUniformLocation(prog.Id, gl.Str("hizTex\x00")), cullTextureUnit)
+
+	c.Indirect, err = buffers.NewIndirectBuffer(1, buffers.BufUsage_Dynamic_Draw)
+	if err != nil {
+		c.Delete()
+		return GpuCuller{}, fmt.Errorf("failed to create GPU culler. Err: %w", err)
+	}
+
+	c.Bounds, err = buffers.NewStorageBuffer(max(capacity, 1)*16, buffers.BufUsage_Dynamic_Draw)
+	if err != nil {
+		c.Delete()
+		return GpuCuller{}, fmt.Errorf("failed to create GPU culler. Err: %w", err)
+	}
+
+	c.Visible, err = buffers.NewStorageBuffer(max(capacity, 1)*4, buffers.BufUsage_Dynamic_Copy)
+	if err != nil {
+		c.Delete()
+		return GpuCuller{}, fmt.Errorf("failed to create GPU culler. Err: %w", err)
+	}
+
+	return c, nil
+}
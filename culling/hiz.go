@@ -0,0 +1,174 @@
+package culling
+
+import (
+	"fmt"
+	"math/bits"
+
+	"github.com/bloeys/nmage/leakcheck"
+	"github.com/bloeys/nmage/logging"
+	"github.com/bloeys/nmage/shaders"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// cullTextureUnit is the texture unit the culling shaders sample from. Compute shaders are only guaranteed 16 units,
+// and this one isn't used by materials, so the renderer's bound material stays valid
+const cullTextureUnit = 4
+
+const hizShader = `
+#version 430
+layout(local_size_x = 8, local_size_y = 8) in;
+
+uniform int isFirstLevel;
+uniform sampler2D depthTex;
+
+layout(r32f) readonly uniform image2D srcLevel;
+layout(r32f) writeonly uniform image2D dstLevel;
+
+float loadSrc(ivec2 coord, ivec2 srcSize) {
+    return imageLoad(srcLevel, min(coord, srcSize - 1)).r;
+}
+
+void main()
+{
+    ivec2 coord = ivec2(gl_GlobalInvocationID.xy);
+    ivec2 dstSize = imageSize(dstLevel);
+    if (coord.x >= dstSize.x || coord.y >= dstSize.y)
+        return;
+
+    if (isFirstLevel != 0)
+    {
+        imageStore(dstLevel, coord, vec4(texelFetch(depthTex, coord, 0).r));
+        return;
+    }
+
+    // Each texel keeps the farthest depth of the texels under it, so a test against it never hides something visible
+    ivec2 srcSize = imageSize(srcLevel);
+    ivec2 base = coord * 2;
+    float d = max(
+        max(loadSrc(base, srcSize), loadSrc(base + ivec2(1, 0), srcSize)),
+        max(loadSrc(base + ivec2(0, 1), srcSize), loadSrc(base + ivec2(1, 1), srcSize))
+    );
+
+    // With odd sizes the last row and column also cover a third source texel
+    bool extraX = (srcSize.x & 1) != 0 && coord.x == dstSize.x - 1;
+    bool extraY = (srcSize.y & 1) != 0 && coord.y == dstSize.y - 1;
+    if (extraX)
+        d = max(d, max(loadSrc(base + ivec2(2, 0), srcSize), loadSrc(base + ivec2(2, 1), srcSize)));
+    if (extraY)
+        d = max(d, max(loadSrc(base + ivec2(0, 2), srcSize), loadSrc(base + ivec2(1, 2), srcSize)));
+    if (extraX && extraY)
+        d = max(d, loadSrc(base + ivec2(2, 2), srcSize));
+
+    imageStore(dstLevel, coord, vec4(d));
+}
+`
+
+// HiZBuffer is a mip chain of a depth buffer where each texel has the farthest depth of the texels it covers.
+// A bounding volume whose nearest depth is behind a few texels of the right mip is hidden, which is how GpuCuller
+// does occlusion culling.
+//
+// It is usually built from the previous frame's depth, so objects that suddenly come into view may show a frame late
+type HiZBuffer struct {
+	TexID    uint32
+	Width    int32
+	Height   int32
+	MipCount int32
+
+	prog            shaders.ShaderProgram
+	isFirstLevelLoc int32
+}
+
+// LevelSize returns the size of a mip level
+func (h *HiZBuffer) LevelSize(level int32) (width, height int32) {
+	return max(h.Width>>level, 1), max(h.Height>>level, 1)
+}
+
+// Resize reallocates the mip chain, e.g. when the window size changes. The contents are undefined until the next Build
+func (h *HiZBuffer) Resize(width, height int32) {
+
+	h.Width = width
+	h.Height = height
+	h.MipCount = int32(bits.Len32(uint32(max(width, height, 1))))
+
+	gl.BindTexture(gl.TEXTURE_2D, h.TexID)
+	for level := int32(0); level < h.MipCount; level++ {
+		w, hgt := h.LevelSize(level)
+		gl.TexImage2D(gl.TEXTURE_2D, level, gl.R32F, w, hgt, 0, gl.RED, gl.FLOAT, nil)
+	}
+
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_BASE_LEVEL, 0)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAX_LEVEL, h.MipCount-1)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST_MIPMAP_NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+}
+
+// Build fills the mip chain from a depth texture of the same size as the buffer. The depth texture must not
+// have a compare mode set (i.e. not be a shadow map), since its depth is read as a float
+func (h *HiZBuffer) Build(depthTexID uint32) {
+
+	gl.ActiveTexture(gl.TEXTURE0 + cullTextureUnit)
+	gl.BindTexture(gl.TEXTURE_2D, depthTexID)
+
+	for level := int32(0); level < h.MipCount; level++ {
+
+		isFirstLevel := int32(0)
+		if level == 0 {
+			isFirstLevel = 1
+		}
+		gl.ProgramUniform1i(h.prog.Id, h.isFirstLevelLoc, isFirstLevel)
+
+		// Level zero doesn't read the source image, but the unit still gets a valid level
+		gl.BindImageTexture(0, h.TexID, max(level-1, 0), false, 0, gl.READ_ONLY, gl.R32F)
+		gl.BindImageTexture(1, h.TexID, level, false, 0, gl.WRITE_ONLY, gl.R32F)
+
+		w, hgt := h.LevelSize(level)
+		h.prog.Dispatch(uint32(w+7)/8, uint32(hgt+7)/8, 1)
+
+		// The next level reads what this one wrote
+		gl.MemoryBarrier(gl.SHADER_IMAGE_ACCESS_BARRIER_BIT)
+	}
+
+	gl.MemoryBarrier(gl.TEXTURE_FETCH_BARRIER_BIT)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+}
+
+func (h *HiZBuffer) Delete() {
+
+	leakcheck.Untrack(leakcheck.ResourceType_Texture, h.TexID)
+	gl.DeleteTextures(1, &h.TexID)
+	h.TexID = 0
+
+	leakcheck.Untrack(leakcheck.ResourceType_Program, h.prog.Id)
+	gl.DeleteProgram(h.prog.Id)
+	h.prog.Id = 0
+}
+
+// NewHiZBuffer creates a HiZ buffer for a depth buffer of width*height. Returns an error if compute shaders aren't supported
+func NewHiZBuffer(width, height int32) (HiZBuffer, error) {
+
+	prog, err := shaders.LoadAndCompileComputeShaderSrc([]byte(hizShader))
+	if err != nil {
+		return HiZBuffer{}, fmt.Errorf("failed to create HiZ buffer. Err: %w", err)
+	}
+
+	h := HiZBuffer{
+		prog:            prog,
+		isFirstLevelLoc: gl.GetUniformLocation(prog.Id, gl.Str("isFirstLevel\x00")),
+	}
+
+	gl.ProgramUniform1i(prog.Id, gl.GetUniformLocation(prog.Id, gl.Str("depthTex\x00")), cullTextureUnit)
+	gl.ProgramUniform1i(prog.Id, gl.GetUniformLocation(prog.Id, gl.Str("srcLevel\x00")), 0)
+	gl.ProgramUniform1i(prog.Id, gl.GetUniformLocation(prog.Id, gl.Str("dstLevel\x00")), 1)
+
+	gl.GenTextures(1, &h.TexID)
+	leakcheck.Track(leakcheck.ResourceType_Texture, h.TexID)
+	if h.TexID == 0 {
+		logging.ErrLog.Panicln("Failed to create OpenGL texture for a HiZ buffer")
+	}
+
+	h.Resize(width, height)
+	return h, nil
+}
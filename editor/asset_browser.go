@@ -0,0 +1,311 @@
+package editor
+
+import (
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	imgui "github.com/AllenDang/cimgui-go"
+	"github.com/bloeys/nmage/assets"
+	"github.com/bloeys/nmage/materials"
+	"github.com/bloeys/nmage/meshes"
+	nmageimgui "github.com/bloeys/nmage/ui/imgui"
+)
+
+// AssetDragDropType is the imgui drag and drop payload type of assets. The payload has no data,
+// and the dragged asset is returned by AssetBrowser.DraggedAsset
+const AssetDragDropType = "NMAGE_ASSET"
+
+type AssetType int32
+
+const (
+	AssetType_Unknown AssetType = iota
+	AssetType_Texture
+	AssetType_Material
+	AssetType_Mesh
+)
+
+func (at AssetType) String() string {
+	switch at {
+	case AssetType_Texture:
+		return "Texture"
+	case AssetType_Material:
+		return "Material"
+	case AssetType_Mesh:
+		return "Mesh"
+	default:
+		return "Unknown"
+	}
+}
+
+// assetTypeFilterItems are the items of the type filter combo, where the index is the AssetType and unknown shows all types
+const assetTypeFilterItems = "All\x00Textures\x00Materials\x00Meshes\x00"
+
+type AssetEntry struct {
+	Name string
+
+	// Path is empty for assets that weren't loaded from disk
+	Path string
+	Type AssetType
+
+	// ThumbnailTex is the GL texture drawn as the thumbnail. Zero draws the type name instead
+	ThumbnailTex uint32
+
+	// Asset is a pointer to the asset, like a *meshes.Mesh
+	Asset any
+}
+
+// AssetBrowser is an imgui window that shows assets as a grid of thumbnails that can be searched and filtered by type.
+//
+// Assets can be dragged into the scene, which is any place outside of imgui windows, or onto anything that accepts AssetDragDropType
+type AssetBrowser struct {
+	Assets []AssetEntry
+
+	ThumbnailSize float32
+	Search        string
+	TypeFilter    AssetType
+
+	// OnInstantiate is called when an asset is dropped into the scene, with the screen position of the mouse so it can be
+	// placed under the cursor
+	OnInstantiate func(a *AssetEntry, screenPos imgui.Vec2)
+
+	// dragged is the index of the last dragged asset, which stays valid after the drag so drop targets drawn later can use it
+	dragged    int
+	isDragging bool
+}
+
+// Add adds an asset, or updates it if an entry with the same asset already exists
+func (ab *AssetBrowser) Add(a AssetEntry) {
+
+	index := ab.indexOf(a.Asset)
+	if index == -1 {
+		ab.Assets = append(ab.Assets, a)
+		return
+	}
+
+	ab.Assets[index] = a
+}
+
+func (ab *AssetBrowser) AddTexture(tex *assets.Texture) {
+
+	name := "Texture " + strconv.FormatUint(uint64(tex.TexID), 10)
+	if tex.Path != "" {
+		name = filepath.Base(tex.Path)
+	}
+
+	entry := AssetEntry{
+		Name:         name,
+		Path:         tex.Path,
+		Type:         AssetType_Texture,
+		ThumbnailTex: tex.TexID,
+		Asset:        tex,
+	}
+
+	// Textures are copied around by value, so the same texture can come from different pointers
+	index := slices.IndexFunc(ab.Assets, func(a AssetEntry) bool { return a.Type == AssetType_Texture && a.ThumbnailTex == tex.TexID })
+	if index == -1 {
+		ab.Assets = append(ab.Assets, entry)
+		return
+	}
+
+	ab.Assets[index] = entry
+}
+
+// AddMaterial adds a material, using its diffuse texture as the thumbnail
+func (ab *AssetBrowser) AddMaterial(mat *materials.Material) {
+	ab.Add(AssetEntry{
+		Name:         mat.Name,
+		Type:         AssetType_Material,
+		ThumbnailTex: mat.DiffuseTex,
+		Asset:        mat,
+	})
+}
+
+func (ab *AssetBrowser) AddMesh(mesh *meshes.Mesh) {
+	ab.Add(AssetEntry{
+		Name:  mesh.Name,
+		Type:  AssetType_Mesh,
+		Asset: mesh,
+	})
+}
+
+// AddCachedTextures adds every texture in the assets texture cache, then sorts the assets by type and name
+func (ab *AssetBrowser) AddCachedTextures() {
+
+	for _, tex := range assets.Textures {
+		ab.AddTexture(&tex)
+	}
+
+	slices.SortStableFunc(ab.Assets, func(a, b AssetEntry) int {
+		if a.Type != b.Type {
+			return int(a.Type) - int(b.Type)
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+// Remove removes the entry of asset, which should be called before the asset is deleted
+func (ab *AssetBrowser) Remove(asset any) {
+
+	index := ab.indexOf(asset)
+	if index == -1 {
+		return
+	}
+
+	ab.Assets = slices.Delete(ab.Assets, index, index+1)
+	ab.dragged = -1
+	ab.isDragging = false
+}
+
+func (ab *AssetBrowser) indexOf(asset any) int {
+	return slices.IndexFunc(ab.Assets, func(a AssetEntry) bool { return a.Asset == asset })
+}
+
+// DraggedAsset returns the asset being dragged, or the last dragged asset after it was dropped. Returns nil if nothing was dragged yet
+func (ab *AssetBrowser) DraggedAsset() *AssetEntry {
+
+	if ab.dragged < 0 || ab.dragged >= len(ab.Assets) {
+		return nil
+	}
+
+	return &ab.Assets[ab.dragged]
+}
+
+// Draw shows the browser window. Must be called between FrameStart and Render
+func (ab *AssetBrowser) Draw(title string) {
+
+	wasDragging := ab.isDragging
+	ab.isDragging = false
+
+	imgui.Begin(title)
+
+	imgui.SetNextItemWidth(imgui.ContentRegionAvail().X * 0.6)
+	imgui.InputTextWithHint("##asset_search", "Search", &ab.Search, 0, nil)
+
+	imgui.SameLine()
+	imgui.SetNextItemWidth(-1)
+	filter := int32(ab.TypeFilter)
+	if imgui.ComboStr("##asset_type_filter", &filter, assetTypeFilterItems) {
+		ab.TypeFilter = AssetType(filter)
+	}
+
+	imgui.Separator()
+
+	cellWidth := ab.ThumbnailSize + imgui.CurrentStyle().ItemSpacing().X*2
+	columns := max(int(imgui.ContentRegionAvail().X/cellWidth), 1)
+
+	search := strings.ToLower(ab.Search)
+	shownCount := 0
+	for i := 0; i < len(ab.Assets); i++ {
+
+		a := &ab.Assets[i]
+		if !ab.isShown(a, search) {
+			continue
+		}
+
+		if shownCount%columns != 0 {
+			imgui.SameLine()
+		}
+		shownCount++
+
+		ab.drawAsset(i)
+	}
+
+	if shownCount == 0 {
+		imgui.TextDisabled("No assets")
+	}
+
+	imgui.End()
+
+	// The drag was released this frame, so if it wasn't over any window it was dropped into the scene
+	if wasDragging && !ab.isDragging && imgui.IsMouseReleased(imgui.MouseButtonLeft) && !imgui.IsWindowHoveredV(imgui.HoveredFlagsAnyWindow) {
+
+		a := ab.DraggedAsset()
+		if a != nil && ab.OnInstantiate != nil {
+			ab.OnInstantiate(a, imgui.MousePos())
+		}
+	}
+}
+
+func (ab *AssetBrowser) isShown(a *AssetEntry, lowerSearch string) bool {
+
+	if ab.TypeFilter != AssetType_Unknown && a.Type != ab.TypeFilter {
+		return false
+	}
+
+	if lowerSearch == "" {
+		return true
+	}
+
+	return strings.Contains(strings.ToLower(a.Name), lowerSearch) || strings.Contains(strings.ToLower(a.Path), lowerSearch)
+}
+
+func (ab *AssetBrowser) drawAsset(index int) {
+
+	a := &ab.Assets[index]
+	thumbSize := imgui.Vec2{X: ab.ThumbnailSize, Y: ab.ThumbnailSize}
+
+	imgui.PushIDInt(int32(index))
+	imgui.BeginGroup()
+
+	// Textures are stored bottom up, so the uvs are flipped to show them the right way
+	if a.ThumbnailTex != 0 {
+		imgui.ImageButtonV("##thumbnail", nmageimgui.TextureId(a.ThumbnailTex), thumbSize, imgui.Vec2{X: 0, Y: 1}, imgui.Vec2{X: 1, Y: 0}, imgui.Vec4{}, imgui.Vec4{X: 1, Y: 1, Z: 1, W: 1})
+	} else {
+		imgui.ButtonV(a.Type.String(), thumbSize)
+	}
+
+	if imgui.BeginDragDropSource() {
+
+		ab.dragged = index
+		ab.isDragging = true
+
+		imgui.SetDragDropPayload(AssetDragDropType, 0, 0)
+		imgui.Text(a.Name)
+		imgui.EndDragDropSource()
+	}
+
+	if imgui.IsItemHovered() {
+		ab.drawTooltip(a)
+	}
+
+	// Long names are cut to the thumbnail width so the grid stays aligned
+	name := a.Name
+	for len(name) > 1 && imgui.CalcTextSizeV(name, false, -1).X > ab.ThumbnailSize {
+		name = name[:len(name)-1]
+	}
+	imgui.Text(strings.ReplaceAll(name, "%", "%%"))
+
+	imgui.EndGroup()
+	imgui.PopID()
+}
+
+func (ab *AssetBrowser) drawTooltip(a *AssetEntry) {
+
+	sb := strings.Builder{}
+	sb.WriteString(a.Name)
+	sb.WriteString("\nType: ")
+	sb.WriteString(a.Type.String())
+
+	if a.Path != "" {
+		sb.WriteString("\nPath: ")
+		sb.WriteString(a.Path)
+	}
+
+	switch asset := a.Asset.(type) {
+	case *assets.Texture:
+		sb.WriteString("\nSize: " + strconv.Itoa(int(asset.Width)) + "x" + strconv.Itoa(int(asset.Height)))
+	case *meshes.Mesh:
+		sb.WriteString("\nSub meshes: " + strconv.Itoa(len(asset.SubMeshes)))
+	}
+
+	imgui.SetTooltip(strings.ReplaceAll(sb.String(), "%", "%%"))
+}
+
+func NewAssetBrowser() AssetBrowser {
+	return AssetBrowser{
+		ThumbnailSize: 64,
+		dragged:       -1,
+	}
+}
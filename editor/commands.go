@@ -0,0 +1,307 @@
+package editor
+
+import (
+	"reflect"
+
+	"github.com/bloeys/nmage/entity"
+	"github.com/bloeys/nmage/registry"
+)
+
+// Command is an undoable edit. Do is called again on redo, so it must work on the state Undo leaves behind
+type Command interface {
+	Name() string
+	Do()
+	Undo()
+}
+
+// MergeableCommand is a command that can absorb the command executed right after it, so that dragging a slider
+// for many frames becomes a single undo step
+type MergeableCommand interface {
+	Command
+
+	// Merge folds next into this command and returns true, or returns false if the commands can't be merged.
+	// next was already done, so only the state needed by Do and Undo should be updated
+	Merge(next Command) bool
+}
+
+// DefaultMaxCommands is how many commands a CommandStack keeps unless changed
+const DefaultMaxCommands = 256
+
+// CommandStack runs commands and keeps them so they can be undone and redone.
+//
+// Consecutive mergeable commands are merged until EndMerge is called, which editor widgets do when they stop being edited
+type CommandStack struct {
+	// MaxCommands is how many commands can be undone. The oldest commands are dropped once it's reached
+	MaxCommands int
+
+	// OnChanged is called after a command was done, undone or redone
+	OnChanged func(c Command)
+
+	undoStack []Command
+	redoStack []Command
+	canMerge  bool
+}
+
+// Commands is the stack used by the editor panels and Inspect. Games can route their own edits through it so they share one history
+var Commands = NewCommandStack(DefaultMaxCommands)
+
+// Execute does the command and adds it to the undo stack, clearing the redo stack
+func (cs *CommandStack) Execute(c Command) {
+	c.Do()
+	cs.Record(c)
+}
+
+// Record adds a command that was already done to the undo stack, which is useful for widgets that change values directly
+func (cs *CommandStack) Record(c Command) {
+
+	clear(cs.redoStack)
+	cs.redoStack = cs.redoStack[:0]
+
+	if cs.canMerge && len(cs.undoStack) > 0 {
+
+		if mc, ok := cs.undoStack[len(cs.undoStack)-1].(MergeableCommand); ok && mc.Merge(c) {
+			cs.changed(mc)
+			return
+		}
+	}
+
+	if cs.MaxCommands > 0 && len(cs.undoStack) >= cs.MaxCommands {
+		copy(cs.undoStack, cs.undoStack[1:])
+		cs.undoStack[len(cs.undoStack)-1] = nil
+		cs.undoStack = cs.undoStack[:len(cs.undoStack)-1]
+	}
+
+	cs.undoStack = append(cs.undoStack, c)
+	cs.canMerge = true
+	cs.changed(c)
+}
+
+// EndMerge stops the next command from merging into the last one
+func (cs *CommandStack) EndMerge() {
+	cs.canMerge = false
+}
+
+// Undo undoes the last command and returns false if there is nothing to undo
+func (cs *CommandStack) Undo() bool {
+
+	if len(cs.undoStack) == 0 {
+		return false
+	}
+
+	c := cs.undoStack[len(cs.undoStack)-1]
+	cs.undoStack[len(cs.undoStack)-1] = nil
+	cs.undoStack = cs.undoStack[:len(cs.undoStack)-1]
+
+	c.Undo()
+	cs.redoStack = append(cs.redoStack, c)
+	cs.canMerge = false
+	cs.changed(c)
+
+	return true
+}
+
+// Redo does the last undone command again and returns false if there is nothing to redo
+func (cs *CommandStack) Redo() bool {
+
+	if len(cs.redoStack) == 0 {
+		return false
+	}
+
+	c := cs.redoStack[len(cs.redoStack)-1]
+	cs.redoStack[len(cs.redoStack)-1] = nil
+	cs.redoStack = cs.redoStack[:len(cs.redoStack)-1]
+
+	c.Do()
+	cs.undoStack = append(cs.undoStack, c)
+	cs.canMerge = false
+	cs.changed(c)
+
+	return true
+}
+
+func (cs *CommandStack) CanUndo() bool {
+	return len(cs.undoStack) > 0
+}
+
+func (cs *CommandStack) CanRedo() bool {
+	return len(cs.redoStack) > 0
+}
+
+// UndoName returns the name of the command Undo would undo, or an empty string if there is none
+func (cs *CommandStack) UndoName() string {
+
+	if len(cs.undoStack) == 0 {
+		return ""
+	}
+
+	return cs.undoStack[len(cs.undoStack)-1].Name()
+}
+
+// RedoName returns the name of the command Redo would do, or an empty string if there is none
+func (cs *CommandStack) RedoName() string {
+
+	if len(cs.redoStack) == 0 {
+		return ""
+	}
+
+	return cs.redoStack[len(cs.redoStack)-1].Name()
+}
+
+// Clear forgets all commands, which should be done when the edited objects go away, like when loading another scene
+func (cs *CommandStack) Clear() {
+
+	clear(cs.undoStack)
+	clear(cs.redoStack)
+
+	cs.undoStack = cs.undoStack[:0]
+	cs.redoStack = cs.redoStack[:0]
+	cs.canMerge = false
+}
+
+func (cs *CommandStack) changed(c Command) {
+	if cs.OnChanged != nil {
+		cs.OnChanged(c)
+	}
+}
+
+func NewCommandStack(maxCommands int) *CommandStack {
+	return &CommandStack{
+		MaxCommands: maxCommands,
+		undoStack:   make([]Command, 0, 16),
+		redoStack:   make([]Command, 0, 16),
+	}
+}
+
+// FuncCommand is a command made of two functions, for edits that don't deserve their own type
+type FuncCommand struct {
+	Label    string
+	DoFunc   func()
+	UndoFunc func()
+}
+
+var _ Command = &FuncCommand{}
+
+func (fc *FuncCommand) Name() string {
+	return fc.Label
+}
+
+func (fc *FuncCommand) Do() {
+	fc.DoFunc()
+}
+
+func (fc *FuncCommand) Undo() {
+	fc.UndoFunc()
+}
+
+// SetValueCommand sets the value Target points to, and merges with later commands that set the same target
+type SetValueCommand[T any] struct {
+	Label  string
+	Target *T
+	Old    T
+	New    T
+}
+
+var _ MergeableCommand = &SetValueCommand[float32]{}
+
+func (sc *SetValueCommand[T]) Name() string {
+	return sc.Label
+}
+
+func (sc *SetValueCommand[T]) Do() {
+	*sc.Target = sc.New
+}
+
+func (sc *SetValueCommand[T]) Undo() {
+	*sc.Target = sc.Old
+}
+
+func (sc *SetValueCommand[T]) Merge(next Command) bool {
+
+	nextSc, ok := next.(*SetValueCommand[T])
+	if !ok || nextSc.Target != sc.Target {
+		return false
+	}
+
+	sc.New = nextSc.New
+	return true
+}
+
+// NewSetValueCommand returns a command that changes *target from its current value to newVal
+func NewSetValueCommand[T any](label string, target *T, newVal T) *SetValueCommand[T] {
+	return &SetValueCommand[T]{
+		Label:  label,
+		Target: target,
+		Old:    *target,
+		New:    newVal,
+	}
+}
+
+// setReflectValueCommand is how Inspect records edits, since it only has reflect values of the edited fields
+type setReflectValueCommand struct {
+	label  string
+	target reflect.Value
+	old    reflect.Value
+	new    reflect.Value
+}
+
+var _ MergeableCommand = &setReflectValueCommand{}
+
+func (sc *setReflectValueCommand) Name() string {
+	return sc.label
+}
+
+func (sc *setReflectValueCommand) Do() {
+	sc.target.Set(sc.new)
+}
+
+func (sc *setReflectValueCommand) Undo() {
+	sc.target.Set(sc.old)
+}
+
+func (sc *setReflectValueCommand) Merge(next Command) bool {
+
+	nextSc, ok := next.(*setReflectValueCommand)
+	if !ok || nextSc.target.Addr().Pointer() != sc.target.Addr().Pointer() || nextSc.target.Type() != sc.target.Type() {
+		return false
+	}
+
+	sc.new = nextSc.new
+	return true
+}
+
+// copyValue returns a copy of v that doesn't change when v does
+func copyValue(v reflect.Value) reflect.Value {
+	c := reflect.New(v.Type()).Elem()
+	c.Set(v)
+	return c
+}
+
+// SetParentCommand moves an entity under another parent, and undo puts it back at its old place among its siblings
+type SetParentCommand struct {
+	Hierarchy *entity.Hierarchy
+	Handle    registry.Handle
+	NewParent registry.Handle
+
+	oldParent registry.Handle
+	oldIndex  int
+}
+
+var _ Command = &SetParentCommand{}
+
+func (sc *SetParentCommand) Name() string {
+	return "Reparent"
+}
+
+func (sc *SetParentCommand) Do() {
+
+	if n := sc.Hierarchy.Node(sc.Handle); n != nil {
+		sc.oldParent = n.Parent
+		sc.oldIndex = sc.Hierarchy.ChildIndex(sc.Handle)
+	}
+
+	sc.Hierarchy.SetParent(sc.Handle, sc.NewParent)
+}
+
+func (sc *SetParentCommand) Undo() {
+	sc.Hierarchy.SetParentAt(sc.Handle, sc.oldParent, sc.oldIndex)
+}
@@ -0,0 +1,225 @@
+package editor
+
+import (
+	"strconv"
+
+	imgui "github.com/AllenDang/cimgui-go"
+	"github.com/bloeys/nmage/entity"
+	"github.com/bloeys/nmage/registry"
+)
+
+// EntityDragDropType is the imgui drag and drop payload type of entities. The payload has no data,
+// and the dragged entity is the primary selection
+const EntityDragDropType = "NMAGE_ENTITY"
+
+// HierarchyPanel is an imgui window that shows an entity hierarchy as a tree, where entities can be selected,
+// dragged onto other entities to reparent them, and created or deleted from the right click menu.
+//
+// Reparenting goes through Commands so it can be undone. Creating and deleting can't be undone since the entities live in the game's registry
+type HierarchyPanel struct {
+	Hierarchy *entity.Hierarchy
+	Selection *Selection
+
+	// OnCreate is called from the right click menu, and should create an entity in its registry and add it to the hierarchy under parent (zero for a root)
+	OnCreate func(parent registry.Handle)
+
+	// OnDelete is called for every deleted entity after it was removed from the hierarchy, with children before their parents,
+	// and should free the entity from its registry
+	OnDelete func(handle registry.Handle)
+
+	// OnAssetDrop is called when an asset from the asset browser (see AssetDragDropType) is dropped on an entity or the empty space,
+	// and should instantiate the asset under parent (zero for a root)
+	OnAssetDrop func(parent registry.Handle)
+
+	// Changes are applied after the tree is drawn, since they change the slices being drawn
+	pendingCreate       bool
+	pendingCreateParent registry.Handle
+	pendingDelete       registry.Handle
+	pendingReparent     registry.Handle
+	pendingNewParent    registry.Handle
+	pendingAssetDrop    bool
+	pendingAssetParent  registry.Handle
+}
+
+// Draw shows the hierarchy window. Must be called between FrameStart and Render
+func (p *HierarchyPanel) Draw(title string) {
+
+	imgui.Begin(title)
+
+	roots := p.Hierarchy.Roots
+	for i := 0; i < len(roots); i++ {
+		p.drawNode(roots[i])
+	}
+
+	// The empty space below the tree is a drop target that makes the dropped entity a root, and clicking it clears the selection
+	emptySpace := imgui.ContentRegionAvail()
+	emptySpace.Y = max(emptySpace.Y, imgui.FrameHeight())
+	if emptySpace.X > 0 {
+
+		imgui.InvisibleButton("##hierarchy_empty_space", emptySpace)
+		if imgui.IsItemClicked() {
+			p.Selection.Clear()
+		}
+
+		p.entityDropTarget(0)
+	}
+
+	if imgui.BeginPopupContextWindowV("##hierarchy_window_menu", imgui.PopupFlagsMouseButtonRight|imgui.PopupFlagsNoOpenOverItems) {
+
+		if imgui.MenuItemBool("Create Entity") {
+			p.pendingCreate = true
+			p.pendingCreateParent = 0
+		}
+
+		imgui.EndPopup()
+	}
+
+	imgui.End()
+
+	p.applyPendingChanges()
+}
+
+func (p *HierarchyPanel) drawNode(handle registry.Handle) {
+
+	n := p.Hierarchy.Node(handle)
+	if n == nil {
+		return
+	}
+
+	flags := imgui.TreeNodeFlagsOpenOnArrow | imgui.TreeNodeFlagsOpenOnDoubleClick | imgui.TreeNodeFlagsSpanAvailWidth
+	if len(n.Children) == 0 {
+		flags |= imgui.TreeNodeFlagsLeaf
+	}
+
+	if p.Selection.IsSelected(handle) {
+		flags |= imgui.TreeNodeFlagsSelected
+	}
+
+	// Names don't have to be unique, so the handle is the imgui id
+	isOpen := imgui.TreeNodeExStrV(n.Name+"##"+strconv.FormatUint(uint64(handle), 10), flags)
+
+	if imgui.IsItemClicked() && !imgui.IsItemToggledOpen() {
+		if imgui.CurrentIO().KeyCtrl() {
+			p.Selection.Toggle(handle)
+		} else {
+			p.Selection.Select(handle)
+		}
+	}
+
+	if imgui.BeginDragDropSource() {
+
+		// Dragging an unselected entity selects it, so the payload is always the primary selection
+		if !p.Selection.IsSelected(handle) {
+			p.Selection.Select(handle)
+		} else {
+			p.Selection.Add(handle)
+		}
+
+		imgui.SetDragDropPayload(EntityDragDropType, 0, 0)
+		imgui.Text(n.Name)
+		imgui.EndDragDropSource()
+	}
+
+	p.entityDropTarget(handle)
+
+	if imgui.BeginPopupContextItem() {
+
+		if imgui.MenuItemBool("Create Child") {
+			p.pendingCreate = true
+			p.pendingCreateParent = handle
+		}
+
+		if imgui.MenuItemBool("Delete") {
+			p.pendingDelete = handle
+		}
+
+		imgui.EndPopup()
+	}
+
+	if !isOpen {
+		return
+	}
+
+	for i := 0; i < len(n.Children); i++ {
+		p.drawNode(n.Children[i])
+	}
+
+	imgui.TreePop()
+}
+
+// entityDropTarget makes the last item accept dragged entities, which are moved under parent, and dragged assets, which are instantiated under parent
+func (p *HierarchyPanel) entityDropTarget(parent registry.Handle) {
+
+	if !imgui.BeginDragDropTarget() {
+		return
+	}
+
+	if imgui.AcceptDragDropPayload(EntityDragDropType) != nil {
+		p.pendingReparent = p.Selection.Primary()
+		p.pendingNewParent = parent
+	}
+
+	if p.OnAssetDrop != nil && imgui.AcceptDragDropPayload(AssetDragDropType) != nil {
+		p.pendingAssetDrop = true
+		p.pendingAssetParent = parent
+	}
+
+	imgui.EndDragDropTarget()
+}
+
+func (p *HierarchyPanel) applyPendingChanges() {
+
+	if !p.pendingReparent.IsZero() {
+
+		// Invalid moves aren't recorded so they don't show up as undo steps
+		if p.pendingReparent != p.pendingNewParent && !p.Hierarchy.IsAncestor(p.pendingReparent, p.pendingNewParent) {
+			Commands.Execute(&SetParentCommand{
+				Hierarchy: p.Hierarchy,
+				Handle:    p.pendingReparent,
+				NewParent: p.pendingNewParent,
+			})
+		}
+
+		p.pendingReparent = 0
+		p.pendingNewParent = 0
+	}
+
+	if p.pendingAssetDrop {
+
+		p.OnAssetDrop(p.pendingAssetParent)
+
+		p.pendingAssetDrop = false
+		p.pendingAssetParent = 0
+	}
+
+	if p.pendingCreate {
+
+		if p.OnCreate != nil {
+			p.OnCreate(p.pendingCreateParent)
+		}
+
+		p.pendingCreate = false
+		p.pendingCreateParent = 0
+	}
+
+	if !p.pendingDelete.IsZero() {
+
+		removed := p.Hierarchy.Remove(p.pendingDelete)
+		for i := 0; i < len(removed); i++ {
+
+			p.Selection.Remove(removed[i])
+			if p.OnDelete != nil {
+				p.OnDelete(removed[i])
+			}
+		}
+
+		p.pendingDelete = 0
+	}
+}
+
+func NewHierarchyPanel(hierarchy *entity.Hierarchy, selection *Selection) HierarchyPanel {
+	return HierarchyPanel{
+		Hierarchy: hierarchy,
+		Selection: selection,
+	}
+}
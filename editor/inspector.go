@@ -0,0 +1,414 @@
+package editor
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	imgui "github.com/AllenDang/cimgui-go"
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/color"
+	"github.com/bloeys/nmage/entity"
+	"github.com/bloeys/nmage/registry"
+	nmageimgui "github.com/bloeys/nmage/ui/imgui"
+)
+
+// fieldTags are the options of the 'editor' struct tag, which is a comma separated list like:
+//
+//	Density float32 `editor:"min=0,max=1,speed=0.001"`
+//	Mode    FogMode `editor:"label=Fog Mode,enum=None|Linear|Exp|Exp2"`
+//	Color   color.Color `editor:"rgb"`
+//	Cache   []byte `editor:"-"`
+//
+// Options:
+//   - '-' hides the field
+//   - 'label' replaces the label, which is otherwise the field name split into words
+//   - 'min', 'max' and 'speed' control numbers and vectors. Min and max are only used when both are set
+//   - 'format' is the printf format of floats and vectors, which defaults to '%.3f'
+//   - 'enum' shows an integer as a combo box of the names, where the first name is zero
+//   - 'rgb' edits a color.Color without its alpha
+//   - 'readonly' shows the value without editing it
+type fieldTags struct {
+	hidden   bool
+	readOnly bool
+	rgb      bool
+
+	label  string
+	format string
+	speed  float32
+	min    float32
+	max    float32
+
+	// enumItems is the enum names separated by zeros, like imgui.ComboStr wants
+	enumItems string
+}
+
+func parseFieldTags(tag string) fieldTags {
+
+	tags := fieldTags{}
+	if tag == "" {
+		return tags
+	}
+
+	for _, opt := range strings.Split(tag, ",") {
+
+		key, val, _ := strings.Cut(strings.TrimSpace(opt), "=")
+		switch key {
+		case "-":
+			tags.hidden = true
+		case "readonly":
+			tags.readOnly = true
+		case "rgb":
+			tags.rgb = true
+		case "label":
+			tags.label = val
+		case "format":
+			tags.format = val
+		case "enum":
+			tags.enumItems = strings.ReplaceAll(val, "|", "\x00") + "\x00"
+		case "speed", "min", "max":
+			f, err := strconv.ParseFloat(val, 32)
+			if err != nil {
+				continue
+			}
+
+			if key == "speed" {
+				tags.speed = float32(f)
+			} else if key == "min" {
+				tags.min = float32(f)
+			} else {
+				tags.max = float32(f)
+			}
+		}
+	}
+
+	return tags
+}
+
+// Inspect shows imgui widgets for the exported fields of the struct that ptr points to, and returns true if a field was changed.
+// Widgets are picked from the field types, and can be customized with the 'editor' struct tag (see fieldTags).
+// Embedded structs are shown as part of the outer struct, while other structs get their own tree node. Edits are recorded in Commands.
+//
+// Must be called between FrameStart and Render
+func Inspect(ptr any) bool {
+
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		imgui.TextDisabled("nil")
+		return false
+	}
+
+	v = v.Elem()
+	if v.Kind() != reflect.Struct {
+		return inspectValue("Value", v, fieldTags{})
+	}
+
+	return inspectStructFields(v)
+}
+
+func inspectStructFields(v reflect.Value) bool {
+
+	changed := false
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		tags := parseFieldTags(f.Tag.Get("editor"))
+		if tags.hidden {
+			continue
+		}
+
+		fv := v.Field(i)
+		if f.Anonymous && fv.Kind() == reflect.Struct && !isSpecialType(fv.Type()) {
+			changed = inspectStructFields(fv) || changed
+			continue
+		}
+
+		label := tags.label
+		if label == "" {
+			label = labelFromFieldName(f.Name)
+		}
+
+		imgui.PushIDStr(f.Name)
+		changed = inspectValue(label, fv, tags) || changed
+		imgui.PopID()
+	}
+
+	return changed
+}
+
+var (
+	colorType  = reflect.TypeFor[color.Color]()
+	vec2Type   = reflect.TypeFor[gglm.Vec2]()
+	vec3Type   = reflect.TypeFor[gglm.Vec3]()
+	vec4Type   = reflect.TypeFor[gglm.Vec4]()
+	quatType   = reflect.TypeFor[gglm.Quat]()
+	handleType = reflect.TypeFor[registry.Handle]()
+)
+
+// isSpecialType reports whether the type has its own widget rather than being shown field by field
+func isSpecialType(t reflect.Type) bool {
+	return t == colorType || t == vec2Type || t == vec3Type || t == vec4Type || t == quatType || t == handleType
+}
+
+// inspectValue shows the widget of the value and records edits of single values in Commands, so they can be undone.
+// Edits merge into one command until the widget is released, so a slider drag is one undo step
+func inspectValue(label string, v reflect.Value, tags fieldTags) bool {
+
+	if !isSingleValue(v) || tags.readOnly || !v.CanSet() {
+		return inspectWidget(label, v, tags)
+	}
+
+	old := copyValue(v)
+	changed := inspectWidget(label, v, tags)
+	if changed {
+		Commands.Record(&setReflectValueCommand{
+			label:  "Edit " + label,
+			target: v,
+			old:    old,
+			new:    copyValue(v),
+		})
+	}
+
+	if imgui.IsItemDeactivated() {
+		Commands.EndMerge()
+	}
+
+	return changed
+}
+
+// isSingleValue reports whether the value is shown as one widget, rather than a tree of other values
+func isSingleValue(v reflect.Value) bool {
+
+	if isSpecialType(v.Type()) {
+		return true
+	}
+
+	switch v.Kind() {
+	case reflect.Struct, reflect.Array, reflect.Slice, reflect.Pointer:
+		return false
+	default:
+		return true
+	}
+}
+
+func inspectWidget(label string, v reflect.Value, tags fieldTags) bool {
+
+	if tags.readOnly || !v.CanSet() {
+		imgui.LabelText(label, strings.ReplaceAll(fmt.Sprint(v.Interface()), "%", "%%"))
+		return false
+	}
+
+	speed := tags.speed
+	intSpeed := tags.speed
+	if speed == 0 {
+		speed = 0.01
+		intSpeed = 0.1
+	}
+
+	format := tags.format
+	if format == "" {
+		format = "%.3f"
+	}
+
+	// Imgui only clamps when min < max
+	switch v.Type() {
+	case colorType:
+		c := v.Addr().Interface().(*color.Color)
+		if tags.rgb {
+			return nmageimgui.ColorEdit3(label, c)
+		}
+		return nmageimgui.ColorEdit4(label, c)
+	case vec2Type:
+		return imgui.DragFloat2V(label, &v.Addr().Interface().(*gglm.Vec2).Data, speed, tags.min, tags.max, format, imgui.SliderFlagsNone)
+	case vec3Type:
+		return imgui.DragFloat3V(label, &v.Addr().Interface().(*gglm.Vec3).Data, speed, tags.min, tags.max, format, imgui.SliderFlagsNone)
+	case vec4Type:
+		return imgui.DragFloat4V(label, &v.Addr().Interface().(*gglm.Vec4).Data, speed, tags.min, tags.max, format, imgui.SliderFlagsNone)
+	case quatType:
+		return imgui.DragFloat4V(label, &v.Addr().Interface().(*gglm.Quat).Data, speed, tags.min, tags.max, format, imgui.SliderFlagsNone)
+	case handleType:
+		// Handles are only meaningful to their registry, so editing them would break things
+		imgui.LabelText(label, strconv.FormatUint(uint64(v.Uint()), 10))
+		return false
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		b := v.Bool()
+		if imgui.Checkbox(label, &b) {
+			v.SetBool(b)
+			return true
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := int32(v.Int())
+		if tags.enumItems != "" {
+			if imgui.ComboStr(label, &i, tags.enumItems) {
+				v.SetInt(int64(i))
+				return true
+			}
+			return false
+		}
+
+		if imgui.DragIntV(label, &i, intSpeed, int32(tags.min), int32(tags.max), "%d", imgui.SliderFlagsNone) {
+			v.SetInt(int64(i))
+			return true
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i := int32(v.Uint())
+		minVal, maxVal := max(int32(tags.min), 0), int32(tags.max)
+		if maxVal <= minVal {
+			maxVal = int32(^uint32(0) >> 1)
+		}
+
+		if imgui.DragIntV(label, &i, intSpeed, minVal, maxVal, "%d", imgui.SliderFlagsNone) {
+			v.SetUint(uint64(i))
+			return true
+		}
+
+	case reflect.Float32, reflect.Float64:
+		f := float32(v.Float())
+		if imgui.DragFloatV(label, &f, speed, tags.min, tags.max, format, imgui.SliderFlagsNone) {
+			v.SetFloat(float64(f))
+			return true
+		}
+
+	case reflect.String:
+		s := v.String()
+		if imgui.InputTextWithHint(label, "", &s, imgui.InputTextFlagsNone, nil) {
+			v.SetString(s)
+			return true
+		}
+
+	case reflect.Struct:
+		if !imgui.TreeNodeExStrV(label, imgui.TreeNodeFlagsSpanAvailWidth) {
+			return false
+		}
+
+		changed := inspectStructFields(v)
+		imgui.TreePop()
+		return changed
+
+	case reflect.Array, reflect.Slice:
+		if !imgui.TreeNodeExStrV(fmt.Sprintf("%s [%d]", label, v.Len()), imgui.TreeNodeFlagsSpanAvailWidth) {
+			return false
+		}
+
+		// Elements share the options of the field, except for the label
+		elemTags := tags
+		elemTags.label = ""
+
+		changed := false
+		for i := 0; i < v.Len(); i++ {
+			imgui.PushIDInt(int32(i))
+			changed = inspectValue(strconv.Itoa(i), v.Index(i), elemTags) || changed
+			imgui.PopID()
+		}
+
+		imgui.TreePop()
+		return changed
+
+	case reflect.Pointer:
+		if v.IsNil() {
+			imgui.LabelText(label, "nil")
+			return false
+		}
+
+		return inspectValue(label, v.Elem(), tags)
+
+	default:
+		imgui.LabelText(label, v.Type().String())
+	}
+
+	return false
+}
+
+// labelFromFieldName splits a field name into words, so 'HeightFalloff' becomes 'Height Falloff'
+func labelFromFieldName(name string) string {
+
+	sb := strings.Builder{}
+	runes := []rune(name)
+	for i, r := range runes {
+
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+			sb.WriteByte(' ')
+		}
+
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
+
+// InspectorPanel is an imgui window that shows the name and components of the primary selection using Inspect
+type InspectorPanel struct {
+	Entities  *registry.Registry[entity.CompContainer]
+	Hierarchy *entity.Hierarchy
+	Selection *Selection
+
+	// OnChanged is called after a component of an entity was edited
+	OnChanged func(handle registry.Handle, c entity.Comp)
+}
+
+// Draw shows the inspector window. Must be called between FrameStart and Render
+func (p *InspectorPanel) Draw(title string) {
+
+	imgui.Begin(title)
+	defer imgui.End()
+
+	handle := p.Selection.Primary()
+	cc := p.Entities.Get(handle)
+	if cc == nil {
+		imgui.TextDisabled("Nothing selected")
+		return
+	}
+
+	if n := p.Hierarchy.Node(handle); n != nil {
+
+		oldName := n.Name
+		if imgui.InputTextWithHint("Name", "", &n.Name, imgui.InputTextFlagsNone, nil) {
+			Commands.Record(&SetValueCommand[string]{
+				Label:  "Rename",
+				Target: &n.Name,
+				Old:    oldName,
+				New:    n.Name,
+			})
+		}
+
+		if imgui.IsItemDeactivated() {
+			Commands.EndMerge()
+		}
+	}
+
+	if len(cc.Comps) == 0 {
+		imgui.TextDisabled("No components")
+		return
+	}
+
+	for i := 0; i < len(cc.Comps); i++ {
+
+		c := cc.Comps[i]
+
+		imgui.PushIDInt(int32(i))
+		if imgui.CollapsingHeaderTreeNodeFlagsV(c.Name(), imgui.TreeNodeFlagsDefaultOpen) && Inspect(c) && p.OnChanged != nil {
+			p.OnChanged(handle, c)
+		}
+		imgui.PopID()
+	}
+}
+
+func NewInspectorPanel(entities *registry.Registry[entity.CompContainer], hierarchy *entity.Hierarchy, selection *Selection) InspectorPanel {
+	return InspectorPanel{
+		Entities:  entities,
+		Hierarchy: hierarchy,
+		Selection: selection,
+	}
+}
@@ -0,0 +1,96 @@
+package editor
+
+import (
+	"slices"
+
+	"github.com/bloeys/nmage/registry"
+)
+
+// Selection is what is selected in the editor. Panels share one selection so selecting in one panel shows in the others
+type Selection struct {
+	// Entities are in the order they were selected, so the last one is the primary selection
+	Entities []registry.Handle
+
+	// OnChanged callbacks are called after every change to the selection
+	OnChanged []func(s *Selection)
+}
+
+// Select makes the entity the only selected entity
+func (s *Selection) Select(handle registry.Handle) {
+
+	if len(s.Entities) == 1 && s.Entities[0] == handle {
+		return
+	}
+
+	s.Entities = append(s.Entities[:0], handle)
+	s.changed()
+}
+
+// Add adds the entity to the selection and makes it the primary selection
+func (s *Selection) Add(handle registry.Handle) {
+
+	if s.Primary() == handle {
+		return
+	}
+
+	s.Entities = slices.DeleteFunc(s.Entities, func(h registry.Handle) bool { return h == handle })
+	s.Entities = append(s.Entities, handle)
+	s.changed()
+}
+
+func (s *Selection) Remove(handle registry.Handle) {
+
+	if !s.IsSelected(handle) {
+		return
+	}
+
+	s.Entities = slices.DeleteFunc(s.Entities, func(h registry.Handle) bool { return h == handle })
+	s.changed()
+}
+
+// Toggle adds the entity if it's not selected and removes it if it is, which is what ctrl+click does
+func (s *Selection) Toggle(handle registry.Handle) {
+
+	if s.IsSelected(handle) {
+		s.Remove(handle)
+	} else {
+		s.Add(handle)
+	}
+}
+
+func (s *Selection) Clear() {
+
+	if len(s.Entities) == 0 {
+		return
+	}
+
+	s.Entities = s.Entities[:0]
+	s.changed()
+}
+
+func (s *Selection) IsSelected(handle registry.Handle) bool {
+	return slices.Contains(s.Entities, handle)
+}
+
+// Primary returns the last selected entity, or a zero handle if nothing is selected
+func (s *Selection) Primary() registry.Handle {
+
+	if len(s.Entities) == 0 {
+		return 0
+	}
+
+	return s.Entities[len(s.Entities)-1]
+}
+
+func (s *Selection) changed() {
+	for i := 0; i < len(s.OnChanged); i++ {
+		s.OnChanged[i](s)
+	}
+}
+
+func NewSelection() Selection {
+	return Selection{
+		Entities:  []registry.Handle{},
+		OnChanged: []func(s *Selection){},
+	}
+}
@@ -0,0 +1,205 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bloeys/nmage/logging"
+)
+
+// Config is the file form of the engine and window options. See LoadConfig
+type Config struct {
+	Engine Options
+	Window WindowOptions
+}
+
+var (
+	// config is the active config. See SetConfig
+	config = DefaultConfig()
+)
+
+func DefaultConfig() Config {
+	return Config{
+		Engine: DefaultOptions(),
+		Window: DefaultWindowOptions(),
+	}
+}
+
+// LoadConfig reads a TOML config file and makes it the active config (see SetConfig). Settings missing from the file use their default values.
+//
+// Example file:
+//
+//	[window]
+//	title = "nMage"
+//	width = 1280
+//	height = 720
+//	resizable = true
+//	vsync = false
+//	adaptive_vsync = false # Late frames tear instead of waiting a full refresh. Needs vsync
+//	msaa_samples = 4
+//	srgb = true
+//	high_dpi = false
+//	display = 0 # Index of the display to center the window on
+//
+//	[gl]
+//	major_version = 4
+//	minor_version = 1
+//	debug_context = false
+//	robust_context = false # Detect driver resets so the context can be recreated
+//
+//	[assets]
+//	root = "" # Relative asset paths are loaded from this folder. Empty means the working directory
+//
+//	[logging]
+//	level = "info" # debug, info, warn or err
+//	file = "logs/nmage.log" # Optional. Logs are also written to this file, which is rotated based on the settings below
+//	max_size_mb = 10 # Zero means no size limit
+//	max_age_hours = 0 # Zero means no age limit
+//	max_backups = 5 # Zero keeps all rotated files
+//
+//	[debug]
+//	assert_gl_state_dump = false # Log GL errors, bound objects and viewport when an assert fails
+//	renderdoc = false # Load RenderDoc so F11 or engine.TriggerCapture captures a frame
+//	perf_hud = false # Show the performance overlay, which F3 toggles
+func LoadConfig(path string) (Config, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return DefaultConfig(), fmt.Errorf("failed to read config file '%s'. Err: %w", path, err)
+	}
+
+	values, err := parseToml(string(data))
+	if err != nil {
+		return DefaultConfig(), fmt.Errorf("failed to parse config file '%s'. Err: %w", path, err)
+	}
+
+	cfg := DefaultConfig()
+	errs := make([]error, 0)
+	for key, val := range values {
+
+		var err error
+		switch key {
+		case "window.title":
+			cfg.Window.Title, err = configString(key, val)
+		case "window.width":
+			cfg.Window.Width, err = configInt32(key, val)
+		case "window.height":
+			cfg.Window.Height, err = configInt32(key, val)
+		case "window.resizable":
+			cfg.Window.Resizable, err = configBool(key, val)
+		case "window.vsync":
+			cfg.Window.VSync, err = configBool(key, val)
+		case "window.adaptive_vsync":
+			cfg.Window.AdaptiveVSync, err = configBool(key, val)
+		case "window.msaa_samples":
+			cfg.Window.MsaaSamples, err = configInt32(key, val)
+		case "window.srgb":
+			cfg.Window.Srgb, err = configBool(key, val)
+		case "window.high_dpi":
+			cfg.Window.HighDPI, err = configBool(key, val)
+		case "window.display":
+			var displayIndex int32
+			displayIndex, err = configInt32(key, val)
+			cfg.Window.X = WindowPosCenteredOnDisplay(int(displayIndex))
+			cfg.Window.Y = WindowPosCenteredOnDisplay(int(displayIndex))
+		case "gl.major_version":
+			cfg.Window.GlMajorVersion, err = configInt32(key, val)
+		case "gl.minor_version":
+			cfg.Window.GlMinorVersion, err = configInt32(key, val)
+		case "gl.debug_context":
+			cfg.Window.DebugContext, err = configBool(key, val)
+		case "gl.robust_context":
+			cfg.Window.RobustContext, err = configBool(key, val)
+		case "assets.root":
+			cfg.Engine.AssetRoot, err = configString(key, val)
+		case "logging.level":
+			var levelName string
+			levelName, err = configString(key, val)
+			if err == nil {
+				cfg.Engine.LogLevel, err = logging.ParseLevel(levelName)
+			}
+		case "logging.file":
+			cfg.Engine.LogFile, err = configString(key, val)
+		case "logging.max_size_mb":
+			var sizeMb int32
+			sizeMb, err = configInt32(key, val)
+			cfg.Engine.LogFileRotation.MaxSizeBytes = int64(sizeMb) * 1024 * 1024
+		case "logging.max_age_hours":
+			var hours int32
+			hours, err = configInt32(key, val)
+			cfg.Engine.LogFileRotation.MaxAge = time.Duration(hours) * time.Hour
+		case "logging.max_backups":
+			var backups int32
+			backups, err = configInt32(key, val)
+			cfg.Engine.LogFileRotation.MaxBackups = int(backups)
+		case "debug.assert_gl_state_dump":
+			cfg.Engine.AssertGlStateDump, err = configBool(key, val)
+		case "debug.renderdoc":
+			cfg.Engine.RenderDoc, err = configBool(key, val)
+		case "debug.perf_hud":
+			cfg.Engine.PerfHud, err = configBool(key, val)
+		default:
+			logging.WarnLog.Printf("Unknown key '%s' in config file '%s'\n", key, path)
+		}
+
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return DefaultConfig(), fmt.Errorf("failed to load config file '%s'. Errs: %v", path, errs)
+	}
+
+	if cfg.Window.Width <= 0 || cfg.Window.Height <= 0 {
+		return DefaultConfig(), fmt.Errorf("failed to load config file '%s' because window size must be positive, but got %dx%d", path, cfg.Window.Width, cfg.Window.Height)
+	}
+
+	if cfg.Window.MsaaSamples < 0 {
+		return DefaultConfig(), fmt.Errorf("failed to load config file '%s' because msaa_samples can not be negative, but got %d", path, cfg.Window.MsaaSamples)
+	}
+
+	config = cfg
+	return config, nil
+}
+
+// SetConfig makes cfg the active config returned by GetConfig. Init and CreateOpenGLWindow take their options directly,
+// which are usually cfg.Engine and cfg.Window, and update the active config with the options they were given
+func SetConfig(cfg Config) {
+	config = cfg
+}
+
+func GetConfig() Config {
+	return config
+}
+
+func configString(key string, val any) (string, error) {
+
+	s, ok := val.(string)
+	if !ok {
+		return "", fmt.Errorf("key '%s' must be a string but got '%v'", key, val)
+	}
+
+	return s, nil
+}
+
+func configInt32(key string, val any) (int32, error) {
+
+	i, ok := val.(int64)
+	if !ok {
+		return 0, fmt.Errorf("key '%s' must be an integer but got '%v'", key, val)
+	}
+
+	return int32(i), nil
+}
+
+func configBool(key string, val any) (bool, error) {
+
+	b, ok := val.(bool)
+	if !ok {
+		return false, fmt.Errorf("key '%s' must be a boolean but got '%v'", key, val)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/bloeys/nmage/assets"
+	"github.com/bloeys/nmage/glutil"
+	"github.com/bloeys/nmage/leakcheck"
+	"github.com/bloeys/nmage/logging"
+	"github.com/bloeys/nmage/renderer"
+	nmageimgui "github.com/bloeys/nmage/ui/imgui"
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// contextResetWaitMs is how long RecreateContext waits for the driver to finish a reset before creating the new context
+const contextResetWaitMs = 5000
+
+// ContextLossHandler is implemented by games that can recreate their GPU resources after the OpenGL context is lost,
+// which happens on driver resets, GPU hangs and some driver updates.
+//
+// When Run detects context loss it calls OnContextLost, recreates the context along with the engine's own resources (GL state, default textures,
+// imgui and the objects of the renderer), then calls OnContextRestored. Games that don't implement this interface are stopped instead, since their GPU objects are gone
+type ContextLossHandler interface {
+	// OnContextLost is called before the lost context is destroyed. Every GPU object id the game has is invalid, and GL calls do nothing
+	OnContextLost()
+
+	// OnContextRestored is called with the new context current, and should recreate GPU resources from their CPU side data
+	// (e.g. reload meshes, textures and materials from disk). Ids of the default textures in assets might have changed.
+	// Returning an error stops the game
+	OnContextRestored() error
+}
+
+// getGraphicsResetStatus is nil when the context can't report resets
+var getGraphicsResetStatus func() uint32
+
+func loadGraphicsResetStatusFunc() {
+
+	getGraphicsResetStatus = nil
+
+	var major, minor int32
+	gl.GetIntegerv(gl.MAJOR_VERSION, &major)
+	gl.GetIntegerv(gl.MINOR_VERSION, &minor)
+
+	var resetStatusFunc func() uint32
+	if major > 4 || (major == 4 && minor >= 5) || glutil.HasGlExtension("GL_KHR_robustness") {
+		resetStatusFunc = gl.GetGraphicsResetStatus
+	} else if glutil.HasGlExtension("GL_ARB_robustness") {
+		resetStatusFunc = gl.GetGraphicsResetStatusARB
+	} else {
+		return
+	}
+
+	// Resets are only reported when the context was created to lose itself on reset. See WindowOptions.RobustContext
+	var strategy int32
+	gl.GetIntegerv(gl.RESET_NOTIFICATION_STRATEGY, &strategy)
+	if strategy != gl.LOSE_CONTEXT_ON_RESET {
+		return
+	}
+
+	getGraphicsResetStatus = resetStatusFunc
+}
+
+// IsContextLost polls the driver for a reset of the GL context, which is only reported by a robust context. See WindowOptions.RobustContext
+func (w *Window) IsContextLost() bool {
+
+	if !w.isContextLost && getGraphicsResetStatus != nil && getGraphicsResetStatus() != gl.NO_ERROR {
+		w.isContextLost = true
+	}
+
+	return w.isContextLost
+}
+
+// RecreateContext replaces the GL context with a new one, and restores the engine's GL state and default textures.
+// Objects of the old context are gone with it, so the texture cache and leak tracking are cleared
+func (w *Window) RecreateContext() error {
+
+	// The new context must only be created after the driver is done resetting, which is when the old context reports no error again
+	if getGraphicsResetStatus != nil {
+		for waitedMs := 0; getGraphicsResetStatus() != gl.NO_ERROR; waitedMs += 10 {
+
+			if waitedMs >= contextResetWaitMs {
+				return fmt.Errorf("failed to recreate OpenGL context because the driver reset didn't finish within %dms", contextResetWaitMs)
+			}
+
+			sdl.Delay(10)
+		}
+	}
+
+	sdl.GLDeleteContext(w.GlCtx)
+	w.GlCtx = nil
+
+	assets.ClearTextureCache()
+	leakcheck.Clear()
+
+	err := w.createGlContext()
+	if err != nil {
+		return fmt.Errorf("failed to recreate OpenGL context. Err: %w", err)
+	}
+
+	w.isContextLost = false
+	w.handleWindowResize()
+
+	return nil
+}
+
+// recoverFromContextLoss recreates the context and the resources of the engine and game. An error means the game can't continue
+func recoverFromContextLoss(g Game, w *Window, rend renderer.Render, ui *nmageimgui.ImguiInfo) error {
+
+	handler, ok := g.(ContextLossHandler)
+	if !ok {
+		return fmt.Errorf("the OpenGL context was lost and the game doesn't implement engine.ContextLossHandler, so its GPU resources can't be recreated")
+	}
+
+	logging.WarnLog.Println("OpenGL context was lost. Recreating it along with GPU resources")
+	handler.OnContextLost()
+
+	err := w.RecreateContext()
+	if err != nil {
+		return err
+	}
+
+	rend.RecreateGpuObjects()
+	ui.RecreateDeviceObjects()
+
+	// Viewport windows share objects with the lost context, so the backend is restarted to recreate them with the new one
+	if ui.ViewportsEnabled() {
+		err = ui.EnableViewports(w.SDLWin, w.GlCtx)
+		if err != nil {
+			logging.ErrLog.Printf("Failed to restart imgui viewports after OpenGL context loss. Err: %v\n", err)
+		}
+	}
+
+	err = handler.OnContextRestored()
+	if err != nil {
+		return fmt.Errorf("failed to restore game after OpenGL context loss. Err: %w", err)
+	}
+
+	logging.InfoLog.Println("Recovered from OpenGL context loss")
+	return nil
+}
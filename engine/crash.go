@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/bloeys/nmage/logging"
+	"github.com/bloeys/nmage/timing"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// glInfo is captured when the GL context is created, because by the time a crash report
+// is written the context might already be gone (e.g. deferred window.Destroy ran first)
+type glInfo struct {
+	Vendor      string
+	Renderer    string
+	Version     string
+	GlslVersion string
+}
+
+var (
+	initOpts       Options
+	glContextInfo  glInfo
+	isGlInfoLoaded = false
+)
+
+func loadGlInfo() {
+
+	glContextInfo = glInfo{
+		Vendor:      gl.GoStr(gl.GetString(gl.VENDOR)),
+		Renderer:    gl.GoStr(gl.GetString(gl.RENDERER)),
+		Version:     gl.GoStr(gl.GetString(gl.VERSION)),
+		GlslVersion: gl.GoStr(gl.GetString(gl.SHADING_LANGUAGE_VERSION)),
+	}
+	isGlInfoLoaded = true
+}
+
+// HandleCrash recovers from a panic, writes a crash report to Options.CrashReportDir and exits the program.
+// It must be deferred directly (not called from another deferred function), and should be the first defer in main:
+//
+//	func main() {
+//		defer engine.HandleCrash()
+//		...
+//	}
+//
+// Note that panics in other goroutines can't be recovered here
+func HandleCrash() {
+
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	stack := debug.Stack()
+	logging.ErrLog.Printf("Panic: %v\n%s\n", r, stack)
+
+	reportPath, err := WriteCrashReport(r, stack)
+	if err != nil {
+		logging.ErrLog.Println(err)
+	} else {
+		logging.ErrLog.Printf("Crash report written to '%s'. Please attach it when reporting this bug\n", reportPath)
+	}
+
+	os.Exit(1)
+}
+
+// WriteCrashReport writes a crash report with the panic value, stack, engine and GL information, and recent logs.
+// Returns the path of the report file
+func WriteCrashReport(panicVal any, stack []byte) (string, error) {
+
+	dir := initOpts.CrashReportDir
+	if dir == "" {
+		dir = DefaultOptions().CrashReportDir
+	}
+
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return "", fmt.Errorf("failed to create crash report folder '%s'. Err: %w", dir, err)
+	}
+
+	now := time.Now()
+	reportPath := filepath.Join(dir, "crash_"+now.Format("2006-01-02_15-04-05")+".txt")
+
+	err = os.WriteFile(reportPath, []byte(buildCrashReport(now, panicVal, stack)), 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to write crash report '%s'. Err: %w", reportPath, err)
+	}
+
+	return reportPath, nil
+}
+
+func buildCrashReport(t time.Time, panicVal any, stack []byte) string {
+
+	sb := strings.Builder{}
+
+	sb.WriteString("nMage crash report\n")
+	sb.WriteString("Time: " + t.Format(time.RFC3339) + "\n")
+	fmt.Fprintf(&sb, "Panic: %v\n", panicVal)
+
+	sb.WriteString("\n== Engine ==\n")
+	fmt.Fprintf(&sb, "Inited: %t\n", isInited)
+	fmt.Fprintf(&sb, "Running: %t\n", isRunning)
+	if isInited {
+		fmt.Fprintf(&sb, "Uptime: %ds\n", timing.ElapsedTime())
+		fmt.Fprintf(&sb, "Avg FPS: %.2f\n", timing.GetAvgFPS())
+	}
+	fmt.Fprintf(&sb, "Asset root: %s\n", initOpts.AssetRoot)
+	fmt.Fprintf(&sb, "Log level: %s\n", logging.GetLevel())
+
+	sb.WriteString("\n== OpenGL ==\n")
+	if isGlInfoLoaded {
+		fmt.Fprintf(&sb, "Vendor: %s\n", glContextInfo.Vendor)
+		fmt.Fprintf(&sb, "Renderer: %s\n", glContextInfo.Renderer)
+		fmt.Fprintf(&sb, "Version: %s\n", glContextInfo.Version)
+		fmt.Fprintf(&sb, "GLSL version: %s\n", glContextInfo.GlslVersion)
+	} else {
+		sb.WriteString("No OpenGL context was created\n")
+	}
+
+	memStats := runtime.MemStats{}
+	runtime.ReadMemStats(&memStats)
+
+	sb.WriteString("\n== Runtime ==\n")
+	fmt.Fprintf(&sb, "Go version: %s\n", runtime.Version())
+	fmt.Fprintf(&sb, "OS/Arch: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&sb, "CPUs: %d\n", runtime.NumCPU())
+	fmt.Fprintf(&sb, "Goroutines: %d\n", runtime.NumGoroutine())
+	fmt.Fprintf(&sb, "Heap alloc: %d KB\n", memStats.HeapAlloc/1024)
+
+	sb.WriteString("\n== Stack ==\n")
+	sb.Write(stack)
+
+	recentLogs := logging.RecentLogs()
+	fmt.Fprintf(&sb, "\n== Recent logs (%d) ==\n", len(recentLogs))
+	for i := 0; i < len(recentLogs); i++ {
+		sb.WriteString(recentLogs[i])
+		sb.WriteByte('\n')
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Display is a monitor connected to the machine. Bounds are in screen coordinates, where the primary display starts at (0, 0)
+type Display struct {
+	Index int
+	Name  string
+
+	Bounds sdl.Rect
+
+	// UsableBounds is Bounds without the areas taken by the OS, like task bars and docks
+	UsableBounds sdl.Rect
+
+	DpiScaling float32
+
+	// RefreshRate is in Hz, and is zero if it's unknown
+	RefreshRate int32
+}
+
+// GetDisplays returns all connected displays, where the first one is the primary display
+func GetDisplays() ([]Display, error) {
+
+	count, err := sdl.GetNumVideoDisplays()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get number of displays. Err: %w", err)
+	}
+
+	displays := make([]Display, 0, count)
+	for i := 0; i < count; i++ {
+
+		d, err := GetDisplay(i)
+		if err != nil {
+			return nil, err
+		}
+
+		displays = append(displays, d)
+	}
+
+	return displays, nil
+}
+
+func GetDisplay(displayIndex int) (Display, error) {
+
+	d := Display{
+		Index:       displayIndex,
+		DpiScaling:  GetDisplayDpiScaling(displayIndex),
+		RefreshRate: getDisplayRefreshRate(displayIndex),
+	}
+
+	var err error
+	d.Name, err = sdl.GetDisplayName(displayIndex)
+	if err != nil {
+		return d, fmt.Errorf("failed to get name of display %d. Err: %w", displayIndex, err)
+	}
+
+	d.Bounds, err = sdl.GetDisplayBounds(displayIndex)
+	if err != nil {
+		return d, fmt.Errorf("failed to get bounds of display %d. Err: %w", displayIndex, err)
+	}
+
+	d.UsableBounds, err = sdl.GetDisplayUsableBounds(displayIndex)
+	if err != nil {
+		return d, fmt.Errorf("failed to get usable bounds of display %d. Err: %w", displayIndex, err)
+	}
+
+	return d, nil
+}
+
+func getDisplayRefreshRate(displayIndex int) int32 {
+
+	mode, err := sdl.GetCurrentDisplayMode(displayIndex)
+	if err != nil {
+		return 0
+	}
+
+	return mode.RefreshRate
+}
+
+// WindowPosCenteredOnDisplay returns a window position (for WindowOptions.X/Y) that centers the window on the display
+func WindowPosCenteredOnDisplay(displayIndex int) int32 {
+	return int32(sdl.WINDOWPOS_CENTERED_MASK | displayIndex)
+}
+
+// WindowPosUndefinedOnDisplay returns a window position (for WindowOptions.X/Y) that lets the OS place the window on the display
+func WindowPosUndefinedOnDisplay(displayIndex int) int32 {
+	return int32(sdl.WINDOWPOS_UNDEFINED_MASK | displayIndex)
+}
+
+// DisplayIndex returns the index of the display the window is on, which is the display with the center of the window
+func (w *Window) DisplayIndex() (int, error) {
+	return w.SDLWin.GetDisplayIndex()
+}
+
+// MoveToDisplay centers the window on the display. Fullscreen windows become fullscreen on the new display
+func (w *Window) MoveToDisplay(displayIndex int) error {
+
+	count, err := sdl.GetNumVideoDisplays()
+	if err != nil {
+		return fmt.Errorf("failed to get number of displays. Err: %w", err)
+	}
+
+	if displayIndex < 0 || displayIndex >= count {
+		return fmt.Errorf("failed to move window to display %d because only %d displays exist", displayIndex, count)
+	}
+
+	// Fullscreen windows can't be moved, so leave fullscreen then go back to it after the move
+	fullscreenFlags := w.SDLWin.GetFlags() & (sdl.WINDOW_FULLSCREEN | sdl.WINDOW_FULLSCREEN_DESKTOP)
+	if fullscreenFlags != 0 {
+		err = w.SDLWin.SetFullscreen(0)
+		if err != nil {
+			return fmt.Errorf("failed to leave fullscreen to move window to display %d. Err: %w", displayIndex, err)
+		}
+	}
+
+	pos := WindowPosCenteredOnDisplay(displayIndex)
+	w.SDLWin.SetPosition(pos, pos)
+
+	if fullscreenFlags != 0 {
+		err = w.SDLWin.SetFullscreen(fullscreenFlags)
+		if err != nil {
+			return fmt.Errorf("failed to make window fullscreen on display %d. Err: %w", displayIndex, err)
+		}
+	}
+
+	// Don't wait for the move event, so DpiScaling is right as soon as this returns
+	w.checkDpiChange()
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"runtime"
+
+	"github.com/bloeys/nmage/logging"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// dpiChangeEpsilon ignores tiny differences between the DPIs reported for similar displays
+const dpiChangeEpsilon = 0.01
+
+// GetDisplayDpiScaling returns how much bigger than normal things should be drawn on a display (e.g. 1.25 for 125% scaling on windows).
+// Returns 1 if the DPI of the display can't be read.
+//
+// Great read on DPI here: https://nlguillemot.wordpress.com/2016/12/11/high-dpi-rendering/
+func GetDisplayDpiScaling(displayIndex int) float32 {
+
+	// The no-scaling DPI on different platforms (e.g. when scale=100% on windows)
+	var defaultDpi float32 = 96
+	if runtime.GOOS == "darwin" {
+		defaultDpi = 72
+	}
+
+	_, dpiHorizontal, _, err := sdl.GetDisplayDPI(displayIndex)
+	if err != nil {
+		logging.ErrLog.Printf("Failed to get DPI of display %d with error '%s'. Using default DPI of '%f'\n", displayIndex, err.Error(), defaultDpi)
+		return 1
+	}
+
+	return dpiHorizontal / defaultDpi
+}
+
+// DpiScaling returns the DPI scaling of the display the window is currently on
+func (w *Window) DpiScaling() float32 {
+	return w.dpiScaling
+}
+
+// checkDpiChange handles the window moving to a display with a different DPI (or the DPI of its display changing)
+// by resizing the window by the change in scaling, then calling DpiChangedCallbacks. Run adds a callback that scales imgui
+func (w *Window) checkDpiChange() {
+
+	displayIndex, err := w.SDLWin.GetDisplayIndex()
+	if err != nil {
+		return
+	}
+
+	newScaling := GetDisplayDpiScaling(displayIndex)
+	oldScaling := w.dpiScaling
+	if newScaling-oldScaling < dpiChangeEpsilon && oldScaling-newScaling < dpiChangeEpsilon {
+		return
+	}
+
+	w.dpiScaling = newScaling
+	ratio := newScaling / oldScaling
+
+	logging.InfoLog.Printf("Window moved to display %d with DPI scaling %f (was %f)\n", displayIndex, newScaling, oldScaling)
+
+	// Maximized and fullscreen windows are sized by the OS
+	flags := WindowFlags(w.SDLWin.GetFlags())
+	if flags&(WindowFlags_MAXIMIZED|WindowFlags_FULLSCREEN|WindowFlags_FULLSCREEN_DESKTOP) == 0 {
+		width, height := w.SDLWin.GetSize()
+		w.SDLWin.SetSize(int32(float32(width)*ratio), int32(float32(height)*ratio))
+	}
+
+	for i := 0; i < len(w.DpiChangedCallbacks); i++ {
+		w.DpiChangedCallbacks[i](oldScaling, newScaling)
+	}
+}
@@ -1,14 +1,18 @@
 package engine
 
 import (
+	"fmt"
 	"image"
 	"image/color"
+	"os"
+	"path/filepath"
 	"runtime"
 
 	imgui "github.com/AllenDang/cimgui-go"
 	"github.com/bloeys/nmage/assert"
 	"github.com/bloeys/nmage/assets"
 	"github.com/bloeys/nmage/input"
+	"github.com/bloeys/nmage/logging"
 	"github.com/bloeys/nmage/timing"
 	nmageimgui "github.com/bloeys/nmage/ui/imgui"
 	"github.com/go-gl/gl/v4.1-core/gl"
@@ -18,6 +22,8 @@ import (
 var (
 	isInited = false
 
+	logFileSink *logging.RotatingFileSink
+
 	isSdlButtonLeftDown   = false
 	isSdlButtonMiddleDown = false
 	isSdlButtonRightDown  = false
@@ -30,6 +36,16 @@ type Window struct {
 	SDLWin         *sdl.Window
 	GlCtx          sdl.GLContext
 	EventCallbacks []func(sdl.Event)
+
+	// DpiChangedCallbacks are called after the window moved to a display with a different DPI scaling (see DpiScaling),
+	// and after the window and imgui fonts were resized to match
+	DpiChangedCallbacks []func(oldScaling, newScaling float32)
+
+	dpiScaling float32
+
+	// opts are kept so the GL context can be recreated with the same settings after context loss
+	opts          WindowOptions
+	isContextLost bool
 }
 
 func (w *Window) handleInputs() {
@@ -41,6 +57,8 @@ func (w *Window) handleInputs() {
 
 	input.EventLoopStart(imguiCaptureMouse, imguiCaptureKeyboard)
 
+	mainWindowId, _ := w.SDLWin.GetID()
+
 	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
 
 		//Fire callbacks
@@ -90,20 +108,35 @@ func (w *Window) handleInputs() {
 
 		case *sdl.WindowEvent:
 
+			// Events of imgui viewport windows are for imgui only
+			if e.WindowID != mainWindowId && nmageimgui.HandleViewportWindowEvent(e) {
+				continue
+			}
+
 			if e.Event == sdl.WINDOWEVENT_SIZE_CHANGED {
 				w.handleWindowResize()
+			} else if e.Event == sdl.WINDOWEVENT_DISPLAY_CHANGED || e.Event == sdl.WINDOWEVENT_MOVED {
+				// Older SDL versions don't send display changed events, so moves are checked as well
+				w.checkDpiChange()
 			}
 
+		case *sdl.DisplayEvent:
+			w.checkDpiChange()
+
 		case *sdl.QuitEvent:
 			input.HandleQuitEvent(e)
 		}
 	}
 
-	if sdl.GetRelativeMouseMode() {
-		imIo.SetMousePos(imgui.Vec2{X: ImguiRelativeMouseModePosX, Y: ImguiRelativeMouseModePosY})
-	} else {
-		x, y, _ := sdl.GetMouseState()
-		imIo.SetMousePos(imgui.Vec2{X: float32(x), Y: float32(y)})
+	// With imgui viewports the imgui sdl2 backend sets the mouse position in screen coordinates
+	if imIo.ConfigFlags()&imgui.ConfigFlagsViewportsEnable == 0 {
+
+		if sdl.GetRelativeMouseMode() {
+			imIo.SetMousePos(imgui.Vec2{X: ImguiRelativeMouseModePosX, Y: ImguiRelativeMouseModePosY})
+		} else {
+			x, y, _ := sdl.GetMouseState()
+			imIo.SetMousePos(imgui.Vec2{X: float32(x), Y: float32(y)})
+		}
 	}
 
 	// If a mouse press event came, always pass it as "mouse held this frame", so we don't miss click-release events that are shorter than 1 frame.
@@ -125,15 +158,47 @@ func (w *Window) Destroy() error {
 	return w.SDLWin.Destroy()
 }
 
-func Init() error {
+// Init initializes the engine. Must be called before creating windows
+func Init(opts Options) error {
 
 	isInited = true
+	initOpts = opts
+	config.Engine = opts
+
+	logging.SetLevel(opts.LogLevel)
+	assets.Root = opts.AssetRoot
+	EnableAssertGlStateDump(opts.AssertGlStateDump)
+	ShowPerfHud(opts.PerfHud)
+
+	if opts.LogFile != "" {
+
+		err := os.MkdirAll(filepath.Dir(opts.LogFile), os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("failed to create log folder for '%s'. Err: %w", opts.LogFile, err)
+		}
+
+		logFileSink, err = logging.NewRotatingFileSink(opts.LogFile, opts.LogFileRotation)
+		if err != nil {
+			return err
+		}
+		logging.AddSink(logFileSink)
+	}
 
 	runtime.LockOSThread()
 	timing.Init()
 	err := initSDL()
+	if err != nil {
+		return err
+	}
+
+	// Failing to load RenderDoc shouldn't stop the game, since it's only a debugging aid
+	if opts.RenderDoc {
+		if err := initRenderDoc(); err != nil {
+			logging.ErrLog.Println(err)
+		}
+	}
 
-	return err
+	return nil
 }
 
 func initSDL() error {
@@ -145,8 +210,14 @@ func initSDL() error {
 
 	sdl.ShowCursor(1)
 
-	sdl.GLSetAttribute(sdl.MAJOR_VERSION, 4)
-	sdl.GLSetAttribute(sdl.MINOR_VERSION, 1)
+	return nil
+}
+
+// setGlAttributes sets the SDL attributes used when creating the OpenGL context of the next window
+func setGlAttributes(opts *WindowOptions) {
+
+	sdl.GLSetAttribute(sdl.GL_CONTEXT_MAJOR_VERSION, int(opts.GlMajorVersion))
+	sdl.GLSetAttribute(sdl.GL_CONTEXT_MINOR_VERSION, int(opts.GlMinorVersion))
 
 	sdl.GLSetAttribute(sdl.GL_RED_SIZE, 8)
 	sdl.GLSetAttribute(sdl.GL_GREEN_SIZE, 8)
@@ -157,52 +228,78 @@ func initSDL() error {
 	sdl.GLSetAttribute(sdl.GL_DEPTH_SIZE, 24)
 	sdl.GLSetAttribute(sdl.GL_STENCIL_SIZE, 8)
 
-	sdl.GLSetAttribute(sdl.GL_FRAMEBUFFER_SRGB_CAPABLE, 1)
+	if opts.Srgb {
+		sdl.GLSetAttribute(sdl.GL_FRAMEBUFFER_SRGB_CAPABLE, 1)
+	} else {
+		sdl.GLSetAttribute(sdl.GL_FRAMEBUFFER_SRGB_CAPABLE, 0)
+	}
 
 	// Allows us to do MSAA
-	sdl.GLSetAttribute(sdl.GL_MULTISAMPLEBUFFERS, 1)
-	sdl.GLSetAttribute(sdl.GL_MULTISAMPLESAMPLES, 4)
+	if opts.MsaaSamples > 0 {
+		sdl.GLSetAttribute(sdl.GL_MULTISAMPLEBUFFERS, 1)
+		sdl.GLSetAttribute(sdl.GL_MULTISAMPLESAMPLES, int(opts.MsaaSamples))
+	} else {
+		sdl.GLSetAttribute(sdl.GL_MULTISAMPLEBUFFERS, 0)
+		sdl.GLSetAttribute(sdl.GL_MULTISAMPLESAMPLES, 0)
+	}
 
-	sdl.GLSetAttribute(sdl.GL_CONTEXT_PROFILE_MASK, sdl.GL_CONTEXT_PROFILE_CORE)
+	contextFlags := 0
+	if opts.DebugContext {
+		contextFlags |= sdl.GL_CONTEXT_DEBUG_FLAG
+	}
 
-	return nil
-}
+	if opts.RobustContext {
+		contextFlags |= sdl.GL_CONTEXT_ROBUST_ACCESS_FLAG
 
-func CreateOpenGLWindow(title string, x, y, width, height int32, flags WindowFlags) (Window, error) {
-	return createWindow(title, x, y, width, height, WindowFlags_OPENGL|flags)
-}
+		// 1 is SDL_GL_CONTEXT_RESET_LOSE_CONTEXT, which makes the driver report resets instead of silently continuing
+		sdl.GLSetAttribute(sdl.GL_CONTEXT_RESET_NOTIFICATION, 1)
+	} else {
+		sdl.GLSetAttribute(sdl.GL_CONTEXT_RESET_NOTIFICATION, 0)
+	}
+
+	sdl.GLSetAttribute(sdl.GL_CONTEXT_FLAGS, contextFlags)
 
-func CreateOpenGLWindowCentered(title string, width, height int32, flags WindowFlags) (Window, error) {
-	return createWindow(title, sdl.WINDOWPOS_CENTERED, sdl.WINDOWPOS_CENTERED, width, height, WindowFlags_OPENGL|flags)
+	sdl.GLSetAttribute(sdl.GL_CONTEXT_PROFILE_MASK, sdl.GL_CONTEXT_PROFILE_CORE)
 }
 
-func createWindow(title string, x, y, width, height int32, flags WindowFlags) (Window, error) {
+// CreateOpenGLWindow creates a window with an OpenGL context based on the options. Start from DefaultWindowOptions and change what you need
+func CreateOpenGLWindow(opts WindowOptions) (Window, error) {
 
 	assert.T(isInited, "engine.Init() was not called!")
+	config.Window = opts
 
 	win := Window{
 		SDLWin:         nil,
 		EventCallbacks: make([]func(sdl.Event), 0),
+		opts:           opts,
 	}
 
-	var err error
+	flags := WindowFlags_OPENGL | opts.Flags
+	if opts.Resizable {
+		flags |= WindowFlags_RESIZABLE
+	}
 
-	win.SDLWin, err = sdl.CreateWindow(title, x, y, width, height, uint32(flags))
-	if err != nil {
-		return win, err
+	if opts.HighDPI {
+		flags |= WindowFlags_ALLOW_HIGHDPI
 	}
 
-	win.GlCtx, err = win.SDLWin.GLCreateContext()
+	setGlAttributes(&opts)
+
+	var err error
+	win.SDLWin, err = sdl.CreateWindow(opts.Title, opts.X, opts.Y, opts.Width, opts.Height, uint32(flags))
 	if err != nil {
 		return win, err
 	}
 
-	err = initOpenGL()
+	err = win.createGlContext()
 	if err != nil {
 		return win, err
 	}
 
-	setupDefaultTextures()
+	win.dpiScaling = 1
+	if displayIndex, err := win.SDLWin.GetDisplayIndex(); err == nil {
+		win.dpiScaling = GetDisplayDpiScaling(displayIndex)
+	}
 
 	// Get rid of the blinding white startup screen (unfortunately there is still one frame of white)
 	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT | gl.STENCIL_BUFFER_BIT)
@@ -211,6 +308,51 @@ func createWindow(title string, x, y, width, height int32, flags WindowFlags) (W
 	return win, err
 }
 
+// createGlContext creates the GL context of the window and sets up the engine's GL state and default textures
+func (w *Window) createGlContext() error {
+
+	setGlAttributes(&w.opts)
+
+	var err error
+	w.GlCtx, err = w.SDLWin.GLCreateContext()
+	if err != nil && w.opts.RobustContext {
+
+		logging.WarnLog.Printf("Failed to create a robust OpenGL context, so context loss won't be detected. Creating a normal context instead. Err: %s\n", err)
+
+		normalOpts := w.opts
+		normalOpts.RobustContext = false
+		setGlAttributes(&normalOpts)
+
+		w.GlCtx, err = w.SDLWin.GLCreateContext()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = initOpenGL()
+	if err != nil {
+		return err
+	}
+	loadGlInfo()
+	loadGraphicsResetStatusFunc()
+
+	err = setupDefaultTextures()
+	if err != nil {
+		return err
+	}
+
+	if w.opts.VSync && w.opts.AdaptiveVSync {
+		SetVSyncMode(VSyncMode_Adaptive)
+	} else {
+		SetVSync(w.opts.VSync)
+	}
+	SetMSAA(w.opts.MsaaSamples > 0)
+	SetSrgbFramebuffer(w.opts.Srgb)
+
+	return nil
+}
+
 func initOpenGL() error {
 
 	if err := gl.Init(); err != nil {
@@ -224,10 +366,11 @@ func initOpenGL() error {
 	gl.FrontFace(gl.CCW)
 
 	gl.Enable(gl.BLEND)
-	gl.Enable(gl.MULTISAMPLE)
-	gl.Enable(gl.FRAMEBUFFER_SRGB)
 	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
 
+	// Filters across cubemap face edges, without which the blurry mips of environment maps show seams
+	gl.Enable(gl.TEXTURE_CUBE_MAP_SEAMLESS)
+
 	gl.ClearColor(0, 0, 0, 1)
 
 	return nil
@@ -293,13 +436,44 @@ func SetSrgbFramebuffer(isEnabled bool) {
 	}
 }
 
+// SetVSync turns vsync on or off. See SetVSyncMode for adaptive vsync
 func SetVSync(enabled bool) {
 
 	if enabled {
+		SetVSyncMode(VSyncMode_On)
+	} else {
+		SetVSyncMode(VSyncMode_Off)
+	}
+}
+
+// SetVSyncMode sets the swap interval of the current context and returns the mode that was applied,
+// since adaptive vsync falls back to normal vsync when the driver doesn't support it
+func SetVSyncMode(mode VSyncMode) VSyncMode {
+
+	if mode == VSyncMode_Adaptive {
+
+		err := sdl.GLSetSwapInterval(-1)
+		if err == nil {
+			vsyncMode = VSyncMode_Adaptive
+			return vsyncMode
+		}
+
+		logging.WarnLog.Printf("Adaptive vsync is not supported, so normal vsync is used instead. Err: %s\n", err)
+		mode = VSyncMode_On
+	}
+
+	if mode == VSyncMode_On {
 		sdl.GLSetSwapInterval(1)
 	} else {
 		sdl.GLSetSwapInterval(0)
 	}
+
+	vsyncMode = mode
+	return vsyncMode
+}
+
+func GetVSyncMode() VSyncMode {
+	return vsyncMode
 }
 
 func SetMSAA(isEnabled bool) {
@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/bloeys/nmage/timing"
+)
+
+type VSyncMode int32
+
+const (
+	VSyncMode_Off VSyncMode = iota
+	VSyncMode_On
+
+	// VSyncMode_Adaptive syncs to the display like VSyncMode_On, except late frames are shown right away instead of waiting for the next refresh
+	VSyncMode_Adaptive
+)
+
+func (m VSyncMode) String() string {
+	switch m {
+	case VSyncMode_Off:
+		return "Off"
+	case VSyncMode_On:
+		return "On"
+	case VSyncMode_Adaptive:
+		return "Adaptive"
+	default:
+		return "Unknown"
+	}
+}
+
+type FramePacing int32
+
+const (
+	FramePacing_None FramePacing = iota
+
+	// FramePacing_RefreshRate waits at the end of each frame so the game runs at the refresh rate of the display the window is on.
+	// Meant for running without vsync, where it stops rendering frames that are never shown without the latency of vsync
+	FramePacing_RefreshRate
+
+	// FramePacing_TargetFps waits at the end of each frame so the game runs at most at the fps set with SetTargetFps
+	FramePacing_TargetFps
+)
+
+func (fp FramePacing) String() string {
+	switch fp {
+	case FramePacing_None:
+		return "None"
+	case FramePacing_RefreshRate:
+		return "RefreshRate"
+	case FramePacing_TargetFps:
+		return "TargetFps"
+	default:
+		return "Unknown"
+	}
+}
+
+// pacingSpinDuration is how much of the wait is spent spinning rather than sleeping,
+// since sleeps can overshoot by a millisecond or more depending on the OS
+const pacingSpinDuration = 2 * time.Millisecond
+
+var (
+	vsyncMode   VSyncMode
+	framePacing FramePacing
+	targetFps   int32 = 60
+)
+
+func SetFramePacing(fp FramePacing) {
+	framePacing = fp
+}
+
+func GetFramePacing() FramePacing {
+	return framePacing
+}
+
+// SetTargetFps sets the fps used by FramePacing_TargetFps
+func SetTargetFps(fps int32) {
+	targetFps = fps
+}
+
+func GetTargetFps() int32 {
+	return targetFps
+}
+
+// RefreshRate returns the refresh rate in Hz of the display the window is on, or zero if it's unknown
+func (w *Window) RefreshRate() int32 {
+
+	displayIndex, err := w.SDLWin.GetDisplayIndex()
+	if err != nil {
+		return 0
+	}
+
+	return getDisplayRefreshRate(displayIndex)
+}
+
+// paceFrame waits until the frame took as long as the frame pacing mode wants. Called by Run before the frame ends
+func (w *Window) paceFrame() {
+
+	var fps int32
+	switch framePacing {
+	case FramePacing_RefreshRate:
+		fps = w.RefreshRate()
+	case FramePacing_TargetFps:
+		fps = targetFps
+	}
+
+	if fps <= 0 {
+		return
+	}
+
+	frameDur := time.Second / time.Duration(fps)
+	remaining := frameDur - timing.SinceFrameStart()
+	if remaining > pacingSpinDuration {
+		time.Sleep(remaining - pacingSpinDuration)
+	}
+
+	for timing.SinceFrameStart() < frameDur {
+	}
+}
@@ -1,10 +1,16 @@
 package engine
 
 import (
+	"github.com/bloeys/nmage/audio"
+	"github.com/bloeys/nmage/input"
+	"github.com/bloeys/nmage/leakcheck"
+	"github.com/bloeys/nmage/logging"
 	"github.com/bloeys/nmage/renderer"
 	"github.com/bloeys/nmage/timing"
+	"github.com/bloeys/nmage/tween"
 	nmageimgui "github.com/bloeys/nmage/ui/imgui"
 	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/veandco/go-sdl2/sdl"
 )
 
 var (
@@ -25,6 +31,11 @@ func Run(g Game, w *Window, rend renderer.Render, ui nmageimgui.ImguiInfo) {
 
 	isRunning = true
 
+	// Fonts are rasterized again at the new size instead of stretching them, which would look blurry
+	w.DpiChangedCallbacks = append(w.DpiChangedCallbacks, func(oldScaling, newScaling float32) {
+		ui.SetUIScale(ui.UIScale() * newScaling / oldScaling)
+	})
+
 	// Run init with an active Imgui frame to allow init full imgui access
 	timing.FrameStarted()
 	w.handleInputs()
@@ -35,12 +46,26 @@ func Run(g Game, w *Window, rend renderer.Render, ui nmageimgui.ImguiInfo) {
 	g.Init()
 
 	fbWidth, fbHeight := w.SDLWin.GLGetDrawableSize()
-	ui.Render(float32(width), float32(height), fbWidth, fbHeight)
+	ui.RenderLayer(fbWidth, fbHeight)
+	ui.RenderViewports(w.SDLWin, w.GlCtx)
 
 	timing.FrameEnded()
 
 	for isRunning {
 
+		// Rendering with a lost context is undefined, so either recover or stop the game
+		if w.IsContextLost() {
+
+			err := recoverFromContextLoss(g, w, rend, &ui)
+			if err != nil {
+				logging.ErrLog.Println(err)
+
+				// Objects of the lost context are gone, so they shouldn't be reported as leaks
+				leakcheck.Clear()
+				break
+			}
+		}
+
 		width, height = w.SDLWin.GetSize()
 		fbWidth, fbHeight = w.SDLWin.GLGetDrawableSize()
 
@@ -48,19 +73,76 @@ func Run(g Game, w *Window, rend renderer.Render, ui nmageimgui.ImguiInfo) {
 		w.handleInputs()
 		ui.FrameStart(float32(width), float32(height))
 
+		if renderdocApi != nil && input.KeyClicked(initOpts.RenderDocCaptureKey) {
+			TriggerCapture()
+		}
+
+		if initOpts.PerfHudKey != sdl.K_UNKNOWN && input.KeyClicked(initOpts.PerfHudKey) {
+			ShowPerfHud(!IsPerfHudShown())
+		}
+
+		for _, s := range preUpdaters {
+			s.PreUpdate()
+		}
+
+		tween.Update(timing.DT())
+		updateTasks()
+
+		for _, s := range updaters {
+			s.Update()
+		}
+
 		g.Update()
+		audio.Update()
+
+		for _, s := range postUpdaters {
+			s.PostUpdate()
+		}
+
+		if perfHud.isShown {
+			perfHud.draw(rend.LastFrameStats())
+		}
 
 		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT | gl.STENCIL_BUFFER_BIT)
+
+		for _, s := range preRenderers {
+			s.PreRender()
+		}
+
 		g.Render()
-		ui.Render(float32(width), float32(height), fbWidth, fbHeight)
+
+		for _, s := range postRenderers {
+			s.PostRender()
+		}
+
+		if len(overlays) > 0 {
+
+			state := beginOverlayPass(w, fbWidth, fbHeight)
+			for _, s := range overlays {
+				s.RenderOverlay(fbWidth, fbHeight)
+			}
+			endOverlayPass(&state)
+		}
+
+		ui.RenderLayer(fbWidth, fbHeight)
+		ui.RenderViewports(w.SDLWin, w.GlCtx)
 		w.SDLWin.GLSwap()
 
 		g.FrameEnd()
 		rend.FrameEnd()
+
+		w.paceFrame()
 		timing.FrameEnded()
 	}
 
+	// Tasks are stopped first so their deferred calls can still use the game
+	StopAllTasks()
 	g.DeInit()
+	ui.DisableViewports()
+	rend.Delete()
+
+	// Everything the game created should be deleted by now, so anything left is a leak
+	leakcheck.Report()
 }
 
 func Quit() {
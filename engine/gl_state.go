@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bloeys/nmage/assert"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// EnableAssertGlStateDump makes failed asserts log the current GL state (see GlStateDump) before panicking.
+// Since the dump makes GL calls, asserts in goroutines other than the GL thread should not fail while this is enabled
+func EnableAssertGlStateDump(isEnabled bool) {
+
+	if isEnabled {
+		assert.SetDiagnostics(GlStateDump)
+	} else {
+		assert.SetDiagnostics(nil)
+	}
+}
+
+// GlStateDump returns the pending GL errors, bound objects, viewport and some common capabilities of the current context.
+// Must be called on the GL thread. Note that this clears the GL error flags
+func GlStateDump() string {
+
+	if !isGlInfoLoaded {
+		return "No OpenGL context was created"
+	}
+
+	sb := strings.Builder{}
+
+	// There can be multiple error flags set, and each GetError call clears one
+	errs := make([]string, 0)
+	for glErr := gl.GetError(); glErr != gl.NO_ERROR && len(errs) < 16; glErr = gl.GetError() {
+		errs = append(errs, glErrorName(glErr))
+	}
+
+	if len(errs) == 0 {
+		sb.WriteString("GL errors: none\n")
+	} else {
+		sb.WriteString("GL errors: " + strings.Join(errs, ", ") + "\n")
+	}
+
+	fmt.Fprintf(&sb, "Draw FBO: %d\n", glGetInt(gl.DRAW_FRAMEBUFFER_BINDING))
+	fmt.Fprintf(&sb, "Read FBO: %d\n", glGetInt(gl.READ_FRAMEBUFFER_BINDING))
+	fmt.Fprintf(&sb, "Draw FBO status: %s\n", glFramebufferStatusName(gl.CheckFramebufferStatus(gl.DRAW_FRAMEBUFFER)))
+	fmt.Fprintf(&sb, "Program: %d\n", glGetInt(gl.CURRENT_PROGRAM))
+	fmt.Fprintf(&sb, "VAO: %d\n", glGetInt(gl.VERTEX_ARRAY_BINDING))
+	fmt.Fprintf(&sb, "Array buffer: %d\n", glGetInt(gl.ARRAY_BUFFER_BINDING))
+	fmt.Fprintf(&sb, "Element array buffer: %d\n", glGetInt(gl.ELEMENT_ARRAY_BUFFER_BINDING))
+	fmt.Fprintf(&sb, "Uniform buffer: %d\n", glGetInt(gl.UNIFORM_BUFFER_BINDING))
+	fmt.Fprintf(&sb, "Active texture unit: %d\n", glGetInt(gl.ACTIVE_TEXTURE)-gl.TEXTURE0)
+	fmt.Fprintf(&sb, "Texture 2D: %d\n", glGetInt(gl.TEXTURE_BINDING_2D))
+
+	var viewport [4]int32
+	gl.GetIntegerv(gl.VIEWPORT, &viewport[0])
+	fmt.Fprintf(&sb, "Viewport: x=%d y=%d w=%d h=%d\n", viewport[0], viewport[1], viewport[2], viewport[3])
+
+	var scissor [4]int32
+	gl.GetIntegerv(gl.SCISSOR_BOX, &scissor[0])
+	fmt.Fprintf(&sb, "Scissor: enabled=%t x=%d y=%d w=%d h=%d\n", gl.IsEnabled(gl.SCISSOR_TEST), scissor[0], scissor[1], scissor[2], scissor[3])
+
+	fmt.Fprintf(&sb, "Depth test: %t\n", gl.IsEnabled(gl.DEPTH_TEST))
+	fmt.Fprintf(&sb, "Stencil test: %t\n", gl.IsEnabled(gl.STENCIL_TEST))
+	fmt.Fprintf(&sb, "Blend: %t\n", gl.IsEnabled(gl.BLEND))
+	fmt.Fprintf(&sb, "Cull face: %t\n", gl.IsEnabled(gl.CULL_FACE))
+
+	return sb.String()
+}
+
+func glGetInt(pname uint32) int32 {
+
+	var v int32
+	gl.GetIntegerv(pname, &v)
+	return v
+}
+
+func glErrorName(glErr uint32) string {
+
+	switch glErr {
+	case gl.INVALID_ENUM:
+		return "GL_INVALID_ENUM"
+	case gl.INVALID_VALUE:
+		return "GL_INVALID_VALUE"
+	case gl.INVALID_OPERATION:
+		return "GL_INVALID_OPERATION"
+	case gl.INVALID_FRAMEBUFFER_OPERATION:
+		return "GL_INVALID_FRAMEBUFFER_OPERATION"
+	case gl.OUT_OF_MEMORY:
+		return "GL_OUT_OF_MEMORY"
+	case gl.STACK_UNDERFLOW:
+		return "GL_STACK_UNDERFLOW"
+	case gl.STACK_OVERFLOW:
+		return "GL_STACK_OVERFLOW"
+	default:
+		return fmt.Sprintf("0x%X", glErr)
+	}
+}
+
+func glFramebufferStatusName(status uint32) string {
+
+	switch status {
+	case gl.FRAMEBUFFER_COMPLETE:
+		return "complete"
+	case gl.FRAMEBUFFER_UNDEFINED:
+		return "undefined"
+	case gl.FRAMEBUFFER_INCOMPLETE_ATTACHMENT:
+		return "incomplete attachment"
+	case gl.FRAMEBUFFER_INCOMPLETE_MISSING_ATTACHMENT:
+		return "missing attachment"
+	case gl.FRAMEBUFFER_INCOMPLETE_DRAW_BUFFER:
+		return "incomplete draw buffer"
+	case gl.FRAMEBUFFER_INCOMPLETE_READ_BUFFER:
+		return "incomplete read buffer"
+	case gl.FRAMEBUFFER_UNSUPPORTED:
+		return "unsupported"
+	case gl.FRAMEBUFFER_INCOMPLETE_MULTISAMPLE:
+		return "incomplete multisample"
+	case gl.FRAMEBUFFER_INCOMPLETE_LAYER_TARGETS:
+		return "incomplete layer targets"
+	default:
+		return fmt.Sprintf("0x%X", status)
+	}
+}
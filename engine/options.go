@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"github.com/bloeys/nmage/logging"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Options are engine wide settings passed to engine.Init
+type Options struct {
+	// AssetRoot is the folder relative asset paths are loaded from. Empty means relative to the working directory
+	AssetRoot string
+
+	LogLevel logging.Level
+
+	// LogFile, if set, adds a rotating file sink that writes logs to this path
+	LogFile         string
+	LogFileRotation logging.RotatingFileSinkOptions
+
+	// CrashReportDir is where HandleCrash writes crash reports
+	CrashReportDir string
+
+	// AssertGlStateDump logs the GL state when an assert fails. See EnableAssertGlStateDump
+	AssertGlStateDump bool
+
+	// RenderDoc loads the RenderDoc in-application api in Init, so frames can be captured with RenderDocCaptureKey or TriggerCapture.
+	// RenderDoc must be installed so its library can be found, or the game launched from RenderDoc
+	RenderDoc           bool
+	RenderDocCaptureKey sdl.Keycode
+
+	// PerfHud shows the performance overlay from the start (see ShowPerfHud), and PerfHudKey toggles it. sdl.K_UNKNOWN disables the key
+	PerfHud    bool
+	PerfHudKey sdl.Keycode
+}
+
+func DefaultOptions() Options {
+	return Options{
+		AssetRoot: "",
+		LogLevel:  logging.Level_Info,
+		LogFile:   "",
+		LogFileRotation: logging.RotatingFileSinkOptions{
+			MaxSizeBytes: 10 * 1024 * 1024,
+			MaxBackups:   5,
+		},
+		CrashReportDir:    "./crash_reports",
+		AssertGlStateDump: false,
+
+		RenderDoc: false,
+		// RenderDoc's own default capture key is F12, which would capture twice if this was the same
+		RenderDocCaptureKey: sdl.K_F11,
+
+		PerfHud:    false,
+		PerfHudKey: sdl.K_F3,
+	}
+}
+
+// WindowOptions control how a window and its OpenGL context are created.
+// New fields are added here with defaults in DefaultWindowOptions, so callers don't break when capabilities are added
+type WindowOptions struct {
+	Title string
+
+	// X and Y are the window position. Use WindowPos_Centered to center the window, or WindowPosCenteredOnDisplay to center it on a specific display
+	X int32
+	Y int32
+
+	Width  int32
+	Height int32
+
+	GlMajorVersion int32
+	GlMinorVersion int32
+
+	// Srgb enables GL_FRAMEBUFFER_SRGB, so writes to the default framebuffer are converted from linear to sRGB
+	Srgb bool
+
+	// MsaaSamples is the number of samples per pixel of the default framebuffer. Zero disables MSAA
+	MsaaSamples int32
+
+	VSync bool
+
+	// AdaptiveVSync makes vsync show late frames right away instead of waiting for the next refresh, which tears a little but
+	// avoids dropping to half the refresh rate when a frame takes slightly too long. Only used with VSync, and falls back to normal vsync when not supported
+	AdaptiveVSync bool
+
+	Resizable bool
+
+	// HighDPI requests a full resolution framebuffer on high DPI displays (e.g. Retina).
+	// The framebuffer size might then differ from the window size, so use Window.SDLWin.GLGetDrawableSize for rendering sizes
+	HighDPI bool
+
+	// DebugContext creates an OpenGL debug context, which is slower but gives more information when used with GL debug tools
+	DebugContext bool
+
+	// RobustContext creates a context that reports driver resets and GPU hangs as context loss, so the engine can recreate the
+	// context instead of rendering with a dead one. Falls back to a normal context if the driver doesn't support it. See ContextLossHandler.
+	// Off by default since robust contexts can be slower on some drivers
+	RobustContext bool
+
+	// Flags are extra SDL window flags. WindowFlags_OPENGL is always added
+	Flags WindowFlags
+}
+
+const (
+	WindowPos_Centered  int32 = sdl.WINDOWPOS_CENTERED
+	WindowPos_Undefined int32 = sdl.WINDOWPOS_UNDEFINED
+)
+
+func DefaultWindowOptions() WindowOptions {
+	return WindowOptions{
+		Title:          "nMage",
+		X:              WindowPos_Centered,
+		Y:              WindowPos_Centered,
+		Width:          1280,
+		Height:         720,
+		GlMajorVersion: 4,
+		GlMinorVersion: 1,
+		Srgb:           true,
+		MsaaSamples:    4,
+		VSync:          false,
+		AdaptiveVSync:  false,
+		Resizable:      true,
+		HighDPI:        false,
+		DebugContext:   false,
+		RobustContext:  false,
+	}
+}
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// OverlayRenderer draws screen space things like HUDs, sprites and text after the game was rendered and tonemapped, and under imgui.
+//
+// Overlays are drawn straight into the window with their own state, so their colors are shown as they were authored instead
+// of being changed by the scene's exposure, or gamma corrected twice when drawn into an HDR buffer that is gamma corrected later.
+// Overlay shaders output linear colors, and the window converts them to sRGB when created with Options.Srgb
+type OverlayRenderer interface {
+	RenderOverlay(fbWidth, fbHeight int32)
+}
+
+// overlayState is the GL state changed by the overlay pass, which is restored after it
+type overlayState struct {
+	fbo      int32
+	viewport [4]int32
+
+	isDepthTestEnabled bool
+	isCullingEnabled   bool
+	isBlendEnabled     bool
+	isSrgbEnabled      bool
+
+	blendSrcRgb   int32
+	blendDstRgb   int32
+	blendSrcAlpha int32
+	blendDstAlpha int32
+}
+
+// beginOverlayPass draws into the whole window with alpha blending and without depth testing or culling, so overlays are drawn
+// in order and can use either winding. sRGB conversion follows the window's Options.Srgb whatever the game last set
+func beginOverlayPass(w *Window, fbWidth, fbHeight int32) overlayState {
+
+	var s overlayState
+	gl.GetIntegerv(gl.DRAW_FRAMEBUFFER_BINDING, &s.fbo)
+	gl.GetIntegerv(gl.VIEWPORT, &s.viewport[0])
+	gl.GetIntegerv(gl.BLEND_SRC_RGB, &s.blendSrcRgb)
+	gl.GetIntegerv(gl.BLEND_DST_RGB, &s.blendDstRgb)
+	gl.GetIntegerv(gl.BLEND_SRC_ALPHA, &s.blendSrcAlpha)
+	gl.GetIntegerv(gl.BLEND_DST_ALPHA, &s.blendDstAlpha)
+	s.isDepthTestEnabled = gl.IsEnabled(gl.DEPTH_TEST)
+	s.isCullingEnabled = gl.IsEnabled(gl.CULL_FACE)
+	s.isBlendEnabled = gl.IsEnabled(gl.BLEND)
+	s.isSrgbEnabled = gl.IsEnabled(gl.FRAMEBUFFER_SRGB)
+
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	gl.Viewport(0, 0, fbWidth, fbHeight)
+
+	gl.Disable(gl.DEPTH_TEST)
+	gl.Disable(gl.CULL_FACE)
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+	SetSrgbFramebuffer(w.opts.Srgb)
+
+	return s
+}
+
+func endOverlayPass(s *overlayState) {
+
+	gl.BindFramebuffer(gl.FRAMEBUFFER, uint32(s.fbo))
+	gl.Viewport(s.viewport[0], s.viewport[1], s.viewport[2], s.viewport[3])
+	gl.BlendFuncSeparate(uint32(s.blendSrcRgb), uint32(s.blendDstRgb), uint32(s.blendSrcAlpha), uint32(s.blendDstAlpha))
+
+	setGlCap(gl.DEPTH_TEST, s.isDepthTestEnabled)
+	setGlCap(gl.CULL_FACE, s.isCullingEnabled)
+	setGlCap(gl.BLEND, s.isBlendEnabled)
+	SetSrgbFramebuffer(s.isSrgbEnabled)
+}
+
+func setGlCap(glCap uint32, isEnabled bool) {
+
+	if isEnabled {
+		gl.Enable(glCap)
+	} else {
+		gl.Disable(glCap)
+	}
+}
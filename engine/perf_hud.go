@@ -0,0 +1,106 @@
+package engine
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"time"
+
+	imgui "github.com/AllenDang/cimgui-go"
+	"github.com/bloeys/nmage/renderer"
+	"github.com/bloeys/nmage/timing"
+)
+
+// perfHudMemInterval is how often memory stats are read, since runtime.ReadMemStats stops the world
+const perfHudMemInterval = 500 * time.Millisecond
+
+var (
+	perfHud = perfHudState{
+		frameTimesMs: make([]float32, 0, timing.FrameHistorySize),
+	}
+)
+
+type perfHudState struct {
+	isShown bool
+
+	// frameTimesMs is reused to get the frame history for the graph
+	frameTimesMs []float32
+
+	gpuScopes   *renderer.GpuScopes
+	gpuScopeIds []string
+
+	memStats    runtime.MemStats
+	lastMemRead time.Time
+}
+
+// ShowPerfHud shows or hides an overlay in the top right corner of the window with the FPS, frame time percentiles,
+// draw calls, GPU scope times and memory use. The overlay can also be toggled with Options.PerfHudKey
+func ShowPerfHud(show bool) {
+	perfHud.isShown = show
+}
+
+func IsPerfHudShown() bool {
+	return perfHud.isShown
+}
+
+// SetPerfHudGpuScopes makes the perf HUD show the latest GPU time of every scope in gs. Nil removes the GPU times
+func SetPerfHudGpuScopes(gs *renderer.GpuScopes) {
+	perfHud.gpuScopes = gs
+}
+
+func (ph *perfHudState) draw(rendStats renderer.RenderStats) {
+
+	const pad = 10
+	vp := imgui.MainViewport()
+	workPos := vp.WorkPos()
+	workSize := vp.WorkSize()
+
+	imgui.SetNextWindowPosV(imgui.Vec2{X: workPos.X + workSize.X - pad, Y: workPos.Y + pad}, imgui.CondAlways, imgui.Vec2{X: 1, Y: 0})
+	imgui.SetNextWindowViewport(vp.ID())
+	imgui.SetNextWindowBgAlpha(0.6)
+
+	flags := imgui.WindowFlagsNoDecoration | imgui.WindowFlagsAlwaysAutoResize | imgui.WindowFlagsNoSavedSettings |
+		imgui.WindowFlagsNoFocusOnAppearing | imgui.WindowFlagsNoNav | imgui.WindowFlagsNoDocking | imgui.WindowFlagsNoInputs
+
+	if !imgui.BeginV("##nmage_perf_hud", nil, flags) {
+		imgui.End()
+		return
+	}
+
+	stats := timing.GetFrameStats()
+	imgui.Text(fmt.Sprintf("FPS: %.0f (%.2f ms)", timing.GetAvgFPS(), timing.UnscaledDT()*1000))
+	imgui.Text(fmt.Sprintf("p50: %.2f ms  p95: %.2f ms  p99: %.2f ms", stats.P50, stats.P95, stats.P99))
+
+	ph.frameTimesMs = timing.FrameHistory(ph.frameTimesMs[:0])
+	imgui.PlotLinesFloatPtrV("##frame_times", ph.frameTimesMs, int32(len(ph.frameTimesMs)), 0, "", 0, max(stats.P99*1.5, 16.6), imgui.Vec2{X: 260, Y: 40}, 4)
+
+	imgui.Text(fmt.Sprintf("Draw calls: %d  Triangles: %d", rendStats.DrawCalls, rendStats.Triangles))
+
+	if ph.gpuScopes != nil && len(ph.gpuScopes.LastDurations) > 0 {
+
+		imgui.SeparatorText("GPU")
+
+		// Map order changes every frame, so scopes are sorted to keep the lines in place
+		ph.gpuScopeIds = ph.gpuScopeIds[:0]
+		for name := range ph.gpuScopes.LastDurations {
+			ph.gpuScopeIds = append(ph.gpuScopeIds, name)
+		}
+		slices.Sort(ph.gpuScopeIds)
+
+		for _, name := range ph.gpuScopeIds {
+			dur := ph.gpuScopes.LastDurations[name]
+			imgui.Text(fmt.Sprintf("%s: %.3f ms", name, float64(dur.Microseconds())/1000))
+		}
+	}
+
+	if time.Since(ph.lastMemRead) > perfHudMemInterval {
+		runtime.ReadMemStats(&ph.memStats)
+		ph.lastMemRead = time.Now()
+	}
+
+	imgui.SeparatorText("Memory")
+	imgui.Text(fmt.Sprintf("Heap: %.1f MB  Sys: %.1f MB", float64(ph.memStats.HeapAlloc)/(1024*1024), float64(ph.memStats.Sys)/(1024*1024)))
+	imgui.Text(fmt.Sprintf("GCs: %d  Goroutines: %d", ph.memStats.NumGC, runtime.NumGoroutine()))
+
+	imgui.End()
+}
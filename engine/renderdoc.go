@@ -0,0 +1,174 @@
+package engine
+
+/*
+#include <stdint.h>
+#include <stddef.h>
+#include <stdlib.h>
+
+// renderdocApi is the start of RENDERDOC_API_1_1_2 from renderdoc_app.h. The struct is only function pointers,
+// so entries that aren't called here are kept as void pointers to keep the layout
+typedef struct {
+    void *GetAPIVersion;
+    void *SetCaptureOptionU32;
+    void *SetCaptureOptionF32;
+    void *GetCaptureOptionU32;
+    void *GetCaptureOptionF32;
+    void *SetFocusToggleKeys;
+    void *SetCaptureKeys;
+    void *GetOverlayBits;
+    void *MaskOverlayBits;
+    void *RemoveHooks;
+    void *UnloadCrashHandler;
+    void (*SetCaptureFilePathTemplate)(const char *pathTemplate);
+    void *GetCaptureFilePathTemplate;
+    uint32_t (*GetNumCaptures)(void);
+    void *GetCapture;
+    void (*TriggerCapture)(void);
+    void *IsTargetControlConnected;
+    uint32_t (*LaunchReplayUI)(uint32_t connectTargetControl, const char *cmdLine);
+    void *SetActiveWindow;
+    void *StartFrameCapture;
+    uint32_t (*IsFrameCapturing)(void);
+    void *EndFrameCapture;
+    void (*TriggerMultiFrameCapture)(uint32_t numFrames);
+} renderdocApi;
+
+typedef int (*renderdocGetApiFn)(int version, void **outApiPointers);
+
+static renderdocApi *renderdocGetApi(void *getApiFn)
+{
+    // 10102 is eRENDERDOC_API_Version_1_1_2
+    void *api = NULL;
+    if (!((renderdocGetApiFn)getApiFn)(10102, &api))
+        return NULL;
+
+    return (renderdocApi *)api;
+}
+
+static void renderdocSetCaptureFilePathTemplate(renderdocApi *api, const char *pathTemplate) { api->SetCaptureFilePathTemplate(pathTemplate); }
+static uint32_t renderdocGetNumCaptures(renderdocApi *api) { return api->GetNumCaptures(); }
+static void renderdocTriggerCapture(renderdocApi *api) { api->TriggerCapture(); }
+static void renderdocTriggerMultiFrameCapture(renderdocApi *api, uint32_t numFrames) { api->TriggerMultiFrameCapture(numFrames); }
+static uint32_t renderdocIsFrameCapturing(renderdocApi *api) { return api->IsFrameCapturing(); }
+static uint32_t renderdocLaunchReplayUI(renderdocApi *api) { return api->LaunchReplayUI(1, NULL); }
+*/
+import "C"
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"github.com/bloeys/nmage/logging"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+var (
+	renderdocLib sdl.SharedObject
+	renderdocApi *C.renderdocApi
+)
+
+// initRenderDoc loads the RenderDoc in-application API. RenderDoc hooks OpenGL when it's loaded,
+// so this must run before any window or GL context is created.
+//
+// If the game was launched from RenderDoc the library is already loaded and this only gets the api
+func initRenderDoc() error {
+
+	libName := "librenderdoc.so"
+	switch runtime.GOOS {
+	case "windows":
+		libName = "renderdoc.dll"
+	case "darwin":
+		return fmt.Errorf("RenderDoc is not supported on macOS")
+	}
+
+	renderdocLib = sdl.LoadObject(libName)
+	if renderdocLib == 0 {
+		return fmt.Errorf("failed to load RenderDoc library '%s'. Is RenderDoc installed and in the library path? Err: %s", libName, sdl.GetError())
+	}
+
+	getApiFn := renderdocLib.LoadFunction("RENDERDOC_GetAPI")
+	if getApiFn == nil {
+		renderdocLib.Unload()
+		renderdocLib = 0
+		return fmt.Errorf("failed to find RENDERDOC_GetAPI in RenderDoc library '%s'", libName)
+	}
+
+	renderdocApi = C.renderdocGetApi(getApiFn)
+	if renderdocApi == nil {
+		renderdocLib.Unload()
+		renderdocLib = 0
+		return fmt.Errorf("RenderDoc library '%s' doesn't support api version 1.1.2", libName)
+	}
+
+	logging.InfoLog.Printf("Loaded RenderDoc. Press %s or call engine.TriggerCapture to capture a frame\n", sdl.GetKeyName(initOpts.RenderDocCaptureKey))
+	return nil
+}
+
+// IsRenderDocLoaded reports whether the RenderDoc api was loaded, which requires Options.RenderDoc
+func IsRenderDocLoaded() bool {
+	return renderdocApi != nil
+}
+
+// TriggerCapture makes RenderDoc capture the next frame. Does nothing if RenderDoc isn't loaded
+func TriggerCapture() {
+	TriggerMultiFrameCapture(1)
+}
+
+// TriggerMultiFrameCapture makes RenderDoc capture the next frameCount frames, which helps with issues that last a few frames.
+// Does nothing if RenderDoc isn't loaded
+func TriggerMultiFrameCapture(frameCount uint32) {
+
+	if renderdocApi == nil {
+		logging.WarnLog.Println("Ignoring RenderDoc capture request because RenderDoc isn't loaded. Enable it with Options.RenderDoc")
+		return
+	}
+
+	if frameCount == 1 {
+		C.renderdocTriggerCapture(renderdocApi)
+	} else {
+		C.renderdocTriggerMultiFrameCapture(renderdocApi, C.uint32_t(frameCount))
+	}
+}
+
+// IsFrameCapturing reports whether RenderDoc is capturing the current frame
+func IsFrameCapturing() bool {
+	return renderdocApi != nil && C.renderdocIsFrameCapturing(renderdocApi) != 0
+}
+
+// RenderDocCaptureCount returns how many captures were made since the game started
+func RenderDocCaptureCount() int {
+
+	if renderdocApi == nil {
+		return 0
+	}
+
+	return int(C.renderdocGetNumCaptures(renderdocApi))
+}
+
+// SetRenderDocCapturePathTemplate sets where captures are saved, where a template like 'captures/nmage'
+// saves files like 'captures/nmage_frame123.rdc'
+func SetRenderDocCapturePathTemplate(pathTemplate string) {
+
+	if renderdocApi == nil {
+		return
+	}
+
+	cPathTemplate := C.CString(pathTemplate)
+	defer C.free(unsafe.Pointer(cPathTemplate))
+	C.renderdocSetCaptureFilePathTemplate(renderdocApi, cPathTemplate)
+}
+
+// LaunchRenderDocUI opens the RenderDoc replay UI connected to the game, so captures can be opened as they are made
+func LaunchRenderDocUI() error {
+
+	if renderdocApi == nil {
+		return fmt.Errorf("failed to launch RenderDoc UI because RenderDoc isn't loaded")
+	}
+
+	if C.renderdocLaunchReplayUI(renderdocApi) == 0 {
+		return fmt.Errorf("failed to launch RenderDoc UI")
+	}
+
+	return nil
+}
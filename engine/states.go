@@ -0,0 +1,407 @@
+package engine
+
+import (
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/color"
+	"github.com/bloeys/nmage/logging"
+	"github.com/bloeys/nmage/timing"
+	"github.com/bloeys/nmage/ui/gameui"
+)
+
+// State is a screen of the game like a main menu, a loading screen or the game itself, managed by a StateManager.
+// It is the part of the Game interface a screen needs
+type State interface {
+	// Init is called on the main thread when the state becomes active, after Preload finished if the state is a StatePreloader
+	Init()
+
+	Update()
+	Render()
+
+	// DeInit is called when the state is popped or replaced
+	DeInit()
+}
+
+// StatePauser is implemented by states that need to know when a state is pushed on top of them (Pause)
+// and when that state is popped (Resume)
+type StatePauser interface {
+	Pause()
+	Resume()
+}
+
+// StatePreloader is implemented by states with slow loading, like reading levels from disk. Preload runs on another goroutine
+// while the StateManager shows its LoadingState, so it must not make GL calls. GL objects are created in Init instead
+type StatePreloader interface {
+	Preload() error
+}
+
+// TransparentState is implemented by states that show the state under them, like pause menus.
+// While IsTransparent returns true the state under it is rendered first, but isn't updated
+type TransparentState interface {
+	IsTransparent() bool
+}
+
+type stateChangeType int32
+
+const (
+	stateChangeType_Push stateChangeType = iota
+	stateChangeType_Pop
+	stateChangeType_Replace
+)
+
+type stateChange struct {
+	Type  stateChangeType
+	State State
+}
+
+type statePhase int32
+
+const (
+	statePhase_None statePhase = iota
+	statePhase_FadeOut
+	statePhase_Loading
+	statePhase_LoadingFadeOut
+	statePhase_FadeIn
+)
+
+// StateManager is a stack of states where only the top state is updated. It implements Game, so it can be passed to Run directly.
+//
+// Changes are queued and applied one at a time between frames, so a state can push, pop or replace states from its own Update.
+// Each change fades the screen to FadeColor, applies the change and fades back in. Changes to a StatePreloader show
+// LoadingState while the new state preloads
+type StateManager struct {
+	// FadeSeconds is how long fading out and fading in each take. Zero changes states instantly
+	FadeSeconds float32
+	FadeColor   color.Color
+
+	// LoadingState is shown while a state preloads. Without one the screen stays faded out while loading
+	LoadingState State
+
+	// OnPreloadError is called when a state's Preload fails, after which the change is dropped and the current state stays.
+	// Errors are logged when it is nil
+	OnPreloadError func(s State, err error)
+
+	win *Window
+
+	stack   []State
+	pending []stateChange
+
+	phase       statePhase
+	fadeAlpha   float32
+	fadeTarget  float32
+	isLoading   bool
+	preloadDone chan error
+	preloadErr  error
+
+	// fadeBatch is created the first time a fade is drawn
+	fadeBatch *gameui.Batch
+}
+
+var _ Game = &StateManager{}
+
+// Push pauses the current state and makes s the top state
+func (sm *StateManager) Push(s State) {
+	sm.pending = append(sm.pending, stateChange{Type: stateChangeType_Push, State: s})
+}
+
+// Pop removes the top state and resumes the one under it
+func (sm *StateManager) Pop() {
+	sm.pending = append(sm.pending, stateChange{Type: stateChangeType_Pop})
+}
+
+// Replace removes the top state and makes s the top state, like going from a loading screen into the game
+func (sm *StateManager) Replace(s State) {
+	sm.pending = append(sm.pending, stateChange{Type: stateChangeType_Replace, State: s})
+}
+
+// Top returns the top state, or nil if the stack is empty
+func (sm *StateManager) Top() State {
+
+	if len(sm.stack) == 0 {
+		return nil
+	}
+
+	return sm.stack[len(sm.stack)-1]
+}
+
+func (sm *StateManager) StateCount() int {
+	return len(sm.stack)
+}
+
+// IsTransitioning returns true while a state change is fading or loading
+func (sm *StateManager) IsTransitioning() bool {
+	return sm.phase != statePhase_None
+}
+
+// Init inits the initial state. Called by Run when the manager is the game
+func (sm *StateManager) Init() {
+	for i := 0; i < len(sm.stack); i++ {
+		sm.stack[i].Init()
+	}
+}
+
+func (sm *StateManager) Update() {
+
+	sm.updateTransition()
+
+	if sm.isLoading {
+		sm.LoadingState.Update()
+		return
+	}
+
+	if top := sm.Top(); top != nil {
+		top.Update()
+	}
+}
+
+func (sm *StateManager) updateTransition() {
+
+	// Fades use real time so they aren't stopped by pausing the game
+	if sm.fadeAlpha < sm.fadeTarget {
+		sm.fadeAlpha = min(sm.fadeAlpha+sm.fadeStep(), sm.fadeTarget)
+	} else if sm.fadeAlpha > sm.fadeTarget {
+		sm.fadeAlpha = max(sm.fadeAlpha-sm.fadeStep(), sm.fadeTarget)
+	}
+
+	isFadeDone := sm.fadeAlpha == sm.fadeTarget
+	switch sm.phase {
+	case statePhase_None:
+
+		if len(sm.pending) > 0 {
+			sm.phase = statePhase_FadeOut
+			sm.fadeTarget = 1
+			sm.updateTransition()
+		}
+
+	case statePhase_FadeOut:
+
+		if !isFadeDone {
+			return
+		}
+
+		preloader, ok := sm.pending[0].State.(StatePreloader)
+		if !ok {
+			sm.applyPendingChange()
+			sm.phase = statePhase_FadeIn
+			sm.fadeTarget = 0
+			return
+		}
+
+		sm.preloadErr = nil
+		sm.preloadDone = make(chan error, 1)
+		go func() {
+			sm.preloadDone <- preloader.Preload()
+		}()
+
+		if sm.LoadingState != nil {
+			sm.LoadingState.Init()
+			sm.isLoading = true
+			sm.fadeTarget = 0
+		}
+		sm.phase = statePhase_Loading
+
+	case statePhase_Loading:
+
+		if sm.preloadDone != nil {
+			select {
+			case sm.preloadErr = <-sm.preloadDone:
+				sm.preloadDone = nil
+			default:
+				return
+			}
+		}
+
+		if !isFadeDone {
+			return
+		}
+
+		if sm.isLoading {
+			sm.phase = statePhase_LoadingFadeOut
+			sm.fadeTarget = 1
+			return
+		}
+
+		sm.finishLoading()
+
+	case statePhase_LoadingFadeOut:
+
+		if !isFadeDone {
+			return
+		}
+
+		sm.LoadingState.DeInit()
+		sm.isLoading = false
+		sm.finishLoading()
+
+	case statePhase_FadeIn:
+
+		if isFadeDone {
+			sm.phase = statePhase_None
+		}
+	}
+}
+
+// finishLoading applies the preloaded change, or drops it if preloading failed
+func (sm *StateManager) finishLoading() {
+
+	if sm.preloadErr != nil {
+
+		change := sm.pending[0]
+		sm.pending = sm.pending[1:]
+
+		if sm.OnPreloadError != nil {
+			sm.OnPreloadError(change.State, sm.preloadErr)
+		} else {
+			logging.ErrLog.Printf("Failed to preload state, so the state change was dropped. Err: %v\n", sm.preloadErr)
+		}
+	} else {
+		sm.applyPendingChange()
+	}
+
+	sm.phase = statePhase_FadeIn
+	sm.fadeTarget = 0
+}
+
+func (sm *StateManager) fadeStep() float32 {
+
+	if sm.FadeSeconds <= 0 {
+		return 1
+	}
+
+	return timing.UnscaledDT() / sm.FadeSeconds
+}
+
+func (sm *StateManager) applyPendingChange() {
+
+	change := sm.pending[0]
+	sm.pending = sm.pending[1:]
+
+	switch change.Type {
+	case stateChangeType_Push:
+
+		if p, ok := sm.Top().(StatePauser); ok {
+			p.Pause()
+		}
+
+		change.State.Init()
+		sm.stack = append(sm.stack, change.State)
+
+	case stateChangeType_Pop:
+
+		top := sm.Top()
+		if top == nil {
+			logging.WarnLog.Println("Ignoring state pop since there are no states")
+			return
+		}
+
+		top.DeInit()
+		sm.stack[len(sm.stack)-1] = nil
+		sm.stack = sm.stack[:len(sm.stack)-1]
+
+		if p, ok := sm.Top().(StatePauser); ok {
+			p.Resume()
+		}
+
+	case stateChangeType_Replace:
+
+		if top := sm.Top(); top != nil {
+			top.DeInit()
+			sm.stack = sm.stack[:len(sm.stack)-1]
+		}
+
+		change.State.Init()
+		sm.stack = append(sm.stack, change.State)
+	}
+}
+
+// Render draws the top state, with the states under it first while the states above them are transparent, then the fade on top
+func (sm *StateManager) Render() {
+
+	if sm.isLoading {
+		sm.LoadingState.Render()
+	} else {
+		sm.renderStack()
+	}
+
+	if sm.fadeAlpha <= 0 {
+		return
+	}
+
+	if sm.fadeBatch == nil {
+		b := gameui.NewBatch()
+		sm.fadeBatch = &b
+	}
+
+	fbWidth, fbHeight := sm.win.SDLWin.GLGetDrawableSize()
+
+	c := sm.FadeColor
+	c.SetA(c.A() * sm.fadeAlpha)
+	sm.fadeBatch.AddQuadUV(gameui.Rect{W: float32(fbWidth), H: float32(fbHeight)}, 0, gglm.NewVec2(0, 0), gglm.NewVec2(1, 1), &c)
+	sm.fadeBatch.Flush(float32(fbWidth), float32(fbHeight), fbWidth, fbHeight)
+}
+
+func (sm *StateManager) renderStack() {
+
+	if len(sm.stack) == 0 {
+		return
+	}
+
+	bottom := len(sm.stack) - 1
+	for bottom > 0 {
+
+		ts, ok := sm.stack[bottom].(TransparentState)
+		if !ok || !ts.IsTransparent() {
+			break
+		}
+
+		bottom--
+	}
+
+	for i := bottom; i < len(sm.stack); i++ {
+		sm.stack[i].Render()
+	}
+}
+
+func (sm *StateManager) FrameEnd() {
+}
+
+// DeInit deinits all states from the top down. A running preload isn't waited on, and the state it was loading isn't deinited since it never got Init
+func (sm *StateManager) DeInit() {
+
+	if sm.isLoading {
+		sm.LoadingState.DeInit()
+		sm.isLoading = false
+	}
+
+	for i := len(sm.stack) - 1; i >= 0; i-- {
+		sm.stack[i].DeInit()
+	}
+
+	clear(sm.stack)
+	sm.stack = sm.stack[:0]
+	sm.pending = sm.pending[:0]
+	sm.phase = statePhase_None
+	sm.fadeAlpha = 0
+	sm.fadeTarget = 0
+
+	if sm.fadeBatch != nil {
+		sm.fadeBatch.Delete()
+		sm.fadeBatch = nil
+	}
+}
+
+// NewStateManager returns a manager that starts in the initial state, which is Init-ed by StateManager.Init.
+// The window is used for the size of fades
+func NewStateManager(win *Window, initial State) StateManager {
+
+	sm := StateManager{
+		FadeSeconds: 0.3,
+		FadeColor:   color.NewLinear(0, 0, 0),
+		win:         win,
+	}
+
+	if initial != nil {
+		sm.stack = append(sm.stack, initial)
+	}
+
+	return sm
+}
@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"slices"
+
+	"github.com/bloeys/nmage/logging"
+)
+
+// System is a part of the game loop that runs next to Game, like physics, animation or a HUD.
+// Systems implement the phase interfaces they need (PreUpdater, Updater, PostUpdater, PreRenderer, PostRenderer and OverlayRenderer),
+// and are called by Run every frame in this order:
+//
+//	PreUpdate -> tweens and tasks -> Update -> Game.Update -> PostUpdate -> PreRender -> Game.Render -> PostRender -> RenderOverlay -> imgui
+//
+// Within a phase systems run by their order, then by when they were registered
+type System interface {
+	Name() string
+}
+
+// PreUpdater runs before Update, e.g. to read input before the game does
+type PreUpdater interface {
+	PreUpdate()
+}
+
+// Updater runs before Game.Update
+type Updater interface {
+	Update()
+}
+
+// PostUpdater runs after Game.Update, e.g. to simulate physics with the forces the game set
+type PostUpdater interface {
+	PostUpdate()
+}
+
+// PreRenderer runs after the window is cleared and before Game.Render
+type PreRenderer interface {
+	PreRender()
+}
+
+// PostRenderer runs after Game.Render with the state the game left, e.g. to draw into the game's HDR buffer.
+// Screen space drawing like HUDs should use OverlayRenderer instead
+type PostRenderer interface {
+	PostRender()
+}
+
+type registeredSystem struct {
+	sys   System
+	order int32
+}
+
+var (
+	systems []registeredSystem
+
+	// Each phase has its own list so the loop doesn't check which systems implement it every frame
+	preUpdaters   []PreUpdater
+	updaters      []Updater
+	postUpdaters  []PostUpdater
+	preRenderers  []PreRenderer
+	postRenderers []PostRenderer
+	overlays      []OverlayRenderer
+)
+
+// RegisterSystem adds a system with an order of zero. See RegisterSystemOrdered
+func RegisterSystem(sys System) {
+	RegisterSystemOrdered(sys, 0)
+}
+
+// RegisterSystemOrdered adds a system that runs before systems with a higher order in each phase.
+// Registering a system twice is ignored
+func RegisterSystemOrdered(sys System, order int32) {
+
+	if slices.ContainsFunc(systems, func(rs registeredSystem) bool { return rs.sys == sys }) {
+		logging.WarnLog.Printf("Ignoring system '%s' since it is already registered\n", sys.Name())
+		return
+	}
+
+	systems = append(systems, registeredSystem{sys: sys, order: order})
+	updateSystemPhases()
+}
+
+func UnregisterSystem(sys System) {
+
+	systems = slices.DeleteFunc(systems, func(rs registeredSystem) bool { return rs.sys == sys })
+	updateSystemPhases()
+}
+
+// Systems returns the registered systems in the order they run
+func Systems() []System {
+
+	out := make([]System, len(systems))
+	for i := 0; i < len(systems); i++ {
+		out[i] = systems[i].sys
+	}
+
+	return out
+}
+
+// updateSystemPhases sorts the systems and rebuilds the list of each phase.
+// The lists are new slices, so systems can be registered while a phase is running without changing the running list
+func updateSystemPhases() {
+
+	slices.SortStableFunc(systems, func(a, b registeredSystem) int {
+		return int(a.order) - int(b.order)
+	})
+
+	preUpdaters = nil
+	updaters = nil
+	postUpdaters = nil
+	preRenderers = nil
+	postRenderers = nil
+	overlays = nil
+
+	for _, rs := range systems {
+
+		if s, ok := rs.sys.(PreUpdater); ok {
+			preUpdaters = append(preUpdaters, s)
+		}
+
+		if s, ok := rs.sys.(Updater); ok {
+			updaters = append(updaters, s)
+		}
+
+		if s, ok := rs.sys.(PostUpdater); ok {
+			postUpdaters = append(postUpdaters, s)
+		}
+
+		if s, ok := rs.sys.(PreRenderer); ok {
+			preRenderers = append(preRenderers, s)
+		}
+
+		if s, ok := rs.sys.(PostRenderer); ok {
+			postRenderers = append(postRenderers, s)
+		}
+
+		if s, ok := rs.sys.(OverlayRenderer); ok {
+			overlays = append(overlays, s)
+		}
+	}
+}
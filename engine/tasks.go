@@ -0,0 +1,235 @@
+package engine
+
+import (
+	"iter"
+
+	"github.com/bloeys/nmage/timing"
+)
+
+// Yielder is passed to task functions, and pauses the task until the next frame that its wait is over
+type Yielder interface {
+	// WaitFrames pauses the task for frames frames, so WaitFrames(1) continues on the next frame
+	WaitFrames(frames int32)
+
+	// WaitSeconds pauses the task for seconds of game time, which follows the time scale and stops while paused
+	WaitSeconds(seconds float32)
+
+	// WaitSecondsUnscaled pauses the task for seconds of real time, e.g. for pause menu animations
+	WaitSecondsUnscaled(seconds float32)
+
+	// WaitUntil pauses the task until cond returns true. Cond is checked once per frame
+	WaitUntil(cond func() bool)
+
+	// WaitTask pauses the task until t is done
+	WaitTask(t *Task)
+}
+
+type taskWait struct {
+	frames     int32
+	seconds    float32
+	isUnscaled bool
+	cond       func() bool
+}
+
+// taskStopped is panicked inside a stopped task to unwind it, and is recovered by the task's wrapper
+type taskStopped struct{}
+
+type taskYielder struct {
+	task  *Task
+	yield func(taskWait) bool
+}
+
+var _ Yielder = &taskYielder{}
+
+func (ty *taskYielder) wait(w taskWait) {
+	if ty.task.isStopRequested || !ty.yield(w) {
+		panic(taskStopped{})
+	}
+}
+
+func (ty *taskYielder) WaitFrames(frames int32) {
+	ty.wait(taskWait{frames: max(frames, 1)})
+}
+
+func (ty *taskYielder) WaitSeconds(seconds float32) {
+	ty.wait(taskWait{seconds: seconds})
+}
+
+func (ty *taskYielder) WaitSecondsUnscaled(seconds float32) {
+	ty.wait(taskWait{seconds: seconds, isUnscaled: true})
+}
+
+func (ty *taskYielder) WaitUntil(cond func() bool) {
+	ty.wait(taskWait{cond: cond})
+}
+
+func (ty *taskYielder) WaitTask(t *Task) {
+	ty.wait(taskWait{cond: t.IsDone})
+}
+
+// Task is a function that runs across frames, pausing at the waits of its Yielder.
+//
+// Tasks are coroutines: they run on the main thread during the frame, one at a time, so they can use the engine
+// and GL like Game.Update can. Panics in tasks reach the game loop like panics in Update
+type Task struct {
+	next func() (taskWait, bool)
+	stop func()
+	wait taskWait
+
+	isRunning       bool
+	isStopRequested bool
+	isDone          bool
+}
+
+// Stop ends the task at its current wait, running its deferred calls. A task stopping itself ends at its next wait
+func (t *Task) Stop() {
+
+	if t.isDone {
+		return
+	}
+
+	if t.isRunning {
+		t.isStopRequested = true
+		return
+	}
+
+	t.isDone = true
+	t.stop()
+
+	for i := 0; i < len(activeTasks); i++ {
+
+		// Removed entries are nil-ed rather than deleted so stopping tasks from inside a task doesn't break the iteration in updateTasks
+		if activeTasks[i] == t {
+			activeTasks[i] = nil
+			return
+		}
+	}
+}
+
+func (t *Task) IsDone() bool {
+	return t.isDone
+}
+
+// resume runs the task until its next wait, marking it done when it returns
+func (t *Task) resume() {
+
+	t.isRunning = true
+	w, ok := t.next()
+	t.isRunning = false
+
+	if !ok {
+		t.isDone = true
+		return
+	}
+
+	t.wait = w
+}
+
+// update resumes the task if its wait is over and returns true once the task is done
+func (t *Task) update() (isDone bool) {
+
+	if t.isDone {
+		return true
+	}
+
+	w := &t.wait
+	switch {
+	case w.frames > 0:
+		w.frames--
+		if w.frames > 0 {
+			return false
+		}
+
+	case w.seconds > 0:
+
+		if w.isUnscaled {
+			w.seconds -= timing.UnscaledDT()
+		} else {
+			w.seconds -= timing.DT()
+		}
+
+		if w.seconds > 0 {
+			return false
+		}
+
+	case w.cond != nil:
+		if !w.cond() {
+			return false
+		}
+	}
+
+	t.wait = taskWait{}
+	t.resume()
+	return t.isDone
+}
+
+var (
+	activeTasks = make([]*Task, 0, 64)
+)
+
+// StartTask runs f until its first wait right away, then continues it every frame its wait is over until f returns.
+// For example:
+//
+//	engine.StartTask(func(y engine.Yielder) {
+//		door.Open()
+//		y.WaitSeconds(2)
+//		door.Close()
+//	})
+func StartTask(f func(y Yielder)) *Task {
+
+	t := &Task{}
+	seq := func(yield func(taskWait) bool) {
+
+		defer func() {
+			if r := recover(); r != nil {
+				if _, ok := r.(taskStopped); !ok {
+					panic(r)
+				}
+			}
+		}()
+
+		f(&taskYielder{task: t, yield: yield})
+	}
+
+	t.next, t.stop = iter.Pull(seq)
+	t.resume()
+
+	if !t.isDone {
+		activeTasks = append(activeTasks, t)
+	}
+
+	return t
+}
+
+// StopAllTasks stops every running task, e.g. when leaving a level whose tasks use its objects
+func StopAllTasks() {
+
+	for i := 0; i < len(activeTasks); i++ {
+		if activeTasks[i] != nil {
+			activeTasks[i].Stop()
+		}
+	}
+}
+
+// updateTasks continues tasks whose waits are over. Called by Run every frame before Game.Update
+func updateTasks() {
+
+	// Tasks started during the loop already ran to their first wait, so they are only updated from the next frame
+	count := len(activeTasks)
+
+	kept := 0
+	for i := 0; i < count; i++ {
+
+		t := activeTasks[i]
+		if t == nil || t.update() {
+			continue
+		}
+
+		activeTasks[kept] = t
+		kept++
+	}
+
+	kept += copy(activeTasks[kept:], activeTasks[count:])
+	clear(activeTasks[kept:])
+	activeTasks = activeTasks[:kept]
+}
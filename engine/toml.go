@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseToml parses the subset of TOML used by config files: comments, [tables], and key/value pairs
+// whose values are strings, integers, floats or booleans.
+//
+// Keys are returned as 'table.key', or just 'key' for keys before the first table
+func parseToml(data string) (map[string]any, error) {
+
+	values := map[string]any{}
+	table := ""
+
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+
+		lineNum := i + 1
+		line := strings.TrimSpace(stripTomlComment(lines[i]))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("invalid table header on line %d: %s", lineNum, line)
+			}
+
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			if table == "" {
+				return nil, fmt.Errorf("empty table name on line %d", lineNum)
+			}
+
+			continue
+		}
+
+		key, rawVal, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("expected 'key = value' on line %d: %s", lineNum, line)
+		}
+
+		key = strings.TrimSpace(key)
+		rawVal = strings.TrimSpace(rawVal)
+		if key == "" || rawVal == "" {
+			return nil, fmt.Errorf("expected 'key = value' on line %d: %s", lineNum, line)
+		}
+
+		if table != "" {
+			key = table + "." + key
+		}
+
+		if _, exists := values[key]; exists {
+			return nil, fmt.Errorf("duplicate key '%s' on line %d", key, lineNum)
+		}
+
+		val, err := parseTomlValue(rawVal)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for key '%s' on line %d. Err: %w", key, lineNum, err)
+		}
+
+		values[key] = val
+	}
+
+	return values, nil
+}
+
+func parseTomlValue(s string) (any, error) {
+
+	if s[0] == '"' {
+
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", s)
+		}
+
+		return unquoted, nil
+	}
+
+	// Literal strings have no escapes
+	if s[0] == '\'' {
+
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return nil, fmt.Errorf("invalid string %s", s)
+		}
+
+		return s[1 : len(s)-1], nil
+	}
+
+	if s == "true" {
+		return true, nil
+	}
+
+	if s == "false" {
+		return false, nil
+	}
+
+	numStr := strings.ReplaceAll(s, "_", "")
+	if i, err := strconv.ParseInt(numStr, 10, 64); err == nil {
+		return i, nil
+	}
+
+	if f, err := strconv.ParseFloat(numStr, 64); err == nil {
+		return f, nil
+	}
+
+	return nil, fmt.Errorf("unsupported value %s", s)
+}
+
+// stripTomlComment removes a '#' comment from the line, while ignoring '#' inside strings
+func stripTomlComment(line string) string {
+
+	var quote byte
+	for i := 0; i < len(line); i++ {
+
+		c := line[i]
+		switch {
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == '"' && c == '\\':
+			// Skip escaped character
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+
+	return line
+}
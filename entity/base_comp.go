@@ -5,7 +5,8 @@ import "github.com/bloeys/nmage/registry"
 var _ Comp = &BaseComp{}
 
 type BaseComp struct {
-	Handle registry.Handle
+	// Handle is not saved because entities get new handles when loaded
+	Handle registry.Handle `json:"-"`
 }
 
 func (b BaseComp) baseComp() {
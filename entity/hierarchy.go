@@ -0,0 +1,199 @@
+package entity
+
+import (
+	"slices"
+
+	"github.com/bloeys/nmage/registry"
+)
+
+type HierarchyNode struct {
+	Name     string
+	Parent   registry.Handle
+	Children []registry.Handle
+}
+
+// Hierarchy is the scene graph of entities, which gives each entity a name and a parent.
+// Entities with a zero parent handle are roots. The hierarchy only stores handles, so entities are still created and freed through their registry
+type Hierarchy struct {
+	Roots []registry.Handle
+	nodes map[registry.Handle]*HierarchyNode
+}
+
+// Add adds the entity as the last child of parent, or as a root if parent is zero or isn't in the hierarchy
+func (h *Hierarchy) Add(handle registry.Handle, name string, parent registry.Handle) {
+
+	if _, ok := h.nodes[handle]; ok {
+		return
+	}
+
+	if _, ok := h.nodes[parent]; !ok {
+		parent = 0
+	}
+
+	h.nodes[handle] = &HierarchyNode{
+		Name:   name,
+		Parent: parent,
+	}
+
+	h.addToParent(handle, parent)
+}
+
+// Node returns the node of the entity, or nil if it's not in the hierarchy
+func (h *Hierarchy) Node(handle registry.Handle) *HierarchyNode {
+	return h.nodes[handle]
+}
+
+func (h *Hierarchy) Has(handle registry.Handle) bool {
+	_, ok := h.nodes[handle]
+	return ok
+}
+
+func (h *Hierarchy) Len() int {
+	return len(h.nodes)
+}
+
+// Children returns the children of the entity, or the roots if handle is zero
+func (h *Hierarchy) Children(handle registry.Handle) []registry.Handle {
+
+	if handle.IsZero() {
+		return h.Roots
+	}
+
+	n := h.nodes[handle]
+	if n == nil {
+		return nil
+	}
+
+	return n.Children
+}
+
+// IsAncestor reports whether ancestor is a parent of handle, or a parent of its parent and so on
+func (h *Hierarchy) IsAncestor(ancestor, handle registry.Handle) bool {
+
+	n := h.nodes[handle]
+	for n != nil && !n.Parent.IsZero() {
+
+		if n.Parent == ancestor {
+			return true
+		}
+
+		n = h.nodes[n.Parent]
+	}
+
+	return false
+}
+
+// ChildIndex returns the index of the entity among the children of its parent (or the roots), or -1 if it's not in the hierarchy
+func (h *Hierarchy) ChildIndex(handle registry.Handle) int {
+
+	n := h.nodes[handle]
+	if n == nil {
+		return -1
+	}
+
+	return slices.Index(h.Children(n.Parent), handle)
+}
+
+// SetParent moves the entity and its children under parent as its last child, or to the roots if parent is zero.
+// Returns false if the move would make the entity its own ancestor
+func (h *Hierarchy) SetParent(handle, parent registry.Handle) bool {
+	return h.SetParentAt(handle, parent, -1)
+}
+
+// SetParentAt is like SetParent but puts the entity at index among the children of parent, where -1 or an index past the end puts it last
+func (h *Hierarchy) SetParentAt(handle, parent registry.Handle, index int) bool {
+
+	n := h.nodes[handle]
+	if n == nil {
+		return false
+	}
+
+	if _, ok := h.nodes[parent]; !ok {
+		parent = 0
+	}
+
+	if parent == handle || h.IsAncestor(handle, parent) {
+		return false
+	}
+
+	if n.Parent == parent && index == -1 {
+		return true
+	}
+
+	h.removeFromParent(handle, n.Parent)
+	n.Parent = parent
+	h.insertInParent(handle, parent, index)
+
+	return true
+}
+
+// Remove removes the entity and all its descendants from the hierarchy, and returns the removed handles with children before their parents,
+// which is the order they should be freed in
+func (h *Hierarchy) Remove(handle registry.Handle) []registry.Handle {
+
+	n := h.nodes[handle]
+	if n == nil {
+		return nil
+	}
+
+	h.removeFromParent(handle, n.Parent)
+
+	removed := make([]registry.Handle, 0, 1+len(n.Children))
+	return h.removeSubtree(handle, removed)
+}
+
+func (h *Hierarchy) removeSubtree(handle registry.Handle, removed []registry.Handle) []registry.Handle {
+
+	n := h.nodes[handle]
+	for i := 0; i < len(n.Children); i++ {
+		removed = h.removeSubtree(n.Children[i], removed)
+	}
+
+	delete(h.nodes, handle)
+	return append(removed, handle)
+}
+
+func (h *Hierarchy) addToParent(handle, parent registry.Handle) {
+
+	if parent.IsZero() {
+		h.Roots = append(h.Roots, handle)
+		return
+	}
+
+	p := h.nodes[parent]
+	p.Children = append(p.Children, handle)
+}
+
+func (h *Hierarchy) insertInParent(handle, parent registry.Handle, index int) {
+
+	children := h.Children(parent)
+	if index < 0 || index > len(children) {
+		index = len(children)
+	}
+
+	children = slices.Insert(children, index, handle)
+	if parent.IsZero() {
+		h.Roots = children
+		return
+	}
+
+	h.nodes[parent].Children = children
+}
+
+func (h *Hierarchy) removeFromParent(handle, parent registry.Handle) {
+
+	if parent.IsZero() {
+		h.Roots = slices.DeleteFunc(h.Roots, func(c registry.Handle) bool { return c == handle })
+		return
+	}
+
+	p := h.nodes[parent]
+	p.Children = slices.DeleteFunc(p.Children, func(c registry.Handle) bool { return c == handle })
+}
+
+func NewHierarchy() Hierarchy {
+	return Hierarchy{
+		Roots: []registry.Handle{},
+		nodes: map[registry.Handle]*HierarchyNode{},
+	}
+}
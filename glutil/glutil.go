@@ -0,0 +1,18 @@
+// The glutil package has small OpenGL queries shared by packages that can't import each other (e.g. buffers and shaders)
+package glutil
+
+import "github.com/go-gl/gl/v4.1-core/gl"
+
+// HasGlExtension returns true if the current context reports the extension
+func HasGlExtension(name string) bool {
+
+	var extCount int32
+	gl.GetIntegerv(gl.NUM_EXTENSIONS, &extCount)
+	for i := int32(0); i < extCount; i++ {
+		if gl.GoStr(gl.GetStringi(gl.EXTENSIONS, uint32(i))) == name {
+			return true
+		}
+	}
+
+	return false
+}
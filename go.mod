@@ -14,6 +14,7 @@ require (
 require (
 	github.com/AllenDang/cimgui-go v0.0.0-20240912193335-545751598105
 	github.com/mandykoh/prism v0.35.1
+	github.com/yuin/gopher-lua v1.1.1
 )
 
 require (
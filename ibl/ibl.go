@@ -0,0 +1,504 @@
+// The ibl package bakes the maps used for image based lighting, which lights PBR materials (see res/shaders/pbr.glsl)
+// with the environment around them, so metals reflect the sky and rough surfaces pick up its colors
+package ibl
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/bloeys/nmage/buffers"
+	"github.com/bloeys/nmage/leakcheck"
+	"github.com/bloeys/nmage/materials"
+	"github.com/bloeys/nmage/renderer"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+const iblShader = `
+//shader:vertex
+#version 410
+
+out vec2 vertUV0;
+
+vec2 quadData[6] = vec2[](
+    vec2(0.0, 1.0),
+    vec2(0.0, 0.0),
+    vec2(1.0, 0.0),
+    vec2(0.0, 1.0),
+    vec2(1.0, 0.0),
+    vec2(1.0, 1.0)
+);
+
+void main()
+{
+    vertUV0 = quadData[gl_VertexID];
+    gl_Position = vec4(vertUV0 * 2.0 - 1.0, 0.0, 1.0);
+}
+
+//shader:fragment
+#version 410
+
+#define MODE_ENVIRONMENT 0
+#define MODE_IRRADIANCE 1
+#define MODE_PREFILTER 2
+#define MODE_BRDF_LUT 3
+
+#define PI 3.14159265359
+
+uniform int mode;
+
+// face is the cubemap face being drawn in the OpenGL face order (+x, -x, +y, -y, +z, -z)
+uniform int face;
+
+uniform samplerCube srcCubemap;
+
+// srcSize is the face size of the source cubemap, and srcLod the mip the irradiance reads from
+uniform float srcSize;
+uniform float srcLod;
+
+uniform float roughness;
+uniform int sampleCount;
+
+// See renderer.SkyboxSettings. The yaw is in radians
+uniform float skyboxIntensity = 1;
+uniform float skyboxYaw = 0;
+uniform vec3 skyboxTint = vec3(1);
+
+in vec2 vertUV0;
+
+out vec4 fragColor;
+
+// CubemapDir returns the direction of the texel at uv on the face being drawn
+vec3 CubemapDir(vec2 uv)
+{
+    vec2 st = uv * 2.0 - 1.0;
+
+    vec3 dir;
+    if (face == 0)
+        dir = vec3(1, -st.y, -st.x);
+    else if (face == 1)
+        dir = vec3(-1, -st.y, st.x);
+    else if (face == 2)
+        dir = vec3(st.x, 1, st.y);
+    else if (face == 3)
+        dir = vec3(st.x, -1, -st.y);
+    else if (face == 4)
+        dir = vec3(st.x, -st.y, 1);
+    else
+        dir = vec3(-st.x, -st.y, -1);
+
+    return normalize(dir);
+}
+
+// SkyDir returns the direction the skybox shader samples for the world direction dir, so the environment matches the visible sky
+vec3 SkyDir(vec3 dir)
+{
+    dir = vec3(dir.x, dir.y, -dir.z);
+
+    float c = cos(skyboxYaw);
+    float s = sin(skyboxYaw);
+    return vec3(c * dir.x - s * dir.z, dir.y, s * dir.x + c * dir.z);
+}
+
+// Hammersley returns the i-th point of a low discrepancy sequence of n points in [0, 1]^2
+vec2 Hammersley(uint i, uint n)
+{
+    return vec2(float(i) / float(n), float(bitfieldReverse(i)) * 2.3283064365386963e-10);
+}
+
+// ImportanceSampleGGX returns a half vector around N, picked with the GGX distribution of roughness
+vec3 ImportanceSampleGGX(vec2 xi, vec3 N, float roughness)
+{
+    float a = roughness * roughness;
+
+    float phi = 2.0 * PI * xi.x;
+    float cosTheta = sqrt((1.0 - xi.y) / (1.0 + (a * a - 1.0) * xi.y));
+    float sinTheta = sqrt(1.0 - cosTheta * cosTheta);
+    vec3 H = vec3(cos(phi) * sinTheta, sin(phi) * sinTheta, cosTheta);
+
+    vec3 up = abs(N.z) < 0.999 ? vec3(0, 0, 1) : vec3(1, 0, 0);
+    vec3 tangent = normalize(cross(up, N));
+    vec3 bitangent = cross(N, tangent);
+    return normalize(tangent * H.x + bitangent * H.y + N * H.z);
+}
+
+float DistributionGGX(float NdotH, float roughness)
+{
+    float a = roughness * roughness;
+    float a2 = a * a;
+    float d = NdotH * NdotH * (a2 - 1.0) + 1.0;
+    return a2 / (PI * d * d);
+}
+
+// GeometrySmithIbl uses the k of image based lighting, which is different from the one of punctual lights
+float GeometrySmithIbl(float NdotV, float NdotL, float roughness)
+{
+    float k = roughness * roughness / 2.0;
+    float gView = NdotV / (NdotV * (1.0 - k) + k);
+    float gLight = NdotL / (NdotL * (1.0 - k) + k);
+    return gView * gLight;
+}
+
+// Irradiance convolves the environment with a cosine lobe around N, and is divided by pi like the ambient light of the lit shaders
+vec3 Irradiance(vec3 N)
+{
+    vec3 up = abs(N.y) < 0.999 ? vec3(0, 1, 0) : vec3(0, 0, 1);
+    vec3 right = normalize(cross(up, N));
+    up = cross(N, right);
+
+    const float sampleDelta = 0.05;
+
+    vec3 irradiance = vec3(0);
+    float count = 0;
+    for (float phi = 0.0; phi < 2.0 * PI; phi += sampleDelta)
+    {
+        for (float theta = 0.0; theta < 0.5 * PI; theta += sampleDelta)
+        {
+            vec3 tangentDir = vec3(sin(theta) * cos(phi), sin(theta) * sin(phi), cos(theta));
+            vec3 dir = tangentDir.x * right + tangentDir.y * up + tangentDir.z * N;
+
+            irradiance += textureLod(srcCubemap, dir, srcLod).rgb * cos(theta) * sin(theta);
+            count++;
+        }
+    }
+
+    return PI * irradiance / count;
+}
+
+// Prefilter convolves the environment with the GGX lobe of roughness, assuming the view direction is the normal.
+// Samples read from blurrier mips where they are sparse, which removes the bright dots of undersampling
+vec3 Prefilter(vec3 N)
+{
+    vec3 V = N;
+
+    vec3 color = vec3(0);
+    float weightSum = 0;
+    for (uint i = 0u; i < uint(sampleCount); i++)
+    {
+        vec3 H = ImportanceSampleGGX(Hammersley(i, uint(sampleCount)), N, roughness);
+        vec3 L = normalize(2.0 * dot(V, H) * H - V);
+
+        float NdotL = dot(N, L);
+        if (NdotL <= 0.0)
+            continue;
+
+        float NdotH = max(dot(N, H), 0.0);
+        float HdotV = max(dot(H, V), 0.0);
+        float pdf = DistributionGGX(NdotH, roughness) * NdotH / (4.0 * HdotV) + 0.0001;
+
+        float texelSolidAngle = 4.0 * PI / (6.0 * srcSize * srcSize);
+        float sampleSolidAngle = 1.0 / (float(sampleCount) * pdf + 0.0001);
+        float lod = roughness == 0.0 ? 0.0 : 0.5 * log2(sampleSolidAngle / texelSolidAngle);
+
+        color += textureLod(srcCubemap, L, lod).rgb * NdotL;
+        weightSum += NdotL;
+    }
+
+    return color / max(weightSum, 0.0001);
+}
+
+// IntegrateBrdf returns the scale and bias applied to the fresnel reflectance at zero degrees by the specular
+// part of the environment, for a view angle and roughness
+vec2 IntegrateBrdf(float NdotV, float roughness)
+{
+    vec3 V = vec3(sqrt(1.0 - NdotV * NdotV), 0.0, NdotV);
+    vec3 N = vec3(0, 0, 1);
+
+    float scale = 0;
+    float bias = 0;
+    for (uint i = 0u; i < uint(sampleCount); i++)
+    {
+        vec3 H = ImportanceSampleGGX(Hammersley(i, uint(sampleCount)), N, roughness);
+        vec3 L = normalize(2.0 * dot(V, H) * H - V);
+
+        float NdotL = max(L.z, 0.0);
+        if (NdotL <= 0.0)
+            continue;
+
+        float NdotH = max(H.z, 0.0);
+        float VdotH = max(dot(V, H), 0.0);
+
+        float g = GeometrySmithIbl(NdotV, NdotL, roughness);
+        float gVis = g * VdotH / (NdotH * NdotV);
+        float fc = pow(1.0 - VdotH, 5.0);
+
+        scale += (1.0 - fc) * gVis;
+        bias += fc * gVis;
+    }
+
+    return vec2(scale, bias) / float(sampleCount);
+}
+
+void main()
+{
+    if (mode == MODE_BRDF_LUT)
+    {
+        // The lowest view angle and roughness are avoided since they divide by zero
+        fragColor = vec4(IntegrateBrdf(max(vertUV0.x, 0.001), max(vertUV0.y, 0.001)), 0, 1);
+        return;
+    }
+
+    vec3 dir = CubemapDir(vertUV0);
+    if (mode == MODE_ENVIRONMENT)
+        fragColor = vec4(texture(srcCubemap, SkyDir(dir)).rgb * skyboxTint * skyboxIntensity, 1);
+    else if (mode == MODE_IRRADIANCE)
+        fragColor = vec4(Irradiance(dir), 1);
+    else
+        fragColor = vec4(Prefilter(dir), 1);
+}
+`
+
+const (
+	iblMode_Environment int32 = iota
+	iblMode_Irradiance
+	iblMode_Prefilter
+	iblMode_BrdfLut
+)
+
+const (
+	// PrefilterMipCount is the number of mips of the prefiltered environment map, going from smooth at mip zero to fully rough at the last.
+	// Must match PREFILTER_MAX_LOD+1 in res/shaders/pbr.glsl
+	PrefilterMipCount = 5
+)
+
+// Settings are the sizes and quality of the baked maps
+type Settings struct {
+	// EnvironmentSize is the face size of the copy of the sky the other maps are made from. Bigger sizes keep more detail in smooth reflections
+	EnvironmentSize int32
+
+	// IrradianceSize is the face size of the irradiance map, which only holds low frequency light so it can be tiny
+	IrradianceSize int32
+
+	// PrefilterSize is the face size of the first mip of the prefiltered environment map
+	PrefilterSize int32
+
+	// BrdfLutSize is the width and height of the BRDF lookup table
+	BrdfLutSize int32
+
+	// SampleCount is the number of GGX samples per texel of the prefiltered map and the BRDF lookup table
+	SampleCount int32
+}
+
+func DefaultSettings() Settings {
+	return Settings{
+		EnvironmentSize: 256,
+		IrradianceSize:  32,
+		PrefilterSize:   128,
+		BrdfLutSize:     256,
+		SampleCount:     512,
+	}
+}
+
+// Maps are the textures PBR materials are lit with. IrradianceTex is the diffuse light arriving from each direction,
+// PrefilterTex is the environment blurred by roughness for each mip, and BrdfLutTex scales and biases the fresnel of the prefiltered light.
+//
+// All maps are world aligned, so they are sampled with world space directions
+type Maps struct {
+	EnvironmentTex uint32
+	IrradianceTex  uint32
+	PrefilterTex   uint32
+	BrdfLutTex     uint32
+}
+
+// Apply makes the material use the maps. The material's shader must have the samplers of res/shaders/pbr.glsl
+func (m *Maps) Apply(mat *materials.Material) {
+
+	mat.IrradianceTex = m.IrradianceTex
+	mat.PrefilterTex = m.PrefilterTex
+	mat.BrdfLutTex = m.BrdfLutTex
+
+	mat.SetUnifInt32("irradianceMap", int32(materials.TextureSlot_IrradianceMap))
+	mat.SetUnifInt32("prefilterMap", int32(materials.TextureSlot_PrefilterMap))
+	mat.SetUnifInt32("brdfLut", int32(materials.TextureSlot_BrdfLut))
+}
+
+func (m *Maps) Delete() {
+
+	for _, tex := range []*uint32{&m.EnvironmentTex, &m.IrradianceTex, &m.PrefilterTex, &m.BrdfLutTex} {
+
+		if *tex == 0 {
+			continue
+		}
+
+		leakcheck.Untrack(leakcheck.ResourceType_Texture, *tex)
+		gl.DeleteTextures(1, tex)
+		*tex = 0
+	}
+}
+
+// Generate bakes the maps of a skybox cubemap, where skyboxSettings (which can be nil) are the settings the sky is drawn with so the
+// lighting matches it. Only the sky is captured, so objects don't reflect the scene.
+//
+// Baking takes a few milliseconds on the GPU, so it should be done again when the sky changes rather than every frame.
+// Blending is changed while baking and restored to the engine default after
+func Generate(rend renderer.Render, skyboxTex uint32, skyboxSettings *renderer.SkyboxSettings, settings *Settings) (Maps, error) {
+
+	if skyboxTex == 0 {
+		return Maps{}, fmt.Errorf("failed to generate image based lighting maps because the skybox texture is zero")
+	}
+
+	if settings.EnvironmentSize <= 0 || settings.IrradianceSize <= 0 || settings.PrefilterSize < 1<<(PrefilterMipCount-1) || settings.BrdfLutSize <= 0 || settings.SampleCount <= 0 {
+		return Maps{}, fmt.Errorf("failed to generate image based lighting maps because of invalid settings: %+v", *settings)
+	}
+
+	mat, err := materials.NewMaterialSrc("IBL Mat", []byte(iblShader))
+	if err != nil {
+		return Maps{}, fmt.Errorf("failed to generate image based lighting maps. Err: %w", err)
+	}
+	defer mat.Delete()
+
+	vao := buffers.NewVertexArray()
+	defer vao.Delete()
+
+	mat.SetUnifInt32("srcCubemap", int32(materials.TextureSlot_Cubemap))
+	mat.SetUnifInt32("sampleCount", settings.SampleCount)
+	if skyboxSettings != nil {
+		skyboxSettings.Apply(&mat)
+	}
+
+	var fboId uint32
+	gl.GenFramebuffers(1, &fboId)
+	leakcheck.Track(leakcheck.ResourceType_Framebuffer, fboId)
+	defer func() {
+		leakcheck.Untrack(leakcheck.ResourceType_Framebuffer, fboId)
+		gl.DeleteFramebuffers(1, &fboId)
+	}()
+
+	wasBlendEnabled := gl.IsEnabled(gl.BLEND)
+	gl.Disable(gl.BLEND)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, fboId)
+
+	b := baker{rend: rend, mat: &mat, vao: &vao}
+	maps := Maps{
+		EnvironmentTex: newCubemapTex(settings.EnvironmentSize, mipCountForSize(settings.EnvironmentSize)),
+		IrradianceTex:  newCubemapTex(settings.IrradianceSize, 1),
+		PrefilterTex:   newCubemapTex(settings.PrefilterSize, PrefilterMipCount),
+		BrdfLutTex:     newBrdfLutTex(settings.BrdfLutSize),
+	}
+
+	// Environment, whose mips are used by the other maps to read wide areas in one sample
+	mat.SetUnifInt32("mode", iblMode_Environment)
+	b.drawCubemap(skyboxTex, maps.EnvironmentTex, settings.EnvironmentSize, 0)
+
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, maps.EnvironmentTex)
+	gl.GenerateMipmap(gl.TEXTURE_CUBE_MAP)
+
+	// Irradiance, read from the environment mip closest to its size since it blurs everything anyway
+	envSize := float32(settings.EnvironmentSize)
+	mat.SetUnifInt32("mode", iblMode_Irradiance)
+	mat.SetUnifFloat32("srcLod", max(float32(math.Log2(float64(envSize/float32(settings.IrradianceSize)))), 0))
+	b.drawCubemap(maps.EnvironmentTex, maps.IrradianceTex, settings.IrradianceSize, 0)
+
+	// Prefiltered environment, one roughness per mip
+	mat.SetUnifInt32("mode", iblMode_Prefilter)
+	mat.SetUnifFloat32("srcSize", envSize)
+	for mip := int32(0); mip < PrefilterMipCount; mip++ {
+		mat.SetUnifFloat32("roughness", float32(mip)/float32(PrefilterMipCount-1))
+		b.drawCubemap(maps.EnvironmentTex, maps.PrefilterTex, settings.PrefilterSize>>mip, mip)
+	}
+
+	// BRDF lookup table
+	mat.SetUnifInt32("mode", iblMode_BrdfLut)
+	gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, maps.BrdfLutTex, 0)
+	err = b.draw(settings.BrdfLutSize)
+
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	if wasBlendEnabled {
+		gl.Enable(gl.BLEND)
+	}
+
+	if err == nil {
+		err = b.err
+	}
+
+	if err != nil {
+		maps.Delete()
+		return Maps{}, fmt.Errorf("failed to generate image based lighting maps. Err: %w", err)
+	}
+
+	return maps, nil
+}
+
+// baker draws the passes of Generate into the bound framebuffer, keeping the first error
+type baker struct {
+	rend renderer.Render
+	mat  *materials.Material
+	vao  *buffers.VertexArray
+	err  error
+}
+
+// drawCubemap draws all faces of mip of dstTex, reading from srcTex
+func (b *baker) drawCubemap(srcTex, dstTex uint32, size, mip int32) {
+
+	// The renderer only binds the material when it changes, so the source is bound here for every pass
+	gl.ActiveTexture(uint32(gl.TEXTURE0 + materials.TextureSlot_Cubemap))
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, srcTex)
+
+	for face := uint32(0); face < 6; face++ {
+
+		b.mat.SetUnifInt32("face", int32(face))
+		gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_CUBE_MAP_POSITIVE_X+face, dstTex, mip)
+		if err := b.draw(size); err != nil && b.err == nil {
+			b.err = err
+		}
+	}
+}
+
+func (b *baker) draw(size int32) error {
+
+	if status := gl.CheckFramebufferStatus(gl.FRAMEBUFFER); status != gl.FRAMEBUFFER_COMPLETE {
+		return fmt.Errorf("framebuffer is not complete. Status: %d", status)
+	}
+
+	b.rend.PushViewport(0, 0, size, size)
+	b.rend.DrawVertexArray(b.mat, b.vao, 0, 6)
+	b.rend.PopViewport()
+	return nil
+}
+
+func mipCountForSize(size int32) int32 {
+	return int32(math.Floor(math.Log2(float64(size)))) + 1
+}
+
+func newCubemapTex(size, mipCount int32) uint32 {
+
+	var tex uint32
+	gl.GenTextures(1, &tex)
+	leakcheck.Track(leakcheck.ResourceType_Texture, tex)
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, tex)
+
+	for mip := int32(0); mip < mipCount; mip++ {
+		for face := uint32(0); face < 6; face++ {
+			gl.TexImage2D(gl.TEXTURE_CUBE_MAP_POSITIVE_X+face, mip, gl.RGBA16F, size>>mip, size>>mip, 0, gl.RGBA, gl.FLOAT, nil)
+		}
+	}
+
+	minFilter := int32(gl.LINEAR)
+	if mipCount > 1 {
+		minFilter = gl.LINEAR_MIPMAP_LINEAR
+	}
+
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_MIN_FILTER, minFilter)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_MAX_LEVEL, mipCount-1)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_WRAP_R, gl.CLAMP_TO_EDGE)
+
+	return tex
+}
+
+func newBrdfLutTex(size int32) uint32 {
+
+	var tex uint32
+	gl.GenTextures(1, &tex)
+	leakcheck.Track(leakcheck.ResourceType_Texture, tex)
+	gl.BindTexture(gl.TEXTURE_2D, tex)
+
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RG16F, size, size, 0, gl.RG, gl.FLOAT, nil)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+
+	return tex
+}
@@ -0,0 +1,244 @@
+// The impostors package bakes meshes into atlases of pictures taken from around them, which are drawn as camera facing
+// billboards when the mesh is far away. Far objects in dense scenes like forests then cost two triangles each
+package impostors
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/buffers"
+	"github.com/bloeys/nmage/materials"
+	"github.com/bloeys/nmage/meshes"
+	"github.com/bloeys/nmage/renderer"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+type BakeSettings struct {
+	// AngleCount is how many pictures are taken around the mesh's Y axis. More angles make turning around the
+	// impostor smoother but use a bigger atlas
+	AngleCount int32
+
+	// CellSize is the width and height of each cell in pixels
+	CellSize int32
+
+	// CellPadding is the transparent border in pixels between each picture and the edges of its cell. Mip levels are
+	// limited to the ones where the border is still at least a pixel, since lower levels would blend neighboring pictures
+	// together. Zero means no padding, and so no mip maps
+	CellPadding int32
+
+	// Center and Radius are a sphere in model space containing the whole mesh
+	Center gglm.Vec3
+	Radius float32
+}
+
+// Impostor is a baked mesh. Its atlas has one cell per angle, row by row from the top left
+type Impostor struct {
+	Atlas buffers.Framebuffer
+
+	AngleCount  int32
+	Columns     int32
+	Rows        int32
+	CellSize    int32
+	CellPadding int32
+
+	// Center and Radius are the bounding sphere the impostor was baked with, in model space
+	Center gglm.Vec3
+	Radius float32
+
+	// SwitchDistance is how far from the camera the mesh is drawn as its impostor
+	SwitchDistance float32
+}
+
+// AtlasTexID returns the texture with the baked pictures, to be set as the DiffuseTex of the impostor's billboard material
+func (imp *Impostor) AtlasTexID() uint32 {
+	return imp.Atlas.ColorTex(0)
+}
+
+// CellUVs returns the uv of the top left and bottom right corners of the picture in an angle's cell, which leaves out the padding
+func (imp *Impostor) CellUVs(angleIndex int32) (uvMin, uvMax gglm.Vec2) {
+
+	col := float32(angleIndex % imp.Columns)
+	row := float32(angleIndex / imp.Columns)
+	cellSize := float32(imp.CellSize)
+	padding := float32(imp.CellPadding)
+	width := float32(imp.Columns) * cellSize
+	height := float32(imp.Rows) * cellSize
+
+	// Row zero is the top of the atlas, which is the end of the texture since textures are stored bottom up
+	uvMin = gglm.NewVec2((col*cellSize+padding)/width, (height-row*cellSize-padding)/height)
+	uvMax = gglm.NewVec2(((col+1)*cellSize-padding)/width, (height-(row+1)*cellSize+padding)/height)
+	return uvMin, uvMax
+}
+
+// AngleIndex returns the angle whose picture is closest to how the camera sees a mesh with the model matrix
+func (imp *Impostor) AngleIndex(modelMat *gglm.TrMat, camPos *gglm.Vec3) int32 {
+
+	m := &modelMat.Data
+	toCamX := camPos.X() - m[3][0]
+	toCamZ := camPos.Z() - m[3][2]
+
+	// Angles are around Y, so the camera's angle is made relative to the model's rotation around Y
+	camYaw := math.Atan2(float64(toCamX), float64(toCamZ))
+	modelYaw := math.Atan2(float64(m[2][0]), float64(m[2][2]))
+
+	step := 2 * math.Pi / float64(imp.AngleCount)
+	index := int32(math.Round((camYaw - modelYaw) / step))
+	return ((index % imp.AngleCount) + imp.AngleCount) % imp.AngleCount
+}
+
+// Billboard returns the billboard showing the mesh from the camera's angle
+func (imp *Impostor) Billboard(modelMat *gglm.TrMat, camPos *gglm.Vec3) renderer.Billboard {
+
+	m := &modelMat.Data
+	localCenter := gglm.NewVec4(imp.Center.X(), imp.Center.Y(), imp.Center.Z(), 1)
+	center := gglm.MulMat4Vec4(&modelMat.Mat4, &localCenter)
+
+	// The billboard only turns around Y, so its size follows the model's horizontal and vertical scale
+	xAxis := gglm.NewVec3(m[0][0], m[0][1], m[0][2])
+	yAxis := gglm.NewVec3(m[1][0], m[1][1], m[1][2])
+	scaleXZ := xAxis.Mag()
+	scaleY := yAxis.Mag()
+
+	b := renderer.NewBillboard(
+		renderer.BillboardMode_Cylindrical,
+		gglm.NewVec3(center.X(), center.Y(), center.Z()),
+		gglm.NewVec2(2*imp.Radius*scaleXZ, 2*imp.Radius*scaleY),
+	)
+	b.UVMin, b.UVMax = imp.CellUVs(imp.AngleIndex(modelMat, camPos))
+
+	return b
+}
+
+// Draw draws the mesh with mat when it is closer to camPos than SwitchDistance, and the impostor with impMat otherwise.
+// impMat is a billboard material (see res/shaders/billboard.glsl) whose DiffuseTex is the atlas, and is only used by this impostor
+func (imp *Impostor) Draw(rend renderer.Render, mesh *meshes.Mesh, modelMat *gglm.TrMat, mat *materials.Material, impMat *materials.Material, camPos *gglm.Vec3) {
+
+	m := &modelMat.Data
+	pos := gglm.NewVec3(m[3][0], m[3][1], m[3][2])
+	if gglm.DistVec3(&pos, camPos) < imp.SwitchDistance {
+		rend.DrawMesh(mesh, modelMat, mat)
+		return
+	}
+
+	b := imp.Billboard(modelMat, camPos)
+	rend.DrawBillboard(&b, impMat)
+}
+
+func (imp *Impostor) Delete() {
+	imp.Atlas.Delete()
+}
+
+// Bake draws the mesh with mat from settings.AngleCount angles around it into a new atlas.
+//
+// mat must take its matrices like res/shaders/simple-unlit.glsl, with MaterialSettings_HasModelMtx and a projViewMat uniform,
+// which Bake overwrites. Unlit materials bake best, since the impostor doesn't change with the scene's lighting
+func Bake(rend renderer.Render, mesh *meshes.Mesh, mat *materials.Material, settings *BakeSettings) (Impostor, error) {
+
+	if settings.AngleCount <= 0 || settings.CellSize <= 0 || settings.Radius <= 0 {
+		return Impostor{}, errors.New("failed to bake impostor because AngleCount, CellSize and Radius must be bigger than zero")
+	}
+
+	if settings.CellPadding < 0 || 2*settings.CellPadding >= settings.CellSize {
+		return Impostor{}, fmt.Errorf("failed to bake impostor because CellPadding must be at least zero and less than half of CellSize. CellPadding=%d, CellSize=%d", settings.CellPadding, settings.CellSize)
+	}
+
+	cols := int32(math.Ceil(math.Sqrt(float64(settings.AngleCount))))
+	rows := (settings.AngleCount + cols - 1) / cols
+
+	atlas, err := buffers.NewFramebuffer(uint32(cols*settings.CellSize), uint32(rows*settings.CellSize))
+	if err != nil {
+		return Impostor{}, fmt.Errorf("failed to bake impostor. Err: %w", err)
+	}
+
+	imp := Impostor{
+		Atlas:          atlas,
+		AngleCount:     settings.AngleCount,
+		Columns:        cols,
+		Rows:           rows,
+		CellSize:       settings.CellSize,
+		CellPadding:    settings.CellPadding,
+		Center:         settings.Center,
+		Radius:         settings.Radius,
+		SwitchDistance: 50,
+	}
+
+	err = imp.Atlas.NewColorAttachment(buffers.FramebufferAttachmentType_Texture, buffers.FramebufferAttachmentDataFormat_SRGBA)
+	if err == nil {
+		err = imp.Atlas.NewDepthStencilAttachment(buffers.FramebufferAttachmentType_Renderbuffer, buffers.FramebufferAttachmentDataFormat_Depth24Stencil8)
+	}
+
+	if err != nil {
+		imp.Atlas.Delete()
+		return Impostor{}, fmt.Errorf("failed to bake impostor. Err: %w", err)
+	}
+
+	if !imp.Atlas.IsComplete() {
+		imp.Atlas.Delete()
+		return Impostor{}, fmt.Errorf("failed to bake impostor because its atlas framebuffer is incomplete. Width=%d, Height=%d", cols*settings.CellSize, rows*settings.CellSize)
+	}
+
+	// Empty parts of the cells must be transparent, so the clear color and blending are changed while baking
+	var clearColor [4]float32
+	gl.GetFloatv(gl.COLOR_CLEAR_VALUE, &clearColor[0])
+	wasBlendEnabled := gl.IsEnabled(gl.BLEND)
+
+	gl.ClearColor(0, 0, 0, 0)
+	gl.Disable(gl.BLEND)
+
+	imp.Atlas.Bind()
+	imp.Atlas.Clear()
+
+	r := settings.Radius
+	projMat := gglm.Ortho(-r, r, r, -r, 0.01, 4*r)
+	modelMat := gglm.NewTrMatId()
+	up := gglm.NewVec3(0, 1, 0)
+
+	for i := int32(0); i < settings.AngleCount; i++ {
+
+		yaw := 2 * math.Pi * float64(i) / float64(settings.AngleCount)
+		camPos := gglm.NewVec3(
+			settings.Center.X()+float32(math.Sin(yaw))*2*r,
+			settings.Center.Y(),
+			settings.Center.Z()+float32(math.Cos(yaw))*2*r,
+		)
+
+		viewMat := gglm.LookAtRH(&camPos, &settings.Center, &up)
+		projViewMat := *projMat.Clone().Mul(&viewMat)
+		mat.SetUnifMat4("projViewMat", &projViewMat.Mat4)
+
+		// Viewport y is from the bottom, while rows are from the top. The picture is drawn inside the padding of its cell
+		col := i % cols
+		row := i / cols
+		padding := settings.CellPadding
+		pictureSize := settings.CellSize - 2*padding
+		rend.PushViewport(col*settings.CellSize+padding, (rows-row-1)*settings.CellSize+padding, pictureSize, pictureSize)
+		rend.DrawMesh(mesh, &modelMat, mat)
+		rend.PopViewport()
+	}
+
+	imp.Atlas.UnBind()
+
+	gl.ClearColor(clearColor[0], clearColor[1], clearColor[2], clearColor[3])
+	if wasBlendEnabled {
+		gl.Enable(gl.BLEND)
+	}
+
+	// Far impostors are small on screen, so mip maps keep them from flickering. A texel of mip level L covers 2^L pixels,
+	// so levels past log2(CellPadding) would mix neighboring pictures
+	maxMipLevel := int32(0)
+	if settings.CellPadding > 0 {
+		maxMipLevel = int32(math.Log2(float64(settings.CellPadding)))
+	}
+
+	gl.BindTexture(gl.TEXTURE_2D, imp.AtlasTexID())
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAX_LEVEL, maxMipLevel)
+	gl.GenerateMipmap(gl.TEXTURE_2D)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR_MIPMAP_LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+
+	return imp, nil
+}
@@ -104,6 +104,12 @@ func IsMouseCaptured() bool {
 	return isMouseCaptured
 }
 
+// CaptureMouse marks the mouse as captured until the next frame, which is how UIs other than imgui (like gameui)
+// stop clicks on them from reaching the game
+func CaptureMouse() {
+	isMouseCaptured = true
+}
+
 func IsKeyboardCaptured() bool {
 	return isKeyboardCaptured
 }
@@ -0,0 +1,218 @@
+package leakcheck
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/bloeys/nmage/consts"
+	"github.com/bloeys/nmage/logging"
+)
+
+type ResourceType uint8
+
+const (
+	ResourceType_Unknown ResourceType = iota
+	ResourceType_Texture
+	ResourceType_Buffer
+	ResourceType_VertexArray
+	ResourceType_Framebuffer
+	ResourceType_Renderbuffer
+	ResourceType_Program
+	ResourceType_Shader
+	ResourceType_Query
+	ResourceType_TransformFeedback
+)
+
+func (rt ResourceType) String() string {
+	switch rt {
+	case ResourceType_Texture:
+		return "Texture"
+	case ResourceType_Buffer:
+		return "Buffer"
+	case ResourceType_VertexArray:
+		return "VertexArray"
+	case ResourceType_Framebuffer:
+		return "Framebuffer"
+	case ResourceType_Renderbuffer:
+		return "Renderbuffer"
+	case ResourceType_Program:
+		return "Program"
+	case ResourceType_Shader:
+		return "Shader"
+	case ResourceType_Query:
+		return "Query"
+	case ResourceType_TransformFeedback:
+		return "TransformFeedback"
+	default:
+		return "Unknown"
+	}
+}
+
+// maxStackDepth is how many callers are kept per object, which is enough to get past the engine into game code
+const maxStackDepth = 16
+
+type resourceKey struct {
+	Type ResourceType
+	Id   uint32
+}
+
+type liveResource struct {
+	stack    [maxStackDepth]uintptr
+	stackLen int
+}
+
+var (
+	isEnabled = consts.Debug
+
+	mutex sync.Mutex
+	live  = map[resourceKey]liveResource{}
+)
+
+// SetEnabled turns tracking on or off. Enabled by default in debug builds.
+// Objects created while disabled aren't tracked, so this should be set before any objects are created
+func SetEnabled(enabled bool) {
+	isEnabled = enabled
+}
+
+func IsEnabled() bool {
+	return isEnabled
+}
+
+// Track records a newly created GL object along with the stack that created it, and the object is considered leaked until Untrack is called.
+// Stacks are only kept in debug builds (see consts.Debug). In release builds tracking is disabled and costs a single branch
+func Track(resType ResourceType, id uint32) {
+
+	if !isEnabled || id == 0 {
+		return
+	}
+
+	res := liveResource{}
+
+	// Skip runtime.Callers and Track
+	res.stackLen = runtime.Callers(2, res.stack[:])
+
+	mutex.Lock()
+	live[resourceKey{Type: resType, Id: id}] = res
+	mutex.Unlock()
+}
+
+// Untrack records that a GL object was deleted
+func Untrack(resType ResourceType, id uint32) {
+
+	if !isEnabled || id == 0 {
+		return
+	}
+
+	mutex.Lock()
+	delete(live, resourceKey{Type: resType, Id: id})
+	mutex.Unlock()
+}
+
+// Clear forgets all tracked objects without reporting them, which is needed when the GL context that owned them was lost
+func Clear() {
+	mutex.Lock()
+	clear(live)
+	mutex.Unlock()
+}
+
+// LiveCount returns how many tracked objects of the type are alive. ResourceType_Unknown counts all types
+func LiveCount(resType ResourceType) int {
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if resType == ResourceType_Unknown {
+		return len(live)
+	}
+
+	count := 0
+	for k := range live {
+		if k.Type == resType {
+			count++
+		}
+	}
+
+	return count
+}
+
+type leakGroup struct {
+	resType ResourceType
+	stack   string
+	ids     []uint32
+}
+
+// Report logs every live object as a leak, grouping objects created by the same stack, and returns the number of leaked objects.
+// Should be called after everything was supposed to be deleted, like at the end of the game
+func Report() int {
+
+	if !isEnabled {
+		return 0
+	}
+
+	mutex.Lock()
+
+	groups := map[string]*leakGroup{}
+	for k, res := range live {
+
+		stack := formatStack(res.stack[:res.stackLen])
+		groupKey := k.Type.String() + "\n" + stack
+
+		g, ok := groups[groupKey]
+		if !ok {
+			g = &leakGroup{resType: k.Type, stack: stack}
+			groups[groupKey] = g
+		}
+
+		g.ids = append(g.ids, k.Id)
+	}
+
+	leakCount := len(live)
+	mutex.Unlock()
+
+	if leakCount == 0 {
+		return 0
+	}
+
+	// Largest groups first since they are usually the interesting ones
+	sortedGroups := make([]*leakGroup, 0, len(groups))
+	for _, g := range groups {
+		slices.Sort(g.ids)
+		sortedGroups = append(sortedGroups, g)
+	}
+
+	slices.SortFunc(sortedGroups, func(a, b *leakGroup) int {
+		if len(a.ids) != len(b.ids) {
+			return len(b.ids) - len(a.ids)
+		}
+		return strings.Compare(a.stack, b.stack)
+	})
+
+	sb := strings.Builder{}
+	fmt.Fprintf(&sb, "%d GPU objects were created but never deleted:\n", leakCount)
+	for _, g := range sortedGroups {
+		fmt.Fprintf(&sb, "\n%d %s object(s) with ids %v created at:\n%s", len(g.ids), g.resType.String(), g.ids, g.stack)
+	}
+
+	logging.WarnLog.Println(sb.String())
+	return leakCount
+}
+
+func formatStack(pcs []uintptr) string {
+
+	sb := strings.Builder{}
+	frames := runtime.CallersFrames(pcs)
+	for {
+
+		frame, more := frames.Next()
+		fmt.Fprintf(&sb, "    %s\n        %s:%d\n", frame.Function, frame.File, frame.Line)
+
+		if !more {
+			break
+		}
+	}
+
+	return sb.String()
+}
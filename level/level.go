@@ -2,10 +2,30 @@ package level
 
 import (
 	"github.com/bloeys/nmage/assert"
+	"github.com/bloeys/nmage/assets"
 )
 
 type Level struct {
 	Name string
+
+	// Lightmap is the baked lighting of the level's static objects, or nil if the level has none
+	Lightmap *assets.LightmapAtlas
+}
+
+// LoadLightmap loads the lightmap atlas of the level, replacing any previous one
+func (l *Level) LoadLightmap(atlasFile string) error {
+
+	atlas, err := assets.LoadLightmapAtlas(atlasFile)
+	if err != nil {
+		return err
+	}
+
+	if l.Lightmap != nil {
+		l.Lightmap.Delete()
+	}
+
+	l.Lightmap = &atlas
+	return nil
 }
 
 func NewLevel(name string) *Level {
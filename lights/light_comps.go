@@ -0,0 +1,163 @@
+package lights
+
+import (
+	"slices"
+
+	"github.com/bloeys/nmage/entity"
+	"github.com/bloeys/nmage/registry"
+)
+
+var (
+	_ entity.Comp = &DirLightComp{}
+	_ entity.Comp = &PointLightComp{}
+	_ entity.Comp = &SpotLightComp{}
+	_ entity.Comp = &AreaLightComp{}
+)
+
+var (
+	// Light components add themselves to these on Init and remove themselves on Destroy,
+	// which is how light managers find them
+	dirLightComps   = []*DirLightComp{}
+	pointLightComps = []*PointLightComp{}
+	spotLightComps  = []*SpotLightComp{}
+	areaLightComps  = []*AreaLightComp{}
+)
+
+type DirLightComp struct {
+	entity.BaseComp
+	DirLight
+
+	shadowCache ShadowMapCache
+}
+
+// ShadowCache returns what the light's shadow map was last drawn with (see ShadowSettings.UpdateMode)
+func (d *DirLightComp) ShadowCache() *ShadowMapCache {
+	return &d.shadowCache
+}
+
+func (d *DirLightComp) Name() string {
+	return "Directional Light Component"
+}
+
+func (d *DirLightComp) Init(parentHandle registry.Handle) {
+	d.BaseComp.Init(parentHandle)
+	dirLightComps = append(dirLightComps, d)
+}
+
+func (d *DirLightComp) Destroy() {
+	dirLightComps = removeComp(dirLightComps, d)
+}
+
+type PointLightComp struct {
+	entity.BaseComp
+	PointLight
+
+	shadowCache ShadowMapCache
+}
+
+// ShadowCache returns what the light's shadow map was last drawn with (see ShadowSettings.UpdateMode)
+func (p *PointLightComp) ShadowCache() *ShadowMapCache {
+	return &p.shadowCache
+}
+
+func (p *PointLightComp) Name() string {
+	return "Point Light Component"
+}
+
+func (p *PointLightComp) Init(parentHandle registry.Handle) {
+	p.BaseComp.Init(parentHandle)
+	pointLightComps = append(pointLightComps, p)
+}
+
+func (p *PointLightComp) Destroy() {
+	pointLightComps = removeComp(pointLightComps, p)
+}
+
+type SpotLightComp struct {
+	entity.BaseComp
+	SpotLight
+
+	shadowCache ShadowMapCache
+}
+
+// ShadowCache returns what the light's shadow map was last drawn with (see ShadowSettings.UpdateMode)
+func (s *SpotLightComp) ShadowCache() *ShadowMapCache {
+	return &s.shadowCache
+}
+
+func (s *SpotLightComp) Name() string {
+	return "Spot Light Component"
+}
+
+func (s *SpotLightComp) Init(parentHandle registry.Handle) {
+	s.BaseComp.Init(parentHandle)
+	spotLightComps = append(spotLightComps, s)
+}
+
+func (s *SpotLightComp) Destroy() {
+	spotLightComps = removeComp(spotLightComps, s)
+}
+
+type AreaLightComp struct {
+	entity.BaseComp
+	AreaLight
+}
+
+func (a *AreaLightComp) Name() string {
+	return "Area Light Component"
+}
+
+func (a *AreaLightComp) Init(parentHandle registry.Handle) {
+	a.BaseComp.Init(parentHandle)
+	areaLightComps = append(areaLightComps, a)
+}
+
+func (a *AreaLightComp) Destroy() {
+	areaLightComps = removeComp(areaLightComps, a)
+}
+
+// DirLightComps returns all initialized directional light components. The returned slice must not be modified
+func DirLightComps() []*DirLightComp {
+	return dirLightComps
+}
+
+// PointLightComps returns all initialized point light components. The returned slice must not be modified
+func PointLightComps() []*PointLightComp {
+	return pointLightComps
+}
+
+// SpotLightComps returns all initialized spot light components. The returned slice must not be modified
+func SpotLightComps() []*SpotLightComp {
+	return spotLightComps
+}
+
+// AreaLightComps returns all initialized area light components. The returned slice must not be modified
+func AreaLightComps() []*AreaLightComp {
+	return areaLightComps
+}
+
+func removeComp[T comparable](comps []T, c T) []T {
+
+	i := slices.Index(comps, c)
+	if i == -1 {
+		return comps
+	}
+
+	return slices.Delete(comps, i, i+1)
+}
+
+func NewDirLightComp(l DirLight) *DirLightComp {
+	return &DirLightComp{DirLight: l}
+}
+
+func NewPointLightComp(l PointLight) *PointLightComp {
+	return &PointLightComp{PointLight: l}
+}
+
+func NewSpotLightComp(l SpotLight) *SpotLightComp {
+	return &SpotLightComp{SpotLight: l}
+}
+
+func NewAreaLightComp(l AreaLight) *AreaLightComp {
+	return &AreaLightComp{AreaLight: l}
+}
@@ -0,0 +1,176 @@
+package lights
+
+import (
+	"slices"
+
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/camera"
+	"github.com/bloeys/nmage/color"
+)
+
+// LightManager gathers the light components every frame, culls the ones that can't affect the view,
+// and writes the rest into the lights ubo data. Lights that don't light any layer the camera draws (see camera.LayerMask)
+// are always culled, since they can't affect anything on screen, and the shaders skip the objects not on a light's layers.
+//
+// Only MaxPointLights point lights, MaxSpotLights spot lights and MaxAreaLights area lights fit in the ubo, so when more than that are visible
+// the ones closest to the camera are used. The index of a light in the Visible* slices is its index in the ubo.
+// Spot lights render their shadow map into the layer of their ubo index, while point lights get their cubemap layer
+// from PointShadowLayers, so it stays the same as other lights come and go.
+//
+// Renderers without the ubo limits (e.g. deferred renderers) can use AllVisiblePointLights and AllVisibleSpotLights,
+// which start with the Visible* lights in the same order
+type LightManager struct {
+	AmbientColor color.Color
+
+	// DisableCulling makes all lights considered visible, which is useful when debugging lighting
+	DisableCulling bool
+
+	// DirLight is the first directional light component, or nil if there are none.
+	// The lit shaders only support one directional light
+	DirLight           *DirLightComp
+	VisiblePointLights []*PointLightComp
+	VisibleSpotLights  []*SpotLightComp
+	VisibleAreaLights  []*AreaLightComp
+
+	// AllVisiblePointLights and AllVisibleSpotLights have all the lights that passed culling, sorted by distance
+	// to the camera when there are more than fit in the ubo
+	AllVisiblePointLights []*PointLightComp
+	AllVisibleSpotLights  []*SpotLightComp
+
+	// PointShadowLayers has the layer in the point light shadow cubemap array of each visible point light with shadows,
+	// which is also written to the light's ubo data
+	PointShadowLayers PointShadowLayers
+
+	UboData LightsUboData
+}
+
+// Update gathers and culls lights against the camera and refreshes UboData.
+// Should be called once per frame after the camera was updated
+func (lm *LightManager) Update(cam *camera.Camera) {
+
+	frustum := cam.Frustum()
+
+	// Directional light
+	lm.DirLight = nil
+	lm.UboData.DirLight = DirLightUboData{}
+	if len(dirLightComps) > 0 {
+		lm.DirLight = dirLightComps[0]
+		lm.UboData.DirLight = lm.DirLight.ToUboData()
+	}
+
+	// Point lights
+	lm.AllVisiblePointLights = lm.AllVisiblePointLights[:0]
+	for _, p := range pointLightComps {
+
+		if !cam.CullingMask.SeesAnyOf(p.CullingMask) {
+			continue
+		}
+
+		if !lm.DisableCulling && !frustum.SphereVisible(&p.Pos, p.Radius) {
+			continue
+		}
+
+		lm.AllVisiblePointLights = append(lm.AllVisiblePointLights, p)
+	}
+
+	if len(lm.AllVisiblePointLights) > MaxPointLights {
+		slices.SortStableFunc(lm.AllVisiblePointLights, func(a, b *PointLightComp) int {
+			return compareDist(&cam.Pos, &a.Pos, &b.Pos)
+		})
+	}
+	lm.VisiblePointLights = append(lm.VisiblePointLights[:0], lm.AllVisiblePointLights[:min(len(lm.AllVisiblePointLights), MaxPointLights)]...)
+
+	lm.PointShadowLayers.update(lm.VisiblePointLights)
+	for i := 0; i < MaxPointLights; i++ {
+
+		// Unused slots are zeroed so lights removed since the last update don't stay lit
+		if i >= len(lm.VisiblePointLights) {
+			lm.UboData.PointLights[i] = PointLightUboData{}
+			continue
+		}
+
+		p := lm.VisiblePointLights[i]
+		lm.UboData.PointLights[i] = p.ToUboData()
+		lm.UboData.PointLights[i].ShadowLayer = lm.PointShadowLayers.Layer(p)
+	}
+
+	// Spot lights
+	lm.AllVisibleSpotLights = lm.AllVisibleSpotLights[:0]
+	for _, s := range spotLightComps {
+
+		if !cam.CullingMask.SeesAnyOf(s.CullingMask) {
+			continue
+		}
+
+		// The sphere around the light position with a radius of the range contains the whole cone
+		if !lm.DisableCulling && s.Range > 0 && !frustum.SphereVisible(&s.Pos, s.Range) {
+			continue
+		}
+
+		lm.AllVisibleSpotLights = append(lm.AllVisibleSpotLights, s)
+	}
+
+	if len(lm.AllVisibleSpotLights) > MaxSpotLights {
+		slices.SortStableFunc(lm.AllVisibleSpotLights, func(a, b *SpotLightComp) int {
+			return compareDist(&cam.Pos, &a.Pos, &b.Pos)
+		})
+	}
+	lm.VisibleSpotLights = append(lm.VisibleSpotLights[:0], lm.AllVisibleSpotLights[:min(len(lm.AllVisibleSpotLights), MaxSpotLights)]...)
+
+	for i := 0; i < MaxSpotLights; i++ {
+
+		if i >= len(lm.VisibleSpotLights) {
+			lm.UboData.SpotLights[i] = SpotLightUboData{}
+			continue
+		}
+
+		lm.UboData.SpotLights[i] = lm.VisibleSpotLights[i].ToUboData()
+	}
+
+	// Area lights
+	lm.VisibleAreaLights = lm.VisibleAreaLights[:0]
+	for _, a := range areaLightComps {
+
+		if !cam.CullingMask.SeesAnyOf(a.CullingMask) {
+			continue
+		}
+
+		if !lm.DisableCulling && a.Range > 0 && !frustum.SphereVisible(&a.Pos, a.BoundingRadius()) {
+			continue
+		}
+
+		lm.VisibleAreaLights = append(lm.VisibleAreaLights, a)
+	}
+
+	if len(lm.VisibleAreaLights) > MaxAreaLights {
+		slices.SortStableFunc(lm.VisibleAreaLights, func(a, b *AreaLightComp) int {
+			return compareDist(&cam.Pos, &a.Pos, &b.Pos)
+		})
+		lm.VisibleAreaLights = lm.VisibleAreaLights[:MaxAreaLights]
+	}
+
+	for i := 0; i < MaxAreaLights; i++ {
+
+		if i >= len(lm.VisibleAreaLights) {
+			lm.UboData.AreaLights[i] = AreaLightUboData{}
+			continue
+		}
+
+		lm.UboData.AreaLights[i] = lm.VisibleAreaLights[i].ToUboData()
+	}
+
+	lm.UboData.AmbientColor = lm.AmbientColor
+}
+
+func compareDist(origin, a, b *gglm.Vec3) int {
+
+	distA := gglm.DistVec3(origin, a)
+	distB := gglm.DistVec3(origin, b)
+	if distA < distB {
+		return -1
+	} else if distA > distB {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,266 @@
+package lights
+
+import (
+	"math"
+
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/camera"
+)
+
+// SH9 is incoming light (radiance) stored as rgb L2 spherical harmonics, which is 9 coefficients per color channel.
+// This is enough to store the low frequency light that diffuse surfaces care about (e.g. a bright sky above and a red wall to the left)
+// in a few floats, and to get the light arriving at any normal with a handful of multiply-adds.
+//
+// Coefficients are in the usual order: l=0, then l=1 (m=-1,0,1), then l=2 (m=-2,-1,0,1,2)
+type SH9 [9]gglm.Vec3
+
+// Basis constants of the real spherical harmonics up to l=2
+const (
+	shY0  = 0.282095
+	shY1  = 0.488603
+	shY2  = 1.092548
+	shY20 = 0.315392
+	shY22 = 0.546274
+)
+
+// shBasis evaluates the 9 basis functions in direction dir, which must be normalized
+func shBasis(x, y, z float32) [9]float32 {
+	return [9]float32{
+		shY0,
+		shY1 * y,
+		shY1 * z,
+		shY1 * x,
+		shY2 * x * y,
+		shY2 * y * z,
+		shY20 * (3*z*z - 1),
+		shY2 * x * z,
+		shY22 * (x*x - y*y),
+	}
+}
+
+// AddSample adds radiance coming from the normalized direction dir, scaled by weight (e.g. the solid angle the sample covers)
+func (sh *SH9) AddSample(dir *gglm.Vec3, radiance *gglm.Vec3, weight float32) {
+
+	basis := shBasis(dir.X(), dir.Y(), dir.Z())
+	for i := 0; i < len(sh); i++ {
+		sh[i].Add(radiance.Clone().Scale(basis[i] * weight))
+	}
+}
+
+func (sh *SH9) Scale(s float32) {
+	for i := 0; i < len(sh); i++ {
+		sh[i].Scale(s)
+	}
+}
+
+// AddScaled adds other*s to sh, which is used to blend probes
+func (sh *SH9) AddScaled(other *SH9, s float32) {
+	for i := 0; i < len(sh); i++ {
+		sh[i].Add(other[i].Clone().Scale(s))
+	}
+}
+
+// Irradiance returns the light a diffuse surface with the normalized normal n receives, divided by pi so it can be multiplied
+// with the diffuse color directly like an ambient color. The shaders do the same calculation.
+//
+// Based on: 'An Efficient Representation for Irradiance Environment Maps' by Ramamoorthi and Hanrahan
+func (sh *SH9) Irradiance(n *gglm.Vec3) gglm.Vec3 {
+
+	// Cosine lobe convolution constants of each band, divided by pi
+	const (
+		a0 = 1
+		a1 = 2.0 / 3.0
+		a2 = 1.0 / 4.0
+	)
+
+	basis := shBasis(n.X(), n.Y(), n.Z())
+	bandScales := [9]float32{a0, a1, a1, a1, a2, a2, a2, a2, a2}
+
+	irradiance := gglm.Vec3{}
+	for i := 0; i < len(sh); i++ {
+		irradiance.Add(sh[i].Clone().Scale(basis[i] * bandScales[i]))
+	}
+
+	irradiance.SetXYZ(max(0, irradiance.X()), max(0, irradiance.Y()), max(0, irradiance.Z()))
+	return irradiance
+}
+
+// ProjectCubemapToSH converts the six faces of a cubemap rendered with camera.CubemapViewMats and camera.CubemapProjMat (e.g. by renderer.CubemapPass)
+// into spherical harmonics.
+//
+// Every face is size*size rgba float pixels with the bottom row first, which is how gl.ReadPixels returns them
+func ProjectCubemapToSH(faces *[6][]float32, size int) SH9 {
+
+	sh := SH9{}
+	weightSum := float32(0)
+	for faceIndex := 0; faceIndex < len(faces); faceIndex++ {
+
+		face := &camera.CubemapFaces[faceIndex]
+		right := gglm.Cross(&face.Forward, &face.Up)
+		up := gglm.Cross(&right, &face.Forward)
+
+		pixels := faces[faceIndex]
+		for y := 0; y < size; y++ {
+
+			v := (float32(y)+0.5)/float32(size)*2 - 1
+			for x := 0; x < size; x++ {
+
+				u := (float32(x)+0.5)/float32(size)*2 - 1
+
+				// Texels near the face corners cover a smaller part of the sphere than ones at the center
+				distSqr := 1 + u*u + v*v
+				weight := 4 / (float32(size*size) * distSqr * gglm.Sqrt32(distSqr))
+
+				dir := *face.Forward.Clone().Add(right.Clone().Scale(u)).Add(up.Clone().Scale(v))
+				dir.Normalize()
+
+				pixelIndex := (y*size + x) * 4
+				radiance := gglm.NewVec3(pixels[pixelIndex], pixels[pixelIndex+1], pixels[pixelIndex+2])
+
+				sh.AddSample(&dir, &radiance, weight)
+				weightSum += weight
+			}
+		}
+	}
+
+	// Weights only approximate the solid angles, so normalize them to cover exactly the whole sphere
+	sh.Scale(4 * math.Pi / weightSum)
+	return sh
+}
+
+// LightProbe stores the light arriving at a point in the scene, which lights dynamic objects near it
+type LightProbe struct {
+	Pos gglm.Vec3
+	SH  SH9
+
+	// IsCaptured is false until SH is set, and probes that aren't captured are ignored when sampling a grid
+	IsCaptured bool
+}
+
+// CaptureViewMats returns the view matrices of the six cubemap faces to render around the probe, to be used with LightProbeCaptureProjMat
+func (p *LightProbe) CaptureViewMats() [6]gglm.Mat4 {
+	return camera.CubemapViewMats(&p.Pos)
+}
+
+// LightProbeCaptureProjMat returns the 90 degree projection that makes the views of LightProbe.CaptureViewMats into a cubemap
+func LightProbeCaptureProjMat(nearPlane, farPlane float32) gglm.Mat4 {
+	return camera.CubemapProjMat(nearPlane, farPlane)
+}
+
+// LightProbeGrid places probes evenly in a box, and gives any position inside it the light of the probes around it.
+// Positions outside the box use the probes at the closest edge.
+//
+// Probes are stored with x changing fastest, then y, then z
+type LightProbeGrid struct {
+	Min gglm.Vec3
+	Max gglm.Vec3
+
+	CountX int
+	CountY int
+	CountZ int
+
+	Probes []LightProbe
+}
+
+// NewLightProbeGrid creates a grid with countX*countY*countZ uncaptured probes spread between min and max, where each count must be at least 1.
+// A count of 1 places the probes of that axis in the middle of the box
+func NewLightProbeGrid(min, max gglm.Vec3, countX, countY, countZ int) LightProbeGrid {
+
+	g := LightProbeGrid{
+		Min:    min,
+		Max:    max,
+		CountX: countX,
+		CountY: countY,
+		CountZ: countZ,
+		Probes: make([]LightProbe, countX*countY*countZ),
+	}
+
+	for z := 0; z < countZ; z++ {
+		for y := 0; y < countY; y++ {
+			for x := 0; x < countX; x++ {
+				g.Probe(x, y, z).Pos = gglm.NewVec3(
+					probeAxisPos(min.X(), max.X(), x, countX),
+					probeAxisPos(min.Y(), max.Y(), y, countY),
+					probeAxisPos(min.Z(), max.Z(), z, countZ),
+				)
+			}
+		}
+	}
+
+	return g
+}
+
+func probeAxisPos(min, max float32, index, count int) float32 {
+
+	if count == 1 {
+		return (min + max) * 0.5
+	}
+
+	return min + (max-min)*float32(index)/float32(count-1)
+}
+
+func (g *LightProbeGrid) Probe(x, y, z int) *LightProbe {
+	return &g.Probes[x+y*g.CountX+z*g.CountX*g.CountY]
+}
+
+// SampleAmbient blends the 8 probes around pos with trilinear interpolation and writes the result into outSH.
+// Probes that aren't captured yet are skipped, and false is returned if none of the 8 probes are captured.
+//
+// This satisfies renderer.AmbientSampler, so a grid can be given directly to the renderer
+func (g *LightProbeGrid) SampleAmbient(pos *gglm.Vec3, outSH *[9]gglm.Vec3) bool {
+
+	x0, x1, tx := probeAxisCell(g.Min.X(), g.Max.X(), pos.X(), g.CountX)
+	y0, y1, ty := probeAxisCell(g.Min.Y(), g.Max.Y(), pos.Y(), g.CountY)
+	z0, z1, tz := probeAxisCell(g.Min.Z(), g.Max.Z(), pos.Z(), g.CountZ)
+
+	sh := (*SH9)(outSH)
+	*sh = SH9{}
+
+	weightSum := float32(0)
+	for i := 0; i < 8; i++ {
+
+		x, wx := x0, 1-tx
+		if i&1 != 0 {
+			x, wx = x1, tx
+		}
+
+		y, wy := y0, 1-ty
+		if i&2 != 0 {
+			y, wy = y1, ty
+		}
+
+		z, wz := z0, 1-tz
+		if i&4 != 0 {
+			z, wz = z1, tz
+		}
+
+		weight := wx * wy * wz
+		p := g.Probe(x, y, z)
+		if weight == 0 || !p.IsCaptured {
+			continue
+		}
+
+		sh.AddScaled(&p.SH, weight)
+		weightSum += weight
+	}
+
+	if weightSum == 0 {
+		return false
+	}
+
+	sh.Scale(1 / weightSum)
+	return true
+}
+
+// probeAxisCell returns the indices of the two probes around pos on one axis, and how far pos is between them in [0, 1]
+func probeAxisCell(axisMin, axisMax, pos float32, count int) (i0, i1 int, t float32) {
+
+	if count == 1 || axisMax <= axisMin {
+		return 0, 0, 0
+	}
+
+	cellPos := gglm.Clamp((pos-axisMin)/(axisMax-axisMin), 0, 1) * float32(count-1)
+
+	i0 = min(int(cellPos), count-2)
+	return i0, i0 + 1, cellPos - float32(i0)
+}
@@ -0,0 +1,231 @@
+package lights
+
+import (
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/camera"
+	"github.com/bloeys/nmage/color"
+)
+
+const (
+	// These must match the shader values
+	MaxPointLights = 8
+
+	// If this changes update the array depth map shader
+	MaxSpotLights = 4
+
+	MaxAreaLights = 4
+)
+
+// ShadowSettings controls how a single light casts shadows
+type ShadowSettings struct {
+	Enabled bool
+
+	// Resolution is the width and height of the shadow map in pixels.
+	// All point lights share one shadow map array and so do all spot lights, so those use the largest resolution of their type
+	Resolution uint32 `editor:"readonly"`
+
+	// NearPlane and FarPlane are the range of the light's shadow projection.
+	// Surfaces outside it don't receive shadows from this light
+	NearPlane float32 `editor:"min=0.01,max=1000,speed=0.1"`
+	FarPlane  float32 `editor:"min=0.01,max=1000,speed=0.1"`
+
+	// BiasConstant is the smallest depth bias, used for surfaces facing the light
+	BiasConstant float32 `editor:"min=0,max=1,speed=0.001,format=%.4f"`
+
+	// BiasSlope is the bias added as surfaces turn away from the light, where shadow acne is worse.
+	// The final bias is max(BiasSlope*(1-dot(normal, lightDir)), BiasConstant)
+	BiasSlope float32 `editor:"min=0,max=1,speed=0.001,format=%.4f"`
+
+	// NormalOffset moves the shadow lookup position along the surface normal (in world units),
+	// which reduces acne without the 'peter panning' large biases cause
+	NormalOffset float32 `editor:"min=0,max=1,speed=0.001,format=%.4f"`
+
+	// PcfRadius is the radius in texels of 'Percentage Close Filtering' used for soft shadows,
+	// where 0 takes one sample, 1 averages 3x3 samples, 2 averages 5x5 samples etc
+	PcfRadius int32 `editor:"min=0,max=4,speed=0.05"`
+
+	// PolygonOffsetFactor and PolygonOffsetUnits push the depth written into the shadow map away from the light (see glPolygonOffset).
+	// The factor scales with how steep the surface is from the light, and the units are in the smallest depth steps.
+	// Unlike BiasConstant and BiasSlope this is done while drawing the shadow map, so it costs nothing in the lit shaders
+	PolygonOffsetFactor float32 `editor:"min=0,max=10,speed=0.01"`
+	PolygonOffsetUnits  float32 `editor:"min=0,max=100,speed=0.1"`
+
+	// CullFrontFaces draws only the back faces of meshes into the shadow map, which helps acne and 'peter panning' on closed meshes,
+	// but open meshes (e.g. quads) don't cast shadows with it
+	CullFrontFaces bool
+
+	// UpdateMode is how often the shadow map is drawn, where UpdateInterval is the frames between draws of ShadowUpdateMode_Interval
+	UpdateMode     ShadowUpdateMode `editor:"enum=EveryFrame|Interval|OnChange|Static"`
+	UpdateInterval int32            `editor:"min=1,max=120,speed=0.1"`
+
+	// CacheStatic draws the casters with renderer.ObjectFlags_Static into a separate cached map, which is only drawn again when the
+	// light's projection or settings change (see ShadowMapCache.ShouldDrawStatic). Every time the shadow map is drawn the cached map
+	// is copied into it and only the dynamic casters are drawn on top, which is much cheaper in mostly static scenes
+	CacheStatic bool
+}
+
+type DirLight struct {
+	Dir           gglm.Vec3
+	DiffuseColor  color.Color `editor:"rgb"`
+	SpecularColor color.Color `editor:"rgb"`
+
+	// ShadowPos is where the shadow projection looks from, since directional lights have no position
+	ShadowPos gglm.Vec3
+	// ShadowSize is the half width and height of the area covered by the orthographic shadow projection
+	ShadowSize float32
+	Shadow     ShadowSettings
+
+	// CullingMask is the layers of the objects lit by the light, where zero lights all layers (see camera.LayerMask)
+	CullingMask camera.LayerMask
+}
+
+func (d *DirLight) GetProjViewMat() gglm.Mat4 {
+
+	pos := d.ShadowPos
+	size := d.ShadowSize
+
+	up := gglm.NewVec3(0, 1, 0)
+	projMat := gglm.Ortho(-size, size, -size, size, d.Shadow.NearPlane, d.Shadow.FarPlane).Mat4
+	viewMat := gglm.LookAtRH(&pos, pos.Clone().Add(&d.Dir), &up).Mat4
+
+	return *projMat.Mul(&viewMat)
+}
+
+// Based on: https://lisyarus.github.io/blog/posts/point-light-attenuation.html
+type PointLight struct {
+	Pos           gglm.Vec3
+	DiffuseColor  color.Color `editor:"rgb"`
+	SpecularColor color.Color `editor:"rgb"`
+
+	Radius  float32
+	Falloff float32
+
+	// Shadow.NearPlane is the distance where if the pixel is closer to the light
+	// than this distance, no shadow will be casted. This helps not produce shadows from within objects.
+	//
+	// Shadow.FarPlane is the max distance at which shadows from this light will show.
+	// This should be a bit bigger than the radius, as an object at the edge of the radius
+	// should still cast a shadow, and so this shadow will be further than the radius.
+	// Something like 'FarPlane=Radius*1.25' might work.
+	Shadow ShadowSettings
+
+	// CullingMask is the layers of the objects lit by the light, where zero lights all layers (see camera.LayerMask)
+	CullingMask camera.LayerMask
+}
+
+func (p *PointLight) GetProjViewMats(shadowMapWidth, shadowMapHeight float32) [6]gglm.Mat4 {
+
+	aspect := float32(shadowMapWidth) / float32(shadowMapHeight)
+	projMat := gglm.Perspective(90*gglm.Deg2Rad, aspect, p.Shadow.NearPlane, p.Shadow.FarPlane)
+
+	// The faces of the shadow cubemap are the same as any other cubemap's
+	viewMats := camera.CubemapViewMats(&p.Pos)
+
+	projViewMats := [6]gglm.Mat4{}
+	for i := 0; i < len(viewMats); i++ {
+		projViewMats[i] = *projMat.Clone().Mul(&viewMats[i])
+	}
+
+	return projViewMats
+}
+
+type SpotLight struct {
+	Pos            gglm.Vec3
+	Dir            gglm.Vec3
+	DiffuseColor   color.Color `editor:"rgb"`
+	SpecularColor  color.Color `editor:"rgb"`
+	InnerCutoffRad float32
+	OuterCutoffRad float32
+
+	// CookieTex is an optional texture (e.g. a window frame or leaves) projected by the light that multiplies its color.
+	// It uses the same projection as the light's shadow map, so the cookie covers the outer cutoff cone.
+	// Zero means no cookie.
+	//
+	// Texture ids are only valid while the game runs, so this is not saved
+	CookieTex uint32 `json:"-" editor:"-"`
+
+	// Range is how far the light reaches, and is only used to cull the light when it can't affect the view.
+	// Zero means the light is never culled
+	Range float32
+
+	// A Shadow.NearPlane like 0.x (or anything too small) causes shadows to not work properly.
+	// Needs adjusting as the distance of light to object increases
+	Shadow ShadowSettings
+
+	// CullingMask is the layers of the objects lit by the light, where zero lights all layers (see camera.LayerMask)
+	CullingMask camera.LayerMask
+}
+
+func (s *SpotLight) GetProjViewMat() gglm.Mat4 {
+
+	projMat := gglm.Perspective(s.OuterCutoffRad*2, 1, s.Shadow.NearPlane, s.Shadow.FarPlane)
+
+	// Adjust up vector if lightDir is parallel or nearly parallel to upVector
+	// as lookat view matrix breaks if up and look at are parallel
+	up := gglm.NewVec3(0, 1, 0)
+	if gglm.Abs32(gglm.DotVec3(&s.Dir, &up)) > 0.99 {
+		up.SetXY(1, 0)
+	}
+
+	viewMat := gglm.LookAtRH(&s.Pos, s.Pos.Clone().Add(&s.Dir), &up).Mat4
+
+	return *projMat.Mul(&viewMat)
+}
+
+func (s *SpotLight) InnerCutoffCos() float32 {
+	return gglm.Cos32(s.InnerCutoffRad)
+}
+
+func (s *SpotLight) OuterCutoffCos() float32 {
+	return gglm.Cos32(s.OuterCutoffRad)
+}
+
+// AreaLight is a rectangle that emits light from its front face, like a lamp panel or a window.
+// It is shaded with 'Linearly Transformed Cosines' (see LtcLuts), and doesn't cast shadows.
+//
+// Unlike point and spot lights, the amount of light depends on how much of the view the rectangle covers,
+// so larger lights are brighter and there is no radius where the light fades out
+type AreaLight struct {
+	// Pos is the center of the rectangle
+	Pos gglm.Vec3
+
+	// Right and Up are the unit directions of the rectangle's sides. They should be perpendicular,
+	// and the light faces cross(Right, Up)
+	Right gglm.Vec3
+	Up    gglm.Vec3
+
+	Width  float32
+	Height float32
+
+	DiffuseColor  color.Color `editor:"rgb"`
+	SpecularColor color.Color `editor:"rgb"`
+
+	// TwoSided makes the back face emit light as well
+	TwoSided bool
+
+	// Range is how far the light visibly reaches, and is only used to cull the light when it can't affect the view.
+	// Zero means the light is never culled
+	Range float32
+
+	// CullingMask is the layers of the objects lit by the light, where zero lights all layers (see camera.LayerMask)
+	CullingMask camera.LayerMask
+}
+
+// Points returns the corners of the rectangle in the order the shaders expect
+func (a *AreaLight) Points() [4]gglm.Vec3 {
+
+	halfRight := a.Right.Clone().Scale(a.Width * 0.5)
+	halfUp := a.Up.Clone().Scale(a.Height * 0.5)
+
+	return [4]gglm.Vec3{
+		*a.Pos.Clone().Sub(halfRight).Sub(halfUp),
+		*a.Pos.Clone().Add(halfRight).Sub(halfUp),
+		*a.Pos.Clone().Add(halfRight).Add(halfUp),
+		*a.Pos.Clone().Sub(halfRight).Add(halfUp),
+	}
+}
+
+// BoundingRadius is the radius of the sphere around Pos that contains everything the light can affect
+func (a *AreaLight) BoundingRadius() float32 {
+	return a.Range + 0.5*gglm.Sqrt32(a.Width*a.Width+a.Height*a.Height)
+}
@@ -0,0 +1,589 @@
+package lights
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/bloeys/nmage/assets"
+	"github.com/bloeys/nmage/logging"
+)
+
+// LtcLutSize is the width and height of the LTC lookup tables. Must match LTC_LUT_SIZE in the lit shaders
+const LtcLutSize = 32
+
+// LtcLuts are the two lookup tables used to shade area lights with 'Linearly Transformed Cosines'.
+// Both are LtcLutSize*LtcLutSize RGBA float tables stored row by row, where the x axis is the roughness
+// and the y axis is sqrt(1-dot(normal, viewDir)).
+//
+// Mat holds the non-constant entries of the inverse LTC matrix (m00, m02, m20, m22) normalized by m11.
+// Amp holds the GGX magnitude, the fresnel term, unused, and in its last channel the horizon clipped form factor of a sphere
+// (with its own (z*0.5+0.5, formFactor) parameterization), which is used instead of clipping polygons to the horizon.
+//
+// Based on: https://eheitzresearch.wordpress.com/415-2/
+type LtcLuts struct {
+	Mat []float32
+	Amp []float32
+}
+
+// LoadLtcLuts reads lookup tables written by LtcLuts.WriteFile.
+// If the file doesn't exist the tables are fitted with NewLtcLuts instead, which is slow
+func LoadLtcLuts(file string) (LtcLuts, error) {
+
+	fileBytes, err := os.ReadFile(assets.ResolvePath(file))
+	if os.IsNotExist(err) {
+		logging.WarnLog.Printf("LTC lookup tables file '%s' not found, so the tables will be fitted, which takes a while. Write them with LtcLuts.WriteFile to avoid this\n", file)
+		return NewLtcLuts(), nil
+	}
+
+	if err != nil {
+		return LtcLuts{}, err
+	}
+
+	const tableLen = LtcLutSize * LtcLutSize * 4
+	if len(fileBytes) != tableLen*2*4 {
+		return LtcLuts{}, fmt.Errorf("LTC lookup tables file '%s' has %d bytes but expected %d. Was it written with a different LtcLutSize?", file, len(fileBytes), tableLen*2*4)
+	}
+
+	luts := LtcLuts{
+		Mat: make([]float32, tableLen),
+		Amp: make([]float32, tableLen),
+	}
+
+	r := bytes.NewReader(fileBytes)
+	if err := binary.Read(r, binary.LittleEndian, luts.Mat); err != nil {
+		return LtcLuts{}, err
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, luts.Amp); err != nil {
+		return LtcLuts{}, err
+	}
+
+	return luts, nil
+}
+
+// WriteFile writes the tables as little endian float32s, Mat followed by Amp
+func (l *LtcLuts) WriteFile(file string) error {
+
+	buf := bytes.Buffer{}
+	buf.Grow((len(l.Mat) + len(l.Amp)) * 4)
+
+	if err := binary.Write(&buf, binary.LittleEndian, l.Mat); err != nil {
+		return err
+	}
+
+	if err := binary.Write(&buf, binary.LittleEndian, l.Amp); err != nil {
+		return err
+	}
+
+	return os.WriteFile(assets.ResolvePath(file), buf.Bytes(), 0644)
+}
+
+// NewLtcLuts fits the LTC lookup tables to the GGX brdf.
+// The fit takes many seconds, so the tables are normally loaded with LoadLtcLuts from a file they were written to once
+func NewLtcLuts() LtcLuts {
+
+	const n = LtcLutSize
+
+	luts := LtcLuts{
+		Mat: make([]float32, n*n*4),
+		Amp: make([]float32, n*n*4),
+	}
+
+	// Each fit starts from the result of a neighbouring one, going from rough to smooth.
+	// Rows only depend on their first entry, so that column is fitted first and then the rows in parallel
+	firstFits := [n]ltcFit{}
+	for a := n - 1; a >= 0; a-- {
+
+		fit := newIsotropicLtcFit()
+		if a < n-1 {
+			fit.m11 = firstFits[a+1].m11
+			fit.m22 = firstFits[a+1].m22
+		}
+
+		fitLtcEntry(&fit, a, 0)
+		firstFits[a] = fit
+	}
+
+	wg := sync.WaitGroup{}
+	for a := 0; a < n; a++ {
+
+		wg.Add(1)
+		go func(a int) {
+			defer wg.Done()
+
+			fit := firstFits[a]
+			luts.storeFit(&fit, a, 0)
+
+			for t := 1; t < n; t++ {
+				fitLtcEntry(&fit, a, t)
+				luts.storeFit(&fit, a, t)
+			}
+		}(a)
+	}
+
+	wg.Wait()
+
+	luts.fillSphereTable()
+	return luts
+}
+
+func (l *LtcLuts) storeFit(fit *ltcFit, a, t int) {
+
+	invM := fit.invM
+
+	// Normalizing by the middle entry makes it always 1 so it doesn't need storing
+	i := (a + t*LtcLutSize) * 4
+	l.Mat[i+0] = float32(invM[0][0] / invM[1][1])
+	l.Mat[i+1] = float32(invM[0][2] / invM[1][1])
+	l.Mat[i+2] = float32(invM[2][0] / invM[1][1])
+	l.Mat[i+3] = float32(invM[2][2] / invM[1][1])
+
+	l.Amp[i+0] = float32(fit.magnitude)
+	l.Amp[i+1] = float32(fit.fresnel)
+}
+
+// fillSphereTable writes the form factor of a sphere clipped to the horizon, divided by its unclipped form factor,
+// into the last channel of Amp. The x axis is the cosine of the sphere's elevation remapped to [0, 1],
+// and the y axis is the unclipped form factor, which for a sphere of angular radius r is sin(r)^2
+func (l *LtcLuts) fillSphereTable() {
+
+	const n = LtcLutSize
+	const samples = 64
+
+	for j := 0; j < n; j++ {
+
+		formFactor := float64(j) / (n - 1)
+		cosRadius := math.Sqrt(1 - formFactor)
+
+		for i := 0; i < n; i++ {
+
+			z := float64(i)/(n-1)*2 - 1
+			sinElev := math.Sqrt(max(0, 1-z*z))
+
+			// Tiny spheres are fully above or below the horizon
+			scale := max(z, 0)
+			if formFactor > 0 {
+
+				// Integrate cos/pi over the cap around the sphere direction with a midpoint grid over (cos, phi)
+				sum := 0.0
+				for v := 0; v < samples; v++ {
+
+					cosA := 1 - (float64(v)+0.5)/samples*(1-cosRadius)
+					sinA := math.Sqrt(max(0, 1-cosA*cosA))
+					for u := 0; u < samples; u++ {
+
+						phi := (float64(u) + 0.5) / samples * 2 * math.Pi
+						sum += max(0, cosA*z+sinA*math.Cos(phi)*sinElev)
+					}
+				}
+
+				capSolidAngle := 2 * math.Pi * (1 - cosRadius)
+				clippedFormFactor := sum / (samples * samples) * capSolidAngle / math.Pi
+				scale = clippedFormFactor / formFactor
+			}
+
+			l.Amp[(i+j*n)*4+3] = float32(scale)
+		}
+	}
+}
+
+const (
+	ltcMinAlpha     = 0.00001
+	ltcFitSamples   = 16
+	ltcFitMaxIters  = 100
+	ltcFitStartStep = 0.05
+	ltcFitTolerance = 1e-5
+)
+
+type ltcVec3 [3]float64
+
+func (v ltcVec3) add(o ltcVec3) ltcVec3 {
+	return ltcVec3{v[0] + o[0], v[1] + o[1], v[2] + o[2]}
+}
+
+func (v ltcVec3) scale(s float64) ltcVec3 {
+	return ltcVec3{v[0] * s, v[1] * s, v[2] * s}
+}
+
+func (v ltcVec3) dot(o ltcVec3) float64 {
+	return v[0]*o[0] + v[1]*o[1] + v[2]*o[2]
+}
+
+func (v ltcVec3) len() float64 {
+	return math.Sqrt(v.dot(v))
+}
+
+func (v ltcVec3) normalize() ltcVec3 {
+	return v.scale(1 / v.len())
+}
+
+// ltcMat3 is indexed [col][row]
+type ltcMat3 [3]ltcVec3
+
+func (m *ltcMat3) mulVec(v ltcVec3) ltcVec3 {
+	return m[0].scale(v[0]).add(m[1].scale(v[1])).add(m[2].scale(v[2]))
+}
+
+func (m *ltcMat3) mul(o *ltcMat3) ltcMat3 {
+	return ltcMat3{m.mulVec(o[0]), m.mulVec(o[1]), m.mulVec(o[2])}
+}
+
+func (m *ltcMat3) det() float64 {
+	return m[0][0]*(m[1][1]*m[2][2]-m[2][1]*m[1][2]) -
+		m[1][0]*(m[0][1]*m[2][2]-m[2][1]*m[0][2]) +
+		m[2][0]*(m[0][1]*m[1][2]-m[1][1]*m[0][2])
+}
+
+func (m *ltcMat3) inverse() ltcMat3 {
+
+	invDet := 1 / m.det()
+
+	inv := ltcMat3{}
+	inv[0][0] = (m[1][1]*m[2][2] - m[2][1]*m[1][2]) * invDet
+	inv[1][0] = -(m[1][0]*m[2][2] - m[2][0]*m[1][2]) * invDet
+	inv[2][0] = (m[1][0]*m[2][1] - m[2][0]*m[1][1]) * invDet
+	inv[0][1] = -(m[0][1]*m[2][2] - m[2][1]*m[0][2]) * invDet
+	inv[1][1] = (m[0][0]*m[2][2] - m[2][0]*m[0][2]) * invDet
+	inv[2][1] = -(m[0][0]*m[2][1] - m[2][0]*m[0][1]) * invDet
+	inv[0][2] = (m[0][1]*m[1][2] - m[1][1]*m[0][2]) * invDet
+	inv[1][2] = -(m[0][0]*m[1][2] - m[1][0]*m[0][2]) * invDet
+	inv[2][2] = (m[0][0]*m[1][1] - m[1][0]*m[0][1]) * invDet
+
+	return inv
+}
+
+// ltcFit is a clamped cosine transformed by M = basis * [[m11, 0, m13], [0, m22, 0], [0, 0, 1]]
+type ltcFit struct {
+	m11, m22, m13 float64
+	x, y, z       ltcVec3
+
+	magnitude float64
+	fresnel   float64
+
+	// Set by update
+	m    ltcMat3
+	invM ltcMat3
+	detM float64
+}
+
+func newIsotropicLtcFit() ltcFit {
+	return ltcFit{
+		m11: 1,
+		m22: 1,
+		x:   ltcVec3{1, 0, 0},
+		y:   ltcVec3{0, 1, 0},
+		z:   ltcVec3{0, 0, 1},
+	}
+}
+
+func (f *ltcFit) update() {
+
+	basis := ltcMat3{f.x, f.y, f.z}
+	params := ltcMat3{{f.m11, 0, 0}, {0, f.m22, 0}, {f.m13, 0, 1}}
+
+	f.m = basis.mul(&params)
+	f.invM = f.m.inverse()
+	f.detM = math.Abs(f.m.det())
+}
+
+func (f *ltcFit) eval(l ltcVec3) float64 {
+
+	lOriginal := f.invM.mulVec(l)
+	lLen := lOriginal.len()
+	lOriginal = lOriginal.scale(1 / lLen)
+
+	// Jacobian of going from the original cosine directions to the transformed ones
+	d := max(0, lOriginal[2]) / math.Pi
+	jacobian := f.detM * lLen * lLen * lLen
+
+	return f.magnitude * d / jacobian
+}
+
+func (f *ltcFit) sample(u1, u2 float64) ltcVec3 {
+
+	theta := math.Acos(math.Sqrt(u1))
+	phi := 2 * math.Pi * u2
+
+	sinTheta := math.Sin(theta)
+	l := ltcVec3{sinTheta * math.Cos(phi), sinTheta * math.Sin(phi), math.Cos(theta)}
+
+	return f.m.mulVec(l).normalize()
+}
+
+// ggxEval returns the GGX brdf multiplied by the cosine of the light direction, and the pdf of ggxSample picking l
+func ggxEval(v, l ltcVec3, alpha float64) (val, pdf float64) {
+
+	if v[2] <= 0 {
+		return 0, 0
+	}
+
+	// Masking and shadowing
+	lambdaV := ggxLambda(alpha, v[2])
+	g2 := 0.0
+	if l[2] > 0 {
+		g2 = 1 / (1 + lambdaV + ggxLambda(alpha, l[2]))
+	}
+
+	// Distribution
+	h := v.add(l).normalize()
+	slopeX := h[0] / h[2]
+	slopeY := h[1] / h[2]
+
+	d := 1 / (1 + (slopeX*slopeX+slopeY*slopeY)/alpha/alpha)
+	d = d * d
+	d = d / (math.Pi * alpha * alpha * h[2] * h[2] * h[2] * h[2])
+
+	pdf = math.Abs(d * h[2] / 4 / v.dot(h))
+	val = d * g2 / 4 / v[2]
+	return val, pdf
+}
+
+func ggxLambda(alpha, cosTheta float64) float64 {
+
+	if cosTheta >= 1 {
+		return 0
+	}
+
+	a := 1 / alpha / math.Tan(math.Acos(cosTheta))
+	return 0.5 * (-1 + math.Sqrt(1+1/a/a))
+}
+
+func ggxSample(v ltcVec3, alpha, u1, u2 float64) ltcVec3 {
+
+	phi := 2 * math.Pi * u1
+	r := alpha * math.Sqrt(u2/(1-u2))
+
+	n := ltcVec3{r * math.Cos(phi), r * math.Sin(phi), 1}.normalize()
+	return v.scale(-1).add(n.scale(2 * n.dot(v)))
+}
+
+// fitLtcEntry fits the ltc of roughness index a and view angle index t, starting from the current parameters of fit.
+// The first entry of a row (t=0) is isotropic and keeps the basis of fit, otherwise the basis is set to the brdf's average direction
+func fitLtcEntry(fit *ltcFit, a, t int) {
+
+	const n = LtcLutSize
+
+	roughness := float64(a) / (n - 1)
+	alpha := max(roughness*roughness, ltcMinAlpha)
+
+	x := float64(t) / (n - 1)
+	cosTheta := 1 - x*x
+	theta := min(1.57, math.Acos(cosTheta))
+	v := ltcVec3{math.Sin(theta), 0, math.Cos(theta)}
+
+	avgDir := ltcVec3{}
+	fit.magnitude, fit.fresnel, avgDir = ggxAverageTerms(v, alpha)
+
+	isotropic := t == 0
+	if isotropic {
+		fit.m13 = 0
+	} else {
+		fit.x = ltcVec3{avgDir[2], 0, -avgDir[0]}
+		fit.y = ltcVec3{0, 1, 0}
+		fit.z = avgDir
+	}
+
+	setParams := func(p [3]float64) {
+
+		if isotropic {
+			fit.m11 = max(p[0], ltcMinAlpha)
+			fit.m22 = fit.m11
+			fit.m13 = 0
+		} else {
+			fit.m11 = max(p[0], ltcMinAlpha)
+			fit.m22 = max(p[1], ltcMinAlpha)
+			fit.m13 = p[2]
+		}
+
+		fit.update()
+	}
+
+	start := [3]float64{fit.m11, fit.m22, fit.m13}
+	best := nelderMead3(start, ltcFitStartStep, ltcFitTolerance, ltcFitMaxIters, func(p [3]float64) float64 {
+		setParams(p)
+		return ltcFitError(fit, v, alpha)
+	})
+
+	setParams(best)
+}
+
+// ggxAverageTerms returns the integral of the brdf (its magnitude), the integral with schlick's fresnel weight,
+// and the average light direction projected onto the xz plane
+func ggxAverageTerms(v ltcVec3, alpha float64) (magnitude, fresnel float64, avgDir ltcVec3) {
+
+	const n = ltcFitSamples
+
+	for j := 0; j < n; j++ {
+		for i := 0; i < n; i++ {
+
+			u1 := (float64(i) + 0.5) / n
+			u2 := (float64(j) + 0.5) / n
+
+			l := ggxSample(v, alpha, u1, u2)
+			val, pdf := ggxEval(v, l, alpha)
+			if pdf <= 0 {
+				continue
+			}
+
+			weight := val / pdf
+			h := v.add(l).normalize()
+
+			magnitude += weight
+			fresnel += weight * math.Pow(1-max(v.dot(h), 0), 5)
+			avgDir = avgDir.add(l.scale(weight))
+		}
+	}
+
+	magnitude /= n * n
+	fresnel /= n * n
+
+	avgDir[1] = 0
+	avgDir = avgDir.normalize()
+
+	return magnitude, fresnel, avgDir
+}
+
+// ltcFitError compares the ltc with the brdf, sampling both distributions and weighting with their combined pdfs
+func ltcFitError(fit *ltcFit, v ltcVec3, alpha float64) float64 {
+
+	const n = ltcFitSamples
+
+	sampleErr := func(l ltcVec3) float64 {
+
+		brdfVal, brdfPdf := ggxEval(v, l, alpha)
+		ltcVal := fit.eval(l)
+		ltcPdf := ltcVal / fit.magnitude
+
+		diff := math.Abs(brdfVal - ltcVal)
+		return diff * diff * diff / (ltcPdf + brdfPdf)
+	}
+
+	totalErr := 0.0
+	for j := 0; j < n; j++ {
+		for i := 0; i < n; i++ {
+
+			u1 := (float64(i) + 0.5) / n
+			u2 := (float64(j) + 0.5) / n
+
+			totalErr += sampleErr(fit.sample(u1, u2))
+			totalErr += sampleErr(ggxSample(v, alpha, u1, u2))
+		}
+	}
+
+	return totalErr / (n * n)
+}
+
+// nelderMead3 minimizes f over 3 parameters starting from a simplex around start.
+// Stops after maxIters or once the best and worst values of the simplex are within the relative tolerance
+func nelderMead3(start [3]float64, step, tolerance float64, maxIters int, f func(p [3]float64) float64) [3]float64 {
+
+	const dims = 3
+
+	points := [dims + 1][3]float64{start, start, start, start}
+	for i := 0; i < dims; i++ {
+		points[i+1][i] += step
+	}
+
+	values := [dims + 1]float64{}
+	for i := range points {
+		values[i] = f(points[i])
+	}
+
+	lerp := func(a, b [3]float64, t float64) [3]float64 {
+		return [3]float64{a[0] + (b[0]-a[0])*t, a[1] + (b[1]-a[1])*t, a[2] + (b[2]-a[2])*t}
+	}
+
+	for iter := 0; iter < maxIters; iter++ {
+
+		// Find best, worst and second worst points
+		best, worst := 0, 0
+		for i := range values {
+			if values[i] < values[best] {
+				best = i
+			}
+			if values[i] > values[worst] {
+				worst = i
+			}
+		}
+
+		secondWorst := best
+		for i := range values {
+			if i != worst && values[i] > values[secondWorst] {
+				secondWorst = i
+			}
+		}
+
+		// Relative since errors of smooth and rough lobes are orders of magnitude apart
+		if math.Abs(values[best]-values[worst]) <= tolerance*(math.Abs(values[best])+math.Abs(values[worst])) {
+			break
+		}
+
+		// Centroid of all but the worst point
+		centroid := [3]float64{}
+		for i := range points {
+			if i == worst {
+				continue
+			}
+
+			for d := 0; d < dims; d++ {
+				centroid[d] += points[i][d] / dims
+			}
+		}
+
+		reflected := lerp(centroid, points[worst], -1)
+		reflectedVal := f(reflected)
+
+		if reflectedVal < values[best] {
+
+			expanded := lerp(centroid, points[worst], -2)
+			expandedVal := f(expanded)
+			if expandedVal < reflectedVal {
+				points[worst], values[worst] = expanded, expandedVal
+			} else {
+				points[worst], values[worst] = reflected, reflectedVal
+			}
+
+			continue
+		}
+
+		if reflectedVal < values[secondWorst] {
+			points[worst], values[worst] = reflected, reflectedVal
+			continue
+		}
+
+		// Contract towards the better of the worst and reflected points
+		contracted := lerp(centroid, points[worst], 0.5)
+		if reflectedVal < values[worst] {
+			contracted = lerp(centroid, points[worst], -0.5)
+		}
+
+		contractedVal := f(contracted)
+		if contractedVal < min(values[worst], reflectedVal) {
+			points[worst], values[worst] = contracted, contractedVal
+			continue
+		}
+
+		// Shrink everything towards the best point
+		for i := range points {
+			if i == best {
+				continue
+			}
+
+			points[i] = lerp(points[best], points[i], 0.5)
+			values[i] = f(points[i])
+		}
+	}
+
+	best := 0
+	for i := range values {
+		if values[i] < values[best] {
+			best = i
+		}
+	}
+
+	return points[best]
+}
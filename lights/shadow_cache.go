@@ -0,0 +1,103 @@
+package lights
+
+import "github.com/bloeys/gglm/gglm"
+
+// ShadowUpdateMode decides how often a light's shadow map is drawn
+type ShadowUpdateMode int32
+
+const (
+	ShadowUpdateMode_EveryFrame ShadowUpdateMode = iota
+
+	// ShadowUpdateMode_Interval draws the map every ShadowSettings.UpdateInterval frames, e.g. for far lights where a slow shadow isn't noticed.
+	// Lights that move should be drawn every frame, since between draws their map is from where they were
+	ShadowUpdateMode_Interval
+
+	// ShadowUpdateMode_OnChange draws the map when the light's shadow projection or shadow settings change.
+	// Objects moving in the scene don't redraw it, so it suits lights that only shadow static objects
+	ShadowUpdateMode_OnChange
+
+	// ShadowUpdateMode_Static draws the map once and keeps it until ShadowMapCache.Invalidate is called (e.g. after loading a level)
+	ShadowUpdateMode_Static
+)
+
+func (m ShadowUpdateMode) String() string {
+	switch m {
+	case ShadowUpdateMode_EveryFrame:
+		return "EveryFrame"
+	case ShadowUpdateMode_Interval:
+		return "Interval"
+	case ShadowUpdateMode_OnChange:
+		return "OnChange"
+	case ShadowUpdateMode_Static:
+		return "Static"
+	default:
+		return "Unknown"
+	}
+}
+
+// ShadowMapCache remembers what a light's shadow map was last drawn with, so the light's ShadowUpdateMode can skip drawing it
+type ShadowMapCache struct {
+	isValid         bool
+	framesSinceDraw int32
+	projViewMat     gglm.Mat4
+	settings        ShadowSettings
+
+	// The state of the static casters map of ShadowSettings.CacheStatic
+	isStaticValid     bool
+	staticProjViewMat gglm.Mat4
+	staticSettings    ShadowSettings
+}
+
+// ShouldDraw must be called once per frame, and returns true if the shadow map has to be drawn this frame,
+// in which case the map is assumed drawn. projViewMat is the light's shadow projection (the first face for point lights)
+func (c *ShadowMapCache) ShouldDraw(ss *ShadowSettings, projViewMat *gglm.Mat4) bool {
+
+	c.framesSinceDraw++
+
+	shouldDraw := !c.isValid
+	switch ss.UpdateMode {
+	case ShadowUpdateMode_Interval:
+		shouldDraw = shouldDraw || c.framesSinceDraw >= max(ss.UpdateInterval, 1)
+	case ShadowUpdateMode_OnChange:
+		shouldDraw = shouldDraw || c.projViewMat != *projViewMat || c.settings != *ss
+	case ShadowUpdateMode_Static:
+	default:
+		shouldDraw = true
+	}
+
+	if shouldDraw {
+		c.isValid = true
+		c.framesSinceDraw = 0
+		c.projViewMat = *projViewMat
+		c.settings = *ss
+	}
+
+	return shouldDraw
+}
+
+// ShouldDrawStatic is called when the shadow map is drawn with ShadowSettings.CacheStatic, and returns true if the map of
+// static casters has to be drawn before the dynamic casters are drawn on top, in which case it is assumed drawn.
+// The static map is only drawn when it was invalidated or the light's projection or settings changed
+func (c *ShadowMapCache) ShouldDrawStatic(ss *ShadowSettings, projViewMat *gglm.Mat4) bool {
+
+	if c.isStaticValid && c.staticProjViewMat == *projViewMat && c.staticSettings == *ss {
+		return false
+	}
+
+	c.isStaticValid = true
+	c.staticProjViewMat = *projViewMat
+	c.staticSettings = *ss
+	return true
+}
+
+// ProjViewMat returns the projection the shadow map was last drawn with, which is what lookups into a cached map must use
+func (c *ShadowMapCache) ProjViewMat() *gglm.Mat4 {
+	return &c.projViewMat
+}
+
+// Invalidate makes the next ShouldDraw and ShouldDrawStatic return true, e.g. when the shadow map was reallocated,
+// drawn over by another light, or when static objects were added or removed
+func (c *ShadowMapCache) Invalidate() {
+	c.isValid = false
+	c.isStaticValid = false
+}
@@ -0,0 +1,89 @@
+package lights
+
+// PointShadowLayers assigns the layers of the point light shadow cubemap array to lights.
+//
+// A light keeps its layer for as long as it is visible with shadows enabled, so its cached shadow map stays valid as other
+// lights are added, removed or reordered. Layers of lights that stop being visible are only given away when another light
+// needs one, so a light that comes back soon usually still has its map
+type PointShadowLayers struct {
+	owners [MaxPointLights]*PointLightComp
+
+	// needsDraw marks layers given to a new light, whose map has another light's shadows until it is drawn
+	needsDraw [MaxPointLights]bool
+}
+
+// Layer returns the cubemap array layer of the light, or -1 if it has none
+func (sl *PointShadowLayers) Layer(p *PointLightComp) int32 {
+
+	for i := 0; i < len(sl.owners); i++ {
+		if sl.owners[i] == p {
+			return int32(i)
+		}
+	}
+
+	return -1
+}
+
+// Owner returns the light using layer, or nil if the layer is free
+func (sl *PointShadowLayers) Owner(layer int32) *PointLightComp {
+	return sl.owners[layer]
+}
+
+// NeedsDraw returns true if layer was given to its light since it was last drawn (see MarkDrawn),
+// in which case it must be drawn even if the light's shadow map is cached
+func (sl *PointShadowLayers) NeedsDraw(layer int32) bool {
+	return sl.needsDraw[layer]
+}
+
+// MarkDrawn should be called after the shadow map in layer is drawn
+func (sl *PointShadowLayers) MarkDrawn(layer int32) {
+	sl.needsDraw[layer] = false
+}
+
+// Free frees the layer of the light, if it has one
+func (sl *PointShadowLayers) Free(p *PointLightComp) {
+
+	if layer := sl.Layer(p); layer >= 0 {
+		sl.owners[layer] = nil
+		sl.needsDraw[layer] = false
+	}
+}
+
+// FreeAll frees all layers, e.g. when the cubemap array is reallocated
+func (sl *PointShadowLayers) FreeAll() {
+	clear(sl.owners[:])
+	clear(sl.needsDraw[:])
+}
+
+// update gives a layer to each visible light with shadows enabled that doesn't have one yet, taking free layers first and then
+// layers of lights that are no longer visible or shadowed. There are as many layers as visible point lights, so every such light gets one
+func (sl *PointShadowLayers) update(visible []*PointLightComp) {
+
+	isWanted := [MaxPointLights]bool{}
+	for _, p := range visible {
+		if layer := sl.Layer(p); layer >= 0 && p.Shadow.Enabled {
+			isWanted[layer] = true
+		}
+	}
+
+	for _, p := range visible {
+
+		if !p.Shadow.Enabled || sl.Layer(p) >= 0 {
+			continue
+		}
+
+		layer := sl.Layer(nil)
+		if layer < 0 {
+			for i := 0; i < len(isWanted); i++ {
+				if !isWanted[i] {
+					layer = int32(i)
+					break
+				}
+			}
+		}
+
+		sl.owners[layer] = p
+		sl.needsDraw[layer] = true
+		isWanted[layer] = true
+	}
+}
@@ -0,0 +1,264 @@
+package lights
+
+import (
+	"math"
+	"slices"
+
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/color"
+)
+
+const HoursPerDay = 24
+
+// SkyState is how the lighting and sky look at one hour of the day. TimeOfDay blends between the states around the current hour
+type SkyState struct {
+	// Hour is in [0, 24)
+	Hour float32
+
+	// LightColor is the color of the directional light, which is the sun during the day and the moon at night
+	LightColor   color.Color
+	AmbientColor color.Color
+
+	// SkyTint multiplies the colors of the sky
+	SkyTint  color.Color
+	FogColor color.Color
+}
+
+type timeOfDayHook struct {
+	id   int
+	hour float32
+	fn   func(t *TimeOfDay)
+}
+
+// TimeOfDay is a clock that moves the sun (and the moon, which is opposite to it) and blends between sky states as the day passes.
+//
+// The sun rises at 6, is highest at 12 and sets at 18. While it's below the horizon the directional light follows the moon instead,
+// so night states should have dim light colors to hide the switch
+type TimeOfDay struct {
+	// DayLengthSecs is how many real seconds a full day takes. Zero stops time
+	DayLengthSecs float32
+	Paused        bool
+
+	// SunAzimuthRad rotates the path of the sun around the up axis. At zero the sun rises in +x and sets in -x
+	SunAzimuthRad float32
+
+	// MaxSunElevationRad is how high above the horizon the sun is at noon, where pi/2 is directly overhead
+	MaxSunElevationRad float32
+
+	// States must be sorted by hour, and blending wraps from the last state of the day to the first one
+	States []SkyState
+
+	hour float32
+	days int
+
+	hooks      []timeOfDayHook
+	nextHookId int
+}
+
+// NewTimeOfDay returns a clock starting at hour with a night, dawn, noon and dusk state
+func NewTimeOfDay(hour, dayLengthSecs float32) TimeOfDay {
+
+	t := TimeOfDay{
+		DayLengthSecs:      dayLengthSecs,
+		MaxSunElevationRad: 60 * gglm.Deg2Rad,
+		States: []SkyState{
+			{
+				Hour:         0,
+				LightColor:   color.NewLinear(0.05, 0.07, 0.12),
+				AmbientColor: color.NewLinear(0.01, 0.012, 0.025),
+				SkyTint:      color.NewLinear(0.05, 0.06, 0.12),
+				FogColor:     color.NewLinear(0.02, 0.025, 0.04),
+			},
+			{
+				Hour:         6,
+				LightColor:   color.NewLinear(0.6, 0.3, 0.15),
+				AmbientColor: color.NewLinear(0.05, 0.04, 0.04),
+				SkyTint:      color.NewLinear(0.9, 0.55, 0.4),
+				FogColor:     color.NewLinear(0.45, 0.35, 0.3),
+			},
+			{
+				Hour:         12,
+				LightColor:   color.NewLinear(1, 0.97, 0.9),
+				AmbientColor: color.NewLinear(0.08, 0.08, 0.09),
+				SkyTint:      color.NewLinear(1, 1, 1),
+				FogColor:     color.NewLinear(0.5, 0.55, 0.6),
+			},
+			{
+				Hour:         18,
+				LightColor:   color.NewLinear(0.7, 0.3, 0.1),
+				AmbientColor: color.NewLinear(0.05, 0.035, 0.035),
+				SkyTint:      color.NewLinear(0.95, 0.5, 0.35),
+				FogColor:     color.NewLinear(0.45, 0.3, 0.25),
+			},
+		},
+	}
+
+	t.SetHour(hour)
+	return t
+}
+
+// Update advances the clock by dt real seconds and calls the hooks of every hour that was passed
+func (t *TimeOfDay) Update(dt float32) {
+
+	if t.Paused || t.DayLengthSecs <= 0 {
+		return
+	}
+
+	prevHour := t.hour
+	t.hour += dt / t.DayLengthSecs * HoursPerDay
+
+	for t.hour >= HoursPerDay {
+
+		t.callHooks(prevHour, HoursPerDay)
+
+		prevHour = 0
+		t.hour -= HoursPerDay
+		t.days++
+	}
+
+	t.callHooks(prevHour, t.hour)
+}
+
+// callHooks calls hooks with an hour in (fromHour, toHour]. Hooks at hour 0 are called when the day wraps
+func (t *TimeOfDay) callHooks(fromHour, toHour float32) {
+
+	for i := 0; i < len(t.hooks); i++ {
+
+		h := &t.hooks[i]
+
+		hour := h.hour
+		if hour == 0 {
+			hour = HoursPerDay
+		}
+
+		if hour > fromHour && hour <= toHour {
+			h.fn(t)
+		}
+	}
+}
+
+// Hour returns the time of day in [0, 24)
+func (t *TimeOfDay) Hour() float32 {
+	return t.hour
+}
+
+// SetHour jumps to hour, which is wrapped into [0, 24). Hooks are not called for the skipped hours
+func (t *TimeOfDay) SetHour(hour float32) {
+
+	hour = float32(math.Mod(float64(hour), HoursPerDay))
+	if hour < 0 {
+		hour += HoursPerDay
+	}
+
+	t.hour = hour
+}
+
+// Days returns how many times the clock went past midnight
+func (t *TimeOfDay) Days() int {
+	return t.days
+}
+
+// IsDay reports whether the sun is above the horizon
+func (t *TimeOfDay) IsDay() bool {
+	return t.hour >= 6 && t.hour < 18
+}
+
+// AddHook calls fn every time the clock passes hour, e.g. to turn on street lights at 18. The returned id is used with RemoveHook
+func (t *TimeOfDay) AddHook(hour float32, fn func(t *TimeOfDay)) int {
+
+	t.nextHookId++
+	t.hooks = append(t.hooks, timeOfDayHook{
+		id:   t.nextHookId,
+		hour: float32(math.Mod(float64(hour), HoursPerDay)),
+		fn:   fn,
+	})
+
+	return t.nextHookId
+}
+
+func (t *TimeOfDay) RemoveHook(id int) {
+	t.hooks = slices.DeleteFunc(t.hooks, func(h timeOfDayHook) bool {
+		return h.id == id
+	})
+}
+
+// SunDir returns the normalized direction from the scene towards the sun
+func (t *TimeOfDay) SunDir() gglm.Vec3 {
+
+	// Zero at sunrise, pi/2 at noon and pi at sunset
+	angle := (t.hour - 6) / HoursPerDay * 2 * math.Pi
+
+	across := gglm.Cos32(angle)
+	up := gglm.Sin32(angle)
+
+	// Tilt the path of the sun so it reaches MaxSunElevationRad at noon
+	dir := gglm.NewVec3(across, up*gglm.Sin32(t.MaxSunElevationRad), up*gglm.Cos32(t.MaxSunElevationRad))
+
+	cosAz := gglm.Cos32(t.SunAzimuthRad)
+	sinAz := gglm.Sin32(t.SunAzimuthRad)
+	dir.SetXYZ(dir.X()*cosAz+dir.Z()*sinAz, dir.Y(), -dir.X()*sinAz+dir.Z()*cosAz)
+
+	return dir
+}
+
+// LightDir returns the direction of the directional light, which comes from the sun during the day and from the moon at night
+func (t *TimeOfDay) LightDir() gglm.Vec3 {
+
+	sunDir := t.SunDir()
+	if t.IsDay() {
+		return *sunDir.Scale(-1)
+	}
+
+	return sunDir
+}
+
+// State returns the blend of the states around the current hour
+func (t *TimeOfDay) State() SkyState {
+
+	if len(t.States) == 0 {
+		return SkyState{Hour: t.hour}
+	}
+
+	// Find the last state at or before the current hour, wrapping to the last state of the previous day
+	prevIndex := len(t.States) - 1
+	for i := 0; i < len(t.States); i++ {
+		if t.States[i].Hour <= t.hour {
+			prevIndex = i
+		}
+	}
+
+	nextIndex := (prevIndex + 1) % len(t.States)
+	prev := &t.States[prevIndex]
+	next := &t.States[nextIndex]
+
+	hoursBetween := next.Hour - prev.Hour
+	if hoursBetween <= 0 {
+		hoursBetween += HoursPerDay
+	}
+
+	hoursSincePrev := t.hour - prev.Hour
+	if hoursSincePrev < 0 {
+		hoursSincePrev += HoursPerDay
+	}
+
+	blend := gglm.Clamp(hoursSincePrev/hoursBetween, 0, 1)
+	return SkyState{
+		Hour:         t.hour,
+		LightColor:   color.Lerp(&prev.LightColor, &next.LightColor, blend),
+		AmbientColor: color.Lerp(&prev.AmbientColor, &next.AmbientColor, blend),
+		SkyTint:      color.Lerp(&prev.SkyTint, &next.SkyTint, blend),
+		FogColor:     color.Lerp(&prev.FogColor, &next.FogColor, blend),
+	}
+}
+
+// Apply moves the directional light and sets its colors and the ambient color of the light manager from the current state.
+// The sky and fog colors are left to the caller since they aren't part of the lights
+func (t *TimeOfDay) Apply(dirLight *DirLight, lm *LightManager) {
+
+	state := t.State()
+
+	dirLight.Dir = t.LightDir()
+	dirLight.DiffuseColor = state.LightColor
+	dirLight.SpecularColor = state.LightColor
+	lm.AmbientColor = state.AmbientColor
+}
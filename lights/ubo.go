@@ -0,0 +1,153 @@
+package lights
+
+import (
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/color"
+)
+
+// LightsUboData is the data of the 'Lights' uniform block of the lit shaders
+type LightsUboData struct {
+	DirLight     DirLightUboData
+	PointLights  [MaxPointLights]PointLightUboData
+	SpotLights   [MaxSpotLights]SpotLightUboData
+	AreaLights   [MaxAreaLights]AreaLightUboData
+	AmbientColor color.Color `ubo:"type=vec3"`
+}
+
+type ShadowUboData struct {
+	Enabled      int32
+	BiasConstant float32
+	BiasSlope    float32
+	NormalOffset float32
+	PcfRadius    int32
+	NearPlane    float32
+	FarPlane     float32
+}
+
+type DirLightUboData struct {
+	Dir           gglm.Vec3
+	DiffuseColor  color.Color `ubo:"type=vec3"`
+	SpecularColor color.Color `ubo:"type=vec3"`
+	Shadow        ShadowUboData
+
+	// CullingMask is the light's camera.LayerMask with zero turned into all layers, which the shader
+	// compares with the layers of the object (see renderer.PerObjectUboData.Layers)
+	CullingMask uint32
+}
+
+type PointLightUboData struct {
+	Pos           gglm.Vec3
+	DiffuseColor  color.Color `ubo:"type=vec3"`
+	SpecularColor color.Color `ubo:"type=vec3"`
+	Radius        float32
+	Falloff       float32
+	Shadow        ShadowUboData
+
+	// ShadowLayer is the layer of the light in the point light shadow cubemap array, or -1 if it has none
+	ShadowLayer int32
+	CullingMask uint32
+}
+
+type SpotLightUboData struct {
+	Pos           gglm.Vec3
+	Dir           gglm.Vec3
+	DiffuseColor  color.Color `ubo:"type=vec3"`
+	SpecularColor color.Color `ubo:"type=vec3"`
+	InnerCutoff   float32
+	OuterCutoff   float32
+	Shadow        ShadowUboData
+	HasCookie     int32
+	CullingMask   uint32
+}
+
+// AreaLightUboData has the half extents of the rectangle in HalfRight and HalfUp,
+// so the shader gets the corners with Pos +- HalfRight +- HalfUp
+type AreaLightUboData struct {
+	Pos           gglm.Vec3
+	HalfRight     gglm.Vec3
+	HalfUp        gglm.Vec3
+	DiffuseColor  color.Color `ubo:"type=vec3"`
+	SpecularColor color.Color `ubo:"type=vec3"`
+	TwoSided      int32
+	CullingMask   uint32
+}
+
+func (s *ShadowSettings) ToUboData() ShadowUboData {
+
+	enabled := int32(0)
+	if s.Enabled {
+		enabled = 1
+	}
+
+	return ShadowUboData{
+		Enabled:      enabled,
+		BiasConstant: s.BiasConstant,
+		BiasSlope:    s.BiasSlope,
+		NormalOffset: s.NormalOffset,
+		PcfRadius:    s.PcfRadius,
+		NearPlane:    s.NearPlane,
+		FarPlane:     s.FarPlane,
+	}
+}
+
+func (d *DirLight) ToUboData() DirLightUboData {
+	return DirLightUboData{
+		Dir:           d.Dir,
+		DiffuseColor:  d.DiffuseColor,
+		SpecularColor: d.SpecularColor,
+		Shadow:        d.Shadow.ToUboData(),
+		CullingMask:   uint32(d.CullingMask.AsCullingMask()),
+	}
+}
+
+func (p *PointLight) ToUboData() PointLightUboData {
+	return PointLightUboData{
+		Pos:           p.Pos,
+		DiffuseColor:  p.DiffuseColor,
+		SpecularColor: p.SpecularColor,
+		Radius:        p.Radius,
+		Falloff:       p.Falloff,
+		Shadow:        p.Shadow.ToUboData(),
+		ShadowLayer:   -1,
+		CullingMask:   uint32(p.CullingMask.AsCullingMask()),
+	}
+}
+
+func (s *SpotLight) ToUboData() SpotLightUboData {
+
+	hasCookie := int32(0)
+	if s.CookieTex != 0 {
+		hasCookie = 1
+	}
+
+	// Shader needs cos values
+	return SpotLightUboData{
+		Pos:           s.Pos,
+		Dir:           s.Dir,
+		DiffuseColor:  s.DiffuseColor,
+		SpecularColor: s.SpecularColor,
+		InnerCutoff:   s.InnerCutoffCos(),
+		OuterCutoff:   s.OuterCutoffCos(),
+		Shadow:        s.Shadow.ToUboData(),
+		HasCookie:     hasCookie,
+		CullingMask:   uint32(s.CullingMask.AsCullingMask()),
+	}
+}
+
+func (a *AreaLight) ToUboData() AreaLightUboData {
+
+	twoSided := int32(0)
+	if a.TwoSided {
+		twoSided = 1
+	}
+
+	return AreaLightUboData{
+		Pos:           a.Pos,
+		HalfRight:     *a.Right.Clone().Scale(a.Width * 0.5),
+		HalfUp:        *a.Up.Clone().Scale(a.Height * 0.5),
+		DiffuseColor:  a.DiffuseColor,
+		SpecularColor: a.SpecularColor,
+		TwoSided:      twoSided,
+		CullingMask:   uint32(a.CullingMask.AsCullingMask()),
+	}
+}
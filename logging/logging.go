@@ -1,19 +1,227 @@
 package logging
 
 import (
+	"fmt"
 	"log"
-	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Level is the severity of a message. The zero value is Level_Info
+type Level int32
+
+const (
+	Level_Debug Level = iota - 1
+	Level_Info
+	Level_Warn
+	Level_Err
+)
+
+func (l Level) String() string {
+	switch l {
+	case Level_Debug:
+		return "debug"
+	case Level_Info:
+		return "info"
+	case Level_Warn:
+		return "warn"
+	case Level_Err:
+		return "err"
+	default:
+		return "unknown"
+	}
+}
+
 var (
+	// InfoLog, WarnLog and ErrLog are kept for compatibility with the standard log API.
+	// Their messages go through the same level filtering and sinks as Info/Warn/Error, but without fields.
+	// Fatal and Panic calls still exit/panic even if the level is filtered out
 	InfoLog *log.Logger
 	WarnLog *log.Logger
 	ErrLog  *log.Logger
+
+	level atomic.Int32
+
+	sinksMutex sync.RWMutex
+	sinks      []Sink
 )
 
 func init() {
 
-	InfoLog = log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
-	WarnLog = log.New(os.Stdout, "WARN: ", log.Ldate|log.Ltime|log.Lshortfile)
-	ErrLog = log.New(os.Stderr, "Err: ", log.Ldate|log.Ltime|log.Lshortfile)
+	level.Store(int32(Level_Info))
+	sinks = []Sink{NewConsoleSink()}
+
+	InfoLog = log.New(&shimWriter{level: Level_Info}, "", log.Lshortfile)
+	WarnLog = log.New(&shimWriter{level: Level_Warn}, "", log.Lshortfile)
+	ErrLog = log.New(&shimWriter{level: Level_Err}, "", log.Lshortfile)
+}
+
+// SetLevel filters out messages below the given level. Safe to call at any time from any goroutine
+func SetLevel(l Level) {
+	level.Store(int32(l))
+}
+
+func GetLevel() Level {
+	return Level(level.Load())
+}
+
+func IsLevelEnabled(l Level) bool {
+	return l >= GetLevel()
+}
+
+// ParseLevel converts level names (e.g. from config files) like 'debug', 'info', 'warn' and 'err' to a level
+func ParseLevel(s string) (Level, error) {
+
+	switch strings.ToLower(s) {
+	case "debug":
+		return Level_Debug, nil
+	case "info":
+		return Level_Info, nil
+	case "warn", "warning":
+		return Level_Warn, nil
+	case "err", "error":
+		return Level_Err, nil
+	default:
+		return Level_Info, fmt.Errorf("unknown log level '%s'. Valid levels are: debug, info, warn, err", s)
+	}
+}
+
+// AddSink makes the sink receive all messages that pass level filtering
+func AddSink(s Sink) {
+
+	sinksMutex.Lock()
+	sinks = append(sinks, s)
+	sinksMutex.Unlock()
+}
+
+// RemoveSink stops sending messages to the sink. Does not close it
+func RemoveSink(s Sink) {
+
+	sinksMutex.Lock()
+	defer sinksMutex.Unlock()
+
+	for i := 0; i < len(sinks); i++ {
+
+		if sinks[i] != s {
+			continue
+		}
+
+		sinks = append(sinks[:i], sinks[i+1:]...)
+		return
+	}
+}
+
+// Debug logs a message with optional key/value pairs, like: logging.Debug("Loaded texture", "path", path, "ms", ms)
+func Debug(msg string, keyVals ...any) {
+	logWithCaller(Level_Debug, msg, keyVals)
+}
+
+// Info logs a message with optional key/value pairs, like: logging.Info("Loaded texture", "path", path, "ms", ms)
+func Info(msg string, keyVals ...any) {
+	logWithCaller(Level_Info, msg, keyVals)
+}
+
+// Warn logs a message with optional key/value pairs, like: logging.Warn("Texture too large", "path", path, "width", w)
+func Warn(msg string, keyVals ...any) {
+	logWithCaller(Level_Warn, msg, keyVals)
+}
+
+// Error logs a message with optional key/value pairs, like: logging.Error("Failed to load texture", "path", path, "err", err)
+func Error(msg string, keyVals ...any) {
+	logWithCaller(Level_Err, msg, keyVals)
+}
+
+func logWithCaller(l Level, msg string, keyVals []any) {
+
+	if !IsLevelEnabled(l) {
+		return
+	}
+
+	caller := ""
+	// Skip logWithCaller and the Debug/Info/Warn/Error function
+	_, file, line, ok := runtime.Caller(2)
+	if ok {
+		caller = filepath.Base(file) + ":" + strconv.Itoa(line)
+	}
+
+	dispatch(&Entry{
+		Time:   time.Now(),
+		Level:  l,
+		Caller: caller,
+		Msg:    msg,
+		Fields: fieldsFromKeyVals(keyVals),
+	})
+}
+
+func fieldsFromKeyVals(keyVals []any) []Field {
+
+	if len(keyVals) == 0 {
+		return nil
+	}
+
+	fields := make([]Field, 0, (len(keyVals)+1)/2)
+	for i := 0; i < len(keyVals); i += 2 {
+
+		// A missing value is kept rather than dropped so the mistake is visible in the logs
+		if i+1 >= len(keyVals) {
+			fields = append(fields, Field{Key: "!BADKEY", Val: keyVals[i]})
+			break
+		}
+
+		key, ok := keyVals[i].(string)
+		if !ok {
+			key = fmt.Sprint(keyVals[i])
+		}
+
+		fields = append(fields, Field{Key: key, Val: keyVals[i+1]})
+	}
+
+	return fields
+}
+
+func dispatch(e *Entry) {
+
+	// Kept outside the sink list so crash reports always have logs even if sinks are removed
+	recentLogs.Write(e)
+
+	sinksMutex.RLock()
+	for i := 0; i < len(sinks); i++ {
+		sinks[i].Write(e)
+	}
+	sinksMutex.RUnlock()
+}
+
+// shimWriter turns the output of a standard log.Logger (with only the Lshortfile flag) into entries
+type shimWriter struct {
+	level Level
+}
+
+func (w *shimWriter) Write(p []byte) (n int, err error) {
+
+	if !IsLevelEnabled(w.level) {
+		return len(p), nil
+	}
+
+	// Output looks like 'file.go:12: msg\n'
+	msg := strings.TrimSuffix(string(p), "\n")
+	caller, rest, found := strings.Cut(msg, ": ")
+	if found {
+		msg = rest
+	} else {
+		caller = ""
+	}
+
+	dispatch(&Entry{
+		Time:   time.Now(),
+		Level:  w.level,
+		Caller: caller,
+		Msg:    msg,
+	})
+
+	return len(p), nil
 }
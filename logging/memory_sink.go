@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"sync"
+)
+
+const (
+	// RecentLogsCapacity is how many log entries are kept in memory for crash reports
+	RecentLogsCapacity = 256
+)
+
+var _ Sink = &MemorySink{}
+
+// MemorySink keeps the latest entries in a ring buffer. Useful for in-game consoles and crash reports
+type MemorySink struct {
+	mutex   sync.Mutex
+	entries []Entry
+	next    int
+	isFull  bool
+
+	// version changes every write, so consoles can tell if there are new entries
+	version uint64
+}
+
+func (s *MemorySink) Write(e *Entry) {
+
+	s.mutex.Lock()
+
+	s.entries[s.next] = *e
+	s.next++
+	if s.next == len(s.entries) {
+		s.next = 0
+		s.isFull = true
+	}
+	s.version++
+
+	s.mutex.Unlock()
+}
+
+// Entries returns a copy of the stored entries, oldest first
+func (s *MemorySink) Entries() []Entry {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.isFull {
+		out := make([]Entry, s.next)
+		copy(out, s.entries[:s.next])
+		return out
+	}
+
+	out := make([]Entry, 0, len(s.entries))
+	out = append(out, s.entries[s.next:]...)
+	out = append(out, s.entries[:s.next]...)
+	return out
+}
+
+// Version is incremented on every write
+func (s *MemorySink) Version() uint64 {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.version
+}
+
+func (s *MemorySink) Clear() {
+
+	s.mutex.Lock()
+
+	clear(s.entries)
+	s.next = 0
+	s.isFull = false
+	s.version++
+
+	s.mutex.Unlock()
+}
+
+func NewMemorySink(capacity int) *MemorySink {
+	return &MemorySink{
+		entries: make([]Entry, capacity),
+	}
+}
+
+var (
+	recentLogs = NewMemorySink(RecentLogsCapacity)
+)
+
+// RecentLogs returns up to RecentLogsCapacity of the latest formatted log entries, oldest first.
+// Entries filtered out by SetLevel are not included
+func RecentLogs() []string {
+
+	entries := recentLogs.Entries()
+
+	out := make([]string, len(entries))
+	for i := 0; i < len(entries); i++ {
+		out[i] = entries[i].String()
+	}
+
+	return out
+}
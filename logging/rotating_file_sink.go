@@ -0,0 +1,207 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type RotatingFileSinkOptions struct {
+	// MaxSizeBytes rotates the file once it grows past this size. Zero means no size limit
+	MaxSizeBytes int64
+
+	// MaxAge rotates the file once it has been open this long. Zero means no time limit
+	MaxAge time.Duration
+
+	// MaxBackups is how many rotated files are kept, with the oldest deleted first. Zero keeps all of them
+	MaxBackups int
+}
+
+var _ Sink = &RotatingFileSink{}
+
+// RotatingFileSink appends formatted entries to a file, and when the file gets too big or too old
+// it is renamed to 'name.<timestamp>.ext' and a new file is started
+type RotatingFileSink struct {
+	mutex sync.Mutex
+
+	path     string
+	opts     RotatingFileSinkOptions
+	file     *os.File
+	size     int64
+	openTime time.Time
+}
+
+func (s *RotatingFileSink) Write(e *Entry) {
+
+	line := []byte(e.String() + "\n")
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file == nil {
+		return
+	}
+
+	if s.shouldRotate(int64(len(line))) {
+
+		// We can't log errors from inside a sink, so they go straight to stderr
+		err := s.rotate()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			if s.file == nil {
+				return
+			}
+		}
+	}
+
+	n, _ := s.file.Write(line)
+	s.size += int64(n)
+}
+
+func (s *RotatingFileSink) shouldRotate(nextWriteSize int64) bool {
+
+	// Never rotate an empty file, otherwise one huge entry would rotate on every write
+	if s.size == 0 {
+		return false
+	}
+
+	if s.opts.MaxSizeBytes > 0 && s.size+nextWriteSize > s.opts.MaxSizeBytes {
+		return true
+	}
+
+	if s.opts.MaxAge > 0 && time.Since(s.openTime) >= s.opts.MaxAge {
+		return true
+	}
+
+	return false
+}
+
+func (s *RotatingFileSink) rotate() error {
+
+	err := s.file.Close()
+	s.file = nil
+	if err != nil {
+		return fmt.Errorf("failed to close log file '%s' for rotation. Err: %w", s.path, err)
+	}
+
+	ext := filepath.Ext(s.path)
+	base := strings.TrimSuffix(s.path, ext)
+	backupPath := base + "." + time.Now().Format("2006-01-02_15-04-05.000") + ext
+
+	// Even if the rename fails we still reopen, so logging continues in the old file
+	renameErr := os.Rename(s.path, backupPath)
+
+	err = s.open()
+	if err != nil {
+		return err
+	}
+
+	if renameErr != nil {
+		return fmt.Errorf("failed to rotate log file '%s'. Err: %w", s.path, renameErr)
+	}
+
+	return s.removeOldBackups()
+}
+
+func (s *RotatingFileSink) open() error {
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file '%s'. Err: %w", s.path, err)
+	}
+
+	fileInfo, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat log file '%s'. Err: %w", s.path, err)
+	}
+
+	s.file = f
+	s.size = fileInfo.Size()
+	s.openTime = time.Now()
+	return nil
+}
+
+// Backups returns the paths of the rotated files, oldest first
+func (s *RotatingFileSink) Backups() ([]string, error) {
+
+	dir := filepath.Dir(s.path)
+	ext := filepath.Ext(s.path)
+	prefix := strings.TrimSuffix(filepath.Base(s.path), ext) + "."
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups of log file '%s'. Err: %w", s.path, err)
+	}
+
+	backups := make([]string, 0)
+	for i := 0; i < len(dirEntries); i++ {
+
+		name := dirEntries[i].Name()
+		if dirEntries[i].IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) || len(name) <= len(prefix)+len(ext) {
+			continue
+		}
+
+		backups = append(backups, filepath.Join(dir, name))
+	}
+
+	// Timestamps are formatted so that name order is also time order
+	sort.Strings(backups)
+	return backups, nil
+}
+
+func (s *RotatingFileSink) removeOldBackups() error {
+
+	if s.opts.MaxBackups <= 0 {
+		return nil
+	}
+
+	backups, err := s.Backups()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(backups)-s.opts.MaxBackups; i++ {
+
+		err = os.Remove(backups[i])
+		if err != nil {
+			return fmt.Errorf("failed to remove old log file '%s'. Err: %w", backups[i], err)
+		}
+	}
+
+	return nil
+}
+
+func (s *RotatingFileSink) Close() error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// NewRotatingFileSink opens the file for appending, creating it if needed. Remember to RemoveSink and Close it when done
+func NewRotatingFileSink(path string, opts RotatingFileSinkOptions) (*RotatingFileSink, error) {
+
+	s := &RotatingFileSink{
+		path: path,
+		opts: opts,
+	}
+
+	err := s.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
@@ -0,0 +1,165 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Field struct {
+	Key string
+	Val any
+}
+
+type Entry struct {
+	Time  time.Time
+	Level Level
+
+	// Caller is 'file.go:line' of the log call. Can be empty
+	Caller string
+	Msg    string
+	Fields []Field
+}
+
+// String formats the entry like: '2024/01/02 15:04:05 INFO main.go:12: Loaded texture path=tex.png ms=3'
+func (e *Entry) String() string {
+
+	sb := strings.Builder{}
+	sb.WriteString(e.Time.Format("2006/01/02 15:04:05 "))
+	sb.WriteString(strings.ToUpper(e.Level.String()))
+	sb.WriteByte(' ')
+	e.writeBody(&sb)
+
+	return sb.String()
+}
+
+// writeBody writes the caller, message and fields of the entry
+func (e *Entry) writeBody(sb *strings.Builder) {
+
+	if e.Caller != "" {
+		sb.WriteString(e.Caller)
+		sb.WriteString(": ")
+	}
+
+	sb.WriteString(e.Msg)
+
+	for i := 0; i < len(e.Fields); i++ {
+
+		sb.WriteByte(' ')
+		sb.WriteString(e.Fields[i].Key)
+		sb.WriteByte('=')
+
+		val := fmt.Sprint(e.Fields[i].Val)
+		if val == "" || strings.ContainsAny(val, " =\"\n\t") {
+			val = strconv.Quote(val)
+		}
+		sb.WriteString(val)
+	}
+}
+
+// Sink receives log entries that pass level filtering. Sinks are called from whatever goroutine logged,
+// so they must be safe for concurrent use. Entries must not be kept after Write returns, but copies can be.
+//
+// Sinks are compared when removed, so they should be pointers
+type Sink interface {
+	Write(e *Entry)
+}
+
+var _ Sink = &WriterSink{}
+
+// WriterSink writes one formatted line per entry to an io.Writer (e.g. os.Stderr)
+type WriterSink struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (s *WriterSink) Write(e *Entry) {
+
+	line := e.String() + "\n"
+
+	s.mutex.Lock()
+	s.w.Write([]byte(line))
+	s.mutex.Unlock()
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{
+		w: w,
+	}
+}
+
+var _ Sink = &ConsoleSink{}
+
+// ConsoleSink is the default sink. It writes errors to stderr and everything else to stdout, in the format of the
+// standard loggers the engine used before sinks, like: 'INFO: 2024/01/02 15:04:05 main.go:12: Loaded texture path=tex.png'
+type ConsoleSink struct {
+	mutex sync.Mutex
+}
+
+func (s *ConsoleSink) Write(e *Entry) {
+
+	sb := strings.Builder{}
+	switch e.Level {
+	case Level_Debug:
+		sb.WriteString("DEBUG: ")
+	case Level_Info:
+		sb.WriteString("INFO: ")
+	case Level_Warn:
+		sb.WriteString("WARN: ")
+	default:
+		sb.WriteString("Err: ")
+	}
+
+	sb.WriteString(e.Time.Format("2006/01/02 15:04:05 "))
+	e.writeBody(&sb)
+	sb.WriteByte('\n')
+
+	w := os.Stdout
+	if e.Level >= Level_Err {
+		w = os.Stderr
+	}
+
+	s.mutex.Lock()
+	w.WriteString(sb.String())
+	s.mutex.Unlock()
+}
+
+func NewConsoleSink() *ConsoleSink {
+	return &ConsoleSink{}
+}
+
+var _ Sink = &FileSink{}
+
+// FileSink appends formatted entries to a file
+type FileSink struct {
+	WriterSink
+	file *os.File
+}
+
+func (s *FileSink) Close() error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.file.Close()
+}
+
+// NewFileSink opens the file for appending, creating it if needed. Remember to RemoveSink and Close it when done
+func NewFileSink(path string) (*FileSink, error) {
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file '%s'. Err: %w", path, err)
+	}
+
+	return &FileSink{
+		WriterSink: WriterSink{
+			w: f,
+		},
+		file: f,
+	}, nil
+}
@@ -1,25 +1,41 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"image"
+	imgColor "image/color"
 	"os"
-	"runtime"
 	"runtime/pprof"
 	"strconv"
+	"time"
 
 	imgui "github.com/AllenDang/cimgui-go"
 	"github.com/bloeys/gglm/gglm"
 	"github.com/bloeys/nmage/assert"
 	"github.com/bloeys/nmage/assets"
+	"github.com/bloeys/nmage/audio"
 	"github.com/bloeys/nmage/buffers"
 	"github.com/bloeys/nmage/camera"
+	"github.com/bloeys/nmage/color"
+	"github.com/bloeys/nmage/editor"
 	"github.com/bloeys/nmage/engine"
+	"github.com/bloeys/nmage/entity"
+	"github.com/bloeys/nmage/ibl"
 	"github.com/bloeys/nmage/input"
+	"github.com/bloeys/nmage/leakcheck"
+	"github.com/bloeys/nmage/lights"
 	"github.com/bloeys/nmage/logging"
 	"github.com/bloeys/nmage/materials"
 	"github.com/bloeys/nmage/meshes"
+	"github.com/bloeys/nmage/postprocess"
+	"github.com/bloeys/nmage/registry"
+	"github.com/bloeys/nmage/renderer"
 	"github.com/bloeys/nmage/renderer/rend3dgl"
+	"github.com/bloeys/nmage/rng"
+	"github.com/bloeys/nmage/shaders"
 	"github.com/bloeys/nmage/timing"
+	"github.com/bloeys/nmage/ui/gameui"
 	nmageimgui "github.com/bloeys/nmage/ui/imgui"
 	"github.com/go-gl/gl/v4.1-core/gl"
 	"github.com/veandco/go-sdl2/sdl"
@@ -53,231 +69,65 @@ import (
 	- Material system editor with fields automatically extracted from the shader
 */
 
-type DirLight struct {
-	Dir           gglm.Vec3
-	DiffuseColor  gglm.Vec3
-	SpecularColor gglm.Vec3
-}
-
 var (
 	renderDirLightShadows   = true
 	renderPointLightShadows = true
 	renderSpotLightShadows  = true
 
-	dirLightSize float32 = 30
-	dirLightNear float32 = 0.1
-	dirLightFar  float32 = 30
-	dirLightPos          = gglm.NewVec3(0, 10, 0)
-
 	pointLightRadiusToFarPlaneRatio float32 = 1.25
 )
 
-func (d *DirLight) GetProjViewMat() gglm.Mat4 {
-
-	// Some arbitrary position for the directional light
-	pos := dirLightPos
-
-	size := dirLightSize
-	nearClip := dirLightNear
-	farClip := dirLightFar
-
-	up := gglm.NewVec3(0, 1, 0)
-	projMat := gglm.Ortho(-size, size, -size, size, nearClip, farClip).Mat4
-	viewMat := gglm.LookAtRH(&pos, pos.Clone().Add(&d.Dir), &up).Mat4
-
-	return *projMat.Mul(&viewMat)
-}
-
-// Based on: https://lisyarus.github.io/blog/posts/point-light-attenuation.html
-type PointLight struct {
-	Pos           gglm.Vec3
-	DiffuseColor  gglm.Vec3
-	SpecularColor gglm.Vec3
-
-	Radius  float32
-	Falloff float32
-
-	// MaxBias is the max shadow bias applied for this light.
-	// A usual value is 0.05
-	MaxBias float32
-
-	// NearPlane is the distance where if the pixel
-	// is closer to the light than this distance, no shadow will be casted.
-	//
-	// This helps not produce shadows from within objects.
-	// Same idea a camera near plane.
-	NearPlane float32
-
-	// Far plane is the max distance at which shadows from this
-	// light will show.
-	//
-	// This should be a bit bigger than the radius, as an object
-	// at the edge of the radius should still cast a shadow, and
-	// so this shadow will be further than the radius.
-	//
-	// Something like 'FarPlane=Radius*1.25' might work.
-	FarPlane float32
-}
-
-const (
-	MaxPointLights = 8
-
-	// If this changes update the array depth map shader
-	MaxSpotLights = 4
-)
-
-var (
-	pointLightNear float32 = 1
-)
-
-func (p *PointLight) GetProjViewMats(shadowMapWidth, shadowMapHeight float32) [6]gglm.Mat4 {
-
-	aspect := float32(shadowMapWidth) / float32(shadowMapHeight)
-	projMat := gglm.Perspective(90*gglm.Deg2Rad, aspect, pointLightNear, p.FarPlane)
-
-	targetPos0 := gglm.NewVec3(1+p.Pos.X(), p.Pos.Y(), p.Pos.Z())
-	targetPos1 := gglm.NewVec3(-1+p.Pos.X(), p.Pos.Y(), p.Pos.Z())
-	targetPos2 := gglm.NewVec3(p.Pos.X(), 1+p.Pos.Y(), p.Pos.Z())
-	targetPos3 := gglm.NewVec3(p.Pos.X(), -1+p.Pos.Y(), p.Pos.Z())
-	targetPos4 := gglm.NewVec3(p.Pos.X(), p.Pos.Y(), 1+p.Pos.Z())
-	targetPos5 := gglm.NewVec3(p.Pos.X(), p.Pos.Y(), -1+p.Pos.Z())
-
-	worldUp0 := gglm.NewVec3(0, -1, 0)
-	worldUp1 := gglm.NewVec3(0, -1, 0)
-	worldUp2 := gglm.NewVec3(0, 0, 1)
-	worldUp3 := gglm.NewVec3(0, 0, -1)
-	worldUp4 := gglm.NewVec3(0, -1, 0)
-	worldUp5 := gglm.NewVec3(0, -1, 0)
-
-	lookAt0 := gglm.LookAtRH(&p.Pos, &targetPos0, &worldUp0)
-	lookAt1 := gglm.LookAtRH(&p.Pos, &targetPos1, &worldUp1)
-	lookAt2 := gglm.LookAtRH(&p.Pos, &targetPos2, &worldUp2)
-	lookAt3 := gglm.LookAtRH(&p.Pos, &targetPos3, &worldUp3)
-	lookAt4 := gglm.LookAtRH(&p.Pos, &targetPos4, &worldUp4)
-	lookAt5 := gglm.LookAtRH(&p.Pos, &targetPos5, &worldUp5)
-
-	projViewMats := [6]gglm.Mat4{
-		*projMat.Clone().Mul(&lookAt0.Mat4),
-		*projMat.Clone().Mul(&lookAt1.Mat4),
-		*projMat.Clone().Mul(&lookAt2.Mat4),
-		*projMat.Clone().Mul(&lookAt3.Mat4),
-		*projMat.Clone().Mul(&lookAt4.Mat4),
-		*projMat.Clone().Mul(&lookAt5.Mat4),
-	}
-
-	return projViewMats
-}
-
-type SpotLight struct {
-	Pos            gglm.Vec3
-	Dir            gglm.Vec3
-	DiffuseColor   gglm.Vec3
-	SpecularColor  gglm.Vec3
-	InnerCutoffRad float32
-	OuterCutoffRad float32
-
-	// Near plane like 0.x (or anything too small) causes shadows to not work properly.
-	// Needs adjusting as the distance of light to object increases
-	NearPlane float32
-
-	FarPlane float32
-}
-
-func (s *SpotLight) GetProjViewMat() gglm.Mat4 {
-
-	projMat := gglm.Perspective(s.OuterCutoffRad*2, 1, s.NearPlane, s.FarPlane)
-
-	// Adjust up vector if lightDir is parallel or nearly parallel to upVector
-	// as lookat view matrix breaks if up and look at are parallel
-	up := gglm.NewVec3(0, 1, 0)
-	if gglm.Abs32(gglm.DotVec3(&s.Dir, &up)) > 0.99 {
-		up.SetXY(1, 0)
-	}
-
-	viewMat := gglm.LookAtRH(&s.Pos, s.Pos.Clone().Add(&s.Dir), &up).Mat4
-
-	return *projMat.Mul(&viewMat)
-}
-
-func (s *SpotLight) InnerCutoffCos() float32 {
-	return gglm.Cos32(s.InnerCutoffRad)
-}
-
-func (s *SpotLight) OuterCutoffCos() float32 {
-	return gglm.Cos32(s.OuterCutoffRad)
-}
-
 type GlobalMatricesUboData struct {
 	CamPos      gglm.Vec3
 	ProjViewMat gglm.Mat4
 }
 
-type DirLightUboData struct {
-	Dir           gglm.Vec3
-	DiffuseColor  gglm.Vec3
-	SpecularColor gglm.Vec3
-}
-
-type PointLightUboData struct {
-	Pos           gglm.Vec3
-	DiffuseColor  gglm.Vec3
-	SpecularColor gglm.Vec3
-	Radius        float32
-	Falloff       float32
-	MaxBias       float32
-	NearPlane     float32
-	FarPlane      float32
-}
-
-type SpotLightUboData struct {
-	Pos           gglm.Vec3
-	Dir           gglm.Vec3
-	DiffuseColor  gglm.Vec3
-	SpecularColor gglm.Vec3
-	InnerCutoff   float32
-	OuterCutoff   float32
-}
-
-type LightsUboData struct {
-	DirLight     DirLightUboData
-	PointLights  [POINT_LIGHT_COUNT]PointLightUboData
-	SpotLights   [SPOT_LIGHT_COUNT]SpotLightUboData
-	AmbientColor gglm.Vec3
-}
-
 const (
+	// lightProbeCaptureSize is the width and height of each captured cubemap face. Probes only keep low frequency light,
+	// so tiny faces are enough
+	lightProbeCaptureSize = 16
 
-	// These must match the shader values
-	POINT_LIGHT_COUNT = 8
-	SPOT_LIGHT_COUNT  = 4
-
-	UNSCALED_WINDOW_WIDTH  = 1280
-	UNSCALED_WINDOW_HEIGHT = 720
+	// pbrSpheresPerRow is the number of spheres in each row of the PBR demo, with roughness going from smooth to rough along a row
+	pbrSpheresPerRow = 5
 
 	PROFILE_CPU = false
 	PROFILE_MEM = false
-
-	FRAME_TIME_MS_SAMPLES = 10000
 )
 
 var (
 	globalMatricesUboData GlobalMatricesUboData
 	globalMatricesUbo     buffers.UniformBuffer
 
-	lightsUboData LightsUboData
-	lightsUbo     buffers.UniformBuffer
+	lightManager lights.LightManager
+	lightsUbo    buffers.UniformBuffer
+
+	perFrameUboRing buffers.UniformRingBuffer
+
+	gpuScopes renderer.GpuScopes
+
+	fog = renderer.Fog{
+		Mode:          renderer.FogMode_Exp2,
+		Color:         color.NewLinear(0.5, 0.55, 0.6),
+		Density:       0.03,
+		Start:         2,
+		End:           60,
+		HeightFalloff: 0.15,
+		HeightBase:    -2,
+		SkyBlend:      0.6,
+	}
+	fogUbo buffers.UniformBuffer
 
-	frameTimesMsIndex int       = 0
-	frameTimesMs      []float32 = make([]float32, 0, FRAME_TIME_MS_SAMPLES)
+	skyboxSettings = renderer.NewSkyboxSettings()
 
-	camMoveSpeed float32 = 15
-	camRotSpeed  float32 = 0.5
+	// The day-night cycle drives the directional light, ambient, sky and fog colors while enabled
+	enableDayNightCycle = false
+	timeOfDay           = lights.NewTimeOfDay(10, 120)
+	streetLightsOn      = false
 
 	window engine.Window
 
-	pitch float32 = 0
-	yaw   float32 = -1.5
-	cam   camera.Camera
+	cam           camera.Camera
+	camController camera.FlyController
 
 	renderToBackBuffer = true
 
@@ -299,26 +149,72 @@ var (
 	// Spot light fbo
 	spotLightDepthMapFbo buffers.Framebuffer
 
+	// The cached maps of the static shadow casters of lights with ShadowSettings.CacheStatic, which are created by staticShadowFbo
+	dirLightStaticDepthMapFbo   buffers.Framebuffer
+	pointLightStaticDepthMapFbo buffers.Framebuffer
+	spotLightStaticDepthMapFbo  buffers.Framebuffer
+
+	// The lights whose shadow maps are in each layer of the spot light shadow map array. A light whose layer
+	// was drawn by another light must draw its map again, even if its own map is cached.
+	// Point light layers are tracked by lightManager.PointShadowLayers
+	spotShadowLayerOwners [lights.MaxSpotLights]*lights.SpotLightComp
+
 	// Hdr Fbo
 	hdrRendering                    = true
 	hdrExposure             float32 = 1
 	tonemappedScreenQuadMat materials.Material
 	hdrFbo                  buffers.Framebuffer
 
+	// Bloom makes emissive materials with intensities above one glow
+	bloomEnabled = true
+	bloom        postprocess.Bloom
+
+	// Deferred rendering draws the simple.glsl objects into a G-buffer and lights them after, so many more point lights
+	// than the lights ubo holds can be lit. PBR objects are still drawn forward. Only used with hdr rendering
+	deferredRendering = false
+	deferred          rend3dgl.Deferred
+
+	// lightSwarm is many small point lights without shadows over the ground, to show the lights deferred rendering can handle
+	lightSwarm     []registry.Handle
+	lightSwarmSize int32
+	lightSwarmRng  = rng.New(1)
+
+	// The hdr fbo is dynRes.ScaledSize of the window, and is upsampled to the window when tonemapping
+	dynRes = renderer.NewDynamicResolution(60)
+
 	screenQuadVao buffers.VertexArray
 	screenQuadMat materials.Material
 
+	// assetManager shares the simple.glsl program between the lit materials, which all set the same uniforms
+	assetManager = assets.NewManager()
+
+	// shaderWatcher reloads the shaders of the demo materials when they are edited, so lighting can be tweaked without restarting
+	shaderWatcher = shaders.NewWatcher(shaders.DefaultWatcherPollInterval)
+
 	unlitMat           materials.Material
+	flareMat           materials.Material
 	whiteMat           materials.Material
 	containerMat       materials.Material
 	groundMat          materials.Material
 	palleteMat         materials.Material
+	lightMarkerMat     materials.Material
 	skyboxMat          materials.Material
 	depthMapMat        materials.Material
 	arrayDepthMapMat   materials.Material
 	omnidirDepthMapMat materials.Material
 	debugDepthMat      materials.Material
 
+	// G-buffer copies of the lit materials for deferred rendering. See materials.NewGBufferMaterial
+	gbufferMat            materials.Material
+	containerGBufferMat   materials.Material
+	groundGBufferMat      materials.Material
+	palleteGBufferMat     materials.Material
+	lightMarkerGBufferMat materials.Material
+
+	// pbrMats are the PBR demo materials, where the first row is dielectric and the second metallic.
+	// All are variants of the first one, so they share its pbr.glsl program
+	pbrMats [2 * pbrSpheresPerRow]materials.Material
+
 	cubeMesh   meshes.Mesh
 	sphereMesh meshes.Mesh
 	chairMesh  meshes.Mesh
@@ -328,67 +224,59 @@ var (
 
 	renderSkybox      = true
 	renderDepthBuffer = false
+	renderLightFlares = true
+	renderViewmodel   = true
+
+	// viewmodel draws a block held in front of the camera like a first person weapon
+	viewmodel = renderer.NewViewmodel(60*gglm.Deg2Rad, camera.Layer(1))
+
+	// debugView is set on all materials using simple.glsl or pbr.glsl
+	debugView renderer.DebugView
+
+	flareTex assets.Texture
 
 	skyboxCmap assets.Cubemap
 
+	// iblMaps light the PBR materials with the skybox. They are baked at the start of the next frame's rendering while iblDirty is set,
+	// which it is at startup and when the skybox settings change
+	iblMaps  ibl.Maps
+	iblDirty = true
+
+	spotLightCookieTex assets.Texture
+
+	// Area light lookup tables
+	ltcMatTex assets.Texture
+	ltcAmpTex assets.Texture
+
+	// Light probes give objects the ambient light around them. Probes are captured one cubemap face per frame
+	// so capturing doesn't stall a frame, and lightProbeCaptureFace is probeIndex*6+face of the next face to capture
+	useLightProbes        = true
+	lightProbeGrid        lights.LightProbeGrid
+	lightProbeCaptureFbo  buffers.Framebuffer
+	lightProbeCaptureFace = 0
+	lightProbeFaces       [6][]float32
+
 	dpiScaling float32
+	uiScale    float32 = 1
 
-	// Light settings
-	dirLightDir = gglm.NewVec3(0, -0.5, -0.8)
-	// Lights
-	dirLight = DirLight{
-		Dir:           *dirLightDir.Normalize(),
-		DiffuseColor:  gglm.NewVec3(63.0/255, 63.0/255, 63.0/255),
-		SpecularColor: gglm.NewVec3(1, 1, 1),
-	}
-	pointLights = [POINT_LIGHT_COUNT]PointLight{
-		{
-			Pos:           gglm.NewVec3(0, 4, -3),
-			DiffuseColor:  gglm.NewVec3(1, 0, 0),
-			SpecularColor: gglm.NewVec3(1, 1, 1),
-			Radius:        10,
-			Falloff:       1.0,
-			MaxBias:       0.05,
-			NearPlane:     0.2,
-			FarPlane:      20 * pointLightRadiusToFarPlaneRatio,
-		},
-		{
-			Pos:           gglm.NewVec3(5, 0, 0),
-			DiffuseColor:  gglm.NewVec3(1, 1, 1),
-			SpecularColor: gglm.NewVec3(1, 1, 1),
-			Radius:        10,
-			Falloff:       1.0,
-			MaxBias:       0.05,
-			NearPlane:     0.2,
-			FarPlane:      20 * pointLightRadiusToFarPlaneRatio,
-		},
-		{
-			Pos:           gglm.NewVec3(-3, 4, 3),
-			DiffuseColor:  gglm.NewVec3(1, 1, 1),
-			SpecularColor: gglm.NewVec3(1, 1, 1),
-			Radius:        10,
-			Falloff:       1.0,
-			MaxBias:       0.05,
-			NearPlane:     0.2,
-			FarPlane:      20 * pointLightRadiusToFarPlaneRatio,
-		},
-	}
+	consoleSink = logging.NewMemorySink(512)
+	logConsole  = nmageimgui.NewLogConsole(consoleSink)
 
-	spotLightDir0 = gglm.NewVec3(1.5, -0.9, 0)
-	spotLights    = [SPOT_LIGHT_COUNT]SpotLight{
-		{
-			Pos:           gglm.NewVec3(-4, 7, 5),
-			Dir:           *spotLightDir0.Normalize(),
-			DiffuseColor:  gglm.NewVec3(1, 0, 1),
-			SpecularColor: gglm.NewVec3(1, 1, 1),
-			// These must be cosine values
-			InnerCutoffRad: 15 * gglm.Deg2Rad,
-			OuterCutoffRad: 20 * gglm.Deg2Rad,
+	// Entities are only used for lights for now
+	entities       = registry.NewRegistry[entity.CompContainer](64)
+	sceneHierarchy = entity.NewHierarchy()
 
-			NearPlane: 2,
-			FarPlane:  50,
-		},
-	}
+	editorSelection = editor.NewSelection()
+	hierarchyPanel  = editor.NewHierarchyPanel(&sceneHierarchy, &editorSelection)
+	inspectorPanel  = editor.NewInspectorPanel(entities, &sceneHierarchy, &editorSelection)
+	assetBrowser    = editor.NewAssetBrowser()
+
+	showGameHud       = true
+	gameHud           gameui.UI
+	hudFrameTex       assets.Texture
+	hudExposureSlider *gameui.Slider
+
+	dockspaceWindowClass = imgui.NewWindowClass()
 )
 
 type Game struct {
@@ -401,33 +289,64 @@ type Game struct {
 
 func main() {
 
+	defer engine.HandleCrash()
+
+	logging.AddSink(consoleSink)
+
+	// Missing config is fine since we have defaults, but a broken one is not
+	cfg, err := engine.LoadConfig("engine.toml")
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logging.ErrLog.Fatalln("Failed to load engine config. Err:", err)
+		}
+
+		logging.WarnLog.Println("Engine config file not found, so default config will be used. Err:", err)
+	}
+
 	//Init engine
-	err := engine.Init()
+	err = engine.Init(cfg.Engine)
 	if err != nil {
 		logging.ErrLog.Fatalln("Failed to init nMage. Err:", err)
 	}
 
+	// Audio is optional so the demo still runs on machines without an audio device
+	err = audio.Init()
+	if err != nil {
+		logging.WarnLog.Println("Failed to init audio. Sounds will not play. Err:", err)
+	}
+	defer audio.DeInit()
+
 	//Create window
-	dpiScaling = getDpiScaling(UNSCALED_WINDOW_WIDTH, UNSCALED_WINDOW_HEIGHT)
-	window, err = engine.CreateOpenGLWindowCentered("nMage", int32(UNSCALED_WINDOW_WIDTH*dpiScaling), int32(UNSCALED_WINDOW_HEIGHT*dpiScaling), engine.WindowFlags_RESIZABLE)
+	winOpts := cfg.Window
+	dpiScaling = engine.GetDisplayDpiScaling(0)
+	winOpts.Width = int32(float32(winOpts.Width) * dpiScaling)
+	winOpts.Height = int32(float32(winOpts.Height) * dpiScaling)
+	logging.InfoLog.Printf("DPI scaling=%f. Scaled window size (width, height)=(%d, %d)\n", dpiScaling, winOpts.Width, winOpts.Height)
+
+	window, err = engine.CreateOpenGLWindow(winOpts)
 	if err != nil {
 		logging.ErrLog.Fatalln("Failed to create window. Err: ", err)
 	}
 	defer window.Destroy()
 
-	engine.SetMSAA(true)
-	engine.SetVSync(false)
-	engine.SetSrgbFramebuffer(true)
-
+	winWidth, winHeight := window.SDLWin.GetSize()
 	game := &Game{
 		Win:       &window,
-		WinWidth:  int32(UNSCALED_WINDOW_WIDTH * dpiScaling),
-		WinHeight: int32(UNSCALED_WINDOW_HEIGHT * dpiScaling),
+		WinWidth:  winWidth,
+		WinHeight: winHeight,
 		Rend:      rend3dgl.NewRend3DGL(),
 		ImGUIInfo: nmageimgui.NewImGui("./res/shaders/imgui.glsl"),
 	}
 	window.EventCallbacks = append(window.EventCallbacks, game.handleWindowEvents)
 
+	// Fonts are rasterized for the DPI of the display instead of being drawn small on high DPI displays
+	uiScale = dpiScaling
+	game.ImGUIInfo.SetUIScale(uiScale)
+	window.DpiChangedCallbacks = append(window.DpiChangedCallbacks, game.handleDpiChanged)
+
+	fbWidth, fbHeight := window.SDLWin.GLGetDrawableSize()
+	game.Rend.SetViewport(0, 0, fbWidth, fbHeight)
+
 	if PROFILE_CPU {
 
 		pf, err := os.Create("cpu.pprof")
@@ -439,6 +358,12 @@ func main() {
 		}
 	}
 
+	// Lets editor panels be dragged out of the window, e.g. onto another display
+	err = game.ImGUIInfo.EnableViewports(window.SDLWin, window.GlCtx)
+	if err != nil {
+		logging.WarnLog.Printf("Imgui windows can't be moved out of the main window. Err: %v\n", err)
+	}
+
 	window.SDLWin.SetTitle("nMage")
 	engine.Run(game, &window, game.Rend, game.ImGUIInfo)
 
@@ -468,11 +393,21 @@ func (g *Game) handleWindowEvents(e sdl.Event) {
 
 	switch e := e.(type) {
 	case *sdl.WindowEvent:
+
+		// Imgui windows dragged out of the main window have their own OS windows
+		winId, _ := g.Win.SDLWin.GetID()
+		if e.WindowID != winId {
+			return
+		}
+
 		if e.Event == sdl.WINDOWEVENT_SIZE_CHANGED {
 
 			g.WinWidth = e.Data1
 			g.WinHeight = e.Data2
 
+			fbWidth, fbHeight := g.Win.SDLWin.GLGetDrawableSize()
+			g.Rend.SetViewport(0, 0, fbWidth, fbHeight)
+
 			cam.AspectRatio = float32(g.WinWidth) / float32(g.WinHeight)
 			cam.Update()
 
@@ -481,38 +416,11 @@ func (g *Game) handleWindowEvents(e sdl.Event) {
 	}
 }
 
-func getDpiScaling(unscaledWindowWidth, unscaledWindowHeight int32) float32 {
-
-	// Great read on DPI here: https://nlguillemot.wordpress.com/2016/12/11/high-dpi-rendering/
-
-	// The no-scaling DPI on different platforms (e.g. when scale=100% on windows)
-	var defaultDpi float32 = 96
-	if runtime.GOOS == "windows" {
-		defaultDpi = 96
-	} else if runtime.GOOS == "darwin" {
-		defaultDpi = 72
-	}
-
-	// Current DPI of the monitor
-	_, dpiHorizontal, _, err := sdl.GetDisplayDPI(0)
-	if err != nil {
-		dpiHorizontal = defaultDpi
-		logging.ErrLog.Printf("Failed to get DPI with error '%s'. Using default DPI of '%f'\n", err.Error(), defaultDpi)
-	}
-
-	// Scaling factor (e.g. will be 1.25 for 125% scaling on windows)
-	dpiScaling := dpiHorizontal / defaultDpi
-
-	logging.InfoLog.Printf(
-		"Default DPI=%f\nHorizontal DPI=%f\nDPI scaling=%f\nUnscaled window size (width, height)=(%d, %d)\nScaled window size (width, height)=(%d, %d)\n\n",
-		defaultDpi,
-		dpiHorizontal,
-		dpiScaling,
-		unscaledWindowWidth, unscaledWindowHeight,
-		int32(float32(unscaledWindowWidth)*dpiScaling), int32(float32(unscaledWindowHeight)*dpiScaling),
-	)
-
-	return dpiScaling
+// handleDpiChanged runs when the window moves to a display with a different DPI. The engine already resized the window, and scales imgui
+func (g *Game) handleDpiChanged(oldScaling, newScaling float32) {
+	dpiScaling = newScaling
+	uiScale *= newScaling / oldScaling
+	gameHud.Scale = newScaling
 }
 
 func (g *Game) Init() {
@@ -534,18 +442,31 @@ func (g *Game) Init() {
 		float32(winWidth)/float32(winHeight),
 	)
 
+	camController = camera.NewFlyController(&cam)
+	camController.LookButton = sdl.BUTTON_RIGHT
+	camController.MoveSpeed = 15
+	camController.Sensitivity = 0.008
+
 	//Load meshes
-	cubeMesh, err = meshes.NewMesh("Cube", "./res/models/cube.fbx", 0)
+	// Scene meshes get depth streams so shadow passes only fetch positions
+	cubeMesh, err = meshes.NewMeshWithOptions("Cube", "./res/models/cube.fbx", 0, meshes.MeshLoadOptions{DepthStream: true})
 	if err != nil {
 		logging.ErrLog.Fatalln("Failed to load mesh. Err: ", err)
 	}
 
-	sphereMesh, err = meshes.NewMesh("Sphere", "./res/models/sphere.fbx", 0)
+	sphereMesh, err = meshes.NewMeshWithOptions("Sphere", "./res/models/sphere.fbx", 0, meshes.MeshLoadOptions{DepthStream: true})
 	if err != nil {
 		logging.ErrLog.Fatalln("Failed to load mesh. Err: ", err)
 	}
 
-	chairMesh, err = meshes.NewMesh("Chair", "./res/models/chair.fbx", 0)
+	chairMesh, err = meshes.NewMeshWithOptions("Chair", "./res/models/chair.fbx", 0, meshes.MeshLoadOptions{
+		BakeAo: &meshes.AoBakeSettings{
+			RayCount: 32,
+			MaxDist:  1,
+			Bias:     0.001,
+		},
+		DepthStream: true,
+	})
 	if err != nil {
 		logging.ErrLog.Fatalln("Failed to load mesh. Err: ", err)
 	}
@@ -581,6 +502,26 @@ func (g *Game) Init() {
 		logging.ErrLog.Fatalln("Failed to load texture. Err: ", err)
 	}
 
+	spotLightCookieTex, err = assets.LoadTextureInMemPngImg(newWindowCookieImg(256, 4), &assets.TextureLoadOptions{NoSrgba: true})
+	if err != nil {
+		logging.ErrLog.Fatalln("Failed to create cookie texture. Err: ", err)
+	}
+
+	ltcLuts, err := lights.LoadLtcLuts("./res/textures/ltc-luts.bin")
+	if err != nil {
+		logging.ErrLog.Fatalln("Failed to load LTC lookup tables. Err: ", err)
+	}
+
+	ltcMatTex, err = assets.NewTextureRGBA32F(ltcLuts.Mat, lights.LtcLutSize, lights.LtcLutSize)
+	if err != nil {
+		logging.ErrLog.Fatalln("Failed to create LTC matrix texture. Err: ", err)
+	}
+
+	ltcAmpTex, err = assets.NewTextureRGBA32F(ltcLuts.Amp, lights.LtcLutSize, lights.LtcLutSize)
+	if err != nil {
+		logging.ErrLog.Fatalln("Failed to create LTC amplitude texture. Err: ", err)
+	}
+
 	skyboxCmap, err = assets.LoadCubemapTextures(
 		"./res/textures/sb-right.jpg", "./res/textures/sb-left.jpg",
 		"./res/textures/sb-top.jpg", "./res/textures/sb-bottom.jpg",
@@ -594,20 +535,34 @@ func (g *Game) Init() {
 	//
 	// Create materials and assign any unused texture slots to black
 	//
-	screenQuadMat = materials.NewMaterial("Screen Quad Mat", "./res/shaders/screen-quad.glsl")
+	screenQuadMat = assert.MustGet(materials.NewMaterial("Screen Quad Mat", "./res/shaders/screen-quad.glsl"))
 	screenQuadMat.SetUnifVec2("scale", &demoFboScale)
 	screenQuadMat.SetUnifVec2("offset", &demoFboOffset)
 	screenQuadMat.SetUnifInt32("material.diffuse", int32(materials.TextureSlot_Diffuse))
 
-	tonemappedScreenQuadMat = materials.NewMaterial("Tonemapped Screen Quad Mat", "./res/shaders/tonemapped-screen-quad.glsl")
+	tonemappedScreenQuadMat = assert.MustGet(materials.NewMaterial("Tonemapped Screen Quad Mat", "./res/shaders/tonemapped-screen-quad.glsl"))
 	tonemappedScreenQuadMat.SetUnifInt32("material.diffuse", int32(materials.TextureSlot_Diffuse))
 
-	unlitMat = materials.NewMaterial("Unlit mat", "./res/shaders/simple-unlit.glsl")
+	// The bloom texture is bound through the specular slot
+	tonemappedScreenQuadMat.SetUnifInt32("bloomTex", int32(materials.TextureSlot_Specular))
+	tonemappedScreenQuadMat.SpecularTex = assets.DefaultBlackTexId.TexID
+	bloom = postprocess.NewBloom()
+
+	unlitMat = assert.MustGet(materials.NewMaterial("Unlit mat", "./res/shaders/simple-unlit.glsl"))
 	unlitMat.Settings.Set(materials.MaterialSettings_HasModelMtx)
 	unlitMat.SetUnifInt32("material.diffuse", int32(materials.TextureSlot_Diffuse))
 
-	whiteMat = materials.NewMaterial("White mat", "./res/shaders/simple.glsl")
-	whiteMat.Settings.Set(materials.MaterialSettings_HasModelMtx)
+	flareTex, err = assets.LoadTextureInMemPngImg(newFlareImg(64), &assets.TextureLoadOptions{GenMipMaps: true})
+	if err != nil {
+		logging.ErrLog.Fatalln("Failed to create flare texture. Err:", err)
+	}
+
+	flareMat = assert.MustGet(materials.NewMaterial("Flare mat", "./res/shaders/billboard.glsl"))
+	flareMat.DiffuseTex = flareTex.TexID
+	flareMat.SetUnifInt32("material.diffuse", int32(materials.TextureSlot_Diffuse))
+
+	whiteMat = assert.MustGet(materials.NewManagedMaterial(&assetManager, "White mat", "./res/shaders/simple.glsl"))
+	whiteMat.Settings.Set(materials.MaterialSettings_HasPerObjectUbo)
 	whiteMat.Shininess = 64
 	whiteMat.SetUnifInt32("material.diffuse", int32(materials.TextureSlot_Diffuse))
 	whiteMat.SetUnifInt32("material.specular", int32(materials.TextureSlot_Specular))
@@ -618,8 +573,8 @@ func (g *Game) Init() {
 	whiteMat.SetUnifInt32("pointLightCubeShadowMaps", int32(materials.TextureSlot_Cubemap_Array))
 	whiteMat.SetUnifInt32("spotLightShadowMaps", int32(materials.TextureSlot_ShadowMap_Array1))
 
-	containerMat = materials.NewMaterial("Container mat", "./res/shaders/simple.glsl")
-	containerMat.Settings.Set(materials.MaterialSettings_HasModelMtx)
+	containerMat = assert.MustGet(materials.NewManagedMaterial(&assetManager, "Container mat", "./res/shaders/simple.glsl"))
+	containerMat.Settings.Set(materials.MaterialSettings_HasPerObjectUbo)
 	containerMat.Shininess = 64
 	containerMat.DiffuseTex = containerDiffuseTex.TexID
 	containerMat.SpecularTex = containerSpecularTex.TexID
@@ -632,8 +587,8 @@ func (g *Game) Init() {
 	containerMat.SetUnifInt32("pointLightCubeShadowMaps", int32(materials.TextureSlot_Cubemap_Array))
 	containerMat.SetUnifInt32("spotLightShadowMaps", int32(materials.TextureSlot_ShadowMap_Array1))
 
-	groundMat = materials.NewMaterial("Ground mat", "./res/shaders/simple.glsl")
-	groundMat.Settings.Set(materials.MaterialSettings_HasModelMtx)
+	groundMat = assert.MustGet(materials.NewManagedMaterial(&assetManager, "Ground mat", "./res/shaders/simple.glsl"))
+	groundMat.Settings.Set(materials.MaterialSettings_HasPerObjectUbo)
 	groundMat.Shininess = 64
 	groundMat.DiffuseTex = brickwallDiffuseTex.TexID
 	groundMat.NormalTex = brickwallNormalTex.TexID
@@ -646,8 +601,8 @@ func (g *Game) Init() {
 	groundMat.SetUnifInt32("pointLightCubeShadowMaps", int32(materials.TextureSlot_Cubemap_Array))
 	groundMat.SetUnifInt32("spotLightShadowMaps", int32(materials.TextureSlot_ShadowMap_Array1))
 
-	palleteMat = materials.NewMaterial("Pallete mat", "./res/shaders/simple.glsl")
-	palleteMat.Settings.Set(materials.MaterialSettings_HasModelMtx)
+	palleteMat = assert.MustGet(materials.NewManagedMaterial(&assetManager, "Pallete mat", "./res/shaders/simple.glsl"))
+	palleteMat.Settings.Set(materials.MaterialSettings_HasPerObjectUbo)
 	palleteMat.Shininess = 64
 	palleteMat.DiffuseTex = palleteTex.TexID
 	palleteMat.SetUnifInt32("material.diffuse", int32(materials.TextureSlot_Diffuse))
@@ -659,21 +614,108 @@ func (g *Game) Init() {
 	palleteMat.SetUnifInt32("pointLightCubeShadowMaps", int32(materials.TextureSlot_Cubemap_Array))
 	palleteMat.SetUnifInt32("spotLightShadowMaps", int32(materials.TextureSlot_ShadowMap_Array1))
 
-	debugDepthMat = materials.NewMaterial("Debug depth mat", "./res/shaders/debug-depth.glsl")
+	pbrMats[0] = assert.MustGet(materials.NewManagedMaterial(&assetManager, "PBR mat 0", "./res/shaders/pbr.glsl"))
+	pbrMats[0].Settings.Set(materials.MaterialSettings_HasPerObjectUbo | materials.MaterialSettings_Pbr)
+	pbrMats[0].DiffuseTex = assets.DefaultWhiteTexId.TexID
+	pbrMats[0].SetUnifInt32("material.diffuse", int32(materials.TextureSlot_Diffuse))
+	pbrMats[0].SetUnifInt32("material.normal", int32(materials.TextureSlot_Normal))
+	pbrMats[0].SetUnifInt32("material.emission", int32(materials.TextureSlot_Emission))
+	pbrMats[0].SetUnifInt32("material.metallic", int32(materials.TextureSlot_Metallic))
+	pbrMats[0].SetUnifInt32("material.roughness", int32(materials.TextureSlot_Roughness))
+	pbrMats[0].SetUnifInt32("material.ao", int32(materials.TextureSlot_AmbientOcclusion))
+	pbrMats[0].SetUnifInt32("dirLightShadowMap", int32(materials.TextureSlot_ShadowMap1))
+	pbrMats[0].SetUnifInt32("pointLightCubeShadowMaps", int32(materials.TextureSlot_Cubemap_Array))
+	pbrMats[0].SetUnifInt32("spotLightShadowMaps", int32(materials.TextureSlot_ShadowMap_Array1))
+
+	setSpotLightCookieSamplers(&whiteMat)
+	setSpotLightCookieSamplers(&containerMat)
+	setSpotLightCookieSamplers(&groundMat)
+	setSpotLightCookieSamplers(&palleteMat)
+	setSpotLightCookieSamplers(&pbrMats[0])
+
+	setLtcTextures(&whiteMat)
+	setLtcTextures(&containerMat)
+	setLtcTextures(&groundMat)
+	setLtcTextures(&palleteMat)
+	setLtcTextures(&pbrMats[0])
+
+	whiteMat.SetUnifInt32("lightmap", int32(materials.TextureSlot_Lightmap))
+	containerMat.SetUnifInt32("lightmap", int32(materials.TextureSlot_Lightmap))
+	groundMat.SetUnifInt32("lightmap", int32(materials.TextureSlot_Lightmap))
+	palleteMat.SetUnifInt32("lightmap", int32(materials.TextureSlot_Lightmap))
+	pbrMats[0].SetUnifInt32("lightmap", int32(materials.TextureSlot_Lightmap))
+
+	for i := 1; i < len(pbrMats); i++ {
+		pbrMats[i] = materials.NewMaterialVariant(&pbrMats[0], "PBR mat "+strconv.Itoa(i))
+	}
+
+	for i := range pbrMats {
+		pbrMats[i].Roughness = float32(i%pbrSpheresPerRow) / (pbrSpheresPerRow - 1)
+		if i >= pbrSpheresPerRow {
+			pbrMats[i].Metallic = 1
+		}
+	}
+
+	// Light markers glow above one so they bloom. Pallete mat keeps the default black emission texture, so the
+	// emissive uniforms the marker leaves on the shared shader don't change it
+	lightMarkerMat = materials.NewMaterialVariant(&palleteMat, "Light marker mat")
+	lightMarkerMat.Settings.Set(materials.MaterialSettings_Emissive)
+	lightMarkerMat.EmissionTex = assets.DefaultWhiteTexId.TexID
+	lightMarkerMat.EmissiveColor = color.NewLinear(1, 0.9, 0.7)
+	lightMarkerMat.EmissiveIntensity = 4
+
+	gbufferMat = assert.MustGet(materials.NewManagedMaterial(&assetManager, "G-buffer mat", "./res/shaders/gbuffer.glsl"))
+	gbufferMat.Settings.Set(materials.MaterialSettings_HasPerObjectUbo)
+	gbufferMat.Shininess = 64
+	gbufferMat.SetUnifInt32("material.diffuse", int32(materials.TextureSlot_Diffuse))
+	gbufferMat.SetUnifInt32("material.specular", int32(materials.TextureSlot_Specular))
+	gbufferMat.SetUnifInt32("material.normal", int32(materials.TextureSlot_Normal))
+	gbufferMat.SetUnifInt32("material.emission", int32(materials.TextureSlot_Emission))
+	gbufferMat.SetUnifFloat32("material.shininess", gbufferMat.Shininess)
+	gbufferMat.SetUnifInt32("lightmap", int32(materials.TextureSlot_Lightmap))
+
+	containerGBufferMat = materials.NewGBufferMaterial(&gbufferMat, &containerMat)
+	groundGBufferMat = materials.NewGBufferMaterial(&gbufferMat, &groundMat)
+	palleteGBufferMat = materials.NewGBufferMaterial(&gbufferMat, &palleteMat)
+	lightMarkerGBufferMat = materials.NewGBufferMaterial(&gbufferMat, &lightMarkerMat)
+
+	// Light batches use binding point 4, after the blocks set up in initUbos
+	deferred = rend3dgl.NewDeferred(1024, 4)
+	setLtcTextures(&deferred.LightMat)
+
+	debugDepthMat = assert.MustGet(materials.NewMaterial("Debug depth mat", "./res/shaders/debug-depth.glsl"))
 	debugDepthMat.Settings.Set(materials.MaterialSettings_HasModelMtx)
 
-	depthMapMat = materials.NewMaterial("Depth Map mat", "./res/shaders/depth-map.glsl")
-	depthMapMat.Settings.Set(materials.MaterialSettings_HasModelMtx)
+	// Depth materials have a zero cutoff so they turn off the cutout of their cutout copies (see materials.NewCutoutDepthMaterial),
+	// and are position only so meshes with depth streams are drawn with them
+	depthMapMat = assert.MustGet(materials.NewMaterial("Depth Map mat", "./res/shaders/depth-map.glsl"))
+	depthMapMat.Settings.Set(materials.MaterialSettings_HasModelMtx | materials.MaterialSettings_AlphaCutout | materials.MaterialSettings_PositionOnly)
 
-	arrayDepthMapMat = materials.NewMaterial("Array Depth Map mat", "./res/shaders/array-depth-map.glsl")
-	arrayDepthMapMat.Settings.Set(materials.MaterialSettings_HasModelMtx)
+	arrayDepthMapMat = assert.MustGet(materials.NewMaterial("Array Depth Map mat", "./res/shaders/array-depth-map.glsl"))
+	arrayDepthMapMat.Settings.Set(materials.MaterialSettings_HasModelMtx | materials.MaterialSettings_AlphaCutout | materials.MaterialSettings_PositionOnly)
 
-	omnidirDepthMapMat = materials.NewMaterial("Omnidirectional Depth Map mat", "./res/shaders/omnidirectional-depth-map.glsl")
-	omnidirDepthMapMat.Settings.Set(materials.MaterialSettings_HasModelMtx)
+	omnidirDepthMapMat = assert.MustGet(materials.NewMaterial("Omnidirectional Depth Map mat", "./res/shaders/omnidirectional-depth-map.glsl"))
+	omnidirDepthMapMat.Settings.Set(materials.MaterialSettings_HasModelMtx | materials.MaterialSettings_AlphaCutout | materials.MaterialSettings_PositionOnly)
 
-	skyboxMat = materials.NewMaterial("Skybox mat", "./res/shaders/skybox.glsl")
+	skyboxMat = assert.MustGet(materials.NewMaterial("Skybox mat", "./res/shaders/skybox.glsl"))
+	skyboxMat.Settings.Set(materials.MaterialSettings_TwoSided)
 	skyboxMat.CubemapTex = skyboxCmap.TexID
 	skyboxMat.SetUnifInt32("skybox", int32(materials.TextureSlot_Cubemap))
+	skyboxMat.SetUnifVec3("skyTint", &gglm.Vec3{Data: [3]float32{1, 1, 1}})
+	skyboxSettings.Apply(&skyboxMat)
+
+	watchedMats := []*materials.Material{
+		&screenQuadMat, &tonemappedScreenQuadMat, &unlitMat, &flareMat,
+		&whiteMat, &containerMat, &groundMat, &palleteMat, &lightMarkerMat,
+		&debugDepthMat, &depthMapMat, &arrayDepthMapMat, &omnidirDepthMapMat, &skyboxMat, &gbufferMat,
+	}
+	for _, mat := range watchedMats {
+		assert.Must(mat.WatchShader(&shaderWatcher))
+	}
+
+	for i := range pbrMats {
+		assert.Must(pbrMats[i].WatchShader(&shaderWatcher))
+	}
 
 	// Cube model mat
 	translationMat := gglm.NewTranslationMat(0, 0, 0)
@@ -692,8 +734,20 @@ func (g *Game) Init() {
 	screenQuadVao = buffers.NewVertexArray()
 	screenQuadVao.AddVertexBuffer(screenQuadVbo)
 
-	// Fbos and lights
+	gpuScopes = renderer.NewGpuScopes()
+	engine.SetPerfHudGpuScopes(&gpuScopes)
+
+	// Frames slower than 30 FPS are logged so hitches can be found after playing
+	timing.SetFrameSpikeCallback(33*time.Millisecond, func(frameTime time.Duration) {
+		logging.WarnLog.Printf("Frame spike of %.2fms\n", float64(frameTime.Microseconds())/1000)
+	})
+
+	// Lights and fbos
+	g.initLights()
 	g.initFbos()
+	initAssetBrowser()
+	initGameHud()
+	engine.RegisterSystem(&gameHudSystem{g: g})
 	// Ubos
 	g.initUbos()
 
@@ -701,240 +755,846 @@ func (g *Game) Init() {
 	cam.Update()
 	updateAllProjViewMats(cam.ProjMat, cam.ViewMat)
 
-	lightsUboData.AmbientColor = gglm.NewVec3(20.0/255, 20.0/255, 20.0/255)
 	g.applyLightUpdates()
 }
 
-func (g *Game) initUbos() {
+func (g *Game) initLights() {
 
-	globalMatricesUbo = buffers.NewUniformBuffer(
-		[]buffers.UniformBufferFieldInput{
-			{Id: 0, Type: buffers.DataTypeVec3},
-			{Id: 1, Type: buffers.DataTypeMat4},
+	lightManager.AmbientColor = color.NewLinear(20.0/255, 20.0/255, 20.0/255)
+
+	dirLightDir := gglm.NewVec3(0, -0.5, -0.8)
+	addEntityWithComp("Sun", lights.NewDirLightComp(lights.DirLight{
+		Dir:           *dirLightDir.Normalize(),
+		DiffuseColor:  color.NewLinear(63.0/255, 63.0/255, 63.0/255),
+		SpecularColor: color.NewLinear(1, 1, 1),
+		ShadowPos:     gglm.NewVec3(0, 10, 0),
+		ShadowSize:    30,
+		Shadow: lights.ShadowSettings{
+			Enabled:      true,
+			Resolution:   4096,
+			NearPlane:    0.1,
+			FarPlane:     30,
+			BiasConstant: 0.005,
+			BiasSlope:    0.05,
+			PcfRadius:    1,
+
+			CullFrontFaces: true,
+			CacheStatic:    true,
 		},
-		buffers.BufUsage_Dynamic_Draw,
-	)
+	}))
 
-	globalMatricesUbo.SetBindPoint(0)
-	groundMat.SetUniformBlockBindingPoint("GlobalMatrices", 0)
-	whiteMat.SetUniformBlockBindingPoint("GlobalMatrices", 0)
-	containerMat.SetUniformBlockBindingPoint("GlobalMatrices", 0)
-	palleteMat.SetUniformBlockBindingPoint("GlobalMatrices", 0)
+	pointLightPositions := []gglm.Vec3{
+		gglm.NewVec3(0, 4, -3),
+		gglm.NewVec3(5, 0, 0),
+		gglm.NewVec3(-3, 4, 3),
+	}
+	pointLightColors := []color.Color{
+		color.NewLinear(1, 0, 0),
+		color.NewLinear(1, 1, 1),
+		color.NewLinear(1, 1, 1),
+	}
 
-	lightsUbo = buffers.NewUniformBuffer(
-		[]buffers.UniformBufferFieldInput{
-			// Dir light
-			{Id: 0, Type: buffers.DataTypeStruct,
-				Subfields: []buffers.UniformBufferFieldInput{
-					{Id: 1, Type: buffers.DataTypeVec3}, // 12 00
-					{Id: 2, Type: buffers.DataTypeVec3}, // 12 16
-					{Id: 3, Type: buffers.DataTypeVec3}, // 12 32
-				},
-			},
-			// Point lights
-			{Id: 5, Type: buffers.DataTypeStruct,
-				Count: POINT_LIGHT_COUNT,
-				Subfields: []buffers.UniformBufferFieldInput{
-					{Id: 6, Type: buffers.DataTypeVec3},     // 12 48
-					{Id: 7, Type: buffers.DataTypeVec3},     // 12 64
-					{Id: 8, Type: buffers.DataTypeVec3},     // 12 80
-					{Id: 9, Type: buffers.DataTypeFloat32},  // 04 92
-					{Id: 10, Type: buffers.DataTypeFloat32}, // 04 96
-					{Id: 11, Type: buffers.DataTypeFloat32}, // 04 100
-					{Id: 12, Type: buffers.DataTypeFloat32}, // 04 104
-					{Id: 13, Type: buffers.DataTypeFloat32}, // 04 108
-				},
-			},
-			// Spot lights
-			{Id: 14, Type: buffers.DataTypeStruct,
-				Count: SPOT_LIGHT_COUNT,
-				Subfields: []buffers.UniformBufferFieldInput{
-					{Id: 15, Type: buffers.DataTypeVec3},    // 12 112
-					{Id: 16, Type: buffers.DataTypeVec3},    // 12 128
-					{Id: 17, Type: buffers.DataTypeVec3},    // 12 144
-					{Id: 18, Type: buffers.DataTypeVec3},    // 12 160
-					{Id: 19, Type: buffers.DataTypeFloat32}, // 04 172
-					{Id: 20, Type: buffers.DataTypeFloat32}, // 04 176
-				},
+	for i := 0; i < len(pointLightPositions); i++ {
+		addEntityWithComp("Point Light "+strconv.Itoa(i), lights.NewPointLightComp(lights.PointLight{
+			Pos:           pointLightPositions[i],
+			DiffuseColor:  pointLightColors[i],
+			SpecularColor: color.NewLinear(1, 1, 1),
+			Radius:        10,
+			Falloff:       1.0,
+			Shadow: lights.ShadowSettings{
+				Enabled:      true,
+				Resolution:   1024,
+				NearPlane:    0.2,
+				FarPlane:     20 * pointLightRadiusToFarPlaneRatio,
+				BiasConstant: 0.005,
+				BiasSlope:    0.05,
+				CacheStatic:  true,
 			},
+		}))
+	}
 
-			// Ambient
-			{Id: 21, Type: buffers.DataTypeVec3}, // 12 192
+	spotLightDir := gglm.NewVec3(1.5, -0.9, 0)
+	addEntityWithComp("Spot Light", lights.NewSpotLightComp(lights.SpotLight{
+		Pos:           gglm.NewVec3(-4, 7, 5),
+		Dir:           *spotLightDir.Normalize(),
+		DiffuseColor:  color.NewLinear(1, 0, 1),
+		SpecularColor: color.NewLinear(1, 1, 1),
+		// These must be cosine values
+		InnerCutoffRad: 15 * gglm.Deg2Rad,
+		OuterCutoffRad: 20 * gglm.Deg2Rad,
+		Range:          50,
+		CookieTex:      spotLightCookieTex.TexID,
+
+		Shadow: lights.ShadowSettings{
+			Enabled:      true,
+			Resolution:   1024,
+			NearPlane:    2,
+			FarPlane:     50,
+			BiasConstant: 0.005,
+			BiasSlope:    0.05,
+			PcfRadius:    1,
 		},
-		buffers.BufUsage_Dynamic_Draw,
-	)
+	}))
+
+	// A panel above the ground facing down
+	addEntityWithComp("Area Light", lights.NewAreaLightComp(lights.AreaLight{
+		Pos:           gglm.NewVec3(5, 1, -5),
+		Right:         gglm.NewVec3(1, 0, 0),
+		Up:            gglm.NewVec3(0, 0, 1),
+		Width:         4,
+		Height:        1.5,
+		DiffuseColor:  color.NewLinear(1, 0.85, 0.6),
+		SpecularColor: color.NewLinear(1, 0.85, 0.6),
+		Range:         25,
+	}))
+
+	// Probes cover the ground and the space above it
+	lightProbeGrid = lights.NewLightProbeGrid(gglm.NewVec3(-9, -1.5, -9), gglm.NewVec3(9, 4.5, 9), 4, 2, 4)
+	lightProbeCaptureFace = 0
+	g.Rend.SetAmbientSampler(&lightProbeGrid)
+
+	hierarchyPanel.OnCreate = createEmptyEntity
+	hierarchyPanel.OnDelete = deleteEntity
+
+	// Gameplay hooks on the time of day, here only used to show the state in the debug window
+	timeOfDay.AddHook(18, func(t *lights.TimeOfDay) { streetLightsOn = true })
+	timeOfDay.AddHook(6, func(t *lights.TimeOfDay) { streetLightsOn = false })
+}
 
-	// fmt.Printf("\n==Lights UBO (id=%d)==\nSize=%d\nFields: %+v\n\n", lightsUbo.Id, lightsUbo.Size, lightsUbo.Fields)
+// setLightSwarmSize adds or removes swarm lights until there are size of them
+func setLightSwarmSize(size int) {
 
-	lightsUbo.SetBindPoint(1)
-	groundMat.SetUniformBlockBindingPoint("Lights", 1)
-	whiteMat.SetUniformBlockBindingPoint("Lights", 1)
-	containerMat.SetUniformBlockBindingPoint("Lights", 1)
-	palleteMat.SetUniformBlockBindingPoint("Lights", 1)
+	for len(lightSwarm) < size {
+
+		pos := gglm.NewVec3(lightSwarmRng.Range(-9, 9), lightSwarmRng.Range(-2.2, 0), lightSwarmRng.Range(-9, 9))
+		lightSwarm = append(lightSwarm, addEntityWithComp("Swarm Light "+strconv.Itoa(len(lightSwarm)), lights.NewPointLightComp(lights.PointLight{
+			Pos:           pos,
+			DiffuseColor:  color.FromHsv(lightSwarmRng.Range(0, 360), 0.8, 1),
+			SpecularColor: color.NewLinear(1, 1, 1),
+			Radius:        2,
+			Falloff:       1.0,
+		})))
+	}
+
+	for len(lightSwarm) > size {
+
+		handle := lightSwarm[len(lightSwarm)-1]
+		lightSwarm = lightSwarm[:len(lightSwarm)-1]
+
+		// The light may have been deleted in the editor already
+		removed := sceneHierarchy.Remove(handle)
+		for i := 0; i < len(removed); i++ {
+			editorSelection.Remove(removed[i])
+			deleteEntity(removed[i])
+		}
+	}
 }
 
-func (g *Game) initFbos() {
+func addEntityWithComp[T entity.Comp](name string, c T) registry.Handle {
 
-	// @TODO: Resize window sized fbos on window resize
+	cc, handle := entities.New()
+	*cc = entity.NewCompContainer()
+	entity.AddComp(handle, cc, c)
 
-	// Demo fbo
-	demoFbo = buffers.NewFramebuffer(uint32(g.WinWidth), uint32(g.WinHeight))
+	sceneHierarchy.Add(handle, name, 0)
+	return handle
+}
 
-	demoFbo.NewColorAttachment(
-		buffers.FramebufferAttachmentType_Texture,
-		buffers.FramebufferAttachmentDataFormat_SRGBA,
-	)
+func createEmptyEntity(parent registry.Handle) {
 
-	demoFbo.NewDepthStencilAttachment(
-		buffers.FramebufferAttachmentType_Renderbuffer,
-		buffers.FramebufferAttachmentDataFormat_Depth24Stencil8,
-	)
+	cc, handle := entities.New()
+	*cc = entity.NewCompContainer()
 
-	assert.T(demoFbo.IsComplete(), "Demo fbo is not complete after init")
+	sceneHierarchy.Add(handle, "Entity", parent)
+	editorSelection.Select(handle)
+}
 
-	// Depth map fbo
-	dirLightDepthMapFbo = buffers.NewFramebuffer(4096, 4096)
-	dirLightDepthMapFbo.SetNoColorBuffer()
-	dirLightDepthMapFbo.NewDepthAttachment(
-		buffers.FramebufferAttachmentType_Texture,
-		buffers.FramebufferAttachmentDataFormat_DepthF32,
-	)
+// initGameHud creates a small HUD to show the game UI. There is no font sheet in the demo assets yet, so widgets have no text
+func initGameHud() {
 
-	assert.T(dirLightDepthMapFbo.IsComplete(), "Depth map fbo is not complete after init")
+	gameHud = gameui.NewUI()
+	gameHud.Scale = dpiScaling
 
-	// Point light depth map fbo
-	pointLightDepthMapFbo = buffers.NewFramebuffer(1024, 1024)
-	pointLightDepthMapFbo.SetNoColorBuffer()
-	pointLightDepthMapFbo.NewDepthCubemapArrayAttachment(
-		buffers.FramebufferAttachmentDataFormat_DepthF32,
-		MaxPointLights,
+	const hudFrameBorder = 3
+	var err error
+	hudFrameTex, err = assets.LoadTextureInMemPngImg(newHudFrameImg(16, hudFrameBorder), nil)
+	if err != nil {
+		logging.ErrLog.Println("Failed to create HUD frame texture. Err:", err)
+	}
+
+	// The frame is 9-sliced so its border stays thin however big the panel is
+	frameSprite := gameui.NewSlicedSprite(&hudFrameTex, 0, 0, hudFrameTex.Width, hudFrameTex.Height, gameui.SpriteMargins{
+		Left:   hudFrameBorder,
+		Top:    hudFrameBorder,
+		Right:  hudFrameBorder,
+		Bottom: hudFrameBorder,
+	})
+
+	panel := gameui.NewPanel(
+		gameui.Anchor_BottomLeft.Layout(gglm.NewVec2(10, 10), gglm.NewVec2(200, 68)),
+		gameui.SkinState{Sprite: frameSprite, Color: color.NewLinear(1, 1, 1)},
 	)
+	panel.Name = "HUD Panel"
+	panel.Padding = 10
+	panel.Stack = gameui.StackDirection_Vertical
+	panel.StackSpacing = 8
+
+	buttonSkin := gameui.NewColorSkin(gameui.Sprite{}, color.FromSrgb8(60, 110, 200, 255))
+	dayNightButton := gameui.NewButton(gameui.Anchor_StretchTop.Layout(gglm.NewVec2(0, 0), gglm.NewVec2(0, 20)), buttonSkin, "Day-Night Cycle", nil, func() {
+		enableDayNightCycle = !enableDayNightCycle
+		if !enableDayNightCycle {
+			skyboxMat.SetUnifVec3("skyTint", &gglm.Vec3{Data: [3]float32{1, 1, 1}})
+		}
+	})
 
-	assert.T(pointLightDepthMapFbo.IsComplete(), "Point light depth map fbo is not complete after init")
+	trackSkin := gameui.NewColorSkin(gameui.Sprite{}, color.FromSrgb8(50, 50, 60, 255))
+	handleSkin := gameui.NewColorSkin(gameui.Sprite{}, color.FromSrgb8(220, 220, 220, 255))
+	hudExposureSlider = gameui.NewSlider(gameui.Anchor_StretchTop.Layout(gglm.NewVec2(0, 0), gglm.NewVec2(0, 20)), trackSkin, handleSkin, 0, 5, hdrExposure)
+	hudExposureSlider.OnChanged = func(v float32) {
+		hdrExposure = v
+		tonemappedScreenQuadMat.SetUnifFloat32("exposure", hdrExposure)
+	}
 
-	// Spot light depth map fbo
-	spotLightDepthMapFbo = buffers.NewFramebuffer(1024, 1024)
-	spotLightDepthMapFbo.SetNoColorBuffer()
-	spotLightDepthMapFbo.NewDepthTextureArrayAttachment(
-		buffers.FramebufferAttachmentDataFormat_DepthF32,
-		MaxSpotLights,
-	)
+	panel.Add(dayNightButton, hudExposureSlider)
+	gameHud.Add(panel)
+}
 
-	assert.T(spotLightDepthMapFbo.IsComplete(), "Spot light depth map fbo is not complete after init")
+// gameHudSystem updates the HUD before the game so dragging a HUD slider doesn't also move the camera,
+// and draws it as an overlay so exposure doesn't change its colors
+type gameHudSystem struct {
+	g *Game
+}
 
-	// Hdr fbo
-	hdrFbo = buffers.NewFramebuffer(uint32(g.WinWidth), uint32(g.WinHeight))
-	hdrFbo.NewColorAttachment(
-		buffers.FramebufferAttachmentType_Texture,
-		buffers.FramebufferAttachmentDataFormat_RGBAF16,
-	)
+var (
+	_ engine.PreUpdater      = &gameHudSystem{}
+	_ engine.OverlayRenderer = &gameHudSystem{}
+)
 
-	hdrFbo.NewDepthStencilAttachment(
-		buffers.FramebufferAttachmentType_Renderbuffer,
-		buffers.FramebufferAttachmentDataFormat_Depth24Stencil8,
-	)
+func (hs *gameHudSystem) Name() string {
+	return "Game HUD"
+}
 
-	assert.T(hdrFbo.IsComplete(), "Hdr fbo is not complete after init")
+func (hs *gameHudSystem) PreUpdate() {
+	if showGameHud {
+		gameHud.Update(hs.g.WinWidth, hs.g.WinHeight)
+	}
 }
 
-// applyLightUpdates updates materials and light ubo using
-// data from the game's light structs
-func (g *Game) applyLightUpdates() {
+func (hs *gameHudSystem) RenderOverlay(fbWidth, fbHeight int32) {
+	if showGameHud {
+		gameHud.Render(fbWidth, fbHeight)
+	}
+}
 
-	// Directional light
-	lightsUboData.DirLight = DirLightUboData(dirLight)
-	whiteMat.ShadowMapTex1 = dirLightDepthMapFbo.Attachments[0].Id
-	containerMat.ShadowMapTex1 = dirLightDepthMapFbo.Attachments[0].Id
-	groundMat.ShadowMapTex1 = dirLightDepthMapFbo.Attachments[0].Id
-	palleteMat.ShadowMapTex1 = dirLightDepthMapFbo.Attachments[0].Id
+func initAssetBrowser() {
 
-	// Point lights
-	for i := 0; i < len(pointLights); i++ {
+	assetBrowser.AddCachedTextures()
 
-		p := &pointLights[i]
-		lightsUboData.PointLights[i] = PointLightUboData(*p)
+	assetBrowser.AddMaterial(&whiteMat)
+	assetBrowser.AddMaterial(&containerMat)
+	assetBrowser.AddMaterial(&groundMat)
+	assetBrowser.AddMaterial(&palleteMat)
+	for i := range pbrMats {
+		assetBrowser.AddMaterial(&pbrMats[i])
 	}
 
-	whiteMat.CubemapArrayTex = pointLightDepthMapFbo.Attachments[0].Id
-	containerMat.CubemapArrayTex = pointLightDepthMapFbo.Attachments[0].Id
-	groundMat.CubemapArrayTex = pointLightDepthMapFbo.Attachments[0].Id
-	palleteMat.CubemapArrayTex = pointLightDepthMapFbo.Attachments[0].Id
+	assetBrowser.AddMesh(&cubeMesh)
+	assetBrowser.AddMesh(&sphereMesh)
+	assetBrowser.AddMesh(&chairMesh)
 
-	// Spotlights
-	for i := 0; i < len(spotLights); i++ {
-
-		l := &spotLights[i]
-		innerCutoffCos := l.InnerCutoffCos()
-		outerCutoffCos := l.OuterCutoffCos()
-
-		lightsUboData.SpotLights[i] = SpotLightUboData{
-			Pos:           l.Pos,
-			Dir:           l.Dir,
-			DiffuseColor:  l.DiffuseColor,
-			SpecularColor: l.SpecularColor,
-			InnerCutoff:   innerCutoffCos,
-			OuterCutoff:   outerCutoffCos,
+	// Entities have no mesh or material components yet, so instantiating only creates an entity named after the asset
+	assetBrowser.OnInstantiate = func(a *editor.AssetEntry, screenPos imgui.Vec2) {
+		instantiateAsset(a, 0)
+	}
+
+	hierarchyPanel.OnAssetDrop = func(parent registry.Handle) {
+
+		a := assetBrowser.DraggedAsset()
+		if a != nil {
+			instantiateAsset(a, parent)
 		}
 	}
+}
 
-	whiteMat.ShadowMapTexArray1 = spotLightDepthMapFbo.Attachments[0].Id
-	containerMat.ShadowMapTexArray1 = spotLightDepthMapFbo.Attachments[0].Id
-	groundMat.ShadowMapTexArray1 = spotLightDepthMapFbo.Attachments[0].Id
-	palleteMat.ShadowMapTexArray1 = spotLightDepthMapFbo.Attachments[0].Id
+func instantiateAsset(a *editor.AssetEntry, parent registry.Handle) {
 
-	// Apply changes
-	lightsUbo.Bind()
-	lightsUbo.SetStruct(lightsUboData)
+	cc, handle := entities.New()
+	*cc = entity.NewCompContainer()
+
+	sceneHierarchy.Add(handle, a.Name, parent)
+	editorSelection.Select(handle)
 }
 
-func (g *Game) Update() {
+func deleteEntity(handle registry.Handle) {
 
-	if input.IsQuitClicked() || input.KeyClicked(sdl.K_ESCAPE) {
-		engine.Quit()
+	cc := entities.Get(handle)
+	if cc == nil {
+		return
 	}
 
-	g.updateCameraLookAround()
-	g.updateCameraPos()
-
-	globalMatricesUboData.CamPos = cam.Pos
-	updateAllProjViewMats(cam.ProjMat, cam.ViewMat)
+	for i := 0; i < len(cc.Comps); i++ {
+		cc.Comps[i].Destroy()
+	}
 
-	g.showDebugWindow()
+	entities.Free(handle)
 }
 
-func (g *Game) showDebugWindow() {
+func (g *Game) initUbos() {
 
-	imgui.ShowDemoWindow()
+	// Both blocks are rewritten every frame, so they are only layouts and their data lives in the ring buffer
+	globalMatricesUbo = buffers.NewUniformBufferLayoutFor[GlobalMatricesUboData](buffers.BlockLayout_Std140)
 
-	imgui.Begin("Debug controls")
+	groundMat.SetUniformBlockBindingPoint("GlobalMatrices", 0)
+	whiteMat.SetUniformBlockBindingPoint("GlobalMatrices", 0)
+	containerMat.SetUniformBlockBindingPoint("GlobalMatrices", 0)
+	palleteMat.SetUniformBlockBindingPoint("GlobalMatrices", 0)
+	pbrMats[0].SetUniformBlockBindingPoint("GlobalMatrices", 0)
+	gbufferMat.SetUniformBlockBindingPoint("GlobalMatrices", 0)
+	deferred.LightMat.SetUniformBlockBindingPoint("GlobalMatrices", 0)
 
-	imgui.PushStyleColorVec4(imgui.ColText, imgui.NewColor(1, 1, 0, 1).FieldValue)
-	imgui.LabelText("FPS", fmt.Sprint(timing.GetAvgFPS()))
-	imgui.PopStyleColor()
+	lightsUbo = buffers.NewUniformBufferLayoutFor[lights.LightsUboData](buffers.BlockLayout_Std140)
 
-	if len(frameTimesMs) < FRAME_TIME_MS_SAMPLES {
-		frameTimesMs = append(frameTimesMs, timing.DT()*1000)
-	} else {
-		frameTimesMs[frameTimesMsIndex] = timing.DT() * 1000
+	// Misaligned ubo fields silently produce wrong values in the shader, so catch layout mismatches early
+	if err := globalMatricesUbo.ValidateAgainst(&groundMat, "GlobalMatrices"); err != nil {
+		logging.ErrLog.Println(err)
+	}
 
-		frameTimesMsIndex++
-		if frameTimesMsIndex >= len(frameTimesMs) {
-			frameTimesMsIndex = 0
-		}
+	if err := lightsUbo.ValidateAgainst(&groundMat, "Lights"); err != nil {
+		logging.ErrLog.Println(err)
 	}
 
-	imgui.PlotLinesFloatPtrV("Frame Times", frameTimesMs, int32(len(frameTimesMs)), 0, "", 0, 16, imgui.Vec2{Y: 50}, 4)
+	// fmt.Printf("\n==Lights UBO (id=%d)==\nSize=%d\nFields: %+v\n\n", lightsUbo.Id, lightsUbo.Size, lightsUbo.Fields)
 
-	imgui.Spacing()
+	// Also holds the per object matrices of every draw, which take a full uniform offset alignment (usually 256 bytes) each
+	perFrameUboRing = buffers.NewUniformRingBuffer(64*1024, buffers.DefaultUniformRingFramesInFlight)
+	g.Rend.EnablePerObjectUbo(&perFrameUboRing, 2)
 
-	// Camera
-	imgui.Text("Camera")
-	if imgui.DragFloat3("Cam Pos", &cam.Pos.Data) {
+	groundMat.SetUniformBlockBindingPoint("Lights", 1)
+	whiteMat.SetUniformBlockBindingPoint("Lights", 1)
+	containerMat.SetUniformBlockBindingPoint("Lights", 1)
+	palleteMat.SetUniformBlockBindingPoint("Lights", 1)
+	pbrMats[0].SetUniformBlockBindingPoint("Lights", 1)
+	gbufferMat.SetUniformBlockBindingPoint("Lights", 1)
+	deferred.LightMat.SetUniformBlockBindingPoint("Lights", 1)
+
+	groundMat.SetUniformBlockBindingPoint(renderer.PerObjectUboBlockName, 2)
+	whiteMat.SetUniformBlockBindingPoint(renderer.PerObjectUboBlockName, 2)
+	containerMat.SetUniformBlockBindingPoint(renderer.PerObjectUboBlockName, 2)
+	palleteMat.SetUniformBlockBindingPoint(renderer.PerObjectUboBlockName, 2)
+	pbrMats[0].SetUniformBlockBindingPoint(renderer.PerObjectUboBlockName, 2)
+	gbufferMat.SetUniformBlockBindingPoint(renderer.PerObjectUboBlockName, 2)
+
+	fogUbo = buffers.NewUniformBufferLayoutFor[renderer.FogUboData](buffers.BlockLayout_Std140)
+
+	groundMat.SetUniformBlockBindingPoint(renderer.FogUboBlockName, 3)
+	whiteMat.SetUniformBlockBindingPoint(renderer.FogUboBlockName, 3)
+	containerMat.SetUniformBlockBindingPoint(renderer.FogUboBlockName, 3)
+	palleteMat.SetUniformBlockBindingPoint(renderer.FogUboBlockName, 3)
+	pbrMats[0].SetUniformBlockBindingPoint(renderer.FogUboBlockName, 3)
+	skyboxMat.SetUniformBlockBindingPoint(renderer.FogUboBlockName, 3)
+	deferred.LightMat.SetUniformBlockBindingPoint(renderer.FogUboBlockName, 3)
+
+	if err := fogUbo.ValidateAgainst(&groundMat, renderer.FogUboBlockName); err != nil {
+		logging.ErrLog.Println(err)
+	}
+}
+
+func (g *Game) initFbos() {
+
+	// @TODO: Resize window sized fbos on window resize
+
+	// Demo fbo
+	demoFbo = assert.MustGet(buffers.NewFramebuffer(uint32(g.WinWidth), uint32(g.WinHeight)))
+
+	assert.Must(demoFbo.NewColorAttachment(
+		buffers.FramebufferAttachmentType_Texture,
+		buffers.FramebufferAttachmentDataFormat_SRGBA,
+	))
+
+	assert.Must(demoFbo.NewDepthStencilAttachment(
+		buffers.FramebufferAttachmentType_Renderbuffer,
+		buffers.FramebufferAttachmentDataFormat_Depth24Stencil8,
+	))
+
+	assert.T(demoFbo.IsComplete(), "Demo fbo is not complete after init")
+
+	// Shadow map fbos
+	dirLightDepthMapFbo = newDirLightDepthMapFbo(dirLightShadowResolution())
+	pointLightDepthMapFbo = newPointLightDepthMapFbo(pointLightShadowResolution())
+	spotLightDepthMapFbo = newSpotLightDepthMapFbo(spotLightShadowResolution())
+
+	// Hdr fbo
+	fbWidth, fbHeight := g.Win.SDLWin.GLGetDrawableSize()
+	hdrFbo = newHdrFbo(dynRes.ScaledSize(fbWidth, fbHeight))
+
+	// Light probe capture fbo. Faces are drawn one at a time, so the depth buffer is shared by all of them
+	lightProbeCaptureFbo = assert.MustGet(buffers.NewFramebuffer(lightProbeCaptureSize, lightProbeCaptureSize))
+	assert.Must(lightProbeCaptureFbo.NewColorAttachment(
+		buffers.FramebufferAttachmentType_Cubemap,
+		buffers.FramebufferAttachmentDataFormat_RGBAF16,
+	))
+
+	assert.Must(lightProbeCaptureFbo.NewDepthStencilAttachment(
+		buffers.FramebufferAttachmentType_Renderbuffer,
+		buffers.FramebufferAttachmentDataFormat_Depth24Stencil8,
+	))
+
+	assert.T(lightProbeCaptureFbo.IsComplete(), "Light probe capture fbo is not complete after init")
+
+	for i := 0; i < len(lightProbeFaces); i++ {
+		lightProbeFaces[i] = make([]float32, lightProbeCaptureSize*lightProbeCaptureSize*4)
+	}
+}
+
+// hdrFboColorName is the name of the color attachment of the hdr fbo (see buffers.Framebuffer.Attach)
+const hdrFboColorName = "hdr"
+
+func newHdrFbo(width, height int32) buffers.Framebuffer {
+
+	fbo := assert.MustGet(buffers.NewFramebuffer(uint32(width), uint32(height)))
+	assert.Must(fbo.Attach(
+		hdrFboColorName,
+		buffers.FramebufferAttachmentType_Texture,
+		buffers.FramebufferAttachmentDataFormat_RGBAF16,
+	))
+
+	assert.Must(fbo.Attach(
+		"depth",
+		buffers.FramebufferAttachmentType_Renderbuffer,
+		buffers.FramebufferAttachmentDataFormat_Depth24Stencil8,
+	))
+
+	assert.T(fbo.IsComplete(), "Hdr fbo is not complete after init")
+	return fbo
+}
+
+// updateHdrFboSize reallocates the hdr fbo when the window size or the render scale changed
+func (g *Game) updateHdrFboSize() {
+
+	fbWidth, fbHeight := g.Win.SDLWin.GLGetDrawableSize()
+	width, height := dynRes.ScaledSize(fbWidth, fbHeight)
+	if uint32(width) == hdrFbo.Width && uint32(height) == hdrFbo.Height {
+		return
+	}
+
+	hdrFbo.Delete()
+	hdrFbo = newHdrFbo(width, height)
+}
+
+func newDirLightDepthMapFbo(resolution uint32) buffers.Framebuffer {
+
+	fbo := assert.MustGet(buffers.NewFramebuffer(resolution, resolution))
+	assert.Must(fbo.SetNoColorBuffer())
+	assert.Must(fbo.NewDepthAttachment(
+		buffers.FramebufferAttachmentType_Texture,
+		buffers.FramebufferAttachmentDataFormat_DepthF32,
+	))
+
+	assert.T(fbo.IsComplete(), "Depth map fbo is not complete after init")
+	return fbo
+}
+
+func newPointLightDepthMapFbo(resolution uint32) buffers.Framebuffer {
+
+	fbo := assert.MustGet(buffers.NewFramebuffer(resolution, resolution))
+	assert.Must(fbo.SetNoColorBuffer())
+	assert.Must(fbo.NewDepthCubemapArrayAttachment(
+		buffers.FramebufferAttachmentDataFormat_DepthF32,
+		lights.MaxPointLights,
+	))
+
+	assert.T(fbo.IsComplete(), "Point light depth map fbo is not complete after init")
+	return fbo
+}
+
+func newSpotLightDepthMapFbo(resolution uint32) buffers.Framebuffer {
+
+	fbo := assert.MustGet(buffers.NewFramebuffer(resolution, resolution))
+	assert.Must(fbo.SetNoColorBuffer())
+	assert.Must(fbo.NewDepthTextureArrayAttachment(
+		buffers.FramebufferAttachmentDataFormat_DepthF32,
+		lights.MaxSpotLights,
+	))
+
+	assert.T(fbo.IsComplete(), "Spot light depth map fbo is not complete after init")
+	return fbo
+}
+
+// pointLightShadowResolution returns the largest shadow resolution of all point lights, since they share one shadow map array
+func pointLightShadowResolution() uint32 {
+
+	var res uint32 = 1
+	for _, p := range lights.PointLightComps() {
+		res = max(res, p.Shadow.Resolution)
+	}
+
+	return res
+}
+
+// spotLightShadowResolution returns the largest shadow resolution of all spot lights, since they share one shadow map array
+func spotLightShadowResolution() uint32 {
+
+	var res uint32 = 1
+	for _, s := range lights.SpotLightComps() {
+		res = max(res, s.Shadow.Resolution)
+	}
+
+	return res
+}
+
+// dirLightShadowResolution returns the shadow resolution of the directional light, or 1 if there is none
+func dirLightShadowResolution() uint32 {
+
+	dirLights := lights.DirLightComps()
+	if len(dirLights) == 0 {
+		return 1
+	}
+
+	return max(1, dirLights[0].Shadow.Resolution)
+}
+
+// updateShadowMapSizes recreates shadow map fbos whose light resolution changed
+func updateShadowMapSizes() {
+
+	// New fbos have no shadows in them, so cached maps are drawn again
+	if res := dirLightShadowResolution(); res != dirLightDepthMapFbo.Width {
+		dirLightDepthMapFbo.Delete()
+		dirLightDepthMapFbo = newDirLightDepthMapFbo(res)
+
+		for _, d := range lights.DirLightComps() {
+			d.ShadowCache().Invalidate()
+		}
+	}
+
+	if res := pointLightShadowResolution(); res != pointLightDepthMapFbo.Width {
+		pointLightDepthMapFbo.Delete()
+		pointLightDepthMapFbo = newPointLightDepthMapFbo(res)
+		lightManager.PointShadowLayers.FreeAll()
+	}
+
+	if res := spotLightShadowResolution(); res != spotLightDepthMapFbo.Width {
+		spotLightDepthMapFbo.Delete()
+		spotLightDepthMapFbo = newSpotLightDepthMapFbo(res)
+		clear(spotShadowLayerOwners[:])
+	}
+}
+
+// applyLightUpdates updates the shadow maps used by materials after light settings change.
+// The light ubo data itself is filled by the light manager every frame
+func (g *Game) applyLightUpdates() {
+
+	updateShadowMapSizes()
+
+	// Directional light
+	whiteMat.ShadowMapTex1 = dirLightDepthMapFbo.DepthTex()
+	containerMat.ShadowMapTex1 = dirLightDepthMapFbo.DepthTex()
+	groundMat.ShadowMapTex1 = dirLightDepthMapFbo.DepthTex()
+	palleteMat.ShadowMapTex1 = dirLightDepthMapFbo.DepthTex()
+	lightMarkerMat.ShadowMapTex1 = dirLightDepthMapFbo.DepthTex()
+	deferred.LightMat.ShadowMapTex1 = dirLightDepthMapFbo.DepthTex()
+	for i := range pbrMats {
+		pbrMats[i].ShadowMapTex1 = dirLightDepthMapFbo.DepthTex()
+	}
+
+	// Point lights
+	whiteMat.CubemapArrayTex = pointLightDepthMapFbo.DepthTex()
+	containerMat.CubemapArrayTex = pointLightDepthMapFbo.DepthTex()
+	groundMat.CubemapArrayTex = pointLightDepthMapFbo.DepthTex()
+	palleteMat.CubemapArrayTex = pointLightDepthMapFbo.DepthTex()
+	lightMarkerMat.CubemapArrayTex = pointLightDepthMapFbo.DepthTex()
+	deferred.LightMat.CubemapArrayTex = pointLightDepthMapFbo.DepthTex()
+	for i := range pbrMats {
+		pbrMats[i].CubemapArrayTex = pointLightDepthMapFbo.DepthTex()
+	}
+
+	// Spotlights
+	whiteMat.ShadowMapTexArray1 = spotLightDepthMapFbo.DepthTex()
+	containerMat.ShadowMapTexArray1 = spotLightDepthMapFbo.DepthTex()
+	groundMat.ShadowMapTexArray1 = spotLightDepthMapFbo.DepthTex()
+	palleteMat.ShadowMapTexArray1 = spotLightDepthMapFbo.DepthTex()
+	lightMarkerMat.ShadowMapTexArray1 = spotLightDepthMapFbo.DepthTex()
+	deferred.LightMat.ShadowMapTexArray1 = spotLightDepthMapFbo.DepthTex()
+	for i := range pbrMats {
+		pbrMats[i].ShadowMapTexArray1 = spotLightDepthMapFbo.DepthTex()
+	}
+}
+
+func setSpotLightCookieSamplers(m *materials.Material) {
+	for i := 0; i < materials.MaxSpotLightCookies; i++ {
+		m.SetUnifInt32("spotLightCookies["+strconv.Itoa(i)+"]", int32(materials.TextureSlot_SpotLightCookie0)+int32(i))
+	}
+}
+
+func setLtcTextures(m *materials.Material) {
+	m.LtcMatTex = ltcMatTex.TexID
+	m.LtcAmpTex = ltcAmpTex.TexID
+	m.SetUnifInt32("ltcMat", int32(materials.TextureSlot_LtcMat))
+	m.SetUnifInt32("ltcAmp", int32(materials.TextureSlot_LtcAmp))
+}
+
+// applySpotLightCookies gives materials the cookies of the visible spot lights.
+// Runs every frame because which spot light uses which ubo index changes with culling
+func applySpotLightCookies() {
+
+	for i := 0; i < materials.MaxSpotLightCookies; i++ {
+
+		var cookieTex uint32
+		if i < len(lightManager.VisibleSpotLights) {
+			cookieTex = lightManager.VisibleSpotLights[i].CookieTex
+		}
+
+		whiteMat.SpotLightCookieTexs[i] = cookieTex
+		containerMat.SpotLightCookieTexs[i] = cookieTex
+		groundMat.SpotLightCookieTexs[i] = cookieTex
+		palleteMat.SpotLightCookieTexs[i] = cookieTex
+		lightMarkerMat.SpotLightCookieTexs[i] = cookieTex
+		deferred.LightMat.SpotLightCookieTexs[i] = cookieTex
+		for j := range pbrMats {
+			pbrMats[j].SpotLightCookieTexs[i] = cookieTex
+		}
+	}
+}
+
+// newWindowCookieImg creates a white square image split into panes by black bars, which looks like light coming through a window
+func newWindowCookieImg(size, panes int) *image.RGBA {
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	paneSize := size / panes
+	barHalfWidth := max(1, size/64)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+
+			distToBarX := min(x%paneSize, paneSize-x%paneSize)
+			distToBarY := min(y%paneSize, paneSize-y%paneSize)
+			if distToBarX < barHalfWidth || distToBarY < barHalfWidth {
+				img.Set(x, y, imgColor.RGBA{A: 255})
+				continue
+			}
+
+			img.Set(x, y, imgColor.RGBA{R: 255, G: 255, B: 255, A: 255})
+		}
+	}
+
+	return img
+}
+
+// newHudFrameImg creates a dark translucent square with a light border, used as a 9-slice sprite by the HUD
+// newFlareImg returns a white circle that fades out from its center
+func newFlareImg(size int) *image.RGBA {
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	half := float32(size) / 2
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+
+			dx := (float32(x) + 0.5 - half) / half
+			dy := (float32(y) + 0.5 - half) / half
+			falloff := max(1-(dx*dx+dy*dy), 0)
+			img.Set(x, y, imgColor.RGBA{R: 255, G: 255, B: 255, A: uint8(falloff * falloff * 255)})
+		}
+	}
+
+	return img
+}
+
+func newHudFrameImg(size, border int) *image.RGBA {
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+
+			if x < border || y < border || x >= size-border || y >= size-border {
+				img.Set(x, y, imgColor.RGBA{R: 120, G: 130, B: 160, A: 255})
+				continue
+			}
+
+			img.Set(x, y, imgColor.RGBA{R: 20, G: 20, B: 30, A: 200})
+		}
+	}
+
+	return img
+}
+
+// shadowSettingsUi shows the shadow settings of a light in a tree node, and returns true if any of them changed
+func shadowSettingsUi(label string, ss *lights.ShadowSettings) bool {
+
+	if !imgui.TreeNodeExStrV(label, imgui.TreeNodeFlagsSpanAvailWidth) {
+		return false
+	}
+
+	changed := editor.Inspect(ss)
+
+	imgui.TreePop()
+	return changed
+}
+
+func (g *Game) Update() {
+
+	defer timing.BeginScope("Update").End()
+
+	if input.IsQuitClicked() || input.KeyClicked(sdl.K_ESCAPE) {
+		engine.Quit()
+	}
+
+	// Ctrl+Z and Ctrl+Y undo and redo editor edits, unless text is being typed
+	if !imgui.CurrentIO().WantTextInput() && (input.KeyDown(sdl.K_LCTRL) || input.KeyDown(sdl.K_RCTRL)) {
+		if input.KeyClicked(sdl.K_z) {
+			editor.Commands.Undo()
+		} else if input.KeyClicked(sdl.K_y) {
+			editor.Commands.Redo()
+		}
+	}
+
+	shaderWatcher.Update()
+
+	// The camera ignores the time scale so it can still be moved while the game is paused or slowed down
+	camController.Update(&cam, timing.UnscaledDT())
+
+	globalMatricesUboData.CamPos = cam.Pos
+	updateAllProjViewMats(cam.ProjMat, cam.ViewMat)
+	audio.SetListenerFromCamera(&cam)
+
+	g.showDebugWindow()
+
+	if enableDayNightCycle {
+		g.updateDayNightCycle()
+	}
+
+	// After the debug window so light edits show in the same frame
+	lightManager.Update(&cam)
+	applySpotLightCookies()
+}
+
+func (g *Game) updateDayNightCycle() {
+
+	timeOfDay.Update(timing.DT())
+
+	if dirLight := lightManager.DirLight; dirLight != nil {
+
+		timeOfDay.Apply(&dirLight.DirLight, &lightManager)
+
+		// Keep the shadow projection looking at the scene center from the light's side
+		dirLight.ShadowPos = *dirLight.Dir.Clone().Scale(-15)
+	}
+
+	state := timeOfDay.State()
+	fog.Color = state.FogColor
+
+	skyTint := state.SkyTint.Vec3()
+	skyboxMat.SetUnifVec3("skyTint", &skyTint)
+}
+
+func (g *Game) showDebugWindow() {
+
+	// Panels can be docked to the edges of the window, while the center stays see through to show the scene
+	imgui.DockSpaceOverViewportV(imgui.MainViewport(), imgui.DockNodeFlagsPassthruCentralNode, dockspaceWindowClass)
+
+	imgui.ShowDemoWindow()
+	logConsole.Draw("Console")
+	hierarchyPanel.Draw("Hierarchy")
+	inspectorPanel.Draw("Inspector")
+	assetBrowser.Draw("Assets")
+
+	imgui.Begin("Debug controls")
+
+	showPerfHud := engine.IsPerfHudShown()
+	if imgui.Checkbox("Perf HUD", &showPerfHud) {
+		engine.ShowPerfHud(showPerfHud)
+	}
+
+	isPaused := timing.IsPaused()
+	if imgui.Checkbox("Pause", &isPaused) {
+		if isPaused {
+			timing.Pause()
+		} else {
+			timing.Resume()
+		}
+	}
+
+	imgui.SameLine()
+	timeScale := timing.TimeScale()
+	if imgui.SliderFloat("Time Scale", &timeScale, 0, 2) {
+		timing.SetTimeScale(timeScale)
+	}
+
+	imgui.Text(fmt.Sprintf("Refresh Rate: %dHz", window.RefreshRate()))
+
+	imgui.BeginDisabledV(!editor.Commands.CanUndo())
+	if imgui.Button("Undo " + editor.Commands.UndoName() + "###undo") {
+		editor.Commands.Undo()
+	}
+	imgui.EndDisabled()
+
+	imgui.SameLine()
+	imgui.BeginDisabledV(!editor.Commands.CanRedo())
+	if imgui.Button("Redo " + editor.Commands.RedoName() + "###redo") {
+		editor.Commands.Redo()
+	}
+	imgui.EndDisabled()
+
+	if imgui.TreeNodeExStrV("Displays", imgui.TreeNodeFlagsSpanAvailWidth) {
+
+		displays, err := engine.GetDisplays()
+		if err != nil {
+			imgui.Text(err.Error())
+		}
+
+		currDisplayIndex, _ := window.DisplayIndex()
+		for i := 0; i < len(displays); i++ {
+
+			d := &displays[i]
+			imgui.Text(fmt.Sprintf("%d: %s %dx%d at (%d, %d), %dHz, DPI scaling %.2f", d.Index, d.Name, d.Bounds.W, d.Bounds.H, d.Bounds.X, d.Bounds.Y, d.RefreshRate, d.DpiScaling))
+
+			if d.Index == currDisplayIndex {
+				continue
+			}
+
+			imgui.SameLine()
+			if imgui.Button("Move Here##" + strconv.Itoa(d.Index)) {
+				if err := window.MoveToDisplay(d.Index); err != nil {
+					logging.ErrLog.Println(err)
+				}
+			}
+		}
+
+		imgui.TreePop()
+	}
+
+	vsyncMode := int32(engine.GetVSyncMode())
+	if imgui.ComboStr("VSync", &vsyncMode, "Off\x00On\x00Adaptive\x00") {
+		engine.SetVSyncMode(engine.VSyncMode(vsyncMode))
+	}
+
+	framePacing := int32(engine.GetFramePacing())
+	if imgui.ComboStr("Frame Pacing", &framePacing, "None\x00Refresh Rate\x00Target FPS\x00") {
+		engine.SetFramePacing(engine.FramePacing(framePacing))
+	}
+
+	if engine.GetFramePacing() == engine.FramePacing_TargetFps {
+		targetFps := engine.GetTargetFps()
+		if imgui.DragIntV("Target FPS", &targetFps, 1, 10, 500, "%d", imgui.SliderFlagsNone) {
+			engine.SetTargetFps(targetFps)
+		}
+	}
+
+	// Rebuilding the fonts every frame while dragging would be slow, so the scale is applied when the drag ends
+	imgui.SliderFloatV("UI Scale", &uiScale, 0.5, 3, "%.2f", imgui.SliderFlagsAlwaysClamp)
+	if imgui.IsItemDeactivatedAfterEdit() {
+		g.ImGUIInfo.SetUIScale(uiScale)
+	}
+
+	imgui.Spacing()
+
+	// Camera
+	imgui.Text("Camera")
+	if imgui.DragFloat3("Cam Pos", &cam.Pos.Data) {
 		cam.Update()
 		updateAllProjViewMats(cam.ProjMat, cam.ViewMat)
 	}
 	if imgui.DragFloat3("Cam Forward", &cam.Forward.Data) {
 		cam.Update()
+		camController.SyncFromCamera(&cam)
 		updateAllProjViewMats(cam.ProjMat, cam.ViewMat)
 	}
 
@@ -942,8 +1602,82 @@ func (g *Game) showDebugWindow() {
 
 	imgui.Text("HDR")
 	imgui.Checkbox("Enable HDR", &hdrRendering)
+	imgui.Checkbox("Deferred Rendering", &deferredRendering)
+	if deferredRendering {
+		imgui.DragIntV("Max Deferred Lights", &deferred.MaxLights, 1, 0, 4096, "%d", imgui.SliderFlagsNone)
+		if imgui.DragIntV("Light Swarm", &lightSwarmSize, 1, 0, 1000, "%d", imgui.SliderFlagsNone) {
+			setLightSwarmSize(int(lightSwarmSize))
+		}
+	}
 	if imgui.DragFloatV("Exposure", &hdrExposure, 0.1, -10, 100, "%.3f", imgui.SliderFlagsNone) {
 		tonemappedScreenQuadMat.SetUnifFloat32("exposure", hdrExposure)
+		hudExposureSlider.Value = hdrExposure
+	}
+
+	imgui.Checkbox("Bloom", &bloomEnabled)
+	if bloomEnabled {
+		imgui.DragFloatV("Bloom Threshold", &bloom.Threshold, 0.01, 0, 20, "%.2f", imgui.SliderFlagsNone)
+		imgui.DragFloatV("Bloom Knee", &bloom.Knee, 0.01, 0, 5, "%.2f", imgui.SliderFlagsNone)
+		imgui.DragFloatV("Bloom Intensity", &bloom.Intensity, 0.01, 0, 5, "%.2f", imgui.SliderFlagsNone)
+		imgui.DragFloatV("Bloom Radius", &bloom.Radius, 0.01, 0.5, 4, "%.2f", imgui.SliderFlagsNone)
+	}
+
+	imgui.DragFloatV("Render Scale", &dynRes.Scale, 0.01, dynRes.MinScale, dynRes.MaxScale, "%.2f", imgui.SliderFlagsNone)
+
+	upsampleMode := int32(dynRes.Upsample)
+	if imgui.ComboStr("Upsample", &upsampleMode, "Bilinear\x00FSR1\x00") {
+		dynRes.Upsample = renderer.UpsampleMode(upsampleMode)
+	}
+
+	if dynRes.Upsample == renderer.UpsampleMode_Fsr1 {
+		imgui.SliderFloat("Sharpness", &dynRes.Sharpness, 0, 1)
+	}
+
+	imgui.Checkbox("Auto Render Scale", &dynRes.AutoAdjust)
+	if dynRes.AutoAdjust {
+
+		targetFps := float32(time.Second) / float32(dynRes.TargetFrameTime)
+		if imgui.DragFloatV("Target FPS", &targetFps, 1, 10, 500, "%.0f", imgui.SliderFlagsNone) {
+			dynRes.SetTargetFps(targetFps)
+		}
+	}
+
+	imgui.Spacing()
+
+	// Day-night cycle
+	imgui.Text("Day-Night Cycle")
+
+	if imgui.Checkbox("Enable Day-Night Cycle", &enableDayNightCycle) && !enableDayNightCycle {
+		skyboxMat.SetUnifVec3("skyTint", &gglm.Vec3{Data: [3]float32{1, 1, 1}})
+	}
+
+	hour := timeOfDay.Hour()
+	if imgui.SliderFloat("Hour", &hour, 0, lights.HoursPerDay) {
+		timeOfDay.SetHour(hour)
+	}
+
+	imgui.DragFloatV("Day Length (secs)", &timeOfDay.DayLengthSecs, 1, 0, 3600, "%.1f", imgui.SliderFlagsNone)
+	imgui.Checkbox("Pause Time", &timeOfDay.Paused)
+	imgui.Text(fmt.Sprintf("Day %d, street lights on: %v", timeOfDay.Days(), streetLightsOn))
+
+	imgui.Spacing()
+
+	// Skybox. The sky is part of what light probes capture, so they are captured again to match it
+	if imgui.TreeNodeExStrV("Skybox", imgui.TreeNodeFlagsSpanAvailWidth) {
+
+		if editor.Inspect(&skyboxSettings) {
+			skyboxSettings.Apply(&skyboxMat)
+			lightProbeCaptureFace = 0
+			iblDirty = true
+		}
+
+		imgui.TreePop()
+	}
+
+	// Fog
+	if imgui.TreeNodeExStrV("Fog", imgui.TreeNodeFlagsSpanAvailWidth) {
+		editor.Inspect(&fog)
+		imgui.TreePop()
 	}
 
 	imgui.Spacing()
@@ -956,40 +1690,60 @@ func (g *Game) showDebugWindow() {
 	// Ambient light
 	imgui.Text("Ambient Light")
 
-	if imgui.ColorEdit3("Ambient Color", &lightsUboData.AmbientColor.Data) {
+	if nmageimgui.ColorEdit3("Ambient Color", &lightManager.AmbientColor) {
 		updateLights = true
 	}
 
 	imgui.Spacing()
 
+	// Light probes
+	imgui.Text("Light Probes")
+
+	if imgui.Checkbox("Use Light Probes", &useLightProbes) {
+		if useLightProbes {
+			g.Rend.SetAmbientSampler(&lightProbeGrid)
+		} else {
+			g.Rend.SetAmbientSampler(nil)
+		}
+	}
+
+	// Captured probes light the scene of later captures, so recapturing also adds a bounce of indirect light
+	if imgui.Button("Recapture Light Probes") {
+		lightProbeCaptureFace = 0
+	}
+
+	imgui.Text(fmt.Sprintf("Captured: %d/%d", min(lightProbeCaptureFace/6, len(lightProbeGrid.Probes)), len(lightProbeGrid.Probes)))
+
+	imgui.Spacing()
+
 	// Directional light
 	imgui.Text("Directional Light")
 
 	imgui.Checkbox("Render Directional Light Shadows", &renderDirLightShadows)
 
-	if imgui.DragFloat3("Direction", &dirLight.Dir.Data) {
-		updateLights = true
-	}
+	if dirLight := lightManager.DirLight; dirLight != nil {
 
-	if imgui.ColorEdit3("Diffuse Color", &dirLight.DiffuseColor.Data) {
-		updateLights = true
-	}
+		if imgui.DragFloat3("Direction", &dirLight.Dir.Data) {
+			updateLights = true
+		}
+
+		if nmageimgui.ColorEdit3("Diffuse Color", &dirLight.DiffuseColor) {
+			updateLights = true
+		}
 
-	if imgui.ColorEdit3("Specular Color", &dirLight.SpecularColor.Data) {
-		updateLights = true
-	}
+		if nmageimgui.ColorEdit3("Specular Color", &dirLight.SpecularColor) {
+			updateLights = true
+		}
 
-	if imgui.DragFloat3("dPos", &dirLightPos.Data) {
-		updateLights = true
-	}
-	if imgui.DragFloat("dSize", &dirLightSize) {
-		updateLights = true
-	}
-	if imgui.DragFloat("dNear", &dirLightNear) {
-		updateLights = true
-	}
-	if imgui.DragFloat("dFar", &dirLightFar) {
-		updateLights = true
+		if imgui.DragFloat3("dPos", &dirLight.ShadowPos.Data) {
+			updateLights = true
+		}
+		if imgui.DragFloat("dSize", &dirLight.ShadowSize) {
+			updateLights = true
+		}
+		if shadowSettingsUi("Dir Light Shadow", &dirLight.Shadow) {
+			updateLights = true
+		}
 	}
 
 	imgui.Spacing()
@@ -1008,11 +1762,13 @@ func (g *Game) showDebugWindow() {
 
 	// Point lights
 	imgui.Checkbox("Render Point Light Shadows", &renderPointLightShadows)
+	imgui.Checkbox("Disable Light Culling", &lightManager.DisableCulling)
+	imgui.Text(fmt.Sprintf("Visible Lights: %d point, %d spot, %d area", len(lightManager.VisiblePointLights), len(lightManager.VisibleSpotLights), len(lightManager.VisibleAreaLights)))
+
 	if imgui.BeginListBoxV("Point Lights", imgui.Vec2{Y: 200}) {
 
-		for i := 0; i < len(pointLights); i++ {
+		for i, pl := range lights.PointLightComps() {
 
-			pl := &pointLights[i]
 			indexNumString := strconv.Itoa(i)
 
 			if !imgui.TreeNodeExStrV("Point Light "+indexNumString, imgui.TreeNodeFlagsSpanAvailWidth) {
@@ -1023,11 +1779,11 @@ func (g *Game) showDebugWindow() {
 				updateLights = true
 			}
 
-			if imgui.ColorEdit3("Diffuse Color", &pl.DiffuseColor.Data) {
+			if nmageimgui.ColorEdit3("Diffuse Color", &pl.DiffuseColor) {
 				updateLights = true
 			}
 
-			if imgui.ColorEdit3("Specular Color", &pl.SpecularColor.Data) {
+			if nmageimgui.ColorEdit3("Specular Color", &pl.SpecularColor) {
 				updateLights = true
 			}
 
@@ -1037,10 +1793,10 @@ func (g *Game) showDebugWindow() {
 
 			if imgui.DragFloatV("Radius", &pl.Radius, 0.2, 0, 500, "%.3f", imgui.SliderFlagsNone) {
 				updateLights = true
-				pl.FarPlane = pl.Radius * pointLightRadiusToFarPlaneRatio
+				pl.Shadow.FarPlane = pl.Radius * pointLightRadiusToFarPlaneRatio
 			}
 
-			if imgui.DragFloatV("Max Bias", &pl.MaxBias, 0.01, 0, 10, "%.3f", imgui.SliderFlagsNone) {
+			if shadowSettingsUi("Shadow", &pl.Shadow) {
 				updateLights = true
 			}
 
@@ -1055,9 +1811,8 @@ func (g *Game) showDebugWindow() {
 
 	if imgui.BeginListBoxV("Spot Lights", imgui.Vec2{Y: 200}) {
 
-		for i := 0; i < len(spotLights); i++ {
+		for i, l := range lights.SpotLightComps() {
 
-			l := &spotLights[i]
 			indexNumString := strconv.Itoa(i)
 
 			if !imgui.TreeNodeExStrV("Spot Light "+indexNumString, imgui.TreeNodeFlagsSpanAvailWidth) {
@@ -1072,11 +1827,11 @@ func (g *Game) showDebugWindow() {
 				updateLights = true
 			}
 
-			if imgui.ColorEdit3("Diffuse Color", &l.DiffuseColor.Data) {
+			if nmageimgui.ColorEdit3("Diffuse Color", &l.DiffuseColor) {
 				updateLights = true
 			}
 
-			if imgui.ColorEdit3("Specular Color", &l.SpecularColor.Data) {
+			if nmageimgui.ColorEdit3("Specular Color", &l.SpecularColor) {
 				updateLights = true
 			}
 
@@ -1094,8 +1849,56 @@ func (g *Game) showDebugWindow() {
 				updateLights = true
 			}
 
-			imgui.DragFloat("Spot Near Plane", &l.NearPlane)
-			imgui.DragFloat("Spot Far Plane", &l.FarPlane)
+			if imgui.DragFloatV("Range", &l.Range, 0.2, 0, 500, "%.3f", imgui.SliderFlagsNone) {
+				updateLights = true
+			}
+
+			hasCookie := l.CookieTex != 0
+			if imgui.Checkbox("Window Cookie", &hasCookie) {
+
+				l.CookieTex = 0
+				if hasCookie {
+					l.CookieTex = spotLightCookieTex.TexID
+				}
+			}
+
+			if shadowSettingsUi("Shadow", &l.Shadow) {
+				updateLights = true
+			}
+
+			imgui.TreePop()
+		}
+
+		imgui.EndListBox()
+	}
+
+	// Area lights
+	if imgui.BeginListBoxV("Area Lights", imgui.Vec2{Y: 200}) {
+
+		for i, l := range lights.AreaLightComps() {
+
+			indexNumString := strconv.Itoa(i)
+
+			if !imgui.TreeNodeExStrV("Area Light "+indexNumString, imgui.TreeNodeFlagsSpanAvailWidth) {
+				continue
+			}
+
+			imgui.DragFloat3("Pos", &l.Pos.Data)
+
+			if imgui.DragFloat3("Right", &l.Right.Data) {
+				l.Right.Normalize()
+			}
+
+			if imgui.DragFloat3("Up", &l.Up.Data) {
+				l.Up.Normalize()
+			}
+
+			imgui.DragFloatV("Width", &l.Width, 0.05, 0, 100, "%.3f", imgui.SliderFlagsNone)
+			imgui.DragFloatV("Height", &l.Height, 0.05, 0, 100, "%.3f", imgui.SliderFlagsNone)
+			nmageimgui.ColorEdit3("Diffuse Color", &l.DiffuseColor)
+			nmageimgui.ColorEdit3("Specular Color", &l.SpecularColor)
+			imgui.Checkbox("Two Sided", &l.TwoSided)
+			imgui.DragFloatV("Range", &l.Range, 0.2, 0, 500, "%.3f", imgui.SliderFlagsNone)
 
 			imgui.TreePop()
 		}
@@ -1122,72 +1925,76 @@ func (g *Game) showDebugWindow() {
 	// Other
 	imgui.Text("Other Settings")
 
+	imgui.Checkbox("Show game HUD", &showGameHud)
 	imgui.Checkbox("Render skybox", &renderSkybox)
-	imgui.Checkbox("Render to back buffer", &renderToBackBuffer)
-	imgui.Checkbox("Render depth buffer", &renderDepthBuffer)
-
-	imgui.End()
-}
-
-func (g *Game) updateCameraLookAround() {
+	imgui.Checkbox("Render light flares", &renderLightFlares)
+	imgui.Checkbox("Render viewmodel", &renderViewmodel)
 
-	mouseX, mouseY := input.GetMouseMotion()
-	if (mouseX == 0 && mouseY == 0) || !input.MouseDown(sdl.BUTTON_RIGHT) {
-		return
+	viewmodelFovDeg := viewmodel.Fov * gglm.Rad2Deg
+	if imgui.SliderFloat("Viewmodel FOV", &viewmodelFovDeg, 30, 110) {
+		viewmodel.Fov = viewmodelFovDeg * gglm.Deg2Rad
 	}
 
-	const MAX_MOUSE_MOVE = 300
-	mouseX = gglm.Clamp(mouseX, -MAX_MOUSE_MOVE, MAX_MOUSE_MOVE)
-	mouseY = gglm.Clamp(mouseY, -MAX_MOUSE_MOVE, MAX_MOUSE_MOVE)
-
-	// Yaw
-	yaw += float32(mouseX) * camRotSpeed * timing.DT()
-
-	// Pitch
-	pitch += float32(-mouseY) * camRotSpeed * timing.DT()
-	if pitch > 1.5 {
-		pitch = 1.5
+	viewmodelDepthModeIndex := int32(viewmodel.DepthMode)
+	if imgui.ComboStr("Viewmodel Depth", &viewmodelDepthModeIndex, "Depth Range\x00Clear Depth\x00") {
+		viewmodel.DepthMode = renderer.ViewmodelDepthMode(viewmodelDepthModeIndex)
 	}
+	imgui.Checkbox("Render to back buffer", &renderToBackBuffer)
+	imgui.Checkbox("Render depth buffer", &renderDepthBuffer)
 
-	if pitch < -1.5 {
-		pitch = -1.5
+	debugViewIndex := int32(debugView)
+	if imgui.ComboStr("Debug View", &debugViewIndex, "None\x00Normals\x00Light Count\x00") {
+		debugView = renderer.DebugView(debugViewIndex)
+		for _, mat := range []*materials.Material{&whiteMat, &containerMat, &groundMat, &palleteMat, &pbrMats[0]} {
+			mat.SetUnifInt32(renderer.DebugViewUniformName, int32(debugView))
+		}
 	}
 
-	cam.UpdateRotation(pitch, yaw)
-}
+	if leakcheck.IsEnabled() {
+		imgui.Text(fmt.Sprintf("Live GPU Objects: %d", leakcheck.LiveCount(leakcheck.ResourceType_Unknown)))
+	}
 
-func (g *Game) updateCameraPos() {
+	// Profiling
+	if timing.IsTracing() {
 
-	update := false
+		if imgui.Button("Stop and Export Trace") {
 
-	var camSpeedScale float32 = 1.0
-	if input.KeyDown(sdl.K_LSHIFT) {
-		camSpeedScale = 2
+			timing.StopTrace()
+			if err := timing.ExportTrace("nmage-trace.json"); err != nil {
+				logging.ErrLog.Println(err)
+			} else {
+				logging.InfoLog.Println("Wrote trace to nmage-trace.json. Open it with chrome://tracing or https://ui.perfetto.dev")
+			}
+		}
+	} else if imgui.Button("Start Trace") {
+		timing.ClearTrace()
+		timing.StartTrace()
 	}
 
-	// Forward and backward
-	if input.KeyDown(sdl.K_w) {
-		cam.Pos.Add(cam.Forward.Clone().Scale(camMoveSpeed * camSpeedScale * timing.DT()))
-		update = true
-	} else if input.KeyDown(sdl.K_s) {
-		cam.Pos.Add(cam.Forward.Clone().Scale(-camMoveSpeed * camSpeedScale * timing.DT()))
-		update = true
+	if imgui.Button("Export Frame Times") {
+		if err := timing.ExportFrameHistory("nmage-frame-times.csv"); err != nil {
+			logging.ErrLog.Println(err)
+		} else {
+			logging.InfoLog.Println("Wrote frame times to nmage-frame-times.csv")
+		}
 	}
 
-	// Left and right
-	if input.KeyDown(sdl.K_d) {
-		cross := gglm.Cross(&cam.Forward, &cam.WorldUp)
-		cam.Pos.Add(cross.Normalize().Scale(camMoveSpeed * camSpeedScale * timing.DT()))
-		update = true
-	} else if input.KeyDown(sdl.K_a) {
-		cross := gglm.Cross(&cam.Forward, &cam.WorldUp)
-		cam.Pos.Add(cross.Normalize().Scale(-camMoveSpeed * camSpeedScale * timing.DT()))
-		update = true
-	}
+	if engine.IsRenderDocLoaded() {
 
-	if update {
-		cam.Update()
+		imgui.Text(fmt.Sprintf("RenderDoc Captures: %d", engine.RenderDocCaptureCount()))
+		if imgui.Button("Capture Frame") {
+			engine.TriggerCapture()
+		}
+
+		imgui.SameLine()
+		if imgui.Button("Open RenderDoc") {
+			if err := engine.LaunchRenderDocUI(); err != nil {
+				logging.ErrLog.Println(err)
+			}
+		}
 	}
+
+	imgui.End()
 }
 
 var (
@@ -1201,25 +2008,66 @@ var (
 
 func (g *Game) Render() {
 
-	globalMatricesUbo.Bind()
-	globalMatricesUbo.SetStruct(globalMatricesUboData)
+	defer timing.BeginScope("Render").End()
+
+	perFrameUboRing.BeginFrame()
+	perFrameUboRing.Bind()
+	perFrameUboRing.BindRange(0, perFrameUboRing.SetStruct(&globalMatricesUbo, &globalMatricesUboData))
+	perFrameUboRing.BindRange(1, perFrameUboRing.SetStruct(&lightsUbo, &lightManager.UboData))
+
+	fogUboData := fog.ToUboData()
+	perFrameUboRing.BindRange(3, perFrameUboRing.SetStruct(&fogUbo, &fogUboData))
 
 	rotatingCubeTrMat1.Rotate(rotatingCubeSpeedDeg1*gglm.Deg2Rad*timing.DT(), 0, 1, 0)
 	rotatingCubeTrMat2.Rotate(rotatingCubeSpeedDeg2*gglm.Deg2Rad*timing.DT(), 1, 1, 0)
 	rotatingCubeTrMat3.Rotate(rotatingCubeSpeedDeg3*gglm.Deg2Rad*timing.DT(), 1, 1, 1)
 
-	if renderDirLightShadows {
+	shadowsScope := timing.BeginScope("Shadows")
+	gpuScopes.Begin("Shadows")
+
+	if renderDirLightShadows && lightManager.DirLight != nil && lightManager.DirLight.Shadow.Enabled {
 		g.renderDirectionalLightShadowmap()
 	}
 
 	if renderSpotLightShadows {
 		g.renderSpotLightShadowmaps()
+	} else {
+		// Spot light matrices are needed by cookies even when spot shadows aren't rendered
+		setSpotLightProjViewMats(false)
 	}
 
 	if renderPointLightShadows {
 		g.renderPointLightShadowmaps()
 	}
 
+	gpuScopes.End()
+	shadowsScope.End()
+
+	if iblDirty {
+
+		iblScope := timing.BeginScope("IBL Bake")
+		gpuScopes.Begin("IBL Bake")
+
+		g.bakeIbl()
+
+		gpuScopes.End()
+		iblScope.End()
+	}
+
+	if lightProbeCaptureFace < len(lightProbeGrid.Probes)*6 {
+
+		probeScope := timing.BeginScope("Light Probe Capture")
+		gpuScopes.Begin("Light Probe Capture")
+
+		g.captureLightProbeFace()
+
+		gpuScopes.End()
+		probeScope.End()
+	}
+
+	sceneScope := timing.BeginScope("Scene")
+	gpuScopes.Begin("Scene")
+
 	if renderToBackBuffer {
 
 		if renderDepthBuffer {
@@ -1232,43 +2080,79 @@ func (g *Game) Render() {
 			if renderSkybox {
 				g.DrawSkybox()
 			}
+
+			if renderViewmodel {
+				g.DrawViewmodel()
+			}
+
+			if renderLightFlares {
+				g.DrawLightFlares()
+			}
 		}
 	}
 
 	if renderToDemoFbo {
 		g.renderDemoFbo()
 	}
+
+	gpuScopes.End()
+	sceneScope.End()
+
+	perFrameUboRing.EndFrame()
 }
 
 func (g *Game) renderDirectionalLightShadowmap() {
 
-	// Set some uniforms
+	dirLight := lightManager.DirLight
 	dirLightProjViewMat := dirLight.GetProjViewMat()
+	shouldDraw := dirLight.ShadowCache().ShouldDraw(&dirLight.Shadow, &dirLightProjViewMat)
 
+	// A cached map is looked up with the projection it was drawn with
+	dirLightProjViewMat = *dirLight.ShadowCache().ProjViewMat()
 	whiteMat.SetUnifMat4("dirLightProjViewMat", &dirLightProjViewMat)
 	containerMat.SetUnifMat4("dirLightProjViewMat", &dirLightProjViewMat)
 	groundMat.SetUnifMat4("dirLightProjViewMat", &dirLightProjViewMat)
 	palleteMat.SetUnifMat4("dirLightProjViewMat", &dirLightProjViewMat)
+	pbrMats[0].SetUnifMat4("dirLightProjViewMat", &dirLightProjViewMat)
+	deferred.LightMat.SetUnifMat4("dirLightProjViewMat", &dirLightProjViewMat)
 
-	depthMapMat.SetUnifMat4("projViewMat", &dirLightProjViewMat)
+	if shouldDraw {
 
-	// Start rendering
-	dirLightDepthMapFbo.BindWithViewport()
-	dirLightDepthMapFbo.Clear()
+		depthMapMat.SetUnifMat4("projViewMat", &dirLightProjViewMat)
 
-	// Culling front faces helps 'peter panning' when
-	// drawing shadow maps, but works only for solids with a back face (i.e. quads won't cast shadows).
-	// Check more here: https://learnopengl.com/Advanced-Lighting/Shadows/Shadow-Mapping
-	//
-	// Some note that this is too troublesome and fails in many cases. Might be better to remove.
-	gl.CullFace(gl.FRONT)
-	g.RenderScene(&depthMapMat)
-	gl.CullFace(gl.BACK)
+		g.Rend.PushViewport(0, 0, int32(dirLightDepthMapFbo.Width), int32(dirLightDepthMapFbo.Height))
+
+		passMode := renderer.ShadowPassMode_All
+		if dirLight.Shadow.CacheStatic {
+
+			staticFbo := staticShadowFbo(&dirLightStaticDepthMapFbo, &dirLightDepthMapFbo, newDirLightDepthMapFbo)
+			if dirLight.ShadowCache().ShouldDrawStatic(&dirLight.Shadow, &dirLightProjViewMat) {
+
+				staticFbo.Bind()
+				staticFbo.Clear()
+
+				beginShadowPass(g.Rend, &dirLight.Shadow, renderer.ShadowPassMode_Static)
+				g.RenderScene(&depthMapMat)
+				endShadowPass(g.Rend, &dirLight.Shadow)
+			}
 
-	dirLightDepthMapFbo.UnBindWithViewport(uint32(g.WinWidth), uint32(g.WinHeight))
+			dirLightDepthMapFbo.CopyDepthLayersFrom(staticFbo, 0, 1)
+			passMode = renderer.ShadowPassMode_Dynamic
+		} else {
+			dirLightDepthMapFbo.Bind()
+			dirLightDepthMapFbo.Clear()
+		}
+
+		beginShadowPass(g.Rend, &dirLight.Shadow, passMode)
+		g.RenderScene(&depthMapMat)
+		endShadowPass(g.Rend, &dirLight.Shadow)
+
+		dirLightDepthMapFbo.UnBind()
+		g.Rend.PopViewport()
+	}
 
 	if showDirLightDepthMapFbo {
-		screenQuadMat.DiffuseTex = dirLightDepthMapFbo.Attachments[0].Id
+		screenQuadMat.DiffuseTex = dirLightDepthMapFbo.DepthTex()
 		screenQuadMat.SetUnifVec2("offset", &dirLightDepthMapFboOffset)
 		screenQuadMat.SetUnifVec2("scale", &dirLightDepthMapFboScale)
 		screenQuadMat.Bind()
@@ -1276,62 +2160,291 @@ func (g *Game) renderDirectionalLightShadowmap() {
 	}
 }
 
-func (g *Game) renderSpotLightShadowmaps() {
+// setSpotLightProjViewMats sets the spot light matrices used to draw and look up the shadow map layers and cookies.
+// With useShadowCache, lights with shadows use the projection their cached map was drawn with
+func setSpotLightProjViewMats(useShadowCache bool) {
 
-	for i := 0; i < len(spotLights); i++ {
+	// Shadow map layers match the light indices in the lights ubo
+	for i, l := range lightManager.VisibleSpotLights {
 
-		l := &spotLights[i]
 		indexStr := strconv.Itoa(i)
 		projViewMatIndexStr := "spotLightProjViewMats[" + indexStr + "]"
 
 		// Set render uniforms
 		projViewMat := l.GetProjViewMat()
+		if useShadowCache && l.Shadow.Enabled {
+			projViewMat = *l.ShadowCache().ProjViewMat()
+		}
 
 		whiteMat.SetUnifMat4(projViewMatIndexStr, &projViewMat)
 		containerMat.SetUnifMat4(projViewMatIndexStr, &projViewMat)
 		groundMat.SetUnifMat4(projViewMatIndexStr, &projViewMat)
 		palleteMat.SetUnifMat4(projViewMatIndexStr, &projViewMat)
+		pbrMats[0].SetUnifMat4(projViewMatIndexStr, &projViewMat)
+		deferred.LightMat.SetUnifMat4(projViewMatIndexStr, &projViewMat)
 
 		// Set depth uniforms
 		arrayDepthMapMat.SetUnifMat4("projViewMats["+indexStr+"]", &projViewMat)
 	}
+}
+
+// beginShadowPass sets the polygon offset and face culling of a light's shadow map pass, and makes the renderer only draw
+// the shadow casters of the pass mode
+func beginShadowPass(rend renderer.Render, ss *lights.ShadowSettings, mode renderer.ShadowPassMode) {
+
+	rend.SetShadowPass(mode)
+
+	if ss.PolygonOffsetFactor != 0 || ss.PolygonOffsetUnits != 0 {
+		gl.Enable(gl.POLYGON_OFFSET_FILL)
+		gl.PolygonOffset(ss.PolygonOffsetFactor, ss.PolygonOffsetUnits)
+	}
+
+	if ss.CullFrontFaces {
+		gl.CullFace(gl.FRONT)
+	}
+}
+
+// endShadowPass restores the state changed by beginShadowPass
+func endShadowPass(rend renderer.Render, ss *lights.ShadowSettings) {
+
+	rend.SetShadowPass(renderer.ShadowPassMode_None)
+
+	if ss.PolygonOffsetFactor != 0 || ss.PolygonOffsetUnits != 0 {
+		gl.Disable(gl.POLYGON_OFFSET_FILL)
+		gl.PolygonOffset(0, 0)
+	}
+
+	if ss.CullFrontFaces {
+		gl.CullFace(gl.BACK)
+	}
+}
+
+// staticShadowFbo returns the fbo with the cached static casters of a shadow map, which is created with newFbo the first time
+// a light caches its static casters and again when the shadow map is resized
+func staticShadowFbo(staticFbo, fbo *buffers.Framebuffer, newFbo func(resolution uint32) buffers.Framebuffer) *buffers.Framebuffer {
+
+	if staticFbo.Id == 0 || staticFbo.Width != fbo.Width {
+		staticFbo.Delete()
+		*staticFbo = newFbo(fbo.Width)
+	}
+
+	return staticFbo
+}
+
+// spotLightsShadowPassSettings returns the largest polygon offset of the shadow casting spot lights,
+// and only culls front faces or caches static casters if all of them ask for it
+func spotLightsShadowPassSettings() lights.ShadowSettings {
+
+	var passSettings lights.ShadowSettings
+	hasCaster := false
+	for _, l := range lightManager.VisibleSpotLights {
+
+		if !l.Shadow.Enabled {
+			continue
+		}
+
+		passSettings.PolygonOffsetFactor = max(passSettings.PolygonOffsetFactor, l.Shadow.PolygonOffsetFactor)
+		passSettings.PolygonOffsetUnits = max(passSettings.PolygonOffsetUnits, l.Shadow.PolygonOffsetUnits)
+		passSettings.CullFrontFaces = l.Shadow.CullFrontFaces && (passSettings.CullFrontFaces || !hasCaster)
+		passSettings.CacheStatic = l.Shadow.CacheStatic && (passSettings.CacheStatic || !hasCaster)
+		hasCaster = true
+	}
+
+	return passSettings
+}
+
+func (g *Game) renderSpotLightShadowmaps() {
+
+	// All layers are drawn in one pass, so the pass runs when any light needs its map drawn. A light whose layer belonged
+	// to another light has the other light's shadows in its layer, so its cached maps are drawn again
+	shouldDraw := false
+	for i, l := range lightManager.VisibleSpotLights {
+
+		if !l.Shadow.Enabled {
+			continue
+		}
+
+		if spotShadowLayerOwners[i] != l {
+			l.ShadowCache().Invalidate()
+			spotShadowLayerOwners[i] = l
+		}
+
+		projViewMat := l.GetProjViewMat()
+		if l.ShadowCache().ShouldDraw(&l.Shadow, &projViewMat) {
+			shouldDraw = true
+		}
+	}
+
+	// A cached map is looked up with the projection it was drawn with, and when the pass runs the layers of lights that
+	// didn't need drawing are drawn again with that projection too
+	setSpotLightProjViewMats(true)
+
+	if !shouldDraw {
+		return
+	}
+
+	g.Rend.PushViewport(0, 0, int32(spotLightDepthMapFbo.Width), int32(spotLightDepthMapFbo.Height))
+
+	// All spot lights are drawn in one pass, so they share the pass settings
+	passSettings := spotLightsShadowPassSettings()
+	passMode := renderer.ShadowPassMode_All
+	if passSettings.CacheStatic {
+
+		// Every light is asked so they all remember what their static map was drawn with
+		shouldDrawStatic := false
+		for _, l := range lightManager.VisibleSpotLights {
+
+			if l.Shadow.Enabled && l.ShadowCache().ShouldDrawStatic(&l.Shadow, l.ShadowCache().ProjViewMat()) {
+				shouldDrawStatic = true
+			}
+		}
 
-	// Render
-	spotLightDepthMapFbo.BindWithViewport()
-	spotLightDepthMapFbo.Clear()
+		staticFbo := staticShadowFbo(&spotLightStaticDepthMapFbo, &spotLightDepthMapFbo, newSpotLightDepthMapFbo)
+		if shouldDrawStatic {
 
-	// Front culling created issues
-	// gl.CullFace(gl.FRONT)
+			staticFbo.Bind()
+			staticFbo.Clear()
+
+			beginShadowPass(g.Rend, &passSettings, renderer.ShadowPassMode_Static)
+			g.RenderScene(&arrayDepthMapMat)
+			endShadowPass(g.Rend, &passSettings)
+		}
+
+		spotLightDepthMapFbo.CopyDepthLayersFrom(staticFbo, 0, lights.MaxSpotLights)
+		passMode = renderer.ShadowPassMode_Dynamic
+	} else {
+		spotLightDepthMapFbo.Bind()
+		spotLightDepthMapFbo.Clear()
+	}
+
+	beginShadowPass(g.Rend, &passSettings, passMode)
 	g.RenderScene(&arrayDepthMapMat)
-	// gl.CullFace(gl.BACK)
+	endShadowPass(g.Rend, &passSettings)
 
-	spotLightDepthMapFbo.UnBindWithViewport(uint32(g.WinWidth), uint32(g.WinHeight))
+	spotLightDepthMapFbo.UnBind()
+	g.Rend.PopViewport()
 }
 
 func (g *Game) renderPointLightShadowmaps() {
 
-	pointLightDepthMapFbo.BindWithViewport()
-	pointLightDepthMapFbo.Clear()
+	pointLightDepthMapFbo.Bind()
+	g.Rend.PushViewport(0, 0, int32(pointLightDepthMapFbo.Width), int32(pointLightDepthMapFbo.Height))
+
+	// Each light draws into the cubemap the light manager gave it, and only the cubemaps being drawn are cleared
+	// so cached ones are kept
+	shadowLayers := &lightManager.PointShadowLayers
+	for _, p := range lightManager.VisiblePointLights {
+
+		layer := shadowLayers.Layer(p)
+		if !p.Shadow.Enabled || layer < 0 {
+			continue
+		}
 
-	for i := 0; i < len(pointLights); i++ {
+		// A new layer has another light's shadows in it, in both the shadow map and the static map
+		if shadowLayers.NeedsDraw(layer) {
+			p.ShadowCache().Invalidate()
+			shadowLayers.MarkDrawn(layer)
+		}
 
-		p := &pointLights[i]
+		projViewMats := p.GetProjViewMats(float32(pointLightDepthMapFbo.Width), float32(pointLightDepthMapFbo.Height))
+		if !p.ShadowCache().ShouldDraw(&p.Shadow, &projViewMats[0]) {
+			continue
+		}
 
 		// Generic uniforms
 		omnidirDepthMapMat.SetUnifVec3("lightPos", &p.Pos)
-		omnidirDepthMapMat.SetUnifInt32("cubemapIndex", int32(i))
-		omnidirDepthMapMat.SetUnifFloat32("farPlane", p.FarPlane)
+		omnidirDepthMapMat.SetUnifInt32("cubemapIndex", layer)
+		omnidirDepthMapMat.SetUnifFloat32("farPlane", p.Shadow.FarPlane)
 
 		// Set projView matrices
-		projViewMats := p.GetProjViewMats(float32(pointLightDepthMapFbo.Width), float32(pointLightDepthMapFbo.Height))
 		for j := 0; j < len(projViewMats); j++ {
 			omnidirDepthMapMat.SetUnifMat4("cubemapProjViewMats["+strconv.Itoa(j)+"]", &projViewMats[j])
 		}
 
+		passMode := renderer.ShadowPassMode_All
+		if p.Shadow.CacheStatic {
+
+			staticFbo := staticShadowFbo(&pointLightStaticDepthMapFbo, &pointLightDepthMapFbo, newPointLightDepthMapFbo)
+			if p.ShadowCache().ShouldDrawStatic(&p.Shadow, &projViewMats[0]) {
+
+				staticFbo.Bind()
+				staticFbo.ClearCubemapArrayCubemap(layer)
+
+				beginShadowPass(g.Rend, &p.Shadow, renderer.ShadowPassMode_Static)
+				g.RenderScene(&omnidirDepthMapMat)
+				endShadowPass(g.Rend, &p.Shadow)
+			}
+
+			pointLightDepthMapFbo.CopyDepthLayersFrom(staticFbo, layer*6, 6)
+			passMode = renderer.ShadowPassMode_Dynamic
+		} else {
+			pointLightDepthMapFbo.Bind()
+			pointLightDepthMapFbo.ClearCubemapArrayCubemap(layer)
+		}
+
+		beginShadowPass(g.Rend, &p.Shadow, passMode)
 		g.RenderScene(&omnidirDepthMapMat)
+		endShadowPass(g.Rend, &p.Shadow)
+	}
+
+	pointLightDepthMapFbo.UnBind()
+	g.Rend.PopViewport()
+}
+
+// captureLightProbeFace renders the scene into the next cubemap face of the probe being captured, and once all six faces are
+// read back the probe gets their spherical harmonics.
+//
+// Captures use the lights visible to the camera, so lights culled at capture time are missing from the probes
+func (g *Game) captureLightProbeFace() {
+
+	probe := &lightProbeGrid.Probes[lightProbeCaptureFace/6]
+	face := int32(lightProbeCaptureFace % 6)
+
+	capturePass := renderer.NewCubemapPass(probe.Pos, 0.1, 100)
+	capturePass.RenderFaces(g.Rend, &lightProbeCaptureFbo, face, 1, func(face int32, viewMat, projMat *gglm.Mat4) {
+
+		globalMatricesUboData.CamPos = probe.Pos
+		updateAllProjViewMats(*projMat, *viewMat)
+		perFrameUboRing.BindRange(0, perFrameUboRing.SetStruct(&globalMatricesUbo, &globalMatricesUboData))
+
+		g.RenderScene(nil)
+		if renderSkybox {
+			g.DrawSkybox()
+		}
+
+		gl.ReadPixels(0, 0, lightProbeCaptureSize, lightProbeCaptureSize, gl.RGBA, gl.FLOAT, gl.Ptr(lightProbeFaces[face]))
+	})
+
+	// Restore the camera for the rest of the frame
+	globalMatricesUboData.CamPos = cam.Pos
+	updateAllProjViewMats(cam.ProjMat, cam.ViewMat)
+	perFrameUboRing.BindRange(0, perFrameUboRing.SetStruct(&globalMatricesUbo, &globalMatricesUboData))
+
+	if face == 5 {
+		probe.SH = lights.ProjectCubemapToSH(&lightProbeFaces, lightProbeCaptureSize)
+		probe.IsCaptured = true
+	}
+
+	lightProbeCaptureFace++
+}
+
+// bakeIbl regenerates iblMaps from the skybox and gives them to the PBR materials
+func (g *Game) bakeIbl() {
+
+	iblDirty = false
+	iblMaps.Delete()
+
+	settings := ibl.DefaultSettings()
+	maps, err := ibl.Generate(g.Rend, skyboxCmap.TexID, &skyboxSettings, &settings)
+	if err != nil {
+		logging.ErrLog.Println("Failed to bake image based lighting. Err:", err)
 	}
 
-	pointLightDepthMapFbo.UnBindWithViewport(uint32(g.WinWidth), uint32(g.WinHeight))
+	// Failed bakes give zero maps, so the materials fall back to the ambient light
+	iblMaps = maps
+	for i := range pbrMats {
+		iblMaps.Apply(&pbrMats[i])
+	}
 }
 
 func (g *Game) renderDemoFbo() {
@@ -1351,7 +2464,7 @@ func (g *Game) renderDemoFbo() {
 
 	demoFbo.UnBind()
 
-	screenQuadMat.DiffuseTex = demoFbo.Attachments[0].Id
+	screenQuadMat.DiffuseTex = demoFbo.ColorTex(0)
 	screenQuadMat.SetUnifVec2("offset", &demoFboOffset)
 	screenQuadMat.SetUnifVec2("scale", &demoFboScale)
 
@@ -1360,31 +2473,101 @@ func (g *Game) renderDemoFbo() {
 
 func (g *Game) renderHdrFbo() {
 
+	g.updateHdrFboSize()
+
 	hdrFbo.Bind()
+	g.Rend.PushViewport(0, 0, int32(hdrFbo.Width), int32(hdrFbo.Height))
 	hdrFbo.Clear()
 
-	g.RenderScene(nil)
+	if deferredRendering {
+		g.renderDeferred()
+	} else {
+		g.RenderScene(nil)
+	}
 
 	if renderSkybox {
 		g.DrawSkybox()
 	}
 
+	if renderViewmodel {
+		g.DrawViewmodel()
+	}
+
+	if renderLightFlares {
+		g.DrawLightFlares()
+	}
+
 	hdrFbo.UnBind()
+	g.Rend.PopViewport()
 
-	tonemappedScreenQuadMat.DiffuseTex = hdrFbo.Attachments[0].Id
+	if bloomEnabled {
+		bloom.Render(g.Rend, hdrFbo.Tex(hdrFboColorName), int32(hdrFbo.Width), int32(hdrFbo.Height))
+		tonemappedScreenQuadMat.SpecularTex = bloom.TexID()
+		tonemappedScreenQuadMat.SetUnifFloat32("bloomIntensity", bloom.Intensity)
+	} else {
+		tonemappedScreenQuadMat.SpecularTex = assets.DefaultBlackTexId.TexID
+		tonemappedScreenQuadMat.SetUnifFloat32("bloomIntensity", 0)
+	}
+
+	tonemappedScreenQuadMat.SetUnifInt32("upsampleMode", int32(dynRes.Upsample))
+	tonemappedScreenQuadMat.SetUnifFloat32("sharpness", dynRes.Sharpness)
+	tonemappedScreenQuadMat.DiffuseTex = hdrFbo.Tex(hdrFboColorName)
 	g.Rend.DrawVertexArray(&tonemappedScreenQuadMat, &screenQuadVao, 0, 6)
 }
 
+// renderDeferred draws the scene into the bound hdr fbo with deferred lighting, then the objects
+// without G-buffer materials forward on top
+func (g *Game) renderDeferred() {
+
+	deferred.Resize(int32(hdrFbo.Width), int32(hdrFbo.Height))
+
+	deferred.BeginGeometry(g.Rend)
+	g.renderScenePass(nil, scenePass_GBuffer)
+	deferred.EndGeometry(g.Rend)
+
+	// Light binds the hdr fbo again
+	deferred.Light(g.Rend, &lightManager, &cam, &hdrFbo)
+
+	g.renderScenePass(nil, scenePass_Forward)
+}
+
+// scenePass selects the objects drawn by renderScenePass
+type scenePass uint8
+
+const (
+	scenePass_All scenePass = iota
+	// scenePass_GBuffer draws the objects with G-buffer materials using those materials
+	scenePass_GBuffer
+	// scenePass_Forward draws the objects scenePass_GBuffer leaves out
+	scenePass_Forward
+)
+
 func (g *Game) RenderScene(overrideMat *materials.Material) {
+	g.renderScenePass(overrideMat, scenePass_All)
+}
+
+func (g *Game) renderScenePass(overrideMat *materials.Material, pass scenePass) {
+
+	if pass == scenePass_Forward {
+		g.drawPbrSpheres(overrideMat)
+		return
+	}
 
 	tempModelMatrix := *cubeModelMat.Clone()
 
 	// See if we need overrides
-	sunMat := palleteMat
+	sunMat := lightMarkerMat
 	chairMat := palleteMat
 	cubeMat := containerMat
 	groundMat := groundMat
 
+	if pass == scenePass_GBuffer {
+		sunMat = lightMarkerGBufferMat
+		chairMat = palleteGBufferMat
+		cubeMat = containerGBufferMat
+		groundMat = groundGBufferMat
+	}
+
 	if overrideMat != nil {
 		sunMat = *overrideMat
 		chairMat = *overrideMat
@@ -1392,37 +2575,58 @@ func (g *Game) RenderScene(overrideMat *materials.Material) {
 		groundMat = *overrideMat
 	}
 
+	// Light markers sit inside their lights, so they would shadow everything around them
+	markerFlags := renderer.ObjectFlags_NoCastShadows | renderer.ObjectFlags_NoReceiveShadows
+
 	// Draw dir light
 	dirLightTrMat := gglm.NewTrMatId()
-	g.Rend.DrawMesh(&sphereMesh, dirLightTrMat.Translate(0, 10, 0).Scale(0.1, 0.1, 0.1), &sunMat)
+	g.Rend.DrawMeshWithFlags(&sphereMesh, dirLightTrMat.Translate(0, 10, 0).Scale(0.1, 0.1, 0.1), &sunMat, markerFlags)
 
 	// Draw point lights
-	for i := 0; i < len(pointLights); i++ {
+	for _, pl := range lightManager.VisiblePointLights {
 
-		pl := &pointLights[i]
 		plTrMat := gglm.NewTrMatId()
-		g.Rend.DrawMesh(&cubeMesh, plTrMat.TranslateVec(&pl.Pos).Scale(0.1, 0.1, 0.1), &sunMat)
+		g.Rend.DrawMeshWithFlags(&cubeMesh, plTrMat.TranslateVec(&pl.Pos).Scale(0.1, 0.1, 0.1), &sunMat, markerFlags)
+	}
+
+	// Draw area lights as thin panels
+	for _, al := range lightManager.VisibleAreaLights {
+
+		normal := gglm.Cross(&al.Right, &al.Up)
+		alTrMat := gglm.TrMat{
+			Mat4: *gglm.NewMat4Arr(
+				[4]float32{al.Right.X() * al.Width, al.Right.Y() * al.Width, al.Right.Z() * al.Width, 0},
+				[4]float32{al.Up.X() * al.Height, al.Up.Y() * al.Height, al.Up.Z() * al.Height, 0},
+				[4]float32{normal.X() * 0.02, normal.Y() * 0.02, normal.Z() * 0.02, 0},
+				[4]float32{al.Pos.X(), al.Pos.Y(), al.Pos.Z(), 1},
+			),
+		}
+		g.Rend.DrawMeshWithFlags(&cubeMesh, &alTrMat, &sunMat, markerFlags)
 	}
 
-	// Chair
-	g.Rend.DrawMesh(&chairMesh, &tempModelMatrix, &chairMat)
+	// Chair. It never moves, so lights caching static casters only draw its shadows once
+	g.Rend.DrawMeshWithFlags(&chairMesh, &tempModelMatrix, &chairMat, renderer.ObjectFlags_Static)
 
-	// Ground
+	// Ground. Nothing is under it, so it only receives shadows
 	groundTrMat := gglm.NewTrMatId()
-	g.Rend.DrawMesh(&cubeMesh, groundTrMat.Translate(0, -3, 0).Scale(20, 1, 20), &groundMat)
+	g.Rend.DrawMeshWithFlags(&cubeMesh, groundTrMat.Translate(0, -3, 0).Scale(20, 1, 20), &groundMat, renderer.ObjectFlags_NoCastShadows|renderer.ObjectFlags_Static)
 
 	// Cubes
 	tempModelMatrix.Translate(-6, 0, 0)
-	g.Rend.DrawMesh(&cubeMesh, &tempModelMatrix, &cubeMat)
+	g.Rend.DrawMeshWithFlags(&cubeMesh, &tempModelMatrix, &cubeMat, renderer.ObjectFlags_Static)
 
 	tempModelMatrix.Translate(0, -1, -4)
-	g.Rend.DrawMesh(&cubeMesh, &tempModelMatrix, &cubeMat)
+	g.Rend.DrawMeshWithFlags(&cubeMesh, &tempModelMatrix, &cubeMat, renderer.ObjectFlags_Static)
 
 	// Rotating cubes
 	g.Rend.DrawMesh(&cubeMesh, &rotatingCubeTrMat1, &cubeMat)
 	g.Rend.DrawMesh(&cubeMesh, &rotatingCubeTrMat2, &cubeMat)
 	g.Rend.DrawMesh(&cubeMesh, &rotatingCubeTrMat3, &cubeMat)
 
+	if pass == scenePass_All {
+		g.drawPbrSpheres(overrideMat)
+	}
+
 	// Cubes generator
 	// rowSize := 1
 	// for y := 0; y < rowSize; y++ {
@@ -1434,21 +2638,84 @@ func (g *Game) RenderScene(overrideMat *materials.Material) {
 	// }
 }
 
+// drawPbrSpheres draws the PBR spheres, with roughness increasing to the right and the metallic row on top
+func (g *Game) drawPbrSpheres(overrideMat *materials.Material) {
+
+	for i := range pbrMats {
+
+		sphereMat := &pbrMats[i]
+		if overrideMat != nil {
+			sphereMat = overrideMat
+		}
+
+		sphereTrMat := gglm.NewTrMatId()
+		sphereTrMat.Translate(float32(i%pbrSpheresPerRow)*2.5-5, float32(i/pbrSpheresPerRow)*2.5, 8).Scale(0.8, 0.8, 0.8)
+		g.Rend.DrawMeshWithFlags(&sphereMesh, &sphereTrMat, sphereMat, renderer.ObjectFlags_Static)
+	}
+}
+
+// DrawLightFlares draws a glow facing the camera on every visible point light, after the scene so the glow blends over it
+func (g *Game) DrawLightFlares() {
+
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE)
+	gl.DepthMask(false)
+
+	for _, pl := range lightManager.VisiblePointLights {
+
+		flare := renderer.NewBillboard(renderer.BillboardMode_Spherical, pl.Pos, gglm.NewVec2(1, 1))
+		flare.Color = pl.DiffuseColor
+		g.Rend.DrawBillboard(&flare, &flareMat)
+	}
+
+	gl.DepthMask(true)
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+}
+
 func (g *Game) DrawSkybox() {
 
-	gl.Disable(gl.CULL_FACE)
 	gl.DepthFunc(gl.LEQUAL)
-
 	g.Rend.DrawCubemap(&skyboxMesh, &skyboxMat)
-
 	gl.DepthFunc(gl.LESS)
-	gl.Enable(gl.CULL_FACE)
+}
+
+// DrawViewmodel draws a block held in front of the camera with the viewmodel's field of view. It is drawn after the world and
+// skybox so it never clips into walls, and before the flares so they are still hidden by walls when the depth range is remapped
+func (g *Game) DrawViewmodel() {
+
+	globalMatricesUboData.ProjViewMat = viewmodel.ProjViewMat(&cam)
+	perFrameUboRing.BindRange(0, perFrameUboRing.SetStruct(&globalMatricesUbo, &globalMatricesUboData))
+
+	viewmodel.Begin(g.Rend)
+
+	localTrMat := gglm.NewTrMatId()
+	localTrMat.Translate(0.35, -0.3, -0.9).Scale(0.08, 0.08, 0.4)
+	worldTrMat := renderer.ViewmodelWorldMat(&cam, &localTrMat)
+	g.Rend.DrawMeshWithFlags(&cubeMesh, &worldTrMat, &containerMat, renderer.ObjectFlags_NoCastShadows)
+
+	viewmodel.End(g.Rend, &cam)
+
+	// Restore the camera for the rest of the frame
+	updateAllProjViewMats(cam.ProjMat, cam.ViewMat)
+	perFrameUboRing.BindRange(0, perFrameUboRing.SetStruct(&globalMatricesUbo, &globalMatricesUboData))
 }
 
 func (g *Game) FrameEnd() {
+	gpuScopes.Poll()
+
+	// Only the scene is scaled, but shadows are part of the GPU time the scale has to fit in
+	if hdrRendering {
+		dynRes.Update(gpuScopes.LastDurations["Shadows"] + gpuScopes.LastDurations["Scene"])
+	}
 }
 
 func (g *Game) DeInit() {
+	assetManager.UnloadAll()
+	gameHud.Delete()
+	hudFrameTex.Delete()
+	flareTex.Delete()
+	iblMaps.Delete()
+	deferred.Delete()
 	g.Win.Destroy()
 }
 
@@ -1458,6 +2725,8 @@ func updateAllProjViewMats(projMat, viewMat gglm.Mat4) {
 	globalMatricesUboData.ProjViewMat = projViewMat
 
 	unlitMat.SetUnifMat4("projViewMat", &projViewMat)
+	flareMat.SetUnifMat4("viewMat", &viewMat)
+	flareMat.SetUnifMat4("projMat", &projMat)
 	debugDepthMat.SetUnifMat4("projViewMat", &projViewMat)
 
 	// Update skybox projViewMat
@@ -1,12 +1,13 @@
 package materials
 
 import (
+	"fmt"
 	_ "unsafe"
 
 	"github.com/bloeys/gglm/gglm"
 	"github.com/bloeys/nmage/assert"
 	"github.com/bloeys/nmage/assets"
-	"github.com/bloeys/nmage/logging"
+	"github.com/bloeys/nmage/color"
 	"github.com/bloeys/nmage/shaders"
 	"github.com/go-gl/gl/v4.1-core/gl"
 )
@@ -28,10 +29,34 @@ const (
 	TextureSlot_Specular         TextureSlot = 1
 	TextureSlot_Normal           TextureSlot = 2
 	TextureSlot_Emission         TextureSlot = 3
+	TextureSlot_Metallic         TextureSlot = 4
+	TextureSlot_Roughness        TextureSlot = 5
+	TextureSlot_AmbientOcclusion TextureSlot = 6
 	TextureSlot_Cubemap          TextureSlot = 10
 	TextureSlot_Cubemap_Array    TextureSlot = 11
 	TextureSlot_ShadowMap1       TextureSlot = 12
 	TextureSlot_ShadowMap_Array1 TextureSlot = 13
+	TextureSlot_Diffuse_Array    TextureSlot = 14
+
+	// TextureSlot_SpotLightCookie0 is the slot of the first spot light cookie,
+	// and the rest use the slots after it (up to MaxSpotLightCookies slots)
+	TextureSlot_SpotLightCookie0 TextureSlot = 15
+
+	// Area light lookup tables (see lights.LtcLuts)
+	TextureSlot_LtcMat TextureSlot = 19
+	TextureSlot_LtcAmp TextureSlot = 20
+
+	TextureSlot_Lightmap TextureSlot = 21
+
+	// Image based lighting maps (see ibl.Maps)
+	TextureSlot_IrradianceMap TextureSlot = 22
+	TextureSlot_PrefilterMap  TextureSlot = 23
+	TextureSlot_BrdfLut       TextureSlot = 24
+)
+
+const (
+	// MaxSpotLightCookies must match the number of spot lights in the lit shaders
+	MaxSpotLightCookies = 4
 )
 
 type MaterialSettings uint64
@@ -40,6 +65,29 @@ const (
 	MaterialSettings_None        MaterialSettings = iota
 	MaterialSettings_HasModelMtx MaterialSettings = 1 << (iota - 1)
 	MaterialSettings_HasNormalMtx
+	// MaterialSettings_HasPerObjectUbo makes the renderer write the model matrix (and the normal matrix if
+	// MaterialSettings_HasNormalMtx is set) into the 'PerObject' uniform block instead of setting uniforms on every draw
+	MaterialSettings_HasPerObjectUbo
+	// MaterialSettings_BindlessTextures means the diffuse, specular, normal and emission textures are read through
+	// bindless handles (see buffers.TextureHandleBuffer), so Bind doesn't bind them to texture slots
+	MaterialSettings_BindlessTextures
+	// MaterialSettings_AlphaCutout makes Bind set the 'alphaCutoff' float uniform to AlphaCutoff, so the shader discards pixels
+	// whose diffuse alpha is below it (e.g. leaves and fences). Renderers turn on alpha to coverage for these materials when
+	// drawing into a multisampled framebuffer, which antialiases the cut edges
+	MaterialSettings_AlphaCutout
+	// MaterialSettings_TwoSided makes renderers draw both faces of triangles (e.g. leaves, cloth, skyboxes) by turning off face culling
+	// while the material is bound. Lit shaders flip the normals of back faces so they are lit like front faces
+	MaterialSettings_TwoSided
+	// MaterialSettings_Emissive makes Bind set the 'emissiveColor' vec3 and 'emissiveIntensity' float uniforms, which scale the
+	// emission texture. Intensities above one give HDR values that bloom picks up, which is what makes neon and LEDs glow
+	MaterialSettings_Emissive
+	// MaterialSettings_PositionOnly means the shader only needs vertex positions (e.g. depth and shadow materials), so renderers
+	// draw meshes that have a depth stream (see meshes.MeshLoadOptions.DepthStream) with it, and other attributes read as (0, 0, 0, 1)
+	MaterialSettings_PositionOnly
+	// MaterialSettings_Pbr makes Bind bind the metallic, roughness and ambient occlusion textures, and set the 'metallicFactor',
+	// 'roughnessFactor' and 'hasIbl' uniforms of the PBR shader (see res/shaders/pbr.glsl). The diffuse texture is the base color,
+	// while the specular texture and Shininess are unused
+	MaterialSettings_Pbr
 )
 
 func (ms *MaterialSettings) Set(flags MaterialSettings) {
@@ -73,6 +121,27 @@ type Material struct {
 	// Shininess of specular highlights
 	Shininess float32
 
+	// PBR textures, of which only the red channel is used. Only used with MaterialSettings_Pbr
+	MetallicTex         uint32
+	RoughnessTex        uint32
+	AmbientOcclusionTex uint32
+
+	// Metallic and Roughness multiply MetallicTex and RoughnessTex, so materials without textures can use them directly.
+	// Only used with MaterialSettings_Pbr
+	Metallic  float32
+	Roughness float32
+
+	// EmissiveColor and EmissiveIntensity multiply EmissionTex. Only used with MaterialSettings_Emissive.
+	// Surfaces that glow all over can use assets.DefaultWhiteTexId as their EmissionTex
+	EmissiveColor     color.Color
+	EmissiveIntensity float32
+
+	// AlphaCutoff is the diffuse alpha below which pixels are discarded. Only used with MaterialSettings_AlphaCutout, and zero disables it
+	AlphaCutoff float32
+
+	// DiffuseTexArray is an assets.TextureArray used by materials whose instances each pick a diffuse layer
+	DiffuseTexArray uint32
+
 	// Cubemaps
 	CubemapTex      uint32
 	CubemapArrayTex uint32
@@ -80,23 +149,82 @@ type Material struct {
 	// Shadowmaps
 	ShadowMapTex1      uint32
 	ShadowMapTexArray1 uint32
+
+	// SpotLightCookieTexs holds the cookie texture of each spot light, indexed like the spot lights in the lights ubo.
+	// Zero entries are not bound
+	SpotLightCookieTexs [MaxSpotLightCookies]uint32
+
+	// shaderManager and shaderHandle are set for materials made with NewManagedMaterial, whose shader is owned by the manager
+	shaderManager *assets.Manager
+	shaderHandle  assets.Handle[*shaders.ShaderProgram]
+
+	// shaderPath is the file the shader was loaded from, which is empty for materials made from source.
+	// shaderWatcher is set by WatchShader
+	shaderPath    string
+	shaderWatcher *shaders.Watcher
+
+	// LtcMatTex and LtcAmpTex are the lookup tables used to shade area lights
+	LtcMatTex uint32
+	LtcAmpTex uint32
+
+	// IrradianceTex, PrefilterTex and BrdfLutTex light PBR materials with their environment (see ibl.Maps.Apply).
+	// PBR materials without them use the ambient light instead
+	IrradianceTex uint32
+	PrefilterTex  uint32
+	BrdfLutTex    uint32
+
+	// LightmapTex is the baked lighting of the scene (see assets.LightmapAtlas), sampled with the mesh's second uv channel
+	LightmapTex uint32
 }
 
 func (m *Material) Bind() {
 
 	m.ShaderProg.Bind()
 
-	gl.ActiveTexture(uint32(gl.TEXTURE0 + TextureSlot_Diffuse))
-	gl.BindTexture(gl.TEXTURE_2D, m.DiffuseTex)
+	if m.Settings.Has(MaterialSettings_AlphaCutout) {
+		m.SetUnifFloat32("alphaCutoff", m.AlphaCutoff)
+	}
+
+	if m.Settings.Has(MaterialSettings_Emissive) {
+		m.SetUnifColorRGB("emissiveColor", &m.EmissiveColor)
+		m.SetUnifFloat32("emissiveIntensity", m.EmissiveIntensity)
+	}
+
+	if m.Settings.Has(MaterialSettings_Pbr) {
+
+		m.SetUnifFloat32("metallicFactor", m.Metallic)
+		m.SetUnifFloat32("roughnessFactor", m.Roughness)
+
+		hasIbl := int32(0)
+		if m.IrradianceTex != 0 && m.PrefilterTex != 0 && m.BrdfLutTex != 0 {
+			hasIbl = 1
+		}
+		m.SetUnifInt32("hasIbl", hasIbl)
+
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + TextureSlot_Metallic))
+		gl.BindTexture(gl.TEXTURE_2D, m.MetallicTex)
+
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + TextureSlot_Roughness))
+		gl.BindTexture(gl.TEXTURE_2D, m.RoughnessTex)
+
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + TextureSlot_AmbientOcclusion))
+		gl.BindTexture(gl.TEXTURE_2D, m.AmbientOcclusionTex)
+	}
 
-	gl.ActiveTexture(uint32(gl.TEXTURE0 + TextureSlot_Specular))
-	gl.BindTexture(gl.TEXTURE_2D, m.SpecularTex)
+	if !m.Settings.Has(MaterialSettings_BindlessTextures) {
 
-	gl.ActiveTexture(uint32(gl.TEXTURE0 + TextureSlot_Normal))
-	gl.BindTexture(gl.TEXTURE_2D, m.NormalTex)
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + TextureSlot_Diffuse))
+		gl.BindTexture(gl.TEXTURE_2D, m.DiffuseTex)
 
-	gl.ActiveTexture(uint32(gl.TEXTURE0 + TextureSlot_Emission))
-	gl.BindTexture(gl.TEXTURE_2D, m.EmissionTex)
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + TextureSlot_Specular))
+		gl.BindTexture(gl.TEXTURE_2D, m.SpecularTex)
+
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + TextureSlot_Normal))
+		gl.BindTexture(gl.TEXTURE_2D, m.NormalTex)
+
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + TextureSlot_Emission))
+		gl.BindTexture(gl.TEXTURE_2D, m.EmissionTex)
+	}
 
 	// @TODO: Have defaults for these
 	if m.CubemapTex != 0 {
@@ -114,10 +242,55 @@ func (m *Material) Bind() {
 		gl.BindTexture(gl.TEXTURE_2D, m.ShadowMapTex1)
 	}
 
+	if m.DiffuseTexArray != 0 {
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + TextureSlot_Diffuse_Array))
+		gl.BindTexture(gl.TEXTURE_2D_ARRAY, m.DiffuseTexArray)
+	}
+
 	if m.ShadowMapTexArray1 != 0 {
 		gl.ActiveTexture(uint32(gl.TEXTURE0 + TextureSlot_ShadowMap_Array1))
 		gl.BindTexture(gl.TEXTURE_2D_ARRAY, m.ShadowMapTexArray1)
 	}
+
+	for i := 0; i < len(m.SpotLightCookieTexs); i++ {
+
+		if m.SpotLightCookieTexs[i] == 0 {
+			continue
+		}
+
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + TextureSlot_SpotLightCookie0 + TextureSlot(i)))
+		gl.BindTexture(gl.TEXTURE_2D, m.SpotLightCookieTexs[i])
+	}
+
+	if m.LtcMatTex != 0 {
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + TextureSlot_LtcMat))
+		gl.BindTexture(gl.TEXTURE_2D, m.LtcMatTex)
+	}
+
+	if m.LtcAmpTex != 0 {
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + TextureSlot_LtcAmp))
+		gl.BindTexture(gl.TEXTURE_2D, m.LtcAmpTex)
+	}
+
+	if m.LightmapTex != 0 {
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + TextureSlot_Lightmap))
+		gl.BindTexture(gl.TEXTURE_2D, m.LightmapTex)
+	}
+
+	if m.IrradianceTex != 0 {
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + TextureSlot_IrradianceMap))
+		gl.BindTexture(gl.TEXTURE_CUBE_MAP, m.IrradianceTex)
+	}
+
+	if m.PrefilterTex != 0 {
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + TextureSlot_PrefilterMap))
+		gl.BindTexture(gl.TEXTURE_CUBE_MAP, m.PrefilterTex)
+	}
+
+	if m.BrdfLutTex != 0 {
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + TextureSlot_BrdfLut))
+		gl.BindTexture(gl.TEXTURE_2D, m.BrdfLutTex)
+	}
 }
 
 func (m *Material) UnBind() {
@@ -219,6 +392,32 @@ func SetUnifVec4(shaderProgId uint32, unifLoc int32, vec4 *gglm.Vec4) {
 	gl.ProgramUniform4fv(shaderProgId, unifLoc, 1, &vec4.Data[0])
 }
 
+// SetUnifColorRGB sets a vec3 uniform to the linear RGB values of the color
+func (m *Material) SetUnifColorRGB(uniformName string, c *color.Color) {
+	internalSetUnifColorRGB(m.ShaderProg.Id, m.GetUnifLoc(uniformName), c)
+}
+
+//go:noescape
+//go:linkname internalSetUnifColorRGB github.com/bloeys/nmage/materials.SetUnifColorRGB
+func internalSetUnifColorRGB(shaderProgId uint32, unifLoc int32, c *color.Color)
+
+func SetUnifColorRGB(shaderProgId uint32, unifLoc int32, c *color.Color) {
+	gl.ProgramUniform3fv(shaderProgId, unifLoc, 1, &c.Data[0])
+}
+
+// SetUnifColor sets a vec4 uniform to the linear RGBA values of the color
+func (m *Material) SetUnifColor(uniformName string, c *color.Color) {
+	internalSetUnifColor(m.ShaderProg.Id, m.GetUnifLoc(uniformName), c)
+}
+
+//go:noescape
+//go:linkname internalSetUnifColor github.com/bloeys/nmage/materials.SetUnifColor
+func internalSetUnifColor(shaderProgId uint32, unifLoc int32, c *color.Color)
+
+func SetUnifColor(shaderProgId uint32, unifLoc int32, c *color.Color) {
+	gl.ProgramUniform4fv(shaderProgId, unifLoc, 1, &c.Data[0])
+}
+
 func (m *Material) SetUnifMat2(uniformName string, mat2 *gglm.Mat2) {
 	internalSetUnifMat2(m.ShaderProg.Id, m.GetUnifLoc(uniformName), mat2)
 }
@@ -255,8 +454,58 @@ func SetUnifMat4(shaderProgId uint32, unifLoc int32, mat4 *gglm.Mat4) {
 	gl.ProgramUniformMatrix4fv(shaderProgId, unifLoc, 1, false, &mat4.Data[0][0])
 }
 
+// WatchShader makes w reload the material's shader when its file changes, keeping the values of uniforms set on the material.
+// The material must not be moved or copied while watched, since w updates its shader in place, but copies made each frame
+// (e.g. to override materials) see the reloaded shader. Variants (see NewMaterialVariant) must be watched separately.
+//
+// Returns an error for materials made from source, since they have no file to watch
+func (m *Material) WatchShader(w *shaders.Watcher) error {
+
+	if m.shaderPath == "" {
+		return fmt.Errorf("failed to watch shader of material '%s' because it wasn't loaded from a file", m.Name)
+	}
+
+	// Locations may change when the shader is recompiled. The maps are shared with copies of the material, so clearing them clears theirs too
+	unifLocs, attribLocs := m.UnifLocs, m.AttribLocs
+	onReload := func() {
+		clear(unifLocs)
+		clear(attribLocs)
+	}
+
+	if err := w.Watch(m.shaderPath, &m.ShaderProg, onReload); err != nil {
+		return fmt.Errorf("failed to watch shader of material '%s'. Err: %w", m.Name, err)
+	}
+
+	// The manager's copy of a managed shader is deleted on release, so it must also get the reloaded shader
+	if m.shaderManager != nil {
+		if err := w.Watch(m.shaderPath, assets.Get(m.shaderManager, m.shaderHandle), nil); err != nil {
+			return fmt.Errorf("failed to watch shader of material '%s'. Err: %w", m.Name, err)
+		}
+	}
+
+	m.shaderWatcher = w
+	return nil
+}
+
+// Delete deletes the shader of the material, or releases its reference to it for materials made with NewManagedMaterial
 func (m *Material) Delete() {
-	gl.DeleteProgram(m.ShaderProg.Id)
+
+	if m.shaderManager != nil {
+
+		shaderProg := assets.Get(m.shaderManager, m.shaderHandle)
+		if assets.Release(m.shaderManager, m.shaderHandle) && m.shaderWatcher != nil {
+			m.shaderWatcher.Unwatch(shaderProg)
+		}
+
+		m.shaderManager = nil
+	} else {
+		m.ShaderProg.Delete()
+	}
+
+	if m.shaderWatcher != nil {
+		m.shaderWatcher.Unwatch(&m.ShaderProg)
+		m.shaderWatcher = nil
+	}
 }
 
 func getNewMatId() uint32 {
@@ -264,13 +513,39 @@ func getNewMatId() uint32 {
 	return lastMatId
 }
 
-func NewMaterial(matName, shaderPath string) Material {
+// NewMaterial creates a material from the combined shader file at shaderPath, returning an error if the shader fails to load or compile
+func NewMaterial(matName, shaderPath string) (Material, error) {
 
 	shdrProg, err := shaders.LoadAndCompileCombinedShader(shaderPath)
 	if err != nil {
-		logging.ErrLog.Fatalf("Failed to create new material '%s'. Err: %s\n", matName, err.Error())
+		return Material{}, fmt.Errorf("failed to create new material '%s'. Err: %w", matName, err)
 	}
 
+	mat := newMaterialWithProg(matName, shdrProg)
+	mat.shaderPath = shaderPath
+	return mat, nil
+}
+
+// NewManagedMaterial is NewMaterial with the shader loaded through an asset manager (see shaders.LoadManagedCombinedShader),
+// so all materials of a shader file share one compiled program. Each material holds a reference to the shader until it is deleted.
+//
+// Like with NewMaterialVariant, uniforms are per shader, so setting one on a material sets it on all materials of the same file
+func NewManagedMaterial(m *assets.Manager, matName, shaderPath string) (Material, error) {
+
+	shaderHandle, err := shaders.LoadManagedCombinedShader(m, shaderPath)
+	if err != nil {
+		return Material{}, fmt.Errorf("failed to create new material '%s'. Err: %w", matName, err)
+	}
+
+	mat := newMaterialWithProg(matName, *assets.Get(m, shaderHandle))
+	mat.shaderManager = m
+	mat.shaderHandle = shaderHandle
+	mat.shaderPath = shaderPath
+	return mat, nil
+}
+
+func newMaterialWithProg(matName string, shdrProg shaders.ShaderProgram) Material {
+
 	return Material{
 		Id:         getNewMatId(),
 		Name:       matName,
@@ -282,26 +557,70 @@ func NewMaterial(matName, shaderPath string) Material {
 		SpecularTex: assets.DefaultSpecularTexId.TexID,
 		NormalTex:   assets.DefaultNormalTexId.TexID,
 		EmissionTex: assets.DefaultEmissionTexId.TexID,
+
+		// White textures make the factors the final values
+		MetallicTex:         assets.DefaultWhiteTexId.TexID,
+		RoughnessTex:        assets.DefaultWhiteTexId.TexID,
+		AmbientOcclusionTex: assets.DefaultWhiteTexId.TexID,
+		Roughness:           1,
 	}
 }
 
-func NewMaterialSrc(matName string, shaderSrc []byte) Material {
+// NewCutoutDepthMaterial returns a copy of a depth material (e.g. res/shaders/depth-map.glsl) that discards like cutoutMat,
+// for drawing cutout objects into shadow maps. The copy shares the shader of depthMat, so only depthMat should be deleted.
+//
+// Since the shader is shared, depthMat itself should have MaterialSettings_AlphaCutout with a zero AlphaCutoff,
+// so binding it turns the cutout off again. The copy reads UVs, so it never has MaterialSettings_PositionOnly
+func NewCutoutDepthMaterial(depthMat, cutoutMat *Material) Material {
+
+	m := NewMaterialVariant(depthMat, depthMat.Name+" ("+cutoutMat.Name+" cutout)")
+	m.DiffuseTex = cutoutMat.DiffuseTex
+	m.AlphaCutoff = cutoutMat.AlphaCutoff
+	m.Settings.Set(MaterialSettings_AlphaCutout)
+	m.Settings.Remove(MaterialSettings_PositionOnly)
+	return m
+}
+
+// NewGBufferMaterial returns a copy of a G-buffer material (e.g. res/shaders/gbuffer.glsl) with the textures and surface settings
+// of the lit material litMat, for drawing litMat's objects into the G-buffer of a deferred renderer. The copy shares the shader of
+// gbufferMat, so only gbufferMat should be deleted, and like with lit materials of one shader the shininess uniform is shared
+func NewGBufferMaterial(gbufferMat, litMat *Material) Material {
+
+	m := NewMaterialVariant(gbufferMat, gbufferMat.Name+" ("+litMat.Name+")")
+	m.DiffuseTex = litMat.DiffuseTex
+	m.SpecularTex = litMat.SpecularTex
+	m.NormalTex = litMat.NormalTex
+	m.EmissionTex = litMat.EmissionTex
+	m.LightmapTex = litMat.LightmapTex
+	m.Shininess = litMat.Shininess
+	m.EmissiveColor = litMat.EmissiveColor
+	m.EmissiveIntensity = litMat.EmissiveIntensity
+	m.AlphaCutoff = litMat.AlphaCutoff
+
+	const surfaceSettings = MaterialSettings_AlphaCutout | MaterialSettings_TwoSided | MaterialSettings_Emissive
+	m.Settings.Remove(surfaceSettings)
+	m.Settings.Set(litMat.Settings & surfaceSettings)
+	return m
+}
+
+// NewMaterialVariant returns a copy of base with its own Id, so renderers bind it separately, but sharing the shader of base.
+// Uniforms set on either change both, so only what Bind sets from the material's fields (e.g. textures, MaterialSettings_Emissive)
+// can differ between them. Only base should be deleted
+func NewMaterialVariant(base *Material, name string) Material {
+
+	m := *base
+	m.Id = getNewMatId()
+	m.Name = name
+	return m
+}
+
+// NewMaterialSrc is NewMaterial with the combined shader source passed directly
+func NewMaterialSrc(matName string, shaderSrc []byte) (Material, error) {
 
 	shdrProg, err := shaders.LoadAndCompileCombinedShaderSrc(shaderSrc)
 	if err != nil {
-		logging.ErrLog.Fatalf("Failed to create new material '%s'. Err: %s\n", matName, err.Error())
+		return Material{}, fmt.Errorf("failed to create new material '%s'. Err: %w", matName, err)
 	}
 
-	return Material{
-		Id:         getNewMatId(),
-		Name:       matName,
-		ShaderProg: shdrProg,
-		UnifLocs:   make(map[string]int32),
-		AttribLocs: make(map[string]int32),
-
-		DiffuseTex:  assets.DefaultDiffuseTexId.TexID,
-		SpecularTex: assets.DefaultSpecularTexId.TexID,
-		NormalTex:   assets.DefaultNormalTexId.TexID,
-		EmissionTex: assets.DefaultEmissionTexId.TexID,
-	}
+	return newMaterialWithProg(matName, shdrProg), nil
 }
@@ -0,0 +1,375 @@
+package meshes
+
+import (
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/bloeys/assimp-go/asig"
+	"github.com/bloeys/gglm/gglm"
+)
+
+// AoBakeSettings controls ambient occlusion baked by NewMeshWithBakedAo
+type AoBakeSettings struct {
+	// RayCount is how many rays are cast from each vertex. More rays give smoother occlusion but take longer to bake
+	RayCount int
+
+	// MaxDist is how far (in model units) geometry can be and still occlude a vertex
+	MaxDist float32
+
+	// Bias moves ray origins along the vertex normal so rays don't hit the triangles around the vertex
+	Bias float32
+}
+
+// BakeVertexAo ray casts ambient occlusion for every vertex against the given triangles, and returns a value per vertex
+// where 1 is fully unoccluded and 0 is fully occluded.
+//
+// Rays are cosine weighted over the hemisphere around each normal, so occluders in front of the surface count more than ones at grazing angles
+func BakeVertexAo(positions, normals []gglm.Vec3, indices []uint32, settings *AoBakeSettings) []float32 {
+
+	tris := make([]aoTri, len(indices)/3)
+	for i := 0; i < len(tris); i++ {
+		tris[i] = aoTri{
+			toAoVec3(&positions[indices[i*3+0]]),
+			toAoVec3(&positions[indices[i*3+1]]),
+			toAoVec3(&positions[indices[i*3+2]]),
+		}
+	}
+
+	bvh := newAoBvh(tris)
+	rayDirs := cosineHemisphereDirs(settings.RayCount)
+
+	ao := make([]float32, len(positions))
+
+	// Vertices are independent so they are split between cores
+	workerCount := runtime.NumCPU()
+	chunkSize := (len(positions) + workerCount - 1) / workerCount
+
+	wg := sync.WaitGroup{}
+	for start := 0; start < len(positions); start += chunkSize {
+
+		end := min(start+chunkSize, len(positions))
+
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+
+			stack := make([]int32, 0, 64)
+			for i := start; i < end; i++ {
+
+				n := toAoVec3(&normals[i]).normalize()
+				t, b := n.orthonormalBasis()
+				origin := toAoVec3(&positions[i]).add(n.scale(settings.Bias))
+
+				hits := 0
+				for _, d := range rayDirs {
+
+					dir := t.scale(d[0]).add(b.scale(d[1])).add(n.scale(d[2]))
+					if bvh.anyHit(origin, dir, settings.MaxDist, &stack) {
+						hits++
+					}
+				}
+
+				ao[i] = 1 - float32(hits)/float32(len(rayDirs))
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	return ao
+}
+
+// bakeSceneAo bakes the meshes of a scene together so they occlude each other, and returns the ao of each mesh
+func bakeSceneAo(sceneMeshes []*asig.Mesh, settings *AoBakeSettings) [][]float32 {
+
+	var positions []gglm.Vec3
+	var normals []gglm.Vec3
+	var indices []uint32
+	for _, m := range sceneMeshes {
+
+		baseVertex := uint32(len(positions))
+		positions = append(positions, m.Vertices...)
+		normals = append(normals, m.Normals...)
+
+		for _, f := range m.Faces {
+			indices = append(indices, baseVertex+uint32(f.Indices[0]), baseVertex+uint32(f.Indices[1]), baseVertex+uint32(f.Indices[2]))
+		}
+	}
+
+	ao := BakeVertexAo(positions, normals, indices, settings)
+
+	aoPerMesh := make([][]float32, len(sceneMeshes))
+	start := 0
+	for i, m := range sceneMeshes {
+		aoPerMesh[i] = ao[start : start+len(m.Vertices)]
+		start += len(m.Vertices)
+	}
+
+	return aoPerMesh
+}
+
+// cosineHemisphereDirs returns count directions around +z, spread evenly using the hammersley sequence
+func cosineHemisphereDirs(count int) []aoVec3 {
+
+	dirs := make([]aoVec3, count)
+	for i := 0; i < count; i++ {
+
+		u1 := (float64(i) + 0.5) / float64(count)
+		u2 := radicalInverseBase2(uint32(i))
+
+		r := math.Sqrt(u1)
+		phi := 2 * math.Pi * u2
+		dirs[i] = aoVec3{
+			float32(r * math.Cos(phi)),
+			float32(r * math.Sin(phi)),
+			float32(math.Sqrt(max(0, 1-u1))),
+		}
+	}
+
+	return dirs
+}
+
+func radicalInverseBase2(bits uint32) float64 {
+	bits = (bits << 16) | (bits >> 16)
+	bits = ((bits & 0x55555555) << 1) | ((bits & 0xAAAAAAAA) >> 1)
+	bits = ((bits & 0x33333333) << 2) | ((bits & 0xCCCCCCCC) >> 2)
+	bits = ((bits & 0x0F0F0F0F) << 4) | ((bits & 0xF0F0F0F0) >> 4)
+	bits = ((bits & 0x00FF00FF) << 8) | ((bits & 0xFF00FF00) >> 8)
+	return float64(bits) / (1 << 32)
+}
+
+type aoVec3 [3]float32
+
+func toAoVec3(v *gglm.Vec3) aoVec3 {
+	return aoVec3{v.X(), v.Y(), v.Z()}
+}
+
+func (v aoVec3) add(o aoVec3) aoVec3 {
+	return aoVec3{v[0] + o[0], v[1] + o[1], v[2] + o[2]}
+}
+
+func (v aoVec3) sub(o aoVec3) aoVec3 {
+	return aoVec3{v[0] - o[0], v[1] - o[1], v[2] - o[2]}
+}
+
+func (v aoVec3) scale(s float32) aoVec3 {
+	return aoVec3{v[0] * s, v[1] * s, v[2] * s}
+}
+
+func (v aoVec3) dot(o aoVec3) float32 {
+	return v[0]*o[0] + v[1]*o[1] + v[2]*o[2]
+}
+
+func (v aoVec3) cross(o aoVec3) aoVec3 {
+	return aoVec3{
+		v[1]*o[2] - v[2]*o[1],
+		v[2]*o[0] - v[0]*o[2],
+		v[0]*o[1] - v[1]*o[0],
+	}
+}
+
+func (v aoVec3) normalize() aoVec3 {
+
+	l := gglm.Sqrt32(v.dot(v))
+	if l == 0 {
+		return aoVec3{0, 0, 1}
+	}
+
+	return v.scale(1 / l)
+}
+
+// orthonormalBasis returns two unit vectors perpendicular to v and each other. v must be normalized
+func (v aoVec3) orthonormalBasis() (t, b aoVec3) {
+
+	up := aoVec3{0, 1, 0}
+	if gglm.Abs32(v[1]) > 0.99 {
+		up = aoVec3{1, 0, 0}
+	}
+
+	t = up.cross(v).normalize()
+	b = v.cross(t)
+	return t, b
+}
+
+type aoTri [3]aoVec3
+
+// hit is the Möller–Trumbore ray-triangle test, and reports whether the ray hits the triangle within maxDist
+func (tri *aoTri) hit(origin, dir aoVec3, maxDist float32) bool {
+
+	const epsilon = 1e-7
+
+	edge1 := tri[1].sub(tri[0])
+	edge2 := tri[2].sub(tri[0])
+
+	p := dir.cross(edge2)
+	det := edge1.dot(p)
+	if det > -epsilon && det < epsilon {
+		return false
+	}
+
+	invDet := 1 / det
+	toOrigin := origin.sub(tri[0])
+
+	u := toOrigin.dot(p) * invDet
+	if u < 0 || u > 1 {
+		return false
+	}
+
+	q := toOrigin.cross(edge1)
+	v := dir.dot(q) * invDet
+	if v < 0 || u+v > 1 {
+		return false
+	}
+
+	t := edge2.dot(q) * invDet
+	return t > 0 && t < maxDist
+}
+
+type aoBvhNode struct {
+	min, max aoVec3
+
+	// Leaves have a triCount above zero and their triangles are tris[first:first+triCount],
+	// otherwise the children are nodes[first] and nodes[first+1]
+	first    int32
+	triCount int32
+}
+
+// aoBvh is a bounding volume hierarchy used to find whether rays hit any triangle of a mesh
+type aoBvh struct {
+	nodes []aoBvhNode
+	tris  []aoTri
+}
+
+func newAoBvh(tris []aoTri) *aoBvh {
+
+	bvh := &aoBvh{
+		nodes: make([]aoBvhNode, 0, max(1, len(tris)/2)),
+		tris:  tris,
+	}
+
+	bvh.nodes = append(bvh.nodes, aoBvhNode{first: 0, triCount: int32(len(tris))})
+	bvh.subdivide(0)
+
+	return bvh
+}
+
+func (bvh *aoBvh) subdivide(nodeIndex int32) {
+
+	const maxLeafTris = 4
+
+	node := &bvh.nodes[nodeIndex]
+	tris := bvh.tris[node.first : node.first+node.triCount]
+
+	node.min = aoVec3{math.MaxFloat32, math.MaxFloat32, math.MaxFloat32}
+	node.max = aoVec3{-math.MaxFloat32, -math.MaxFloat32, -math.MaxFloat32}
+	for i := range tris {
+		for _, v := range tris[i] {
+			for axis := 0; axis < 3; axis++ {
+				node.min[axis] = min(node.min[axis], v[axis])
+				node.max[axis] = max(node.max[axis], v[axis])
+			}
+		}
+	}
+
+	if len(tris) <= maxLeafTris {
+		return
+	}
+
+	// Split at the middle of the longest axis
+	extent := node.max.sub(node.min)
+	axis := 0
+	if extent[1] > extent[axis] {
+		axis = 1
+	}
+	if extent[2] > extent[axis] {
+		axis = 2
+	}
+
+	splitPos := node.min[axis] + extent[axis]*0.5
+	centroid := func(t *aoTri) float32 {
+		return (t[0][axis] + t[1][axis] + t[2][axis]) / 3
+	}
+
+	// Partition triangles so the ones left of the split come first
+	leftCount := 0
+	for i := range tris {
+		if centroid(&tris[i]) < splitPos {
+			tris[i], tris[leftCount] = tris[leftCount], tris[i]
+			leftCount++
+		}
+	}
+
+	// All centroids on one side (e.g. many overlapping triangles) means splitting won't help
+	if leftCount == 0 || leftCount == len(tris) {
+		return
+	}
+
+	first := node.first
+	triCount := node.triCount
+
+	childIndex := int32(len(bvh.nodes))
+	bvh.nodes = append(bvh.nodes,
+		aoBvhNode{first: first, triCount: int32(leftCount)},
+		aoBvhNode{first: first + int32(leftCount), triCount: triCount - int32(leftCount)},
+	)
+
+	// Appending might have moved the nodes, so don't use the node pointer after this
+	bvh.nodes[nodeIndex].first = childIndex
+	bvh.nodes[nodeIndex].triCount = 0
+
+	bvh.subdivide(childIndex)
+	bvh.subdivide(childIndex + 1)
+}
+
+// anyHit reports whether the ray hits any triangle within maxDist. stack is scratch memory reused between calls
+func (bvh *aoBvh) anyHit(origin, dir aoVec3, maxDist float32, stack *[]int32) bool {
+
+	if len(bvh.tris) == 0 {
+		return false
+	}
+
+	invDir := aoVec3{1 / dir[0], 1 / dir[1], 1 / dir[2]}
+
+	*stack = append((*stack)[:0], 0)
+	for len(*stack) > 0 {
+
+		nodeIndex := (*stack)[len(*stack)-1]
+		*stack = (*stack)[:len(*stack)-1]
+
+		node := &bvh.nodes[nodeIndex]
+		if !rayHitsAabb(origin, invDir, maxDist, node.min, node.max) {
+			continue
+		}
+
+		if node.triCount == 0 {
+			*stack = append(*stack, node.first, node.first+1)
+			continue
+		}
+
+		for i := node.first; i < node.first+node.triCount; i++ {
+			if bvh.tris[i].hit(origin, dir, maxDist) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// rayHitsAabb is the slab test
+func rayHitsAabb(origin, invDir aoVec3, maxDist float32, boxMin, boxMax aoVec3) bool {
+
+	tMin := float32(0)
+	tMax := maxDist
+	for axis := 0; axis < 3; axis++ {
+
+		t1 := (boxMin[axis] - origin[axis]) * invDir[axis]
+		t2 := (boxMax[axis] - origin[axis]) * invDir[axis]
+
+		tMin = max(tMin, min(t1, t2))
+		tMax = min(tMax, max(t1, t2))
+	}
+
+	return tMin <= tMax
+}
@@ -2,52 +2,210 @@ package meshes
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/bloeys/assimp-go/asig"
 	"github.com/bloeys/gglm/gglm"
 	"github.com/bloeys/nmage/assert"
+	"github.com/bloeys/nmage/assets"
 	"github.com/bloeys/nmage/buffers"
 )
 
 type SubMesh struct {
-	BaseVertex int32
-	BaseIndex  uint32
-	IndexCount int32
+	BaseVertex  int32
+	VertexCount int32
+	BaseIndex   uint32
+	IndexCount  int32
 }
 
+var _ assets.Destroyer = &Mesh{}
+
 type Mesh struct {
 	Name string
+	// Vao uses one of the mesh layouts (e.g. MeshLayoutColor or MeshLayoutSeparateColor), which is in Vao.Layout
+	Vao       buffers.VertexArray
+	SubMeshes []SubMesh
+
+	// HasLightmapUVs is true if the model has a second uv channel, which is used to sample lightmaps
+	HasLightmapUVs bool
+
+	// DepthVao only has the positions of the mesh in MeshLayoutDepth, and is used by renderers to draw materials with
+	// materials.MaterialSettings_PositionOnly. Its Id is zero unless the mesh was loaded with MeshLoadOptions.DepthStream
+	DepthVao buffers.VertexArray
+
+	// isDepthStreamShared is true when DepthVao reads the position stream of Vao instead of having its own copy
+	isDepthStreamShared bool
+}
+
+var (
 	/*
-		Vao has the following shader attribute layout:
+		The vertex layouts of meshes have the following shader attribute layout:
 			- Loc0: Pos
 			- Loc1: Normal
-			- Loc2: UV0
-			- Loc3: Tangent
-			- (Optional) Color
+			- Loc2: Tangent, with the handedness of the bitangent in w (see MikkTangents)
+			- Loc3: UV0
+			- (Optional) Loc4: Color, with baked ambient occlusion in alpha
+			- (Optional) Loc5: UV1, used for lightmaps
 
-		Optional stuff appear in the order in this list, depending on what other optional stuff exists.
+		Meshes with UV1 always have a color (white if the model has none), so that UV1 is always at Loc5.
+		Shaders may read the optional attributes from meshes without them, and get (0, 0, 0, 1)
 
-		For example:
-			- If color exists it will be in Loc3, otherwise it is unset
+		The separate layouts (see MeshLoadOptions.SeparateStreams) have the same locations, but each attribute is in its own
+		stream, with the stream index being the same as the location (see MeshStream_Pos and friends)
 	*/
-	Vao       buffers.VertexArray
-	SubMeshes []SubMesh
+	MeshLayout         = newMeshLayout("Mesh", false, false, false)
+	MeshLayoutColor    = newMeshLayout("Mesh Color", true, false, false)
+	MeshLayoutColorUV1 = newMeshLayout("Mesh Color UV1", true, true, false)
+
+	MeshLayoutSeparate         = newMeshLayout("Mesh Separate", false, false, true)
+	MeshLayoutSeparateColor    = newMeshLayout("Mesh Separate Color", true, false, true)
+	MeshLayoutSeparateColorUV1 = newMeshLayout("Mesh Separate Color UV1", true, true, true)
+
+	// MeshLayoutDepth is the layout of Mesh.DepthVao, which only has positions. Shaders drawing it may read the other
+	// mesh attributes (e.g. depth shaders that read UVs for alpha cutout), but get (0, 0, 0, 1)
+	MeshLayoutDepth = newMeshDepthLayout()
+
+	// MeshInstanceLayout is the per instance data of instanced mesh draws (see renderer.Render.DrawMeshInstanced), which is the
+	// model matrix of each instance at Loc6 (taking Loc6 to Loc9). Instanced shaders read it with:
+	//
+	//	layout(location=6) in mat4 instanceModelMat;
+	MeshInstanceLayout = buffers.NewVertexLayout("Mesh Instance", buffers.VertexAttrib{Loc: 6, Name: "InstanceModelMat", Element: buffers.Element{ElementType: buffers.DataTypeMat4}})
+)
+
+// The streams of meshes loaded with MeshLoadOptions.SeparateStreams, as passed to Mesh.SetStreamData
+const (
+	MeshStream_Pos uint32 = iota
+	MeshStream_Normal
+	MeshStream_Tangent
+	MeshStream_UV0
+	MeshStream_Color
+	MeshStream_UV1
+)
+
+// MeshLoadOptions are the optional settings of NewMeshWithOptions
+type MeshLoadOptions struct {
+	// BakeAo bakes ambient occlusion into the alpha of the vertex colors when set (see NewMeshWithBakedAo)
+	BakeAo *AoBakeSettings
+
+	// SeparateStreams stores each vertex attribute in its own vertex buffer instead of interleaving them in one.
+	//
+	// This allows updating some attributes with Mesh.SetStreamData (e.g. positions for CPU skinning or morph targets)
+	// without uploading the rest, and passes that only read positions (e.g. depth and shadows) fetch less memory.
+	// Interleaved meshes are a bit faster to draw with shaders that read all attributes
+	SeparateStreams bool
+
+	// DepthStream gives the mesh a DepthVao, so depth and shadow passes only fetch positions instead of whole vertices.
+	// Interleaved meshes get a copy of their positions, while SeparateStreams meshes share their position stream
+	DepthStream bool
+
+	// StreamUsage is the usage of the vertex buffers, which defaults to buffers.BufUsage_Static_Draw.
+	// Meshes updated every frame should use buffers.BufUsage_Dynamic_Draw
+	StreamUsage buffers.BufUsage
 }
 
 var (
 	// DefaultMeshLoadFlags are the flags always applied when loading a new mesh regardless
 	// of what post process flags are used when loading a mesh.
 	//
-	// Defaults to: asig.PostProcessTriangulate | asig.PostProcessCalcTangentSpace;
-	// Note: changing this will break the normal lit shaders, which expect tangents to be there
-	DefaultMeshLoadFlags asig.PostProcess = asig.PostProcessTriangulate | asig.PostProcessCalcTangentSpace
+	// Defaults to: asig.PostProcessTriangulate;
+	// Note: tangents are always generated with MikkTangents rather than by assimp, so asig.PostProcessCalcTangentSpace has no effect
+	DefaultMeshLoadFlags asig.PostProcess = asig.PostProcessTriangulate
 )
 
+func newMeshLayout(name string, hasColor, hasUV1, isSeparate bool) buffers.VertexLayout {
+
+	attribs := []buffers.VertexAttrib{
+		{Loc: 0, Name: "Pos", Element: buffers.Element{ElementType: buffers.DataTypeVec3}},
+		{Loc: 1, Name: "Normal", Element: buffers.Element{ElementType: buffers.DataTypeVec3}},
+		{Loc: 2, Name: "Tangent", Element: buffers.Element{ElementType: buffers.DataTypeVec4}},
+		{Loc: 3, Name: "UV0", Element: buffers.Element{ElementType: buffers.DataTypeVec2}},
+	}
+
+	defaultedLocs := []uint32{4, 5}
+	if hasColor {
+		attribs = append(attribs, buffers.VertexAttrib{Loc: 4, Name: "Color", Element: buffers.Element{ElementType: buffers.DataTypeVec4}})
+		defaultedLocs = []uint32{5}
+	}
+
+	if hasUV1 {
+		attribs = append(attribs, buffers.VertexAttrib{Loc: 5, Name: "UV1", Element: buffers.Element{ElementType: buffers.DataTypeVec2}})
+		defaultedLocs = nil
+	}
+
+	var l buffers.VertexLayout
+	if isSeparate {
+		l = buffers.NewSeparateVertexLayout(name, attribs...)
+	} else {
+		l = buffers.NewVertexLayout(name, attribs...)
+	}
+
+	l.DefaultedLocs = defaultedLocs
+	return l
+}
+
+func newMeshDepthLayout() buffers.VertexLayout {
+	l := buffers.NewVertexLayout("Mesh Depth", buffers.VertexAttrib{Loc: 0, Name: "Pos", Element: buffers.Element{ElementType: buffers.DataTypeVec3}})
+	l.DefaultedLocs = []uint32{1, 2, 3, 4, 5}
+	return l
+}
+
+// meshLayoutFor returns the shared layout of meshes with the passed attributes. UV1 always comes with colors
+func meshLayoutFor(hasColor, hasUV1, isSeparate bool) *buffers.VertexLayout {
+
+	if isSeparate {
+
+		if hasUV1 {
+			return &MeshLayoutSeparateColorUV1
+		}
+
+		if hasColor {
+			return &MeshLayoutSeparateColor
+		}
+
+		return &MeshLayoutSeparate
+	}
+
+	if hasUV1 {
+		return &MeshLayoutColorUV1
+	}
+
+	if hasColor {
+		return &MeshLayoutColor
+	}
+
+	return &MeshLayout
+}
+
 func NewMesh(name, modelPath string, postProcessFlags asig.PostProcess) (Mesh, error) {
+	return NewMeshWithOptions(name, modelPath, postProcessFlags, MeshLoadOptions{})
+}
+
+// NewMeshWithBakedAo loads a mesh like NewMesh, and bakes ambient occlusion into the alpha of its vertex colors,
+// which the lit shaders use to darken ambient lighting. Models without vertex colors get white ones.
+//
+// Only the geometry of the model itself occludes, so this suits static meshes with detail that occludes itself (e.g. crevices).
+// Baking is done on load and can take a while for dense meshes
+func NewMeshWithBakedAo(name, modelPath string, postProcessFlags asig.PostProcess, aoSettings AoBakeSettings) (Mesh, error) {
+	return NewMeshWithOptions(name, modelPath, postProcessFlags, MeshLoadOptions{BakeAo: &aoSettings})
+}
+
+// LoadManagedMesh is NewMeshWithOptions through an asset manager, so a model file loaded with the same flags and stream options
+// is loaded once. AoBakeSettings aren't part of the key, so a file should always be baked with the same settings
+func LoadManagedMesh(m *assets.Manager, name, modelPath string, postProcessFlags asig.PostProcess, opts MeshLoadOptions) (assets.Handle[*Mesh], error) {
+
+	key := fmt.Sprintf("mesh:%s:flags=%d:ao=%t:separate=%t:depth=%t", modelPath, postProcessFlags, opts.BakeAo != nil, opts.SeparateStreams, opts.DepthStream)
+	return assets.Acquire(m, key, func() (*Mesh, error) {
+		mesh, err := NewMeshWithOptions(name, modelPath, postProcessFlags, opts)
+		return &mesh, err
+	})
+}
+
+// NewMeshWithOptions loads a mesh like NewMesh, with the optional settings in opts
+func NewMeshWithOptions(name, modelPath string, postProcessFlags asig.PostProcess, opts MeshLoadOptions) (Mesh, error) {
 
 	finalPostProcessFlags := DefaultMeshLoadFlags | postProcessFlags
 
-	scene, release, err := asig.ImportFile(modelPath, finalPostProcessFlags)
+	scene, release, err := asig.ImportFile(assets.ResolvePath(modelPath), finalPostProcessFlags)
 	if err != nil {
 		return Mesh{}, errors.New("Failed to load model. Err: " + err.Error())
 	}
@@ -57,59 +215,107 @@ func NewMesh(name, modelPath string, postProcessFlags asig.PostProcess) (Mesh, e
 		return Mesh{}, errors.New("No meshes found in file: " + modelPath)
 	}
 
-	mesh := Mesh{
-		Name:      name,
-		Vao:       buffers.NewVertexArray(),
-		SubMeshes: make([]SubMesh, 0, 1),
+	mesh, err := NewMeshFromSceneMeshes(name, scene.Meshes, opts)
+	if err != nil {
+		return Mesh{}, fmt.Errorf("failed to load mesh at path '%s'. Err: %w", modelPath, err)
 	}
 
-	vbo := buffers.NewVertexBuffer()
-	ibo := buffers.NewIndexBuffer()
+	return mesh, nil
+}
 
-	// Estimate a useful prealloc capacity based on the first submesh that has vertex pos+normals+tangents+texCoords
-	vertexBufDataCapacity := len(scene.Meshes[0].Vertices) * 3 * 3 * 3 * 2
+// NewMeshFromSceneMeshes creates a mesh from meshes of a scene imported with asig.ImportFile (which should include DefaultMeshLoadFlags),
+// with one submesh per scene mesh. This is for loaders that need more of the scene than NewMesh reads (e.g. materials or nodes).
+// The scene meshes may be changed (e.g. given white vertex colors), and all must end up with the same vertex layout
+func NewMeshFromSceneMeshes(name string, sceneMeshes []*asig.Mesh, opts MeshLoadOptions) (Mesh, error) {
 
-	// Increase capacity depending on what the mesh has
-	if len(scene.Meshes[0].ColorSets) > 0 && len(scene.Meshes[0].ColorSets[0]) > 0 {
-		vertexBufDataCapacity *= 4
+	if len(sceneMeshes) == 0 {
+		return Mesh{}, fmt.Errorf("failed to create mesh '%s' because no scene meshes were passed", name)
 	}
 
-	var vertexBufData []float32 = make([]float32, 0, vertexBufDataCapacity)
+	if opts.StreamUsage == buffers.BufUsage_Unknown {
+		opts.StreamUsage = buffers.BufUsage_Static_Draw
+	}
+
+	mesh := Mesh{
+		Name:      name,
+		Vao:       buffers.NewVertexArray(),
+		SubMeshes: make([]SubMesh, 0, 1),
+	}
+
+	// Has the data of each stream of the layout, which is allocated once the layout is known from the first submesh
+	var streamData [][]float32
 
 	// Initial size assumes 3 indices per face
-	var indexBufData []uint32 = make([]uint32, 0, len(scene.Meshes[0].Faces)*3)
+	var indexBufData []uint32 = make([]uint32, 0, len(sceneMeshes[0].Faces)*3)
 
-	// fmt.Printf("\nMesh %s has %d meshe(s) with first mesh having %d vertices\n", name, len(scene.Meshes), len(scene.Meshes[0].Vertices))
+	// fmt.Printf("\nMesh %s has %d meshe(s) with first mesh having %d vertices\n", name, len(sceneMeshes), len(sceneMeshes[0].Vertices))
 
-	for i := 0; i < len(scene.Meshes); i++ {
+	var layout *buffers.VertexLayout
+	var vertexCount int32
+	var depthStreamData []float32
+	var aoPerSceneMesh [][]float32
+	if opts.BakeAo != nil {
+		aoPerSceneMesh = bakeSceneAo(sceneMeshes, opts.BakeAo)
+	}
 
-		sceneMesh := scene.Meshes[i]
+	for i := 0; i < len(sceneMeshes); i++ {
 
-		// We always want tangents and UV0
-		if len(sceneMesh.Tangents) == 0 {
-			sceneMesh.Tangents = make([]gglm.Vec3, len(sceneMesh.Vertices))
-		}
+		sceneMesh := sceneMeshes[i]
 
+		// We always want UV0
 		if len(sceneMesh.TexCoords[0]) == 0 {
 			sceneMesh.TexCoords[0] = make([]gglm.Vec3, len(sceneMesh.Vertices))
 		}
 
 		hasColorSet0 := len(sceneMesh.ColorSets) > 0 && len(sceneMesh.ColorSets[0]) > 0
+		hasUV1 := len(sceneMesh.TexCoords[1]) > 0
+		hasAo := aoPerSceneMesh != nil
+		if (hasUV1 || hasAo) && !hasColorSet0 {
 
-		layoutToUse := []buffers.Element{
-			{ElementType: buffers.DataTypeVec3}, // Position
-			{ElementType: buffers.DataTypeVec3}, // Normals
-			{ElementType: buffers.DataTypeVec3}, // Tangents
-			{ElementType: buffers.DataTypeVec2}, // UV0
+			whiteColors := make([]gglm.Vec4, len(sceneMesh.Vertices))
+			for i := 0; i < len(whiteColors); i++ {
+				whiteColors[i] = gglm.NewVec4(1, 1, 1, 1)
+			}
+
+			sceneMesh.ColorSets[0] = whiteColors
+			hasColorSet0 = true
 		}
 
-		if hasColorSet0 {
-			layoutToUse = append(layoutToUse, buffers.Element{ElementType: buffers.DataTypeVec4})
+		if hasAo {
+
+			// Copied so the ao doesn't change the colors of the scene
+			colors := make([]gglm.Vec4, len(sceneMesh.ColorSets[0]))
+			copy(colors, sceneMesh.ColorSets[0])
+			for j := 0; j < len(colors); j++ {
+				colors[j].SetW(aoPerSceneMesh[i][j])
+			}
+
+			sceneMesh.ColorSets[0] = colors
 		}
 
+		indices := flattenFaces(sceneMesh.Faces)
+		uv0 := v3sToV2s(sceneMesh.TexCoords[0])
+
+		tangents, splitFrom := MikkTangents(sceneMesh.Vertices, sceneMesh.Normals, uv0, indices)
+		if len(splitFrom) > 0 {
+			sceneMesh.Vertices = appendSplitVerts(sceneMesh.Vertices, splitFrom)
+			sceneMesh.Normals = appendSplitVerts(sceneMesh.Normals, splitFrom)
+			uv0 = appendSplitVerts(uv0, splitFrom)
+			sceneMesh.ColorSets[0] = appendSplitVerts(sceneMesh.ColorSets[0], splitFrom)
+			sceneMesh.TexCoords[1] = appendSplitVerts(sceneMesh.TexCoords[1], splitFrom)
+		}
+
+		submeshLayout := meshLayoutFor(hasColorSet0, hasUV1, opts.SeparateStreams)
 		if i == 0 {
-			vbo.SetLayout(layoutToUse...)
-		} else {
+
+			layout = submeshLayout
+
+			// Estimate a useful prealloc capacity based on the first submesh
+			streamData = make([][]float32, layout.StreamCount())
+			for j := 0; j < len(streamData); j++ {
+				streamData[j] = make([]float32, 0, len(sceneMesh.Vertices)*int(layout.Strides[j]/4))
+			}
+		} else if submeshLayout != layout {
 
 			// @TODO @NOTE: This requirement is because we are using one VAO+VBO for all
 			// the meshes and so the buffer must have one format.
@@ -117,46 +323,87 @@ func NewMesh(name, modelPath string, postProcessFlags asig.PostProcess) (Mesh, e
 			// If we want to allow different layouts then we can simply create one vbo per layout and put
 			// meshes of the same layout in the same vbo, and we store the index of the vbo the mesh
 			// uses in the submesh struct.
-			firstSubmeshLayout := vbo.GetLayout()
-			assert.T(len(firstSubmeshLayout) == len(layoutToUse), "Vertex layout of submesh '%d' of mesh '%s' at path '%s' does not equal vertex layout of the first submesh. Original layout: %v; This layout: %v", i, name, modelPath, firstSubmeshLayout, layoutToUse)
-
-			for i := 0; i < len(firstSubmeshLayout); i++ {
-				assert.T(firstSubmeshLayout[i].ElementType == layoutToUse[i].ElementType, "Vertex layout of submesh '%d' of mesh '%s' at path '%s' does not equal vertex layout of the first submesh. Original layout: %v; This layout: %v", i, name, modelPath, firstSubmeshLayout, layoutToUse)
-			}
+			mesh.Vao.Delete()
+			return Mesh{}, fmt.Errorf("failed to create mesh '%s' because submesh %d has vertex layout %s while the first submesh has %s", name, i, submeshLayout.String(), layout.String())
 		}
 
 		arrs := []arrToInterleave{
 			{V3s: sceneMesh.Vertices},
 			{V3s: sceneMesh.Normals},
-			{V3s: sceneMesh.Tangents},
-			{V2s: v3sToV2s(sceneMesh.TexCoords[0])},
+			{V4s: tangents},
+			{V2s: uv0},
 		}
 
 		if hasColorSet0 {
 			arrs = append(arrs, arrToInterleave{V4s: sceneMesh.ColorSets[0]})
 		}
 
-		indices := flattenFaces(sceneMesh.Faces)
+		if hasUV1 {
+			arrs = append(arrs, arrToInterleave{V2s: v3sToV2s(sceneMesh.TexCoords[1])})
+			mesh.HasLightmapUVs = true
+		}
+
 		mesh.SubMeshes = append(mesh.SubMeshes, SubMesh{
 
 			// Index of the vertex to start from (e.g. if index buffer says use vertex 5, and BaseVertex=3, the vertex used will be vertex 8)
-			BaseVertex: int32(len(vertexBufData)*4) / vbo.Stride,
+			BaseVertex:  vertexCount,
+			VertexCount: int32(len(sceneMesh.Vertices)),
 			// Which index (in the index buffer) to start from
 			BaseIndex: uint32(len(indexBufData)),
 			// How many indices in this submesh
 			IndexCount: int32(len(indices)),
 		})
 
-		vertexBufData = append(vertexBufData, interleave(arrs...)...)
+		// arrs is in the order of the layout's attributes, and each stream interleaves its own attributes
+		for stream := uint32(0); stream < layout.StreamCount(); stream++ {
+
+			streamArrs := make([]arrToInterleave, 0, len(arrs))
+			for j := 0; j < len(arrs); j++ {
+				if layout.Attribs[j].Stream == stream {
+					streamArrs = append(streamArrs, arrs[j])
+				}
+			}
+
+			streamData[stream] = append(streamData[stream], interleave(streamArrs...)...)
+		}
+
+		// Separate stream meshes already have a position only stream
+		if opts.DepthStream && !opts.SeparateStreams {
+			depthStreamData = append(depthStreamData, interleave(arrs[MeshStream_Pos])...)
+		}
+
+		vertexCount += int32(len(sceneMesh.Vertices))
 		indexBufData = append(indexBufData, indices...)
 	}
 
-	vbo.SetData(vertexBufData, buffers.BufUsage_Static_Draw)
+	vbos := make([]buffers.VertexBuffer, len(streamData))
+	for i := 0; i < len(vbos); i++ {
+		vbos[i] = buffers.NewVertexBuffer()
+		vbos[i].SetData(streamData[i], opts.StreamUsage)
+	}
+
+	ibo := buffers.NewIndexBuffer()
 	ibo.SetData(indexBufData)
 
-	mesh.Vao.AddVertexBuffer(vbo)
+	mesh.Vao.AddVertexBuffersWithLayout(vbos, layout)
 	mesh.Vao.SetIndexBuffer(ibo)
 
+	if opts.DepthStream {
+
+		var depthVbo buffers.VertexBuffer
+		if opts.SeparateStreams {
+			depthVbo = *mesh.Vao.StreamBuffer(MeshStream_Pos)
+			mesh.isDepthStreamShared = true
+		} else {
+			depthVbo = buffers.NewVertexBuffer()
+			depthVbo.SetData(depthStreamData, opts.StreamUsage)
+		}
+
+		mesh.DepthVao = buffers.NewVertexArray()
+		mesh.DepthVao.AddVertexBufferWithLayout(depthVbo, &MeshLayoutDepth)
+		mesh.DepthVao.SetIndexBuffer(ibo)
+	}
+
 	// This is needed so that if you load meshes one after the other the
 	// following mesh doesn't attach its vbo/ibo to this vao
 	mesh.Vao.UnBind()
@@ -164,6 +411,35 @@ func NewMesh(name, modelPath string, postProcessFlags asig.PostProcess) (Mesh, e
 	return mesh, nil
 }
 
+// SetStreamData replaces the vertices of one stream starting at firstVertex (e.g. SubMesh.BaseVertex) without uploading the other streams.
+// values has the attributes of the stream interleaved, so for meshes loaded with MeshLoadOptions.SeparateStreams it only has
+// that attribute (e.g. 3 floats per vertex for MeshStream_Pos).
+//
+// The depth stream of interleaved meshes is a copy that isn't updated, so meshes updated this way should use SeparateStreams
+// if they also want a DepthStream
+func (m *Mesh) SetStreamData(stream uint32, firstVertex int32, values []float32) {
+	vbo := m.Vao.StreamBuffer(stream)
+	vbo.SetSubData(int(firstVertex*vbo.Stride), values)
+}
+
+// Delete deletes the vertex arrays of the mesh along with their vertex and index buffers
+func (m *Mesh) Delete() {
+
+	if m.DepthVao.Id != 0 {
+
+		// The index buffer, and the position buffer when shared, are owned by Vao
+		m.DepthVao.IndexBuffer = buffers.IndexBuffer{}
+		if m.isDepthStreamShared {
+			m.DepthVao.Vbos = nil
+		}
+
+		m.DepthVao.Delete()
+	}
+
+	m.Vao.Delete()
+	m.SubMeshes = nil
+}
+
 func v3sToV2s(v3s []gglm.Vec3) []gglm.Vec2 {
 
 	v2s := make([]gglm.Vec2, len(v3s))
@@ -0,0 +1,175 @@
+package meshes
+
+import (
+	"math"
+
+	"github.com/bloeys/gglm/gglm"
+)
+
+// MikkTangents generates a tangent per vertex the way mikkTSpace does, which is what most tools (e.g. Blender, Substance, xNormal)
+// use when baking normal maps, so baked normal maps shade without seams. The w of each tangent is the handedness of the bitangent,
+// which shaders rebuild with 'bitangent = w * cross(normal, tangent)'.
+//
+// Triangles with mirrored uvs need the opposite handedness, so vertices shared by mirrored and unmirrored triangles are split:
+// splitFrom has the original vertex of every added vertex (vertex len(positions)+i is a copy of splitFrom[i]),
+// and indices are changed in place to use the added vertices
+func MikkTangents(positions, normals []gglm.Vec3, uvs []gglm.Vec2, indices []uint32) (tangents []gglm.Vec4, splitFrom []uint32) {
+
+	const (
+		orientPositive uint8 = 1 << iota
+		orientNegative
+	)
+
+	triCount := len(indices) / 3
+	triTangents := make([]aoVec3, triCount)
+	triIsPositive := make([]bool, triCount)
+	triIsValid := make([]bool, triCount)
+	vertOrients := make([]uint8, len(positions))
+
+	for t := 0; t < triCount; t++ {
+
+		i0, i1, i2 := indices[t*3+0], indices[t*3+1], indices[t*3+2]
+		p0 := toAoVec3(&positions[i0])
+		e1 := toAoVec3(&positions[i1]).sub(p0)
+		e2 := toAoVec3(&positions[i2]).sub(p0)
+
+		du1, dv1 := uvs[i1].X()-uvs[i0].X(), uvs[i1].Y()-uvs[i0].Y()
+		du2, dv2 := uvs[i2].X()-uvs[i0].X(), uvs[i2].Y()-uvs[i0].Y()
+
+		// The tangent follows +U whatever the winding, and the sign of the uv area tells if the uvs are mirrored
+		signedUvArea := du1*dv2 - du2*dv1
+		isPositive := signedUvArea > 0
+
+		tangent := e1.scale(dv2).sub(e2.scale(dv1))
+		if !isPositive {
+			tangent = tangent.scale(-1)
+		}
+
+		if gglm.Abs32(signedUvArea) < 1e-12 || tangent.dot(tangent) < 1e-20 {
+			continue
+		}
+
+		triTangents[t] = tangent.normalize()
+		triIsPositive[t] = isPositive
+		triIsValid[t] = true
+
+		orient := orientNegative
+		if isPositive {
+			orient = orientPositive
+		}
+
+		vertOrients[i0] |= orient
+		vertOrients[i1] |= orient
+		vertOrients[i2] |= orient
+	}
+
+	// Vertices used with both handedness keep the positive triangles, and the negative ones get a copy
+	negativeCopies := map[uint32]uint32{}
+	for v := 0; v < len(vertOrients); v++ {
+		if vertOrients[v] == orientPositive|orientNegative {
+			negativeCopies[uint32(v)] = uint32(len(positions) + len(splitFrom))
+			splitFrom = append(splitFrom, uint32(v))
+		}
+	}
+
+	if len(splitFrom) > 0 {
+		for t := 0; t < triCount; t++ {
+
+			if !triIsValid[t] || triIsPositive[t] {
+				continue
+			}
+
+			for c := t * 3; c < t*3+3; c++ {
+				if copyIndex, ok := negativeCopies[indices[c]]; ok {
+					indices[c] = copyIndex
+				}
+			}
+		}
+	}
+
+	vertCount := len(positions) + len(splitFrom)
+	originalVert := func(v uint32) uint32 {
+		if int(v) < len(positions) {
+			return v
+		}
+		return splitFrom[int(v)-len(positions)]
+	}
+
+	sums := make([]aoVec3, vertCount)
+	signs := make([]float32, vertCount)
+	for i := 0; i < len(signs); i++ {
+		signs[i] = 1
+	}
+
+	for t := 0; t < triCount; t++ {
+
+		if !triIsValid[t] {
+			continue
+		}
+
+		for c := 0; c < 3; c++ {
+
+			v := indices[t*3+c]
+			orig := originalVert(v)
+			n := toAoVec3(&normals[orig]).normalize()
+
+			// Each corner adds the triangle's tangent flattened onto the vertex normal, weighted by the angle of the corner
+			// so the result doesn't change with how the surface is triangulated
+			p := toAoVec3(&positions[orig])
+			toNext := projectOnPlane(toAoVec3(&positions[originalVert(indices[t*3+(c+1)%3])]).sub(p), n)
+			toPrev := projectOnPlane(toAoVec3(&positions[originalVert(indices[t*3+(c+2)%3])]).sub(p), n)
+
+			angle := float32(0)
+			if toNext.dot(toNext) > 0 && toPrev.dot(toPrev) > 0 {
+				cos := min(max(toNext.normalize().dot(toPrev.normalize()), -1), 1)
+				angle = float32(math.Acos(float64(cos)))
+			}
+
+			tangent := projectOnPlane(triTangents[t], n)
+			if tangent.dot(tangent) == 0 {
+				continue
+			}
+
+			sums[v] = sums[v].add(tangent.normalize().scale(angle))
+			if !triIsPositive[t] {
+				signs[v] = -1
+			}
+		}
+	}
+
+	tangents = make([]gglm.Vec4, vertCount)
+	for v := 0; v < vertCount; v++ {
+
+		n := toAoVec3(&normals[originalVert(uint32(v))]).normalize()
+		tangent := projectOnPlane(sums[v], n)
+
+		// Vertices only used by degenerate triangles, or without uvs, get any tangent that is perpendicular to the normal
+		if tangent.dot(tangent) < 1e-20 {
+			tangent, _ = n.orthonormalBasis()
+		} else {
+			tangent = tangent.normalize()
+		}
+
+		tangents[v] = gglm.NewVec4(tangent[0], tangent[1], tangent[2], signs[v])
+	}
+
+	return tangents, splitFrom
+}
+
+func projectOnPlane(v, planeNormal aoVec3) aoVec3 {
+	return v.sub(planeNormal.scale(v.dot(planeNormal)))
+}
+
+// appendSplitVerts adds a copy of the vertex data of each split vertex to the end of data
+func appendSplitVerts[T any](data []T, splitFrom []uint32) []T {
+
+	if len(data) == 0 {
+		return data
+	}
+
+	for _, v := range splitFrom {
+		data = append(data, data[v])
+	}
+
+	return data
+}
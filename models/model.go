@@ -0,0 +1,305 @@
+// The models package loads whole model files, with their meshes, materials, textures and node hierarchy.
+//
+// It is its own package rather than part of assets because models are made of meshes and materials, which both import assets
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/bloeys/assimp-go/asig"
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/assets"
+	"github.com/bloeys/nmage/logging"
+	"github.com/bloeys/nmage/materials"
+	"github.com/bloeys/nmage/meshes"
+	"github.com/bloeys/nmage/renderer"
+)
+
+var _ assets.Destroyer = &Model{}
+
+// Node is a node of the model's hierarchy, which places meshes relative to its parent
+type Node struct {
+	Name string
+
+	// Parent is the index of the parent in Model.Nodes, and is -1 for the root
+	Parent   int32
+	Children []int32
+
+	// MeshIndices are the meshes (in Model.Meshes) drawn at this node
+	MeshIndices []int32
+
+	// LocalTransform is relative to the parent, and WorldTransform is relative to the model (i.e. with all parents applied)
+	LocalTransform gglm.Mat4
+	WorldTransform gglm.Mat4
+}
+
+// Model is a loaded model file, with one mesh per mesh in the file (see meshes.NewMeshFromSceneMeshes)
+type Model struct {
+	Name string
+
+	Meshes []meshes.Mesh
+
+	// MeshMaterials has the index in Materials of the material of each mesh
+	MeshMaterials []int32
+
+	// Materials are variants of the base material passed to LoadModel (see materials.NewMaterialVariant),
+	// with the textures of the model's materials
+	Materials []materials.Material
+
+	// Textures are the textures loaded for Materials, which are owned by the model
+	Textures []assets.Texture
+
+	// Nodes is the node hierarchy, where Nodes[0] is the root and parents come before their children
+	Nodes []Node
+}
+
+// LoadOptions are the optional settings of LoadModel
+type LoadOptions struct {
+	PostProcessFlags asig.PostProcess
+	Mesh             meshes.MeshLoadOptions
+}
+
+// Draw draws every mesh of the model with its material, at the transform of its nodes relative to modelMat
+func (m *Model) Draw(rend renderer.Render, modelMat *gglm.TrMat) {
+	m.draw(rend, modelMat, nil)
+}
+
+// DrawWithMat is Draw with every mesh drawn with mat instead of its own material (e.g. for depth and shadow passes)
+func (m *Model) DrawWithMat(rend renderer.Render, modelMat *gglm.TrMat, mat *materials.Material) {
+	m.draw(rend, modelMat, mat)
+}
+
+func (m *Model) draw(rend renderer.Render, modelMat *gglm.TrMat, overrideMat *materials.Material) {
+
+	for i := 0; i < len(m.Nodes); i++ {
+
+		n := &m.Nodes[i]
+		if len(n.MeshIndices) == 0 {
+			continue
+		}
+
+		nodeMat := gglm.TrMat{Mat4: gglm.MulMat4(&modelMat.Mat4, &n.WorldTransform)}
+		for j := 0; j < len(n.MeshIndices); j++ {
+
+			meshIndex := n.MeshIndices[j]
+
+			mat := overrideMat
+			if mat == nil {
+				mat = &m.Materials[m.MeshMaterials[meshIndex]]
+			}
+
+			rend.DrawMesh(&m.Meshes[meshIndex], &nodeMat, mat)
+		}
+	}
+}
+
+// Delete deletes the meshes and textures of the model. The materials share the shader of the base material, which is left alone
+func (m *Model) Delete() {
+
+	for i := 0; i < len(m.Meshes); i++ {
+		m.Meshes[i].Delete()
+	}
+
+	for i := 0; i < len(m.Textures); i++ {
+		m.Textures[i].Delete()
+	}
+
+	m.Meshes = nil
+	m.MeshMaterials = nil
+	m.Materials = nil
+	m.Textures = nil
+	m.Nodes = nil
+}
+
+// LoadModel loads the meshes, materials, textures and node hierarchy of a model file, ready to be drawn with Model.Draw.
+//
+// Each material of the file becomes a variant of baseMat (e.g. a lit material using res/shaders/simple.glsl) with the diffuse, specular,
+// normal and emissive textures of the file, which can be next to the model or embedded in it. Textures the file doesn't have are kept from baseMat.
+// Only textures are read from the file's materials, since the assimp bindings don't expose other material properties (e.g. colors)
+func LoadModel(name, modelPath string, baseMat *materials.Material, opts LoadOptions) (Model, error) {
+
+	scene, release, err := asig.ImportFile(assets.ResolvePath(modelPath), meshes.DefaultMeshLoadFlags|opts.PostProcessFlags)
+	if err != nil {
+		return Model{}, fmt.Errorf("failed to load model '%s' at path '%s'. Err: %w", name, modelPath, err)
+	}
+	defer release()
+
+	if len(scene.Meshes) == 0 || scene.RootNode == nil {
+		return Model{}, fmt.Errorf("failed to load model '%s' because no meshes were found in file '%s'", name, modelPath)
+	}
+
+	model := Model{
+		Name:          name,
+		Meshes:        make([]meshes.Mesh, 0, len(scene.Meshes)),
+		MeshMaterials: make([]int32, 0, len(scene.Meshes)),
+	}
+
+	for i := 0; i < len(scene.Meshes); i++ {
+
+		sceneMesh := scene.Meshes[i]
+
+		meshName := sceneMesh.Name
+		if meshName == "" {
+			meshName = name + " mesh " + strconv.Itoa(i)
+		}
+
+		mesh, err := meshes.NewMeshFromSceneMeshes(meshName, scene.Meshes[i:i+1], opts.Mesh)
+		if err != nil {
+			model.Delete()
+			return Model{}, fmt.Errorf("failed to load model '%s' at path '%s'. Err: %w", name, modelPath, err)
+		}
+
+		model.Meshes = append(model.Meshes, mesh)
+		model.MeshMaterials = append(model.MeshMaterials, int32(sceneMesh.MaterialIndex))
+	}
+
+	texLoader := modelTextureLoader{
+		modelDir: path.Dir(modelPath),
+		scene:    scene,
+		model:    &model,
+		loaded:   map[string]uint32{},
+	}
+
+	model.Materials = make([]materials.Material, max(len(scene.Materials), 1))
+	for i := 0; i < len(model.Materials); i++ {
+
+		mat := materials.NewMaterialVariant(baseMat, name+" mat "+strconv.Itoa(i))
+		if i < len(scene.Materials) {
+
+			sceneMat := scene.Materials[i]
+			texLoader.setTex(&mat.DiffuseTex, sceneMat, asig.TextureTypeDiffuse, false)
+			texLoader.setTex(&mat.SpecularTex, sceneMat, asig.TextureTypeSpecular, true)
+			texLoader.setTex(&mat.EmissionTex, sceneMat, asig.TextureTypeEmissive, false)
+
+			// Some formats (e.g. obj) store normal maps as height maps
+			if !texLoader.setTex(&mat.NormalTex, sceneMat, asig.TextureTypeNormal, true) {
+				texLoader.setTex(&mat.NormalTex, sceneMat, asig.TextureTypeHeight, true)
+			}
+		}
+
+		model.Materials[i] = mat
+	}
+
+	// Meshes without a valid material use the first one
+	for i := 0; i < len(model.MeshMaterials); i++ {
+		if model.MeshMaterials[i] >= int32(len(model.Materials)) {
+			model.MeshMaterials[i] = 0
+		}
+	}
+
+	model.addNode(scene.RootNode, -1, int32(len(model.Meshes)))
+	return model, nil
+}
+
+func (m *Model) addNode(sceneNode *asig.Node, parent int32, meshCount int32) {
+
+	index := int32(len(m.Nodes))
+	n := Node{
+		Name:           sceneNode.Name,
+		Parent:         parent,
+		LocalTransform: *sceneNode.Transformation,
+		MeshIndices:    make([]int32, 0, len(sceneNode.MeshIndicies)),
+	}
+
+	if parent >= 0 {
+		n.WorldTransform = gglm.MulMat4(&m.Nodes[parent].WorldTransform, &n.LocalTransform)
+		m.Nodes[parent].Children = append(m.Nodes[parent].Children, index)
+	} else {
+		n.WorldTransform = n.LocalTransform
+	}
+
+	for i := 0; i < len(sceneNode.MeshIndicies); i++ {
+		if meshIndex := int32(sceneNode.MeshIndicies[i]); meshIndex < meshCount {
+			n.MeshIndices = append(n.MeshIndices, meshIndex)
+		}
+	}
+
+	m.Nodes = append(m.Nodes, n)
+	for i := 0; i < len(sceneNode.Children); i++ {
+		m.addNode(sceneNode.Children[i], index, meshCount)
+	}
+}
+
+// modelTextureLoader loads the textures of a model's materials, loading textures used by multiple materials once
+type modelTextureLoader struct {
+	modelDir string
+	scene    *asig.Scene
+	model    *Model
+
+	// loaded has the texture id of each texture path in the model file
+	loaded map[string]uint32
+}
+
+// setTex sets texId to the first texture of texType of the material, and returns false if it has none or it fails to load.
+// Textures that fail to load are logged and skipped, so a missing texture doesn't fail the whole model
+func (tl *modelTextureLoader) setTex(texId *uint32, sceneMat *asig.Material, texType asig.TextureType, isLinear bool) bool {
+
+	if asig.GetMaterialTextureCount(sceneMat, texType) == 0 {
+		return false
+	}
+
+	texInfo, err := asig.GetMaterialTexture(sceneMat, texType, 0)
+	if err != nil {
+		logging.WarnLog.Printf("Failed to get %s texture of a material of model '%s'. Err: %v\n", texType.String(), tl.model.Name, err)
+		return false
+	}
+
+	if id, ok := tl.loaded[texInfo.Path]; ok {
+		*texId = id
+		return true
+	}
+
+	tex, err := tl.load(texInfo.Path, &assets.TextureLoadOptions{NoSrgba: isLinear})
+	if err != nil {
+		logging.WarnLog.Printf("Failed to load %s texture '%s' of model '%s'. Err: %v\n", texType.String(), texInfo.Path, tl.model.Name, err)
+		return false
+	}
+
+	tl.model.Textures = append(tl.model.Textures, tex)
+	tl.loaded[texInfo.Path] = tex.TexID
+	*texId = tex.TexID
+	return true
+}
+
+// load loads a texture embedded in the model (whose path is '*' followed by its index), or a texture file relative to the model
+func (tl *modelTextureLoader) load(texPath string, loadOptions *assets.TextureLoadOptions) (assets.Texture, error) {
+
+	if indexStr, isEmbedded := strings.CutPrefix(texPath, "*"); isEmbedded {
+
+		index, err := strconv.Atoi(indexStr)
+		if err != nil || index < 0 || index >= len(tl.scene.Textures) {
+			return assets.Texture{}, fmt.Errorf("invalid embedded texture index '%s'", indexStr)
+		}
+
+		embedded := tl.scene.Textures[index]
+		if !embedded.IsCompressed {
+			return assets.Texture{}, errors.New("embedded textures must be compressed (e.g. png or jpg)")
+		}
+
+		img, _, err := image.Decode(bytes.NewReader(embedded.Data))
+		if err != nil {
+			return assets.Texture{}, err
+		}
+
+		return assets.LoadTextureInMemPngImg(img, loadOptions)
+	}
+
+	// Exporters on windows may write backslashes
+	file := path.Join(tl.modelDir, strings.ReplaceAll(texPath, "\\", "/"))
+	switch strings.ToLower(path.Ext(file)) {
+	case ".png":
+		return assets.LoadTexturePNG(file, loadOptions)
+	case ".jpg", ".jpeg":
+		return assets.LoadTextureJpeg(file, loadOptions)
+	default:
+		return assets.Texture{}, fmt.Errorf("unsupported texture extension '%s'. Supported extensions are .png, .jpg and .jpeg", path.Ext(file))
+	}
+}
@@ -0,0 +1,125 @@
+package nav
+
+import (
+	"github.com/bloeys/gglm/gglm"
+)
+
+// Agent moves along a path on a navmesh using simple seek and arrive steering.
+//
+// Usage is to call SetDestination once when the agent should go somewhere,
+// then call Update every frame and use Pos to position the character.
+type Agent struct {
+	Pos gglm.Vec3
+	Vel gglm.Vec3
+
+	// MaxSpeed is the max speed in units per second
+	MaxSpeed float32
+
+	// MaxAccel is the max change in velocity in units per second squared
+	MaxAccel float32
+
+	// WaypointRadius is how close the agent must get to a path corner before moving on to the next one
+	WaypointRadius float32
+
+	// ArriveRadius is the distance from the final destination at which the agent starts slowing down
+	ArriveRadius float32
+
+	Path          []gglm.Vec3
+	NextPathIndex int
+}
+
+func (a *Agent) HasPath() bool {
+	return a.NextPathIndex < len(a.Path)
+}
+
+// SetDestination finds a path from the agent's current position to the destination.
+// On failure the agent keeps its previous path
+func (a *Agent) SetDestination(nm *NavMesh, dest *gglm.Vec3) error {
+
+	path, err := nm.FindPath(&a.Pos, dest)
+	if err != nil {
+		return err
+	}
+
+	a.Path = path
+
+	// First point is the current position
+	a.NextPathIndex = 1
+	return nil
+}
+
+func (a *Agent) Stop() {
+	a.Path = a.Path[:0]
+	a.NextPathIndex = 0
+	a.Vel = gglm.Vec3{}
+}
+
+// Update steers the agent towards the next point of its path and moves it.
+// dt is in seconds
+func (a *Agent) Update(dt float32) {
+
+	if !a.HasPath() {
+		a.Vel = gglm.Vec3{}
+		return
+	}
+
+	target := &a.Path[a.NextPathIndex]
+	isLastPoint := a.NextPathIndex == len(a.Path)-1
+
+	toTarget := gglm.SubVec3(target, &a.Pos)
+	dist := toTarget.Mag()
+
+	if !isLastPoint && dist <= a.WaypointRadius {
+		a.NextPathIndex++
+		target = &a.Path[a.NextPathIndex]
+		isLastPoint = a.NextPathIndex == len(a.Path)-1
+		toTarget = gglm.SubVec3(target, &a.Pos)
+		dist = toTarget.Mag()
+	}
+
+	if isLastPoint && dist < 0.001 {
+		a.Pos = *target
+		a.Stop()
+		return
+	}
+
+	// Seek, and slow down when arriving at the destination
+	desiredSpeed := a.MaxSpeed
+	if isLastPoint && dist < a.ArriveRadius {
+		desiredSpeed = a.MaxSpeed * dist / a.ArriveRadius
+	}
+
+	desiredVel := toTarget
+	desiredVel.Scale(desiredSpeed / dist)
+
+	steering := gglm.SubVec3(&desiredVel, &a.Vel)
+	maxSteering := a.MaxAccel * dt
+	if steering.Mag() > maxSteering {
+		steering.Normalize().Scale(maxSteering)
+	}
+
+	a.Vel.Add(&steering)
+	if a.Vel.Mag() > a.MaxSpeed {
+		a.Vel.Normalize().Scale(a.MaxSpeed)
+	}
+
+	// Don't overshoot the final point
+	step := a.Vel.Clone().Scale(dt)
+	if isLastPoint && step.Mag() >= dist {
+		a.Pos = *target
+		a.Stop()
+		return
+	}
+
+	a.Pos.Add(step)
+}
+
+func NewAgent(pos *gglm.Vec3, maxSpeed, maxAccel float32) Agent {
+	return Agent{
+		Pos:            *pos,
+		MaxSpeed:       maxSpeed,
+		MaxAccel:       maxAccel,
+		WaypointRadius: 0.2,
+		ArriveRadius:   1,
+	}
+}
@@ -0,0 +1,232 @@
+package nav
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/bloeys/gglm/gglm"
+)
+
+// Poly is a convex polygon that is part of a navmesh.
+//
+// Vertices are stored as indices into the navmesh vertex array, and Neighbors[i] is the
+// index of the polygon sharing the edge (Verts[i], Verts[i+1]), or -1 if the edge is a border edge.
+type Poly struct {
+	Verts     []uint32
+	Neighbors []int32
+	Center    gglm.Vec3
+}
+
+type NavMesh struct {
+	Verts []gglm.Vec3
+	Polys []Poly
+}
+
+// NewNavMesh creates a navmesh by importing an already authored walkable triangle mesh (e.g. one made in a modelling tool).
+// Each triangle becomes one navmesh polygon, and triangles sharing an edge are connected.
+//
+// Vertices that share the same position are welded, so it is fine to pass meshes where
+// each triangle has its own vertices (as is common with exported models).
+func NewNavMesh(verts []gglm.Vec3, indices []uint32) (NavMesh, error) {
+
+	if len(indices) == 0 || len(indices)%3 != 0 {
+		return NavMesh{}, fmt.Errorf("failed to create navmesh because index count must be a non-zero multiple of 3, but got %d", len(indices))
+	}
+
+	nm := NavMesh{
+		Verts: make([]gglm.Vec3, 0, len(verts)),
+		Polys: make([]Poly, 0, len(indices)/3),
+	}
+
+	// Weld vertices
+	type vertKey [3]int32
+	const weldPrecision = 1000
+
+	weldedIndices := make([]uint32, len(verts))
+	keyToIndex := make(map[vertKey]uint32, len(verts))
+	for i := 0; i < len(verts); i++ {
+
+		v := &verts[i]
+		key := vertKey{
+			int32(math.Round(float64(v.X() * weldPrecision))),
+			int32(math.Round(float64(v.Y() * weldPrecision))),
+			int32(math.Round(float64(v.Z() * weldPrecision))),
+		}
+
+		index, ok := keyToIndex[key]
+		if !ok {
+			index = uint32(len(nm.Verts))
+			nm.Verts = append(nm.Verts, *v)
+			keyToIndex[key] = index
+		}
+
+		weldedIndices[i] = index
+	}
+
+	for i := 0; i < len(indices); i += 3 {
+
+		if indices[i] >= uint32(len(verts)) || indices[i+1] >= uint32(len(verts)) || indices[i+2] >= uint32(len(verts)) {
+			return NavMesh{}, fmt.Errorf("failed to create navmesh because triangle %d references a vertex that is out of range. Vertex count=%d", i/3, len(verts))
+		}
+
+		nm.Polys = append(nm.Polys, Poly{
+			Verts: []uint32{
+				weldedIndices[indices[i]],
+				weldedIndices[indices[i+1]],
+				weldedIndices[indices[i+2]],
+			},
+			Neighbors: []int32{-1, -1, -1},
+		})
+	}
+
+	nm.connectSharedEdges()
+	nm.calcCenters()
+
+	return nm, nil
+}
+
+// connectSharedEdges sets the neighbors of all polygons based on which polygons share an edge
+func (nm *NavMesh) connectSharedEdges() {
+
+	type edgeKey [2]uint32
+	type edgeRef struct {
+		polyIndex int32
+		edgeIndex int32
+	}
+
+	edges := make(map[edgeKey]edgeRef, len(nm.Polys)*3)
+	for i := 0; i < len(nm.Polys); i++ {
+
+		p := &nm.Polys[i]
+		for j := 0; j < len(p.Verts); j++ {
+
+			a := p.Verts[j]
+			b := p.Verts[(j+1)%len(p.Verts)]
+			if a > b {
+				a, b = b, a
+			}
+
+			key := edgeKey{a, b}
+			other, ok := edges[key]
+			if !ok {
+				edges[key] = edgeRef{polyIndex: int32(i), edgeIndex: int32(j)}
+				continue
+			}
+
+			p.Neighbors[j] = other.polyIndex
+			nm.Polys[other.polyIndex].Neighbors[other.edgeIndex] = int32(i)
+		}
+	}
+}
+
+func (nm *NavMesh) calcCenters() {
+
+	for i := 0; i < len(nm.Polys); i++ {
+
+		p := &nm.Polys[i]
+		p.Center = gglm.Vec3{}
+		for j := 0; j < len(p.Verts); j++ {
+			p.Center.Add(&nm.Verts[p.Verts[j]])
+		}
+		p.Center.Scale(1 / float32(len(p.Verts)))
+	}
+}
+
+// FindPoly returns the index of the polygon that contains the point when projected on the XZ plane.
+// If multiple polygons contain the point (e.g. multiple floors) the one closest on the Y axis is returned.
+//
+// If no polygon contains the point, the polygon with the closest center is returned, and -1 is only
+// returned if the navmesh is empty.
+func (nm *NavMesh) FindPoly(point *gglm.Vec3) int32 {
+
+	bestIndex := int32(-1)
+	var bestYDist float32 = math.MaxFloat32
+	for i := 0; i < len(nm.Polys); i++ {
+
+		p := &nm.Polys[i]
+		if !nm.polyContainsXZ(p, point) {
+			continue
+		}
+
+		yDist := gglm.Abs32(p.Center.Y() - point.Y())
+		if yDist < bestYDist {
+			bestYDist = yDist
+			bestIndex = int32(i)
+		}
+	}
+
+	if bestIndex != -1 {
+		return bestIndex
+	}
+
+	var bestSqrDist float32 = math.MaxFloat32
+	for i := 0; i < len(nm.Polys); i++ {
+
+		sqrDist := gglm.SqrDistVec3(&nm.Polys[i].Center, point)
+		if sqrDist < bestSqrDist {
+			bestSqrDist = sqrDist
+			bestIndex = int32(i)
+		}
+	}
+
+	return bestIndex
+}
+
+func (nm *NavMesh) polyContainsXZ(p *Poly, point *gglm.Vec3) bool {
+
+	// A point is inside a convex polygon if it is on the same side of all the edges
+	hasPositive := false
+	hasNegative := false
+	for i := 0; i < len(p.Verts); i++ {
+
+		a := &nm.Verts[p.Verts[i]]
+		b := &nm.Verts[p.Verts[(i+1)%len(p.Verts)]]
+
+		area := triArea2XZ(a, b, point)
+		if area > 0 {
+			hasPositive = true
+		} else if area < 0 {
+			hasNegative = true
+		}
+
+		if hasPositive && hasNegative {
+			return false
+		}
+	}
+
+	return true
+}
+
+// portal returns the edge shared between two neighboring polygons, with left and right
+// being relative to someone moving from the first polygon to the second
+func (nm *NavMesh) portal(fromPolyIndex, toPolyIndex int32) (left, right gglm.Vec3, err error) {
+
+	from := &nm.Polys[fromPolyIndex]
+	to := &nm.Polys[toPolyIndex]
+	for i := 0; i < len(from.Neighbors); i++ {
+
+		if from.Neighbors[i] != toPolyIndex {
+			continue
+		}
+
+		a := nm.Verts[from.Verts[i]]
+		b := nm.Verts[from.Verts[(i+1)%len(from.Verts)]]
+		if triArea2XZ(&from.Center, &to.Center, &a) > 0 {
+			return b, a, nil
+		}
+
+		return a, b, nil
+	}
+
+	return left, right, errors.New("failed to find portal because polygons are not neighbors")
+}
+
+// triArea2XZ returns twice the signed area of the triangle formed by the 3 points on the XZ plane
+func triArea2XZ(a, b, c *gglm.Vec3) float32 {
+	abX := b.X() - a.X()
+	abZ := b.Z() - a.Z()
+	acX := c.X() - a.X()
+	acZ := c.Z() - a.Z()
+	return acX*abZ - abX*acZ
+}
@@ -0,0 +1,341 @@
+package nav
+
+import (
+	"container/heap"
+	"errors"
+
+	"github.com/bloeys/gglm/gglm"
+)
+
+type searchNode struct {
+	polyIndex int32
+	parent    int32
+	costSoFar float32
+	totalCost float32
+	heapIndex int
+	closed    bool
+}
+
+type openList []*searchNode
+
+func (o openList) Len() int {
+	return len(o)
+}
+
+func (o openList) Less(i, j int) bool {
+	return o[i].totalCost < o[j].totalCost
+}
+
+func (o openList) Swap(i, j int) {
+	o[i], o[j] = o[j], o[i]
+	o[i].heapIndex = i
+	o[j].heapIndex = j
+}
+
+func (o *openList) Push(x any) {
+	n := x.(*searchNode)
+	n.heapIndex = len(*o)
+	*o = append(*o, n)
+}
+
+func (o *openList) Pop() any {
+	old := *o
+	n := old[len(old)-1]
+	old[len(old)-1] = nil
+	n.heapIndex = -1
+	*o = old[:len(old)-1]
+	return n
+}
+
+// FindPolyPath uses A* to find the list of polygons that connect the start and end polygons.
+// The returned path includes both the start and end polygons.
+func (nm *NavMesh) FindPolyPath(startPolyIndex, endPolyIndex int32) ([]int32, error) {
+
+	if startPolyIndex < 0 || startPolyIndex >= int32(len(nm.Polys)) || endPolyIndex < 0 || endPolyIndex >= int32(len(nm.Polys)) {
+		return nil, errors.New("failed to find path because start or end polygon index is out of range")
+	}
+
+	if startPolyIndex == endPolyIndex {
+		return []int32{startPolyIndex}, nil
+	}
+
+	endCenter := &nm.Polys[endPolyIndex].Center
+
+	nodes := make(map[int32]*searchNode, 64)
+	startNode := &searchNode{
+		polyIndex: startPolyIndex,
+		parent:    -1,
+		totalCost: gglm.DistVec3(&nm.Polys[startPolyIndex].Center, endCenter),
+	}
+	nodes[startPolyIndex] = startNode
+
+	open := &openList{}
+	heap.Push(open, startNode)
+
+	for open.Len() > 0 {
+
+		curr := heap.Pop(open).(*searchNode)
+		curr.closed = true
+
+		if curr.polyIndex == endPolyIndex {
+
+			path := make([]int32, 0, 16)
+			for n := curr; n != nil; n = nodes[n.parent] {
+				path = append(path, n.polyIndex)
+			}
+
+			// Path was built from the end so reverse it
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+
+			return path, nil
+		}
+
+		currPoly := &nm.Polys[curr.polyIndex]
+		for i := 0; i < len(currPoly.Neighbors); i++ {
+
+			neighborIndex := currPoly.Neighbors[i]
+			if neighborIndex == -1 {
+				continue
+			}
+
+			neighborPoly := &nm.Polys[neighborIndex]
+			costSoFar := curr.costSoFar + gglm.DistVec3(&currPoly.Center, &neighborPoly.Center)
+
+			n, ok := nodes[neighborIndex]
+			if !ok {
+
+				n = &searchNode{
+					polyIndex: neighborIndex,
+					parent:    curr.polyIndex,
+					costSoFar: costSoFar,
+					totalCost: costSoFar + gglm.DistVec3(&neighborPoly.Center, endCenter),
+				}
+
+				nodes[neighborIndex] = n
+				heap.Push(open, n)
+				continue
+			}
+
+			if n.closed || costSoFar >= n.costSoFar {
+				continue
+			}
+
+			n.totalCost += costSoFar - n.costSoFar
+			n.costSoFar = costSoFar
+			n.parent = curr.polyIndex
+			heap.Fix(open, n.heapIndex)
+		}
+	}
+
+	return nil, errors.New("failed to find path because the end polygon is not reachable from the start polygon")
+}
+
+// FindPath returns a list of points that go from start to end while staying on the navmesh.
+//
+// The first point is the start position and the last point is the end position.
+// If start or end are not on the navmesh then the nearest polygon to them is used.
+func (nm *NavMesh) FindPath(start, end *gglm.Vec3) ([]gglm.Vec3, error) {
+
+	if len(nm.Polys) == 0 {
+		return nil, errors.New("failed to find path because navmesh is empty")
+	}
+
+	polyPath, err := nm.FindPolyPath(nm.FindPoly(start), nm.FindPoly(end))
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := nm.stringPull(start, end, polyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return nm.smoothPath(path), nil
+}
+
+// smoothPath removes path points that can be skipped by walking in a straight line.
+//
+// The funnel algorithm gives the shortest path within the chosen polygons, but with many small polygons (e.g. from voxelization)
+// A* can choose a 'staircase' of polygons, so this removes the unneeded corners that creates
+func (nm *NavMesh) smoothPath(path []gglm.Vec3) []gglm.Vec3 {
+
+	if len(path) <= 2 {
+		return path
+	}
+
+	smoothed := make([]gglm.Vec3, 0, len(path))
+	smoothed = append(smoothed, path[0])
+
+	for curr := 0; curr < len(path)-1; {
+
+		// Find the furthest point we can directly walk to
+		next := curr + 1
+		for candidate := len(path) - 1; candidate > curr+1; candidate-- {
+			if nm.IsStraightWalkable(&path[curr], &path[candidate]) {
+				next = candidate
+				break
+			}
+		}
+
+		smoothed = append(smoothed, path[next])
+		curr = next
+	}
+
+	return smoothed
+}
+
+// IsStraightWalkable returns true if walking in a straight line (on the XZ plane) from start to end stays on the navmesh
+func (nm *NavMesh) IsStraightWalkable(start, end *gglm.Vec3) bool {
+
+	currPolyIndex := nm.FindPoly(start)
+	if currPolyIndex == -1 || !nm.polyContainsXZ(&nm.Polys[currPolyIndex], start) {
+		return false
+	}
+
+	segX := end.X() - start.X()
+	segZ := end.Z() - start.Z()
+
+	// Walk from polygon to polygon through the edges the segment exits from
+	for visited := 0; visited < len(nm.Polys); visited++ {
+
+		p := &nm.Polys[currPolyIndex]
+		if nm.polyContainsXZ(p, end) {
+			return true
+		}
+
+		exitEdge := -1
+		var exitT float32 = -1
+		for i := 0; i < len(p.Verts); i++ {
+
+			a := &nm.Verts[p.Verts[i]]
+			b := &nm.Verts[p.Verts[(i+1)%len(p.Verts)]]
+
+			edgeX := b.X() - a.X()
+			edgeZ := b.Z() - a.Z()
+			denom := segX*edgeZ - segZ*edgeX
+			if gglm.Abs32(denom) < 1e-9 {
+				continue
+			}
+
+			toEdgeX := a.X() - start.X()
+			toEdgeZ := a.Z() - start.Z()
+
+			// t is how far along the segment the intersection is, and s is how far along the edge
+			t := (toEdgeX*edgeZ - toEdgeZ*edgeX) / denom
+			s := (toEdgeX*segZ - toEdgeZ*segX) / denom
+			if s < -0.0001 || s > 1.0001 {
+				continue
+			}
+
+			if t > exitT {
+				exitT = t
+				exitEdge = i
+			}
+		}
+
+		if exitEdge == -1 || p.Neighbors[exitEdge] == -1 {
+			return false
+		}
+
+		currPolyIndex = p.Neighbors[exitEdge]
+	}
+
+	return false
+}
+
+// stringPull uses the 'simple stupid funnel algorithm' to find the shortest path through the portals
+// between the polygons in the poly path.
+//
+// Details here: https://digestingduck.blogspot.com/2010/03/simple-stupid-funnel-algorithm.html
+func (nm *NavMesh) stringPull(start, end *gglm.Vec3, polyPath []int32) ([]gglm.Vec3, error) {
+
+	// Portals include the start and end points as zero-width portals
+	portalLefts := make([]gglm.Vec3, 0, len(polyPath)+1)
+	portalRights := make([]gglm.Vec3, 0, len(polyPath)+1)
+
+	portalLefts = append(portalLefts, *start)
+	portalRights = append(portalRights, *start)
+	for i := 0; i < len(polyPath)-1; i++ {
+
+		left, right, err := nm.portal(polyPath[i], polyPath[i+1])
+		if err != nil {
+			return nil, err
+		}
+
+		portalLefts = append(portalLefts, left)
+		portalRights = append(portalRights, right)
+	}
+	portalLefts = append(portalLefts, *end)
+	portalRights = append(portalRights, *end)
+
+	path := make([]gglm.Vec3, 0, len(portalLefts))
+	path = append(path, *start)
+
+	apex, left, right := *start, *start, *start
+	apexIndex, leftIndex, rightIndex := 0, 0, 0
+	for i := 1; i < len(portalLefts); i++ {
+
+		newLeft := &portalLefts[i]
+		newRight := &portalRights[i]
+
+		// Update right side of the funnel
+		if triArea2XZ(&apex, &right, newRight) <= 0 {
+
+			if vec3EqXZ(&apex, &right) || triArea2XZ(&apex, &left, newRight) > 0 {
+
+				// Tighten the funnel
+				right = *newRight
+				rightIndex = i
+
+			} else {
+
+				// Right crossed over left, so left is a corner in the path and becomes the new apex
+				path = append(path, left)
+				apex = left
+				apexIndex = leftIndex
+
+				left, right = apex, apex
+				leftIndex, rightIndex = apexIndex, apexIndex
+
+				i = apexIndex
+				continue
+			}
+		}
+
+		// Update left side of the funnel
+		if triArea2XZ(&apex, &left, newLeft) >= 0 {
+
+			if vec3EqXZ(&apex, &left) || triArea2XZ(&apex, &right, newLeft) < 0 {
+
+				// Tighten the funnel
+				left = *newLeft
+				leftIndex = i
+
+			} else {
+
+				// Left crossed over right, so right is a corner in the path and becomes the new apex
+				path = append(path, right)
+				apex = right
+				apexIndex = rightIndex
+
+				left, right = apex, apex
+				leftIndex, rightIndex = apexIndex, apexIndex
+
+				i = apexIndex
+				continue
+			}
+		}
+	}
+
+	if !vec3EqXZ(&path[len(path)-1], end) || len(path) == 1 {
+		path = append(path, *end)
+	}
+
+	return path, nil
+}
+
+func vec3EqXZ(a, b *gglm.Vec3) bool {
+	return gglm.EqF32(a.X(), b.X()) && gglm.EqF32(a.Z(), b.Z())
+}
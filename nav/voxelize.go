@@ -0,0 +1,342 @@
+package nav
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/bloeys/gglm/gglm"
+)
+
+type BuildSettings struct {
+	// CellSize is the size of one voxel on the X and Z axes. Smaller values give more accurate navmeshes but take
+	// longer to build and produce more polygons
+	CellSize float32
+
+	// AgentHeight is the minimum free space required above a surface for it to be walkable
+	AgentHeight float32
+
+	// AgentRadius is how far from walls and ledges agents must stay. Walkable areas are shrunk by this amount
+	AgentRadius float32
+
+	// AgentMaxClimb is the max height difference between two neighboring cells for agents to be able to walk between them (e.g. stairs)
+	AgentMaxClimb float32
+
+	// AgentMaxSlopeRad is the max slope in radians of a surface for it to be walkable
+	AgentMaxSlopeRad float32
+}
+
+// DefaultBuildSettings returns settings that work for a human sized agent in a scene where 1 unit is 1 meter
+func DefaultBuildSettings() BuildSettings {
+	return BuildSettings{
+		CellSize:         0.3,
+		AgentHeight:      2,
+		AgentRadius:      0.5,
+		AgentMaxClimb:    0.4,
+		AgentMaxSlopeRad: 45 * gglm.Deg2Rad,
+	}
+}
+
+// surfaceSample is a point on a surface of the level geometry that was found inside a voxel column
+type surfaceSample struct {
+	Height    float32
+	IsFloor   bool
+	NodeIndex int32
+}
+
+type voxelNode struct {
+	CellX     int32
+	CellZ     int32
+	Height    float32
+	Neighbors [4]int32
+	IsRemoved bool
+}
+
+// cellNeighborOffsets are the offsets of the 4 neighbors of a cell in the order left, forward, right, back.
+// The order matches the order of the edges of the polygons created for the cells
+var cellNeighborOffsets = [4][2]int32{{-1, 0}, {0, 1}, {1, 0}, {0, -1}}
+
+// BuildNavMesh voxelizes the level geometry and produces a navmesh of the areas an agent can walk on.
+//
+// Vertices and indices are a triangle list of the level geometry in world space, where triangles
+// are counter-clockwise (i.e. the same as what we use for rendering with back face culling).
+func BuildNavMesh(verts []gglm.Vec3, indices []uint32, settings *BuildSettings) (NavMesh, error) {
+
+	if settings == nil {
+		s := DefaultBuildSettings()
+		settings = &s
+	}
+
+	if settings.CellSize <= 0 {
+		return NavMesh{}, fmt.Errorf("failed to build navmesh because cell size must be larger than zero, but got %f", settings.CellSize)
+	}
+
+	if len(indices) == 0 || len(indices)%3 != 0 {
+		return NavMesh{}, fmt.Errorf("failed to build navmesh because index count must be a non-zero multiple of 3, but got %d", len(indices))
+	}
+
+	// Find bounds of the geometry on the XZ plane
+	var minX, minZ float32 = math.MaxFloat32, math.MaxFloat32
+	var maxX, maxZ float32 = -math.MaxFloat32, -math.MaxFloat32
+	for i := 0; i < len(indices); i++ {
+
+		if indices[i] >= uint32(len(verts)) {
+			return NavMesh{}, fmt.Errorf("failed to build navmesh because index %d references a vertex that is out of range. Vertex count=%d", i, len(verts))
+		}
+
+		v := &verts[indices[i]]
+		minX = min(minX, v.X())
+		minZ = min(minZ, v.Z())
+		maxX = max(maxX, v.X())
+		maxZ = max(maxZ, v.Z())
+	}
+
+	cellsX := int32(math.Ceil(float64((maxX-minX)/settings.CellSize))) + 1
+	cellsZ := int32(math.Ceil(float64((maxZ-minZ)/settings.CellSize))) + 1
+	columns := make([][]surfaceSample, cellsX*cellsZ)
+
+	// Rasterize triangles into the voxel columns by sampling each triangle at the center of the cells it covers
+	minFloorNormalY := gglm.Cos32(settings.AgentMaxSlopeRad)
+	for i := 0; i < len(indices); i += 3 {
+
+		v0 := &verts[indices[i]]
+		v1 := &verts[indices[i+1]]
+		v2 := &verts[indices[i+2]]
+
+		edge1 := gglm.SubVec3(v1, v0)
+		edge2 := gglm.SubVec3(v2, v0)
+		normal := gglm.Cross(&edge1, &edge2)
+		if normal.SqrMag() == 0 {
+			continue
+		}
+		normal.Normalize()
+
+		// Vertical triangles have no area on the XZ plane and don't produce samples.
+		// They still block movement because the surfaces on both of their sides end up at different heights
+		area := triArea2XZ(v0, v1, v2)
+		if gglm.Abs32(area) < 1e-6 {
+			continue
+		}
+
+		startCellX := int32((min(v0.X(), v1.X(), v2.X()) - minX) / settings.CellSize)
+		startCellZ := int32((min(v0.Z(), v1.Z(), v2.Z()) - minZ) / settings.CellSize)
+		endCellX := min(int32((max(v0.X(), v1.X(), v2.X())-minX)/settings.CellSize), cellsX-1)
+		endCellZ := min(int32((max(v0.Z(), v1.Z(), v2.Z())-minZ)/settings.CellSize), cellsZ-1)
+
+		for z := startCellZ; z <= endCellZ; z++ {
+			for x := startCellX; x <= endCellX; x++ {
+
+				cellCenter := gglm.NewVec3(minX+(float32(x)+0.5)*settings.CellSize, 0, minZ+(float32(z)+0.5)*settings.CellSize)
+
+				// Barycentric coordinates on the XZ plane
+				w0 := triArea2XZ(v1, v2, &cellCenter) / area
+				w1 := triArea2XZ(v2, v0, &cellCenter) / area
+				w2 := 1 - w0 - w1
+				if w0 < 0 || w1 < 0 || w2 < 0 {
+					continue
+				}
+
+				columnIndex := z*cellsX + x
+				columns[columnIndex] = append(columns[columnIndex], surfaceSample{
+					Height:    w0*v0.Y() + w1*v1.Y() + w2*v2.Y(),
+					IsFloor:   normal.Y() >= minFloorNormalY,
+					NodeIndex: -1,
+				})
+			}
+		}
+	}
+
+	// Create a node for every floor sample that has enough space above it
+	nodes := make([]voxelNode, 0, len(columns))
+	for z := int32(0); z < cellsZ; z++ {
+		for x := int32(0); x < cellsX; x++ {
+
+			column := columns[z*cellsX+x]
+			for i := 0; i < len(column); i++ {
+
+				s := &column[i]
+				if !s.IsFloor {
+					continue
+				}
+
+				hasClearance := true
+				for j := 0; j < len(column); j++ {
+
+					otherHeight := column[j].Height
+					if otherHeight > s.Height && otherHeight <= s.Height+settings.AgentHeight {
+						hasClearance = false
+						break
+					}
+				}
+
+				if !hasClearance {
+					continue
+				}
+
+				s.NodeIndex = int32(len(nodes))
+				nodes = append(nodes, voxelNode{
+					CellX:     x,
+					CellZ:     z,
+					Height:    s.Height,
+					Neighbors: [4]int32{-1, -1, -1, -1},
+				})
+			}
+		}
+	}
+
+	if len(nodes) == 0 {
+		return NavMesh{}, errors.New("failed to build navmesh because no walkable surfaces were found")
+	}
+
+	// Connect nodes to the walkable nodes in neighboring columns
+	for i := 0; i < len(nodes); i++ {
+
+		n := &nodes[i]
+		for dir := 0; dir < len(cellNeighborOffsets); dir++ {
+
+			nx := n.CellX + cellNeighborOffsets[dir][0]
+			nz := n.CellZ + cellNeighborOffsets[dir][1]
+			if nx < 0 || nz < 0 || nx >= cellsX || nz >= cellsZ {
+				continue
+			}
+
+			var bestHeightDiff float32 = math.MaxFloat32
+			neighborColumn := columns[nz*cellsX+nx]
+			for j := 0; j < len(neighborColumn); j++ {
+
+				s := &neighborColumn[j]
+				if s.NodeIndex == -1 {
+					continue
+				}
+
+				heightDiff := gglm.Abs32(s.Height - n.Height)
+				if heightDiff <= settings.AgentMaxClimb && heightDiff < bestHeightDiff {
+					bestHeightDiff = heightDiff
+					n.Neighbors[dir] = s.NodeIndex
+				}
+			}
+		}
+	}
+
+	erodeNodes(nodes, int(math.Ceil(float64(settings.AgentRadius/settings.CellSize))))
+
+	return nodesToNavMesh(nodes, minX, minZ, settings.CellSize)
+}
+
+// erodeNodes removes the nodes that are within 'iterations' cells of a border node,
+// so that agents walking on the navmesh stay away from walls and ledges
+func erodeNodes(nodes []voxelNode, iterations int) {
+
+	toRemove := make([]int32, 0, 64)
+	for iter := 0; iter < iterations; iter++ {
+
+		toRemove = toRemove[:0]
+		for i := 0; i < len(nodes); i++ {
+
+			n := &nodes[i]
+			if n.IsRemoved {
+				continue
+			}
+
+			for dir := 0; dir < len(n.Neighbors); dir++ {
+				if n.Neighbors[dir] == -1 {
+					toRemove = append(toRemove, int32(i))
+					break
+				}
+			}
+		}
+
+		if len(toRemove) == 0 {
+			return
+		}
+
+		for _, nodeIndex := range toRemove {
+
+			n := &nodes[nodeIndex]
+			n.IsRemoved = true
+			for dir := 0; dir < len(n.Neighbors); dir++ {
+
+				neighborIndex := n.Neighbors[dir]
+				if neighborIndex == -1 {
+					continue
+				}
+
+				neighbor := &nodes[neighborIndex]
+				for k := 0; k < len(neighbor.Neighbors); k++ {
+					if neighbor.Neighbors[k] == nodeIndex {
+						neighbor.Neighbors[k] = -1
+					}
+				}
+
+				n.Neighbors[dir] = -1
+			}
+		}
+	}
+}
+
+func nodesToNavMesh(nodes []voxelNode, minX, minZ, cellSize float32) (NavMesh, error) {
+
+	nodeToPolyIndex := make([]int32, len(nodes))
+	polyCount := 0
+	for i := 0; i < len(nodes); i++ {
+
+		if nodes[i].IsRemoved {
+			nodeToPolyIndex[i] = -1
+			continue
+		}
+
+		nodeToPolyIndex[i] = int32(polyCount)
+		polyCount++
+	}
+
+	if polyCount == 0 {
+		return NavMesh{}, errors.New("failed to build navmesh because no walkable surfaces remained after shrinking by agent radius")
+	}
+
+	nm := NavMesh{
+		Verts: make([]gglm.Vec3, 0, polyCount*4),
+		Polys: make([]Poly, 0, polyCount),
+	}
+
+	for i := 0; i < len(nodes); i++ {
+
+		n := &nodes[i]
+		if n.IsRemoved {
+			continue
+		}
+
+		x0 := minX + float32(n.CellX)*cellSize
+		z0 := minZ + float32(n.CellZ)*cellSize
+		x1 := x0 + cellSize
+		z1 := z0 + cellSize
+
+		// Edges are in the same order as cellNeighborOffsets (left, forward, right, back)
+		firstVert := uint32(len(nm.Verts))
+		nm.Verts = append(nm.Verts,
+			gglm.NewVec3(x0, n.Height, z0),
+			gglm.NewVec3(x0, n.Height, z1),
+			gglm.NewVec3(x1, n.Height, z1),
+			gglm.NewVec3(x1, n.Height, z0),
+		)
+
+		p := Poly{
+			Verts:     []uint32{firstVert, firstVert + 1, firstVert + 2, firstVert + 3},
+			Neighbors: make([]int32, 4),
+			Center:    gglm.NewVec3(x0+cellSize*0.5, n.Height, z0+cellSize*0.5),
+		}
+
+		for dir := 0; dir < len(n.Neighbors); dir++ {
+
+			if n.Neighbors[dir] == -1 {
+				p.Neighbors[dir] = -1
+				continue
+			}
+
+			p.Neighbors[dir] = nodeToPolyIndex[n.Neighbors[dir]]
+		}
+
+		nm.Polys = append(nm.Polys, p)
+	}
+
+	return nm, nil
+}
@@ -0,0 +1,302 @@
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/bloeys/nmage/entity"
+	"github.com/bloeys/nmage/level"
+	"github.com/bloeys/nmage/registry"
+)
+
+// SaveFile is the format of save files on disk. Component and global data are kept as raw JSON so migrations
+// can modify it without needing the Go types of old versions
+type SaveFile struct {
+	Version   uint32
+	LevelName string
+	Globals   map[string]json.RawMessage
+	Entities  []SavedEntity
+}
+
+type SavedEntity struct {
+	Comps []SavedComp
+}
+
+type SavedComp struct {
+	// Type is the name the component type was registered with
+	Type string
+	Data json.RawMessage
+}
+
+// Migration upgrades a save file from one version to the next. Migrations only need to modify the save file,
+// and the version is increased automatically after the migration runs
+type Migration func(sf *SaveFile) error
+
+var (
+	currentVersion uint32 = 1
+
+	compTypes       = map[string]reflect.Type{}
+	compTypeToNames = map[reflect.Type]string{}
+	globals         = map[string]any{}
+	migrations      = map[uint32]Migration{}
+)
+
+// SetVersion sets the version written into new saves. When the save format of the game changes
+// increase the version and register a migration from the previous version
+func SetVersion(version uint32) {
+	currentVersion = version
+}
+
+func GetVersion() uint32 {
+	return currentVersion
+}
+
+// RegisterComp allows components of type T to be saved and loaded. T must be a pointer to a struct (e.g. *MyComp),
+// and only its exported fields are saved (fields can be skipped with `json:"-"`).
+//
+// The name is stored in save files, so it must not change between versions unless a migration handles it
+func RegisterComp[T entity.Comp](name string) {
+
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Pointer || t.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("failed to register component type '%s' because it must be a pointer to a struct", t.String()))
+	}
+
+	if existingType, ok := compTypes[name]; ok && existingType != t {
+		panic(fmt.Sprintf("failed to register component type '%s' because the name '%s' is already used by '%s'", t.String(), name, existingType.String()))
+	}
+
+	compTypes[name] = t
+	compTypeToNames[t] = name
+}
+
+// RegisterGlobal saves and loads the value pointed to by ptr under the given name
+// (e.g. player progress or settings stored in package level variables)
+func RegisterGlobal(name string, ptr any) {
+
+	if reflect.TypeOf(ptr).Kind() != reflect.Pointer {
+		panic(fmt.Sprintf("failed to register global '%s' because a pointer must be passed", name))
+	}
+
+	globals[name] = ptr
+}
+
+// RegisterMigration registers the function that upgrades save files of version fromVersion to version fromVersion+1
+func RegisterMigration(fromVersion uint32, m Migration) {
+	migrations[fromVersion] = m
+}
+
+// Save writes the level name, registered globals and all entities in the registry to a save file.
+// Components whose type was not registered are skipped.
+//
+// comps returns the components of an entity, since entities are game defined types.
+// The file is written to a temporary file first so a crash while saving doesn't corrupt an existing save
+func Save[T any](path string, lvl *level.Level, reg *registry.Registry[T], comps func(e *T) *entity.CompContainer) error {
+
+	sf := SaveFile{
+		Version:  currentVersion,
+		Globals:  make(map[string]json.RawMessage, len(globals)),
+		Entities: make([]SavedEntity, 0, reg.ItemCount),
+	}
+
+	if lvl != nil {
+		sf.LevelName = lvl.Name
+	}
+
+	for name, ptr := range globals {
+
+		data, err := json.Marshal(ptr)
+		if err != nil {
+			return fmt.Errorf("failed to save global '%s'. Err: %w", name, err)
+		}
+
+		sf.Globals[name] = data
+	}
+
+	it := reg.NewIterator()
+	for e, _ := it.Next(); !it.IsDone(); e, _ = it.Next() {
+
+		cc := comps(e)
+		savedEntity := SavedEntity{
+			Comps: make([]SavedComp, 0, len(cc.Comps)),
+		}
+
+		for i := 0; i < len(cc.Comps); i++ {
+
+			c := cc.Comps[i]
+			typeName, ok := compTypeToNames[reflect.TypeOf(c)]
+			if !ok {
+				continue
+			}
+
+			data, err := json.Marshal(c)
+			if err != nil {
+				return fmt.Errorf("failed to save component '%s'. Err: %w", typeName, err)
+			}
+
+			savedEntity.Comps = append(savedEntity.Comps, SavedComp{Type: typeName, Data: data})
+		}
+
+		sf.Entities = append(sf.Entities, savedEntity)
+	}
+
+	data, err := json.MarshalIndent(&sf, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to encode save file. Err: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write save file '%s'. Err: %w", tmpPath, err)
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return fmt.Errorf("failed to move save file from '%s' to '%s'. Err: %w", tmpPath, path, err)
+	}
+
+	return nil
+}
+
+// ReadSaveFile reads a save file and migrates it to the current version, without applying it
+func ReadSaveFile(path string) (SaveFile, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return SaveFile{}, fmt.Errorf("failed to read save file '%s'. Err: %w", path, err)
+	}
+
+	sf := SaveFile{}
+	err = json.Unmarshal(data, &sf)
+	if err != nil {
+		return SaveFile{}, fmt.Errorf("failed to decode save file '%s'. Err: %w", path, err)
+	}
+
+	if sf.Version > currentVersion {
+		return SaveFile{}, fmt.Errorf("failed to load save file '%s' because its version (%d) is newer than the game's save version (%d)", path, sf.Version, currentVersion)
+	}
+
+	for sf.Version < currentVersion {
+
+		m, ok := migrations[sf.Version]
+		if !ok {
+			return SaveFile{}, fmt.Errorf("failed to load save file '%s' because there is no migration from version %d", path, sf.Version)
+		}
+
+		err = m(&sf)
+		if err != nil {
+			return SaveFile{}, fmt.Errorf("failed to migrate save file '%s' from version %d. Err: %w", path, sf.Version, err)
+		}
+
+		sf.Version++
+	}
+
+	return sf, nil
+}
+
+// Load reads a save file, migrates it if needed, and restores it. All entities in the registry are destroyed
+// and replaced by the saved ones, and registered globals are overwritten.
+//
+// The returned level is the level that was active when saving, which the caller should load.
+// Nothing is changed if reading or decoding the save file fails, or if the registry can't hold all the saved entities
+func Load[T any](path string, reg *registry.Registry[T], comps func(e *T) *entity.CompContainer) (*level.Level, error) {
+
+	sf, err := ReadSaveFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode everything first so that a bad save doesn't leave the game half loaded
+	decodedEntities := make([][]entity.Comp, len(sf.Entities))
+	for i := 0; i < len(sf.Entities); i++ {
+
+		savedEntity := &sf.Entities[i]
+		decodedEntities[i] = make([]entity.Comp, 0, len(savedEntity.Comps))
+
+		for j := 0; j < len(savedEntity.Comps); j++ {
+
+			savedComp := &savedEntity.Comps[j]
+			t, ok := compTypes[savedComp.Type]
+			if !ok {
+				return nil, fmt.Errorf("failed to load save file '%s' because component type '%s' is not registered", path, savedComp.Type)
+			}
+
+			compVal := reflect.New(t.Elem())
+			err = json.Unmarshal(savedComp.Data, compVal.Interface())
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode component '%s' in save file '%s'. Err: %w", savedComp.Type, path, err)
+			}
+
+			decodedEntities[i] = append(decodedEntities[i], compVal.Interface().(entity.Comp))
+		}
+	}
+
+	decodedGlobals := make(map[string]reflect.Value, len(sf.Globals))
+	for name, data := range sf.Globals {
+
+		ptr, ok := globals[name]
+		if !ok {
+			continue
+		}
+
+		newVal := reflect.New(reflect.TypeOf(ptr).Elem())
+		err = json.Unmarshal(data, newVal.Interface())
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode global '%s' in save file '%s'. Err: %w", name, path, err)
+		}
+
+		decodedGlobals[name] = newVal
+	}
+
+	if len(decodedEntities) > len(reg.Handles) {
+		return nil, fmt.Errorf("failed to load save file '%s' because it has %d entities while the registry only has %d slots", path, len(decodedEntities), len(reg.Handles))
+	}
+
+	// Apply
+	for name, newVal := range decodedGlobals {
+		reflect.ValueOf(globals[name]).Elem().Set(newVal.Elem())
+	}
+
+	destroyAllEntities(reg, comps)
+
+	for i := 0; i < len(decodedEntities); i++ {
+
+		e, handle := reg.New()
+		cc := comps(e)
+
+		for _, c := range decodedEntities[i] {
+			cc.Comps = append(cc.Comps, c)
+			c.Init(handle)
+		}
+	}
+
+	if sf.LevelName == "" {
+		return nil, nil
+	}
+
+	return level.NewLevel(sf.LevelName), nil
+}
+
+func destroyAllEntities[T any](reg *registry.Registry[T], comps func(e *T) *entity.CompContainer) {
+
+	handles := make([]registry.Handle, 0, reg.ItemCount)
+
+	it := reg.NewIterator()
+	for e, handle := it.Next(); !it.IsDone(); e, handle = it.Next() {
+
+		cc := comps(e)
+		for i := 0; i < len(cc.Comps); i++ {
+			cc.Comps[i].Destroy()
+		}
+		cc.Comps = cc.Comps[:0]
+
+		handles = append(handles, handle)
+	}
+
+	for _, h := range handles {
+		reg.Free(h)
+	}
+}
@@ -0,0 +1,329 @@
+// The postprocess package has effects that run on the rendered HDR scene before it is tonemapped
+package postprocess
+
+import (
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/assert"
+	"github.com/bloeys/nmage/buffers"
+	"github.com/bloeys/nmage/materials"
+	"github.com/bloeys/nmage/renderer"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+const bloomShader = `
+//shader:vertex
+#version 410
+
+out vec2 vertUV0;
+
+vec2 quadData[6] = vec2[](
+    vec2(0.0, 1.0),
+    vec2(0.0, 0.0),
+    vec2(1.0, 0.0),
+    vec2(0.0, 1.0),
+    vec2(1.0, 0.0),
+    vec2(1.0, 1.0)
+);
+
+void main()
+{
+    vertUV0 = quadData[gl_VertexID];
+    gl_Position = vec4(vertUV0 * 2.0 - 1.0, 0.0, 1.0);
+}
+
+//shader:fragment
+#version 410
+
+#define MODE_PREFILTER 0
+#define MODE_DOWNSAMPLE 1
+#define MODE_UPSAMPLE 2
+
+struct Material {
+    sampler2D diffuse;
+};
+
+uniform Material material;
+
+uniform int mode;
+uniform vec2 srcTexelSize;
+
+// x is the threshold, y the knee, and z is 1/(4*knee) which is used by the soft threshold curve
+uniform vec3 threshold;
+uniform float filterRadius;
+
+in vec2 vertUV0;
+
+out vec4 fragColor;
+
+// Edges are clamped instead of wrapping so one side of the screen doesn't bloom onto the other
+vec3 Sample(vec2 uv)
+{
+    return texture(material.diffuse, clamp(uv, srcTexelSize * 0.5, 1.0 - srcTexelSize * 0.5)).rgb;
+}
+
+// Passes what is above the threshold, with a quadratic curve around it so pixels fade into the bloom
+vec3 ApplyThreshold(vec3 color)
+{
+    float brightness = max(color.r, max(color.g, color.b));
+
+    float soft = clamp(brightness - threshold.x + threshold.y, 0.0, 2.0 * threshold.y);
+    soft = soft * soft * threshold.z;
+
+    float contribution = max(soft, brightness - threshold.x) / max(brightness, 0.0001);
+    return color * contribution;
+}
+
+// Weighs by inverse brightness so single very bright pixels don't become flickering blobs
+float KarisWeight(vec3 color)
+{
+    return 1.0 / (1.0 + max(color.r, max(color.g, color.b)));
+}
+
+// 13 reads in overlapping 4x4 boxes, which keeps the downsampled image stable as the camera moves
+vec3 Downsample13(vec2 uv, bool useKarisAverage)
+{
+    vec2 t = srcTexelSize;
+
+    vec3 a = Sample(uv + t * vec2(-2, 2));
+    vec3 b = Sample(uv + t * vec2(0, 2));
+    vec3 c = Sample(uv + t * vec2(2, 2));
+    vec3 d = Sample(uv + t * vec2(-2, 0));
+    vec3 e = Sample(uv);
+    vec3 f = Sample(uv + t * vec2(2, 0));
+    vec3 g = Sample(uv + t * vec2(-2, -2));
+    vec3 h = Sample(uv + t * vec2(0, -2));
+    vec3 i = Sample(uv + t * vec2(2, -2));
+    vec3 j = Sample(uv + t * vec2(-1, 1));
+    vec3 k = Sample(uv + t * vec2(1, 1));
+    vec3 l = Sample(uv + t * vec2(-1, -1));
+    vec3 m = Sample(uv + t * vec2(1, -1));
+
+    vec3 boxes[5] = vec3[](
+        (j + k + l + m) * 0.25,
+        (a + b + d + e) * 0.25,
+        (b + c + e + f) * 0.25,
+        (d + e + g + h) * 0.25,
+        (e + f + h + i) * 0.25
+    );
+    float boxWeights[5] = float[](0.5, 0.125, 0.125, 0.125, 0.125);
+
+    vec3 result = vec3(0);
+    float weightSum = 0;
+    for (int n = 0; n < 5; n++)
+    {
+        float w = boxWeights[n];
+        if (useKarisAverage)
+            w *= KarisWeight(boxes[n]);
+
+        result += boxes[n] * w;
+        weightSum += w;
+    }
+
+    return result / weightSum;
+}
+
+// 3x3 tent filter, which smooths out the blockiness of the smaller mip
+vec3 UpsampleTent(vec2 uv)
+{
+    vec2 r = srcTexelSize * filterRadius;
+
+    vec3 result = Sample(uv) * 4.0;
+    result += (Sample(uv + vec2(0, r.y)) + Sample(uv - vec2(0, r.y)) + Sample(uv + vec2(r.x, 0)) + Sample(uv - vec2(r.x, 0))) * 2.0;
+    result += Sample(uv + r) + Sample(uv - r) + Sample(uv + vec2(r.x, -r.y)) + Sample(uv + vec2(-r.x, r.y));
+
+    return result / 16.0;
+}
+
+void main()
+{
+    vec3 color;
+    if (mode == MODE_PREFILTER)
+        color = ApplyThreshold(Downsample13(vertUV0, true));
+    else if (mode == MODE_DOWNSAMPLE)
+        color = Downsample13(vertUV0, false);
+    else
+        color = UpsampleTent(vertUV0);
+
+    fragColor = vec4(color, 1);
+}
+`
+
+const (
+	bloomMode_Prefilter int32 = iota
+	bloomMode_Downsample
+	bloomMode_Upsample
+)
+
+const (
+	// bloomMinMipSize stops the mip chain before mips get too small to add anything to the blur
+	bloomMinMipSize = 8
+)
+
+// Bloom makes bright parts of an HDR image glow, by blurring everything above Threshold so it can be added back when tonemapping.
+//
+// The bright parts are downsampled through a chain of half size mips, then upsampled back with each mip added onto the one
+// above it. This gives a wide blur for a few reads per pixel, and is the method from Call of Duty: Advanced Warfare
+type Bloom struct {
+	// Mips are the blur chain, where Mips[0] is half the size of the source and has the final bloom after Render
+	Mips []buffers.Framebuffer
+
+	// Threshold is the brightness above which pixels bloom. Lit surfaces rarely go above one while emissive materials
+	// with intensities above one do, so a threshold around one makes mostly emissive surfaces glow
+	Threshold float32 `editor:"min=0,max=20,speed=0.01"`
+
+	// Knee softens the threshold over [Threshold-Knee, Threshold+Knee] so pixels don't pop in and out of the bloom
+	Knee float32 `editor:"min=0,max=5,speed=0.01"`
+
+	// Intensity is how much of the bloom is added to the image
+	Intensity float32 `editor:"min=0,max=5,speed=0.01"`
+
+	// Radius is the size of the upsample filter in texels of each mip. Bigger radii give a softer, wider glow
+	Radius float32 `editor:"min=0.5,max=4,speed=0.01"`
+
+	MaxMipCount int32 `editor:"min=1,max=10"`
+
+	mat materials.Material
+	vao buffers.VertexArray
+
+	srcWidth  int32
+	srcHeight int32
+}
+
+// TexID returns the texture with the result of the last Render, or zero if nothing was rendered yet
+func (b *Bloom) TexID() uint32 {
+
+	if len(b.Mips) == 0 {
+		return 0
+	}
+
+	return b.Mips[0].ColorTex(0)
+}
+
+// Render blurs the bright parts of the srcWidth*srcHeight HDR texture srcTexID, which can then be read from TexID.
+// Blending is changed while rendering and restored to the engine default after
+func (b *Bloom) Render(rend renderer.Render, srcTexID uint32, srcWidth, srcHeight int32) {
+
+	b.resize(srcWidth, srcHeight)
+	if len(b.Mips) == 0 {
+		return
+	}
+
+	knee := max(b.Knee, 0.0001)
+	thresholdParams := gglm.NewVec3(b.Threshold, knee, 0.25/knee)
+	b.mat.SetUnifVec3("threshold", &thresholdParams)
+	b.mat.SetUnifFloat32("filterRadius", b.Radius)
+
+	wasBlendEnabled := gl.IsEnabled(gl.BLEND)
+	gl.Disable(gl.BLEND)
+
+	// Downsample, with the threshold applied while making the first mip
+	srcTex, w, h := srcTexID, srcWidth, srcHeight
+	for i := 0; i < len(b.Mips); i++ {
+
+		mode := bloomMode_Downsample
+		if i == 0 {
+			mode = bloomMode_Prefilter
+		}
+
+		b.drawPass(rend, mode, srcTex, w, h, &b.Mips[i])
+		srcTex, w, h = b.Mips[i].ColorTex(0), int32(b.Mips[i].Width), int32(b.Mips[i].Height)
+	}
+
+	// Upsample each mip additively onto the bigger one
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.ONE, gl.ONE)
+
+	for i := len(b.Mips) - 1; i > 0; i-- {
+		src := &b.Mips[i]
+		b.drawPass(rend, bloomMode_Upsample, src.ColorTex(0), int32(src.Width), int32(src.Height), &b.Mips[i-1])
+	}
+
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+	if !wasBlendEnabled {
+		gl.Disable(gl.BLEND)
+	}
+}
+
+func (b *Bloom) drawPass(rend renderer.Render, mode int32, srcTexID uint32, srcWidth, srcHeight int32, dst *buffers.Framebuffer) {
+
+	texelSize := gglm.NewVec2(1/float32(srcWidth), 1/float32(srcHeight))
+	b.mat.SetUnifInt32("mode", mode)
+	b.mat.SetUnifVec2("srcTexelSize", &texelSize)
+
+	// The renderer only binds the material when it changes, so the source is also bound here for passes after the first
+	b.mat.DiffuseTex = srcTexID
+	gl.ActiveTexture(uint32(gl.TEXTURE0 + materials.TextureSlot_Diffuse))
+	gl.BindTexture(gl.TEXTURE_2D, srcTexID)
+
+	dst.Bind()
+	rend.PushViewport(0, 0, int32(dst.Width), int32(dst.Height))
+
+	// Downsampled mips are fully overwritten, while upsampling adds onto what the downsample left
+	if mode != bloomMode_Upsample {
+		dst.Clear()
+	}
+
+	rend.DrawVertexArray(&b.mat, &b.vao, 0, 6)
+
+	rend.PopViewport()
+	dst.UnBind()
+}
+
+// resize reallocates the mips when the source size changes
+func (b *Bloom) resize(srcWidth, srcHeight int32) {
+
+	mipCount := int32(0)
+	for w, h := srcWidth/2, srcHeight/2; mipCount < b.MaxMipCount && w >= bloomMinMipSize && h >= bloomMinMipSize; w, h = w/2, h/2 {
+		mipCount++
+	}
+
+	if srcWidth == b.srcWidth && srcHeight == b.srcHeight && int32(len(b.Mips)) == mipCount {
+		return
+	}
+
+	b.deleteMips()
+	b.srcWidth = srcWidth
+	b.srcHeight = srcHeight
+
+	w, h := srcWidth/2, srcHeight/2
+	for i := int32(0); i < mipCount; i++ {
+
+		mip := assert.MustGet(buffers.NewFramebuffer(uint32(w), uint32(h)))
+		assert.Must(mip.NewColorAttachment(buffers.FramebufferAttachmentType_Texture, buffers.FramebufferAttachmentDataFormat_RGBAF16))
+		assert.T(mip.IsComplete(), "Bloom mip %d framebuffer is not complete", i)
+
+		b.Mips = append(b.Mips, mip)
+		w, h = w/2, h/2
+	}
+}
+
+func (b *Bloom) deleteMips() {
+
+	for i := 0; i < len(b.Mips); i++ {
+		b.Mips[i].Delete()
+	}
+
+	b.Mips = b.Mips[:0]
+}
+
+func (b *Bloom) Delete() {
+	b.deleteMips()
+	b.vao.Delete()
+	b.mat.Delete()
+}
+
+func NewBloom() Bloom {
+
+	b := Bloom{
+		Threshold:   1,
+		Knee:        0.5,
+		Intensity:   0.3,
+		Radius:      1,
+		MaxMipCount: 6,
+		mat:         assert.MustGet(materials.NewMaterialSrc("Bloom Mat", []byte(bloomShader))),
+		vao:         buffers.NewVertexArray(),
+	}
+
+	b.mat.SetUnifInt32("material.diffuse", int32(materials.TextureSlot_Diffuse))
+	return b
+}
@@ -0,0 +1,78 @@
+package renderer
+
+import (
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/color"
+)
+
+type BillboardMode int32
+
+const (
+	// BillboardMode_Spherical always faces the camera fully, like particles and light flares
+	BillboardMode_Spherical BillboardMode = iota
+
+	// BillboardMode_Cylindrical only turns around Billboard.Up to face the camera, so it stays upright like distant trees
+	BillboardMode_Cylindrical
+)
+
+func (bm BillboardMode) String() string {
+	switch bm {
+	case BillboardMode_Spherical:
+		return "Spherical"
+	case BillboardMode_Cylindrical:
+		return "Cylindrical"
+	default:
+		return "Unknown"
+	}
+}
+
+// Billboard is a quad that is turned to face the camera in the vertex shader. Its material needs the uniforms
+// of res/shaders/billboard.glsl, where viewMat and projMat are set by the caller and the rest by the renderer
+type Billboard struct {
+	Mode BillboardMode
+
+	// Pos is the center of the quad in world space
+	Pos gglm.Vec3
+
+	// Size is in world units
+	Size gglm.Vec2
+
+	// Rotation turns the quad around its center in radians, e.g. to spin particles
+	Rotation float32
+
+	// Up is the axis cylindrical billboards turn around. Zero uses +Y
+	Up gglm.Vec3
+
+	// UVMin is the uv of the top left corner and UVMax of the bottom right corner, so billboards can show a sprite sheet frame
+	UVMin gglm.Vec2
+	UVMax gglm.Vec2
+
+	// Color tints the material's diffuse texture
+	Color color.Color
+}
+
+// UpAxis returns the normalized axis the billboard turns around, or zero for spherical billboards
+func (b *Billboard) UpAxis() gglm.Vec3 {
+
+	if b.Mode != BillboardMode_Cylindrical {
+		return gglm.Vec3{}
+	}
+
+	if b.Up.Mag() == 0 {
+		return gglm.NewVec3(0, 1, 0)
+	}
+
+	return *b.Up.Clone().Normalize()
+}
+
+// NewBillboard returns a white billboard showing the whole texture
+func NewBillboard(mode BillboardMode, pos gglm.Vec3, size gglm.Vec2) Billboard {
+	return Billboard{
+		Mode:  mode,
+		Pos:   pos,
+		Size:  size,
+		UVMin: gglm.NewVec2(0, 1),
+		UVMax: gglm.NewVec2(1, 0),
+		Color: color.NewLinear(1, 1, 1),
+	}
+}
@@ -0,0 +1,311 @@
+package renderer
+
+import (
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/buffers"
+	"github.com/bloeys/nmage/camera"
+	"github.com/bloeys/nmage/materials"
+	"github.com/bloeys/nmage/meshes"
+)
+
+type CommandType uint8
+
+const (
+	CommandType_Unknown CommandType = iota
+	CommandType_DrawMesh
+	CommandType_DrawVertexArray
+	CommandType_DrawCubemap
+)
+
+func (ct CommandType) String() string {
+	switch ct {
+	case CommandType_DrawMesh:
+		return "DrawMesh"
+	case CommandType_DrawVertexArray:
+		return "DrawVertexArray"
+	case CommandType_DrawCubemap:
+		return "DrawCubemap"
+	default:
+		return "Unknown"
+	}
+}
+
+// Command is a recorded draw call. Only the fields used by the command type are set
+type Command struct {
+	Type CommandType
+
+	// SortKey decides submission order, where lower keys are drawn first. See MakeSortKey
+	SortKey uint64
+
+	Mat *materials.Material
+
+	// Used by CommandType_DrawMesh and CommandType_DrawCubemap
+	Mesh *meshes.Mesh
+
+	// Used by CommandType_DrawMesh. Copied on record so the caller can reuse its matrix
+	ModelMat gglm.TrMat
+
+	// Used by CommandType_DrawMesh. Zero unless recorded with DrawMeshLightmapped
+	LightmapScaleOffset gglm.Vec4
+
+	// Used by CommandType_DrawMesh. Zero unless recorded with DrawMeshWithFlags
+	Flags ObjectFlags
+
+	// SortPriority is the priority the command was recorded with, which is kept so DepthSortLayer can rebuild its sort key.
+	// See CommandList.SortPriority
+	SortPriority int8
+
+	// RenderLayers are the layers of the drawn object, which the renderer compares with its culling mask (see Render.SetCullingMask)
+	RenderLayers camera.LayerMask
+
+	// Used by CommandType_DrawMesh. Identifies the drawn object across frames so IndirectBuilder keeps it in the same slot.
+	// Zero unless recorded with DrawMeshObject
+	ObjectId uint64
+
+	// Used by CommandType_DrawVertexArray
+	Vao          *buffers.VertexArray
+	FirstElement int32
+	ElementCount int32
+}
+
+// Validate returns an error if the command can't be submitted (e.g. a nil material)
+func (c *Command) Validate() error {
+
+	if c.Mat == nil {
+		return fmt.Errorf("%s command has a nil material", c.Type.String())
+	}
+
+	switch c.Type {
+
+	case CommandType_DrawMesh, CommandType_DrawCubemap:
+		if c.Mesh == nil {
+			return fmt.Errorf("%s command with material '%s' has a nil mesh", c.Type.String(), c.Mat.Name)
+		}
+
+		if c.Mesh.Vao.Id == 0 {
+			return fmt.Errorf("%s command with material '%s' has mesh '%s' without a vertex array", c.Type.String(), c.Mat.Name, c.Mesh.Name)
+		}
+
+	case CommandType_DrawVertexArray:
+		if c.Vao == nil || c.Vao.Id == 0 {
+			return fmt.Errorf("%s command with material '%s' has no vertex array", c.Type.String(), c.Mat.Name)
+		}
+
+		if c.FirstElement < 0 || c.ElementCount <= 0 {
+			return fmt.Errorf("%s command with material '%s' has an invalid element range. First=%d, Count=%d", c.Type.String(), c.Mat.Name, c.FirstElement, c.ElementCount)
+		}
+
+	default:
+		return fmt.Errorf("unknown command type %d", c.Type)
+	}
+
+	return nil
+}
+
+// MakeSortKey returns a sort key that draws lower layers first, then within a layer lower priorities first, then groups
+// draws of the same priority by material and vertex array so the renderer changes state as little as possible.
+// Only the lower 24 bits of the material and vertex array ids are used
+func MakeSortKey(layer uint8, priority int8, matId, vaoId uint32) uint64 {
+	return uint64(layer)<<56 | uint64(priorityBits(priority))<<48 | uint64(matId&0xFFFFFF)<<24 | uint64(vaoId&0xFFFFFF)
+}
+
+// MakeDepthSortKey returns a sort key that draws lower layers first, then within a layer lower priorities first, then
+// draws of the same priority back to front, which is what blended objects need. sqrDepth is the squared distance to the camera,
+// and draws at the same distance are grouped by the lower 16 bits of their material id
+func MakeDepthSortKey(layer uint8, priority int8, sqrDepth float32, matId uint32) uint64 {
+
+	// The bits of positive floats sort like the floats, so inverting them puts further draws first
+	depthBits := ^math.Float32bits(max(sqrDepth, 0))
+	return uint64(layer)<<56 | uint64(priorityBits(priority))<<48 | uint64(depthBits)<<16 | uint64(matId&0xFFFF)
+}
+
+// priorityBits maps priorities so negative ones sort before positive ones
+func priorityBits(priority int8) uint8 {
+	return uint8(int16(priority) + 128)
+}
+
+// CommandLayer_Transparent is the sort layer of blended objects, which Render.Submit draws back to front from the position
+// set by Render.SetViewPos. Lower layers (e.g. opaque objects and skyboxes) are drawn before it
+const CommandLayer_Transparent uint8 = 128
+
+// CommandList records draw commands to be submitted by a renderer later.
+//
+// Recording doesn't touch GL, so lists can be filled on worker threads (one list per thread)
+// while traversing the scene, then appended together and submitted on the main thread.
+// Meshes, materials and vertex arrays are kept as pointers, so they must stay alive and unchanged until submission
+type CommandList struct {
+	Commands []Command
+
+	// Layer is used in the sort key of all commands recorded after it is set. Defaults to zero.
+	// For example, skyboxes can be recorded on a later layer than opaque objects
+	Layer uint8
+
+	// SortPriority is the priority of all commands recorded after it is set, where lower priorities are drawn first within a layer.
+	// It overrides the material grouping, and the back to front order of layers sorted with DepthSortLayer, so it decides the order of
+	// draws that must not follow depth. For example, a weapon viewmodel in the transparent layer can use a high priority to always be
+	// drawn over the world, and a sky dome a low one to be drawn behind everything. Defaults to zero
+	SortPriority int8
+
+	// RenderLayers are the render layers of all commands recorded after it is set, which is unrelated to the sort Layer.
+	// Defaults to zero, which is camera.LayerMask_Default
+	RenderLayers camera.LayerMask
+}
+
+func (cl *CommandList) DrawMesh(mesh *meshes.Mesh, modelMat *gglm.TrMat, mat *materials.Material) {
+	cl.Commands = append(cl.Commands, Command{
+		Type:         CommandType_DrawMesh,
+		SortKey:      MakeSortKey(cl.Layer, cl.SortPriority, matIdOrZero(mat), meshVaoIdOrZero(mesh)),
+		SortPriority: cl.SortPriority,
+		RenderLayers: cl.RenderLayers,
+		Mat:          mat,
+		Mesh:         mesh,
+		ModelMat:     *modelMat,
+	})
+}
+
+func (cl *CommandList) DrawMeshWithFlags(mesh *meshes.Mesh, modelMat *gglm.TrMat, mat *materials.Material, flags ObjectFlags) {
+	cl.Commands = append(cl.Commands, Command{
+		Type:         CommandType_DrawMesh,
+		SortKey:      MakeSortKey(cl.Layer, cl.SortPriority, matIdOrZero(mat), meshVaoIdOrZero(mesh)),
+		SortPriority: cl.SortPriority,
+		RenderLayers: cl.RenderLayers,
+		Mat:          mat,
+		Mesh:         mesh,
+		ModelMat:     *modelMat,
+		Flags:        flags,
+	})
+}
+
+// DrawMeshObject is DrawMesh for an object that is drawn every frame, where objectId (e.g. an entity handle) is
+// unique to the object and not zero. See IndirectBuilder
+func (cl *CommandList) DrawMeshObject(objectId uint64, mesh *meshes.Mesh, modelMat *gglm.TrMat, mat *materials.Material) {
+	cl.Commands = append(cl.Commands, Command{
+		Type:         CommandType_DrawMesh,
+		SortKey:      MakeSortKey(cl.Layer, cl.SortPriority, matIdOrZero(mat), meshVaoIdOrZero(mesh)),
+		SortPriority: cl.SortPriority,
+		RenderLayers: cl.RenderLayers,
+		Mat:          mat,
+		Mesh:         mesh,
+		ModelMat:     *modelMat,
+		ObjectId:     objectId,
+	})
+}
+
+func (cl *CommandList) DrawMeshLightmapped(mesh *meshes.Mesh, modelMat *gglm.TrMat, mat *materials.Material, lightmapScaleOffset *gglm.Vec4) {
+	cl.Commands = append(cl.Commands, Command{
+		Type:                CommandType_DrawMesh,
+		SortKey:             MakeSortKey(cl.Layer, cl.SortPriority, matIdOrZero(mat), meshVaoIdOrZero(mesh)),
+		SortPriority:        cl.SortPriority,
+		RenderLayers:        cl.RenderLayers,
+		Mat:                 mat,
+		Mesh:                mesh,
+		ModelMat:            *modelMat,
+		LightmapScaleOffset: *lightmapScaleOffset,
+	})
+}
+
+func (cl *CommandList) DrawVertexArray(mat *materials.Material, vao *buffers.VertexArray, firstElement int32, elementCount int32) {
+
+	var vaoId uint32
+	if vao != nil {
+		vaoId = vao.Id
+	}
+
+	cl.Commands = append(cl.Commands, Command{
+		Type:         CommandType_DrawVertexArray,
+		SortKey:      MakeSortKey(cl.Layer, cl.SortPriority, matIdOrZero(mat), vaoId),
+		SortPriority: cl.SortPriority,
+		RenderLayers: cl.RenderLayers,
+		Mat:          mat,
+		Vao:          vao,
+		FirstElement: firstElement,
+		ElementCount: elementCount,
+	})
+}
+
+func (cl *CommandList) DrawCubemap(mesh *meshes.Mesh, mat *materials.Material) {
+	cl.Commands = append(cl.Commands, Command{
+		Type:         CommandType_DrawCubemap,
+		SortKey:      MakeSortKey(cl.Layer, cl.SortPriority, matIdOrZero(mat), meshVaoIdOrZero(mesh)),
+		SortPriority: cl.SortPriority,
+		RenderLayers: cl.RenderLayers,
+		Mat:          mat,
+		Mesh:         mesh,
+	})
+}
+
+// Append adds all commands of other to this list, for merging lists recorded on different threads
+func (cl *CommandList) Append(other *CommandList) {
+	cl.Commands = append(cl.Commands, other.Commands...)
+}
+
+// DepthSortLayer changes the sort keys of the commands in layer so Sort draws them back to front from camPos, keeping their
+// SortPriority above depth (see MakeDepthSortKey). It should be called on the layer of blended objects once per camera before
+// sorting, which Render.Submit does for CommandLayer_Transparent. Mesh commands use the position of their model matrix, while other commands are treated as being at the camera
+func (cl *CommandList) DepthSortLayer(layer uint8, camPos *gglm.Vec3) {
+
+	for i := 0; i < len(cl.Commands); i++ {
+
+		c := &cl.Commands[i]
+		if uint8(c.SortKey>>56) != layer {
+			continue
+		}
+
+		var sqrDepth float32
+		if c.Type == CommandType_DrawMesh {
+			objPos := gglm.NewVec3(c.ModelMat.Data[3][0], c.ModelMat.Data[3][1], c.ModelMat.Data[3][2])
+			sqrDepth = gglm.SqrDistVec3(&objPos, camPos)
+		}
+
+		c.SortKey = MakeDepthSortKey(layer, c.SortPriority, sqrDepth, matIdOrZero(c.Mat))
+	}
+}
+
+// Sort orders commands by their sort key. Commands with equal keys keep their recording order
+func (cl *CommandList) Sort() {
+	slices.SortStableFunc(cl.Commands, func(a, b Command) int {
+		if a.SortKey < b.SortKey {
+			return -1
+		} else if a.SortKey > b.SortKey {
+			return 1
+		}
+		return 0
+	})
+}
+
+// Reset clears the list while keeping its memory, so a list can be reused every frame without allocating
+func (cl *CommandList) Reset() {
+	clear(cl.Commands)
+	cl.Commands = cl.Commands[:0]
+	cl.Layer = 0
+	cl.SortPriority = 0
+	cl.RenderLayers = camera.LayerMask_None
+}
+
+func (cl *CommandList) Len() int {
+	return len(cl.Commands)
+}
+
+func NewCommandList(capacity int) CommandList {
+	return CommandList{
+		Commands: make([]Command, 0, capacity),
+	}
+}
+
+func matIdOrZero(mat *materials.Material) uint32 {
+	if mat == nil {
+		return 0
+	}
+	return mat.Id
+}
+
+func meshVaoIdOrZero(mesh *meshes.Mesh) uint32 {
+	if mesh == nil {
+		return 0
+	}
+	return mesh.Vao.Id
+}
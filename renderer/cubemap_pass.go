@@ -0,0 +1,58 @@
+package renderer
+
+import (
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/buffers"
+	"github.com/bloeys/nmage/camera"
+)
+
+// CubemapFaceFunc draws one face of a CubemapPass into the bound framebuffer, which has the face attached and is cleared.
+// viewMat and projMat are the camera of the face, which must be given to the materials drawn (e.g. through the global matrices ubo)
+type CubemapFaceFunc func(face int32, viewMat, projMat *gglm.Mat4)
+
+// CubemapPass renders the scene from a point into the faces of a framebuffer with cubemap attachments
+// (see buffers.FramebufferAttachmentType_Cubemap), e.g. for light probes, reflection probes and capturing the sky.
+// The pass sets up the face and viewport of every draw, while what is drawn is up to the caller.
+//
+// Point light shadows draw all faces in one pass with a geometry shader instead, but use the same faces
+// (see camera.CubemapViewMats)
+type CubemapPass struct {
+	Pos gglm.Vec3
+
+	NearPlane float32
+	FarPlane  float32
+}
+
+// Render draws all six faces of fbo, calling drawFace once per face
+func (p *CubemapPass) Render(rend Render, fbo *buffers.Framebuffer, drawFace CubemapFaceFunc) {
+	p.RenderFaces(rend, fbo, 0, camera.CubemapFaceCount, drawFace)
+}
+
+// RenderFaces draws the faces [firstFace, firstFace+faceCount) of fbo, so a capture can be spread over several frames.
+// The framebuffer and viewport are restored after, but the caller must restore the camera its drawFace set
+func (p *CubemapPass) RenderFaces(rend Render, fbo *buffers.Framebuffer, firstFace, faceCount int32, drawFace CubemapFaceFunc) {
+
+	viewMats := camera.CubemapViewMats(&p.Pos)
+	projMat := camera.CubemapProjMat(p.NearPlane, p.FarPlane)
+
+	fbo.Bind()
+	rend.PushViewport(0, 0, int32(fbo.Width), int32(fbo.Height))
+
+	for face := firstFace; face < firstFace+faceCount; face++ {
+
+		fbo.SetCubemapFace(face)
+		fbo.Clear()
+		drawFace(face, &viewMats[face], &projMat)
+	}
+
+	fbo.UnBind()
+	rend.PopViewport()
+}
+
+func NewCubemapPass(pos gglm.Vec3, nearPlane, farPlane float32) CubemapPass {
+	return CubemapPass{
+		Pos:       pos,
+		NearPlane: nearPlane,
+		FarPlane:  farPlane,
+	}
+}
@@ -0,0 +1,37 @@
+package renderer
+
+// DebugViewUniformName is the int uniform of lit shaders (e.g. res/shaders/simple.glsl) that selects the DebugView they output
+const DebugViewUniformName = "debugView"
+
+// DebugViewHeatmapMax is the light count shown as the hottest color of DebugView_LightCount. Must match the shader's LIGHT_HEATMAP_MAX
+const DebugViewHeatmapMax = 8
+
+// DebugView replaces the lit color of surfaces with another value, to find problems in a scene.
+//
+// @TODO: Add decal count and per cluster light count views once the engine has decals and a clustered pipeline.
+// Until then DebugView_LightCount counts the lights of the forward shader per pixel
+type DebugView int32
+
+const (
+	DebugView_None DebugView = iota
+
+	// DebugView_Normals shows the normal map of surfaces
+	DebugView_Normals
+
+	// DebugView_LightCount colors each pixel by how many point and spot lights reach it, from black (none) through blue, green and
+	// yellow to red (DebugViewHeatmapMax or more), so regions where too many lights overlap stand out
+	DebugView_LightCount
+)
+
+func (dv DebugView) String() string {
+	switch dv {
+	case DebugView_None:
+		return "None"
+	case DebugView_Normals:
+		return "Normals"
+	case DebugView_LightCount:
+		return "Light Count"
+	default:
+		return "Unknown"
+	}
+}
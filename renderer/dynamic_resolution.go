@@ -0,0 +1,145 @@
+package renderer
+
+import (
+	"math"
+	"time"
+)
+
+// UpsampleMode is how a scene rendered at a lower resolution is scaled up to the window
+type UpsampleMode int32
+
+const (
+	// UpsampleMode_Bilinear is the cheapest, but blurs the image as the scale goes down
+	UpsampleMode_Bilinear UpsampleMode = iota
+	// UpsampleMode_Fsr1 is an FSR1 style upsample, which is a sharper (Catmull-Rom) upsample followed by contrast adaptive sharpening.
+	// It keeps edges crisp at lower scales, at the cost of a few more texture reads per pixel
+	UpsampleMode_Fsr1
+)
+
+func (m UpsampleMode) String() string {
+	switch m {
+	case UpsampleMode_Bilinear:
+		return "Bilinear"
+	case UpsampleMode_Fsr1:
+		return "FSR1"
+	default:
+		return "Unknown"
+	}
+}
+
+const (
+	// dynResScaleStep is what the scale is rounded to, so the scene buffer is only reallocated on noticeable changes
+	dynResScaleStep = 0.05
+
+	// dynResFramesBetweenChanges gives the frame time average time to settle after a change before the next one
+	dynResFramesBetweenChanges = 20
+
+	// The scale only changes when the average frame time is outside of [lower, upper]*TargetFrameTime,
+	// so it doesn't bounce between two scales
+	dynResLowerBound = 0.85
+	dynResUpperBound = 1.05
+)
+
+// DynamicResolution picks the scale the 3D scene is rendered at. The scene is drawn into a buffer of ScaledSize,
+// which is then upsampled to the window.
+//
+// With AutoAdjust the scale follows the GPU frame time passed to Update, going down when frames take longer than
+// TargetFrameTime and back up when there is time to spare
+type DynamicResolution struct {
+	// Scale is the fraction of the window's width and height the scene is rendered at
+	Scale    float32 `editor:"min=0.25,max=2,speed=0.01"`
+	MinScale float32 `editor:"min=0.25,max=1,speed=0.01"`
+	MaxScale float32 `editor:"min=0.25,max=2,speed=0.01"`
+
+	Upsample UpsampleMode `editor:"enum=Bilinear|FSR1"`
+
+	// Sharpness is how much UpsampleMode_Fsr1 sharpens, from 0 (none) to 1
+	Sharpness float32 `editor:"min=0,max=1,speed=0.01"`
+
+	AutoAdjust      bool
+	TargetFrameTime time.Duration
+
+	avgFrameTime      float64
+	framesSinceChange int
+}
+
+// SetTargetFps sets TargetFrameTime to the frame time of fps
+func (dr *DynamicResolution) SetTargetFps(fps float32) {
+	dr.TargetFrameTime = time.Duration(float64(time.Second) / float64(fps))
+}
+
+// Update adds the GPU time of the last measured frame to the frame time average, and changes the scale if AutoAdjust is on.
+// Should be called once per frame, and frames without a measurement should pass zero
+func (dr *DynamicResolution) Update(gpuFrameTime time.Duration) {
+
+	dr.Scale = dr.clampScale(dr.Scale)
+	if !dr.AutoAdjust || dr.TargetFrameTime <= 0 {
+		dr.avgFrameTime = 0
+		dr.framesSinceChange = 0
+		return
+	}
+
+	if gpuFrameTime <= 0 {
+		return
+	}
+
+	if dr.avgFrameTime == 0 {
+		dr.avgFrameTime = float64(gpuFrameTime)
+	} else {
+		dr.avgFrameTime += (float64(gpuFrameTime) - dr.avgFrameTime) * 0.1
+	}
+
+	dr.framesSinceChange++
+	if dr.framesSinceChange < dynResFramesBetweenChanges {
+		return
+	}
+
+	target := float64(dr.TargetFrameTime)
+	if dr.avgFrameTime >= target*dynResLowerBound && dr.avgFrameTime <= target*dynResUpperBound {
+		return
+	}
+
+	// GPU time mostly grows with the pixel count, which is the square of the scale.
+	// Each change is limited so one slow frame doesn't drop the scale all at once
+	factor := math.Sqrt(target / dr.avgFrameTime)
+	factor = min(max(factor, 0.9), 1.1)
+
+	newScale := dr.clampScale(float32(float64(dr.Scale) * factor))
+	if newScale == dr.Scale {
+		return
+	}
+
+	// The frame times measured so far were at the old scale, so the average is scaled to what is expected at the new one
+	dr.avgFrameTime *= float64(newScale*newScale) / float64(dr.Scale*dr.Scale)
+	dr.Scale = newScale
+	dr.framesSinceChange = 0
+}
+
+// ScaledSize returns the size to render the scene at for a window of width*height
+func (dr *DynamicResolution) ScaledSize(width, height int32) (int32, int32) {
+
+	scale := dr.clampScale(dr.Scale)
+	w := int32(math.Round(float64(float32(width) * scale)))
+	h := int32(math.Round(float64(float32(height) * scale)))
+	return max(w, 1), max(h, 1)
+}
+
+func (dr *DynamicResolution) clampScale(scale float32) float32 {
+	scale = float32(math.Round(float64(scale)/dynResScaleStep) * dynResScaleStep)
+	return min(max(scale, dr.MinScale), dr.MaxScale)
+}
+
+// NewDynamicResolution returns a native resolution setting that, when AutoAdjust is turned on, scales down to hold targetFps
+func NewDynamicResolution(targetFps float32) DynamicResolution {
+
+	dr := DynamicResolution{
+		Scale:     1,
+		MinScale:  0.5,
+		MaxScale:  1,
+		Upsample:  UpsampleMode_Fsr1,
+		Sharpness: 0.5,
+	}
+
+	dr.SetTargetFps(targetFps)
+	return dr
+}
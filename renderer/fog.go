@@ -0,0 +1,95 @@
+package renderer
+
+import (
+	"github.com/bloeys/nmage/color"
+)
+
+// FogUboBlockName is the name of the uniform block with the fog settings, which must match FogUboData:
+//
+//	layout (std140) uniform Fog {
+//	    vec3 fogColor;
+//	    int fogMode;
+//	    float fogDensity;
+//	    float fogStart;
+//	    float fogEnd;
+//	    float fogHeightFalloff;
+//	    float fogHeightBase;
+//	    float fogSkyBlend;
+//	};
+const FogUboBlockName = "Fog"
+
+type FogMode int32
+
+const (
+	FogMode_None FogMode = iota
+
+	// FogMode_Linear goes from no fog at Fog.Start to full fog at Fog.End
+	FogMode_Linear
+
+	// FogMode_Exp thickens quickly close to the camera and slowly far away, based on Fog.Density
+	FogMode_Exp
+
+	// FogMode_Exp2 keeps a clear area around the camera and then thickens faster than FogMode_Exp, based on Fog.Density
+	FogMode_Exp2
+)
+
+func (fm FogMode) String() string {
+	switch fm {
+	case FogMode_None:
+		return "None"
+	case FogMode_Linear:
+		return "Linear"
+	case FogMode_Exp:
+		return "Exp"
+	case FogMode_Exp2:
+		return "Exp2"
+	default:
+		return "Unknown"
+	}
+}
+
+// Fog blends far away surfaces into Color, based on their distance from the camera and optionally their height
+type Fog struct {
+	Mode  FogMode     `editor:"enum=None|Linear|Exp|Exp2"`
+	Color color.Color `editor:"rgb"`
+
+	// Density is used by the exponential modes, where higher values give thicker fog
+	Density float32 `editor:"min=0,max=1,speed=0.001"`
+
+	// Start is the distance from the camera where fog begins. End is only used by FogMode_Linear
+	Start float32 `editor:"min=0,max=1000,speed=0.1"`
+	End   float32 `editor:"min=0,max=1000,speed=0.1"`
+
+	// HeightFalloff makes fog thinner the higher it is above HeightBase, like fog lying in a valley.
+	// Zero makes fog equally thick at all heights
+	HeightFalloff float32 `editor:"min=0,max=10,speed=0.005"`
+	HeightBase    float32 `editor:"min=-1000,max=1000,speed=0.1"`
+
+	// SkyBlend is how much fog covers the sky at the horizon in [0, 1]. Fog fades out looking up so the sky isn't flat
+	SkyBlend float32 `editor:"min=0,max=1"`
+}
+
+// FogUboData is the data of the 'Fog' uniform block. See FogUboBlockName
+type FogUboData struct {
+	Color         color.Color `ubo:"type=vec3"`
+	Mode          int32
+	Density       float32
+	Start         float32
+	End           float32
+	HeightFalloff float32 `editor:"min=0,max=10,speed=0.005"`
+	HeightBase    float32 `editor:"min=-1000,max=1000,speed=0.1"`
+	SkyBlend      float32
+}
+
+func (f *Fog) ToUboData() FogUboData {
+	return FogUboData{
+		Color:         f.Color,
+		Mode:          int32(f.Mode),
+		Density:       f.Density,
+		Start:         f.Start,
+		End:           f.End,
+		HeightFalloff: f.HeightFalloff,
+		HeightBase:    f.HeightBase,
+		SkyBlend:      f.SkyBlend,
+	}
+}
@@ -0,0 +1,79 @@
+package renderer
+
+import (
+	"time"
+
+	"github.com/bloeys/nmage/buffers"
+	"github.com/bloeys/nmage/timing"
+)
+
+type gpuScopeInfo struct {
+	name     string
+	cpuStart time.Duration
+}
+
+// GpuScopes measures how long the GPU takes on named parts of a frame using timer queries,
+// and adds the results to the GPU track of the timing trace.
+//
+// Only one timer query can run at a time, so scopes can't be nested. Results arrive a few frames late, and since timer queries
+// only give durations the trace places each GPU scope at the CPU time it was issued
+type GpuScopes struct {
+	pool    buffers.QueryPool
+	nextTag uint64
+	pending map[uint64]gpuScopeInfo
+
+	// LastDurations has the latest GPU time of every scope by name
+	LastDurations map[string]time.Duration
+}
+
+func (gs *GpuScopes) Begin(name string) {
+
+	gs.nextTag++
+	gs.pending[gs.nextTag] = gpuScopeInfo{
+		name:     name,
+		cpuStart: timing.SinceInit(),
+	}
+
+	gs.pool.Begin(gs.nextTag)
+}
+
+func (gs *GpuScopes) End() {
+	gs.pool.End()
+}
+
+// Poll collects finished scopes without waiting on the GPU. Should be called once per frame
+func (gs *GpuScopes) Poll() {
+
+	results := gs.pool.Poll()
+	for i := 0; i < len(results); i++ {
+
+		info, ok := gs.pending[results[i].Tag]
+		if !ok {
+			continue
+		}
+		delete(gs.pending, results[i].Tag)
+
+		dur := time.Duration(results[i].Value)
+		gs.LastDurations[info.name] = dur
+
+		timing.AddTraceEvent(timing.TraceEvent{
+			Name:  info.name,
+			Track: timing.TraceTrack_Gpu,
+			Start: info.cpuStart,
+			Dur:   dur,
+		})
+	}
+}
+
+func (gs *GpuScopes) Delete() {
+	gs.pool.Delete()
+	clear(gs.pending)
+}
+
+func NewGpuScopes() GpuScopes {
+	return GpuScopes{
+		pool:          buffers.NewQueryPool(buffers.QueryType_TimeElapsed),
+		pending:       make(map[uint64]gpuScopeInfo),
+		LastDurations: make(map[string]time.Duration),
+	}
+}
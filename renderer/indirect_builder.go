@@ -0,0 +1,144 @@
+package renderer
+
+import (
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/buffers"
+	"github.com/bloeys/nmage/materials"
+	"github.com/bloeys/nmage/meshes"
+)
+
+// IndirectBatch is a run of indirect commands that share a material and mesh, so they are drawn with one multi draw
+type IndirectBatch struct {
+	Mat  *materials.Material
+	Mesh *meshes.Mesh
+
+	// FirstCommand and CommandCount are the range of the batch in IndirectBuilder.Commands
+	FirstCommand uint32
+	CommandCount uint32
+}
+
+// IndirectBuilder turns the mesh draws of a command list into packed indirect commands every frame, grouped into batches
+// that Render.DrawIndirectBatches draws with one multi draw indirect each.
+//
+// Every object gets a slot, which is the BaseInstance of its commands and its index in Slots. Objects recorded with
+// CommandList.DrawMeshObject keep their slot across frames until ReleaseObject, so per object data only needs uploading
+// for DirtySlots. Draws without an object id get a slot for the current frame only.
+//
+// Shaders find the object's data with the base instance, e.g. 'objects[gl_BaseInstanceARB]' with GL_ARB_shader_draw_parameters,
+// or an instanced vertex attribute holding 0,1,2... which the GPU offsets by BaseInstance
+type IndirectBuilder struct {
+	Commands []buffers.DrawElementsIndirectCommand
+	Batches  []IndirectBatch
+
+	// Slots has the model matrix of every slot, including free ones
+	Slots []gglm.Mat4
+
+	// DirtySlots has the slots whose model matrix changed during the last Build
+	DirtySlots []uint32
+
+	objectSlots    map[uint64]uint32
+	freeSlots      []uint32
+	transientSlots []uint32
+}
+
+// Build replaces the commands and batches with the visible mesh draws of the list. The list is sorted like
+// Render.Submit does, so draws sharing a material and mesh end up in the same batch. Other command types are ignored
+func (b *IndirectBuilder) Build(cl *CommandList) {
+
+	clear(b.Batches)
+	b.Batches = b.Batches[:0]
+	b.Commands = b.Commands[:0]
+	b.DirtySlots = b.DirtySlots[:0]
+
+	// Last frame's draws without an id are gone, so their slots are reused
+	b.freeSlots = append(b.freeSlots, b.transientSlots...)
+	b.transientSlots = b.transientSlots[:0]
+
+	cl.Sort()
+	for i := 0; i < len(cl.Commands); i++ {
+
+		c := &cl.Commands[i]
+		if c.Type != CommandType_DrawMesh || c.Validate() != nil {
+			continue
+		}
+
+		// A new slot may already hold a matrix equal to the object's, so it is always uploaded
+		slot, isNew := b.slotOf(c.ObjectId)
+		if isNew || b.Slots[slot] != c.ModelMat.Mat4 {
+			b.Slots[slot] = c.ModelMat.Mat4
+			b.DirtySlots = append(b.DirtySlots, slot)
+		}
+
+		if len(b.Batches) == 0 || b.Batches[len(b.Batches)-1].Mat != c.Mat || b.Batches[len(b.Batches)-1].Mesh != c.Mesh {
+			b.Batches = append(b.Batches, IndirectBatch{
+				Mat:          c.Mat,
+				Mesh:         c.Mesh,
+				FirstCommand: uint32(len(b.Commands)),
+			})
+		}
+
+		batch := &b.Batches[len(b.Batches)-1]
+		for j := 0; j < len(c.Mesh.SubMeshes); j++ {
+			sm := &c.Mesh.SubMeshes[j]
+			b.Commands = append(b.Commands, buffers.DrawElementsIndirectCommand{
+				Count:         uint32(sm.IndexCount),
+				InstanceCount: 1,
+				FirstIndex:    sm.BaseIndex,
+				BaseVertex:    sm.BaseVertex,
+				BaseInstance:  slot,
+			})
+			batch.CommandCount++
+		}
+	}
+}
+
+// ReleaseObject frees the slot of an object that won't be drawn anymore (e.g. a destroyed entity), so another object can use it
+func (b *IndirectBuilder) ReleaseObject(objectId uint64) {
+
+	slot, ok := b.objectSlots[objectId]
+	if !ok {
+		return
+	}
+
+	delete(b.objectSlots, objectId)
+	b.freeSlots = append(b.freeSlots, slot)
+}
+
+// ObjectSlot returns the slot of an object, and false if the object has none
+func (b *IndirectBuilder) ObjectSlot(objectId uint64) (uint32, bool) {
+	slot, ok := b.objectSlots[objectId]
+	return slot, ok
+}
+
+// slotOf returns the slot of the object, giving it a new one if it has none. Zero ids get a slot for this frame only
+func (b *IndirectBuilder) slotOf(objectId uint64) (slot uint32, isNew bool) {
+
+	if objectId != 0 {
+		if slot, ok := b.objectSlots[objectId]; ok {
+			return slot, false
+		}
+	}
+
+	if len(b.freeSlots) > 0 {
+		slot = b.freeSlots[len(b.freeSlots)-1]
+		b.freeSlots = b.freeSlots[:len(b.freeSlots)-1]
+	} else {
+		slot = uint32(len(b.Slots))
+		b.Slots = append(b.Slots, gglm.Mat4{})
+	}
+
+	if objectId == 0 {
+		b.transientSlots = append(b.transientSlots, slot)
+	} else {
+		b.objectSlots[objectId] = slot
+	}
+
+	return slot, true
+}
+
+func NewIndirectBuilder(capacity int) IndirectBuilder {
+	return IndirectBuilder{
+		Commands:    make([]buffers.DrawElementsIndirectCommand, 0, capacity),
+		objectSlots: make(map[uint64]uint32, capacity),
+	}
+}
@@ -0,0 +1,155 @@
+package renderer
+
+import "github.com/bloeys/gglm/gglm"
+
+// PerObjectUboBlockName is the name of the uniform block used by materials with MaterialSettings_HasPerObjectUbo.
+// The block must match PerObjectUboData:
+//
+//	layout (std140) uniform PerObject {
+//	    mat4 modelMat;
+//	    mat3 normalMat;
+//	    vec4 lightmapScaleOffset;
+//	    vec3 ambientSH[9];
+//	    int hasAmbientSH;
+//	    int receiveShadows;
+//	    uint layers;
+//	};
+const PerObjectUboBlockName = "PerObject"
+
+// PerObjectUboData is the per draw data written for materials with MaterialSettings_HasPerObjectUbo
+type PerObjectUboData struct {
+	ModelMat  gglm.Mat4
+	NormalMat gglm.Mat3
+
+	// LightmapScaleOffset maps the object's lightmap uvs into its region of the lightmap atlas with uv*xy+zw.
+	// All zeros means the object has no lightmap
+	LightmapScaleOffset gglm.Vec4
+
+	// AmbientSH is the ambient light around the object from the renderer's AmbientSampler, and is only used when HasAmbientSH is 1
+	AmbientSH    [9]gglm.Vec3
+	HasAmbientSH int32
+
+	// ReceiveShadows is 0 for objects drawn with ObjectFlags_NoReceiveShadows, whose lit shaders skip shadow lookups
+	ReceiveShadows int32
+
+	// Layers are the render layers of the object (see camera.LayerMask.AsObjectLayers), and lit shaders skip the lights
+	// whose culling mask has none of them
+	Layers uint32
+}
+
+// ObjectFlags are per object render settings, where the zero value casts and receives shadows like any object
+type ObjectFlags uint8
+
+const (
+	ObjectFlags_None ObjectFlags = iota
+	// ObjectFlags_NoCastShadows makes renderers skip the object in shadow passes (see Render.SetShadowPass),
+	// e.g. for large backgrounds that never shadow anything or markers sitting inside lights
+	ObjectFlags_NoCastShadows ObjectFlags = 1 << (iota - 1)
+	// ObjectFlags_NoReceiveShadows makes lit shaders skip shadow lookups for the object. Only materials with
+	// MaterialSettings_HasPerObjectUbo get it, through PerObjectUboData.ReceiveShadows
+	ObjectFlags_NoReceiveShadows
+	// ObjectFlags_Static marks objects that don't move, which shadow passes with ShadowPassMode_Static draw into cached shadow maps
+	// (see lights.ShadowSettings.CacheStatic). Other objects are dynamic and are drawn on top of the cached maps every time
+	ObjectFlags_Static
+)
+
+func (of *ObjectFlags) Set(flags ObjectFlags) {
+	*of |= flags
+}
+
+func (of *ObjectFlags) Remove(flags ObjectFlags) {
+	*of &= ^flags
+}
+
+func (of *ObjectFlags) Has(flags ObjectFlags) bool {
+	return *of&flags == flags
+}
+
+func (of ObjectFlags) CastShadows() bool {
+	return of&ObjectFlags_NoCastShadows == 0
+}
+
+func (of ObjectFlags) ReceiveShadows() bool {
+	return of&ObjectFlags_NoReceiveShadows == 0
+}
+
+// ShadowPassMode is the kind of pass set with Render.SetShadowPass, which decides the objects drawn
+type ShadowPassMode uint8
+
+const (
+	// ShadowPassMode_None isn't a shadow pass, so all objects are drawn
+	ShadowPassMode_None ShadowPassMode = iota
+	// ShadowPassMode_All draws every object that casts shadows
+	ShadowPassMode_All
+	// ShadowPassMode_Static only draws shadow casters with ObjectFlags_Static, for drawing cached shadow maps
+	ShadowPassMode_Static
+	// ShadowPassMode_Dynamic only draws shadow casters without ObjectFlags_Static, for drawing on top of cached shadow maps
+	ShadowPassMode_Dynamic
+)
+
+func (m ShadowPassMode) String() string {
+	switch m {
+	case ShadowPassMode_None:
+		return "None"
+	case ShadowPassMode_All:
+		return "All"
+	case ShadowPassMode_Static:
+		return "Static"
+	case ShadowPassMode_Dynamic:
+		return "Dynamic"
+	default:
+		return "Unknown"
+	}
+}
+
+// Draws returns true if an object with the passed flags is drawn in a pass of this mode
+func (m ShadowPassMode) Draws(flags ObjectFlags) bool {
+
+	if m == ShadowPassMode_None {
+		return true
+	}
+
+	if !flags.CastShadows() {
+		return false
+	}
+
+	switch m {
+	case ShadowPassMode_Static:
+		return flags.Has(ObjectFlags_Static)
+	case ShadowPassMode_Dynamic:
+		return !flags.Has(ObjectFlags_Static)
+	default:
+		return true
+	}
+}
+
+// AmbientSampler gives the ambient light around a world position as rgb L2 spherical harmonics (see lights.SH9),
+// for example by blending nearby light probes. Renderers sample it once per object at the object's origin,
+// so objects get ambient light that matches where they are instead of one global ambient color
+type AmbientSampler interface {
+	// SampleAmbient writes the coefficients at pos into outSH, and returns false if it has no ambient light for pos
+	SampleAmbient(pos *gglm.Vec3, outSH *[9]gglm.Vec3) bool
+}
+
+// InstanceData is a general purpose per-instance parameter set for instanced draws using buffers.InstanceDataBuffer,
+// which lets every instance look different while sharing one material. The matching std430 shader struct is:
+//
+//	struct InstanceData {
+//	    mat4 modelMat;
+//	    vec4 color;
+//	    vec2 uvOffset;
+//	    vec2 uvScale;
+//	    uint materialIndex;
+//	};
+//
+//	layout(std430, binding=0) buffer Instances { InstanceData instances[]; };
+//
+// The vertex shader reads its instance with instances[gl_InstanceID]
+type InstanceData struct {
+	ModelMat gglm.Mat4
+	Color    gglm.Vec4
+	UvOffset gglm.Vec2
+	UvScale  gglm.Vec2
+	// MaterialIndex can select textures from an array texture or parameters from another buffer
+	MaterialIndex uint32
+}
@@ -0,0 +1,949 @@
+package rend3dgl
+
+import (
+	"strconv"
+
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/assert"
+	"github.com/bloeys/nmage/buffers"
+	"github.com/bloeys/nmage/camera"
+	"github.com/bloeys/nmage/lights"
+	"github.com/bloeys/nmage/materials"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+const deferredLightShader = `
+//shader:vertex
+#version 410
+
+#define MODE_BASE 0
+#define MODE_POINT_LIGHTS 1
+#define MODE_SPOT_LIGHTS 2
+#define MODE_FOG 3
+
+#define LIGHT_BATCH_SIZE 64
+
+struct ShadowSettings {
+    int enabled;
+    float biasConstant;
+    float biasSlope;
+    float normalOffset;
+    int pcfRadius;
+    float nearPlane;
+    float farPlane;
+};
+
+struct PointLight {
+    vec3 pos;
+    vec3 diffuseColor;
+    vec3 specularColor;
+    float radius;
+    float falloff;
+    ShadowSettings shadow;
+    int shadowLayer;
+    uint cullingMask;
+};
+
+struct SpotLight {
+    vec3 pos;
+    vec3 dir;
+    vec3 diffuseColor;
+    vec3 specularColor;
+    float innerCutoff;
+    float outerCutoff;
+    ShadowSettings shadow;
+    int hasCookie;
+    uint cullingMask;
+};
+
+layout (std140) uniform GlobalMatrices {
+    vec3 camPos;
+    mat4 projViewMat;
+};
+
+layout (std140) uniform DeferredLights {
+    PointLight batchPointLights[LIGHT_BATCH_SIZE];
+    SpotLight batchSpotLights[LIGHT_BATCH_SIZE];
+};
+
+uniform int mode;
+
+flat out int lightIndex;
+
+vec2 quadData[6] = vec2[](
+    vec2(0.0, 1.0),
+    vec2(0.0, 0.0),
+    vec2(1.0, 0.0),
+    vec2(0.0, 1.0),
+    vec2(1.0, 0.0),
+    vec2(1.0, 1.0)
+);
+
+// Triangles of a cube, wound counter clockwise from the outside. Corner i is at -1 or 1 on each axis
+// depending on its bits, where bit 0 is x, bit 1 is y and bit 2 is z
+int cubeCorners[36] = int[](
+    1, 3, 7, 1, 7, 5,
+    0, 4, 6, 0, 6, 2,
+    2, 6, 7, 2, 7, 3,
+    0, 1, 5, 0, 5, 4,
+    4, 5, 7, 4, 7, 6,
+    0, 3, 1, 0, 2, 3
+);
+
+void main()
+{
+    lightIndex = gl_InstanceID;
+
+    // Point lights are drawn as a cube around their radius, so only the pixels they can reach are shaded
+    if (mode == MODE_POINT_LIGHTS)
+    {
+        int corner = cubeCorners[gl_VertexID];
+        vec3 cornerPos = vec3((corner & 1) != 0 ? 1.0 : -1.0, (corner & 2) != 0 ? 1.0 : -1.0, (corner & 4) != 0 ? 1.0 : -1.0);
+
+        PointLight light = batchPointLights[gl_InstanceID];
+        gl_Position = projViewMat * vec4(light.pos + cornerPos * light.radius, 1.0);
+        return;
+    }
+
+    vec2 uv = quadData[gl_VertexID];
+    gl_Position = vec4(uv * 2.0 - 1.0, 0.0, 1.0);
+}
+
+//shader:fragment
+#version 410
+
+/*
+    Lights the G-buffer written by res/shaders/gbuffer.glsl, with the same lighting as res/shaders/simple.glsl done in world space.
+    Positions are rebuilt from the depth buffer, and each mode is one pass of rend3dgl.Deferred.Light
+*/
+
+#define MODE_BASE 0
+#define MODE_POINT_LIGHTS 1
+#define MODE_SPOT_LIGHTS 2
+#define MODE_FOG 3
+
+// Must match rend3dgl.DeferredLightBatchSize
+#define LIGHT_BATCH_SIZE 64
+
+#define NUM_SPOT_LIGHTS 4
+#define NUM_POINT_LIGHTS 8
+#define NUM_AREA_LIGHTS 4
+
+flat in int lightIndex;
+
+//
+// Uniforms
+//
+uniform int mode;
+
+// batchStart is the index of the first light of the batch in all the visible lights
+uniform int batchStart;
+uniform mat4 invProjViewMat;
+
+uniform sampler2D gAlbedo;
+uniform sampler2D gNormal;
+uniform sampler2D gLight;
+uniform isampler2D gLayers;
+uniform sampler2D gDepth;
+
+struct ShadowSettings {
+    int enabled;
+    float biasConstant;
+    float biasSlope;
+    float normalOffset;
+    int pcfRadius;
+    float nearPlane;
+    float farPlane;
+};
+
+struct DirLight {
+    vec3 dir;
+    vec3 diffuseColor;
+    vec3 specularColor;
+    ShadowSettings shadow;
+    uint cullingMask;
+};
+uniform sampler2D dirLightShadowMap;
+uniform mat4 dirLightProjViewMat;
+
+struct PointLight {
+    vec3 pos;
+    vec3 diffuseColor;
+    vec3 specularColor;
+    float radius;
+    float falloff;
+    ShadowSettings shadow;
+    int shadowLayer;
+    uint cullingMask;
+};
+uniform samplerCubeArray pointLightCubeShadowMaps;
+
+struct SpotLight {
+    vec3 pos;
+    vec3 dir;
+    vec3 diffuseColor;
+    vec3 specularColor;
+    float innerCutoff;
+    float outerCutoff;
+    ShadowSettings shadow;
+    int hasCookie;
+    uint cullingMask;
+};
+
+// Only the spot lights that are also in the lights ubo have shadow maps, cookies and projections, at their ubo index
+uniform sampler2DArray spotLightShadowMaps;
+uniform sampler2D spotLightCookies[NUM_SPOT_LIGHTS];
+uniform mat4 spotLightProjViewMats[NUM_SPOT_LIGHTS];
+
+struct AreaLight {
+    vec3 pos;
+    vec3 halfRight;
+    vec3 halfUp;
+    vec3 diffuseColor;
+    vec3 specularColor;
+    int twoSided;
+    uint cullingMask;
+};
+
+// Area light lookup tables. See lights.LtcLuts for their contents
+#define LTC_LUT_SIZE 32
+uniform sampler2D ltcMat;
+uniform sampler2D ltcAmp;
+
+layout (std140) uniform GlobalMatrices {
+    vec3 camPos;
+    mat4 projViewMat;
+};
+
+layout (std140) uniform Lights {
+    DirLight dirLight;
+    PointLight pointLights[NUM_POINT_LIGHTS];
+    SpotLight spotLights[NUM_SPOT_LIGHTS];
+    AreaLight areaLights[NUM_AREA_LIGHTS];
+    vec3 ambientColor;
+};
+
+// Must match the vertex shader block
+layout (std140) uniform DeferredLights {
+    PointLight batchPointLights[LIGHT_BATCH_SIZE];
+    SpotLight batchSpotLights[LIGHT_BATCH_SIZE];
+};
+
+// See renderer.Fog
+layout (std140) uniform Fog {
+    vec3 fogColor;
+    int fogMode;
+    float fogDensity;
+    float fogStart;
+    float fogEnd;
+    float fogHeightFalloff;
+    float fogHeightBase;
+    float fogSkyBlend;
+};
+
+out vec4 fragColor;
+
+//
+// The surface of the pixel read from the G-buffer
+//
+vec3 fragPos;
+vec3 normal;
+vec3 viewDir;
+vec3 diffuseColor;
+float specularIntensity;
+float shininess;
+bool receiveShadows;
+uint layers;
+
+// LightsObject returns true if a light with cullingMask lights the object's layers (see camera.LayerMask)
+bool LightsObject(uint cullingMask)
+{
+    return (cullingMask & layers) != 0u;
+}
+
+vec3 CalcBlinnPhong(vec3 lightDir, vec3 lightDiffuse, vec3 lightSpecular)
+{
+    float diffuseAmount = max(0.0, dot(normal, lightDir));
+
+    vec3 halfwayDir = normalize(lightDir + viewDir);
+    float specularAmount = pow(max(dot(normal, halfwayDir), 0.0), shininess);
+
+    return diffuseAmount * lightDiffuse * diffuseColor + specularAmount * lightSpecular * specularIntensity;
+}
+
+float CalcShadowBias(ShadowSettings shadowSettings, vec3 lightDir)
+{
+    return max(shadowSettings.biasSlope * (1 - dot(normal, lightDir)), shadowSettings.biasConstant);
+}
+
+float CalcDirShadow(vec3 lightDir)
+{
+    if (dirLight.shadow.enabled == 0 || !receiveShadows)
+        return 0;
+
+    // Offsetting along the normal before projecting reduces shadow acne without a large depth bias
+    vec4 lightClipPos = dirLightProjViewMat * vec4(fragPos + normal * dirLight.shadow.normalOffset, 1);
+    vec3 projCoords = (lightClipPos.xyz / lightClipPos.w) * 0.5 + 0.5;
+    if (projCoords.z > 1)
+        return 0;
+
+    float bias = CalcShadowBias(dirLight.shadow, lightDir);
+
+    float shadow = 0;
+    int pcfRadius = dirLight.shadow.pcfRadius;
+    vec2 texelSize = 1.0 / vec2(textureSize(dirLightShadowMap, 0));
+    for (int x = -pcfRadius; x <= pcfRadius; x++)
+    {
+        for (int y = -pcfRadius; y <= pcfRadius; y++)
+        {
+            float pcfDepth = texture(dirLightShadowMap, projCoords.xy + vec2(x, y) * texelSize).r;
+            shadow += projCoords.z - bias > pcfDepth ? 1 : 0;
+        }
+    }
+
+    return shadow / ((2 * pcfRadius + 1) * (2 * pcfRadius + 1));
+}
+
+vec3 CalcDirLight()
+{
+    if (!LightsObject(dirLight.cullingMask))
+        return vec3(0);
+
+    vec3 lightDir = normalize(-dirLight.dir);
+    return CalcBlinnPhong(lightDir, dirLight.diffuseColor, dirLight.specularColor) * (1 - CalcDirShadow(lightDir));
+}
+
+// Offsets used for PCF on cubemaps, which sample around the light to fragment direction
+const vec3 pointPcfOffsets[20] = vec3[](
+   vec3( 1,  1,  1), vec3( 1, -1,  1), vec3(-1, -1,  1), vec3(-1,  1,  1),
+   vec3( 1,  1, -1), vec3( 1, -1, -1), vec3(-1, -1, -1), vec3(-1,  1, -1),
+   vec3( 1,  1,  0), vec3( 1, -1,  0), vec3(-1, -1,  0), vec3(-1,  1,  0),
+   vec3( 1,  0,  1), vec3(-1,  0,  1), vec3( 1,  0, -1), vec3(-1,  0, -1),
+   vec3( 0,  1,  1), vec3( 0, -1,  1), vec3( 0, -1, -1), vec3( 0,  1, -1)
+);
+
+float CalcPointShadow(PointLight light, vec3 lightDir)
+{
+    if (light.shadow.enabled == 0 || light.shadowLayer < 0 || !receiveShadows)
+        return 0;
+
+    vec3 lightToFrag = fragPos + normal * light.shadow.normalOffset - light.pos;
+    float currentDepth = length(lightToFrag);
+    if (currentDepth < light.shadow.nearPlane)
+        return 0;
+
+    float bias = CalcShadowBias(light.shadow, lightDir);
+
+    int sampleCount = light.shadow.pcfRadius == 0 ? 1 : 20;
+    float diskRadius = light.shadow.pcfRadius * 0.02;
+
+    float shadow = 0;
+    for (int i = 0; i < sampleCount; i++)
+    {
+        vec3 sampleDir = lightToFrag + (sampleCount == 1 ? vec3(0) : pointPcfOffsets[i] * diskRadius * currentDepth);
+        float closestDepth = texture(pointLightCubeShadowMaps, vec4(sampleDir, light.shadowLayer)).r * light.shadow.farPlane;
+        shadow += currentDepth - bias > closestDepth ? 1 : 0;
+    }
+
+    return shadow / sampleCount;
+}
+
+float sqr(float x)
+{
+    return x * x;
+}
+
+// See AttenuateNoCusp in res/shaders/simple.glsl
+float AttenuateNoCusp(float dist, float radius, float falloff)
+{
+    float s2 = sqr(dist / radius);
+    return sqr(1 - s2) / (1 + falloff * s2);
+}
+
+vec3 CalcPointLight(PointLight light)
+{
+    vec3 fragToLight = light.pos - fragPos;
+    float distToLight = length(fragToLight);
+
+    // The light volume is a cube, so its corners cover pixels past the radius
+    if (light.radius == 0 || distToLight >= light.radius || !LightsObject(light.cullingMask))
+        discard;
+
+    vec3 lightDir = fragToLight / distToLight;
+    float attenuation = AttenuateNoCusp(distToLight, light.radius, light.falloff);
+
+    return CalcBlinnPhong(lightDir, light.diffuseColor, light.specularColor) * attenuation * (1 - CalcPointShadow(light, lightDir));
+}
+
+float CalcSpotShadow(SpotLight light, int uboIndex, vec3 lightDir)
+{
+    if (light.shadow.enabled == 0 || uboIndex < 0 || !receiveShadows)
+        return 0;
+
+    vec4 lightClipPos = spotLightProjViewMats[uboIndex] * vec4(fragPos + normal * light.shadow.normalOffset, 1);
+    vec3 projCoords = (lightClipPos.xyz / lightClipPos.w) * 0.5 + 0.5;
+    if (projCoords.z > 1)
+        return 0;
+
+    float bias = CalcShadowBias(light.shadow, lightDir);
+
+    float shadow = 0;
+    int pcfRadius = light.shadow.pcfRadius;
+    vec2 texelSize = 1.0 / vec2(textureSize(spotLightShadowMaps, 0).xy);
+    for (int x = -pcfRadius; x <= pcfRadius; x++)
+    {
+        for (int y = -pcfRadius; y <= pcfRadius; y++)
+        {
+            float pcfDepth = texture(spotLightShadowMaps, vec3(projCoords.xy + vec2(x, y) * texelSize, uboIndex)).r;
+            shadow += projCoords.z - bias > pcfDepth ? 1 : 0;
+        }
+    }
+
+    return shadow / ((2 * pcfRadius + 1) * (2 * pcfRadius + 1));
+}
+
+// SampleCookie reads the cookie of a ubo index. Sampler arrays can only be indexed by values that are the same for
+// the whole draw, and instances of a draw are different spot lights, so each index is its own branch
+vec3 SampleCookie(int uboIndex, vec2 uv)
+{
+    if (uboIndex == 0)
+        return texture(spotLightCookies[0], uv).rgb;
+    if (uboIndex == 1)
+        return texture(spotLightCookies[1], uv).rgb;
+    if (uboIndex == 2)
+        return texture(spotLightCookies[2], uv).rgb;
+    return texture(spotLightCookies[3], uv).rgb;
+}
+
+vec3 CalcSpotCookie(SpotLight light, int uboIndex)
+{
+    if (light.hasCookie == 0 || uboIndex < 0)
+        return vec3(1);
+
+    vec4 lightClipPos = spotLightProjViewMats[uboIndex] * vec4(fragPos, 1);
+    if (lightClipPos.w <= 0)
+        return vec3(0);
+
+    vec2 cookieUV = (lightClipPos.xy / lightClipPos.w) * 0.5 + 0.5;
+    if (cookieUV.x < 0 || cookieUV.x > 1 || cookieUV.y < 0 || cookieUV.y > 1)
+        return vec3(0);
+
+    return SampleCookie(uboIndex, cookieUV);
+}
+
+vec3 CalcSpotLight(SpotLight light, int uboIndex)
+{
+    if (light.innerCutoff == 1 || !LightsObject(light.cullingMask))
+        discard;
+
+    vec3 lightDir = normalize(light.pos - fragPos);
+
+    float theta = dot(lightDir, normalize(-light.dir));
+    float epsilon = light.innerCutoff - light.outerCutoff;
+    float intensity = clamp((theta - light.outerCutoff) / epsilon, 0.0, 1.0);
+    if (intensity == 0)
+        discard;
+
+    float shadow = CalcSpotShadow(light, uboIndex, lightDir);
+    return CalcBlinnPhong(lightDir, light.diffuseColor, light.specularColor) * intensity * (1 - shadow) * CalcSpotCookie(light, uboIndex);
+}
+
+//
+// Area lights using 'Linearly Transformed Cosines'. See res/shaders/simple.glsl
+//
+vec2 LtcLutUV(vec2 uv)
+{
+    return uv * (LTC_LUT_SIZE - 1.0) / LTC_LUT_SIZE + 0.5 / LTC_LUT_SIZE;
+}
+
+vec3 IntegrateEdgeVec(vec3 v1, vec3 v2)
+{
+    float x = dot(v1, v2);
+    float y = abs(x);
+
+    float a = 0.8543985 + (0.4965155 + 0.0145206 * y) * y;
+    float b = 3.4175940 + (4.1616724 + y) * y;
+    float v = a / b;
+
+    float thetaSinTheta = x > 0.0 ? v : 0.5 * inversesqrt(max(1.0 - x * x, 1e-7)) - v;
+    return cross(v1, v2) * thetaSinTheta;
+}
+
+float LtcEvaluate(vec3 N, vec3 V, vec3 P, mat3 minv, vec3 points[4], bool twoSided)
+{
+    vec3 T1 = normalize(V - N * dot(V, N));
+    vec3 T2 = cross(N, T1);
+    minv = minv * transpose(mat3(T1, T2, N));
+
+    vec3 L[4];
+    L[0] = normalize(minv * (points[0] - P));
+    L[1] = normalize(minv * (points[1] - P));
+    L[2] = normalize(minv * (points[2] - P));
+    L[3] = normalize(minv * (points[3] - P));
+
+    vec3 lightNormal = cross(points[1] - points[0], points[3] - points[0]);
+    bool inFront = dot(points[0] - P, lightNormal) < 0.0;
+
+    vec3 vsum = IntegrateEdgeVec(L[0], L[1]);
+    vsum += IntegrateEdgeVec(L[1], L[2]);
+    vsum += IntegrateEdgeVec(L[2], L[3]);
+    vsum += IntegrateEdgeVec(L[3], L[0]);
+
+    float len = length(vsum);
+    if (len == 0.0 || (!inFront && !twoSided))
+        return 0.0;
+
+    float z = vsum.z / len;
+    if (inFront)
+        z = -z;
+
+    float horizonScale = texture(ltcAmp, LtcLutUV(vec2(z * 0.5 + 0.5, len))).w;
+    return len * horizonScale;
+}
+
+vec3 CalcAreaLight(AreaLight light)
+{
+    if (light.halfRight == vec3(0) || light.halfUp == vec3(0) || !LightsObject(light.cullingMask))
+        return vec3(0);
+
+    vec3 points[4];
+    points[0] = light.pos - light.halfRight - light.halfUp;
+    points[1] = light.pos + light.halfRight - light.halfUp;
+    points[2] = light.pos + light.halfRight + light.halfUp;
+    points[3] = light.pos - light.halfRight + light.halfUp;
+
+    // A GGX roughness that roughly matches the blinn-phong shininess
+    float roughness = sqrt(sqrt(2.0 / (shininess + 2.0)));
+    float dotNV = clamp(dot(normal, viewDir), 0.0, 1.0);
+
+    vec2 lutUV = LtcLutUV(vec2(roughness, sqrt(1.0 - dotNV)));
+    vec4 t1 = texture(ltcMat, lutUV);
+    vec4 t2 = texture(ltcAmp, lutUV);
+    mat3 minv = mat3(
+        vec3(t1.x, 0, t1.y),
+        vec3(0, 1, 0),
+        vec3(t1.z, 0, t1.w)
+    );
+
+    bool twoSided = light.twoSided != 0;
+    float diffuseAmount = LtcEvaluate(normal, viewDir, fragPos, mat3(1), points, twoSided);
+    float specularAmount = LtcEvaluate(normal, viewDir, fragPos, minv, points, twoSided);
+
+    float specularScale = specularIntensity * t2.x + (1.0 - specularIntensity) * t2.y;
+    return diffuseAmount * light.diffuseColor * diffuseColor + specularAmount * light.specularColor * specularScale;
+}
+
+// CalcFog returns how much fog is between the camera and worldPos in [0, 1]. See res/shaders/simple.glsl
+float CalcFog(vec3 worldPos)
+{
+    if (fogMode == 0)
+        return 0;
+
+    vec3 camToPos = worldPos - camPos;
+    float dist = max(length(camToPos) - fogStart, 0.0);
+
+    float fog;
+    if (fogMode == 1)
+        fog = dist / max(fogEnd - fogStart, 0.0001);
+    else if (fogMode == 2)
+        fog = 1.0 - exp(-fogDensity * dist);
+    else
+        fog = 1.0 - exp(-pow(fogDensity * dist, 2.0));
+
+    if (fogHeightFalloff > 0)
+    {
+        float camHeightDensity = exp(-fogHeightFalloff * (camPos.y - fogHeightBase));
+        float falloffAlongRay = fogHeightFalloff * camToPos.y;
+
+        float heightFactor = camHeightDensity;
+        if (abs(falloffAlongRay) > 0.0001)
+            heightFactor *= (1.0 - exp(-falloffAlongRay)) / falloffAlongRay;
+
+        fog *= heightFactor;
+    }
+
+    return clamp(fog, 0.0, 1.0);
+}
+
+void main()
+{
+    ivec2 pixel = ivec2(gl_FragCoord.xy);
+    float depth = texelFetch(gDepth, pixel, 0).r;
+
+    // Nothing was drawn into the G-buffer here, which is left to the forward passes (e.g. the skybox)
+    if (depth == 1.0)
+        discard;
+
+    vec2 uv = (vec2(pixel) + 0.5) / vec2(textureSize(gDepth, 0));
+    vec4 worldPos = invProjViewMat * vec4(vec3(uv, depth) * 2.0 - 1.0, 1.0);
+    fragPos = worldPos.xyz / worldPos.w;
+
+    if (mode == MODE_FOG)
+    {
+        fragColor = vec4(fogColor, CalcFog(fragPos));
+        return;
+    }
+
+    vec4 albedo = texelFetch(gAlbedo, pixel, 0);
+    vec4 normalShininess = texelFetch(gNormal, pixel, 0);
+    vec4 light = texelFetch(gLight, pixel, 0);
+
+    diffuseColor = albedo.rgb;
+    specularIntensity = albedo.a;
+    normal = normalize(normalShininess.xyz);
+    shininess = normalShininess.w;
+    receiveShadows = light.a > 0.5;
+    layers = uint(texelFetch(gLayers, pixel, 0).r);
+    viewDir = normalize(camPos - fragPos);
+
+    vec3 color;
+    if (mode == MODE_BASE)
+    {
+        color = light.rgb + CalcDirLight();
+        for (int i = 0; i < NUM_AREA_LIGHTS; i++)
+            color += CalcAreaLight(areaLights[i]);
+    }
+    else if (mode == MODE_POINT_LIGHTS)
+    {
+        color = CalcPointLight(batchPointLights[lightIndex]);
+    }
+    else
+    {
+        int visibleIndex = batchStart + lightIndex;
+        color = CalcSpotLight(batchSpotLights[lightIndex], visibleIndex < NUM_SPOT_LIGHTS ? visibleIndex : -1);
+    }
+
+    fragColor = vec4(color, 1);
+}
+`
+
+const (
+	deferredMode_Base int32 = iota
+	deferredMode_PointLights
+	deferredMode_SpotLights
+	deferredMode_Fog
+)
+
+const (
+	// DeferredLightBatchSize is how many point lights and how many spot lights are drawn by one instanced draw.
+	// Must match LIGHT_BATCH_SIZE of the deferred lighting shader, and a batch must fit in the 16KB uniform blocks are guaranteed
+	DeferredLightBatchSize = 64
+
+	// lightVolumeVertexCount is the number of vertices of the cube the lighting shader makes from gl_VertexID
+	lightVolumeVertexCount = 36
+)
+
+// Names of the G-buffer attachments (see buffers.Framebuffer.Tex). The color attachments are in the order
+// of the outputs of res/shaders/gbuffer.glsl
+const (
+	GBufferAlbedoName = "albedo"
+	GBufferNormalName = "normal"
+	GBufferLightName  = "light"
+	GBufferLayersName = "layers"
+	GBufferDepthName  = "depth"
+)
+
+// Slots the G-buffer is bound to for the lighting shader, which are material slots the lighting material doesn't otherwise use
+const (
+	gbufferSlot_Albedo = materials.TextureSlot_Diffuse
+	gbufferSlot_Depth  = materials.TextureSlot_Specular
+	gbufferSlot_Normal = materials.TextureSlot_Normal
+	gbufferSlot_Light  = materials.TextureSlot_Emission
+	gbufferSlot_Layers = materials.TextureSlot_Metallic
+)
+
+// deferredLightsUboData is one batch of the 'DeferredLights' uniform block of the lighting shader
+type deferredLightsUboData struct {
+	PointLights [DeferredLightBatchSize]lights.PointLightUboData
+	SpotLights  [DeferredLightBatchSize]lights.SpotLightUboData
+}
+
+// Deferred renders lit objects in two steps. Objects are first drawn into the G-buffer with G-buffer materials
+// (see materials.NewGBufferMaterial and res/shaders/gbuffer.glsl), which stores their surface instead of lighting it.
+// Light then shades every pixel once per light that reaches it, so the cost of a light is the pixels it covers rather than
+// the objects it touches, and the lights aren't limited by the size of the lights ubo.
+//
+// Point lights are drawn as instanced cubes around their radius, while spot lights, which have no range in the lit shaders,
+// are drawn over the whole screen. Lights are uploaded in batches of DeferredLightBatchSize. Only the lights that are also in
+// the lights ubo (see lights.LightManager) have shadows and cookies, and debug views aren't supported.
+//
+// Objects whose materials have no G-buffer version (e.g. PBR and blended materials) are drawn forward after Light,
+// and are depth tested against the G-buffer
+type Deferred struct {
+	GBuffer buffers.Framebuffer
+
+	// LightMat shades the G-buffer. The caller sets its shadow map and LTC textures, the 'dirLightProjViewMat' and
+	// 'spotLightProjViewMats' uniforms, and the 'GlobalMatrices', 'Lights' and renderer.FogUboBlockName binding points
+	// the same way as on the lit materials. Spot light cookies go in SpotLightCookieTexs like on lit materials
+	LightMat materials.Material
+
+	// MaxLights is the most point lights, and separately spot lights, lit per frame. When more are visible
+	// the ones furthest from the camera are dropped
+	MaxLights int32
+
+	vao buffers.VertexArray
+
+	lightsRing      buffers.UniformRingBuffer
+	lightsLayout    buffers.UniformBuffer
+	lightsBindPoint uint32
+	lightsData      deferredLightsUboData
+
+	// wasBlendEnabled is the blending before BeginGeometry, which EndGeometry restores
+	wasBlendEnabled bool
+}
+
+// Resize reallocates the G-buffer when the size changed, and should be called with the size of the target before BeginGeometry
+func (d *Deferred) Resize(width, height int32) {
+
+	if d.GBuffer.Id != 0 && uint32(width) == d.GBuffer.Width && uint32(height) == d.GBuffer.Height {
+		return
+	}
+
+	d.GBuffer.Delete()
+	d.GBuffer = assert.MustGet(buffers.NewFramebuffer(uint32(width), uint32(height)))
+
+	// The alpha of RGBA8 attachments isn't kept, so albedo uses a float format too
+	assert.Must(d.GBuffer.Attach(GBufferAlbedoName, buffers.FramebufferAttachmentType_Texture, buffers.FramebufferAttachmentDataFormat_RGBAF16))
+	assert.Must(d.GBuffer.Attach(GBufferNormalName, buffers.FramebufferAttachmentType_Texture, buffers.FramebufferAttachmentDataFormat_RGBAF16))
+	assert.Must(d.GBuffer.Attach(GBufferLightName, buffers.FramebufferAttachmentType_Texture, buffers.FramebufferAttachmentDataFormat_RGBAF16))
+	assert.Must(d.GBuffer.Attach(GBufferLayersName, buffers.FramebufferAttachmentType_Texture, buffers.FramebufferAttachmentDataFormat_R32Int))
+
+	// Same format as the depth of the targets Light copies it into
+	assert.Must(d.GBuffer.Attach(GBufferDepthName, buffers.FramebufferAttachmentType_Texture, buffers.FramebufferAttachmentDataFormat_Depth24Stencil8))
+
+	assert.T(d.GBuffer.IsComplete(), "G-buffer is not complete after resizing to %dx%d", width, height)
+}
+
+// BeginGeometry binds and clears the G-buffer, which lit objects are then drawn into with G-buffer materials.
+// Blending is off until EndGeometry, since the G-buffer has data in its alpha channels
+func (d *Deferred) BeginGeometry(r *Rend3DGL) {
+
+	assert.T(d.GBuffer.Id != 0, "Deferred.BeginGeometry called before Deferred.Resize")
+
+	d.GBuffer.Bind()
+	r.PushViewport(0, 0, int32(d.GBuffer.Width), int32(d.GBuffer.Height))
+	d.GBuffer.Clear()
+
+	d.wasBlendEnabled = gl.IsEnabled(gl.BLEND)
+	gl.Disable(gl.BLEND)
+}
+
+// EndGeometry finishes the geometry pass started by BeginGeometry and restores blending.
+// The framebuffer bound before BeginGeometry must be bound again by the caller
+func (d *Deferred) EndGeometry(r *Rend3DGL) {
+
+	if d.wasBlendEnabled {
+		gl.Enable(gl.BLEND)
+	}
+
+	d.GBuffer.UnBind()
+	r.PopViewport()
+}
+
+// Light copies the depth of the G-buffer into dst, then draws the lighting of the G-buffer into it. dst must be bound with a viewport
+// the size of the G-buffer, and have a Depth24Stencil8 depth attachment, so forward passes after Light (e.g. the skybox and
+// transparent objects) are depth tested against the lit objects. The lighting of lm and the projection of cam must be the ones
+// the G-buffer was drawn with, and the 'GlobalMatrices', 'Lights' and fog blocks must be bound.
+// Blending is changed while lighting and restored to the engine default after
+func (d *Deferred) Light(r *Rend3DGL, lm *lights.LightManager, cam *camera.Camera, dst *buffers.Framebuffer) {
+
+	d.copyDepthTo(dst)
+
+	projViewMat := gglm.MulMat4(&cam.ProjMat, &cam.ViewMat)
+	d.LightMat.SetUnifMat4("invProjViewMat", projViewMat.Clone().Invert())
+
+	// Mesh draws skip binding their vao when it is the last one they bound, which isn't true after this
+	d.vao.Bind()
+	r.BoundVaoId = d.vao.Id
+	r.BoundMeshVaoId = 0
+
+	r.bindMat(&d.LightMat)
+	d.bindGBufferTextures()
+
+	wasBlendEnabled := gl.IsEnabled(gl.BLEND)
+
+	// Every pixel is shaded once per light, so depth is only read from the G-buffer
+	gl.Disable(gl.DEPTH_TEST)
+	gl.DepthMask(false)
+
+	// Ambient, emission, the directional light and area lights overwrite dst
+	gl.Disable(gl.BLEND)
+	d.LightMat.SetUnifInt32("mode", deferredMode_Base)
+	gl.DrawArrays(gl.TRIANGLES, 0, 6)
+	r.countDraw(2, 1)
+
+	// Point and spot lights are added on top
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.ONE, gl.ONE)
+	d.drawLightBatches(r, lm)
+
+	// Fog is blended over all the light, which is what the lit shaders do by mixing it into their final color
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+	d.LightMat.SetUnifInt32("mode", deferredMode_Fog)
+	gl.DrawArrays(gl.TRIANGLES, 0, 6)
+	r.countDraw(2, 1)
+
+	if !wasBlendEnabled {
+		gl.Disable(gl.BLEND)
+	}
+
+	gl.DepthMask(true)
+	gl.Enable(gl.DEPTH_TEST)
+}
+
+// drawLightBatches draws the visible point and spot lights of lm, DeferredLightBatchSize of each per batch
+func (d *Deferred) drawLightBatches(r *Rend3DGL, lm *lights.LightManager) {
+
+	pointCount := min(len(lm.AllVisiblePointLights), int(d.MaxLights))
+	spotCount := min(len(lm.AllVisibleSpotLights), int(d.MaxLights))
+
+	// Uniform ring buffers write to the bound uniform buffer, so the caller's ring is bound again after
+	var prevUbo int32
+	gl.GetIntegerv(gl.UNIFORM_BUFFER_BINDING, &prevUbo)
+
+	d.lightsRing.BeginFrame()
+	d.lightsRing.Bind()
+
+	for batchStart := 0; batchStart < max(pointCount, spotCount); batchStart += DeferredLightBatchSize {
+
+		pointsInBatch := min(max(pointCount-batchStart, 0), DeferredLightBatchSize)
+		for i := 0; i < pointsInBatch; i++ {
+
+			// Lights in the ubo have their shadow layer there
+			visibleIndex := batchStart + i
+			if visibleIndex < len(lm.VisiblePointLights) {
+				d.lightsData.PointLights[i] = lm.UboData.PointLights[visibleIndex]
+			} else {
+				d.lightsData.PointLights[i] = lm.AllVisiblePointLights[visibleIndex].ToUboData()
+			}
+		}
+
+		spotsInBatch := min(max(spotCount-batchStart, 0), DeferredLightBatchSize)
+		for i := 0; i < spotsInBatch; i++ {
+			d.lightsData.SpotLights[i] = lm.AllVisibleSpotLights[batchStart+i].ToUboData()
+		}
+
+		d.lightsRing.BindRange(d.lightsBindPoint, d.lightsRing.SetStruct(&d.lightsLayout, &d.lightsData))
+		d.LightMat.SetUnifInt32("batchStart", int32(batchStart))
+
+		// Only the back faces of the light volumes are drawn, so each pixel is lit once even when the camera is inside a volume.
+		// Depth clamping keeps the back faces behind the far plane
+		if pointsInBatch > 0 {
+
+			gl.CullFace(gl.FRONT)
+			gl.Enable(gl.DEPTH_CLAMP)
+
+			d.LightMat.SetUnifInt32("mode", deferredMode_PointLights)
+			gl.DrawArraysInstanced(gl.TRIANGLES, 0, lightVolumeVertexCount, int32(pointsInBatch))
+			r.countDraw(lightVolumeVertexCount/3, int32(pointsInBatch))
+
+			gl.Disable(gl.DEPTH_CLAMP)
+			gl.CullFace(gl.BACK)
+		}
+
+		if spotsInBatch > 0 {
+			d.LightMat.SetUnifInt32("mode", deferredMode_SpotLights)
+			gl.DrawArraysInstanced(gl.TRIANGLES, 0, 6, int32(spotsInBatch))
+			r.countDraw(2, int32(spotsInBatch))
+		}
+	}
+
+	d.lightsRing.EndFrame()
+	gl.BindBuffer(gl.UNIFORM_BUFFER, uint32(prevUbo))
+}
+
+// bindGBufferTextures binds the G-buffer for the lighting shader. The renderer only binds the material when it changes,
+// which would also bind the material's own textures over the G-buffer, so this is done after binding the material
+func (d *Deferred) bindGBufferTextures() {
+
+	gl.ActiveTexture(uint32(gl.TEXTURE0 + gbufferSlot_Albedo))
+	gl.BindTexture(gl.TEXTURE_2D, d.GBuffer.Tex(GBufferAlbedoName))
+
+	gl.ActiveTexture(uint32(gl.TEXTURE0 + gbufferSlot_Normal))
+	gl.BindTexture(gl.TEXTURE_2D, d.GBuffer.Tex(GBufferNormalName))
+
+	gl.ActiveTexture(uint32(gl.TEXTURE0 + gbufferSlot_Light))
+	gl.BindTexture(gl.TEXTURE_2D, d.GBuffer.Tex(GBufferLightName))
+
+	gl.ActiveTexture(uint32(gl.TEXTURE0 + gbufferSlot_Layers))
+	gl.BindTexture(gl.TEXTURE_2D, d.GBuffer.Tex(GBufferLayersName))
+
+	gl.ActiveTexture(uint32(gl.TEXTURE0 + gbufferSlot_Depth))
+	gl.BindTexture(gl.TEXTURE_2D, d.GBuffer.Tex(GBufferDepthName))
+}
+
+// copyDepthTo blits the depth and stencil of the G-buffer into dst, and leaves dst bound
+func (d *Deferred) copyDepthTo(dst *buffers.Framebuffer) {
+
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, d.GBuffer.Id)
+	gl.BindFramebuffer(gl.DRAW_FRAMEBUFFER, dst.Id)
+
+	w, h := int32(d.GBuffer.Width), int32(d.GBuffer.Height)
+	gl.BlitFramebuffer(0, 0, w, h, 0, 0, w, h, gl.DEPTH_BUFFER_BIT|gl.STENCIL_BUFFER_BIT, gl.NEAREST)
+
+	dst.Bind()
+}
+
+// RecreateGpuObjects replaces the objects of the lost GL context, e.g. from engine.ContextLossHandler.OnContextRestored.
+// The G-buffer is created again by the next Resize, and the caller sets the textures and uniforms of LightMat again
+func (d *Deferred) RecreateGpuObjects() {
+	d.GBuffer = buffers.Framebuffer{}
+	d.LightMat = newDeferredLightMat(d.lightsBindPoint)
+	d.vao = buffers.NewVertexArray()
+	d.lightsRing.Recreate()
+}
+
+func (d *Deferred) Delete() {
+	d.GBuffer.Delete()
+	d.vao.Delete()
+	d.lightsRing.Delete()
+	d.LightMat.Delete()
+}
+
+// newDeferredLightMat creates the material that shades the G-buffer, with its samplers set to their texture slots
+func newDeferredLightMat(lightsBindPoint uint32) materials.Material {
+
+	mat := assert.MustGet(materials.NewMaterialSrc("Deferred Light Mat", []byte(deferredLightShader)))
+
+	mat.SetUnifInt32("gAlbedo", int32(gbufferSlot_Albedo))
+	mat.SetUnifInt32("gNormal", int32(gbufferSlot_Normal))
+	mat.SetUnifInt32("gLight", int32(gbufferSlot_Light))
+	mat.SetUnifInt32("gLayers", int32(gbufferSlot_Layers))
+	mat.SetUnifInt32("gDepth", int32(gbufferSlot_Depth))
+
+	mat.SetUnifInt32("dirLightShadowMap", int32(materials.TextureSlot_ShadowMap1))
+	mat.SetUnifInt32("pointLightCubeShadowMaps", int32(materials.TextureSlot_Cubemap_Array))
+	mat.SetUnifInt32("spotLightShadowMaps", int32(materials.TextureSlot_ShadowMap_Array1))
+	mat.SetUnifInt32("ltcMat", int32(materials.TextureSlot_LtcMat))
+	mat.SetUnifInt32("ltcAmp", int32(materials.TextureSlot_LtcAmp))
+	for i := int32(0); i < materials.MaxSpotLightCookies; i++ {
+		mat.SetUnifInt32("spotLightCookies["+strconv.Itoa(int(i))+"]", int32(materials.TextureSlot_SpotLightCookie0)+i)
+	}
+
+	mat.SetUniformBlockBindingPoint("DeferredLights", lightsBindPoint)
+
+	return mat
+}
+
+// NewDeferred creates a deferred renderer that lights up to maxLights point lights and maxLights spot lights per frame,
+// with the batches of lights bound to the uniform block binding point lightsBindPoint, which must not be used by other blocks.
+// The G-buffer is created by the first Resize
+func NewDeferred(maxLights int32, lightsBindPoint uint32) Deferred {
+
+	d := Deferred{
+		LightMat:        newDeferredLightMat(lightsBindPoint),
+		MaxLights:       maxLights,
+		vao:             buffers.NewVertexArray(),
+		lightsLayout:    buffers.NewUniformBufferLayoutFor[deferredLightsUboData](buffers.BlockLayout_Std140),
+		lightsBindPoint: lightsBindPoint,
+	}
+
+	// Every batch is its own range, which starts at the ring's offset alignment
+	var offsetAlignment int32
+	gl.GetIntegerv(gl.UNIFORM_BUFFER_OFFSET_ALIGNMENT, &offsetAlignment)
+
+	batchCount := (maxLights + DeferredLightBatchSize - 1) / DeferredLightBatchSize
+	d.lightsRing = buffers.NewUniformRingBuffer(uint32(max(batchCount, 1))*(d.lightsLayout.Size+uint32(max(offsetAlignment, 256))), buffers.DefaultUniformRingFramesInFlight)
+
+	return d
+}
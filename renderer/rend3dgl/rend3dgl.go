@@ -1,8 +1,14 @@
 package rend3dgl
 
 import (
+	"fmt"
+
 	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/assert"
 	"github.com/bloeys/nmage/buffers"
+	"github.com/bloeys/nmage/camera"
+	"github.com/bloeys/nmage/consts"
+	"github.com/bloeys/nmage/logging"
 	"github.com/bloeys/nmage/materials"
 	"github.com/bloeys/nmage/meshes"
 	"github.com/bloeys/nmage/renderer"
@@ -11,36 +17,374 @@ import (
 
 var _ renderer.Render = &Rend3DGL{}
 
+type layoutValidationKey struct {
+	shaderProgId   uint32
+	layout         *buffers.VertexLayout
+	instanceLayout *buffers.VertexLayout
+}
+
+// scissorState is a scissor rect and whether the scissor test is enabled
+type scissorState struct {
+	Rect      renderer.Rect
+	IsEnabled bool
+}
+
 type Rend3DGL struct {
 	BoundVaoId     uint32
 	BoundMatId     uint32
 	BoundMeshVaoId uint32
+
+	viewport      renderer.Rect
+	viewportStack []renderer.Rect
+
+	scissor      scissorState
+	scissorStack []scissorState
+
+	// perObjectRing holds the per draw data of materials with MaterialSettings_HasPerObjectUbo. Set by EnablePerObjectUbo
+	perObjectRing      *buffers.UniformRingBuffer
+	perObjectBindPoint uint32
+	perObjectLayout    buffers.UniformBuffer
+	// perObjectStride is the size of PerObjectUboData rounded up to the ring's offset alignment, so every object's range can be bound
+	perObjectStride  uint32
+	perObjectData    renderer.PerObjectUboData
+	perObjectScratch []byte
+	perObjectRanges  []buffers.UniformRingRange
+
+	// ambientSampler is optional and fills the ambient light of per object ubo draws. Set by SetAmbientSampler
+	ambientSampler renderer.AmbientSampler
+
+	// isAlphaToCoverageOn is whether the renderer enabled alpha to coverage for the bound cutout material
+	isAlphaToCoverageOn bool
+
+	// shadowPassMode is set by SetShadowPass, and skips the objects the shadow pass doesn't draw
+	shadowPassMode renderer.ShadowPassMode
+
+	// cullingMask is set by SetCullingMask, and skips the submitted commands on layers it doesn't see
+	cullingMask camera.LayerMask
+
+	// viewPos is set by SetViewPos, and is what the transparent layer of submitted lists is sorted from
+	viewPos gglm.Vec3
+
+	// isDepthRangeRemapped is whether the depth range was set to something other than [0, 1] by SetDepthRange
+	isDepthRangeRemapped bool
+
+	// isCullingOff is whether the renderer disabled face culling for the bound two sided material
+	isCullingOff bool
+
+	// validatedLayouts has the result of checking each shader and vertex layout drawn together, in debug builds
+	validatedLayouts map[layoutValidationKey]error
+
+	// billboardVao has no buffers since billboard shaders make their corners from gl_VertexID, but GL needs a vao bound to draw.
+	// Created on the first DrawBillboard
+	billboardVao buffers.VertexArray
+
+	// instanceVbo has the transforms of the last DrawMeshInstanced, and is the instance buffer of every mesh vao drawn by it.
+	// Created on the first DrawMeshInstanced
+	instanceVbo     buffers.VertexBuffer
+	instanceScratch []float32
+
+	stats     renderer.RenderStats
+	lastStats renderer.RenderStats
+}
+
+// EnablePerObjectUbo makes the renderer write the matrices of materials with MaterialSettings_HasPerObjectUbo
+// into ring, binding each draw's range to bindPoint instead of setting uniforms per draw.
+//
+// Materials must bind their PerObject block (see renderer.PerObjectUboBlockName) to the same bind point.
+// The ring is not owned by the renderer, so the caller still calls its BeginFrame/EndFrame every frame.
+// Submit packs the data of all its draws into one upload, while DrawMesh uploads one object at a time
+func (r *Rend3DGL) EnablePerObjectUbo(ring *buffers.UniformRingBuffer, bindPoint uint32) {
+
+	r.perObjectRing = ring
+	r.perObjectBindPoint = bindPoint
+	r.perObjectLayout = buffers.NewUniformBufferLayoutFor[renderer.PerObjectUboData](buffers.BlockLayout_Std140)
+
+	alignment := ring.OffsetAlignment()
+	r.perObjectStride = (r.perObjectLayout.Size + alignment - 1) / alignment * alignment
+}
+
+// SetAmbientSampler makes materials with MaterialSettings_HasPerObjectUbo get the ambient light at their object's position
+// from sampler, like a lights.LightProbeGrid. A nil sampler makes objects use the global ambient color again
+func (r *Rend3DGL) SetAmbientSampler(sampler renderer.AmbientSampler) {
+	r.ambientSampler = sampler
 }
 
 func (r *Rend3DGL) DrawMesh(mesh *meshes.Mesh, modelMat *gglm.TrMat, mat *materials.Material) {
+	r.DrawMeshWithFlags(mesh, modelMat, mat, renderer.ObjectFlags_None)
+}
+
+func (r *Rend3DGL) DrawMeshWithFlags(mesh *meshes.Mesh, modelMat *gglm.TrMat, mat *materials.Material, flags renderer.ObjectFlags) {
+
+	if !r.shadowPassMode.Draws(flags) {
+		return
+	}
+
+	r.drawMeshLightmapped(mesh, modelMat, mat, &gglm.Vec4{}, flags)
+}
+
+func (r *Rend3DGL) DrawMeshLightmapped(mesh *meshes.Mesh, modelMat *gglm.TrMat, mat *materials.Material, lightmapScaleOffset *gglm.Vec4) {
+	r.drawMeshLightmapped(mesh, modelMat, mat, lightmapScaleOffset, renderer.ObjectFlags_None)
+}
+
+func (r *Rend3DGL) drawMeshLightmapped(mesh *meshes.Mesh, modelMat *gglm.TrMat, mat *materials.Material, lightmapScaleOffset *gglm.Vec4, flags renderer.ObjectFlags) {
+
+	if mat.Settings.Has(materials.MaterialSettings_HasPerObjectUbo) {
+
+		r.setPerObjectData(modelMat, mat, lightmapScaleOffset, flags, camera.LayerMask_Default)
+
+		r.perObjectRing.Bind()
+		objRange := r.perObjectRing.SetStruct(&r.perObjectLayout, &r.perObjectData)
+		r.drawMesh(mesh, modelMat, mat, &objRange)
+		return
+	}
+
+	r.drawMesh(mesh, modelMat, mat, nil)
+}
+
+// drawMesh draws the mesh, where objRange is the per object ubo range of the draw and is only used by
+// materials with MaterialSettings_HasPerObjectUbo
+func (r *Rend3DGL) drawMesh(mesh *meshes.Mesh, modelMat *gglm.TrMat, mat *materials.Material, objRange *buffers.UniformRingRange) {
+
+	r.bindMeshAndMat(mesh, mat)
+
+	if objRange != nil {
+		r.perObjectRing.BindRange(r.perObjectBindPoint, *objRange)
+	} else {
+
+		if mat.Settings.Has(materials.MaterialSettings_HasModelMtx) {
+			mat.SetUnifMat4("modelMat", &modelMat.Mat4)
+		}
+
+		if mat.Settings.Has(materials.MaterialSettings_HasNormalMtx) {
+			normalMat := modelMat.Clone().InvertAndTranspose().ToMat3()
+			mat.SetUnifMat3("normalMat", &normalMat)
+		}
+	}
+
+	for i := 0; i < len(mesh.SubMeshes); i++ {
+		gl.DrawElementsBaseVertexWithOffset(gl.TRIANGLES, mesh.SubMeshes[i].IndexCount, gl.UNSIGNED_INT, uintptr(mesh.SubMeshes[i].BaseIndex), mesh.SubMeshes[i].BaseVertex)
+		r.countDraw(mesh.SubMeshes[i].IndexCount/3, 1)
+	}
+}
+
+// bindMeshAndMat binds the vao of the mesh and the material. Position only materials use the depth vao of the mesh when
+// it has one (see materials.MaterialSettings_PositionOnly). Debug builds also check the material's shader can read the vertex layout of the mesh
+func (r *Rend3DGL) bindMeshAndMat(mesh *meshes.Mesh, mat *materials.Material) {
+
+	vao := meshVao(mesh, mat)
+	if vao.Id != r.BoundMeshVaoId {
+		vao.Bind()
+		r.BoundMeshVaoId = vao.Id
+	}
+
+	r.bindMat(mat)
+
+	if consts.Debug && vao.Layout != nil {
+		err := r.validateVertexLayout(vao.Layout, vao.InstanceLayout, mat)
+		assert.T(err == nil, "Failed to draw mesh '%s'. Err: %v", mesh.Name, err)
+	}
+}
+
+// meshVao returns the vao of the mesh the material draws, which is the depth vao for position only materials if the mesh has one
+func meshVao(mesh *meshes.Mesh, mat *materials.Material) *buffers.VertexArray {
+
+	if mesh.DepthVao.Id != 0 && mat.Settings.Has(materials.MaterialSettings_PositionOnly) {
+		return &mesh.DepthVao
+	}
 
-	if mesh.Vao.Id != r.BoundMeshVaoId {
-		mesh.Vao.Bind()
-		r.BoundMeshVaoId = mesh.Vao.Id
+	return &mesh.Vao
+}
+
+// validateVertexLayout returns an error if the shader of the material reads vertex inputs the layout and the optional instance layout
+// can't give it (see buffers.VertexLayout.ValidateWithInstances). Results are cached per shader and layouts
+func (r *Rend3DGL) validateVertexLayout(layout, instanceLayout *buffers.VertexLayout, mat *materials.Material) error {
+
+	key := layoutValidationKey{shaderProgId: mat.ShaderProg.Id, layout: layout, instanceLayout: instanceLayout}
+	if err, ok := r.validatedLayouts[key]; ok {
+		return err
 	}
 
-	if mat.Id != r.BoundMatId {
-		mat.Bind()
-		r.BoundMatId = mat.Id
+	err := layout.ValidateWithInstances(mat.ShaderProg.Id, instanceLayout)
+	if err != nil {
+		err = fmt.Errorf("material '%s' can't draw vertex layout '%s'. Err: %w", mat.Name, layout.Name, err)
+	}
+
+	r.validatedLayouts[key] = err
+	return err
+}
+
+// bindMat binds the material unless it is already bound. Cutout materials get alpha to coverage
+// when the bound framebuffer is multisampled (see materials.MaterialSettings_AlphaCutout), and two sided materials
+// are drawn without face culling
+func (r *Rend3DGL) bindMat(mat *materials.Material) {
+
+	if mat.Id == r.BoundMatId {
+		return
+	}
+
+	mat.Bind()
+	r.BoundMatId = mat.Id
+
+	wantsAlphaToCoverage := false
+	if mat.Settings.Has(materials.MaterialSettings_AlphaCutout) && mat.AlphaCutoff > 0 {
+		var sampleBuffers int32
+		gl.GetIntegerv(gl.SAMPLE_BUFFERS, &sampleBuffers)
+		wantsAlphaToCoverage = sampleBuffers > 0 && gl.IsEnabled(gl.MULTISAMPLE)
 	}
 
-	if mat.Settings.Has(materials.MaterialSettings_HasModelMtx) {
-		mat.SetUnifMat4("modelMat", &modelMat.Mat4)
+	r.setAlphaToCoverage(wantsAlphaToCoverage)
+	r.setCullingOff(mat.Settings.Has(materials.MaterialSettings_TwoSided))
+}
+
+// setCullingOff disables face culling, or enables it again if the renderer disabled it. Culling is expected
+// to be enabled outside the renderer (see engine.initOpenGL)
+func (r *Rend3DGL) setCullingOff(isOff bool) {
+
+	if isOff == r.isCullingOff {
+		return
+	}
+
+	if isOff {
+		gl.Disable(gl.CULL_FACE)
+	} else {
+		gl.Enable(gl.CULL_FACE)
+	}
+	r.isCullingOff = isOff
+}
+
+func (r *Rend3DGL) setAlphaToCoverage(isEnabled bool) {
+
+	if isEnabled == r.isAlphaToCoverageOn {
+		return
+	}
+
+	if isEnabled {
+		gl.Enable(gl.SAMPLE_ALPHA_TO_COVERAGE)
+	} else {
+		gl.Disable(gl.SAMPLE_ALPHA_TO_COVERAGE)
+	}
+	r.isAlphaToCoverageOn = isEnabled
+}
+
+func (r *Rend3DGL) setPerObjectData(modelMat *gglm.TrMat, mat *materials.Material, lightmapScaleOffset *gglm.Vec4, flags renderer.ObjectFlags, layers camera.LayerMask) {
+
+	if r.perObjectRing == nil {
+		logging.ErrLog.Panicf("material '%s' has MaterialSettings_HasPerObjectUbo but EnablePerObjectUbo wasn't called on the renderer\n", mat.Name)
 	}
 
+	r.perObjectData.ModelMat = modelMat.Mat4
 	if mat.Settings.Has(materials.MaterialSettings_HasNormalMtx) {
-		normalMat := modelMat.Clone().InvertAndTranspose().ToMat3()
-		mat.SetUnifMat3("normalMat", &normalMat)
+		r.perObjectData.NormalMat = modelMat.Clone().InvertAndTranspose().ToMat3()
+	} else {
+		r.perObjectData.NormalMat = gglm.Mat3{}
+	}
+
+	r.perObjectData.LightmapScaleOffset = *lightmapScaleOffset
+
+	r.perObjectData.ReceiveShadows = 0
+	if flags.ReceiveShadows() {
+		r.perObjectData.ReceiveShadows = 1
 	}
 
+	r.perObjectData.Layers = uint32(layers.AsObjectLayers())
+
+	r.perObjectData.HasAmbientSH = 0
+	if r.ambientSampler != nil {
+
+		objPos := gglm.NewVec3(modelMat.Data[3][0], modelMat.Data[3][1], modelMat.Data[3][2])
+		if r.ambientSampler.SampleAmbient(&objPos, &r.perObjectData.AmbientSH) {
+			r.perObjectData.HasAmbientSH = 1
+		}
+	}
+}
+
+// DrawMeshInstanced uploads the transforms into the renderer's instance buffer, which is set as the instance buffer of the mesh's vao
+// the first time the vao is drawn instanced, then draws all instances at once.
+//
+// The per object ubo and the modelMat and normalMat uniforms aren't set, so instanced shaders get the normal matrix from the instance's model matrix
+func (r *Rend3DGL) DrawMeshInstanced(mesh *meshes.Mesh, transforms []gglm.TrMat, mat *materials.Material) {
+
+	if len(transforms) == 0 {
+		return
+	}
+
+	if r.instanceVbo.Id == 0 {
+		r.instanceVbo = buffers.NewVertexBuffer(meshes.MeshInstanceLayout.StreamElements(0)...)
+		r.instanceVbo.Usage = buffers.BufUsage_Stream_Draw
+	}
+
+	r.instanceScratch = r.instanceScratch[:0]
+	for i := 0; i < len(transforms); i++ {
+		d := &transforms[i].Data
+		r.instanceScratch = append(r.instanceScratch, d[0][:]...)
+		r.instanceScratch = append(r.instanceScratch, d[1][:]...)
+		r.instanceScratch = append(r.instanceScratch, d[2][:]...)
+		r.instanceScratch = append(r.instanceScratch, d[3][:]...)
+	}
+
+	// Orphaning gives each draw fresh memory, so drawing other meshes instanced later in the frame doesn't wait on this draw
+	r.instanceVbo.OrphanAndSet(r.instanceScratch)
+
+	if vao := meshVao(mesh, mat); vao.InstanceVboId != r.instanceVbo.Id {
+		vao.SetInstanceBufferWithLayout(&r.instanceVbo, &meshes.MeshInstanceLayout)
+		r.BoundMeshVaoId = vao.Id
+	}
+
+	r.DrawMeshInstancedCount(mesh, mat, int32(len(transforms)))
+}
+
+func (r *Rend3DGL) DrawMeshInstancedCount(mesh *meshes.Mesh, mat *materials.Material, instanceCount int32) {
+
+	if instanceCount <= 0 {
+		return
+	}
+
+	r.bindMeshAndMat(mesh, mat)
+
 	for i := 0; i < len(mesh.SubMeshes); i++ {
-		gl.DrawElementsBaseVertexWithOffset(gl.TRIANGLES, mesh.SubMeshes[i].IndexCount, gl.UNSIGNED_INT, uintptr(mesh.SubMeshes[i].BaseIndex), mesh.SubMeshes[i].BaseVertex)
+		gl.DrawElementsInstancedBaseVertex(gl.TRIANGLES, mesh.SubMeshes[i].IndexCount, gl.UNSIGNED_INT, gl.PtrOffset(int(mesh.SubMeshes[i].BaseIndex)), instanceCount, mesh.SubMeshes[i].BaseVertex)
+		r.countDraw(mesh.SubMeshes[i].IndexCount/3, instanceCount)
+	}
+}
+
+// DrawMeshIndirect draws submesh i with command i of the indirect buffer. Instance counts are often written by the GPU
+// (e.g. by culling), so the stats count one instance per command
+func (r *Rend3DGL) DrawMeshIndirect(mesh *meshes.Mesh, mat *materials.Material, indirect *buffers.IndirectBuffer) {
+
+	r.bindMeshAndMat(mesh, mat)
+
+	indirect.Bind()
+	cmdCount := min(len(mesh.SubMeshes), int(indirect.Count))
+	for i := 0; i < cmdCount; i++ {
+		gl.DrawElementsIndirect(gl.TRIANGLES, gl.UNSIGNED_INT, gl.PtrOffset(i*int(buffers.DrawElementsIndirectCommandSize)))
+		r.countDraw(mesh.SubMeshes[i].IndexCount/3, 1)
 	}
+	indirect.UnBind()
+}
+
+// DrawIndirectBatches uploads the commands of the builder to the indirect buffer, then draws each batch with one multi draw.
+// The slot data (e.g. Slots uploaded to a storage buffer) must be bound by the caller
+func (r *Rend3DGL) DrawIndirectBatches(b *renderer.IndirectBuilder, indirect *buffers.IndirectBuffer) {
+
+	if len(b.Commands) == 0 {
+		return
+	}
+
+	indirect.SetCommands(b.Commands)
+	for i := 0; i < len(b.Batches); i++ {
+
+		batch := &b.Batches[i]
+		r.bindMeshAndMat(batch.Mesh, batch.Mat)
+
+		offset := int(batch.FirstCommand * buffers.DrawElementsIndirectCommandSize)
+		gl.MultiDrawElementsIndirect(gl.TRIANGLES, gl.UNSIGNED_INT, gl.PtrOffset(offset), int32(batch.CommandCount), 0)
+
+		r.stats.DrawCalls++
+		for j := batch.FirstCommand; j < batch.FirstCommand+batch.CommandCount; j++ {
+			r.stats.Triangles += uint64(b.Commands[j].Count / 3)
+		}
+	}
+	indirect.UnBind()
 }
 
 func (r *Rend3DGL) DrawVertexArray(mat *materials.Material, vao *buffers.VertexArray, firstElement int32, elementCount int32) {
@@ -50,37 +394,327 @@ func (r *Rend3DGL) DrawVertexArray(mat *materials.Material, vao *buffers.VertexA
 		r.BoundVaoId = vao.Id
 	}
 
-	if mat.Id != r.BoundMatId {
-		mat.Bind()
-		r.BoundMatId = mat.Id
-	}
+	r.bindMat(mat)
 
 	gl.DrawArrays(gl.TRIANGLES, firstElement, elementCount)
+	r.countDraw(elementCount/3, 1)
 }
 
-func (r *Rend3DGL) DrawCubemap(mesh *meshes.Mesh, mat *materials.Material) {
+// DrawBillboard sets the billboard's uniforms on the material and draws its quad. Depth and blend state are left to the caller,
+// so transparent billboards like particles should be drawn after opaque objects with depth writes off
+func (r *Rend3DGL) DrawBillboard(b *renderer.Billboard, mat *materials.Material) {
 
-	if mesh.Vao.Id != r.BoundMeshVaoId {
-		mesh.Vao.Bind()
-		r.BoundMeshVaoId = mesh.Vao.Id
+	if r.billboardVao.Id == 0 {
+		r.billboardVao = buffers.NewVertexArray()
 	}
 
-	if mat.Id != r.BoundMatId {
-		mat.Bind()
-		r.BoundMatId = mat.Id
+	if r.billboardVao.Id != r.BoundVaoId {
+		r.billboardVao.Bind()
+		r.BoundVaoId = r.billboardVao.Id
 	}
 
+	r.bindMat(mat)
+
+	up := b.UpAxis()
+	uvRect := gglm.NewVec4(b.UVMin.X(), b.UVMin.Y(), b.UVMax.X(), b.UVMax.Y())
+
+	mat.SetUnifVec3("billboardPos", &b.Pos)
+	mat.SetUnifVec2("billboardSize", &b.Size)
+	mat.SetUnifFloat32("billboardRotation", b.Rotation)
+	mat.SetUnifVec3("billboardUp", &up)
+	mat.SetUnifVec4("billboardUVRect", &uvRect)
+	mat.SetUnifColor("billboardColor", &b.Color)
+
+	gl.DrawArrays(gl.TRIANGLES, 0, 6)
+	r.countDraw(2, 1)
+}
+
+func (r *Rend3DGL) DrawCubemap(mesh *meshes.Mesh, mat *materials.Material) {
+
+	r.bindMeshAndMat(mesh, mat)
+
 	for i := 0; i < len(mesh.SubMeshes); i++ {
 		gl.DrawElementsBaseVertexWithOffset(gl.TRIANGLES, mesh.SubMeshes[i].IndexCount, gl.UNSIGNED_INT, uintptr(mesh.SubMeshes[i].BaseIndex), mesh.SubMeshes[i].BaseVertex)
+		r.countDraw(mesh.SubMeshes[i].IndexCount/3, 1)
+	}
+}
+
+// Submit validates, sorts and draws a command list. Invalid commands are logged and removed from the list.
+// The list is sorted in place, and is otherwise left as is so the caller can reset and reuse it.
+//
+// The per object data of all the list's draws is uploaded at once before drawing, so big scenes should prefer Submit over DrawMesh
+func (r *Rend3DGL) Submit(cl *renderer.CommandList) {
+
+	validCount := 0
+	for i := 0; i < len(cl.Commands); i++ {
+
+		if err := cl.Commands[i].Validate(); err != nil {
+			logging.ErrLog.Printf("Skipping invalid render command at index %d. Err: %v\n", i, err)
+			continue
+		}
+
+		cl.Commands[validCount] = cl.Commands[i]
+		validCount++
+	}
+	clear(cl.Commands[validCount:])
+	cl.Commands = cl.Commands[:validCount]
+
+	cl.DepthSortLayer(renderer.CommandLayer_Transparent, &r.viewPos)
+	cl.Sort()
+	r.uploadPerObjectData(cl)
+
+	for i := 0; i < len(cl.Commands); i++ {
+
+		c := &cl.Commands[i]
+		if !r.draws(c) {
+			continue
+		}
+
+		switch c.Type {
+		case renderer.CommandType_DrawMesh:
+
+			if r.perObjectRanges[i].Size > 0 {
+				r.drawMesh(c.Mesh, &c.ModelMat, c.Mat, &r.perObjectRanges[i])
+			} else {
+				r.drawMesh(c.Mesh, &c.ModelMat, c.Mat, nil)
+			}
+		case renderer.CommandType_DrawVertexArray:
+			r.DrawVertexArray(c.Mat, c.Vao, c.FirstElement, c.ElementCount)
+		case renderer.CommandType_DrawCubemap:
+			r.DrawCubemap(c.Mesh, c.Mat)
+		}
+	}
+}
+
+// draws returns true if the command isn't skipped by the shadow pass or the culling mask
+func (r *Rend3DGL) draws(c *renderer.Command) bool {
+
+	if c.Type == renderer.CommandType_DrawMesh && !r.shadowPassMode.Draws(c.Flags) {
+		return false
+	}
+
+	return r.cullingMask.Sees(c.RenderLayers)
+}
+
+// uploadPerObjectData writes the per object data of all mesh draws whose material has MaterialSettings_HasPerObjectUbo
+// with a single upload, and fills perObjectRanges with the range of each command (zero sized for commands without one)
+func (r *Rend3DGL) uploadPerObjectData(cl *renderer.CommandList) {
+
+	if cap(r.perObjectRanges) < len(cl.Commands) {
+		r.perObjectRanges = make([]buffers.UniformRingRange, len(cl.Commands))
+	}
+	r.perObjectRanges = r.perObjectRanges[:len(cl.Commands)]
+	clear(r.perObjectRanges)
+
+	objCount := uint32(0)
+	for i := 0; i < len(cl.Commands); i++ {
+
+		c := &cl.Commands[i]
+		if c.Type != renderer.CommandType_DrawMesh || !c.Mat.Settings.Has(materials.MaterialSettings_HasPerObjectUbo) {
+			continue
+		}
+
+		if !r.draws(c) {
+			continue
+		}
+
+		r.setPerObjectData(&c.ModelMat, c.Mat, &c.LightmapScaleOffset, c.Flags, c.RenderLayers)
+
+		if uint32(len(r.perObjectScratch)) < (objCount+1)*r.perObjectStride {
+			r.perObjectScratch = append(r.perObjectScratch, make([]byte, r.perObjectStride)...)
+		}
+
+		objOffset := objCount * r.perObjectStride
+		r.perObjectLayout.WriteStruct(r.perObjectScratch[objOffset:objOffset+r.perObjectLayout.Size], &r.perObjectData)
+
+		// Offset is relative to the upload for now, and is made absolute after the upload below
+		r.perObjectRanges[i] = buffers.UniformRingRange{Offset: objOffset, Size: r.perObjectLayout.Size}
+		objCount++
+	}
+
+	if objCount == 0 {
+		return
+	}
+
+	r.perObjectRing.Bind()
+	uploadRange := r.perObjectRing.Write(r.perObjectScratch[:objCount*r.perObjectStride])
+
+	for i := 0; i < len(r.perObjectRanges); i++ {
+		if r.perObjectRanges[i].Size > 0 {
+			r.perObjectRanges[i].BufferId = uploadRange.BufferId
+			r.perObjectRanges[i].Offset += uploadRange.Offset
+		}
+	}
+}
+
+func (r *Rend3DGL) SetShadowPass(mode renderer.ShadowPassMode) {
+	r.shadowPassMode = mode
+}
+
+func (r *Rend3DGL) SetCullingMask(mask camera.LayerMask) {
+	r.cullingMask = mask
+}
+
+func (r *Rend3DGL) SetViewPos(pos *gglm.Vec3) {
+	r.viewPos = *pos
+}
+
+func (r *Rend3DGL) SetDepthRange(near, far float32) {
+	r.isDepthRangeRemapped = near != 0 || far != 1
+	gl.DepthRange(float64(near), float64(far))
+}
+
+// ClearDepth clears the depth buffer of the bound framebuffer. Depth writes must be on, or GL leaves the depth unchanged
+func (r *Rend3DGL) ClearDepth() {
+	gl.Clear(gl.DEPTH_BUFFER_BIT)
+}
+
+// SetViewport sets the area of the framebuffer being drawn to.
+//
+// The GL call is always made even if the viewport didn't change, so that code
+// which sets the viewport directly (e.g. imgui) can't leave the renderer out of sync
+func (r *Rend3DGL) SetViewport(x, y, width, height int32) {
+	r.viewport = renderer.Rect{X: x, Y: y, Width: width, Height: height}
+	gl.Viewport(x, y, width, height)
+}
+
+// Viewport returns the last viewport set through the renderer
+func (r *Rend3DGL) Viewport() renderer.Rect {
+	return r.viewport
+}
+
+// PushViewport saves the current viewport then sets a new one. Used when drawing into
+// a framebuffer of a different size (e.g. shadow maps), followed by PopViewport when done
+func (r *Rend3DGL) PushViewport(x, y, width, height int32) {
+	r.viewportStack = append(r.viewportStack, r.viewport)
+	r.SetViewport(x, y, width, height)
+}
+
+// PopViewport restores the viewport saved by the last PushViewport
+func (r *Rend3DGL) PopViewport() {
+
+	if len(r.viewportStack) == 0 {
+		logging.ErrLog.Panicln("Rend3DGL.PopViewport called without a matching PushViewport")
+	}
+
+	vp := r.viewportStack[len(r.viewportStack)-1]
+	r.viewportStack = r.viewportStack[:len(r.viewportStack)-1]
+	r.SetViewport(vp.X, vp.Y, vp.Width, vp.Height)
+}
+
+// SetScissor enables the scissor test and limits drawing to the passed rect
+func (r *Rend3DGL) SetScissor(x, y, width, height int32) {
+	r.applyScissor(scissorState{
+		Rect:      renderer.Rect{X: x, Y: y, Width: width, Height: height},
+		IsEnabled: true,
+	})
+}
+
+// DisableScissor disables the scissor test. The last scissor rect is kept
+func (r *Rend3DGL) DisableScissor() {
+	r.applyScissor(scissorState{
+		Rect:      r.scissor.Rect,
+		IsEnabled: false,
+	})
+}
+
+// Scissor returns the last scissor rect set through the renderer and whether the scissor test is enabled
+func (r *Rend3DGL) Scissor() (rect renderer.Rect, isEnabled bool) {
+	return r.scissor.Rect, r.scissor.IsEnabled
+}
+
+// PushScissor saves the current scissor state then enables the scissor test with the passed rect
+func (r *Rend3DGL) PushScissor(x, y, width, height int32) {
+	r.scissorStack = append(r.scissorStack, r.scissor)
+	r.SetScissor(x, y, width, height)
+}
+
+// PopScissor restores the scissor state saved by the last PushScissor, including whether the scissor test was enabled
+func (r *Rend3DGL) PopScissor() {
+
+	if len(r.scissorStack) == 0 {
+		logging.ErrLog.Panicln("Rend3DGL.PopScissor called without a matching PushScissor")
+	}
+
+	s := r.scissorStack[len(r.scissorStack)-1]
+	r.scissorStack = r.scissorStack[:len(r.scissorStack)-1]
+	r.applyScissor(s)
+}
+
+func (r *Rend3DGL) applyScissor(s scissorState) {
+
+	r.scissor = s
+	if !s.IsEnabled {
+		gl.Disable(gl.SCISSOR_TEST)
+		return
 	}
+
+	gl.Enable(gl.SCISSOR_TEST)
+	gl.Scissor(s.Rect.X, s.Rect.Y, s.Rect.Width, s.Rect.Height)
+}
+
+func (r *Rend3DGL) countDraw(triangles, instanceCount int32) {
+	r.stats.DrawCalls++
+	r.stats.Triangles += uint64(triangles) * uint64(instanceCount)
+}
+
+func (r3d *Rend3DGL) LastFrameStats() renderer.RenderStats {
+	return r3d.lastStats
 }
 
 func (r3d *Rend3DGL) FrameEnd() {
+	r3d.lastStats = r3d.stats
+	r3d.stats = renderer.RenderStats{}
+
 	r3d.BoundVaoId = 0
 	r3d.BoundMatId = 0
 	r3d.BoundMeshVaoId = 0
+
+	// Drawing outside the renderer (e.g. UI) must not get the state of the last material
+	r3d.setAlphaToCoverage(false)
+	r3d.setCullingOff(false)
+
+	if r3d.isDepthRangeRemapped {
+		r3d.SetDepthRange(0, 1)
+	}
+
+	if len(r3d.viewportStack) > 0 || len(r3d.scissorStack) > 0 {
+		logging.ErrLog.Printf("Rend3DGL frame ended with unbalanced state pushes. Viewport stack=%d, Scissor stack=%d\n", len(r3d.viewportStack), len(r3d.scissorStack))
+		r3d.viewportStack = r3d.viewportStack[:0]
+		r3d.scissorStack = r3d.scissorStack[:0]
+	}
+}
+
+// RecreateGpuObjects drops the objects of the lost context and resets the GL state the renderer tracks.
+// The billboard vao and instance buffer are created again on their next draw, while the ring given to
+// EnablePerObjectUbo is recreated here, so its owner must not recreate it too
+func (r3d *Rend3DGL) RecreateGpuObjects() {
+
+	r3d.billboardVao = buffers.VertexArray{}
+	r3d.instanceVbo = buffers.VertexBuffer{}
+	if r3d.perObjectRing != nil {
+		r3d.perObjectRing.Recreate()
+	}
+
+	r3d.BoundVaoId = 0
+	r3d.BoundMatId = 0
+	r3d.BoundMeshVaoId = 0
+
+	// The new context starts with default state, and reused ids could map to different shaders
+	r3d.isAlphaToCoverageOn = false
+	r3d.isCullingOff = false
+	r3d.isDepthRangeRemapped = false
+	r3d.scissor = scissorState{}
+	clear(r3d.validatedLayouts)
+}
+
+func (r3d *Rend3DGL) Delete() {
+	r3d.billboardVao.Delete()
+	r3d.instanceVbo.Delete()
 }
 
 func NewRend3DGL() *Rend3DGL {
-	return &Rend3DGL{}
+	return &Rend3DGL{
+		validatedLayouts: make(map[layoutValidationKey]error),
+	}
 }
@@ -3,13 +3,96 @@ package renderer
 import (
 	"github.com/bloeys/gglm/gglm"
 	"github.com/bloeys/nmage/buffers"
+	"github.com/bloeys/nmage/camera"
 	"github.com/bloeys/nmage/materials"
 	"github.com/bloeys/nmage/meshes"
 )
 
+// Rect is a rectangle in framebuffer pixels, where (X, Y) is the bottom left corner like in OpenGL
+type Rect struct {
+	X      int32
+	Y      int32
+	Width  int32
+	Height int32
+}
+
+// RenderStats counts the work done by a renderer during a frame
+type RenderStats struct {
+	DrawCalls uint32
+	Triangles uint64
+}
+
 type Render interface {
 	DrawMesh(mesh *meshes.Mesh, trMat *gglm.TrMat, mat *materials.Material)
+
+	// DrawMeshWithFlags is DrawMesh for objects that opt out of casting or receiving shadows (see ObjectFlags)
+	DrawMeshWithFlags(mesh *meshes.Mesh, trMat *gglm.TrMat, mat *materials.Material, flags ObjectFlags)
+
+	// DrawMeshLightmapped is DrawMesh for static objects with baked lighting, where lightmapScaleOffset is the object's
+	// region of the lightmap atlas (see assets.LightmapAtlas). Only materials with MaterialSettings_HasPerObjectUbo get the region
+	DrawMeshLightmapped(mesh *meshes.Mesh, trMat *gglm.TrMat, mat *materials.Material, lightmapScaleOffset *gglm.Vec4)
 	DrawVertexArray(mat *materials.Material, vao *buffers.VertexArray, firstElement int32, count int32)
 	DrawCubemap(mesh *meshes.Mesh, mat *materials.Material)
+
+	// DrawMeshInstanced draws one instance of the mesh per transform with a single draw call. The transforms are uploaded as per instance
+	// vertex data in meshes.MeshInstanceLayout, so the material's shader reads its model matrix from there instead of the modelMat uniform
+	DrawMeshInstanced(mesh *meshes.Mesh, transforms []gglm.TrMat, mat *materials.Material)
+
+	// DrawMeshInstancedCount draws instanceCount instances of the mesh. Per instance data (e.g. a buffers.InstanceDataBuffer)
+	// must be bound by the caller, and model matrices come from it rather than from the renderer
+	DrawMeshInstancedCount(mesh *meshes.Mesh, mat *materials.Material, instanceCount int32)
+
+	// DrawMeshIndirect is DrawMeshInstancedCount with the draw parameters read by the GPU from the indirect buffer,
+	// which has one command per submesh. Like instanced draws, per instance data must be bound by the caller
+	DrawMeshIndirect(mesh *meshes.Mesh, mat *materials.Material, indirect *buffers.IndirectBuffer)
+
+	// DrawIndirectBatches draws the batches of an IndirectBuilder with one multi draw indirect each, using indirect to hold the commands
+	DrawIndirectBatches(b *IndirectBuilder, indirect *buffers.IndirectBuffer)
+
+	// DrawBillboard draws a quad facing the camera (see Billboard)
+	DrawBillboard(b *Billboard, mat *materials.Material)
+
+	// Submit validates, sorts and draws the commands of the list. Must be called on the main thread
+	Submit(cl *CommandList)
+
+	// SetShadowPass marks the draws until the next SetShadowPass(ShadowPassMode_None) as drawing shadow maps,
+	// which skips the objects the mode doesn't draw (see ShadowPassMode.Draws)
+	SetShadowPass(mode ShadowPassMode)
+
+	// SetCullingMask makes Submit skip the commands whose RenderLayers the mask doesn't see (see camera.LayerMask.Sees),
+	// which is usually the culling mask of the camera being drawn. Draws made directly (e.g. DrawMesh) are on
+	// camera.LayerMask_Default and are never skipped, since the caller chose to draw them
+	SetCullingMask(mask camera.LayerMask)
+
+	// SetViewPos sets the position of the camera being drawn, which Submit sorts the commands on CommandLayer_Transparent
+	// back to front from (see CommandList.DepthSortLayer)
+	SetViewPos(pos *gglm.Vec3)
+
+	// SetDepthRange maps the depth of the following draws from [0, 1] into [near, far] of the depth buffer,
+	// e.g. to keep a viewmodel in front of the world (see Viewmodel). The default range is [0, 1]
+	SetDepthRange(near, far float32)
+
+	// ClearDepth clears the depth buffer of the bound framebuffer
+	ClearDepth()
+
+	SetViewport(x, y, width, height int32)
+	PushViewport(x, y, width, height int32)
+	PopViewport()
+
+	SetScissor(x, y, width, height int32)
+	DisableScissor()
+	PushScissor(x, y, width, height int32)
+	PopScissor()
+
+	// LastFrameStats returns the stats of the last frame that ended
+	LastFrameStats() RenderStats
+
 	FrameEnd()
+
+	// RecreateGpuObjects recreates the GL objects the renderer created for itself after the GL context was lost.
+	// Called by the engine with the new context current
+	RecreateGpuObjects()
+
+	// Delete frees the GL objects the renderer created for itself
+	Delete()
 }
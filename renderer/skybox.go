@@ -0,0 +1,34 @@
+package renderer
+
+import (
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/color"
+	"github.com/bloeys/nmage/materials"
+)
+
+// SkyboxSettings art direct the environment of a skybox at runtime. They are applied by the skybox shader (see res/shaders/skybox.glsl),
+// so light probes capturing the sky get them too, but probes must be captured again for changes to reach the ambient light
+type SkyboxSettings struct {
+	// Intensity multiplies the colors of the sky. Values above one make an HDR sky bright enough to light the scene and bloom
+	Intensity float32 `editor:"min=0,max=100,speed=0.01"`
+
+	// Yaw turns the sky around the world up axis in degrees, e.g. to line the sun in the sky up with the directional light
+	Yaw float32 `editor:"min=-180,max=180,speed=0.5"`
+
+	// Tint multiplies the colors of the sky, on top of the tint of the day-night cycle
+	Tint color.Color `editor:"rgb"`
+}
+
+// Apply sets the 'skyboxIntensity', 'skyboxYaw' and 'skyboxTint' uniforms of a skybox material
+func (s *SkyboxSettings) Apply(mat *materials.Material) {
+	mat.SetUnifFloat32("skyboxIntensity", s.Intensity)
+	mat.SetUnifFloat32("skyboxYaw", s.Yaw*gglm.Deg2Rad)
+	mat.SetUnifColorRGB("skyboxTint", &s.Tint)
+}
+
+func NewSkyboxSettings() SkyboxSettings {
+	return SkyboxSettings{
+		Intensity: 1,
+		Tint:      color.NewLinear(1, 1, 1),
+	}
+}
@@ -0,0 +1,107 @@
+package renderer
+
+import (
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/camera"
+)
+
+// ViewmodelDepthMode is how a Viewmodel is kept in front of the world
+type ViewmodelDepthMode uint8
+
+const (
+	// ViewmodelDepthMode_DepthRange squeezes the depth of the viewmodel into the front of the depth buffer (see Viewmodel.DepthRangeFar).
+	// The depth of the world is kept, so passes after the viewmodel (e.g. particles and flares) are still hidden by walls
+	ViewmodelDepthMode_DepthRange ViewmodelDepthMode = iota
+
+	// ViewmodelDepthMode_ClearDepth clears the depth buffer before drawing the viewmodel, which keeps its full depth precision
+	// but loses the depth of the world, so passes that test against the world must be drawn before the viewmodel
+	ViewmodelDepthMode_ClearDepth
+)
+
+func (m ViewmodelDepthMode) String() string {
+	switch m {
+	case ViewmodelDepthMode_DepthRange:
+		return "DepthRange"
+	case ViewmodelDepthMode_ClearDepth:
+		return "ClearDepth"
+	default:
+		return "Unknown"
+	}
+}
+
+// Viewmodel draws first person objects like arms and weapons in their own pass after the opaque world, with a field of view
+// separate from the camera's, and a depth that keeps them from clipping into walls the camera is pressed against.
+//
+// A pass is drawn between Begin and End, where the viewmodel's projection (see ProjViewMat) must replace the camera's
+// (e.g. in the global matrices ubo) and be restored after. Viewmodel objects are usually on their own render layer, which the
+// camera's culling mask leaves out so they aren't drawn by the world pass too. They shouldn't cast shadows, since they are
+// drawn at the camera rather than where they appear to be
+type Viewmodel struct {
+	// Fov is the vertical field of view in radians, so a wide world FOV doesn't stretch the weapon
+	Fov float32
+
+	// NearClip and FarClip only need to cover the viewmodel, so a tiny near clip doesn't cost the world any depth precision
+	NearClip float32
+	FarClip  float32
+
+	DepthMode ViewmodelDepthMode
+
+	// DepthRangeFar is the end of the depth range the viewmodel is squeezed into by ViewmodelDepthMode_DepthRange. Only world surfaces
+	// closer than the depth it maps to are drawn over the viewmodel, which is about the camera's near clip for small values
+	DepthRangeFar float32
+
+	// Layers is the culling mask of the pass, so command lists of the whole scene submitted between Begin and End only draw the viewmodel
+	Layers camera.LayerMask
+}
+
+// ProjMat returns the projection of the viewmodel for a camera with aspectRatio
+func (v *Viewmodel) ProjMat(aspectRatio float32) gglm.Mat4 {
+	return gglm.Perspective(v.Fov, aspectRatio, v.NearClip, v.FarClip)
+}
+
+// ProjViewMat returns the view of cam with the projection of the viewmodel
+func (v *Viewmodel) ProjViewMat(cam *camera.Camera) gglm.Mat4 {
+	projMat := v.ProjMat(cam.AspectRatio)
+	return gglm.MulMat4(&projMat, &cam.ViewMat)
+}
+
+// Begin starts the viewmodel pass, which must be drawn after the opaque world and skybox
+func (v *Viewmodel) Begin(rend Render) {
+
+	switch v.DepthMode {
+	case ViewmodelDepthMode_DepthRange:
+		rend.SetDepthRange(0, v.DepthRangeFar)
+	case ViewmodelDepthMode_ClearDepth:
+		rend.ClearDepth()
+	}
+
+	rend.SetCullingMask(v.Layers)
+}
+
+// End finishes the viewmodel pass started by Begin, and restores the culling mask of cam
+func (v *Viewmodel) End(rend Render, cam *camera.Camera) {
+
+	if v.DepthMode == ViewmodelDepthMode_DepthRange {
+		rend.SetDepthRange(0, 1)
+	}
+
+	rend.SetCullingMask(cam.CullingMask)
+}
+
+// ViewmodelWorldMat returns the world matrix of a viewmodel object whose transform is relative to cam,
+// where x is right, y is up and -z is forward like in view space, so the object follows the camera
+func ViewmodelWorldMat(cam *camera.Camera, local *gglm.TrMat) gglm.TrMat {
+	camToWorld := cam.ViewMat.Clone().Invert()
+	return gglm.TrMat{Mat4: gglm.MulMat4(camToWorld, &local.Mat4)}
+}
+
+func NewViewmodel(fovRadians float32, layers camera.LayerMask) Viewmodel {
+	return Viewmodel{
+		Fov:           fovRadians,
+		NearClip:      0.01,
+		FarClip:       10,
+		DepthMode:     ViewmodelDepthMode_DepthRange,
+		DepthRangeFar: 0.05,
+		Layers:        layers,
+	}
+}
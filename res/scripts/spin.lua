@@ -0,0 +1,24 @@
+-- Example script that spins the entity and moves it with the arrow keys.
+-- Use with: scripting.NewScriptComp("./res/scripts/spin.lua", &someTrMat)
+
+local spin_speed = 1.5
+local move_speed = 4
+
+function init()
+    print("spin.lua loaded")
+end
+
+function update(dt)
+
+    transform.rotate(spin_speed * dt, 0, 1, 0)
+
+    local x, y, z = transform.get_pos()
+    if input.key_down("Left") then
+        x = x - move_speed * dt
+    end
+    if input.key_down("Right") then
+        x = x + move_speed * dt
+    end
+
+    transform.set_pos(x, y, z)
+end
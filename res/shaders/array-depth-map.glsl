@@ -2,11 +2,15 @@
 #version 410
 
 layout(location=0) in vec3 vertPosIn;
+layout(location=3) in vec2 vertUV0In;
 
 uniform mat4 modelMat;
 
+out vec2 geomUV0;
+
 void main()
 {
+    geomUV0 = vertUV0In;
     gl_Position = modelMat * vec4(vertPosIn, 1);
 }
 
@@ -23,7 +27,10 @@ layout (triangle_strip, max_vertices=12) out;
 // This is the same number as max spot lights or whatever else is being rendered
 uniform mat4 projViewMats[NUM_PROJ_VIEW_MATS];
 
+in vec2 geomUV0[];
+
 out vec4 FragPos;
+out vec2 vertUV0;
 
 void main()
 {
@@ -35,6 +42,7 @@ void main()
         for(int i = 0; i < 3; i++)
         {
             FragPos = gl_in[i].gl_Position;
+            vertUV0 = geomUV0[i];
             gl_Position = projViewMat * FragPos;
             EmitVertex();
         }
@@ -46,9 +54,21 @@ void main()
 #version 410
 
 in vec4 FragPos;
+in vec2 vertUV0;
+
+struct Material {
+    sampler2D diffuse;
+};
+uniform Material material;
+
+// See materials.NewCutoutDepthMaterial. Zero means nothing is discarded
+uniform float alphaCutoff;
 
 void main()
 {
+    if (alphaCutoff > 0 && texture(material.diffuse, vertUV0).a < alphaCutoff)
+        discard;
+
     // This implicitly writes to the depth buffer with no color operations
     // Equivalent: gl_FragDepth = gl_FragCoord.z;
 }
@@ -0,0 +1,78 @@
+//shader:vertex
+#version 410
+
+// Billboards don't have vertex buffers, so the corners of their two triangles come from gl_VertexID
+const vec2 corners[6] = vec2[6](
+    vec2(-0.5, -0.5), vec2(0.5, -0.5), vec2(0.5, 0.5),
+    vec2(-0.5, -0.5), vec2(0.5, 0.5), vec2(-0.5, 0.5)
+);
+
+uniform mat4 viewMat;
+uniform mat4 projMat;
+
+uniform vec3 billboardPos;
+uniform vec2 billboardSize;
+uniform float billboardRotation;
+
+// billboardUp is the axis cylindrical billboards turn around, and is zero for spherical billboards
+uniform vec3 billboardUp;
+
+// billboardUVRect has the uv of the top left corner in xy and of the bottom right corner in zw
+uniform vec4 billboardUVRect;
+
+out vec2 vertUV0;
+
+void main()
+{
+    vec2 corner = corners[gl_VertexID];
+    vertUV0 = vec2(
+        mix(billboardUVRect.x, billboardUVRect.z, corner.x + 0.5),
+        mix(billboardUVRect.w, billboardUVRect.y, corner.y + 0.5)
+    );
+
+    float s = sin(billboardRotation);
+    float c = cos(billboardRotation);
+    vec2 offset = corner * billboardSize;
+    offset = vec2(offset.x * c - offset.y * s, offset.x * s + offset.y * c);
+
+    // The rows of the view matrix are the camera's axes in world space
+    vec3 right = vec3(viewMat[0][0], viewMat[1][0], viewMat[2][0]);
+    vec3 up = vec3(viewMat[0][1], viewMat[1][1], viewMat[2][1]);
+
+    if (billboardUp != vec3(0))
+    {
+        vec3 camPos = -transpose(mat3(viewMat)) * viewMat[3].xyz;
+        vec3 cylRight = cross(billboardUp, camPos - billboardPos);
+
+        // Looking straight along the axis keeps the camera's right
+        if (dot(cylRight, cylRight) > 0.000001)
+            right = normalize(cylRight);
+
+        up = billboardUp;
+    }
+
+    vec3 worldPos = billboardPos + right * offset.x + up * offset.y;
+    gl_Position = projMat * viewMat * vec4(worldPos, 1);
+}
+
+//shader:fragment
+#version 410
+
+struct Material {
+    sampler2D diffuse;
+};
+
+uniform Material material;
+uniform vec4 billboardColor;
+
+in vec2 vertUV0;
+
+out vec4 fragColor;
+
+void main()
+{
+    fragColor = billboardColor * texture(material.diffuse, vertUV0);
+
+    if (fragColor.a < 0.01)
+        discard;
+}
@@ -2,7 +2,7 @@
 #version 410
 
 layout(location=0) in vec3 vertPosIn;
-layout(location=2) in vec3 vertTangentIn;
+layout(location=2) in vec4 vertTangentIn;
 layout(location=3) in vec2 vertUV0In;
 layout(location=4) in vec3 vertColorIn;
 
@@ -2,20 +2,37 @@
 #version 410
 
 layout(location=0) in vec3 vertPosIn;
+layout(location=3) in vec2 vertUV0In;
 
 uniform mat4 modelMat;
 uniform mat4 projViewMat;
 
+out vec2 vertUV0;
+
 void main()
 {
+    vertUV0 = vertUV0In;
     gl_Position = projViewMat * modelMat * vec4(vertPosIn, 1);
 }
 
 //shader:fragment
 #version 410
 
+in vec2 vertUV0;
+
+struct Material {
+    sampler2D diffuse;
+};
+uniform Material material;
+
+// See materials.NewCutoutDepthMaterial. Zero means nothing is discarded
+uniform float alphaCutoff;
+
 void main()
 {
+    if (alphaCutoff > 0 && texture(material.diffuse, vertUV0).a < alphaCutoff)
+        discard;
+
     // This implicitly writes to the depth buffer with no color operations
     // Equivalent: gl_FragDepth = gl_FragCoord.z;
 }
@@ -0,0 +1,248 @@
+//shader:vertex
+#version 410
+
+#define NUM_SPOT_LIGHTS 4
+#define NUM_POINT_LIGHTS 8
+#define NUM_AREA_LIGHTS 4
+
+//
+// Inputs
+//
+layout(location=0) in vec3 vertPosIn;
+layout(location=1) in vec3 vertNormalIn;
+layout(location=2) in vec4 vertTangentIn;
+layout(location=3) in vec2 vertUV0In;
+layout(location=4) in vec4 vertColorIn;
+layout(location=5) in vec2 vertUV1In;
+
+//
+// UBOs
+//
+layout (std140) uniform GlobalMatrices {
+    vec3 camPos;
+    mat4 projViewMat;
+};
+
+layout (std140) uniform PerObject {
+    mat4 modelMat;
+    mat3 normalMat;
+    vec4 lightmapScaleOffset;
+    vec3 ambientSH[9];
+    int hasAmbientSH;
+    int receiveShadows;
+    uint layers;
+};
+
+//
+// Outputs
+//
+out vec2 vertUV0;
+
+// Baked ambient occlusion is stored in the vertex color alpha. See res/shaders/simple.glsl
+out float vertAo;
+
+// xy is the uv in the lightmap atlas and z is 1 if the object has a lightmap
+out vec3 vertLightmapUV;
+
+out vec3 fragWorldNormal;
+out vec3 fragWorldTangent;
+out vec3 fragWorldBitangent;
+
+void main()
+{
+    vertUV0 = vertUV0In;
+    vertAo = vertColorIn.a;
+    vertLightmapUV = vec3(vertUV1In * lightmapScaleOffset.xy + lightmapScaleOffset.zw, lightmapScaleOffset.xy == vec2(0) ? 0.0 : 1.0);
+
+    vec3 T = normalize(vec3(modelMat * vec4(vertTangentIn.xyz, 0.0)));
+    vec3 N = normalize(vec3(modelMat * vec4(vertNormalIn, 0.0)));
+    T = normalize(T - dot(T, N) * N);
+
+    fragWorldNormal = N;
+    fragWorldTangent = T;
+    fragWorldBitangent = cross(N, T) * vertTangentIn.w;
+
+    gl_Position = projViewMat * modelMat * vec4(vertPosIn, 1);
+}
+
+//shader:fragment
+#version 410
+
+/*
+    Writes the surface of lit objects into the G-buffer of rend3dgl.Deferred, which lights it later.
+    The lighting of the surface is the same as res/shaders/simple.glsl, except specular textures are read as grayscale.
+
+    Ambient light and emission don't depend on the lights, so they are added here, which keeps the
+    per object ambient (light probes and lightmaps) without storing it in the G-buffer.
+*/
+
+#define NUM_SPOT_LIGHTS 4
+#define NUM_POINT_LIGHTS 8
+#define NUM_AREA_LIGHTS 4
+
+//
+// Inputs
+//
+in vec2 vertUV0;
+in float vertAo;
+in vec3 vertLightmapUV;
+in vec3 fragWorldNormal;
+in vec3 fragWorldTangent;
+in vec3 fragWorldBitangent;
+
+//
+// Uniforms
+//
+struct Material {
+    sampler2D diffuse;
+    sampler2D specular;
+    sampler2D normal;
+    sampler2D emission;
+    float shininess;
+};
+uniform Material material;
+
+uniform vec3 emissiveColor = vec3(1);
+uniform float emissiveIntensity = 1;
+
+uniform sampler2D lightmap;
+
+// See materials.MaterialSettings_AlphaCutout. Zero means the material isn't cut out
+uniform float alphaCutoff;
+
+// The Lights block is only read for the ambient color, but must match the lit shaders since it shares their ubo
+struct ShadowSettings {
+    int enabled;
+    float biasConstant;
+    float biasSlope;
+    float normalOffset;
+    int pcfRadius;
+    float nearPlane;
+    float farPlane;
+};
+
+struct DirLight {
+    vec3 dir;
+    vec3 diffuseColor;
+    vec3 specularColor;
+    ShadowSettings shadow;
+    uint cullingMask;
+};
+
+struct PointLight {
+    vec3 pos;
+    vec3 diffuseColor;
+    vec3 specularColor;
+    float radius;
+    float falloff;
+    ShadowSettings shadow;
+    int shadowLayer;
+    uint cullingMask;
+};
+
+struct SpotLight {
+    vec3 pos;
+    vec3 dir;
+    vec3 diffuseColor;
+    vec3 specularColor;
+    float innerCutoff;
+    float outerCutoff;
+    ShadowSettings shadow;
+    int hasCookie;
+    uint cullingMask;
+};
+
+struct AreaLight {
+    vec3 pos;
+    vec3 halfRight;
+    vec3 halfUp;
+    vec3 diffuseColor;
+    vec3 specularColor;
+    int twoSided;
+    uint cullingMask;
+};
+
+layout (std140) uniform Lights {
+    DirLight dirLight;
+    PointLight pointLights[NUM_POINT_LIGHTS];
+    SpotLight spotLights[NUM_SPOT_LIGHTS];
+    AreaLight areaLights[NUM_AREA_LIGHTS];
+    vec3 ambientColor;
+};
+
+// Must match the vertex shader block
+layout (std140) uniform PerObject {
+    mat4 modelMat;
+    mat3 normalMat;
+    vec4 lightmapScaleOffset;
+    vec3 ambientSH[9];
+    int hasAmbientSH;
+    int receiveShadows;
+    uint layers;
+};
+
+//
+// Outputs. Must match the attachments of rend3dgl.Deferred.GBuffer
+//
+
+// rgb is the diffuse color and a the specular intensity
+layout(location=0) out vec4 gAlbedo;
+
+// xyz is the world normal and w the shininess
+layout(location=1) out vec4 gNormal;
+
+// rgb is the ambient light and emission, and a is 1 if the object receives shadows
+layout(location=2) out vec4 gLight;
+
+// The render layers of the object, which lights compare with their culling masks
+layout(location=3) out int gLayers;
+
+// CalcAmbientSH returns the irradiance (divided by pi) of ambientSH for a world space normal.
+// See lights.SH9.Irradiance which does the same
+vec3 CalcAmbientSH(vec3 n)
+{
+    const float c0 = 0.282095;
+    const float c1 = 0.488603 * (2.0 / 3.0);
+    const float c2 = 1.092548 * 0.25;
+    const float c20 = 0.315392 * 0.25;
+    const float c22 = 0.546274 * 0.25;
+
+    vec3 irradiance = c0 * ambientSH[0]
+        + c1 * (ambientSH[1] * n.y + ambientSH[2] * n.z + ambientSH[3] * n.x)
+        + c2 * (ambientSH[4] * n.x * n.y + ambientSH[5] * n.y * n.z + ambientSH[7] * n.x * n.z)
+        + c20 * ambientSH[6] * (3.0 * n.z * n.z - 1.0)
+        + c22 * ambientSH[8] * (n.x * n.x - n.y * n.y);
+
+    return max(irradiance, vec3(0));
+}
+
+void main()
+{
+    vec4 diffuseTexColor = texture(material.diffuse, vertUV0);
+
+    // Blending isn't possible in the G-buffer, so cutouts are a plain alpha test
+    if (alphaCutoff > 0 && diffuseTexColor.a < alphaCutoff)
+        discard;
+
+    vec3 tangentNormal = normalize(texture(material.normal, vertUV0).rgb * 2.0 - 1.0);
+    mat3 worldTbnMtx = mat3(normalize(fragWorldTangent), normalize(fragWorldBitangent), normalize(fragWorldNormal));
+    vec3 worldNormal = normalize(worldTbnMtx * tangentNormal);
+
+    // Back faces are only drawn by two sided materials, and face the other way
+    if (!gl_FrontFacing)
+        worldNormal = -worldNormal;
+
+    vec3 ambient = ambientColor * diffuseTexColor.rgb;
+    if (hasAmbientSH == 1)
+        ambient = CalcAmbientSH(worldNormal) * diffuseTexColor.rgb;
+
+    if (vertLightmapUV.z > 0)
+        ambient = texture(lightmap, vertLightmapUV.xy).rgb * diffuseTexColor.rgb;
+
+    vec3 emission = texture(material.emission, vertUV0).rgb * emissiveColor * emissiveIntensity;
+
+    gAlbedo = vec4(diffuseTexColor.rgb, texture(material.specular, vertUV0).r);
+    gNormal = vec4(worldNormal, material.shininess);
+    gLight = vec4(ambient * vertAo + emission, receiveShadows == 0 ? 0.0 : 1.0);
+    gLayers = int(layers);
+}
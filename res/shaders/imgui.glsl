@@ -36,6 +36,9 @@ void main()
 
 uniform sampler2D Texture;
 
+// The font texture only has a red channel used as alpha, while other textures (e.g. asset thumbnails) are drawn as they are
+uniform int IsFontTexture;
+
 in vec2 Frag_UV;
 in vec4 Frag_Color;
 
@@ -43,5 +46,8 @@ out vec4 Out_Color;
 
 void main()
 {
-    Out_Color = vec4(Frag_Color.rgb, Frag_Color.a * texture(Texture, Frag_UV.st).r);
+    if (IsFontTexture == 1)
+        Out_Color = vec4(Frag_Color.rgb, Frag_Color.a * texture(Texture, Frag_UV.st).r);
+    else
+        Out_Color = Frag_Color * texture(Texture, Frag_UV.st);
 }
\ No newline at end of file
@@ -2,11 +2,15 @@
 #version 410
 
 layout(location=0) in vec3 vertPosIn;
+layout(location=3) in vec2 vertUV0In;
 
 uniform mat4 modelMat;
 
+out vec2 geomUV0;
+
 void main()
 {
+    geomUV0 = vertUV0In;
     gl_Position = modelMat * vec4(vertPosIn, 1);
 }
 
@@ -22,7 +26,10 @@ layout (triangle_strip, max_vertices=18) out;
 uniform int cubemapIndex;
 uniform mat4 cubemapProjViewMats[6];
 
+in vec2 geomUV0[];
+
 out vec4 FragPos;
+out vec2 vertUV0;
 
 void main()
 {
@@ -38,6 +45,7 @@ void main()
         for(int i = 0; i < 3; ++i)
         {
             FragPos = gl_in[i].gl_Position;
+            vertUV0 = geomUV0[i];
             gl_Position = cubemapProjViewMats[face] * FragPos;
             EmitVertex();
         }
@@ -49,12 +57,24 @@ void main()
 #version 410
 
 in vec4 FragPos;
+in vec2 vertUV0;
+
+struct Material {
+    sampler2D diffuse;
+};
+uniform Material material;
+
+// See materials.NewCutoutDepthMaterial. Zero means nothing is discarded
+uniform float alphaCutoff;
 
 uniform vec3 lightPos;
 uniform float farPlane;
 
 void main()
 {
+    if (alphaCutoff > 0 && texture(material.diffuse, vertUV0).a < alphaCutoff)
+        discard;
+
     // Get distance between fragment and light source
     float lightDistance = length(FragPos.xyz - lightPos);
 
@@ -0,0 +1,890 @@
+//shader:vertex
+#version 410
+
+#define NUM_SPOT_LIGHTS 4
+#define NUM_POINT_LIGHTS 8
+#define NUM_AREA_LIGHTS 4
+
+//
+// Inputs
+//
+layout(location=0) in vec3 vertPosIn;
+layout(location=1) in vec3 vertNormalIn;
+layout(location=2) in vec4 vertTangentIn;
+layout(location=3) in vec2 vertUV0In;
+layout(location=4) in vec4 vertColorIn;
+layout(location=5) in vec2 vertUV1In;
+
+//
+// UBOs
+//
+struct ShadowSettings {
+    int enabled;
+    float biasConstant;
+    float biasSlope;
+    float normalOffset;
+    int pcfRadius;
+    float nearPlane;
+    float farPlane;
+};
+
+struct DirLight {
+    vec3 dir;
+    vec3 diffuseColor;
+    vec3 specularColor;
+    ShadowSettings shadow;
+    uint cullingMask;
+};
+
+struct PointLight {
+    vec3 pos;
+    vec3 diffuseColor;
+    vec3 specularColor;
+    float radius;
+    float falloff;
+    ShadowSettings shadow;
+    int shadowLayer;
+    uint cullingMask;
+};
+
+struct SpotLight {
+    vec3 pos;
+    vec3 dir;
+    vec3 diffuseColor;
+    vec3 specularColor;
+    float innerCutoff;
+    float outerCutoff;
+    ShadowSettings shadow;
+    int hasCookie;
+    uint cullingMask;
+};
+
+struct AreaLight {
+    vec3 pos;
+    vec3 halfRight;
+    vec3 halfUp;
+    vec3 diffuseColor;
+    vec3 specularColor;
+    int twoSided;
+    uint cullingMask;
+};
+
+layout (std140) uniform GlobalMatrices {
+    vec3 camPos;
+    mat4 projViewMat;
+};
+
+layout (std140) uniform Lights {
+    DirLight dirLight;
+    PointLight pointLights[NUM_POINT_LIGHTS];
+    SpotLight spotLights[NUM_SPOT_LIGHTS];
+    AreaLight areaLights[NUM_AREA_LIGHTS];
+    vec3 ambientColor;
+};
+
+layout (std140) uniform PerObject {
+    mat4 modelMat;
+    mat3 normalMat;
+    vec4 lightmapScaleOffset;
+    vec3 ambientSH[9];
+    int hasAmbientSH;
+    int receiveShadows;
+    uint layers;
+};
+
+//
+// Uniforms
+//
+uniform mat4 dirLightProjViewMat;
+uniform mat4 spotLightProjViewMats[NUM_SPOT_LIGHTS];
+
+//
+// Outputs
+//
+out vec2 vertUV0;
+
+// Baked ambient occlusion is stored in the vertex color alpha.
+// Meshes without vertex colors get the default attribute value whose alpha is 1, which is no occlusion
+out float vertAo;
+
+// xy is the uv in the lightmap atlas and z is 1 if the object has a lightmap
+out vec3 vertLightmapUV;
+
+out vec3 fragPos;
+out vec3 fragWorldNormal;
+out vec3 fragWorldTangent;
+out vec3 fragWorldBitangent;
+out vec3 fragPosDirLight;
+out vec4 fragPosSpotLight[NUM_SPOT_LIGHTS];
+
+void main()
+{
+    vertUV0 = vertUV0In;
+    vertAo = vertColorIn.a;
+    vertLightmapUV = vec3(vertUV1In * lightmapScaleOffset.xy + lightmapScaleOffset.zw, lightmapScaleOffset.xy == vec2(0) ? 0.0 : 1.0);
+    vec4 modelVert = modelMat * vec4(vertPosIn, 1);
+
+    // Tangent-BiTangent-Normal vectors for normal mapping, which is done in world space
+    vec3 T = normalize(vec3(modelMat * vec4(vertTangentIn.xyz, 0.0)));
+    vec3 N = normalize(vec3(modelMat * vec4(vertNormalIn, 0.0)));
+
+    // Ensure T is orthogonal with respect to N
+    T = normalize(T - dot(T, N) * N);
+
+    // The w of the tangent flips the bitangent of mirrored uvs, like mikkTSpace expects
+    fragWorldNormal = N;
+    fragWorldTangent = T;
+    fragWorldBitangent = cross(N, T) * vertTangentIn.w;
+
+    fragPos = modelVert.xyz;
+
+    // Offsetting along the normal before projecting reduces shadow acne without a large depth bias
+    fragPosDirLight = vec3(dirLightProjViewMat * vec4(fragPos + N * dirLight.shadow.normalOffset, 1));
+
+    for (int i = 0; i < NUM_SPOT_LIGHTS; i++)
+        fragPosSpotLight[i] = spotLightProjViewMats[i] * vec4(fragPos + N * spotLights[i].shadow.normalOffset, 1);
+
+    gl_Position = projViewMat * modelVert;
+}
+
+//shader:fragment
+#version 410
+
+/*
+    Metallic-roughness PBR, with a Cook-Torrance specular (GGX distribution, Smith geometry and Schlick fresnel)
+    and a Lambert diffuse. Unlike simple.glsl, all lighting is done in world space.
+
+    Light colors are in the same units as simple.glsl, so a light facing a white surface lights it as brightly in both.
+    Ambient light comes from the image based lighting maps when the material has them (see ibl.Maps),
+    otherwise from light probes or the ambient color like simple.glsl.
+*/
+
+#define NUM_SPOT_LIGHTS 4
+#define NUM_POINT_LIGHTS 8
+#define NUM_AREA_LIGHTS 4
+
+#define PI 3.14159265359
+
+//
+// Inputs
+//
+in vec3 fragPos;
+in vec3 fragWorldNormal;
+in vec3 fragWorldTangent;
+in vec3 fragWorldBitangent;
+in vec2 vertUV0;
+in float vertAo;
+in vec3 vertLightmapUV;
+in vec3 fragPosDirLight;
+in vec4 fragPosSpotLight[NUM_SPOT_LIGHTS];
+
+//
+// Uniforms
+//
+struct Material {
+    sampler2D diffuse;
+    sampler2D normal;
+    sampler2D emission;
+    sampler2D metallic;
+    sampler2D roughness;
+    sampler2D ao;
+};
+uniform Material material;
+
+// Multiply the red channel of the metallic and roughness textures. See materials.MaterialSettings_Pbr
+uniform float metallicFactor = 0;
+uniform float roughnessFactor = 1;
+
+// Scale the emission texture, with intensities above one going into the range bloom picks up
+uniform vec3 emissiveColor = vec3(1);
+uniform float emissiveIntensity = 1;
+
+// Image based lighting. Must match ibl.PrefilterMipCount-1
+#define PREFILTER_MAX_LOD 4.0
+uniform int hasIbl;
+uniform samplerCube irradianceMap;
+uniform samplerCube prefilterMap;
+uniform sampler2D brdfLut;
+
+// Baked lighting of static objects, which replaces the diffuse ambient light
+uniform sampler2D lightmap;
+
+// See renderer.DebugView
+uniform int debugView;
+
+// See materials.MaterialSettings_AlphaCutout. Zero means the material isn't cut out
+uniform float alphaCutoff;
+
+struct ShadowSettings {
+    int enabled;
+    float biasConstant;
+    float biasSlope;
+    float normalOffset;
+    int pcfRadius;
+    float nearPlane;
+    float farPlane;
+};
+
+struct DirLight {
+    vec3 dir;
+    vec3 diffuseColor;
+    vec3 specularColor;
+    ShadowSettings shadow;
+    uint cullingMask;
+};
+uniform sampler2D dirLightShadowMap;
+
+struct PointLight {
+    vec3 pos;
+    vec3 diffuseColor;
+    vec3 specularColor;
+    float radius;
+    float falloff;
+    ShadowSettings shadow;
+    int shadowLayer;
+    uint cullingMask;
+};
+uniform samplerCubeArray pointLightCubeShadowMaps;
+
+struct SpotLight {
+    vec3 pos;
+    vec3 dir;
+    vec3 diffuseColor;
+    vec3 specularColor;
+    float innerCutoff;
+    float outerCutoff;
+    ShadowSettings shadow;
+    int hasCookie;
+    uint cullingMask;
+};
+uniform sampler2DArray spotLightShadowMaps;
+
+// Cookies are masks projected by spot lights, and use the same projection as the spot light shadow maps
+uniform sampler2D spotLightCookies[NUM_SPOT_LIGHTS];
+uniform mat4 spotLightProjViewMats[NUM_SPOT_LIGHTS];
+
+struct AreaLight {
+    vec3 pos;
+    vec3 halfRight;
+    vec3 halfUp;
+    vec3 diffuseColor;
+    vec3 specularColor;
+    int twoSided;
+    uint cullingMask;
+};
+
+// Area light lookup tables. See lights.LtcLuts for their contents
+#define LTC_LUT_SIZE 32
+uniform sampler2D ltcMat;
+uniform sampler2D ltcAmp;
+
+layout (std140) uniform GlobalMatrices {
+    vec3 camPos;
+    mat4 projViewMat;
+};
+
+layout (std140) uniform Lights {
+    DirLight dirLight;
+    PointLight pointLights[NUM_POINT_LIGHTS];
+    SpotLight spotLights[NUM_SPOT_LIGHTS];
+    AreaLight areaLights[NUM_AREA_LIGHTS];
+    vec3 ambientColor;
+};
+
+// See renderer.Fog
+layout (std140) uniform Fog {
+    vec3 fogColor;
+    int fogMode;
+    float fogDensity;
+    float fogStart;
+    float fogEnd;
+    float fogHeightFalloff;
+    float fogHeightBase;
+    float fogSkyBlend;
+};
+
+// Must match the vertex shader block. ambientSH is the light of the probes around the object (see lights.SH9)
+layout (std140) uniform PerObject {
+    mat4 modelMat;
+    mat3 normalMat;
+    vec4 lightmapScaleOffset;
+    vec3 ambientSH[9];
+    int hasAmbientSH;
+    int receiveShadows;
+    uint layers;
+};
+
+//
+// Outputs
+//
+out vec4 fragColor;
+
+//
+// Global variables used as cache for lighting calculations
+//
+vec3 N;
+vec3 V;
+vec3 albedo;
+float metallic;
+float roughness;
+
+// F0 is the fresnel reflectance at zero degrees, which is about 4% for non-metals and the albedo for metals
+vec3 F0;
+
+// LightsObject returns true if a light with cullingMask lights the object's layers (see camera.LayerMask)
+bool LightsObject(uint cullingMask)
+{
+    return (cullingMask & layers) != 0u;
+}
+
+//
+// BRDF
+//
+float DistributionGGX(float NdotH)
+{
+    float a = roughness * roughness;
+    float a2 = a * a;
+    float d = NdotH * NdotH * (a2 - 1.0) + 1.0;
+    return a2 / (PI * d * d);
+}
+
+float GeometrySchlickGGX(float NdotX)
+{
+    float r = roughness + 1.0;
+    float k = r * r / 8.0;
+    return NdotX / (NdotX * (1.0 - k) + k);
+}
+
+vec3 FresnelSchlick(float cosTheta)
+{
+    return F0 + (1.0 - F0) * pow(clamp(1.0 - cosTheta, 0.0, 1.0), 5.0);
+}
+
+// FresnelSchlickRoughness dampens the fresnel of rough surfaces, which is used for ambient light coming from all directions
+vec3 FresnelSchlickRoughness(float cosTheta)
+{
+    return F0 + (max(vec3(1.0 - roughness), F0) - F0) * pow(clamp(1.0 - cosTheta, 0.0, 1.0), 5.0);
+}
+
+// CalcBrdf returns the light reflected towards the camera from a light with color lightColor in direction L
+vec3 CalcBrdf(vec3 L, vec3 lightColor)
+{
+    float NdotL = max(dot(N, L), 0.0);
+    if (NdotL == 0.0)
+        return vec3(0);
+
+    vec3 H = normalize(V + L);
+    float NdotV = max(dot(N, V), 0.0001);
+    float NdotH = max(dot(N, H), 0.0);
+
+    float D = DistributionGGX(NdotH);
+    float G = GeometrySchlickGGX(NdotV) * GeometrySchlickGGX(NdotL);
+    vec3 F = FresnelSchlick(max(dot(H, V), 0.0));
+
+    vec3 specular = D * G * F / (4.0 * NdotV * NdotL + 0.0001);
+
+    // Metals have no diffuse, and light reflected by the specular isn't diffused
+    vec3 kD = (1.0 - F) * (1.0 - metallic);
+
+    // Scaled by pi so light colors are in the units of simple.glsl, where a light facing a white surface lights it fully
+    return (kD * albedo / PI + specular) * lightColor * NdotL * PI;
+}
+
+//
+// Shadows
+//
+float CalcDirShadow(vec3 L)
+{
+    if (dirLight.shadow.enabled == 0 || receiveShadows == 0)
+        return 0;
+
+    // Move from [-1,1] to [0, 1]
+    vec3 projCoords = fragPosDirLight * 0.5 + 0.5;
+
+    // If sampling outside the depth texture then force 'no shadow'
+    if(projCoords.z > 1)
+        return 0;
+
+    // currentDepth is the fragment depth from the light's perspective
+    float currentDepth = projCoords.z;
+
+    // Bias in the range [biasConstant, biasSlope] depending on the angle, where a higher
+    // angle gives a higher bias, as shadow acne gets worse with angle
+    float bias = max(dirLight.shadow.biasSlope * (1 - dot(N, L)), dirLight.shadow.biasConstant);
+
+    // 'Percentage Close Filtering'.
+    // Basically get soft shadows by averaging this texel and surrounding ones
+    float shadow = 0;
+    int pcfRadius = dirLight.shadow.pcfRadius;
+    vec2 texelSize = 1 / textureSize(dirLightShadowMap, 0);
+    for(int x = -pcfRadius; x <= pcfRadius; x++)
+    {
+        for(int y = -pcfRadius; y <= pcfRadius; y++)
+        {
+            float pcfDepth = texture(dirLightShadowMap, projCoords.xy + vec2(x, y) * texelSize).r;
+            shadow += currentDepth - bias > pcfDepth ? 1 : 0;
+        }
+    }
+
+    shadow /= (2 * pcfRadius + 1) * (2 * pcfRadius + 1);
+
+    return shadow;
+}
+
+// Offsets used for PCF on cubemaps, which sample around the light to fragment direction
+const vec3 pointPcfOffsets[20] = vec3[](
+   vec3( 1,  1,  1), vec3( 1, -1,  1), vec3(-1, -1,  1), vec3(-1,  1,  1),
+   vec3( 1,  1, -1), vec3( 1, -1, -1), vec3(-1, -1, -1), vec3(-1,  1, -1),
+   vec3( 1,  1,  0), vec3( 1, -1,  0), vec3(-1, -1,  0), vec3(-1,  1,  0),
+   vec3( 1,  0,  1), vec3(-1,  0,  1), vec3( 1,  0, -1), vec3(-1,  0, -1),
+   vec3( 0,  1,  1), vec3( 0, -1,  1), vec3( 0, -1, -1), vec3( 0,  1, -1)
+);
+
+float CalcPointShadow(int shadowLayer, vec3 lightPos, vec3 L, ShadowSettings shadowSettings)
+{
+    if (shadowSettings.enabled == 0 || shadowLayer < 0 || receiveShadows == 0)
+        return 0;
+
+    vec3 lightToFrag = fragPos + fragWorldNormal * shadowSettings.normalOffset - lightPos;
+
+    // Get depth of current fragment
+    float currentDepth = length(lightToFrag);
+
+    if (currentDepth < shadowSettings.nearPlane)
+        return 0;
+
+    float bias = max(shadowSettings.biasSlope * (1 - dot(N, L)), shadowSettings.biasConstant);
+
+    // 'Percentage Close Filtering' with samples spread around the direction,
+    // where the spread grows with the pcf radius
+    int sampleCount = shadowSettings.pcfRadius == 0 ? 1 : 20;
+    float diskRadius = shadowSettings.pcfRadius * 0.02;
+
+    float shadow = 0;
+    for (int i = 0; i < sampleCount; i++)
+    {
+        vec3 sampleDir = lightToFrag + (sampleCount == 1 ? vec3(0) : pointPcfOffsets[i] * diskRadius * currentDepth);
+        float closestDepth = texture(pointLightCubeShadowMaps, vec4(sampleDir, shadowLayer)).r;
+
+        // We stored depth in the cubemap in the range [0, 1], so now we move back to [0, farPlane]
+        closestDepth *= shadowSettings.farPlane;
+
+        shadow += currentDepth - bias > closestDepth ? 1 : 0;
+    }
+
+    return shadow / sampleCount;
+}
+
+float CalcSpotShadow(vec3 L, int lightIndex)
+{
+    ShadowSettings shadowSettings = spotLights[lightIndex].shadow;
+    if (shadowSettings.enabled == 0 || receiveShadows == 0)
+        return 0;
+
+    // Move from clip space to NDC, then from [-1,1] to [0, 1]
+    vec3 projCoords = fragPosSpotLight[lightIndex].xyz / fragPosSpotLight[lightIndex].w;
+    projCoords = projCoords * 0.5 + 0.5;
+
+    // If sampling outside the depth texture then force 'no shadow'
+    if(projCoords.z > 1)
+        return 0;
+
+    float currentDepth = projCoords.z;
+    float bias = max(shadowSettings.biasSlope * (1 - dot(N, L)), shadowSettings.biasConstant);
+
+    float shadow = 0;
+    int pcfRadius = shadowSettings.pcfRadius;
+    vec2 texelSize = 1 / textureSize(spotLightShadowMaps, 0).xy;
+    for(int x = -pcfRadius; x <= pcfRadius; x++)
+    {
+        for(int y = -pcfRadius; y <= pcfRadius; y++)
+        {
+            float pcfDepth = texture(spotLightShadowMaps, vec3(projCoords.xy + vec2(x, y) * texelSize, lightIndex)).r;
+            shadow += currentDepth - bias > pcfDepth ? 1 : 0;
+        }
+    }
+
+    shadow /= (2 * pcfRadius + 1) * (2 * pcfRadius + 1);
+
+    return shadow;
+}
+
+//
+// Lights
+//
+vec3 CalcDirLight()
+{
+    if (!LightsObject(dirLight.cullingMask))
+        return vec3(0);
+
+    vec3 L = normalize(-dirLight.dir);
+    return CalcBrdf(L, dirLight.diffuseColor) * (1 - CalcDirShadow(L));
+}
+
+// Point light attenuation from https://lisyarus.github.io/blog/posts/point-light-attenuation.html, like simple.glsl
+float AttenuateNoCusp(float dist, float radius, float falloff)
+{
+    float s = dist / radius;
+    if (s >= 1.0)
+        return 0.0;
+
+    float s2 = s * s;
+    float oneMinusS2 = 1 - s2;
+    return oneMinusS2 * oneMinusS2 / (1 + falloff * s2);
+}
+
+vec3 CalcPointLight(PointLight pointLight)
+{
+    // Ignore inactive lights, and lights that don't light the object's layers
+    if (pointLight.radius == 0 || !LightsObject(pointLight.cullingMask))
+        return vec3(0);
+
+    vec3 fragToLight = pointLight.pos - fragPos;
+    float attenuation = AttenuateNoCusp(length(fragToLight), pointLight.radius, pointLight.falloff);
+    if (attenuation == 0)
+        return vec3(0);
+
+    vec3 L = normalize(fragToLight);
+    float shadow = CalcPointShadow(pointLight.shadowLayer, pointLight.pos, L, pointLight.shadow);
+
+    return CalcBrdf(L, pointLight.diffuseColor) * attenuation * (1 - shadow);
+}
+
+vec3 CalcSpotCookie(SpotLight light, int lightIndex)
+{
+    if (light.hasCookie == 0)
+        return vec3(1);
+
+    // Projected the same way as the shadow map but without the normal offset, which would distort the cookie
+    vec4 lightClipPos = spotLightProjViewMats[lightIndex] * vec4(fragPos, 1);
+    if (lightClipPos.w <= 0)
+        return vec3(0);
+
+    // Move from clip space to [0, 1] uvs
+    vec2 cookieUV = (lightClipPos.xy / lightClipPos.w) * 0.5 + 0.5;
+
+    // Nothing is projected outside the cookie, regardless of the texture's wrap mode
+    if (cookieUV.x < 0 || cookieUV.x > 1 || cookieUV.y < 0 || cookieUV.y > 1)
+        return vec3(0);
+
+    return texture(spotLightCookies[lightIndex], cookieUV).rgb;
+}
+
+vec3 CalcSpotLight(SpotLight light, int lightIndex)
+{
+    // The cutoffs are cosines, so an inner cutoff of one is a light without a cone
+    if (light.innerCutoff == 1 || !LightsObject(light.cullingMask))
+        return vec3(0);
+
+    vec3 L = normalize(light.pos - fragPos);
+
+    // Full intensity within the inner cutoff, fading out towards the outer cutoff
+    float theta = dot(L, normalize(-light.dir));
+    float intensity = clamp((theta - light.outerCutoff) / (light.innerCutoff - light.outerCutoff), 0.0, 1.0);
+    if (intensity == 0)
+        return vec3(0);
+
+    float shadow = CalcSpotShadow(L, lightIndex);
+    return CalcBrdf(L, light.diffuseColor) * intensity * (1 - shadow) * CalcSpotCookie(light, lightIndex);
+}
+
+//
+// Area lights using 'Linearly Transformed Cosines', like simple.glsl.
+// Based on: https://eheitzresearch.wordpress.com/415-2/
+//
+vec2 LtcLutUV(vec2 uv)
+{
+    // Sample texel centers so the edges of the tables aren't blended with the border
+    return uv * (LTC_LUT_SIZE - 1.0) / LTC_LUT_SIZE + 0.5 / LTC_LUT_SIZE;
+}
+
+// Integral of the clamped cosine over an edge of the polygon (as a vector form factor)
+vec3 IntegrateEdgeVec(vec3 v1, vec3 v2)
+{
+    float x = dot(v1, v2);
+    float y = abs(x);
+
+    float a = 0.8543985 + (0.4965155 + 0.0145206 * y) * y;
+    float b = 3.4175940 + (4.1616724 + y) * y;
+    float v = a / b;
+
+    float thetaSinTheta = x > 0.0 ? v : 0.5 * inversesqrt(max(1.0 - x * x, 1e-7)) - v;
+    return cross(v1, v2) * thetaSinTheta;
+}
+
+// LtcEvaluate integrates the cosine distribution transformed by minv over the rectangle of 'points'
+float LtcEvaluate(vec3 P, mat3 minv, vec3 points[4], bool twoSided)
+{
+    // Orthonormal basis around the normal, with the view direction in the xz plane
+    vec3 T1 = normalize(V - N * dot(V, N));
+    vec3 T2 = cross(N, T1);
+    minv = minv * transpose(mat3(T1, T2, N));
+
+    vec3 L[4];
+    L[0] = normalize(minv * (points[0] - P));
+    L[1] = normalize(minv * (points[1] - P));
+    L[2] = normalize(minv * (points[2] - P));
+    L[3] = normalize(minv * (points[3] - P));
+
+    // Whether the fragment is on the emitting side of the light
+    vec3 lightNormal = cross(points[1] - points[0], points[3] - points[0]);
+    bool inFront = dot(points[0] - P, lightNormal) < 0.0;
+
+    vec3 vsum = IntegrateEdgeVec(L[0], L[1]);
+    vsum += IntegrateEdgeVec(L[1], L[2]);
+    vsum += IntegrateEdgeVec(L[2], L[3]);
+    vsum += IntegrateEdgeVec(L[3], L[0]);
+
+    float len = length(vsum);
+    if (len == 0.0 || (!inFront && !twoSided))
+        return 0.0;
+
+    float z = vsum.z / len;
+    if (inFront)
+        z = -z;
+
+    // Instead of clipping the polygon to the horizon, a sphere with the same vector form factor is clipped using a table
+    float horizonScale = texture(ltcAmp, LtcLutUV(vec2(z * 0.5 + 0.5, len))).w;
+    return len * horizonScale;
+}
+
+vec3 CalcAreaLight(AreaLight light)
+{
+    // Ignore inactive lights, and lights that don't light the object's layers
+    if (light.halfRight == vec3(0) || light.halfUp == vec3(0) || !LightsObject(light.cullingMask))
+        return vec3(0);
+
+    vec3 points[4];
+    points[0] = light.pos - light.halfRight - light.halfUp;
+    points[1] = light.pos + light.halfRight - light.halfUp;
+    points[2] = light.pos + light.halfRight + light.halfUp;
+    points[3] = light.pos - light.halfRight + light.halfUp;
+
+    float NdotV = clamp(dot(N, V), 0.0, 1.0);
+
+    vec2 lutUV = LtcLutUV(vec2(roughness, sqrt(1.0 - NdotV)));
+    vec4 t1 = texture(ltcMat, lutUV);
+    vec4 t2 = texture(ltcAmp, lutUV);
+    mat3 minv = mat3(
+        vec3(t1.x, 0, t1.y),
+        vec3(0, 1, 0),
+        vec3(t1.z, 0, t1.w)
+    );
+
+    bool twoSided = light.twoSided != 0;
+    float diffuseAmount = LtcEvaluate(fragPos, mat3(1), points, twoSided);
+    float specularAmount = LtcEvaluate(fragPos, minv, points, twoSided);
+
+    vec3 specularScale = F0 * t2.x + (1.0 - F0) * t2.y;
+
+    vec3 finalDiffuse = diffuseAmount * light.diffuseColor * albedo * (1.0 - metallic);
+    vec3 finalSpecular = specularAmount * light.specularColor * specularScale;
+
+    return finalDiffuse + finalSpecular;
+}
+
+//
+// Ambient
+//
+
+// CalcAmbientSH returns the irradiance (divided by pi) of ambientSH for a world space normal.
+// See lights.SH9.Irradiance which does the same
+vec3 CalcAmbientSH(vec3 n)
+{
+    // Basis constants multiplied by the cosine lobe constants of each band
+    const float c0 = 0.282095;
+    const float c1 = 0.488603 * (2.0 / 3.0);
+    const float c2 = 1.092548 * 0.25;
+    const float c20 = 0.315392 * 0.25;
+    const float c22 = 0.546274 * 0.25;
+
+    vec3 irradiance = c0 * ambientSH[0]
+        + c1 * (ambientSH[1] * n.y + ambientSH[2] * n.z + ambientSH[3] * n.x)
+        + c2 * (ambientSH[4] * n.x * n.y + ambientSH[5] * n.y * n.z + ambientSH[7] * n.x * n.z)
+        + c20 * ambientSH[6] * (3.0 * n.z * n.z - 1.0)
+        + c22 * ambientSH[8] * (n.x * n.x - n.y * n.y);
+
+    return max(irradiance, vec3(0));
+}
+
+// EnvBrdfApprox is an analytic fit of the BRDF lookup table, used for the specular of ambient light without image based lighting.
+// From 'Physically Based Shading on Mobile' by Brian Karis
+vec3 EnvBrdfApprox(float NdotV)
+{
+    const vec4 c0 = vec4(-1, -0.0275, -0.572, 0.022);
+    const vec4 c1 = vec4(1, 0.0425, 1.04, -0.04);
+
+    vec4 r = roughness * c0 + c1;
+    float a004 = min(r.x * r.x, exp2(-9.28 * NdotV)) * r.x + r.y;
+    vec2 ab = vec2(-1.04, 1.04) * a004 + r.zw;
+    return F0 * ab.x + ab.y;
+}
+
+vec3 CalcAmbient(float ao)
+{
+    float NdotV = max(dot(N, V), 0.0);
+    vec3 F = FresnelSchlickRoughness(NdotV);
+    vec3 kD = (1.0 - F) * (1.0 - metallic);
+
+    // Irradiance divided by pi, so it multiplies the albedo directly
+    vec3 irradiance = ambientColor;
+    if (hasIbl == 1)
+        irradiance = texture(irradianceMap, N).rgb;
+    else if (hasAmbientSH == 1)
+        irradiance = CalcAmbientSH(N);
+
+    if (vertLightmapUV.z > 0)
+        irradiance = texture(lightmap, vertLightmapUV.xy).rgb;
+
+    vec3 diffuse = kD * irradiance * albedo;
+
+    // Without an environment to reflect, rough reflections of the ambient light keep metals from going black
+    vec3 specular;
+    if (hasIbl == 1)
+    {
+        vec3 R = reflect(-V, N);
+        vec3 prefiltered = textureLod(prefilterMap, R, roughness * PREFILTER_MAX_LOD).rgb;
+        vec2 brdf = texture(brdfLut, vec2(NdotV, roughness)).rg;
+        specular = prefiltered * (F * brdf.x + brdf.y);
+    }
+    else
+    {
+        specular = irradiance * EnvBrdfApprox(NdotV);
+    }
+
+    return (diffuse + specular) * ao;
+}
+
+// CalcFog returns how much fog is between the camera and worldPos in [0, 1]
+float CalcFog(vec3 worldPos)
+{
+    if (fogMode == 0)
+        return 0;
+
+    vec3 camToPos = worldPos - camPos;
+    float dist = max(length(camToPos) - fogStart, 0.0);
+
+    float fog;
+    if (fogMode == 1)
+        fog = dist / max(fogEnd - fogStart, 0.0001);
+    else if (fogMode == 2)
+        fog = 1.0 - exp(-fogDensity * dist);
+    else
+        fog = 1.0 - exp(-pow(fogDensity * dist, 2.0));
+
+    // Height fog density is exp(-falloff*(height-base)), and this is its average along the view ray
+    if (fogHeightFalloff > 0)
+    {
+        float camHeightDensity = exp(-fogHeightFalloff * (camPos.y - fogHeightBase));
+        float falloffAlongRay = fogHeightFalloff * camToPos.y;
+
+        float heightFactor = camHeightDensity;
+        if (abs(falloffAlongRay) > 0.0001)
+            heightFactor *= (1.0 - exp(-falloffAlongRay)) / falloffAlongRay;
+
+        fog *= heightFactor;
+    }
+
+    return clamp(fog, 0.0, 1.0);
+}
+
+#define DEBUG_VIEW_NORMALS 1
+#define DEBUG_VIEW_LIGHT_COUNT 2
+
+// Must match renderer.DebugViewHeatmapMax
+#define LIGHT_HEATMAP_MAX 8
+
+// CountLights returns how many point and spot lights reach the fragment, ignoring shadows
+int CountLights()
+{
+    int count = 0;
+    for (int i = 0; i < NUM_POINT_LIGHTS; i++)
+    {
+        if (pointLights[i].radius > 0 && LightsObject(pointLights[i].cullingMask) && length(pointLights[i].pos - fragPos) < pointLights[i].radius)
+            count++;
+    }
+
+    for (int i = 0; i < NUM_SPOT_LIGHTS; i++)
+    {
+        if (spotLights[i].innerCutoff == 1 || !LightsObject(spotLights[i].cullingMask))
+            continue;
+
+        vec3 fragToLightDir = normalize(spotLights[i].pos - fragPos);
+        if (dot(fragToLightDir, normalize(-spotLights[i].dir)) > spotLights[i].outerCutoff)
+            count++;
+    }
+
+    return count;
+}
+
+// Heatmap goes black, blue, green, yellow then red as t goes from 0 to 1
+vec3 Heatmap(float t)
+{
+    const vec3 colors[5] = vec3[5](vec3(0), vec3(0, 0, 1), vec3(0, 1, 0), vec3(1, 1, 0), vec3(1, 0, 0));
+
+    float scaled = clamp(t, 0.0, 1.0) * 4.0;
+    int i = min(int(scaled), 3);
+    return mix(colors[i], colors[i + 1], scaled - float(i));
+}
+
+void main()
+{
+    vec4 diffuseTexColor = texture(material.diffuse, vertUV0);
+
+    // Alpha is sharpened to a pixel wide ramp around the cutoff, which alpha to coverage turns into antialiased edges,
+    // and without MSAA acts like a plain alpha test
+    float alpha = 1;
+    if (alphaCutoff > 0)
+    {
+        alpha = clamp((diffuseTexColor.a - alphaCutoff) / max(fwidth(diffuseTexColor.a), 0.0001) + 0.5, 0.0, 1.0);
+        if (alpha == 0)
+            discard;
+    }
+
+    albedo = diffuseTexColor.rgb;
+    metallic = clamp(texture(material.metallic, vertUV0).r * metallicFactor, 0.0, 1.0);
+
+    // Perfectly smooth surfaces turn lights into invisible points, so roughness is kept above zero
+    roughness = clamp(texture(material.roughness, vertUV0).r * roughnessFactor, 0.04, 1.0);
+    F0 = mix(vec3(0.04), albedo, metallic);
+
+    // Normal mapping, with the normal read in [0,1] and remapped to [-1,1]
+    vec3 tangentNormal = normalize(texture(material.normal, vertUV0).rgb * 2.0 - 1.0);
+    mat3 worldTbnMtx = mat3(normalize(fragWorldTangent), normalize(fragWorldBitangent), normalize(fragWorldNormal));
+    N = normalize(worldTbnMtx * tangentNormal);
+
+    // Back faces are only drawn by two sided materials, and face the other way
+    if (!gl_FrontFacing)
+        N = -N;
+
+    V = normalize(camPos - fragPos);
+
+    // Light contributions
+    vec3 finalColor = CalcDirLight();
+
+    for (int i = 0; i < NUM_POINT_LIGHTS; i++)
+        finalColor += CalcPointLight(pointLights[i]);
+
+    for (int i = 0; i < NUM_SPOT_LIGHTS; i++)
+        finalColor += CalcSpotLight(spotLights[i], i);
+
+    for (int i = 0; i < NUM_AREA_LIGHTS; i++)
+        finalColor += CalcAreaLight(areaLights[i]);
+
+    vec3 finalAmbient = CalcAmbient(texture(material.ao, vertUV0).r * vertAo);
+    vec3 finalEmission = texture(material.emission, vertUV0).rgb * emissiveColor * emissiveIntensity;
+
+    fragColor = vec4(mix(finalColor + finalAmbient + finalEmission, fogColor, CalcFog(fragPos)), alpha);
+
+    if (debugView == DEBUG_VIEW_NORMALS)
+    {
+        fragColor = vec4(texture(material.normal, vertUV0).rgb, 1);
+    }
+    else if (debugView == DEBUG_VIEW_LIGHT_COUNT)
+    {
+        // Dimmed lighting under the heatmap keeps the scene readable
+        float brightness = dot(finalColor + finalAmbient, vec3(0.2126, 0.7152, 0.0722));
+        vec3 heat = Heatmap(float(CountLights()) / float(LIGHT_HEATMAP_MAX));
+        fragColor = vec4(heat * 0.8 + clamp(brightness, 0.0, 1.0) * 0.2, 1);
+    }
+}
@@ -3,23 +3,37 @@
 
 #define NUM_SPOT_LIGHTS 4
 #define NUM_POINT_LIGHTS 8
+#define NUM_AREA_LIGHTS 4
 
 //
 // Inputs
 //
 layout(location=0) in vec3 vertPosIn;
 layout(location=1) in vec3 vertNormalIn;
-layout(location=2) in vec3 vertTangentIn;
+layout(location=2) in vec4 vertTangentIn;
 layout(location=3) in vec2 vertUV0In;
-layout(location=4) in vec3 vertColorIn;
+layout(location=4) in vec4 vertColorIn;
+layout(location=5) in vec2 vertUV1In;
 
 //
 // UBOs
 //
+struct ShadowSettings {
+    int enabled;
+    float biasConstant;
+    float biasSlope;
+    float normalOffset;
+    int pcfRadius;
+    float nearPlane;
+    float farPlane;
+};
+
 struct DirLight {
     vec3 dir;
     vec3 diffuseColor;
     vec3 specularColor;
+    ShadowSettings shadow;
+    uint cullingMask;
 };
 uniform sampler2D dirLightShadowMap;
 
@@ -29,9 +43,9 @@ struct PointLight {
     vec3 specularColor;
     float radius;
     float falloff;
-    float maxBias;
-    float nearPlane;
-    float farPlane;
+    ShadowSettings shadow;
+    int shadowLayer;
+    uint cullingMask;
 };
 
 struct SpotLight {
@@ -41,6 +55,19 @@ struct SpotLight {
     vec3 specularColor;
     float innerCutoff;
     float outerCutoff;
+    ShadowSettings shadow;
+    int hasCookie;
+    uint cullingMask;
+};
+
+struct AreaLight {
+    vec3 pos;
+    vec3 halfRight;
+    vec3 halfUp;
+    vec3 diffuseColor;
+    vec3 specularColor;
+    int twoSided;
+    uint cullingMask;
 };
 
 layout (std140) uniform GlobalMatrices {
@@ -52,13 +79,23 @@ layout (std140) uniform Lights {
     DirLight dirLight;
     PointLight pointLights[NUM_POINT_LIGHTS];
     SpotLight spotLights[NUM_SPOT_LIGHTS];
+    AreaLight areaLights[NUM_AREA_LIGHTS];
     vec3 ambientColor;
 };
 
+layout (std140) uniform PerObject {
+    mat4 modelMat;
+    mat3 normalMat;
+    vec4 lightmapScaleOffset;
+    vec3 ambientSH[9];
+    int hasAmbientSH;
+    int receiveShadows;
+    uint layers;
+};
+
 //
 // Uniforms
 //
-uniform mat4 modelMat;
 uniform mat4 dirLightProjViewMat;
 uniform mat4 spotLightProjViewMats[NUM_SPOT_LIGHTS];
 
@@ -68,7 +105,16 @@ uniform mat4 spotLightProjViewMats[NUM_SPOT_LIGHTS];
 out vec2 vertUV0;
 out vec3 vertColor;
 
+// Baked ambient occlusion is stored in the vertex color alpha.
+// Meshes without vertex colors get the default attribute value whose alpha is 1, which is no occlusion
+out float vertAo;
+
+// xy is the uv in the lightmap atlas and z is 1 if the object has a lightmap
+out vec3 vertLightmapUV;
+
 out vec3 fragPos;
+out vec3 fragWorldNormal;
+out vec3 fragWorldTangent;
 out vec3 fragPosDirLight;
 out vec4 fragPosSpotLight[NUM_SPOT_LIGHTS];
 
@@ -82,22 +128,29 @@ out vec3 tangentPointLightPositions[NUM_POINT_LIGHTS];
 void main()
 {
     vertUV0 = vertUV0In;
-    vertColor = vertColorIn;
+    vertColor = vertColorIn.rgb;
+    vertAo = vertColorIn.a;
+    vertLightmapUV = vec3(vertUV1In * lightmapScaleOffset.xy + lightmapScaleOffset.zw, lightmapScaleOffset.xy == vec2(0) ? 0.0 : 1.0);
     vec4 modelVert = modelMat * vec4(vertPosIn, 1);
 
     // Tangent-BiTangent-Normal matrix for normal mapping
-    vec3 T = normalize(vec3(modelMat * vec4(vertTangentIn,   0.0)));
-    vec3 N = normalize(vec3(modelMat * vec4(vertNormalIn,    0.0)));
+    vec3 T = normalize(vec3(modelMat * vec4(vertTangentIn.xyz, 0.0)));
+    vec3 N = normalize(vec3(modelMat * vec4(vertNormalIn, 0.0)));
 
     // Ensure T is orthogonal with respect to N
     T = normalize(T - dot(T, N) * N);
 
-    vec3 B = cross(N, T);
+    // The w of the tangent flips the bitangent of mirrored uvs, like mikkTSpace expects
+    vec3 B = cross(N, T) * vertTangentIn.w;
     mat3 tbnMtx = transpose(mat3(T, B, N));
 
     // Lighting related
     fragPos = modelVert.xyz;
-    fragPosDirLight = vec3(dirLightProjViewMat * vec4(fragPos, 1));
+    fragWorldNormal = N;
+    fragWorldTangent = T;
+
+    // Offsetting along the normal before projecting reduces shadow acne without a large depth bias
+    fragPosDirLight = vec3(dirLightProjViewMat * vec4(fragPos + N * dirLight.shadow.normalOffset, 1));
 
     tangentCamPos = tbnMtx * camPos;
     tangentFragPos = tbnMtx * fragPos;
@@ -108,7 +161,7 @@ void main()
 
     for (int i = 0; i < NUM_SPOT_LIGHTS; i++)
     {
-        fragPosSpotLight[i] = spotLightProjViewMats[i] * vec4(fragPos, 1);
+        fragPosSpotLight[i] = spotLightProjViewMats[i] * vec4(fragPos + N * spotLights[i].shadow.normalOffset, 1);
 
         tangentSpotLightPositions[i] = tbnMtx * spotLights[i].pos;
         tangentSpotLightDirections[i] = tbnMtx * spotLights[i].dir;
@@ -131,13 +184,18 @@ void main()
 
 #define NUM_SPOT_LIGHTS 4
 #define NUM_POINT_LIGHTS 8
+#define NUM_AREA_LIGHTS 4
 
 //
 // Inputs
 //
 in vec3 fragPos;
+in vec3 fragWorldNormal;
+in vec3 fragWorldTangent;
 in vec2 vertUV0;
 in vec3 vertColor;
+in float vertAo;
+in vec3 vertLightmapUV;
 in vec3 fragPosDirLight;
 in vec4 fragPosSpotLight[NUM_SPOT_LIGHTS];
 
@@ -160,10 +218,35 @@ struct Material {
 };
 uniform Material material;
 
+// Scale the emission texture, with intensities above one going into the range bloom picks up
+uniform vec3 emissiveColor = vec3(1);
+uniform float emissiveIntensity = 1;
+
+// Baked lighting of static objects, which replaces the ambient color
+uniform sampler2D lightmap;
+
+// See renderer.DebugView
+uniform int debugView;
+
+// See materials.MaterialSettings_AlphaCutout. Zero means the material isn't cut out
+uniform float alphaCutoff;
+
+struct ShadowSettings {
+    int enabled;
+    float biasConstant;
+    float biasSlope;
+    float normalOffset;
+    int pcfRadius;
+    float nearPlane;
+    float farPlane;
+};
+
 struct DirLight {
     vec3 dir;
     vec3 diffuseColor;
     vec3 specularColor;
+    ShadowSettings shadow;
+    uint cullingMask;
 };
 uniform sampler2D dirLightShadowMap;
 
@@ -173,9 +256,9 @@ struct PointLight {
     vec3 specularColor;
     float radius;
     float falloff;
-    float maxBias;
-    float nearPlane;
-    float farPlane;
+    ShadowSettings shadow;
+    int shadowLayer;
+    uint cullingMask;
 };
 uniform samplerCubeArray pointLightCubeShadowMaps;
 
@@ -186,9 +269,31 @@ struct SpotLight {
     vec3 specularColor;
     float innerCutoff;
     float outerCutoff;
+    ShadowSettings shadow;
+    int hasCookie;
+    uint cullingMask;
 };
 uniform sampler2DArray spotLightShadowMaps;
 
+// Cookies are masks projected by spot lights, and use the same projection as the spot light shadow maps
+uniform sampler2D spotLightCookies[NUM_SPOT_LIGHTS];
+uniform mat4 spotLightProjViewMats[NUM_SPOT_LIGHTS];
+
+struct AreaLight {
+    vec3 pos;
+    vec3 halfRight;
+    vec3 halfUp;
+    vec3 diffuseColor;
+    vec3 specularColor;
+    int twoSided;
+    uint cullingMask;
+};
+
+// Area light lookup tables. See lights.LtcLuts for their contents
+#define LTC_LUT_SIZE 32
+uniform sampler2D ltcMat;
+uniform sampler2D ltcAmp;
+
 layout (std140) uniform GlobalMatrices {
     vec3 camPos;
     mat4 projViewMat;
@@ -198,9 +303,33 @@ layout (std140) uniform Lights {
     DirLight dirLight;
     PointLight pointLights[NUM_POINT_LIGHTS];
     SpotLight spotLights[NUM_SPOT_LIGHTS];
+    AreaLight areaLights[NUM_AREA_LIGHTS];
     vec3 ambientColor;
 };
 
+// See renderer.Fog
+layout (std140) uniform Fog {
+    vec3 fogColor;
+    int fogMode;
+    float fogDensity;
+    float fogStart;
+    float fogEnd;
+    float fogHeightFalloff;
+    float fogHeightBase;
+    float fogSkyBlend;
+};
+
+// Must match the vertex shader block. ambientSH is the light of the probes around the object (see lights.SH9)
+layout (std140) uniform PerObject {
+    mat4 modelMat;
+    mat3 normalMat;
+    vec4 lightmapScaleOffset;
+    vec3 ambientSH[9];
+    int hasAmbientSH;
+    int receiveShadows;
+    uint layers;
+};
+
 //
 // Outputs
 //
@@ -217,6 +346,9 @@ vec3 normalizedVertNorm;
 
 float CalcDirShadow(sampler2D shadowMap, vec3 tangentLightDir)
 {
+    if (dirLight.shadow.enabled == 0 || receiveShadows == 0)
+        return 0;
+
     // Move from [-1,1] to [0, 1]
     vec3 projCoords = fragPosDirLight * 0.5 + 0.5;
 
@@ -227,17 +359,18 @@ float CalcDirShadow(sampler2D shadowMap, vec3 tangentLightDir)
     // currentDepth is the fragment depth from the light's perspective
     float currentDepth = projCoords.z;
 
-    // Bias in the range [0.005, 0.05] depending on the angle, where a higher
+    // Bias in the range [biasConstant, biasSlope] depending on the angle, where a higher
     // angle gives a higher bias, as shadow acne gets worse with angle
-    float bias = max(0.05 * (1 - dot(normalizedVertNorm, tangentLightDir)), 0.005);
+    float bias = max(dirLight.shadow.biasSlope * (1 - dot(normalizedVertNorm, tangentLightDir)), dirLight.shadow.biasConstant);
 
     // 'Percentage Close Filtering'.
     // Basically get soft shadows by averaging this texel and surrounding ones
     float shadow = 0;
+    int pcfRadius = dirLight.shadow.pcfRadius;
     vec2 texelSize = 1 / textureSize(shadowMap, 0);
-    for(int x = -1; x <= 1; x++)
+    for(int x = -pcfRadius; x <= pcfRadius; x++)
     {
-        for(int y = -1; y <= 1; y++)
+        for(int y = -pcfRadius; y <= pcfRadius; y++)
         {
             float pcfDepth = texture(shadowMap, projCoords.xy + vec2(x, y) * texelSize).r; 
 
@@ -247,13 +380,22 @@ float CalcDirShadow(sampler2D shadowMap, vec3 tangentLightDir)
         }
     }
 
-    shadow /= 9;
+    shadow /= (2 * pcfRadius + 1) * (2 * pcfRadius + 1);
 
     return shadow;
 }
 
+// LightsObject returns true if a light with cullingMask lights the object's layers (see camera.LayerMask)
+bool LightsObject(uint cullingMask)
+{
+    return (cullingMask & layers) != 0u;
+}
+
 vec3 CalcDirLight()
 {
+    if (!LightsObject(dirLight.cullingMask))
+        return vec3(0);
+
     vec3 lightDir = normalize(-tangentDirLightDir);
 
     // Diffuse
@@ -271,27 +413,49 @@ vec3 CalcDirLight()
     return (finalDiffuse + finalSpecular) * (1 - shadow);
 }
 
-float CalcPointShadow(int lightIndex, vec3 worldLightPos, vec3 tangentLightDir, float maxBias, float nearPlane, float farPlane) {
+// Offsets used for PCF on cubemaps, which sample around the light to fragment direction
+const vec3 pointPcfOffsets[20] = vec3[](
+   vec3( 1,  1,  1), vec3( 1, -1,  1), vec3(-1, -1,  1), vec3(-1,  1,  1), 
+   vec3( 1,  1, -1), vec3( 1, -1, -1), vec3(-1, -1, -1), vec3(-1,  1, -1),
+   vec3( 1,  1,  0), vec3( 1, -1,  0), vec3(-1, -1,  0), vec3(-1,  1,  0),
+   vec3( 1,  0,  1), vec3(-1,  0,  1), vec3( 1,  0, -1), vec3(-1,  0, -1),
+   vec3( 0,  1,  1), vec3( 0, -1,  1), vec3( 0, -1, -1), vec3( 0,  1, -1)
+);
+
+float CalcPointShadow(int shadowLayer, vec3 worldLightPos, vec3 tangentLightDir, ShadowSettings shadowSettings) {
+
+    if (shadowSettings.enabled == 0 || shadowLayer < 0 || receiveShadows == 0)
+        return 0;
 
-    vec3 lightToFrag = fragPos - worldLightPos;
+    vec3 lightToFrag = fragPos + fragWorldNormal * shadowSettings.normalOffset - worldLightPos;
 
     // Get depth of current fragment
     float currentDepth = length(lightToFrag);
 
-    if (currentDepth < nearPlane) {
+    if (currentDepth < shadowSettings.nearPlane) {
         return 0;
     }
 
-    float closestDepth = texture(pointLightCubeShadowMaps, vec4(lightToFrag, lightIndex)).r;
+    float bias = max(shadowSettings.biasSlope * (1 - dot(normalizedVertNorm, tangentLightDir)), shadowSettings.biasConstant);
 
-    // We stored depth in the cubemap in the range [0, 1], so now we move back to [0, farPlane]
-    closestDepth *= farPlane;
+    // 'Percentage Close Filtering' with samples spread around the direction,
+    // where the spread grows with the pcf radius
+    int sampleCount = shadowSettings.pcfRadius == 0 ? 1 : 20;
+    float diskRadius = shadowSettings.pcfRadius * 0.02;
 
-    float bias = max(maxBias * (1 - dot(normalizedVertNorm, tangentLightDir)), 0.005);
+    float shadow = 0;
+    for (int i = 0; i < sampleCount; i++)
+    {
+        vec3 sampleDir = lightToFrag + (sampleCount == 1 ? vec3(0) : pointPcfOffsets[i] * diskRadius * currentDepth);
+        float closestDepth = texture(pointLightCubeShadowMaps, vec4(sampleDir, shadowLayer)).r;
 
-    float shadow = currentDepth - bias > closestDepth ? 1 : 0;
+        // We stored depth in the cubemap in the range [0, 1], so now we move back to [0, farPlane]
+        closestDepth *= shadowSettings.farPlane;
 
-    return shadow;
+        shadow += currentDepth - bias > closestDepth ? 1 : 0;
+    }
+
+    return shadow / sampleCount;
 }
 
 //
@@ -346,8 +510,8 @@ float AttenuateCusp(float dist, float radius, float falloff)
 
 vec3 CalcPointLight(PointLight pointLight, int lightIndex)
 {
-    // Ignore inactive lights
-    if (pointLight.radius == 0){
+    // Ignore inactive lights, and lights that don't light the object's layers
+    if (pointLight.radius == 0 || !LightsObject(pointLight.cullingMask)){
         return vec3(0);
     }
 
@@ -368,13 +532,17 @@ vec3 CalcPointLight(PointLight pointLight, int lightIndex)
     float attenuation = AttenuateNoCusp(distToLight, pointLight.radius, pointLight.falloff);
 
     // Shadow
-    float shadow = CalcPointShadow(lightIndex, pointLight.pos, tangentLightDir, pointLight.maxBias, pointLight.nearPlane, pointLight.farPlane);
+    float shadow = CalcPointShadow(pointLight.shadowLayer, pointLight.pos, tangentLightDir, pointLight.shadow);
 
     return (finalDiffuse + finalSpecular) * attenuation * (1 - shadow);
 }
 
 float CalcSpotShadow(vec3 tangentLightDir, int lightIndex)
 {
+    ShadowSettings shadowSettings = spotLights[lightIndex].shadow;
+    if (shadowSettings.enabled == 0 || receiveShadows == 0)
+        return 0;
+
     // Move from clip space to NDC
     vec3 projCoords = fragPosSpotLight[lightIndex].xyz / fragPosSpotLight[lightIndex].w;
 
@@ -388,17 +556,18 @@ float CalcSpotShadow(vec3 tangentLightDir, int lightIndex)
     // currentDepth is the fragment depth from the light's perspective
     float currentDepth = projCoords.z;
 
-    // Bias in the range [0.005, 0.05] depending on the angle, where a higher
+    // Bias in the range [biasConstant, biasSlope] depending on the angle, where a higher
     // angle gives a higher bias, as shadow acne gets worse with angle
-    float bias = max(0.05 * (1 - dot(normalizedVertNorm, tangentLightDir)), 0.005);
+    float bias = max(shadowSettings.biasSlope * (1 - dot(normalizedVertNorm, tangentLightDir)), shadowSettings.biasConstant);
 
     // 'Percentage Close Filtering'.
     // Basically get soft shadows by averaging this texel and surrounding ones
     float shadow = 0;
+    int pcfRadius = shadowSettings.pcfRadius;
     vec2 texelSize = 1 / textureSize(spotLightShadowMaps, 0).xy;
-    for(int x = -1; x <= 1; x++)
+    for(int x = -pcfRadius; x <= pcfRadius; x++)
     {
-        for(int y = -1; y <= 1; y++)
+        for(int y = -pcfRadius; y <= pcfRadius; y++)
         {
             float pcfDepth = texture(spotLightShadowMaps, vec3(projCoords.xy + vec2(x, y) * texelSize, lightIndex)).r; 
 
@@ -408,17 +577,38 @@ float CalcSpotShadow(vec3 tangentLightDir, int lightIndex)
         }
     }
 
-    shadow /= 9;
+    shadow /= (2 * pcfRadius + 1) * (2 * pcfRadius + 1);
 
     return shadow;
 }
 
+vec3 CalcSpotCookie(SpotLight light, int lightIndex)
+{
+    if (light.hasCookie == 0)
+        return vec3(1);
+
+    // Projected the same way as the shadow map but without the normal offset, which would distort the cookie.
+    // Projecting per fragment rather than per vertex also keeps the cookie correct on large triangles
+    vec4 lightClipPos = spotLightProjViewMats[lightIndex] * vec4(fragPos, 1);
+    if (lightClipPos.w <= 0)
+        return vec3(0);
+
+    // Move from clip space to [0, 1] uvs
+    vec2 cookieUV = (lightClipPos.xy / lightClipPos.w) * 0.5 + 0.5;
+
+    // Nothing is projected outside the cookie, regardless of the texture's wrap mode
+    if (cookieUV.x < 0 || cookieUV.x > 1 || cookieUV.y < 0 || cookieUV.y > 1)
+        return vec3(0);
+
+    return texture(spotLightCookies[lightIndex], cookieUV).rgb;
+}
+
 vec3 CalcSpotLight(SpotLight light, int lightIndex)
 {
     // The inner/outer cutoffs are cosine values,
     // which means a value of 1 is mainly produced when the input
     // is 0 degrees or radians. cos(180) will also be 1, but that's too much :)
-    if (light.innerCutoff == 1)
+    if (light.innerCutoff == 1 || !LightsObject(light.cullingMask))
         return vec3(0);
 
     vec3 tangentLightDir = tangentSpotLightDirections[lightIndex];
@@ -446,16 +636,214 @@ vec3 CalcSpotLight(SpotLight light, int lightIndex)
     // Shadow
     float shadow = CalcSpotShadow(fragToLightDir, lightIndex);
 
-    return (finalDiffuse + finalSpecular) * intensity * (1 - shadow);
+    return (finalDiffuse + finalSpecular) * intensity * (1 - shadow) * CalcSpotCookie(light, lightIndex);
 }
 
-#define DRAW_NORMALS false
+//
+// Area lights using 'Linearly Transformed Cosines', which are done in world space.
+// Based on: https://eheitzresearch.wordpress.com/415-2/
+//
+vec2 LtcLutUV(vec2 uv)
+{
+    // Sample texel centers so the edges of the tables aren't blended with the border
+    return uv * (LTC_LUT_SIZE - 1.0) / LTC_LUT_SIZE + 0.5 / LTC_LUT_SIZE;
+}
+
+// Integral of the clamped cosine over an edge of the polygon (as a vector form factor)
+vec3 IntegrateEdgeVec(vec3 v1, vec3 v2)
+{
+    float x = dot(v1, v2);
+    float y = abs(x);
+
+    float a = 0.8543985 + (0.4965155 + 0.0145206 * y) * y;
+    float b = 3.4175940 + (4.1616724 + y) * y;
+    float v = a / b;
+
+    float thetaSinTheta = x > 0.0 ? v : 0.5 * inversesqrt(max(1.0 - x * x, 1e-7)) - v;
+    return cross(v1, v2) * thetaSinTheta;
+}
+
+// LtcEvaluate integrates the cosine distribution transformed by minv over the rectangle of 'points'
+float LtcEvaluate(vec3 N, vec3 V, vec3 P, mat3 minv, vec3 points[4], bool twoSided)
+{
+    // Orthonormal basis around the normal, with the view direction in the xz plane
+    vec3 T1 = normalize(V - N * dot(V, N));
+    vec3 T2 = cross(N, T1);
+    minv = minv * transpose(mat3(T1, T2, N));
+
+    vec3 L[4];
+    L[0] = normalize(minv * (points[0] - P));
+    L[1] = normalize(minv * (points[1] - P));
+    L[2] = normalize(minv * (points[2] - P));
+    L[3] = normalize(minv * (points[3] - P));
+
+    // Whether the fragment is on the emitting side of the light
+    vec3 lightNormal = cross(points[1] - points[0], points[3] - points[0]);
+    bool inFront = dot(points[0] - P, lightNormal) < 0.0;
+
+    vec3 vsum = IntegrateEdgeVec(L[0], L[1]);
+    vsum += IntegrateEdgeVec(L[1], L[2]);
+    vsum += IntegrateEdgeVec(L[2], L[3]);
+    vsum += IntegrateEdgeVec(L[3], L[0]);
+
+    float len = length(vsum);
+    if (len == 0.0 || (!inFront && !twoSided))
+        return 0.0;
+
+    float z = vsum.z / len;
+    if (inFront)
+        z = -z;
+
+    // Instead of clipping the polygon to the horizon, a sphere with the same vector form factor is clipped using a table
+    float horizonScale = texture(ltcAmp, LtcLutUV(vec2(z * 0.5 + 0.5, len))).w;
+    return len * horizonScale;
+}
+
+vec3 CalcAreaLight(AreaLight light, vec3 worldNormal, float roughness)
+{
+    // Ignore inactive lights, and lights that don't light the object's layers
+    if (light.halfRight == vec3(0) || light.halfUp == vec3(0) || !LightsObject(light.cullingMask))
+        return vec3(0);
+
+    vec3 points[4];
+    points[0] = light.pos - light.halfRight - light.halfUp;
+    points[1] = light.pos + light.halfRight - light.halfUp;
+    points[2] = light.pos + light.halfRight + light.halfUp;
+    points[3] = light.pos - light.halfRight + light.halfUp;
+
+    vec3 V = normalize(camPos - fragPos);
+    float dotNV = clamp(dot(worldNormal, V), 0.0, 1.0);
+
+    vec2 lutUV = LtcLutUV(vec2(roughness, sqrt(1.0 - dotNV)));
+    vec4 t1 = texture(ltcMat, lutUV);
+    vec4 t2 = texture(ltcAmp, lutUV);
+    mat3 minv = mat3(
+        vec3(t1.x, 0, t1.y),
+        vec3(0, 1, 0),
+        vec3(t1.z, 0, t1.w)
+    );
+
+    bool twoSided = light.twoSided != 0;
+    float diffuseAmount = LtcEvaluate(worldNormal, V, fragPos, mat3(1), points, twoSided);
+    float specularAmount = LtcEvaluate(worldNormal, V, fragPos, minv, points, twoSided);
+
+    // The specular texture is used as the fresnel reflectance at zero degrees
+    vec3 specularScale = specularTexColor.rgb * t2.x + (1.0 - specularTexColor.rgb) * t2.y;
+
+    vec3 finalDiffuse = diffuseAmount * light.diffuseColor * diffuseTexColor.rgb;
+    vec3 finalSpecular = specularAmount * light.specularColor * specularScale;
+
+    return finalDiffuse + finalSpecular;
+}
+
+// CalcAmbientSH returns the irradiance (divided by pi) of ambientSH for a world space normal.
+// See lights.SH9.Irradiance which does the same
+vec3 CalcAmbientSH(vec3 n)
+{
+    // Basis constants multiplied by the cosine lobe constants of each band
+    const float c0 = 0.282095;
+    const float c1 = 0.488603 * (2.0 / 3.0);
+    const float c2 = 1.092548 * 0.25;
+    const float c20 = 0.315392 * 0.25;
+    const float c22 = 0.546274 * 0.25;
+
+    vec3 irradiance = c0 * ambientSH[0]
+        + c1 * (ambientSH[1] * n.y + ambientSH[2] * n.z + ambientSH[3] * n.x)
+        + c2 * (ambientSH[4] * n.x * n.y + ambientSH[5] * n.y * n.z + ambientSH[7] * n.x * n.z)
+        + c20 * ambientSH[6] * (3.0 * n.z * n.z - 1.0)
+        + c22 * ambientSH[8] * (n.x * n.x - n.y * n.y);
+
+    return max(irradiance, vec3(0));
+}
+
+// CalcFog returns how much fog is between the camera and worldPos in [0, 1]
+float CalcFog(vec3 worldPos)
+{
+    if (fogMode == 0)
+        return 0;
+
+    vec3 camToPos = worldPos - camPos;
+    float dist = max(length(camToPos) - fogStart, 0.0);
+
+    float fog;
+    if (fogMode == 1)
+        fog = dist / max(fogEnd - fogStart, 0.0001);
+    else if (fogMode == 2)
+        fog = 1.0 - exp(-fogDensity * dist);
+    else
+        fog = 1.0 - exp(-pow(fogDensity * dist, 2.0));
+
+    // Height fog density is exp(-falloff*(height-base)), and this is its average along the view ray
+    if (fogHeightFalloff > 0)
+    {
+        float camHeightDensity = exp(-fogHeightFalloff * (camPos.y - fogHeightBase));
+        float falloffAlongRay = fogHeightFalloff * camToPos.y;
+
+        float heightFactor = camHeightDensity;
+        if (abs(falloffAlongRay) > 0.0001)
+            heightFactor *= (1.0 - exp(-falloffAlongRay)) / falloffAlongRay;
+
+        fog *= heightFactor;
+    }
+
+    return clamp(fog, 0.0, 1.0);
+}
+
+#define DEBUG_VIEW_NORMALS 1
+#define DEBUG_VIEW_LIGHT_COUNT 2
+
+// Must match renderer.DebugViewHeatmapMax
+#define LIGHT_HEATMAP_MAX 8
+
+// CountLights returns how many point and spot lights reach the fragment, ignoring shadows
+int CountLights()
+{
+    int count = 0;
+    for (int i = 0; i < NUM_POINT_LIGHTS; i++)
+    {
+        if (pointLights[i].radius > 0 && LightsObject(pointLights[i].cullingMask) && length(tangentPointLightPositions[i] - tangentFragPos) < pointLights[i].radius)
+            count++;
+    }
+
+    for (int i = 0; i < NUM_SPOT_LIGHTS; i++)
+    {
+        if (spotLights[i].innerCutoff == 1 || !LightsObject(spotLights[i].cullingMask))
+            continue;
+
+        vec3 fragToLightDir = normalize(tangentSpotLightPositions[i] - tangentFragPos);
+        if (dot(fragToLightDir, normalize(-tangentSpotLightDirections[i])) > spotLights[i].outerCutoff)
+            count++;
+    }
+
+    return count;
+}
+
+// Heatmap goes black, blue, green, yellow then red as t goes from 0 to 1
+vec3 Heatmap(float t)
+{
+    const vec3 colors[5] = vec3[5](vec3(0), vec3(0, 0, 1), vec3(0, 1, 0), vec3(1, 1, 0), vec3(1, 0, 0));
+
+    float scaled = clamp(t, 0.0, 1.0) * 4.0;
+    int i = min(int(scaled), 3);
+    return mix(colors[i], colors[i + 1], scaled - float(i));
+}
 
 void main()
 {
     // Shared values
     tangentViewDir = normalize(tangentCamPos - tangentFragPos);
     diffuseTexColor = texture(material.diffuse, vertUV0);
+
+    // Alpha is sharpened to a pixel wide ramp around the cutoff, which alpha to coverage turns into antialiased edges,
+    // and without MSAA acts like a plain alpha test
+    float alpha = 1;
+    if (alphaCutoff > 0)
+    {
+        alpha = clamp((diffuseTexColor.a - alphaCutoff) / max(fwidth(diffuseTexColor.a), 0.0001) + 0.5, 0.0, 1.0);
+        if (alpha == 0)
+            discard;
+    }
+
     specularTexColor = texture(material.specular, vertUV0);
     emissionTexColor = texture(material.emission, vertUV0);
 
@@ -465,6 +853,10 @@ void main()
     // Remap normal to [-1,1]
     normalizedVertNorm = normalize(normalizedVertNorm * 2.0 - 1.0);
 
+    // Back faces are only drawn by two sided materials, and face the other way
+    if (!gl_FrontFacing)
+        normalizedVertNorm = -normalizedVertNorm;
+
     // Light contributions
     vec3 finalColor = CalcDirLight();
 
@@ -478,13 +870,38 @@ void main()
         finalColor += CalcSpotLight(spotLights[i], i);
     }
 
-    vec3 finalEmission = emissionTexColor.rgb;
+    // Area lights need the normal in world space, and a GGX roughness that roughly matches the blinn-phong shininess
+    vec3 worldTangent = normalize(fragWorldTangent - fragWorldNormal * dot(fragWorldTangent, fragWorldNormal));
+    mat3 worldTbnMtx = mat3(worldTangent, cross(fragWorldNormal, worldTangent), fragWorldNormal);
+    vec3 worldNormal = normalize(worldTbnMtx * normalizedVertNorm);
+    float roughness = sqrt(sqrt(2.0 / (material.shininess + 2.0)));
+
+    for (int i = 0; i < NUM_AREA_LIGHTS; i++)
+    {
+        finalColor += CalcAreaLight(areaLights[i], worldNormal, roughness);
+    }
+
+    vec3 finalEmission = emissionTexColor.rgb * emissiveColor * emissiveIntensity;
     vec3 finalAmbient = ambientColor * diffuseTexColor.rgb;
+    if (hasAmbientSH == 1)
+        finalAmbient = CalcAmbientSH(worldNormal) * diffuseTexColor.rgb;
 
-    fragColor = vec4(finalColor + finalAmbient + finalEmission, 1);
+    if (vertLightmapUV.z > 0)
+        finalAmbient = texture(lightmap, vertLightmapUV.xy).rgb * diffuseTexColor.rgb;
 
-    if (DRAW_NORMALS)
+    finalAmbient *= vertAo;
+
+    fragColor = vec4(mix(finalColor + finalAmbient + finalEmission, fogColor, CalcFog(fragPos)), alpha);
+
+    if (debugView == DEBUG_VIEW_NORMALS)
     {
         fragColor = vec4(texture(material.normal, vertUV0).rgb, 1);
     }
+    else if (debugView == DEBUG_VIEW_LIGHT_COUNT)
+    {
+        // Dimmed lighting under the heatmap keeps the scene readable
+        float brightness = dot(finalColor + finalAmbient, vec3(0.2126, 0.7152, 0.0722));
+        vec3 heat = Heatmap(float(CountLights()) / float(LIGHT_HEATMAP_MAX));
+        fragColor = vec4(heat * 0.8 + clamp(brightness, 0.0, 1.0) * 0.2, 1);
+    }
 }
@@ -3,7 +3,7 @@
 
 layout(location=0) in vec3 vertPosIn;
 layout(location=1) in vec3 vertNormalIn;
-layout(location=2) in vec3 vertTangentIn;
+layout(location=2) in vec4 vertTangentIn;
 layout(location=3) in vec2 vertUV0In;
 layout(location=4) in vec3 vertColorIn;
 
@@ -27,7 +27,43 @@ out vec4 fragColor;
 
 uniform samplerCube skybox;
 
+// Multiplies the sky colors, e.g. to darken it at night
+uniform vec3 skyTint;
+
+// See renderer.SkyboxSettings. The yaw is in radians
+uniform float skyboxIntensity = 1;
+uniform float skyboxYaw = 0;
+uniform vec3 skyboxTint = vec3(1);
+
+// See renderer.Fog
+layout (std140) uniform Fog {
+    vec3 fogColor;
+    int fogMode;
+    float fogDensity;
+    float fogStart;
+    float fogEnd;
+    float fogHeightFalloff;
+    float fogHeightBase;
+    float fogSkyBlend;
+};
+
+// Turns the sample direction the opposite way of the yaw, which turns the sky by the yaw
+vec3 RotateYaw(vec3 dir, float yaw)
+{
+    float c = cos(yaw);
+    float s = sin(yaw);
+    return vec3(c * dir.x - s * dir.z, dir.y, s * dir.x + c * dir.z);
+}
+
 void main()
 {
-    fragColor = texture(skybox, vertUV0);
+    fragColor = texture(skybox, RotateYaw(vertUV0, skyboxYaw));
+    fragColor.rgb *= skyTint * skyboxTint * skyboxIntensity;
+
+    // Fog covers the horizon and fades out looking up
+    if (fogMode != 0)
+    {
+        float skyFog = fogSkyBlend * (1.0 - smoothstep(0.0, 0.4, normalize(vertUV0).y));
+        fragColor.rgb = mix(fragColor.rgb, fogColor, skyFog);
+    }
 } 
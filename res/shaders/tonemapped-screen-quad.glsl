@@ -32,19 +32,98 @@ struct Material {
 uniform float exposure = 1;
 uniform Material material;
 
+// Bloom (see postprocess.Bloom) is half the size of the scene and is added before tonemapping
+uniform sampler2D bloomTex;
+uniform float bloomIntensity = 0;
+
+// The scene may be rendered smaller than the window (see renderer.DynamicResolution), and is upsampled here.
+// 0 is bilinear and 1 is FSR1 style (Catmull-Rom upsample then contrast adaptive sharpening)
+uniform int upsampleMode = 0;
+uniform float sharpness = 0.5;
+
 in vec2 vertUV0;
 
 out vec4 fragColor;
 
-void main()
+vec3 Tonemap(vec3 color)
 {
-    vec4 diffuseTexColor = texture(material.diffuse, vertUV0);
-
     // Reinhard tone mapping
-    // vec3 mappedColor = diffuseTexColor.rgb / (diffuseTexColor.rgb + vec3(1.0));
+    // return color / (color + vec3(1.0));
 
     // Exposure tone mapping
-    vec3 mappedColor = vec3(1.0) - exp(-diffuseTexColor.rgb * exposure);
+    return vec3(1.0) - exp(-color * exposure);
+}
+
+vec3 SampleTonemapped(vec2 uv)
+{
+    vec3 color = texture(material.diffuse, uv).rgb + texture(bloomTex, uv).rgb * bloomIntensity;
+    return Tonemap(color);
+}
+
+// Catmull-Rom upsample done with 5 bilinear reads instead of 16 point reads, skipping the corners whose weights are tiny.
+// Filtering is done after tonemapping so very bright pixels don't ring
+vec3 SampleCatmullRom(vec2 uv, vec2 texSize)
+{
+    vec2 samplePos = uv * texSize;
+    vec2 texPos1 = floor(samplePos - 0.5) + 0.5;
+    vec2 f = samplePos - texPos1;
+
+    vec2 w0 = f * (-0.5 + f * (1.0 - 0.5 * f));
+    vec2 w1 = 1.0 + f * f * (-2.5 + 1.5 * f);
+    vec2 w2 = f * (0.5 + f * (2.0 - 1.5 * f));
+    vec2 w3 = f * f * (-0.5 + 0.5 * f);
+
+    vec2 w12 = w1 + w2;
+    vec2 offset12 = w2 / w12;
+
+    vec2 texPos0 = (texPos1 - 1.0) / texSize;
+    vec2 texPos3 = (texPos1 + 2.0) / texSize;
+    vec2 texPos12 = (texPos1 + offset12) / texSize;
+
+    vec3 result = SampleTonemapped(vec2(texPos12.x, texPos0.y)) * w12.x * w0.y;
+    result += SampleTonemapped(vec2(texPos0.x, texPos12.y)) * w0.x * w12.y;
+    result += SampleTonemapped(vec2(texPos12.x, texPos12.y)) * w12.x * w12.y;
+    result += SampleTonemapped(vec2(texPos3.x, texPos12.y)) * w3.x * w12.y;
+    result += SampleTonemapped(vec2(texPos12.x, texPos3.y)) * w12.x * w3.y;
+
+    float weightSum = w12.x * w0.y + w0.x * w12.y + w12.x * w12.y + w3.x * w12.y + w12.x * w3.y;
+    return max(result / weightSum, vec3(0.0));
+}
+
+// Sharpens the upsampled color using its neighbours in the scene texture. Like FSR1's RCAS, sharpening is
+// limited where the neighbourhood has high contrast so edges don't get halos
+vec3 SharpenContrastAdaptive(vec3 center, vec2 uv, vec2 texelSize)
+{
+    vec3 n = SampleTonemapped(uv + vec2(0.0, texelSize.y));
+    vec3 s = SampleTonemapped(uv - vec2(0.0, texelSize.y));
+    vec3 e = SampleTonemapped(uv + vec2(texelSize.x, 0.0));
+    vec3 w = SampleTonemapped(uv - vec2(texelSize.x, 0.0));
+
+    vec3 minColor = min(center, min(min(n, s), min(e, w)));
+    vec3 maxColor = max(center, max(max(n, s), max(e, w)));
+
+    // How far the neighbourhood is from clipping decides how much it can be sharpened
+    vec3 headroom = min(minColor, 1.0 - maxColor);
+    vec3 amount = sqrt(clamp(headroom / max(maxColor, 0.0001), 0.0, 1.0));
+
+    vec3 lobe = -amount * mix(0.0, 0.2, sharpness);
+    vec3 sharpened = (center + (n + s + e + w) * lobe) / (1.0 + 4.0 * lobe);
+    return clamp(sharpened, minColor, maxColor);
+}
+
+void main()
+{
+    vec3 mappedColor;
+    if (upsampleMode == 1)
+    {
+        vec2 texSize = vec2(textureSize(material.diffuse, 0));
+        mappedColor = SampleCatmullRom(vertUV0, texSize);
+        mappedColor = SharpenContrastAdaptive(mappedColor, vertUV0, 1.0 / texSize);
+    }
+    else
+    {
+        mappedColor = SampleTonemapped(vertUV0);
+    }
 
     fragColor = vec4(mappedColor, 1);
 }
@@ -0,0 +1,226 @@
+package rng
+
+import (
+	"math"
+	"math/rand/v2"
+
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/assert"
+)
+
+// Rng is a deterministic random number generator, where the same seed always produces the same sequence.
+// Rng is not safe for concurrent use
+type Rng struct {
+	seed uint64
+	pcg  rand.PCG
+	r    *rand.Rand
+}
+
+func (r *Rng) Seed() uint64 {
+	return r.seed
+}
+
+// Reseed restarts the sequence from a new seed
+func (r *Rng) Reseed(seed uint64) {
+	r.seed = seed
+	r.pcg.Seed(seed, splitMix64(seed))
+}
+
+// Uint64 returns a random uint64
+func (r *Rng) Uint64() uint64 {
+	return r.r.Uint64()
+}
+
+// Float32 returns a random float in [0,1)
+func (r *Rng) Float32() float32 {
+	return r.r.Float32()
+}
+
+// Range returns a random float in [min,max)
+func (r *Rng) Range(min, max float32) float32 {
+	return min + r.r.Float32()*(max-min)
+}
+
+// Int returns a random int in [0,n). Panics if n <= 0
+func (r *Rng) Int(n int) int {
+	return r.r.IntN(n)
+}
+
+// IntRange returns a random int in [min,max], with both ends included. Panics if max < min
+func (r *Rng) IntRange(min, max int) int {
+	return min + r.r.IntN(max-min+1)
+}
+
+func (r *Rng) Bool() bool {
+	return r.r.Uint64()&1 == 1
+}
+
+// Chance returns true with probability p, where p is in [0,1]
+func (r *Rng) Chance(p float32) bool {
+	return r.r.Float32() < p
+}
+
+// Sign returns -1 or 1 with equal probability
+func (r *Rng) Sign() float32 {
+
+	if r.Bool() {
+		return 1
+	}
+
+	return -1
+}
+
+// Angle returns a random angle in radians in [0,2*Pi)
+func (r *Rng) Angle() float32 {
+	return r.r.Float32() * 2 * gglm.Pi
+}
+
+// OnUnitCircle returns a random point on the edge of a circle of radius 1
+func (r *Rng) OnUnitCircle() gglm.Vec2 {
+	sin, cos := gglm.Sincos32(r.Angle())
+	return gglm.NewVec2(cos, sin)
+}
+
+// InsideUnitCircle returns a random point inside a circle of radius 1, uniformly distributed over its area
+func (r *Rng) InsideUnitCircle() gglm.Vec2 {
+
+	// Sqrt so points aren't bunched up near the center
+	radius := gglm.Sqrt32(r.r.Float32())
+	sin, cos := gglm.Sincos32(r.Angle())
+	return gglm.NewVec2(cos*radius, sin*radius)
+}
+
+// OnUnitSphere returns a random unit vector, uniformly distributed over the surface of the sphere
+func (r *Rng) OnUnitSphere() gglm.Vec3 {
+
+	// Picking z uniformly gives a uniform distribution over the surface (Archimedes' hat-box theorem)
+	z := r.Range(-1, 1)
+	radius := gglm.Sqrt32(1 - z*z)
+	sin, cos := gglm.Sincos32(r.Angle())
+	return gglm.NewVec3(cos*radius, sin*radius, z)
+}
+
+// InsideUnitSphere returns a random point inside a sphere of radius 1, uniformly distributed over its volume
+func (r *Rng) InsideUnitSphere() gglm.Vec3 {
+
+	// Cube root so points aren't bunched up near the center
+	v := r.OnUnitSphere()
+	v.Scale(float32(math.Cbrt(float64(r.r.Float32()))))
+	return v
+}
+
+// InCone returns a random unit vector within halfAngleRad of dir, uniformly distributed over the cone's cap.
+// dir does not need to be normalized
+func (r *Rng) InCone(dir *gglm.Vec3, halfAngleRad float32) gglm.Vec3 {
+
+	forward := *dir.Clone().Normalize()
+
+	// Same idea as OnUnitSphere, but z is limited to the cap of the cone
+	z := r.Range(gglm.Cos32(halfAngleRad), 1)
+	radius := gglm.Sqrt32(1 - z*z)
+	sin, cos := gglm.Sincos32(r.Angle())
+
+	// Build an orthonormal basis around forward, using whichever axis is least parallel to it
+	up := gglm.NewVec3(0, 1, 0)
+	if gglm.Abs32(forward.Y()) > 0.99 {
+		up = gglm.NewVec3(1, 0, 0)
+	}
+
+	right := gglm.Cross(&up, &forward)
+	right.Normalize()
+	up = gglm.Cross(&forward, &right)
+
+	return gglm.NewVec3(
+		right.Data[0]*cos*radius+up.Data[0]*sin*radius+forward.Data[0]*z,
+		right.Data[1]*cos*radius+up.Data[1]*sin*radius+forward.Data[1]*z,
+		right.Data[2]*cos*radius+up.Data[2]*sin*radius+forward.Data[2]*z,
+	)
+}
+
+// InsideBox returns a random point inside the box defined by the min and max corners
+func (r *Rng) InsideBox(min, max *gglm.Vec3) gglm.Vec3 {
+	return gglm.NewVec3(
+		r.Range(min.Data[0], max.Data[0]),
+		r.Range(min.Data[1], max.Data[1]),
+		r.Range(min.Data[2], max.Data[2]),
+	)
+}
+
+// WeightedIndex returns a random index into weights, where each index has a chance proportional to its weight.
+// Negative weights count as zero. Returns -1 if there are no positive weights
+func (r *Rng) WeightedIndex(weights []float32) int {
+
+	var total float32
+	for i := 0; i < len(weights); i++ {
+		if weights[i] > 0 {
+			total += weights[i]
+		}
+	}
+
+	if total <= 0 {
+		return -1
+	}
+
+	pick := r.r.Float32() * total
+	lastPositive := -1
+	for i := 0; i < len(weights); i++ {
+
+		if weights[i] <= 0 {
+			continue
+		}
+
+		pick -= weights[i]
+		if pick < 0 {
+			return i
+		}
+
+		lastPositive = i
+	}
+
+	// Float rounding can leave pick slightly above zero at the end
+	return lastPositive
+}
+
+// Shuffle randomly reorders the slice in place
+func Shuffle[T any](r *Rng, s []T) {
+	r.r.Shuffle(len(s), func(i, j int) {
+		s[i], s[j] = s[j], s[i]
+	})
+}
+
+// Choice returns a random element of the slice. Panics if the slice is empty
+func Choice[T any](r *Rng, s []T) T {
+	return s[r.r.IntN(len(s))]
+}
+
+// WeightedChoice returns a random element of items, where each has a chance proportional to its weight.
+// items and weights must be the same length. Returns false if there are no positive weights
+func WeightedChoice[T any](r *Rng, items []T, weights []float32) (T, bool) {
+
+	assert.T(len(items) == len(weights), "WeightedChoice got %d items but %d weights", len(items), len(weights))
+
+	i := r.WeightedIndex(weights)
+	if i == -1 {
+		var zero T
+		return zero, false
+	}
+
+	return items[i], true
+}
+
+func New(seed uint64) *Rng {
+
+	r := &Rng{}
+	r.r = rand.New(&r.pcg)
+	r.Reseed(seed)
+
+	return r
+}
+
+// splitMix64 scrambles a seed, which is used to derive well distributed seeds from similar inputs (e.g. 1, 2, 3)
+func splitMix64(x uint64) uint64 {
+	x += 0x9E3779B97F4A7C15
+	x = (x ^ (x >> 30)) * 0xBF58476D1CE4E5B9
+	x = (x ^ (x >> 27)) * 0x94D049BB133111EB
+	return x ^ (x >> 31)
+}
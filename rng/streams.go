@@ -0,0 +1,77 @@
+package rng
+
+// StreamId identifies a named global random stream. Each stream has its own sequence, so that for example
+// spawning more particles (vfx) doesn't change what the gameplay stream produces, which keeps replays deterministic
+type StreamId uint8
+
+const (
+	// StreamId_Gameplay must only be used by simulation logic that needs to be replayed
+	StreamId_Gameplay StreamId = iota
+
+	// StreamId_Vfx is for cosmetic randomness that doesn't affect the simulation (particles, screen shake etc)
+	StreamId_Vfx
+
+	// StreamId_Procedural is for generating content (levels, loot tables etc) from a seed
+	StreamId_Procedural
+
+	streamCount
+)
+
+func (s StreamId) String() string {
+	switch s {
+	case StreamId_Gameplay:
+		return "Gameplay"
+	case StreamId_Vfx:
+		return "Vfx"
+	case StreamId_Procedural:
+		return "Procedural"
+	default:
+		return "Unknown"
+	}
+}
+
+var (
+	masterSeed uint64
+	streams    [streamCount]*Rng
+)
+
+func init() {
+	SeedAll(0)
+}
+
+// SeedAll reseeds all streams from one master seed, with each stream getting its own seed derived from it.
+// Store the master seed with a replay to reproduce the same random sequences
+func SeedAll(seed uint64) {
+
+	masterSeed = seed
+	for i := StreamId(0); i < streamCount; i++ {
+
+		streamSeed := splitMix64(seed ^ splitMix64(uint64(i)+1))
+		if streams[i] == nil {
+			streams[i] = New(streamSeed)
+		} else {
+			streams[i].Reseed(streamSeed)
+		}
+	}
+}
+
+func MasterSeed() uint64 {
+	return masterSeed
+}
+
+// Stream returns one of the global streams. The returned pointer stays valid after SeedAll
+func Stream(id StreamId) *Rng {
+	return streams[id]
+}
+
+func Gameplay() *Rng {
+	return streams[StreamId_Gameplay]
+}
+
+func Vfx() *Rng {
+	return streams[StreamId_Vfx]
+}
+
+func Procedural() *Rng {
+	return streams[StreamId_Procedural]
+}
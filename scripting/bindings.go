@@ -0,0 +1,183 @@
+package scripting
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/bloeys/nmage/assets"
+	"github.com/bloeys/nmage/input"
+	"github.com/bloeys/nmage/timing"
+	"github.com/veandco/go-sdl2/sdl"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// registerBindings exposes engine functionality to the script as global tables:
+//
+//	transform.get_pos() -> x, y, z
+//	transform.set_pos(x, y, z)
+//	transform.translate(x, y, z)
+//	transform.rotate(radians, axisX, axisY, axisZ)
+//	transform.scale(x, y, z)
+//
+//	input.key_down(name), input.key_clicked(name), input.key_released(name)  -- name is an SDL key name like "W" or "Space"
+//	input.mouse_down(button), input.mouse_clicked(button)                     -- 1=left, 2=middle, 3=right
+//	input.mouse_motion() -> dx, dy
+//
+//	assets.load_texture(path) -> texture id, or nil and an error message
+//
+//	time.dt() -> frame time in seconds
+//	time.elapsed() -> seconds since the game started
+func registerBindings(L *lua.LState, s *ScriptComp) {
+
+	L.SetGlobal("transform", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+
+		"get_pos": func(L *lua.LState) int {
+
+			if s.Transform == nil {
+				L.RaiseError("script has no transform")
+				return 0
+			}
+
+			L.Push(lua.LNumber(s.Transform.Data[3][0]))
+			L.Push(lua.LNumber(s.Transform.Data[3][1]))
+			L.Push(lua.LNumber(s.Transform.Data[3][2]))
+			return 3
+		},
+
+		"set_pos": func(L *lua.LState) int {
+
+			if s.Transform == nil {
+				L.RaiseError("script has no transform")
+				return 0
+			}
+
+			s.Transform.Data[3][0] = float32(L.CheckNumber(1))
+			s.Transform.Data[3][1] = float32(L.CheckNumber(2))
+			s.Transform.Data[3][2] = float32(L.CheckNumber(3))
+			return 0
+		},
+
+		"translate": func(L *lua.LState) int {
+
+			if s.Transform == nil {
+				L.RaiseError("script has no transform")
+				return 0
+			}
+
+			s.Transform.Translate(float32(L.CheckNumber(1)), float32(L.CheckNumber(2)), float32(L.CheckNumber(3)))
+			return 0
+		},
+
+		"rotate": func(L *lua.LState) int {
+
+			if s.Transform == nil {
+				L.RaiseError("script has no transform")
+				return 0
+			}
+
+			s.Transform.Rotate(float32(L.CheckNumber(1)), float32(L.CheckNumber(2)), float32(L.CheckNumber(3)), float32(L.CheckNumber(4)))
+			return 0
+		},
+
+		"scale": func(L *lua.LState) int {
+
+			if s.Transform == nil {
+				L.RaiseError("script has no transform")
+				return 0
+			}
+
+			s.Transform.Scale(float32(L.CheckNumber(1)), float32(L.CheckNumber(2)), float32(L.CheckNumber(3)))
+			return 0
+		},
+	}))
+
+	L.SetGlobal("input", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+
+		"key_down": func(L *lua.LState) int {
+			L.Push(lua.LBool(input.KeyDown(checkKeycode(L, 1))))
+			return 1
+		},
+
+		"key_clicked": func(L *lua.LState) int {
+			L.Push(lua.LBool(input.KeyClicked(checkKeycode(L, 1))))
+			return 1
+		},
+
+		"key_released": func(L *lua.LState) int {
+			L.Push(lua.LBool(input.KeyReleased(checkKeycode(L, 1))))
+			return 1
+		},
+
+		"mouse_down": func(L *lua.LState) int {
+			L.Push(lua.LBool(input.MouseDown(L.CheckInt(1))))
+			return 1
+		},
+
+		"mouse_clicked": func(L *lua.LState) int {
+			L.Push(lua.LBool(input.MouseClicked(L.CheckInt(1))))
+			return 1
+		},
+
+		"mouse_motion": func(L *lua.LState) int {
+			dx, dy := input.GetMouseMotion()
+			L.Push(lua.LNumber(dx))
+			L.Push(lua.LNumber(dy))
+			return 2
+		},
+	}))
+
+	L.SetGlobal("assets", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+
+		"load_texture": func(L *lua.LState) int {
+
+			path := L.CheckString(1)
+			loadOptions := &assets.TextureLoadOptions{TryLoadFromCache: true, WriteToCache: true, GenMipMaps: true}
+
+			var tex assets.Texture
+			var err error
+			switch strings.ToLower(filepath.Ext(path)) {
+			case ".png":
+				tex, err = assets.LoadTexturePNG(path, loadOptions)
+			case ".jpg", ".jpeg":
+				tex, err = assets.LoadTextureJpeg(path, loadOptions)
+			default:
+				L.Push(lua.LNil)
+				L.Push(lua.LString("unsupported texture format: " + path))
+				return 2
+			}
+
+			if err != nil {
+				L.Push(lua.LNil)
+				L.Push(lua.LString(err.Error()))
+				return 2
+			}
+
+			L.Push(lua.LNumber(tex.TexID))
+			return 1
+		},
+	}))
+
+	L.SetGlobal("time", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+
+		"dt": func(L *lua.LState) int {
+			L.Push(lua.LNumber(timing.DT()))
+			return 1
+		},
+
+		"elapsed": func(L *lua.LState) int {
+			L.Push(lua.LNumber(timing.ElapsedTime()))
+			return 1
+		},
+	}))
+}
+
+func checkKeycode(L *lua.LState, argIndex int) sdl.Keycode {
+
+	name := L.CheckString(argIndex)
+	kc := sdl.GetKeyFromName(name)
+	if kc == sdl.K_UNKNOWN {
+		L.ArgError(argIndex, "unknown key name: "+name)
+	}
+
+	return kc
+}
@@ -0,0 +1,151 @@
+package scripting
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/assets"
+	"github.com/bloeys/nmage/entity"
+	"github.com/bloeys/nmage/logging"
+	"github.com/bloeys/nmage/registry"
+	"github.com/bloeys/nmage/timing"
+	lua "github.com/yuin/gopher-lua"
+)
+
+var _ entity.Comp = &ScriptComp{}
+
+// ScriptComp is a component whose behavior is implemented in a Lua script.
+//
+// The script can define any of the following global functions, which are called by the component:
+//
+//	function init() end       -- Called once when the component is added to an entity, and after every hot reload
+//	function update(dt) end   -- Called every frame, with dt being the frame time in seconds
+//	function destroy() end    -- Called when the component is destroyed
+//
+// Scripts have access to the 'transform', 'input', 'assets' and 'time' tables (see bindings.go).
+// Script errors are logged rather than crashing the game, so scripts can be fixed and hot reloaded while the game runs.
+type ScriptComp struct {
+	entity.BaseComp
+
+	Path string
+
+	// Transform is what the 'transform' table in the script modifies. Can be nil
+	Transform *gglm.TrMat
+
+	// HotReload makes the component reload the script when its file changes on disk
+	HotReload bool
+
+	L           *lua.LState `json:"-"`
+	fileModTime time.Time
+}
+
+func (s *ScriptComp) Name() string {
+	return "Script Component"
+}
+
+func (s *ScriptComp) Init(parentHandle registry.Handle) {
+
+	s.BaseComp.Init(parentHandle)
+
+	err := s.Load()
+	if err != nil {
+		logging.ErrLog.Println(err)
+		return
+	}
+
+	s.callIfExists("init")
+}
+
+func (s *ScriptComp) Update() {
+
+	if s.HotReload {
+		s.reloadIfChanged()
+	}
+
+	if s.L == nil {
+		return
+	}
+
+	s.callIfExists("update", lua.LNumber(timing.DT()))
+}
+
+func (s *ScriptComp) Destroy() {
+
+	if s.L == nil {
+		return
+	}
+
+	s.callIfExists("destroy")
+	s.L.Close()
+	s.L = nil
+}
+
+// Load (re)creates the Lua state of the component and runs the script file.
+// On failure the previous state (if any) is kept
+func (s *ScriptComp) Load() error {
+
+	fileInfo, err := os.Stat(assets.ResolvePath(s.Path))
+	if err != nil {
+		return fmt.Errorf("failed to load script '%s'. Err: %w", s.Path, err)
+	}
+
+	newL := lua.NewState()
+	registerBindings(newL, s)
+
+	err = newL.DoFile(assets.ResolvePath(s.Path))
+	if err != nil {
+		newL.Close()
+		return fmt.Errorf("failed to run script '%s'. Err: %w", s.Path, err)
+	}
+
+	if s.L != nil {
+		s.L.Close()
+	}
+
+	s.L = newL
+	s.fileModTime = fileInfo.ModTime()
+	return nil
+}
+
+func (s *ScriptComp) reloadIfChanged() {
+
+	fileInfo, err := os.Stat(assets.ResolvePath(s.Path))
+	if err != nil || fileInfo.ModTime().Equal(s.fileModTime) {
+		return
+	}
+
+	// Update mod time even on failure so we don't keep reloading a broken script every frame
+	s.fileModTime = fileInfo.ModTime()
+
+	err = s.Load()
+	if err != nil {
+		logging.ErrLog.Println(err)
+		return
+	}
+
+	logging.InfoLog.Printf("Reloaded script '%s'\n", s.Path)
+	s.callIfExists("init")
+}
+
+// callIfExists calls the global function if the script defines it, and logs any errors
+func (s *ScriptComp) callIfExists(funcName string, args ...lua.LValue) {
+
+	fn, ok := s.L.GetGlobal(funcName).(*lua.LFunction)
+	if !ok {
+		return
+	}
+
+	err := s.L.CallByParam(lua.P{Fn: fn, NRet: 0, Protect: true}, args...)
+	if err != nil {
+		logging.ErrLog.Printf("Error in function '%s' of script '%s'. Err: %v\n", funcName, s.Path, err)
+	}
+}
+
+func NewScriptComp(path string, transform *gglm.TrMat) *ScriptComp {
+	return &ScriptComp{
+		Path:      path,
+		Transform: transform,
+	}
+}
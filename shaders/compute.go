@@ -0,0 +1,91 @@
+package shaders
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/bloeys/nmage/assets"
+	"github.com/bloeys/nmage/glutil"
+	"github.com/bloeys/nmage/leakcheck"
+	"github.com/bloeys/nmage/logging"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+var (
+	isComputeSupported  bool
+	computeSupportOnce  sync.Once
+	computeShaderMarker = []byte("//shader:compute")
+	shaderTypeMarker    = []byte("//shader:")
+)
+
+// IsComputeSupported returns true if the context supports compute shaders, which is core since OpenGL 4.3.
+// Must be called after the GL context is created
+func IsComputeSupported() bool {
+
+	computeSupportOnce.Do(func() {
+
+		var major, minor int32
+		gl.GetIntegerv(gl.MAJOR_VERSION, &major)
+		gl.GetIntegerv(gl.MINOR_VERSION, &minor)
+		if major > 4 || (major == 4 && minor >= 3) {
+			isComputeSupported = true
+			return
+		}
+
+		isComputeSupported = glutil.HasGlExtension("GL_ARB_compute_shader")
+	})
+
+	return isComputeSupported
+}
+
+// LoadAndCompileComputeShader loads a file with a single compute shader. Like combined shaders it may start
+// with '//shader:compute', but no other shader types are allowed in it
+func LoadAndCompileComputeShader(shaderPath string) (ShaderProgram, error) {
+
+	src, err := os.ReadFile(assets.ResolvePath(shaderPath))
+	if err != nil {
+		logging.ErrLog.Println("Failed to read shader. Err: ", err)
+		return ShaderProgram{}, err
+	}
+
+	return LoadAndCompileComputeShaderSrc(src)
+}
+
+func LoadAndCompileComputeShaderSrc(shaderSrc []byte) (ShaderProgram, error) {
+
+	if !IsComputeSupported() {
+		return ShaderProgram{}, errors.New("failed to create compute shader because compute shaders need OpenGL 4.3 or GL_ARB_compute_shader")
+	}
+
+	src := bytes.TrimSpace(shaderSrc)
+	src, _ = bytes.CutPrefix(src, computeShaderMarker)
+	if bytes.Contains(src, shaderTypeMarker) {
+		return ShaderProgram{}, errors.New("failed to create compute shader because a compute shader file can only have one shader, optionally starting with '//shader:compute'")
+	}
+
+	shdrProg, err := NewShaderProgram()
+	if err != nil {
+		return ShaderProgram{}, errors.New("failed to create new shader program. Err: " + err.Error())
+	}
+
+	shdr, err := CompileShaderOfType(src, ShaderType_Compute)
+	if err != nil {
+		leakcheck.Untrack(leakcheck.ResourceType_Program, shdrProg.Id)
+		gl.DeleteProgram(shdrProg.Id)
+		return ShaderProgram{}, err
+	}
+
+	shdrProg.AttachShader(shdr)
+	shdrProg.Link()
+
+	if err := shdrProg.LinkErr(); err != nil {
+		leakcheck.Untrack(leakcheck.ResourceType_Program, shdrProg.Id)
+		gl.DeleteProgram(shdrProg.Id)
+		return ShaderProgram{}, fmt.Errorf("failed to link compute shader. Err: %w", err)
+	}
+
+	return shdrProg, nil
+}
@@ -1,15 +1,43 @@
 package shaders
 
 import (
+	"errors"
+	"strings"
+
+	"github.com/bloeys/nmage/leakcheck"
 	"github.com/bloeys/nmage/logging"
 	"github.com/go-gl/gl/v4.1-core/gl"
 )
 
+type TransformFeedbackMode int32
+
+const (
+	// TransformFeedbackMode_Interleaved writes all captured varyings into one buffer, one vertex after the other
+	TransformFeedbackMode_Interleaved TransformFeedbackMode = iota
+	// TransformFeedbackMode_Separate writes each captured varying into its own buffer, where varying i goes to buffer index i
+	TransformFeedbackMode_Separate
+)
+
+func (m TransformFeedbackMode) ToGl() uint32 {
+
+	switch m {
+	case TransformFeedbackMode_Interleaved:
+		return gl.INTERLEAVED_ATTRIBS
+	case TransformFeedbackMode_Separate:
+		return gl.SEPARATE_ATTRIBS
+
+	default:
+		logging.ErrLog.Fatalf("Unknown transform feedback mode '%d'\n", m)
+		return 0
+	}
+}
+
 type ShaderProgram struct {
 	Id           uint32
 	VertShaderId uint32
 	FragShaderId uint32
 	GeomShaderId uint32
+	CompShaderId uint32
 }
 
 func (sp *ShaderProgram) AttachShader(shader Shader) {
@@ -22,26 +50,102 @@ func (sp *ShaderProgram) AttachShader(shader Shader) {
 		sp.FragShaderId = shader.Id
 	case ShaderType_Geometry:
 		sp.GeomShaderId = shader.Id
+	case ShaderType_Compute:
+		sp.CompShaderId = shader.Id
 	default:
 		logging.ErrLog.Fatalf("Unknown shader type '%d' for shader id '%d'\n", shader.Type, shader.Id)
 	}
 }
 
+// SetTransformFeedbackVaryings sets the vertex (or geometry) shader outputs that are captured during transform feedback.
+// Must be called before Link
+func (sp *ShaderProgram) SetTransformFeedbackVaryings(varyings []string, mode TransformFeedbackMode) {
+
+	if len(varyings) == 0 {
+		return
+	}
+
+	cStrs, free := gl.Strs(varyingsWithNullTerminators(varyings)...)
+	defer free()
+
+	gl.TransformFeedbackVaryings(sp.Id, int32(len(varyings)), cStrs, mode.ToGl())
+}
+
+func varyingsWithNullTerminators(varyings []string) []string {
+
+	out := make([]string, len(varyings))
+	for i := 0; i < len(varyings); i++ {
+		out[i] = varyings[i] + "\x00"
+	}
+
+	return out
+}
+
+// LinkErr returns the link error of the program if linking failed, otherwise returns nil. Must be called after Link
+func (sp *ShaderProgram) LinkErr() error {
+
+	var linkedSuccessfully int32
+	gl.GetProgramiv(sp.Id, gl.LINK_STATUS, &linkedSuccessfully)
+	if linkedSuccessfully == gl.TRUE {
+		return nil
+	}
+
+	var logLength int32
+	gl.GetProgramiv(sp.Id, gl.INFO_LOG_LENGTH, &logLength)
+
+	log := gl.Str(strings.Repeat("\x00", int(logLength)+1))
+	gl.GetProgramInfoLog(sp.Id, logLength, nil, log)
+
+	return errors.New(gl.GoStr(log))
+}
+
 func (sp *ShaderProgram) Link() {
 
 	gl.LinkProgram(sp.Id)
 
 	if sp.VertShaderId != 0 {
+		leakcheck.Untrack(leakcheck.ResourceType_Shader, sp.VertShaderId)
 		gl.DeleteShader(sp.VertShaderId)
 	}
 
 	if sp.FragShaderId != 0 {
+		leakcheck.Untrack(leakcheck.ResourceType_Shader, sp.FragShaderId)
 		gl.DeleteShader(sp.FragShaderId)
 	}
 
 	if sp.GeomShaderId != 0 {
+		leakcheck.Untrack(leakcheck.ResourceType_Shader, sp.GeomShaderId)
 		gl.DeleteShader(sp.GeomShaderId)
 	}
+
+	if sp.CompShaderId != 0 {
+		leakcheck.Untrack(leakcheck.ResourceType_Shader, sp.CompShaderId)
+		gl.DeleteShader(sp.CompShaderId)
+	}
+}
+
+func (s *ShaderProgram) Delete() {
+
+	if s.Id == 0 {
+		return
+	}
+
+	leakcheck.Untrack(leakcheck.ResourceType_Program, s.Id)
+	gl.DeleteProgram(s.Id)
+	s.Id = 0
+}
+
+// deleteWithShaders deletes the program and the shaders attached to it, for programs that failed before Link deleted the shaders
+func (sp *ShaderProgram) deleteWithShaders() {
+
+	for _, shaderId := range [...]uint32{sp.VertShaderId, sp.FragShaderId, sp.GeomShaderId, sp.CompShaderId} {
+		if shaderId != 0 {
+			leakcheck.Untrack(leakcheck.ResourceType_Shader, shaderId)
+			gl.DeleteShader(shaderId)
+		}
+	}
+
+	sp.Delete()
 }
 
 func (s *ShaderProgram) Bind() {
@@ -51,3 +155,10 @@ func (s *ShaderProgram) Bind() {
 func (s *ShaderProgram) UnBind() {
 	gl.UseProgram(0)
 }
+
+// Dispatch binds the compute program and runs it with the passed number of work groups in each dimension.
+// Writes done by the dispatch are only visible to later commands after a matching gl.MemoryBarrier
+func (s *ShaderProgram) Dispatch(groupsX, groupsY, groupsZ uint32) {
+	gl.UseProgram(s.Id)
+	gl.DispatchCompute(groupsX, groupsY, groupsZ)
+}
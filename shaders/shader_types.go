@@ -16,6 +16,8 @@ func (s ShaderType) ToGl() uint32 {
 		return gl.FRAGMENT_SHADER
 	case ShaderType_Geometry:
 		return gl.GEOMETRY_SHADER
+	case ShaderType_Compute:
+		return gl.COMPUTE_SHADER
 
 	default:
 		logging.ErrLog.Fatalf("Unknown shader type '%d'\n", s)
@@ -28,4 +30,5 @@ const (
 	ShaderType_Vertex
 	ShaderType_Fragment
 	ShaderType_Geometry
+	ShaderType_Compute
 )
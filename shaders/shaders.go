@@ -7,6 +7,8 @@ import (
 	"os"
 	"strings"
 
+	"github.com/bloeys/nmage/assets"
+	"github.com/bloeys/nmage/leakcheck"
 	"github.com/bloeys/nmage/logging"
 	"github.com/go-gl/gl/v4.1-core/gl"
 )
@@ -17,6 +19,7 @@ type Shader struct {
 }
 
 func (s *Shader) Delete() {
+	leakcheck.Untrack(leakcheck.ResourceType_Shader, s.Id)
 	gl.DeleteShader(s.Id)
 	s.Id = 0
 }
@@ -24,6 +27,7 @@ func (s *Shader) Delete() {
 func NewShaderProgram() (ShaderProgram, error) {
 
 	id := gl.CreateProgram()
+	leakcheck.Track(leakcheck.ResourceType_Program, id)
 	if id == 0 {
 		return ShaderProgram{}, errors.New("failed to create shader program")
 	}
@@ -33,7 +37,7 @@ func NewShaderProgram() (ShaderProgram, error) {
 
 func LoadAndCompileCombinedShader(shaderPath string) (ShaderProgram, error) {
 
-	combinedSource, err := os.ReadFile(shaderPath)
+	combinedSource, err := os.ReadFile(assets.ResolvePath(shaderPath))
 	if err != nil {
 		logging.ErrLog.Println("Failed to read shader. Err: ", err)
 		return ShaderProgram{}, err
@@ -42,8 +46,73 @@ func LoadAndCompileCombinedShader(shaderPath string) (ShaderProgram, error) {
 	return LoadAndCompileCombinedShaderSrc(combinedSource)
 
 }
+
+// LoadManagedCombinedShader is LoadAndCompileCombinedShader through an asset manager, so materials using the same shader file
+// share one compiled program
+func LoadManagedCombinedShader(m *assets.Manager, shaderPath string) (assets.Handle[*ShaderProgram], error) {
+	return assets.Acquire(m, "shader:"+shaderPath, func() (*ShaderProgram, error) {
+		shdrProg, err := LoadAndCompileCombinedShader(shaderPath)
+		return &shdrProg, err
+	})
+}
+
 func LoadAndCompileCombinedShaderSrc(shaderSrc []byte) (ShaderProgram, error) {
 
+	shdrProg, err := compileAndAttachCombinedShaderSrc(shaderSrc)
+	if err != nil {
+		return ShaderProgram{}, err
+	}
+
+	if shdrProg.FragShaderId == 0 {
+		return ShaderProgram{}, errors.New("no valid fragment shader found. Please put '//shader:fragment' before your vertex shader")
+	}
+
+	shdrProg.Link()
+	return shdrProg, nil
+}
+
+// LoadAndCompileTransformFeedbackShader loads a combined shader whose varyings are captured with transform feedback.
+// Unlike LoadAndCompileCombinedShader the fragment shader is optional, since capture-only programs
+// (e.g. particle simulations) usually run with the rasterizer discarded
+func LoadAndCompileTransformFeedbackShader(shaderPath string, varyings []string, mode TransformFeedbackMode) (ShaderProgram, error) {
+
+	combinedSource, err := os.ReadFile(assets.ResolvePath(shaderPath))
+	if err != nil {
+		logging.ErrLog.Println("Failed to read shader. Err: ", err)
+		return ShaderProgram{}, err
+	}
+
+	return LoadAndCompileTransformFeedbackShaderSrc(combinedSource, varyings, mode)
+}
+
+func LoadAndCompileTransformFeedbackShaderSrc(shaderSrc []byte, varyings []string, mode TransformFeedbackMode) (ShaderProgram, error) {
+
+	if len(varyings) == 0 {
+		return ShaderProgram{}, errors.New("failed to create transform feedback shader because no varyings to capture were passed")
+	}
+
+	shdrProg, err := compileAndAttachCombinedShaderSrc(shaderSrc)
+	if err != nil {
+		return ShaderProgram{}, err
+	}
+
+	shdrProg.SetTransformFeedbackVaryings(varyings, mode)
+	shdrProg.Link()
+
+	// Varyings that don't exist only fail at link time, so link errors are important here
+	if err := shdrProg.LinkErr(); err != nil {
+		leakcheck.Untrack(leakcheck.ResourceType_Program, shdrProg.Id)
+		gl.DeleteProgram(shdrProg.Id)
+		return ShaderProgram{}, fmt.Errorf("failed to link transform feedback shader. Err: %w", err)
+	}
+
+	return shdrProg, nil
+}
+
+// compileAndAttachCombinedShaderSrc compiles all shaders in the combined source and attaches them to a new program without linking it.
+// On errors the program and its shaders are deleted, since shaders that fail to compile are common while hot reloading (see Watcher)
+func compileAndAttachCombinedShaderSrc(shaderSrc []byte) (_ ShaderProgram, err error) {
+
 	shaderSources := bytes.Split(shaderSrc, []byte("//shader:"))
 	if len(shaderSources) < 2 {
 		return ShaderProgram{}, errors.New("failed to read combined shader. The minimum shader types to have are '//shader:vertex' and '//shader:fragment'")
@@ -54,6 +123,12 @@ func LoadAndCompileCombinedShaderSrc(shaderSrc []byte) (ShaderProgram, error) {
 		return ShaderProgram{}, errors.New("failed to create new shader program. Err: " + err.Error())
 	}
 
+	defer func() {
+		if err != nil {
+			shdrProg.deleteWithShaders()
+		}
+	}()
+
 	loadedShdrCount := 0
 	for i := 0; i < len(shaderSources); i++ {
 
@@ -95,17 +170,13 @@ func LoadAndCompileCombinedShaderSrc(shaderSrc []byte) (ShaderProgram, error) {
 		return ShaderProgram{}, errors.New("no valid vertex shader found. Please put '//shader:vertex' before your vertex shader")
 	}
 
-	if shdrProg.FragShaderId == 0 {
-		return ShaderProgram{}, errors.New("no valid fragment shader found. Please put '//shader:fragment' before your vertex shader")
-	}
-
-	shdrProg.Link()
 	return shdrProg, nil
 }
 
 func CompileShaderOfType(shaderSource []byte, shaderType ShaderType) (Shader, error) {
 
 	shaderId := gl.CreateShader(shaderType.ToGl())
+	leakcheck.Track(leakcheck.ResourceType_Shader, shaderId)
 	if shaderId == 0 {
 		return Shader{}, fmt.Errorf("failed to create OpenGl shader. OpenGl Error=%d", gl.GetError())
 	}
@@ -117,6 +188,7 @@ func CompileShaderOfType(shaderSource []byte, shaderType ShaderType) (Shader, er
 
 	gl.CompileShader(shaderId)
 	if err := getShaderCompileErrors(shaderId); err != nil {
+		leakcheck.Untrack(leakcheck.ResourceType_Shader, shaderId)
 		gl.DeleteShader(shaderId)
 		return Shader{}, err
 	}
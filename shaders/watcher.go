@@ -0,0 +1,331 @@
+package shaders
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bloeys/nmage/assets"
+	"github.com/bloeys/nmage/leakcheck"
+	"github.com/bloeys/nmage/logging"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+const (
+	DefaultWatcherPollInterval = 500 * time.Millisecond
+)
+
+type watchedShader struct {
+	path    string
+	modTime time.Time
+
+	// progs are the programs compiled from the file, which get the new program id on reload.
+	// Programs with the same id share one reloaded program
+	progs []*ShaderProgram
+
+	onReload []func()
+}
+
+// Watcher recompiles combined shader files when they change on disk, so shaders can be edited while the game runs.
+//
+// Files are polled every PollInterval from Update, which must be called on the thread of the OpenGL context (e.g. once per frame).
+// When a file changes it is compiled into a new program, the uniform values and uniform block bindings of the old program are copied
+// into it, and the Id of every watched ShaderProgram using the old program is swapped in place. Files that fail to compile or link
+// are logged and the old program is kept, so a typo doesn't crash the game
+type Watcher struct {
+	PollInterval time.Duration
+
+	shaders  []watchedShader
+	lastPoll time.Time
+}
+
+// Watch reloads prog whenever the combined shader file at shaderPath changes, calling onReload (if not nil) after every reload.
+// prog must stay at the same address until it is passed to Unwatch (e.g. a field of a material that isn't moved)
+func (w *Watcher) Watch(shaderPath string, prog *ShaderProgram, onReload func()) error {
+
+	index := slices.IndexFunc(w.shaders, func(ws watchedShader) bool { return ws.path == shaderPath })
+	if index == -1 {
+
+		info, err := os.Stat(assets.ResolvePath(shaderPath))
+		if err != nil {
+			return fmt.Errorf("failed to watch shader '%s'. Err: %w", shaderPath, err)
+		}
+
+		w.shaders = append(w.shaders, watchedShader{path: shaderPath, modTime: info.ModTime()})
+		index = len(w.shaders) - 1
+	}
+
+	ws := &w.shaders[index]
+	if !slices.Contains(ws.progs, prog) {
+		ws.progs = append(ws.progs, prog)
+	}
+
+	if onReload != nil {
+		ws.onReload = append(ws.onReload, onReload)
+	}
+
+	return nil
+}
+
+// Unwatch stops reloading prog, which should be called before it is deleted
+func (w *Watcher) Unwatch(prog *ShaderProgram) {
+
+	for i := 0; i < len(w.shaders); i++ {
+		ws := &w.shaders[i]
+		ws.progs = slices.DeleteFunc(ws.progs, func(p *ShaderProgram) bool { return p == prog })
+	}
+
+	w.shaders = slices.DeleteFunc(w.shaders, func(ws watchedShader) bool { return len(ws.progs) == 0 })
+}
+
+// Update reloads the watched files that changed since the last poll, returning the number of files reloaded
+func (w *Watcher) Update() int {
+
+	if time.Since(w.lastPoll) < w.PollInterval {
+		return 0
+	}
+	w.lastPoll = time.Now()
+
+	reloadCount := 0
+	for i := 0; i < len(w.shaders); i++ {
+
+		ws := &w.shaders[i]
+
+		// Files can be missing for a moment while editors save them
+		info, err := os.Stat(assets.ResolvePath(ws.path))
+		if err != nil || info.ModTime().Equal(ws.modTime) {
+			continue
+		}
+		ws.modTime = info.ModTime()
+
+		if err := w.reload(ws); err != nil {
+			logging.ErrLog.Printf("Failed to reload shader '%s', so the old shader is kept. Err: %v\n", ws.path, err)
+			continue
+		}
+
+		reloadCount++
+		logging.InfoLog.Printf("Reloaded shader '%s'\n", ws.path)
+	}
+
+	return reloadCount
+}
+
+// ReloadAll reloads all watched files whether they changed or not
+func (w *Watcher) ReloadAll() error {
+
+	for i := 0; i < len(w.shaders); i++ {
+		if err := w.reload(&w.shaders[i]); err != nil {
+			return fmt.Errorf("failed to reload shader '%s'. Err: %w", w.shaders[i].path, err)
+		}
+	}
+
+	return nil
+}
+
+func (w *Watcher) reload(ws *watchedShader) error {
+
+	src, err := os.ReadFile(assets.ResolvePath(ws.path))
+	if err != nil {
+		return err
+	}
+
+	// Each old program gets its own new program, since programs compiled separately have their own uniform values
+	newProgs := map[uint32]ShaderProgram{}
+	for _, prog := range ws.progs {
+
+		if prog.Id == 0 {
+			continue
+		}
+
+		if _, ok := newProgs[prog.Id]; ok {
+			continue
+		}
+
+		newProg, err := LoadAndCompileCombinedShaderSrc(src)
+		if err == nil {
+			err = newProg.LinkErr()
+			if err != nil {
+				newProg.Delete()
+			}
+		}
+
+		if err != nil {
+			for _, p := range newProgs {
+				p.Delete()
+			}
+			return err
+		}
+
+		copyUniforms(prog.Id, newProg.Id)
+		newProgs[prog.Id] = newProg
+	}
+
+	for oldId := range newProgs {
+		leakcheck.Untrack(leakcheck.ResourceType_Program, oldId)
+		gl.DeleteProgram(oldId)
+	}
+
+	for _, prog := range ws.progs {
+		if newProg, ok := newProgs[prog.Id]; ok {
+			*prog = newProg
+		}
+	}
+
+	for _, onReload := range ws.onReload {
+		onReload()
+	}
+
+	return nil
+}
+
+// copyUniforms sets the uniforms and uniform block bindings of dstProgId to those of srcProgId, for the ones that exist in both.
+// Uniforms whose type changed keep their default value
+func copyUniforms(srcProgId, dstProgId uint32) {
+
+	var maxNameLen int32
+	gl.GetProgramiv(srcProgId, gl.ACTIVE_UNIFORM_MAX_LENGTH, &maxNameLen)
+
+	var unifCount int32
+	gl.GetProgramiv(srcProgId, gl.ACTIVE_UNIFORMS, &unifCount)
+
+	nameBuf := make([]uint8, maxNameLen+1)
+	for i := uint32(0); i < uint32(unifCount); i++ {
+
+		var nameLen, arraySize int32
+		var unifType uint32
+		gl.GetActiveUniform(srcProgId, i, maxNameLen+1, &nameLen, &arraySize, &unifType, &nameBuf[0])
+
+		// Arrays are named 'name[0]', and each element has its own location
+		name := strings.TrimSuffix(string(nameBuf[:nameLen]), "[0]")
+		for j := int32(0); j < arraySize; j++ {
+
+			elemName := name
+			if len(name) != int(nameLen) {
+				elemName = fmt.Sprintf("%s[%d]", name, j)
+			}
+
+			copyUniform(srcProgId, dstProgId, elemName, unifType)
+		}
+	}
+
+	var blockCount int32
+	gl.GetProgramiv(srcProgId, gl.ACTIVE_UNIFORM_BLOCKS, &blockCount)
+
+	gl.GetProgramiv(srcProgId, gl.ACTIVE_UNIFORM_BLOCK_MAX_NAME_LENGTH, &maxNameLen)
+	nameBuf = make([]uint8, maxNameLen+1)
+	for i := uint32(0); i < uint32(blockCount); i++ {
+
+		var nameLen, binding int32
+		gl.GetActiveUniformBlockName(srcProgId, i, maxNameLen+1, &nameLen, &nameBuf[0])
+		gl.GetActiveUniformBlockiv(srcProgId, i, gl.UNIFORM_BLOCK_BINDING, &binding)
+
+		dstIndex := gl.GetUniformBlockIndex(dstProgId, gl.Str(string(nameBuf[:nameLen])+"\x00"))
+		if dstIndex != gl.INVALID_INDEX {
+			gl.UniformBlockBinding(dstProgId, dstIndex, uint32(binding))
+		}
+	}
+}
+
+func copyUniform(srcProgId, dstProgId uint32, name string, unifType uint32) {
+
+	cName := gl.Str(name + "\x00")
+
+	// Uniforms in uniform blocks have no location
+	srcLoc := gl.GetUniformLocation(srcProgId, cName)
+	if srcLoc == -1 {
+		return
+	}
+
+	dstLoc := gl.GetUniformLocation(dstProgId, cName)
+	if dstLoc == -1 || !uniformTypeMatches(dstProgId, name, unifType) {
+		return
+	}
+
+	var f [16]float32
+	var i [4]int32
+	var u [4]uint32
+	switch unifType {
+	case gl.FLOAT:
+		gl.GetUniformfv(srcProgId, srcLoc, &f[0])
+		gl.ProgramUniform1fv(dstProgId, dstLoc, 1, &f[0])
+	case gl.FLOAT_VEC2:
+		gl.GetUniformfv(srcProgId, srcLoc, &f[0])
+		gl.ProgramUniform2fv(dstProgId, dstLoc, 1, &f[0])
+	case gl.FLOAT_VEC3:
+		gl.GetUniformfv(srcProgId, srcLoc, &f[0])
+		gl.ProgramUniform3fv(dstProgId, dstLoc, 1, &f[0])
+	case gl.FLOAT_VEC4:
+		gl.GetUniformfv(srcProgId, srcLoc, &f[0])
+		gl.ProgramUniform4fv(dstProgId, dstLoc, 1, &f[0])
+	case gl.FLOAT_MAT2:
+		gl.GetUniformfv(srcProgId, srcLoc, &f[0])
+		gl.ProgramUniformMatrix2fv(dstProgId, dstLoc, 1, false, &f[0])
+	case gl.FLOAT_MAT3:
+		gl.GetUniformfv(srcProgId, srcLoc, &f[0])
+		gl.ProgramUniformMatrix3fv(dstProgId, dstLoc, 1, false, &f[0])
+	case gl.FLOAT_MAT4:
+		gl.GetUniformfv(srcProgId, srcLoc, &f[0])
+		gl.ProgramUniformMatrix4fv(dstProgId, dstLoc, 1, false, &f[0])
+	case gl.INT_VEC2, gl.BOOL_VEC2:
+		gl.GetUniformiv(srcProgId, srcLoc, &i[0])
+		gl.ProgramUniform2iv(dstProgId, dstLoc, 1, &i[0])
+	case gl.INT_VEC3, gl.BOOL_VEC3:
+		gl.GetUniformiv(srcProgId, srcLoc, &i[0])
+		gl.ProgramUniform3iv(dstProgId, dstLoc, 1, &i[0])
+	case gl.INT_VEC4, gl.BOOL_VEC4:
+		gl.GetUniformiv(srcProgId, srcLoc, &i[0])
+		gl.ProgramUniform4iv(dstProgId, dstLoc, 1, &i[0])
+	case gl.UNSIGNED_INT:
+		gl.GetUniformuiv(srcProgId, srcLoc, &u[0])
+		gl.ProgramUniform1uiv(dstProgId, dstLoc, 1, &u[0])
+	case gl.UNSIGNED_INT_VEC2:
+		gl.GetUniformuiv(srcProgId, srcLoc, &u[0])
+		gl.ProgramUniform2uiv(dstProgId, dstLoc, 1, &u[0])
+	case gl.UNSIGNED_INT_VEC3:
+		gl.GetUniformuiv(srcProgId, srcLoc, &u[0])
+		gl.ProgramUniform3uiv(dstProgId, dstLoc, 1, &u[0])
+	case gl.UNSIGNED_INT_VEC4:
+		gl.GetUniformuiv(srcProgId, srcLoc, &u[0])
+		gl.ProgramUniform4uiv(dstProgId, dstLoc, 1, &u[0])
+
+	// Ints, bools and samplers, whose value is their texture slot
+	default:
+		gl.GetUniformiv(srcProgId, srcLoc, &i[0])
+		gl.ProgramUniform1iv(dstProgId, dstLoc, 1, &i[0])
+	}
+}
+
+// uniformTypeMatches returns true if the uniform (or array element) called name in progId has type unifType
+func uniformTypeMatches(progId uint32, name string, unifType uint32) bool {
+
+	// Array elements are looked up by the name of the array
+	arrayName := name
+	if bracket := strings.IndexByte(name, '['); bracket != -1 {
+		arrayName = name[:bracket] + "[0]"
+	}
+
+	cNames, free := gl.Strs(name+"\x00", arrayName+"\x00")
+	defer free()
+
+	var indices [2]uint32
+	gl.GetUniformIndices(progId, 2, cNames, &indices[0])
+
+	index := indices[0]
+	if index == gl.INVALID_INDEX {
+		index = indices[1]
+	}
+
+	if index == gl.INVALID_INDEX {
+		return false
+	}
+
+	var dstType int32
+	gl.GetActiveUniformsiv(progId, 1, &index, gl.UNIFORM_TYPE, &dstType)
+	return uint32(dstType) == unifType
+}
+
+func NewWatcher(pollInterval time.Duration) Watcher {
+	return Watcher{PollInterval: pollInterval}
+}
@@ -0,0 +1,356 @@
+package spline
+
+import (
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/assert"
+)
+
+const (
+	// DefaultSamplesPerSegment is how many samples per segment are used for arc length and closest point estimates
+	DefaultSamplesPerSegment = 32
+)
+
+// segment is a cubic Hermite curve. All supported curve types are converted to this form
+type segment struct {
+	P0, M0 gglm.Vec3
+	P1, M1 gglm.Vec3
+}
+
+func (s *segment) evaluate(t float32) gglm.Vec3 {
+
+	t2 := t * t
+	t3 := t2 * t
+
+	h00 := 2*t3 - 3*t2 + 1
+	h10 := t3 - 2*t2 + t
+	h01 := -2*t3 + 3*t2
+	h11 := t3 - t2
+
+	return gglm.NewVec3(
+		h00*s.P0.Data[0]+h10*s.M0.Data[0]+h01*s.P1.Data[0]+h11*s.M1.Data[0],
+		h00*s.P0.Data[1]+h10*s.M0.Data[1]+h01*s.P1.Data[1]+h11*s.M1.Data[1],
+		h00*s.P0.Data[2]+h10*s.M0.Data[2]+h01*s.P1.Data[2]+h11*s.M1.Data[2],
+	)
+}
+
+func (s *segment) derivative(t float32) gglm.Vec3 {
+
+	t2 := t * t
+
+	d00 := 6*t2 - 6*t
+	d10 := 3*t2 - 4*t + 1
+	d01 := -6*t2 + 6*t
+	d11 := 3*t2 - 2*t
+
+	return gglm.NewVec3(
+		d00*s.P0.Data[0]+d10*s.M0.Data[0]+d01*s.P1.Data[0]+d11*s.M1.Data[0],
+		d00*s.P0.Data[1]+d10*s.M0.Data[1]+d01*s.P1.Data[1]+d11*s.M1.Data[1],
+		d00*s.P0.Data[2]+d10*s.M0.Data[2]+d01*s.P1.Data[2]+d11*s.M1.Data[2],
+	)
+}
+
+// Spline is a smooth curve made of cubic segments, created with NewCatmullRom, NewBezier or NewHermite.
+//
+// The parameter t goes from 0 at the start to 1 at the end, with each segment getting an equal share of t.
+// Since segments can have different lengths, equal steps in t don't move equal distances,
+// so use the *AtDistance functions for constant speed movement (e.g. camera rails and moving platforms)
+type Spline struct {
+	segments []segment
+
+	// cumulativeLengths[i] is the approximate length of the curve from t=0 to sample i
+	cumulativeLengths []float32
+	samplesPerSegment int
+}
+
+func (s *Spline) SegmentCount() int {
+	return len(s.segments)
+}
+
+// Length returns the approximate arc length of the whole curve
+func (s *Spline) Length() float32 {
+	return s.cumulativeLengths[len(s.cumulativeLengths)-1]
+}
+
+// segmentAt maps a curve t in [0,1] to a segment and a local t in [0,1] in that segment
+func (s *Spline) segmentAt(t float32) (*segment, float32) {
+
+	t = gglm.Clamp(t, 0, 1)
+
+	scaled := t * float32(len(s.segments))
+	index := int(scaled)
+	if index >= len(s.segments) {
+		index = len(s.segments) - 1
+	}
+
+	return &s.segments[index], scaled - float32(index)
+}
+
+// Evaluate returns the point at t, where t is in [0,1]
+func (s *Spline) Evaluate(t float32) gglm.Vec3 {
+	seg, localT := s.segmentAt(t)
+	return seg.evaluate(localT)
+}
+
+// Derivative returns the rate of change of the position at t with respect to the segment's local t.
+// Its length is related to speed, so use Direction if you only need where the curve is heading
+func (s *Spline) Derivative(t float32) gglm.Vec3 {
+	seg, localT := s.segmentAt(t)
+	return seg.derivative(localT)
+}
+
+// Direction returns the normalized tangent at t
+func (s *Spline) Direction(t float32) gglm.Vec3 {
+
+	d := s.Derivative(t)
+	if d.SqrMag() < gglm.F32Epsilon {
+		return gglm.NewVec3(0, 0, 0)
+	}
+
+	d.Normalize()
+	return d
+}
+
+// TAtDistance returns the t whose point is roughly dist along the curve from the start. dist is clamped to [0,Length]
+func (s *Spline) TAtDistance(dist float32) float32 {
+
+	length := s.Length()
+	if length <= 0 || dist <= 0 {
+		return 0
+	}
+
+	if dist >= length {
+		return 1
+	}
+
+	// Binary search for the first sample whose cumulative length is >= dist
+	lo := 0
+	hi := len(s.cumulativeLengths) - 1
+	for lo < hi {
+
+		mid := (lo + hi) / 2
+		if s.cumulativeLengths[mid] < dist {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	// Linearly interpolate between the samples around dist
+	prevLen := s.cumulativeLengths[lo-1]
+	sampleLen := s.cumulativeLengths[lo] - prevLen
+
+	frac := float32(0)
+	if sampleLen > 0 {
+		frac = (dist - prevLen) / sampleLen
+	}
+
+	totalSamples := float32(len(s.cumulativeLengths) - 1)
+	return (float32(lo-1) + frac) / totalSamples
+}
+
+// EvaluateAtDistance returns the point roughly dist along the curve from the start
+func (s *Spline) EvaluateAtDistance(dist float32) gglm.Vec3 {
+	return s.Evaluate(s.TAtDistance(dist))
+}
+
+// EvaluateUniform is like Evaluate, except u is the fraction of the curve's length, so equal steps in u move equal distances
+func (s *Spline) EvaluateUniform(u float32) gglm.Vec3 {
+	return s.Evaluate(s.TAtDistance(u * s.Length()))
+}
+
+// SampleEvenly returns count points spaced at equal distances along the curve, including both ends.
+// Useful for building meshes (e.g. roads) along the curve
+func (s *Spline) SampleEvenly(count int) []gglm.Vec3 {
+
+	assert.T(count >= 2, "SampleEvenly needs at least 2 samples, but got %d", count)
+
+	points := make([]gglm.Vec3, count)
+	for i := 0; i < count; i++ {
+		points[i] = s.EvaluateUniform(float32(i) / float32(count-1))
+	}
+
+	return points
+}
+
+// ClosestPoint returns the t and position of the point on the curve that is closest to p
+func (s *Spline) ClosestPoint(p *gglm.Vec3) (t float32, point gglm.Vec3) {
+
+	// Find the closest sample, then refine between its neighbours.
+	// This can pick the wrong part of the curve if it comes back very close to itself, but is fine for normal curves
+	totalSamples := len(s.cumulativeLengths) - 1
+	bestSample := 0
+	bestSqrDist := float32(-1)
+	for i := 0; i <= totalSamples; i++ {
+
+		sample := s.Evaluate(float32(i) / float32(totalSamples))
+		sqrDist := gglm.SqrDistVec3(&sample, p)
+		if bestSqrDist < 0 || sqrDist < bestSqrDist {
+			bestSqrDist = sqrDist
+			bestSample = i
+		}
+	}
+
+	lo := float32(max(bestSample-1, 0)) / float32(totalSamples)
+	hi := float32(min(bestSample+1, totalSamples)) / float32(totalSamples)
+
+	// Ternary search, since distance is unimodal over a small enough range
+	for i := 0; i < 24; i++ {
+
+		m1 := lo + (hi-lo)/3
+		m2 := hi - (hi-lo)/3
+
+		p1 := s.Evaluate(m1)
+		p2 := s.Evaluate(m2)
+		if gglm.SqrDistVec3(&p1, p) < gglm.SqrDistVec3(&p2, p) {
+			hi = m2
+		} else {
+			lo = m1
+		}
+	}
+
+	t = (lo + hi) / 2
+	return t, s.Evaluate(t)
+}
+
+// DistanceAtT returns the approximate distance along the curve from the start to t
+func (s *Spline) DistanceAtT(t float32) float32 {
+
+	t = gglm.Clamp(t, 0, 1)
+
+	totalSamples := len(s.cumulativeLengths) - 1
+	scaled := t * float32(totalSamples)
+	index := int(scaled)
+	if index >= totalSamples {
+		return s.Length()
+	}
+
+	frac := scaled - float32(index)
+	return s.cumulativeLengths[index] + (s.cumulativeLengths[index+1]-s.cumulativeLengths[index])*frac
+}
+
+// buildArcLengthTable samples the curve to approximate lengths. Must be called after segments change
+func (s *Spline) buildArcLengthTable() {
+
+	totalSamples := len(s.segments) * s.samplesPerSegment
+	s.cumulativeLengths = make([]float32, totalSamples+1)
+
+	prev := s.Evaluate(0)
+	for i := 1; i <= totalSamples; i++ {
+
+		curr := s.Evaluate(float32(i) / float32(totalSamples))
+		s.cumulativeLengths[i] = s.cumulativeLengths[i-1] + gglm.DistVec3(&prev, &curr)
+		prev = curr
+	}
+}
+
+func newSpline(segments []segment) *Spline {
+
+	s := &Spline{
+		segments:          segments,
+		samplesPerSegment: DefaultSamplesPerSegment,
+	}
+	s.buildArcLengthTable()
+
+	return s
+}
+
+// NewCatmullRom creates a curve that passes through all the points, which makes it easy to author (e.g. camera rails).
+// If isLoop is true the curve connects the last point back to the first one
+func NewCatmullRom(points []gglm.Vec3, isLoop bool) *Spline {
+
+	assert.T(len(points) >= 2, "Catmull-Rom curves need at least 2 points, but got %d", len(points))
+
+	n := len(points)
+	pointAt := func(i int) *gglm.Vec3 {
+
+		if isLoop {
+			return &points[((i%n)+n)%n]
+		}
+
+		return &points[gglm.Clamp(i, 0, n-1)]
+	}
+
+	// Tangent at each point is half the vector between its neighbours.
+	// At the ends of open curves the neighbour is the point itself, which gives a one sided tangent
+	tangentAt := func(i int) gglm.Vec3 {
+
+		next := pointAt(i + 1)
+		prev := pointAt(i - 1)
+
+		scale := float32(0.5)
+		if !isLoop && (i == 0 || i == n-1) {
+			scale = 1
+		}
+
+		return gglm.NewVec3(
+			(next.Data[0]-prev.Data[0])*scale,
+			(next.Data[1]-prev.Data[1])*scale,
+			(next.Data[2]-prev.Data[2])*scale,
+		)
+	}
+
+	segCount := n - 1
+	if isLoop {
+		segCount = n
+	}
+
+	segments := make([]segment, segCount)
+	for i := 0; i < segCount; i++ {
+		segments[i] = segment{
+			P0: *pointAt(i),
+			M0: tangentAt(i),
+			P1: *pointAt(i + 1),
+			M1: tangentAt(i + 1),
+		}
+	}
+
+	return newSpline(segments)
+}
+
+// NewBezier creates a curve from chained cubic Bezier segments, in the form [P0, C0, C1, P1, C2, C3, P2...],
+// where the curve passes through the P points and is pulled towards the C control points.
+// The number of points must be 3*segments+1
+func NewBezier(points []gglm.Vec3) *Spline {
+
+	assert.T(len(points) >= 4 && (len(points)-1)%3 == 0, "Bezier curves need 3*segments+1 points, but got %d", len(points))
+
+	segCount := (len(points) - 1) / 3
+	segments := make([]segment, segCount)
+	for i := 0; i < segCount; i++ {
+
+		p0 := &points[i*3]
+		c0 := &points[i*3+1]
+		c1 := &points[i*3+2]
+		p1 := &points[i*3+3]
+
+		// A cubic Bezier's end tangents are 3 times the vectors to its control points
+		segments[i] = segment{
+			P0: *p0,
+			M0: gglm.NewVec3(3*(c0.Data[0]-p0.Data[0]), 3*(c0.Data[1]-p0.Data[1]), 3*(c0.Data[2]-p0.Data[2])),
+			P1: *p1,
+			M1: gglm.NewVec3(3*(p1.Data[0]-c1.Data[0]), 3*(p1.Data[1]-c1.Data[1]), 3*(p1.Data[2]-c1.Data[2])),
+		}
+	}
+
+	return newSpline(segments)
+}
+
+// NewHermite creates a curve that passes through the points with the given tangent at each point,
+// which gives direct control over direction and speed at each point
+func NewHermite(points, tangents []gglm.Vec3) *Spline {
+
+	assert.T(len(points) >= 2, "Hermite curves need at least 2 points, but got %d", len(points))
+	assert.T(len(points) == len(tangents), "Hermite curves need one tangent per point, but got %d points and %d tangents", len(points), len(tangents))
+
+	segments := make([]segment, len(points)-1)
+	for i := 0; i < len(segments); i++ {
+		segments[i] = segment{
+			P0: points[i],
+			M0: tangents[i],
+			P1: points[i+1],
+			M1: tangents[i+1],
+		}
+	}
+
+	return newSpline(segments)
+}
@@ -0,0 +1,261 @@
+package sprites
+
+import (
+	"math"
+
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/assert"
+	"github.com/bloeys/nmage/buffers"
+	"github.com/bloeys/nmage/color"
+	"github.com/bloeys/nmage/leakcheck"
+	"github.com/bloeys/nmage/materials"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// floatsPerVertex is position (3), uv (2) and color (4)
+const floatsPerVertex = 9
+
+const batchShader = `
+//shader:vertex
+#version 410
+
+layout(location=0) in vec3 vertPosIn;
+layout(location=1) in vec2 vertUV0In;
+layout(location=2) in vec4 vertColorIn;
+
+uniform mat4 projViewMat;
+
+out vec2 vertUV0;
+out vec4 vertColor;
+
+void main()
+{
+    vertUV0 = vertUV0In;
+    vertColor = vertColorIn;
+    gl_Position = projViewMat * vec4(vertPosIn, 1);
+}
+
+//shader:fragment
+#version 410
+
+uniform sampler2D diffTex;
+
+in vec2 vertUV0;
+in vec4 vertColor;
+
+out vec4 fragColor;
+
+void main()
+{
+    fragColor = vertColor * texture(diffTex, vertUV0);
+
+    // Fully transparent pixels are dropped so they don't hide sprites behind them in the depth test
+    if (fragColor.a < 0.01)
+        discard;
+}
+`
+
+// Sprite is a frame of a texture placed in the world
+type Sprite struct {
+	// TexID of zero draws a solid color
+	TexID uint32
+	Frame Frame
+
+	Pos gglm.Vec3
+
+	// Size is in world units
+	Size gglm.Vec2
+
+	// Pivot is the point of the sprite at Pos that it rotates around, from (0, 0) at the bottom left to (1, 1) at the top right
+	Pivot gglm.Vec2
+
+	// Rotation is around Z in radians
+	Rotation float32
+
+	FlipX bool
+	FlipY bool
+	Color color.Color
+}
+
+// SetFrameSize sets Size from the frame's size in pixels
+func (s *Sprite) SetFrameSize(pixelsPerUnit float32) {
+	s.Size = gglm.NewVec2(s.Frame.Width/pixelsPerUnit, s.Frame.Height/pixelsPerUnit)
+}
+
+func NewSprite(texID uint32, frame Frame, pixelsPerUnit float32) Sprite {
+
+	s := Sprite{
+		TexID: texID,
+		Frame: frame,
+		Pivot: gglm.NewVec2(0.5, 0.5),
+		Color: color.NewLinear(1, 1, 1),
+	}
+
+	s.SetFrameSize(pixelsPerUnit)
+	return s
+}
+
+type batchCmd struct {
+	texId uint32
+	first int32
+	count int32
+}
+
+// Batch collects sprites and draws them in world space with as few draw calls as possible.
+// Sprites are drawn in the order they were added, and a new draw call is only needed when the texture changes
+type Batch struct {
+	mat      materials.Material
+	vao      buffers.VertexArray
+	whiteTex uint32
+
+	vertices []float32
+	cmds     []batchCmd
+}
+
+func (b *Batch) Add(s *Sprite) {
+
+	if s.Size.X() == 0 || s.Size.Y() == 0 || s.Color.A() <= 0 {
+		return
+	}
+
+	texId := s.TexID
+	if texId == 0 {
+		texId = b.whiteTex
+	}
+
+	if len(b.cmds) == 0 || b.cmds[len(b.cmds)-1].texId != texId {
+		b.cmds = append(b.cmds, batchCmd{
+			texId: texId,
+			first: int32(len(b.vertices) / floatsPerVertex),
+		})
+	}
+
+	// Corners relative to the pivot, counter clockwise from the bottom left
+	left := -s.Pivot.X() * s.Size.X()
+	bottom := -s.Pivot.Y() * s.Size.Y()
+	right := left + s.Size.X()
+	top := bottom + s.Size.Y()
+
+	sin, cos := math.Sincos(float64(s.Rotation))
+	sinF, cosF := float32(sin), float32(cos)
+	px, py, pz := s.Pos.X(), s.Pos.Y(), s.Pos.Z()
+
+	corner := func(x, y float32) (float32, float32) {
+		return px + x*cosF - y*sinF, py + x*sinF + y*cosF
+	}
+
+	x0, y0 := corner(left, bottom)
+	x1, y1 := corner(right, bottom)
+	x2, y2 := corner(right, top)
+	x3, y3 := corner(left, top)
+
+	// UVMin is the top left of the frame
+	u0, u1 := s.Frame.UVMin.X(), s.Frame.UVMax.X()
+	vTop, vBottom := s.Frame.UVMin.Y(), s.Frame.UVMax.Y()
+	if s.FlipX {
+		u0, u1 = u1, u0
+	}
+
+	if s.FlipY {
+		vTop, vBottom = vBottom, vTop
+	}
+
+	c := &s.Color.Data
+	cr, cg, cb, ca := c[0], c[1], c[2], c[3]
+
+	b.vertices = append(b.vertices,
+		x0, y0, pz, u0, vBottom, cr, cg, cb, ca,
+		x1, y1, pz, u1, vBottom, cr, cg, cb, ca,
+		x2, y2, pz, u1, vTop, cr, cg, cb, ca,
+
+		x0, y0, pz, u0, vBottom, cr, cg, cb, ca,
+		x2, y2, pz, u1, vTop, cr, cg, cb, ca,
+		x3, y3, pz, u0, vTop, cr, cg, cb, ca,
+	)
+
+	b.cmds[len(b.cmds)-1].count += 6
+}
+
+// Flush draws everything added since the last flush to the bound framebuffer. Sprites are depth tested against the scene
+// but don't write depth, so for correct blending of overlapping sprites add them back to front
+func (b *Batch) Flush(projViewMat *gglm.Mat4) {
+
+	if len(b.vertices) == 0 {
+		b.reset()
+		return
+	}
+
+	gl.Enable(gl.BLEND)
+	gl.BlendEquation(gl.FUNC_ADD)
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+	gl.Disable(gl.CULL_FACE)
+	gl.DepthMask(false)
+
+	b.mat.Bind()
+	b.mat.SetUnifMat4("projViewMat", projViewMat)
+	b.mat.SetUnifInt32("diffTex", 0)
+
+	b.vao.Bind()
+	b.vao.Vbos[0].OrphanAndSet(b.vertices)
+
+	gl.ActiveTexture(gl.TEXTURE0)
+	for i := 0; i < len(b.cmds); i++ {
+
+		cmd := &b.cmds[i]
+		if cmd.count == 0 {
+			continue
+		}
+
+		gl.BindTexture(gl.TEXTURE_2D, cmd.texId)
+		gl.DrawArrays(gl.TRIANGLES, cmd.first, cmd.count)
+	}
+
+	gl.DepthMask(true)
+	gl.Enable(gl.CULL_FACE)
+
+	b.reset()
+}
+
+func (b *Batch) reset() {
+	b.vertices = b.vertices[:0]
+	b.cmds = b.cmds[:0]
+}
+
+func (b *Batch) Delete() {
+
+	b.mat.Delete()
+	b.vao.Delete()
+
+	leakcheck.Untrack(leakcheck.ResourceType_Texture, b.whiteTex)
+	gl.DeleteTextures(1, &b.whiteTex)
+	b.whiteTex = 0
+}
+
+func NewBatch() Batch {
+
+	b := Batch{
+		mat:      assert.MustGet(materials.NewMaterialSrc("Sprite Batch Mat", []byte(batchShader))),
+		vao:      buffers.NewVertexArray(),
+		vertices: make([]float32, 0, 6*floatsPerVertex*64),
+	}
+
+	vbo := buffers.NewVertexBuffer(
+		buffers.Element{ElementType: buffers.DataTypeVec3},
+		buffers.Element{ElementType: buffers.DataTypeVec2},
+		buffers.Element{ElementType: buffers.DataTypeVec4},
+	)
+	vbo.Usage = buffers.BufUsage_Stream_Draw
+	b.vao.AddVertexBuffer(vbo)
+
+	// Sprites without a texture sample a white texture so all sprites can use the same shader
+	white := [4]uint8{255, 255, 255, 255}
+	gl.GenTextures(1, &b.whiteTex)
+	leakcheck.Track(leakcheck.ResourceType_Texture, b.whiteTex)
+
+	gl.BindTexture(gl.TEXTURE_2D, b.whiteTex)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA8, 1, 1, 0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(&white[0]))
+
+	return b
+}
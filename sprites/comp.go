@@ -0,0 +1,98 @@
+package sprites
+
+import (
+	"slices"
+
+	"github.com/bloeys/nmage/entity"
+	"github.com/bloeys/nmage/registry"
+	"github.com/bloeys/nmage/timing"
+)
+
+var _ entity.Comp = &FlipbookComp{}
+
+var (
+	// Flipbook components add themselves to this on Init and remove themselves on Destroy,
+	// which is how UpdateFlipbookComps and DrawFlipbookComps find them
+	flipbookComps = []*FlipbookComp{}
+)
+
+// FlipbookComp is a sprite showing the current frame of its animator, e.g. for characters and effects
+type FlipbookComp struct {
+	entity.BaseComp
+	Animator
+	Sprite
+
+	// Hidden comps keep animating but aren't drawn
+	Hidden bool
+}
+
+func (f *FlipbookComp) Name() string {
+	return "Flipbook Component"
+}
+
+func (f *FlipbookComp) Init(parentHandle registry.Handle) {
+	f.BaseComp.Init(parentHandle)
+	flipbookComps = append(flipbookComps, f)
+}
+
+// Update advances the animation by the scaled frame time and shows its current frame
+func (f *FlipbookComp) Update() {
+
+	f.Animator.Update(timing.DT())
+
+	frame := f.Animator.Frame()
+	if frame == nil {
+		return
+	}
+
+	f.Sprite.TexID = f.Animator.Flipbook.TexID
+	f.Sprite.Frame = *frame
+}
+
+func (f *FlipbookComp) Destroy() {
+
+	i := slices.Index(flipbookComps, f)
+	if i != -1 {
+		flipbookComps = slices.Delete(flipbookComps, i, i+1)
+	}
+}
+
+// FlipbookComps returns all initialized flipbook components. The returned slice must not be modified
+func FlipbookComps() []*FlipbookComp {
+	return flipbookComps
+}
+
+// UpdateFlipbookComps updates all flipbook components. Frame event callbacks may destroy components
+func UpdateFlipbookComps() {
+
+	for _, f := range slices.Clone(flipbookComps) {
+		f.Update()
+	}
+}
+
+// DrawFlipbookComps adds all visible flipbook components to the batch
+func DrawFlipbookComps(b *Batch) {
+
+	for _, f := range flipbookComps {
+		if !f.Hidden && f.Animator.Frame() != nil {
+			b.Add(&f.Sprite)
+		}
+	}
+}
+
+// NewFlipbookComp returns a comp playing fb, sized so pixelsPerUnit frame pixels are one world unit
+func NewFlipbookComp(fb *Flipbook, pixelsPerUnit float32) *FlipbookComp {
+
+	f := &FlipbookComp{
+		Animator: NewAnimator(),
+	}
+
+	var frame Frame
+	if len(fb.Frames) > 0 {
+		frame = fb.Frames[0]
+	}
+
+	f.Sprite = NewSprite(fb.TexID, frame, pixelsPerUnit)
+	f.Animator.Play(fb)
+	return f
+}
@@ -0,0 +1,281 @@
+// The sprites package draws textured quads in the world with Batch, and plays sprite sheet animations (flipbooks) on them
+// for characters and effects. Sprites are in the XY plane facing +Z, so they suit 2D games and flat effects in 3D scenes
+package sprites
+
+import (
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/assets"
+	"github.com/bloeys/nmage/ui/gameui"
+)
+
+// Frame is a region of a texture
+type Frame struct {
+	// UVMin is the uv of the top left corner and UVMax of the bottom right corner
+	UVMin gglm.Vec2
+	UVMax gglm.Vec2
+
+	// Width and Height are the size of the region in pixels
+	Width  float32
+	Height float32
+}
+
+// NewFrame returns the frame of the width by height pixels starting at (x, y), where (0, 0) is the top left of the image.
+// Textures are stored bottom up, so the uvs are flipped
+func NewFrame(tex *assets.Texture, x, y, width, height int32) Frame {
+
+	texW := float32(tex.Width)
+	texH := float32(tex.Height)
+
+	return Frame{
+		UVMin:  gglm.NewVec2(float32(x)/texW, 1-float32(y)/texH),
+		UVMax:  gglm.NewVec2(float32(x+width)/texW, 1-float32(y+height)/texH),
+		Width:  float32(width),
+		Height: float32(height),
+	}
+}
+
+// UISprite returns the frame as a game UI sprite, so flipbooks can also play in the UI
+func (f *Frame) UISprite(texID uint32) gameui.Sprite {
+	return gameui.Sprite{
+		TexID:  texID,
+		UVMin:  f.UVMin,
+		UVMax:  f.UVMax,
+		Width:  f.Width,
+		Height: f.Height,
+	}
+}
+
+// GridFrames returns count frames of a sprite sheet whose frames are cells of a grid, starting at cell first and going row by row
+func GridFrames(tex *assets.Texture, cellWidth, cellHeight, first, count int32) []Frame {
+
+	if cellWidth <= 0 || cellHeight <= 0 {
+		return nil
+	}
+
+	cols := tex.Width / cellWidth
+	if cols <= 0 {
+		return nil
+	}
+
+	frames := make([]Frame, 0, count)
+	for i := first; i < first+count; i++ {
+		frames = append(frames, NewFrame(tex, (i%cols)*cellWidth, (i/cols)*cellHeight, cellWidth, cellHeight))
+	}
+
+	return frames
+}
+
+type LoopMode int32
+
+const (
+	// LoopMode_Once stops on the last frame
+	LoopMode_Once LoopMode = iota
+	LoopMode_Loop
+
+	// LoopMode_PingPong plays forward then backward, forever
+	LoopMode_PingPong
+)
+
+func (lm LoopMode) String() string {
+	switch lm {
+	case LoopMode_Once:
+		return "Once"
+	case LoopMode_Loop:
+		return "Loop"
+	case LoopMode_PingPong:
+		return "PingPong"
+	default:
+		return "Unknown"
+	}
+}
+
+// FrameEvent is sent when its frame starts showing, e.g. a 'footstep' event on the frames a foot touches the ground
+type FrameEvent struct {
+	Frame int32
+	Name  string
+}
+
+// Flipbook is an animation made of frames of one texture shown one after the other
+type Flipbook struct {
+	Name   string
+	TexID  uint32
+	Frames []Frame
+	FPS    float32
+	Loop   LoopMode
+	Events []FrameEvent
+}
+
+func NewFlipbook(name string, tex *assets.Texture, frames []Frame, fps float32, loop LoopMode) Flipbook {
+	return Flipbook{
+		Name:   name,
+		TexID:  tex.TexID,
+		Frames: frames,
+		FPS:    fps,
+		Loop:   loop,
+	}
+}
+
+// Animator plays flipbooks. Many animators can play the same flipbook
+type Animator struct {
+	Flipbook *Flipbook
+
+	// Speed multiplies the flipbook's FPS. Negative speeds aren't supported
+	Speed float32
+
+	// OnEvent is called for every event of a frame when the frame starts showing
+	OnEvent func(a *Animator, e FrameEvent)
+
+	// OnFinished is called when a LoopMode_Once flipbook reaches its last frame
+	OnFinished func(a *Animator)
+
+	frameIndex int32
+	elapsed    float32
+
+	// direction is 1 when playing forward and -1 when a ping pong flipbook plays backward
+	direction int32
+
+	isPlaying bool
+	isDone    bool
+}
+
+// Play starts the flipbook from its first frame
+func (a *Animator) Play(fb *Flipbook) {
+
+	a.Flipbook = fb
+	a.frameIndex = 0
+	a.elapsed = 0
+	a.direction = 1
+	a.isPlaying = true
+	a.isDone = false
+
+	a.sendEvents()
+}
+
+// PlayIfNot plays the flipbook unless it is already playing, so it can be called every frame (e.g. with the run animation while moving)
+func (a *Animator) PlayIfNot(fb *Flipbook) {
+
+	if a.Flipbook == fb && a.isPlaying {
+		return
+	}
+
+	a.Play(fb)
+}
+
+// Pause stops advancing frames, keeping the current frame
+func (a *Animator) Pause() {
+	a.isPlaying = false
+}
+
+// Resume continues after Pause. Finished flipbooks stay finished
+func (a *Animator) Resume() {
+	a.isPlaying = a.Flipbook != nil && !a.isDone
+}
+
+func (a *Animator) IsPlaying() bool {
+	return a.isPlaying
+}
+
+// IsDone returns true once a LoopMode_Once flipbook reached its last frame. Looping flipbooks are never done
+func (a *Animator) IsDone() bool {
+	return a.isDone
+}
+
+func (a *Animator) FrameIndex() int32 {
+	return a.frameIndex
+}
+
+// SetFrameIndex jumps to a frame without sending its events
+func (a *Animator) SetFrameIndex(i int32) {
+
+	if a.Flipbook == nil || len(a.Flipbook.Frames) == 0 {
+		return
+	}
+
+	a.frameIndex = min(max(i, 0), int32(len(a.Flipbook.Frames))-1)
+	a.elapsed = 0
+}
+
+// Frame returns the current frame, or nil when there is no flipbook or it has no frames
+func (a *Animator) Frame() *Frame {
+
+	if a.Flipbook == nil || len(a.Flipbook.Frames) == 0 {
+		return nil
+	}
+
+	return &a.Flipbook.Frames[a.frameIndex]
+}
+
+// Update advances the animation by dt seconds, sending the events of every frame it passes
+func (a *Animator) Update(dt float32) {
+
+	if !a.isPlaying || a.Flipbook == nil || a.Flipbook.FPS <= 0 || len(a.Flipbook.Frames) == 0 {
+		return
+	}
+
+	a.elapsed += dt * a.Speed
+
+	frameDur := 1 / a.Flipbook.FPS
+	for a.isPlaying && a.elapsed >= frameDur {
+		a.elapsed -= frameDur
+		a.nextFrame()
+	}
+}
+
+func (a *Animator) nextFrame() {
+
+	fb := a.Flipbook
+	lastIndex := int32(len(fb.Frames)) - 1
+	next := a.frameIndex + a.direction
+
+	switch fb.Loop {
+	case LoopMode_Once:
+
+		if next > lastIndex {
+
+			a.isPlaying = false
+			a.isDone = true
+			a.elapsed = 0
+
+			if a.OnFinished != nil {
+				a.OnFinished(a)
+			}
+			return
+		}
+
+	case LoopMode_Loop:
+
+		if next > lastIndex {
+			next = 0
+		}
+
+	case LoopMode_PingPong:
+
+		if next > lastIndex || next < 0 {
+			a.direction = -a.direction
+			next = min(max(a.frameIndex+a.direction, 0), lastIndex)
+		}
+	}
+
+	a.frameIndex = next
+	a.sendEvents()
+}
+
+func (a *Animator) sendEvents() {
+
+	if a.OnEvent == nil {
+		return
+	}
+
+	for _, e := range a.Flipbook.Events {
+		if e.Frame == a.frameIndex {
+			a.OnEvent(a, e)
+		}
+	}
+}
+
+func NewAnimator() Animator {
+	return Animator{
+		Speed:     1,
+		direction: 1,
+	}
+}
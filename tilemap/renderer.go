@@ -0,0 +1,440 @@
+package tilemap
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/assets"
+	"github.com/bloeys/nmage/buffers"
+	"github.com/bloeys/nmage/logging"
+	"github.com/bloeys/nmage/materials"
+	"github.com/bloeys/nmage/renderer"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+const (
+	// ChunkSize is the width and height in tiles of the chunks layers are split into. Each chunk is a static buffer
+	// drawn with one draw call per tileset it uses
+	ChunkSize = 16
+
+	// MaxAnimatedTiles is how many animated tiles a tileset can have. Must match the size of animFrames in the shader
+	MaxAnimatedTiles = 64
+
+	// floatsPerVertex is position (2), corner (2) and tile (2)
+	floatsPerVertex = 6
+)
+
+const tileShader = `
+//shader:vertex
+#version 410
+
+layout(location=0) in vec2 vertPosIn;
+layout(location=1) in vec2 vertCornerIn;
+
+// x is the tile id and y is the animation slot plus one, where zero means the tile isn't animated
+layout(location=2) in vec2 vertTileIn;
+
+uniform mat4 projViewMat;
+uniform mat4 modelMat;
+
+uniform vec2 texSize;
+uniform vec2 tileSize;
+uniform float tileMargin;
+uniform float tileSpacing;
+uniform int tileColumns;
+uniform int animFrames[64];
+
+out vec2 vertUV0;
+
+void main()
+{
+    int tileId = int(vertTileIn.x + 0.5);
+    int animSlot = int(vertTileIn.y + 0.5) - 1;
+    if (animSlot >= 0)
+        tileId = animFrames[animSlot];
+
+    vec2 cell = vec2(tileId % tileColumns, tileId / tileColumns);
+
+    // Corners are pulled in slightly so neighboring tiles in the image don't bleed in
+    vec2 pixel = vec2(tileMargin) + cell * (tileSize + vec2(tileSpacing)) + mix(vec2(0.01), tileSize - vec2(0.01), vertCornerIn);
+
+    // Images are stored bottom up
+    vertUV0 = vec2(pixel.x / texSize.x, 1 - pixel.y / texSize.y);
+
+    gl_Position = projViewMat * modelMat * vec4(vertPosIn, 0, 1);
+}
+
+//shader:fragment
+#version 410
+
+uniform sampler2D diffTex;
+uniform float opacity;
+
+in vec2 vertUV0;
+
+out vec4 fragColor;
+
+void main()
+{
+    vec4 c = texture(diffTex, vertUV0);
+    c.a *= opacity;
+    if (c.a < 0.01)
+        discard;
+
+    fragColor = c;
+}
+`
+
+// chunkDraw is the tiles of one tileset in a chunk
+type chunkDraw struct {
+	tilesetIndex int
+	first        int32
+	count        int32
+}
+
+type chunk struct {
+	layerIndex int
+	vao        buffers.VertexArray
+	draws      []chunkDraw
+}
+
+// tileAnim is an animated tile of a tileset and its playback state
+type tileAnim struct {
+	frames     []AnimationFrame
+	frameIndex int
+	elapsed    float32
+}
+
+type tilesetGpu struct {
+	tex assets.Texture
+	mat materials.Material
+
+	anims []tileAnim
+
+	// animSlots maps animated tile ids to their slot in anims
+	animSlots     map[uint32]int32
+	animFrames    [MaxAnimatedTiles]int32
+	isAnimChanged bool
+}
+
+// Renderer draws a map with its tile layers split into chunks of static buffers, so drawing doesn't touch tiles on the CPU.
+// Animated tiles are changed in the shader, so animations don't rebuild buffers either
+type Renderer struct {
+	Map *Map
+
+	// Transform places the map in the world
+	Transform gglm.TrMat
+
+	tilesets []tilesetGpu
+	chunks   []chunk
+}
+
+// Update advances tile animations by dt seconds
+func (r *Renderer) Update(dt float32) {
+
+	for i := 0; i < len(r.tilesets); i++ {
+
+		ts := &r.tilesets[i]
+		for slot := 0; slot < len(ts.anims); slot++ {
+
+			a := &ts.anims[slot]
+			a.elapsed += dt
+
+			changed := false
+			for a.elapsed >= a.frames[a.frameIndex].Duration {
+
+				// Zero length frames would loop forever
+				if a.frames[a.frameIndex].Duration <= 0 {
+					break
+				}
+
+				a.elapsed -= a.frames[a.frameIndex].Duration
+				a.frameIndex = (a.frameIndex + 1) % len(a.frames)
+				changed = true
+			}
+
+			if changed {
+				ts.animFrames[slot] = int32(a.frames[a.frameIndex].TileID)
+				ts.isAnimChanged = true
+			}
+		}
+	}
+}
+
+// Draw draws the visible layers in order. Depth testing is disabled while drawing so later layers are on top
+func (r *Renderer) Draw(rend renderer.Render, projViewMat *gglm.Mat4) {
+
+	for i := 0; i < len(r.tilesets); i++ {
+
+		ts := &r.tilesets[i]
+		ts.mat.SetUnifMat4("projViewMat", projViewMat)
+		ts.mat.SetUnifMat4("modelMat", &r.Transform.Mat4)
+
+		if ts.isAnimChanged {
+			gl.ProgramUniform1iv(ts.mat.ShaderProg.Id, ts.mat.GetUnifLoc("animFrames"), MaxAnimatedTiles, &ts.animFrames[0])
+			ts.isAnimChanged = false
+		}
+	}
+
+	gl.Disable(gl.DEPTH_TEST)
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+
+	for i := 0; i < len(r.chunks); i++ {
+
+		c := &r.chunks[i]
+		layer := &r.Map.Layers[c.layerIndex]
+		if !layer.Visible {
+			continue
+		}
+
+		for j := 0; j < len(c.draws); j++ {
+
+			d := &c.draws[j]
+			mat := &r.tilesets[d.tilesetIndex].mat
+			mat.SetUnifFloat32("opacity", layer.Opacity)
+			rend.DrawVertexArray(mat, &c.vao, d.first, d.count)
+		}
+	}
+
+	gl.Enable(gl.DEPTH_TEST)
+}
+
+// Rebuild builds the chunk buffers again, which is needed after changing cells with Map.SetGid
+func (r *Renderer) Rebuild() {
+	r.deleteChunks()
+	r.buildChunks()
+}
+
+func (r *Renderer) buildChunks() {
+
+	m := r.Map
+	chunksX := (m.Width + ChunkSize - 1) / ChunkSize
+	chunksY := (m.Height + ChunkSize - 1) / ChunkSize
+
+	// Vertices of each tileset in the current chunk, concatenated into one buffer per chunk
+	tsVerts := make([][]float32, len(m.Tilesets))
+	allVerts := make([]float32, 0, ChunkSize*ChunkSize*6*floatsPerVertex)
+
+	for li := 0; li < len(m.Layers); li++ {
+		for cy := int32(0); cy < chunksY; cy++ {
+			for cx := int32(0); cx < chunksX; cx++ {
+
+				for i := range tsVerts {
+					tsVerts[i] = tsVerts[i][:0]
+				}
+
+				for y := cy * ChunkSize; y < min((cy+1)*ChunkSize, m.Height); y++ {
+					for x := cx * ChunkSize; x < min((cx+1)*ChunkSize, m.Width); x++ {
+
+						gid := m.Layers[li].Gids[y*m.Width+x]
+						tsIndex := m.TilesetIndex(gid)
+						if tsIndex == -1 {
+							continue
+						}
+
+						tsVerts[tsIndex] = r.appendTile(tsVerts[tsIndex], li, tsIndex, gid, x, y)
+					}
+				}
+
+				c := chunk{layerIndex: li}
+				allVerts = allVerts[:0]
+				for tsIndex, verts := range tsVerts {
+
+					if len(verts) == 0 {
+						continue
+					}
+
+					c.draws = append(c.draws, chunkDraw{
+						tilesetIndex: tsIndex,
+						first:        int32(len(allVerts) / floatsPerVertex),
+						count:        int32(len(verts) / floatsPerVertex),
+					})
+					allVerts = append(allVerts, verts...)
+				}
+
+				if len(c.draws) == 0 {
+					continue
+				}
+
+				c.vao = buffers.NewVertexArray()
+				vbo := buffers.NewVertexBuffer(
+					buffers.Element{ElementType: buffers.DataTypeVec2},
+					buffers.Element{ElementType: buffers.DataTypeVec2},
+					buffers.Element{ElementType: buffers.DataTypeVec2},
+				)
+				vbo.SetData(allVerts, buffers.BufUsage_Static_Draw)
+				c.vao.AddVertexBuffer(vbo)
+
+				r.chunks = append(r.chunks, c)
+			}
+		}
+	}
+}
+
+// appendTile adds the two triangles of a cell. Tiles are aligned to the bottom left of their cell like in Tiled
+func (r *Renderer) appendTile(verts []float32, layerIndex, tsIndex int, gid uint32, x, y int32) []float32 {
+
+	m := r.Map
+	ts := &m.Tilesets[tsIndex]
+	gpu := &r.tilesets[tsIndex]
+	offset := &m.Layers[layerIndex].Offset
+
+	tileId := GidWithoutFlags(gid) - ts.FirstGid
+	animSlot := float32(0)
+	if slot, ok := gpu.animSlots[tileId]; ok {
+		animSlot = float32(slot + 1)
+	}
+
+	ppu := m.PixelsPerUnit
+	left := (float32(x*m.TileWidth) + offset.X()) / ppu
+	bottom := -(float32((y+1)*m.TileHeight) + offset.Y()) / ppu
+	right := left + float32(ts.TileWidth)/ppu
+	top := bottom + float32(ts.TileHeight)/ppu
+
+	// Corners are (0, 0) at the top left of the tile's image. Tiled flips diagonally first, then horizontally and vertically
+	corners := [4][2]float32{{0, 0}, {1, 0}, {1, 1}, {0, 1}}
+	for i := range corners {
+
+		c := &corners[i]
+		if gid&GidFlag_FlipDiagonal != 0 {
+			c[0], c[1] = c[1], c[0]
+		}
+
+		if gid&GidFlag_FlipHorizontal != 0 {
+			c[0] = 1 - c[0]
+		}
+
+		if gid&GidFlag_FlipVertical != 0 {
+			c[1] = 1 - c[1]
+		}
+	}
+
+	tl := [floatsPerVertex]float32{left, top, corners[0][0], corners[0][1], float32(tileId), animSlot}
+	tr := [floatsPerVertex]float32{right, top, corners[1][0], corners[1][1], float32(tileId), animSlot}
+	br := [floatsPerVertex]float32{right, bottom, corners[2][0], corners[2][1], float32(tileId), animSlot}
+	bl := [floatsPerVertex]float32{left, bottom, corners[3][0], corners[3][1], float32(tileId), animSlot}
+
+	// Counter clockwise
+	verts = append(verts, tl[:]...)
+	verts = append(verts, bl[:]...)
+	verts = append(verts, br[:]...)
+	verts = append(verts, tl[:]...)
+	verts = append(verts, br[:]...)
+	verts = append(verts, tr[:]...)
+
+	return verts
+}
+
+func (r *Renderer) deleteChunks() {
+
+	for i := 0; i < len(r.chunks); i++ {
+		r.chunks[i].vao.Delete()
+	}
+
+	r.chunks = r.chunks[:0]
+}
+
+func (r *Renderer) Delete() {
+
+	r.deleteChunks()
+
+	for i := 0; i < len(r.tilesets); i++ {
+		r.tilesets[i].mat.Delete()
+		r.tilesets[i].tex.Delete()
+	}
+
+	r.tilesets = nil
+}
+
+func loadTilesetTexture(path string) (assets.Texture, error) {
+
+	var tex assets.Texture
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		tex, err = assets.LoadTexturePNG(path, nil)
+	case ".jpg", ".jpeg":
+		tex, err = assets.LoadTextureJpeg(path, nil)
+	default:
+		err = fmt.Errorf("unsupported tileset image extension '%s'. Supported extensions are .png, .jpg and .jpeg", filepath.Ext(path))
+	}
+
+	if err != nil {
+		return assets.Texture{}, err
+	}
+
+	// Tiles are usually pixel art, and filtering would also blend in the pixels of neighboring tiles
+	gl.BindTexture(gl.TEXTURE_2D, tex.TexID)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+
+	return tex, nil
+}
+
+// NewRenderer loads the tileset images of the map and builds its chunks. The map is kept by pointer, so
+// changes to layer visibility and opacity show right away
+func NewRenderer(m *Map) (Renderer, error) {
+
+	r := Renderer{
+		Map:       m,
+		Transform: gglm.NewTrMatId(),
+		tilesets:  make([]tilesetGpu, len(m.Tilesets)),
+	}
+
+	for i := 0; i < len(m.Tilesets); i++ {
+
+		ts := &m.Tilesets[i]
+		gpu := &r.tilesets[i]
+
+		tex, err := loadTilesetTexture(ts.ImagePath)
+		if err != nil {
+			r.Delete()
+			return Renderer{}, fmt.Errorf("failed to load image of tileset '%s'. Err: %w", ts.Name, err)
+		}
+
+		gpu.tex = tex
+		gpu.mat, err = materials.NewMaterialSrc("Tilemap Mat: "+ts.Name, []byte(tileShader))
+		if err != nil {
+			r.Delete()
+			return Renderer{}, fmt.Errorf("failed to create material of tileset '%s'. Err: %w", ts.Name, err)
+		}
+		gpu.mat.DiffuseTex = tex.TexID
+		gpu.mat.SetUnifInt32("diffTex", int32(materials.TextureSlot_Diffuse))
+
+		// The image size in the tileset is what the tiles were laid out on, but the loaded size is what the uvs need
+		texSize := gglm.NewVec2(float32(tex.Width), float32(tex.Height))
+		tileSize := gglm.NewVec2(float32(ts.TileWidth), float32(ts.TileHeight))
+		gpu.mat.SetUnifVec2("texSize", &texSize)
+		gpu.mat.SetUnifVec2("tileSize", &tileSize)
+		gpu.mat.SetUnifFloat32("tileMargin", float32(ts.Margin))
+		gpu.mat.SetUnifFloat32("tileSpacing", float32(ts.Spacing))
+		gpu.mat.SetUnifInt32("tileColumns", max(ts.Columns, 1))
+		gpu.mat.SetUnifFloat32("opacity", 1)
+
+		gpu.animSlots = make(map[uint32]int32)
+		for tileId, info := range ts.Tiles {
+
+			if len(info.Animation) == 0 {
+				continue
+			}
+
+			if len(gpu.anims) >= MaxAnimatedTiles {
+				logging.WarnLog.Printf("Tileset '%s' has more than %d animated tiles, so tile %d won't be animated\n", ts.Name, MaxAnimatedTiles, tileId)
+				continue
+			}
+
+			gpu.animFrames[len(gpu.anims)] = int32(info.Animation[0].TileID)
+			gpu.animSlots[tileId] = int32(len(gpu.anims))
+			gpu.anims = append(gpu.anims, tileAnim{frames: info.Animation})
+		}
+		gpu.isAnimChanged = true
+	}
+
+	r.buildChunks()
+	return r, nil
+}
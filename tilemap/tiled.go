@@ -0,0 +1,604 @@
+package tilemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/assets"
+)
+
+// Load reads a Tiled map saved as tmx (xml) or json (.tmj or .json), including external tilesets (.tsx, .tsj or .json).
+// The path is an asset path, and tileset images are relative to the file using them like in Tiled.
+//
+// Only orthogonal maps with a fixed size are supported. Load doesn't make GL calls, so maps can be loaded on
+// another goroutine (e.g. in engine.StatePreloader.Preload) and given to NewRenderer on the main thread
+func Load(path string) (Map, error) {
+
+	data, err := os.ReadFile(assets.ResolvePath(path))
+	if err != nil {
+		return Map{}, fmt.Errorf("failed to read tile map '%s'. Err: %w", path, err)
+	}
+
+	var m Map
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".tmx":
+		m, err = parseTmx(data, path)
+	case ".tmj", ".json":
+		m, err = parseTmj(data, path)
+	default:
+		err = fmt.Errorf("unknown tile map extension '%s'. Supported extensions are .tmx, .tmj and .json", filepath.Ext(path))
+	}
+
+	if err != nil {
+		return Map{}, fmt.Errorf("failed to load tile map '%s'. Err: %w", path, err)
+	}
+
+	m.PixelsPerUnit = float32(m.TileWidth)
+	m.SolidProperty = "solid"
+
+	slices.SortFunc(m.Tilesets, func(a, b Tileset) int {
+		return int(a.FirstGid) - int(b.FirstGid)
+	})
+
+	return m, nil
+}
+
+func loadExternalTileset(path string, firstGid uint32) (Tileset, error) {
+
+	data, err := os.ReadFile(assets.ResolvePath(path))
+	if err != nil {
+		return Tileset{}, fmt.Errorf("failed to read tileset '%s'. Err: %w", path, err)
+	}
+
+	var ts Tileset
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".tsx":
+		var xts tmxTileset
+		if err = xml.Unmarshal(data, &xts); err == nil {
+			ts, err = xts.toTileset(path)
+		}
+	case ".tsj", ".json":
+		var jts tmjTileset
+		if err = json.Unmarshal(data, &jts); err == nil {
+			ts, err = jts.toTileset(path)
+		}
+	default:
+		err = fmt.Errorf("unknown tileset extension '%s'. Supported extensions are .tsx, .tsj and .json", filepath.Ext(path))
+	}
+
+	if err != nil {
+		return Tileset{}, fmt.Errorf("failed to load tileset '%s'. Err: %w", path, err)
+	}
+
+	ts.FirstGid = firstGid
+	return ts, nil
+}
+
+// relativeTo returns the asset path of a file referenced by the file at ownerPath, since Tiled stores paths relative to the file using them
+func relativeTo(ownerPath, path string) string {
+
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(filepath.Dir(ownerPath), path)
+}
+
+// decodeLayerData turns layer data in any of Tiled's encodings into gids
+func decodeLayerData(encoding, compression, data string, cellCount int32) ([]uint32, error) {
+
+	gids := make([]uint32, 0, cellCount)
+	switch encoding {
+	case "csv":
+
+		for _, s := range strings.Split(data, ",") {
+
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+
+			gid, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid csv layer data. Err: %w", err)
+			}
+
+			gids = append(gids, uint32(gid))
+		}
+
+	case "base64":
+
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 layer data. Err: %w", err)
+		}
+
+		var r io.Reader = bytes.NewReader(raw)
+		switch compression {
+		case "":
+		case "zlib":
+			r, err = zlib.NewReader(r)
+		case "gzip":
+			r, err = gzip.NewReader(r)
+		default:
+			return nil, fmt.Errorf("unsupported layer compression '%s'. Supported compressions are zlib and gzip", compression)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress layer data. Err: %w", err)
+		}
+
+		raw, err = io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress layer data. Err: %w", err)
+		}
+
+		for i := 0; i+4 <= len(raw); i += 4 {
+			gids = append(gids, binary.LittleEndian.Uint32(raw[i:]))
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported layer encoding '%s'. Supported encodings are csv and base64", encoding)
+	}
+
+	if int32(len(gids)) != cellCount {
+		return nil, fmt.Errorf("layer has %d cells but the map has %d", len(gids), cellCount)
+	}
+
+	return gids, nil
+}
+
+func checkMapHeader(orientation string, infinite bool) error {
+
+	if orientation != "" && orientation != "orthogonal" {
+		return fmt.Errorf("unsupported map orientation '%s'. Only orthogonal maps are supported", orientation)
+	}
+
+	if infinite {
+		return fmt.Errorf("infinite maps aren't supported")
+	}
+
+	return nil
+}
+
+/*
+	Tmx (xml)
+*/
+
+type tmxProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+
+	// Multiline strings are stored as text instead of the value attribute
+	Text string `xml:",chardata"`
+}
+
+func tmxToProperties(props []tmxProperty) Properties {
+
+	p := make(Properties, len(props))
+	for _, prop := range props {
+
+		if prop.Value == "" {
+			p[prop.Name] = prop.Text
+			continue
+		}
+
+		p[prop.Name] = prop.Value
+	}
+
+	return p
+}
+
+type tmxTile struct {
+	Id         uint32        `xml:"id,attr"`
+	Properties []tmxProperty `xml:"properties>property"`
+	Frames     []struct {
+		TileId   uint32 `xml:"tileid,attr"`
+		Duration int32  `xml:"duration,attr"`
+	} `xml:"animation>frame"`
+}
+
+type tmxTileset struct {
+	FirstGid   uint32 `xml:"firstgid,attr"`
+	Source     string `xml:"source,attr"`
+	Name       string `xml:"name,attr"`
+	TileWidth  int32  `xml:"tilewidth,attr"`
+	TileHeight int32  `xml:"tileheight,attr"`
+	TileCount  int32  `xml:"tilecount,attr"`
+	Columns    int32  `xml:"columns,attr"`
+	Margin     int32  `xml:"margin,attr"`
+	Spacing    int32  `xml:"spacing,attr"`
+	Image      struct {
+		Source string `xml:"source,attr"`
+		Width  int32  `xml:"width,attr"`
+		Height int32  `xml:"height,attr"`
+	} `xml:"image"`
+	Tiles []tmxTile `xml:"tile"`
+}
+
+func (xts *tmxTileset) toTileset(ownerPath string) (Tileset, error) {
+
+	if xts.Image.Source == "" {
+		return Tileset{}, fmt.Errorf("tileset '%s' has no image. Image collection tilesets aren't supported", xts.Name)
+	}
+
+	ts := Tileset{
+		Name:        xts.Name,
+		FirstGid:    xts.FirstGid,
+		TileCount:   xts.TileCount,
+		Columns:     xts.Columns,
+		TileWidth:   xts.TileWidth,
+		TileHeight:  xts.TileHeight,
+		Margin:      xts.Margin,
+		Spacing:     xts.Spacing,
+		ImagePath:   relativeTo(ownerPath, xts.Image.Source),
+		ImageWidth:  xts.Image.Width,
+		ImageHeight: xts.Image.Height,
+		Tiles:       make(map[uint32]TileInfo, len(xts.Tiles)),
+	}
+
+	for _, t := range xts.Tiles {
+
+		info := TileInfo{Properties: tmxToProperties(t.Properties)}
+		for _, f := range t.Frames {
+			info.Animation = append(info.Animation, AnimationFrame{TileID: f.TileId, Duration: float32(f.Duration) / 1000})
+		}
+
+		ts.Tiles[t.Id] = info
+	}
+
+	return ts, nil
+}
+
+// tmxLayer is a tile layer or a group layer, which are read together so their order is kept
+type tmxLayer struct {
+	XMLName    xml.Name
+	Name       string        `xml:"name,attr"`
+	Visible    *int32        `xml:"visible,attr"`
+	Opacity    *float32      `xml:"opacity,attr"`
+	OffsetX    float32       `xml:"offsetx,attr"`
+	OffsetY    float32       `xml:"offsety,attr"`
+	Properties []tmxProperty `xml:"properties>property"`
+	Data       struct {
+		Encoding    string `xml:"encoding,attr"`
+		Compression string `xml:"compression,attr"`
+		Text        string `xml:",chardata"`
+		Tiles       []struct {
+			Gid uint32 `xml:"gid,attr"`
+		} `xml:"tile"`
+	} `xml:"data"`
+	Children []tmxLayer `xml:",any"`
+}
+
+type tmxMap struct {
+	Orientation string        `xml:"orientation,attr"`
+	Infinite    int32         `xml:"infinite,attr"`
+	Width       int32         `xml:"width,attr"`
+	Height      int32         `xml:"height,attr"`
+	TileWidth   int32         `xml:"tilewidth,attr"`
+	TileHeight  int32         `xml:"tileheight,attr"`
+	Properties  []tmxProperty `xml:"properties>property"`
+	Tilesets    []tmxTileset  `xml:"tileset"`
+	Layers      []tmxLayer    `xml:",any"`
+}
+
+func parseTmx(data []byte, path string) (Map, error) {
+
+	var xm tmxMap
+	if err := xml.Unmarshal(data, &xm); err != nil {
+		return Map{}, err
+	}
+
+	if err := checkMapHeader(xm.Orientation, xm.Infinite != 0); err != nil {
+		return Map{}, err
+	}
+
+	m := Map{
+		Width:      xm.Width,
+		Height:     xm.Height,
+		TileWidth:  xm.TileWidth,
+		TileHeight: xm.TileHeight,
+		Properties: tmxToProperties(xm.Properties),
+	}
+
+	for i := 0; i < len(xm.Tilesets); i++ {
+
+		xts := &xm.Tilesets[i]
+
+		var ts Tileset
+		var err error
+		if xts.Source != "" {
+			ts, err = loadExternalTileset(relativeTo(path, xts.Source), xts.FirstGid)
+		} else {
+			ts, err = xts.toTileset(path)
+		}
+
+		if err != nil {
+			return Map{}, err
+		}
+
+		m.Tilesets = append(m.Tilesets, ts)
+	}
+
+	err := addTmxLayers(&m, xm.Layers, true, 1, gglm.Vec2{})
+	return m, err
+}
+
+// addTmxLayers adds the tile layers of a list of layers to the map, flattening groups into the layers they have
+func addTmxLayers(m *Map, layers []tmxLayer, parentVisible bool, parentOpacity float32, parentOffset gglm.Vec2) error {
+
+	for i := 0; i < len(layers); i++ {
+
+		xl := &layers[i]
+		if xl.XMLName.Local != "layer" && xl.XMLName.Local != "group" {
+			continue
+		}
+
+		// Tiled leaves out attributes with default values
+		visible := parentVisible && (xl.Visible == nil || *xl.Visible != 0)
+		opacity := parentOpacity
+		if xl.Opacity != nil {
+			opacity *= *xl.Opacity
+		}
+		offset := gglm.NewVec2(parentOffset.X()+xl.OffsetX, parentOffset.Y()+xl.OffsetY)
+
+		if xl.XMLName.Local == "group" {
+			if err := addTmxLayers(m, xl.Children, visible, opacity, offset); err != nil {
+				return err
+			}
+			continue
+		}
+
+		l := Layer{
+			Name:       xl.Name,
+			Visible:    visible,
+			Opacity:    opacity,
+			Offset:     offset,
+			Properties: tmxToProperties(xl.Properties),
+		}
+
+		var err error
+		if xl.Data.Encoding == "" {
+
+			// The old xml format has a tile element per cell
+			l.Gids = make([]uint32, 0, len(xl.Data.Tiles))
+			for _, t := range xl.Data.Tiles {
+				l.Gids = append(l.Gids, t.Gid)
+			}
+
+			if int32(len(l.Gids)) != m.Width*m.Height {
+				err = fmt.Errorf("layer has %d cells but the map has %d", len(l.Gids), m.Width*m.Height)
+			}
+		} else {
+			l.Gids, err = decodeLayerData(xl.Data.Encoding, xl.Data.Compression, xl.Data.Text, m.Width*m.Height)
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to read layer '%s'. Err: %w", xl.Name, err)
+		}
+
+		m.Layers = append(m.Layers, l)
+	}
+
+	return nil
+}
+
+/*
+	Tmj (json)
+*/
+
+type tmjProperty struct {
+	Name  string          `json:"name"`
+	Value json.RawMessage `json:"value"`
+}
+
+func tmjToProperties(props []tmjProperty) Properties {
+
+	p := make(Properties, len(props))
+	for _, prop := range props {
+
+		// Strings are unquoted while other types are kept as written, so all types read the same as in tmx files
+		var s string
+		if err := json.Unmarshal(prop.Value, &s); err == nil {
+			p[prop.Name] = s
+			continue
+		}
+
+		p[prop.Name] = string(prop.Value)
+	}
+
+	return p
+}
+
+type tmjTileset struct {
+	FirstGid    uint32 `json:"firstgid"`
+	Source      string `json:"source"`
+	Name        string `json:"name"`
+	TileWidth   int32  `json:"tilewidth"`
+	TileHeight  int32  `json:"tileheight"`
+	TileCount   int32  `json:"tilecount"`
+	Columns     int32  `json:"columns"`
+	Margin      int32  `json:"margin"`
+	Spacing     int32  `json:"spacing"`
+	Image       string `json:"image"`
+	ImageWidth  int32  `json:"imagewidth"`
+	ImageHeight int32  `json:"imageheight"`
+	Tiles       []struct {
+		Id         uint32        `json:"id"`
+		Properties []tmjProperty `json:"properties"`
+		Animation  []struct {
+			TileId   uint32 `json:"tileid"`
+			Duration int32  `json:"duration"`
+		} `json:"animation"`
+	} `json:"tiles"`
+}
+
+func (jts *tmjTileset) toTileset(ownerPath string) (Tileset, error) {
+
+	if jts.Image == "" {
+		return Tileset{}, fmt.Errorf("tileset '%s' has no image. Image collection tilesets aren't supported", jts.Name)
+	}
+
+	ts := Tileset{
+		Name:        jts.Name,
+		FirstGid:    jts.FirstGid,
+		TileCount:   jts.TileCount,
+		Columns:     jts.Columns,
+		TileWidth:   jts.TileWidth,
+		TileHeight:  jts.TileHeight,
+		Margin:      jts.Margin,
+		Spacing:     jts.Spacing,
+		ImagePath:   relativeTo(ownerPath, jts.Image),
+		ImageWidth:  jts.ImageWidth,
+		ImageHeight: jts.ImageHeight,
+		Tiles:       make(map[uint32]TileInfo, len(jts.Tiles)),
+	}
+
+	for _, t := range jts.Tiles {
+
+		info := TileInfo{Properties: tmjToProperties(t.Properties)}
+		for _, f := range t.Animation {
+			info.Animation = append(info.Animation, AnimationFrame{TileID: f.TileId, Duration: float32(f.Duration) / 1000})
+		}
+
+		ts.Tiles[t.Id] = info
+	}
+
+	return ts, nil
+}
+
+type tmjLayer struct {
+	Type        string          `json:"type"`
+	Name        string          `json:"name"`
+	Visible     bool            `json:"visible"`
+	Opacity     float32         `json:"opacity"`
+	OffsetX     float32         `json:"offsetx"`
+	OffsetY     float32         `json:"offsety"`
+	Properties  []tmjProperty   `json:"properties"`
+	Encoding    string          `json:"encoding"`
+	Compression string          `json:"compression"`
+	Data        json.RawMessage `json:"data"`
+	Layers      []tmjLayer      `json:"layers"`
+}
+
+type tmjMap struct {
+	Orientation string        `json:"orientation"`
+	Infinite    bool          `json:"infinite"`
+	Width       int32         `json:"width"`
+	Height      int32         `json:"height"`
+	TileWidth   int32         `json:"tilewidth"`
+	TileHeight  int32         `json:"tileheight"`
+	Properties  []tmjProperty `json:"properties"`
+	Tilesets    []tmjTileset  `json:"tilesets"`
+	Layers      []tmjLayer    `json:"layers"`
+}
+
+func parseTmj(data []byte, path string) (Map, error) {
+
+	var jm tmjMap
+	if err := json.Unmarshal(data, &jm); err != nil {
+		return Map{}, err
+	}
+
+	if err := checkMapHeader(jm.Orientation, jm.Infinite); err != nil {
+		return Map{}, err
+	}
+
+	m := Map{
+		Width:      jm.Width,
+		Height:     jm.Height,
+		TileWidth:  jm.TileWidth,
+		TileHeight: jm.TileHeight,
+		Properties: tmjToProperties(jm.Properties),
+	}
+
+	for i := 0; i < len(jm.Tilesets); i++ {
+
+		jts := &jm.Tilesets[i]
+
+		var ts Tileset
+		var err error
+		if jts.Source != "" {
+			ts, err = loadExternalTileset(relativeTo(path, jts.Source), jts.FirstGid)
+		} else {
+			ts, err = jts.toTileset(path)
+		}
+
+		if err != nil {
+			return Map{}, err
+		}
+
+		m.Tilesets = append(m.Tilesets, ts)
+	}
+
+	err := addTmjLayers(&m, jm.Layers, true, 1, gglm.Vec2{})
+	return m, err
+}
+
+// addTmjLayers adds the tile layers of a list of layers to the map, flattening groups into the layers they have
+func addTmjLayers(m *Map, layers []tmjLayer, parentVisible bool, parentOpacity float32, parentOffset gglm.Vec2) error {
+
+	for i := 0; i < len(layers); i++ {
+
+		jl := &layers[i]
+		if jl.Type != "tilelayer" && jl.Type != "group" {
+			continue
+		}
+
+		visible := parentVisible && jl.Visible
+		opacity := parentOpacity * jl.Opacity
+		offset := gglm.NewVec2(parentOffset.X()+jl.OffsetX, parentOffset.Y()+jl.OffsetY)
+
+		if jl.Type == "group" {
+			if err := addTmjLayers(m, jl.Layers, visible, opacity, offset); err != nil {
+				return err
+			}
+			continue
+		}
+
+		l := Layer{
+			Name:       jl.Name,
+			Visible:    visible,
+			Opacity:    opacity,
+			Offset:     offset,
+			Properties: tmjToProperties(jl.Properties),
+		}
+
+		// Csv data is a json array, while base64 data is a string
+		var err error
+		if jl.Encoding == "" || jl.Encoding == "csv" {
+
+			err = json.Unmarshal(jl.Data, &l.Gids)
+			if err == nil && int32(len(l.Gids)) != m.Width*m.Height {
+				err = fmt.Errorf("layer has %d cells but the map has %d", len(l.Gids), m.Width*m.Height)
+			}
+		} else {
+
+			var s string
+			if err = json.Unmarshal(jl.Data, &s); err == nil {
+				l.Gids, err = decodeLayerData(jl.Encoding, jl.Compression, s, m.Width*m.Height)
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to read layer '%s'. Err: %w", jl.Name, err)
+		}
+
+		m.Layers = append(m.Layers, l)
+	}
+
+	return nil
+}
@@ -0,0 +1,271 @@
+// The tilemap package loads 2D tile maps made with Tiled (https://www.mapeditor.org), draws them with Renderer,
+// and answers tile queries for gameplay like collision.
+//
+// Maps are in the XY plane with +Y up. The top left corner of the map is at the origin and rows go down along -Y,
+// so a tile's world position matches where it is in the editor
+package tilemap
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/bloeys/gglm/gglm"
+)
+
+// Tiled stores whether a tile is flipped in the top bits of its gid
+const (
+	GidFlag_FlipHorizontal uint32 = 0x80000000
+	GidFlag_FlipVertical   uint32 = 0x40000000
+	GidFlag_FlipDiagonal   uint32 = 0x20000000
+	GidFlag_RotateHex120   uint32 = 0x10000000
+
+	gidFlagsMask = GidFlag_FlipHorizontal | GidFlag_FlipVertical | GidFlag_FlipDiagonal | GidFlag_RotateHex120
+)
+
+// GidWithoutFlags returns the gid with its flip flags cleared, which is the tile it shows. A gid of zero is an empty cell
+func GidWithoutFlags(gid uint32) uint32 {
+	return gid &^ gidFlagsMask
+}
+
+// Properties are the custom properties set in Tiled, stored as text whatever their type
+type Properties map[string]string
+
+func (p Properties) String(name string) string {
+	return p[name]
+}
+
+// Bool returns false for properties that are missing or aren't bools
+func (p Properties) Bool(name string) bool {
+	b, _ := strconv.ParseBool(p[name])
+	return b
+}
+
+// Float returns zero for properties that are missing or aren't numbers
+func (p Properties) Float(name string) float32 {
+	f, _ := strconv.ParseFloat(p[name], 32)
+	return float32(f)
+}
+
+type AnimationFrame struct {
+	// TileID is the id of the frame's tile inside the tileset
+	TileID uint32
+
+	// Duration is how long the frame shows in seconds
+	Duration float32
+}
+
+// TileInfo is what Tiled knows about a tile besides its image
+type TileInfo struct {
+	Properties Properties
+
+	// Animation makes the tile cycle through these frames. Empty for tiles that aren't animated
+	Animation []AnimationFrame
+}
+
+// Tileset is a grid of tiles in an image, where tile ids start at zero in the top left and go row by row
+type Tileset struct {
+	Name string
+
+	// FirstGid is the gid of the tileset's first tile in the map
+	FirstGid uint32
+
+	TileCount int32
+	Columns   int32
+
+	// TileWidth and TileHeight are the size of a tile in pixels. Tiles bigger than the map's grid stick out above their cell
+	TileWidth  int32
+	TileHeight int32
+
+	// Margin is the space around the tiles and Spacing the space between them, in pixels
+	Margin  int32
+	Spacing int32
+
+	// ImagePath is an asset path relative to assets.Root, or to the working directory for absolute maps
+	ImagePath   string
+	ImageWidth  int32
+	ImageHeight int32
+
+	// Tiles has the info of tiles with properties or animations, by tile id
+	Tiles map[uint32]TileInfo
+}
+
+// Layer is a grid of gids the size of the map. Tiled group layers are flattened into their tile layers
+type Layer struct {
+	Name    string
+	Visible bool
+	Opacity float32
+
+	// Offset is how far the layer is moved from the grid in pixels, with +Y down like in Tiled
+	Offset gglm.Vec2
+
+	Properties Properties
+
+	// Gids are the cells of the layer row by row, including flip flags
+	Gids []uint32
+}
+
+type Map struct {
+	// Width and Height are the size of the map in tiles
+	Width  int32
+	Height int32
+
+	// TileWidth and TileHeight are the size of the map's grid cells in pixels
+	TileWidth  int32
+	TileHeight int32
+
+	// PixelsPerUnit is how many pixels are one world unit. Load sets it to TileWidth so a tile is one unit wide.
+	// It must be set before creating a Renderer, since tiles are placed in world units when their buffers are built
+	PixelsPerUnit float32
+
+	// SolidProperty is the tile property that makes tiles solid in collision queries. Tiles of layers with this property
+	// set to true are all solid. Load sets it to "solid"
+	SolidProperty string
+
+	Tilesets   []Tileset
+	Layers     []Layer
+	Properties Properties
+}
+
+// LayerIndex returns the index of the first layer with the name, or -1 if there is none
+func (m *Map) LayerIndex(name string) int {
+
+	for i := 0; i < len(m.Layers); i++ {
+		if m.Layers[i].Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (m *Map) IsInside(x, y int32) bool {
+	return x >= 0 && y >= 0 && x < m.Width && y < m.Height
+}
+
+// Gid returns the gid of a layer's cell including flip flags, or zero for empty cells and cells outside the map
+func (m *Map) Gid(layer int, x, y int32) uint32 {
+
+	if layer < 0 || layer >= len(m.Layers) || !m.IsInside(x, y) {
+		return 0
+	}
+
+	return m.Layers[layer].Gids[y*m.Width+x]
+}
+
+// SetGid changes a layer's cell. Renderers don't see the change until they are rebuilt
+func (m *Map) SetGid(layer int, x, y int32, gid uint32) {
+
+	if layer < 0 || layer >= len(m.Layers) || !m.IsInside(x, y) {
+		return
+	}
+
+	m.Layers[layer].Gids[y*m.Width+x] = gid
+}
+
+// TilesetIndex returns the index of the tileset a gid belongs to, or -1 for empty cells
+func (m *Map) TilesetIndex(gid uint32) int {
+
+	gid = GidWithoutFlags(gid)
+	if gid == 0 {
+		return -1
+	}
+
+	// Tilesets are sorted by their first gid, so the gid belongs to the last one starting at or before it
+	for i := len(m.Tilesets) - 1; i >= 0; i-- {
+		if m.Tilesets[i].FirstGid <= gid {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// TileInfo returns the info of the tile a gid shows, which is false if the tile has no properties or animation
+func (m *Map) TileInfo(gid uint32) (TileInfo, bool) {
+
+	tsIndex := m.TilesetIndex(gid)
+	if tsIndex == -1 {
+		return TileInfo{}, false
+	}
+
+	ts := &m.Tilesets[tsIndex]
+	info, ok := ts.Tiles[GidWithoutFlags(gid)-ts.FirstGid]
+	return info, ok
+}
+
+// IsSolid returns true if any layer has a solid tile in the cell (see SolidProperty), including hidden layers so collision layers
+// can be hidden. Cells outside the map aren't solid
+func (m *Map) IsSolid(x, y int32) bool {
+
+	if !m.IsInside(x, y) {
+		return false
+	}
+
+	for i := 0; i < len(m.Layers); i++ {
+
+		l := &m.Layers[i]
+		gid := l.Gids[y*m.Width+x]
+		if gid == 0 {
+			continue
+		}
+
+		if l.Properties.Bool(m.SolidProperty) {
+			return true
+		}
+
+		if info, ok := m.TileInfo(gid); ok && info.Properties.Bool(m.SolidProperty) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WorldToTile returns the cell containing a world position. The cell can be outside the map
+func (m *Map) WorldToTile(worldX, worldY float32) (x, y int32) {
+
+	x = int32(math.Floor(float64(worldX * m.PixelsPerUnit / float32(m.TileWidth))))
+	y = int32(math.Floor(float64(-worldY * m.PixelsPerUnit / float32(m.TileHeight))))
+	return x, y
+}
+
+// TileToWorld returns the world position of the top left corner of a cell
+func (m *Map) TileToWorld(x, y int32) gglm.Vec2 {
+	return gglm.NewVec2(
+		float32(x*m.TileWidth)/m.PixelsPerUnit,
+		-float32(y*m.TileHeight)/m.PixelsPerUnit,
+	)
+}
+
+// TileSize returns the size of a cell in world units
+func (m *Map) TileSize() gglm.Vec2 {
+	return gglm.NewVec2(float32(m.TileWidth)/m.PixelsPerUnit, float32(m.TileHeight)/m.PixelsPerUnit)
+}
+
+// OverlapsSolid returns true if the world space box between boxMin and boxMax touches a solid cell, e.g. to check if a character can move somewhere.
+// Boxes resting exactly on a cell's edge don't touch it
+func (m *Map) OverlapsSolid(boxMin, boxMax gglm.Vec2) bool {
+
+	// In pixels with +Y down like the grid
+	left := float64(boxMin.X() * m.PixelsPerUnit)
+	right := float64(boxMax.X() * m.PixelsPerUnit)
+	top := float64(-boxMax.Y() * m.PixelsPerUnit)
+	bottom := float64(-boxMin.Y() * m.PixelsPerUnit)
+
+	tw := float64(m.TileWidth)
+	th := float64(m.TileHeight)
+	minX := max(int32(math.Floor(left/tw)), 0)
+	maxX := min(int32(math.Ceil(right/tw))-1, m.Width-1)
+	minY := max(int32(math.Floor(top/th)), 0)
+	maxY := min(int32(math.Ceil(bottom/th))-1, m.Height-1)
+
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			if m.IsSolid(x, y) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
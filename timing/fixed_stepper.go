@@ -0,0 +1,95 @@
+package timing
+
+// DefaultMaxFixedSteps is the max steps per frame of new fixed steppers
+const DefaultMaxFixedSteps = 8
+
+// DefaultFixedStepRate is the ticks per second of fixed steppers made with a rate of zero or less, and of the zero value FixedStepper
+const DefaultFixedStepRate = 60
+
+// FixedStepper turns variable frame times into a number of fixed size ticks, for code that needs a constant
+// timestep like physics and networked simulation. Usage:
+//
+//	steps, alpha := stepper.Update()
+//	for i := int32(0); i < steps; i++ {
+//		simulate(stepper.StepDT())
+//	}
+//	render(lerp(prevState, currState, alpha))
+//
+// The zero value ticks DefaultFixedStepRate times per second without a MaxSteps limit
+type FixedStepper struct {
+	stepDT float32
+
+	// MaxSteps limits the ticks of a single frame. When a frame needs more, the extra time is dropped and the simulation
+	// runs slower than real time, instead of each slow frame needing more ticks and making the next one slower too
+	MaxSteps int32
+
+	accumulator float32
+	alpha       float32
+}
+
+// Update advances the stepper by DT, so it follows the time scale and ticks zero times while paused. Should be called once per frame
+func (fs *FixedStepper) Update() (steps int32, alpha float32) {
+	return fs.Advance(DT())
+}
+
+// Advance adds dt seconds and returns how many ticks to run now, and alpha which is how far (0 to 1) the leftover time is
+// into the next tick. Alpha is used to interpolate between the last two simulated states when rendering
+func (fs *FixedStepper) Advance(dt float32) (steps int32, alpha float32) {
+
+	fs.setDefaultStepDT()
+	fs.accumulator += max(dt, 0)
+
+	steps = int32(fs.accumulator / fs.stepDT)
+	if fs.MaxSteps > 0 && steps > fs.MaxSteps {
+		steps = fs.MaxSteps
+		fs.accumulator = fs.stepDT * float32(steps)
+	}
+
+	fs.accumulator -= fs.stepDT * float32(steps)
+	fs.alpha = min(fs.accumulator/fs.stepDT, 1)
+
+	return steps, fs.alpha
+}
+
+// StepDT is the size of a tick in seconds
+func (fs *FixedStepper) StepDT() float32 {
+	fs.setDefaultStepDT()
+	return fs.stepDT
+}
+
+// Rate is the number of ticks per second
+func (fs *FixedStepper) Rate() float32 {
+	fs.setDefaultStepDT()
+	return 1 / fs.stepDT
+}
+
+// setDefaultStepDT gives the zero value a step size, since ticks of zero seconds would divide by zero
+func (fs *FixedStepper) setDefaultStepDT() {
+	if fs.stepDT <= 0 {
+		fs.stepDT = 1.0 / DefaultFixedStepRate
+	}
+}
+
+// Alpha returns the alpha of the last Advance
+func (fs *FixedStepper) Alpha() float32 {
+	return fs.alpha
+}
+
+// Reset drops the accumulated time, e.g. after loading a level so the load time isn't simulated
+func (fs *FixedStepper) Reset() {
+	fs.accumulator = 0
+	fs.alpha = 0
+}
+
+// NewFixedStepper returns a stepper that ticks rate times per second. Rates of zero or less use DefaultFixedStepRate
+func NewFixedStepper(rate float32) FixedStepper {
+
+	if rate <= 0 {
+		rate = DefaultFixedStepRate
+	}
+
+	return FixedStepper{
+		stepDT:   1 / rate,
+		MaxSteps: DefaultMaxFixedSteps,
+	}
+}
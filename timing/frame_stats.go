@@ -0,0 +1,136 @@
+package timing
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// FrameHistorySize is how many of the latest frame times are kept for stats and export
+const FrameHistorySize = 1000
+
+// FrameStats are stats over the frame times in the history, in milliseconds
+type FrameStats struct {
+	Count int32
+
+	Min float32
+	Max float32
+	Avg float32
+
+	P50 float32
+	P95 float32
+	P99 float32
+}
+
+// FrameSpikeCallback is called at the end of frames that took longer than the spike threshold, with the frame's time
+type FrameSpikeCallback func(frameTime time.Duration)
+
+var (
+	// frameTimesMs is a ring of unscaled frame times, where frameTimesHead is the oldest one once the ring is full
+	frameTimesMs   = make([]float32, 0, FrameHistorySize)
+	frameTimesHead int
+
+	frameStats        FrameStats
+	isFrameStatsDirty bool
+	sortedFrameTimes  = make([]float32, 0, FrameHistorySize)
+
+	frameSpikeThreshold time.Duration
+	frameSpikeCallback  FrameSpikeCallback
+)
+
+// SetFrameSpikeCallback makes cb get called for every frame longer than threshold, e.g. 33ms to catch frames below 30 FPS.
+// A nil cb removes the callback
+func SetFrameSpikeCallback(threshold time.Duration, cb FrameSpikeCallback) {
+	frameSpikeThreshold = threshold
+	frameSpikeCallback = cb
+}
+
+func recordFrameTime(frameDur time.Duration) {
+
+	ms := float32(frameDur.Seconds() * 1000)
+	if len(frameTimesMs) < FrameHistorySize {
+		frameTimesMs = append(frameTimesMs, ms)
+	} else {
+		frameTimesMs[frameTimesHead] = ms
+		frameTimesHead = (frameTimesHead + 1) % FrameHistorySize
+	}
+
+	isFrameStatsDirty = true
+
+	if frameSpikeCallback != nil && frameDur > frameSpikeThreshold {
+		frameSpikeCallback(frameDur)
+	}
+}
+
+// GetFrameStats returns the stats of the frame history. Stats are only calculated again after a new frame ends
+func GetFrameStats() FrameStats {
+
+	if !isFrameStatsDirty {
+		return frameStats
+	}
+	isFrameStatsDirty = false
+
+	sortedFrameTimes = append(sortedFrameTimes[:0], frameTimesMs...)
+	slices.Sort(sortedFrameTimes)
+
+	frameStats = FrameStats{Count: int32(len(sortedFrameTimes))}
+	if len(sortedFrameTimes) == 0 {
+		return frameStats
+	}
+
+	var sum float32
+	for _, ms := range sortedFrameTimes {
+		sum += ms
+	}
+
+	frameStats.Min = sortedFrameTimes[0]
+	frameStats.Max = sortedFrameTimes[len(sortedFrameTimes)-1]
+	frameStats.Avg = sum / float32(len(sortedFrameTimes))
+	frameStats.P50 = sortedPercentile(sortedFrameTimes, 50)
+	frameStats.P95 = sortedPercentile(sortedFrameTimes, 95)
+	frameStats.P99 = sortedPercentile(sortedFrameTimes, 99)
+
+	return frameStats
+}
+
+// sortedPercentile returns the value p percent of the sorted values are at or below, using the nearest rank
+func sortedPercentile(sorted []float32, p float32) float32 {
+	i := int(p / 100 * float32(len(sorted)-1))
+	return sorted[i]
+}
+
+// FrameHistory appends the frame times of the history in milliseconds to out, oldest first, and returns it
+func FrameHistory(out []float32) []float32 {
+	out = append(out, frameTimesMs[frameTimesHead:]...)
+	return append(out, frameTimesMs[:frameTimesHead]...)
+}
+
+func ClearFrameHistory() {
+	frameTimesMs = frameTimesMs[:0]
+	frameTimesHead = 0
+	isFrameStatsDirty = true
+}
+
+// ExportFrameHistory writes the frame history as csv with one frame per line, oldest first, so it can be graphed in a spreadsheet
+func ExportFrameHistory(path string) error {
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create frame history file '%s'. Err: %w", path, err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "frame,frame_time_ms")
+	for i, ms := range FrameHistory(make([]float32, 0, len(frameTimesMs))) {
+		fmt.Fprintf(w, "%d,%.3f\n", i, ms)
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write frame history file '%s'. Err: %w", path, err)
+	}
+
+	return nil
+}
@@ -6,6 +6,8 @@ import (
 
 var (
 	dt         float32 = 0.01
+	timeScale  float32 = 1
+	isPaused   bool
 	frameStart time.Time
 	startTime  time.Time
 
@@ -41,17 +43,66 @@ func FrameStarted() {
 func FrameEnded() {
 
 	//Calculate new dt
-	dt = float32(time.Since(frameStart).Seconds())
+	frameDur := time.Since(frameStart)
+	dt = float32(frameDur.Seconds())
 	if dt == 0 {
 		dt = float32(time.Microsecond.Seconds())
 	}
+
+	recordFrameTime(frameDur)
+
+	AddTraceEvent(TraceEvent{
+		Name:  "Frame",
+		Track: TraceTrack_Main,
+		Start: frameStart.Sub(startTime),
+		Dur:   frameDur,
+	})
 }
 
-//DT is frame deltatime in seconds
+// SinceFrameStart returns how long the current frame has been running
+func SinceFrameStart() time.Duration {
+	return time.Since(frameStart)
+}
+
+//DT is frame deltatime in seconds multiplied by the time scale, and is zero while paused.
+//Use it for anything that should slow down or stop with the game, and UnscaledDT for things that shouldn't like menus and debug cameras
 func DT() float32 {
+
+	if isPaused {
+		return 0
+	}
+
+	return dt * timeScale
+}
+
+// UnscaledDT is the real frame deltatime in seconds, ignoring the time scale and pausing
+func UnscaledDT() float32 {
 	return dt
 }
 
+// SetTimeScale sets how fast DT runs compared to real time, where 0.5 is half speed (slow motion) and zero freezes time
+// like a hitstop. Negative values are treated as zero
+func SetTimeScale(scale float32) {
+	timeScale = max(scale, 0)
+}
+
+func TimeScale() float32 {
+	return timeScale
+}
+
+// Pause makes DT zero until Resume is called, without changing the time scale
+func Pause() {
+	isPaused = true
+}
+
+func Resume() {
+	isPaused = false
+}
+
+func IsPaused() bool {
+	return isPaused
+}
+
 //GetAvgFPS returns the fps averaged over 1 second
 func GetAvgFPS() float32 {
 	return avgFps
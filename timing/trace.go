@@ -0,0 +1,216 @@
+package timing
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Tracks are the rows of a trace. Scopes on the same track nest by time, so each thread (or the GPU) needs its own track
+const (
+	TraceTrack_Main int32 = 1
+	TraceTrack_Gpu  int32 = 2
+)
+
+// MaxTraceEvents is how many events a trace keeps. When full the oldest events are dropped,
+// so tracing can be left on and exported right after a spike
+const MaxTraceEvents = 1 << 18
+
+// TraceEvent is a finished scope, where Start is the time since Init
+type TraceEvent struct {
+	Name  string
+	Track int32
+	Start time.Duration
+	Dur   time.Duration
+}
+
+var (
+	isTracing atomic.Bool
+
+	// traceEvents is a ring buffer, where traceHead is the index of the next write once the buffer is full
+	traceMutex      sync.Mutex
+	traceEvents     []TraceEvent
+	traceHead       int
+	traceTrackNames = map[int32]string{
+		TraceTrack_Main: "Main Thread",
+		TraceTrack_Gpu:  "GPU",
+	}
+)
+
+// Scope is a running trace scope. Use as:
+//
+//	defer timing.BeginScope("Physics").End()
+type Scope struct {
+	name  string
+	track int32
+	start time.Duration
+}
+
+// BeginScope starts a scope on the main track. Scopes cost almost nothing while not tracing
+func BeginScope(name string) Scope {
+	return BeginScopeOnTrack(name, TraceTrack_Main)
+}
+
+// BeginScopeOnTrack starts a scope on a custom track, which is needed for scopes running on other goroutines
+// since overlapping scopes on one track show as nested. Name custom tracks with SetTraceTrackName
+func BeginScopeOnTrack(name string, track int32) Scope {
+
+	if !isTracing.Load() {
+		return Scope{}
+	}
+
+	return Scope{
+		name:  name,
+		track: track,
+		start: SinceInit(),
+	}
+}
+
+func (s Scope) End() {
+
+	// Scopes started before tracing began have no name
+	if !isTracing.Load() || s.name == "" {
+		return
+	}
+
+	AddTraceEvent(TraceEvent{
+		Name:  s.name,
+		Track: s.track,
+		Start: s.start,
+		Dur:   SinceInit() - s.start,
+	})
+}
+
+// AddTraceEvent adds an already measured event, like GPU timer query results. Ignored while not tracing
+func AddTraceEvent(e TraceEvent) {
+
+	if !isTracing.Load() {
+		return
+	}
+
+	traceMutex.Lock()
+	defer traceMutex.Unlock()
+
+	if len(traceEvents) < MaxTraceEvents {
+		traceEvents = append(traceEvents, e)
+		return
+	}
+
+	traceEvents[traceHead] = e
+	traceHead = (traceHead + 1) % MaxTraceEvents
+}
+
+// SinceInit returns the time since Init, which is the clock trace events use
+func SinceInit() time.Duration {
+	return time.Since(startTime)
+}
+
+func SetTraceTrackName(track int32, name string) {
+	traceMutex.Lock()
+	traceTrackNames[track] = name
+	traceMutex.Unlock()
+}
+
+// StartTrace starts recording scopes, keeping the events of previous traces unless ClearTrace is called
+func StartTrace() {
+	isTracing.Store(true)
+}
+
+func StopTrace() {
+	isTracing.Store(false)
+}
+
+func IsTracing() bool {
+	return isTracing.Load()
+}
+
+func ClearTrace() {
+	traceMutex.Lock()
+	traceEvents = traceEvents[:0]
+	traceHead = 0
+	traceMutex.Unlock()
+}
+
+// traceFileEvent is an event in the chrome trace event format.
+// See: https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU
+type traceFileEvent struct {
+	Name string            `json:"name"`
+	Ph   string            `json:"ph"`
+	Ts   float64           `json:"ts"`
+	Dur  float64           `json:"dur,omitempty"`
+	Pid  int32             `json:"pid"`
+	Tid  int32             `json:"tid"`
+	Args map[string]string `json:"args,omitempty"`
+}
+
+type traceFile struct {
+	TraceEvents     []traceFileEvent `json:"traceEvents"`
+	DisplayTimeUnit string           `json:"displayTimeUnit"`
+}
+
+// ExportTrace writes the recorded events as json that can be opened with chrome://tracing or https://ui.perfetto.dev
+func ExportTrace(path string) error {
+
+	traceMutex.Lock()
+
+	tf := traceFile{
+		TraceEvents:     make([]traceFileEvent, 0, len(traceEvents)+len(traceTrackNames)),
+		DisplayTimeUnit: "ms",
+	}
+
+	for track, name := range traceTrackNames {
+		tf.TraceEvents = append(tf.TraceEvents, traceFileEvent{
+			Name: "thread_name",
+			Ph:   "M",
+			Pid:  1,
+			Tid:  track,
+			Args: map[string]string{"name": name},
+		})
+	}
+
+	for i := 0; i < len(traceEvents); i++ {
+
+		e := &traceEvents[i]
+		tf.TraceEvents = append(tf.TraceEvents, traceFileEvent{
+			Name: e.Name,
+			Ph:   "X",
+			Ts:   float64(e.Start.Nanoseconds()) / 1000,
+			Dur:  float64(e.Dur.Nanoseconds()) / 1000,
+			Pid:  1,
+			Tid:  e.Track,
+		})
+	}
+
+	traceMutex.Unlock()
+
+	// Metadata first, then by time so the file reads in order. Viewers don't need this but it makes diffs and reading easier
+	sort.SliceStable(tf.TraceEvents, func(i, j int) bool {
+		a, b := &tf.TraceEvents[i], &tf.TraceEvents[j]
+		if (a.Ph == "M") != (b.Ph == "M") {
+			return a.Ph == "M"
+		}
+		return a.Ts < b.Ts
+	})
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create trace file '%s'. Err: %w", path, err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if err := json.NewEncoder(w).Encode(&tf); err != nil {
+		return fmt.Errorf("failed to write trace file '%s'. Err: %w", path, err)
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write trace file '%s'. Err: %w", path, err)
+	}
+
+	return nil
+}
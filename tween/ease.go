@@ -0,0 +1,231 @@
+package tween
+
+import (
+	"math"
+
+	"github.com/bloeys/gglm/gglm"
+)
+
+// EaseFunc maps linear progress t in [0,1] to eased progress. Most return 0 at t=0 and 1 at t=1,
+// but some (e.g. back and elastic) go outside [0,1] in between.
+//
+// See https://easings.net for what each one looks like
+type EaseFunc func(t float32) float32
+
+const (
+	backC1    = 1.70158
+	backC2    = backC1 * 1.525
+	backC3    = backC1 + 1
+	elasticC4 = (2 * gglm.Pi) / 3
+	elasticC5 = (2 * gglm.Pi) / 4.5
+)
+
+func Linear(t float32) float32 {
+	return t
+}
+
+func InQuad(t float32) float32 {
+	return t * t
+}
+
+func OutQuad(t float32) float32 {
+	return 1 - (1-t)*(1-t)
+}
+
+func InOutQuad(t float32) float32 {
+
+	if t < 0.5 {
+		return 2 * t * t
+	}
+
+	f := -2*t + 2
+	return 1 - f*f/2
+}
+
+func InCubic(t float32) float32 {
+	return t * t * t
+}
+
+func OutCubic(t float32) float32 {
+	f := 1 - t
+	return 1 - f*f*f
+}
+
+func InOutCubic(t float32) float32 {
+
+	if t < 0.5 {
+		return 4 * t * t * t
+	}
+
+	f := -2*t + 2
+	return 1 - f*f*f/2
+}
+
+func InQuart(t float32) float32 {
+	return t * t * t * t
+}
+
+func OutQuart(t float32) float32 {
+	f := 1 - t
+	return 1 - f*f*f*f
+}
+
+func InOutQuart(t float32) float32 {
+
+	if t < 0.5 {
+		return 8 * t * t * t * t
+	}
+
+	f := -2*t + 2
+	return 1 - f*f*f*f/2
+}
+
+func InSine(t float32) float32 {
+	return 1 - gglm.Cos32(t*gglm.Pi/2)
+}
+
+func OutSine(t float32) float32 {
+	return gglm.Sin32(t * gglm.Pi / 2)
+}
+
+func InOutSine(t float32) float32 {
+	return -(gglm.Cos32(gglm.Pi*t) - 1) / 2
+}
+
+func InExpo(t float32) float32 {
+
+	if t <= 0 {
+		return 0
+	}
+
+	return pow2(10*t - 10)
+}
+
+func OutExpo(t float32) float32 {
+
+	if t >= 1 {
+		return 1
+	}
+
+	return 1 - pow2(-10*t)
+}
+
+func InOutExpo(t float32) float32 {
+
+	if t <= 0 {
+		return 0
+	}
+
+	if t >= 1 {
+		return 1
+	}
+
+	if t < 0.5 {
+		return pow2(20*t-10) / 2
+	}
+
+	return (2 - pow2(-20*t+10)) / 2
+}
+
+// InBack pulls back slightly before moving towards the target
+func InBack(t float32) float32 {
+	return backC3*t*t*t - backC1*t*t
+}
+
+// OutBack overshoots the target slightly before settling
+func OutBack(t float32) float32 {
+	f := t - 1
+	return 1 + backC3*f*f*f + backC1*f*f
+}
+
+func InOutBack(t float32) float32 {
+
+	if t < 0.5 {
+		f := 2 * t
+		return f * f * ((backC2+1)*f - backC2) / 2
+	}
+
+	f := 2*t - 2
+	return (f*f*((backC2+1)*f+backC2) + 2) / 2
+}
+
+func InElastic(t float32) float32 {
+
+	if t <= 0 {
+		return 0
+	}
+
+	if t >= 1 {
+		return 1
+	}
+
+	return -pow2(10*t-10) * gglm.Sin32((t*10-10.75)*elasticC4)
+}
+
+// OutElastic springs past the target and oscillates before settling
+func OutElastic(t float32) float32 {
+
+	if t <= 0 {
+		return 0
+	}
+
+	if t >= 1 {
+		return 1
+	}
+
+	return pow2(-10*t)*gglm.Sin32((t*10-0.75)*elasticC4) + 1
+}
+
+func InOutElastic(t float32) float32 {
+
+	if t <= 0 {
+		return 0
+	}
+
+	if t >= 1 {
+		return 1
+	}
+
+	if t < 0.5 {
+		return -(pow2(20*t-10) * gglm.Sin32((20*t-11.125)*elasticC5)) / 2
+	}
+
+	return (pow2(-20*t+10)*gglm.Sin32((20*t-11.125)*elasticC5))/2 + 1
+}
+
+func InBounce(t float32) float32 {
+	return 1 - OutBounce(1-t)
+}
+
+// OutBounce bounces against the target like a dropped ball
+func OutBounce(t float32) float32 {
+
+	const n1 = 7.5625
+	const d1 = 2.75
+
+	if t < 1/d1 {
+		return n1 * t * t
+	} else if t < 2/d1 {
+		t -= 1.5 / d1
+		return n1*t*t + 0.75
+	} else if t < 2.5/d1 {
+		t -= 2.25 / d1
+		return n1*t*t + 0.9375
+	}
+
+	t -= 2.625 / d1
+	return n1*t*t + 0.984375
+}
+
+func InOutBounce(t float32) float32 {
+
+	if t < 0.5 {
+		return (1 - OutBounce(1-2*t)) / 2
+	}
+
+	return (1 + OutBounce(2*t-1)) / 2
+}
+
+func pow2(x float32) float32 {
+	return float32(math.Exp2(float64(x)))
+}
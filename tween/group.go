@@ -0,0 +1,199 @@
+package tween
+
+var _ Animation = &Sequence{}
+
+// Sequence plays animations one after the other
+type Sequence struct {
+	Animations []Animation
+
+	// Loops is how many times the sequence plays. Zero and one play it once, and LoopForever never stops
+	Loops int
+
+	OnComplete func()
+
+	current     int
+	loopsPlayed int
+	isDone      bool
+}
+
+func (s *Sequence) Update(dt float32) (isDone bool) {
+
+	if s.isDone {
+		return true
+	}
+
+	if len(s.Animations) == 0 {
+		s.isDone = true
+		return true
+	}
+
+	// Animations that finish this frame let the next one start in the same frame,
+	// so zero length animations (e.g. callbacks) don't each cost a frame.
+	// The max iterations stops a looping sequence of zero length animations from locking up
+	for i := 0; i < len(s.Animations)+1; i++ {
+
+		if s.current >= len(s.Animations) {
+
+			s.loopsPlayed++
+			if s.Loops != LoopForever && s.loopsPlayed >= s.Loops {
+
+				s.isDone = true
+				if s.OnComplete != nil {
+					s.OnComplete()
+				}
+
+				return true
+			}
+
+			s.restartChildren()
+		}
+
+		if !s.Animations[s.current].Update(dt) {
+			return false
+		}
+
+		s.current++
+		dt = 0
+	}
+
+	return false
+}
+
+func (s *Sequence) restartChildren() {
+
+	s.current = 0
+	for i := 0; i < len(s.Animations); i++ {
+		s.Animations[i].Restart()
+	}
+}
+
+func (s *Sequence) Restart() {
+	s.restartChildren()
+	s.loopsPlayed = 0
+	s.isDone = false
+}
+
+func (s *Sequence) IsDone() bool {
+	return s.isDone
+}
+
+func NewSequence(animations ...Animation) *Sequence {
+	return &Sequence{
+		Animations: animations,
+	}
+}
+
+var _ Animation = &Parallel{}
+
+// Parallel plays animations at the same time, and is done when all of them are done
+type Parallel struct {
+	Animations []Animation
+
+	OnComplete func()
+
+	isAnimDone []bool
+	isDone     bool
+}
+
+func (p *Parallel) Update(dt float32) (isDone bool) {
+
+	if p.isDone {
+		return true
+	}
+
+	if len(p.isAnimDone) != len(p.Animations) {
+		p.isAnimDone = make([]bool, len(p.Animations))
+	}
+
+	allDone := true
+	for i := 0; i < len(p.Animations); i++ {
+
+		if p.isAnimDone[i] {
+			continue
+		}
+
+		p.isAnimDone[i] = p.Animations[i].Update(dt)
+		allDone = allDone && p.isAnimDone[i]
+	}
+
+	if allDone {
+
+		p.isDone = true
+		if p.OnComplete != nil {
+			p.OnComplete()
+		}
+	}
+
+	return p.isDone
+}
+
+func (p *Parallel) Restart() {
+
+	for i := 0; i < len(p.Animations); i++ {
+		p.Animations[i].Restart()
+	}
+
+	clear(p.isAnimDone)
+	p.isDone = false
+}
+
+func (p *Parallel) IsDone() bool {
+	return p.isDone
+}
+
+func NewParallel(animations ...Animation) *Parallel {
+	return &Parallel{
+		Animations: animations,
+	}
+}
+
+var _ Animation = &Wait{}
+
+// Wait does nothing for Duration seconds. Useful for adding gaps in sequences
+type Wait struct {
+	Duration float32
+	elapsed  float32
+}
+
+func (w *Wait) Update(dt float32) (isDone bool) {
+	w.elapsed += dt
+	return w.elapsed >= w.Duration
+}
+
+func (w *Wait) Restart() {
+	w.elapsed = 0
+}
+
+func NewWait(duration float32) *Wait {
+	return &Wait{
+		Duration: duration,
+	}
+}
+
+var _ Animation = &Call{}
+
+// Call runs a function once when reached. Useful for triggering events in sequences
+type Call struct {
+	Func   func()
+	isDone bool
+}
+
+func (c *Call) Update(dt float32) (isDone bool) {
+
+	if !c.isDone {
+		c.isDone = true
+		c.Func()
+	}
+
+	return true
+}
+
+func (c *Call) Restart() {
+	c.isDone = false
+}
+
+func NewCall(f func()) *Call {
+	return &Call{
+		Func: f,
+	}
+}
@@ -0,0 +1,68 @@
+package tween
+
+var (
+	activeAnims = make([]Animation, 0, 64)
+)
+
+// Play starts updating the animation every frame until it is done or stopped.
+// Playing an animation that is already playing restarts it
+func Play(a Animation) {
+
+	a.Restart()
+	if IsPlaying(a) {
+		return
+	}
+
+	activeAnims = append(activeAnims, a)
+}
+
+// Stop stops updating the animation, leaving its target at whatever value it has now
+func Stop(a Animation) {
+
+	for i := 0; i < len(activeAnims); i++ {
+
+		// Removed entries are nil-ed rather than deleted, so that stopping from
+		// inside an OnComplete callback doesn't break the iteration in Update
+		if activeAnims[i] == a {
+			activeAnims[i] = nil
+			return
+		}
+	}
+}
+
+func StopAll() {
+	clear(activeAnims)
+}
+
+func IsPlaying(a Animation) bool {
+
+	for i := 0; i < len(activeAnims); i++ {
+		if activeAnims[i] == a {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Update advances all playing animations by dt seconds and removes finished ones.
+// This is called by the engine every frame
+func Update(dt float32) {
+
+	// Iterating by index on the live slice allows OnComplete callbacks to Play new animations.
+	// Finished and stopped animations are compacted in place
+	kept := 0
+	for i := 0; i < len(activeAnims); i++ {
+
+		a := activeAnims[i]
+		if a == nil || a.Update(dt) {
+			continue
+		}
+
+		activeAnims[kept] = a
+		kept++
+	}
+
+	clear(activeAnims[kept:])
+	activeAnims = activeAnims[:kept]
+}
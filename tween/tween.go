@@ -0,0 +1,247 @@
+package tween
+
+import (
+	"github.com/bloeys/gglm/gglm"
+)
+
+const (
+	LoopForever = -1
+)
+
+// Animation is anything that can be advanced by time, like a Tween, Sequence or Parallel
+type Animation interface {
+	// Update advances the animation by dt seconds and returns true once it is done
+	Update(dt float32) (isDone bool)
+
+	// Restart resets the animation so it plays again from the start
+	Restart()
+}
+
+var _ Animation = &Tween[float32]{}
+
+// Tween animates the value pointed to by Target from From to To over Duration seconds.
+// Create tweens with Float32, Vec2, Vec3, Vec4 or Quat, then change any fields before playing them
+type Tween[T any] struct {
+	Target *T
+	From   T
+	To     T
+
+	// Duration and Delay are in seconds. The delay is only applied once, not on every loop
+	Duration float32
+	Delay    float32
+
+	Ease EaseFunc
+
+	// Lerp interpolates between a and b. t can be outside [0,1] with some easing functions
+	Lerp func(a, b *T, t float32) T
+
+	// FromCurrent makes the tween read From from Target when it starts (after the delay),
+	// so it animates from wherever the value is at that time
+	FromCurrent bool
+
+	// Loops is how many times the tween plays. Zero and one play it once, and LoopForever never stops
+	Loops int
+
+	// PingPong makes every other loop play backwards
+	PingPong bool
+
+	// OnComplete is called when the last loop finishes
+	OnComplete func()
+
+	elapsed     float32
+	loopsPlayed int
+	isStarted   bool
+	isDone      bool
+}
+
+func (tw *Tween[T]) Update(dt float32) (isDone bool) {
+
+	if tw.isDone {
+		return true
+	}
+
+	tw.elapsed += dt
+	if tw.elapsed < tw.Delay {
+		return false
+	}
+
+	if !tw.isStarted {
+
+		tw.isStarted = true
+		if tw.FromCurrent {
+			tw.From = *tw.Target
+		}
+	}
+
+	for {
+
+		t := float32(1)
+		if tw.Duration > 0 {
+			t = (tw.elapsed - tw.Delay) / tw.Duration
+		}
+
+		if t < 1 {
+			tw.apply(t)
+			return false
+		}
+
+		// Loop finished
+		tw.apply(1)
+		tw.loopsPlayed++
+
+		if tw.Loops != LoopForever && tw.loopsPlayed >= tw.Loops {
+
+			tw.isDone = true
+			if tw.OnComplete != nil {
+				tw.OnComplete()
+			}
+
+			return true
+		}
+
+		// Carry over the extra time into the next loop
+		tw.elapsed -= tw.Duration
+
+		// Avoid looping forever on zero length tweens
+		if tw.Duration <= 0 {
+			return false
+		}
+	}
+}
+
+func (tw *Tween[T]) apply(t float32) {
+
+	if tw.PingPong && tw.loopsPlayed%2 == 1 {
+		t = 1 - t
+	}
+
+	ease := tw.Ease
+	if ease == nil {
+		ease = Linear
+	}
+
+	*tw.Target = tw.Lerp(&tw.From, &tw.To, ease(t))
+}
+
+func (tw *Tween[T]) Restart() {
+	tw.elapsed = 0
+	tw.loopsPlayed = 0
+	tw.isStarted = false
+	tw.isDone = false
+}
+
+func (tw *Tween[T]) IsDone() bool {
+	return tw.isDone
+}
+
+// Progress returns how far the current loop is in [0,1], without easing
+func (tw *Tween[T]) Progress() float32 {
+
+	if tw.isDone {
+		return 1
+	}
+
+	if !tw.isStarted || tw.Duration <= 0 {
+		return 0
+	}
+
+	return gglm.Clamp((tw.elapsed-tw.Delay)/tw.Duration, 0, 1)
+}
+
+// NewTween creates a tween for any type given a lerp function. The tween starts from the current value of target
+func NewTween[T any](target *T, to T, duration float32, ease EaseFunc, lerp func(a, b *T, t float32) T) *Tween[T] {
+	return &Tween[T]{
+		Target:      target,
+		From:        *target,
+		To:          to,
+		Duration:    duration,
+		Ease:        ease,
+		Lerp:        lerp,
+		FromCurrent: true,
+	}
+}
+
+func Float32(target *float32, to float32, duration float32, ease EaseFunc) *Tween[float32] {
+	return NewTween(target, to, duration, ease, LerpFloat32)
+}
+
+func Vec2(target *gglm.Vec2, to gglm.Vec2, duration float32, ease EaseFunc) *Tween[gglm.Vec2] {
+	return NewTween(target, to, duration, ease, LerpVec2)
+}
+
+func Vec3(target *gglm.Vec3, to gglm.Vec3, duration float32, ease EaseFunc) *Tween[gglm.Vec3] {
+	return NewTween(target, to, duration, ease, LerpVec3)
+}
+
+// Vec4 tweens can also be used for RGBA colors
+func Vec4(target *gglm.Vec4, to gglm.Vec4, duration float32, ease EaseFunc) *Tween[gglm.Vec4] {
+	return NewTween(target, to, duration, ease, LerpVec4)
+}
+
+// Quat tweens rotate along the shortest path using spherical interpolation
+func Quat(target *gglm.Quat, to gglm.Quat, duration float32, ease EaseFunc) *Tween[gglm.Quat] {
+	return NewTween(target, to, duration, ease, SlerpQuat)
+}
+
+func LerpFloat32(a, b *float32, t float32) float32 {
+	return *a + (*b-*a)*t
+}
+
+func LerpVec2(a, b *gglm.Vec2, t float32) gglm.Vec2 {
+	return gglm.Vec2{Data: [2]float32{
+		a.Data[0] + (b.Data[0]-a.Data[0])*t,
+		a.Data[1] + (b.Data[1]-a.Data[1])*t,
+	}}
+}
+
+func LerpVec3(a, b *gglm.Vec3, t float32) gglm.Vec3 {
+	return gglm.Vec3{Data: [3]float32{
+		a.Data[0] + (b.Data[0]-a.Data[0])*t,
+		a.Data[1] + (b.Data[1]-a.Data[1])*t,
+		a.Data[2] + (b.Data[2]-a.Data[2])*t,
+	}}
+}
+
+func LerpVec4(a, b *gglm.Vec4, t float32) gglm.Vec4 {
+	return gglm.Vec4{Data: [4]float32{
+		a.Data[0] + (b.Data[0]-a.Data[0])*t,
+		a.Data[1] + (b.Data[1]-a.Data[1])*t,
+		a.Data[2] + (b.Data[2]-a.Data[2])*t,
+		a.Data[3] + (b.Data[3]-a.Data[3])*t,
+	}}
+}
+
+// SlerpQuat spherically interpolates between two unit quaternions along the shortest path
+func SlerpQuat(a, b *gglm.Quat, t float32) gglm.Quat {
+
+	bData := b.Data
+	cosTheta := gglm.DotQuat(a, b)
+
+	// q and -q are the same rotation, so flip to take the shorter path
+	if cosTheta < 0 {
+		cosTheta = -cosTheta
+		bData = [4]float32{-bData[0], -bData[1], -bData[2], -bData[3]}
+	}
+
+	// When very close sin(theta) approaches zero, so use a normalized lerp instead
+	var wa, wb float32
+	if cosTheta > 1-gglm.F32Epsilon {
+		wa = 1 - t
+		wb = t
+	} else {
+		theta := gglm.Acos32(cosTheta)
+		sinTheta := gglm.Sin32(theta)
+		wa = gglm.Sin32((1-t)*theta) / sinTheta
+		wb = gglm.Sin32(t*theta) / sinTheta
+	}
+
+	q := gglm.NewQuat(
+		a.Data[0]*wa+bData[0]*wb,
+		a.Data[1]*wa+bData[1]*wb,
+		a.Data[2]*wa+bData[2]*wb,
+		a.Data[3]*wa+bData[3]*wb,
+	)
+	q.Normalize()
+
+	return q
+}
@@ -0,0 +1,313 @@
+package gameui
+
+import (
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/assert"
+	"github.com/bloeys/nmage/buffers"
+	"github.com/bloeys/nmage/color"
+	"github.com/bloeys/nmage/leakcheck"
+	"github.com/bloeys/nmage/materials"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// floatsPerVertex is position (2), uv (2) and color (4)
+const floatsPerVertex = 8
+
+const batchShader = `
+//shader:vertex
+#version 410
+
+layout(location=0) in vec2 vertPosIn;
+layout(location=1) in vec2 vertUV0In;
+layout(location=2) in vec4 vertColorIn;
+
+uniform mat4 projMat;
+
+out vec2 vertUV0;
+out vec4 vertColor;
+
+void main()
+{
+    vertUV0 = vertUV0In;
+    vertColor = vertColorIn;
+    gl_Position = projMat * vec4(vertPosIn, 0, 1);
+}
+
+//shader:fragment
+#version 410
+
+uniform sampler2D diffTex;
+
+in vec2 vertUV0;
+in vec4 vertColor;
+
+out vec4 fragColor;
+
+void main()
+{
+    fragColor = vertColor * texture(diffTex, vertUV0);
+}
+`
+
+type batchCmd struct {
+	texId   uint32
+	clip    Rect
+	first   int32
+	count   int32
+	hasClip bool
+}
+
+// Batch collects textured and colored quads in UI pixels and draws them with as few draw calls as possible.
+// Quads are drawn in the order they were added, and a new draw call is only needed when the texture or clip rect changes
+type Batch struct {
+	mat      materials.Material
+	vao      buffers.VertexArray
+	whiteTex uint32
+
+	vertices  []float32
+	cmds      []batchCmd
+	clipStack []Rect
+}
+
+// AddQuad adds a quad covering r, drawing the sprite tinted by c. A sprite without a texture draws a solid color,
+// and 9-slice sprites are drawn as 9 quads
+func (b *Batch) AddQuad(r Rect, s *Sprite, c *color.Color) {
+
+	if s.Margins.IsZero() || s.Width <= 0 || s.Height <= 0 {
+		b.AddQuadUV(r, s.TexID, s.UVMin, s.UVMax, c)
+		return
+	}
+
+	b.addSlicedQuad(r, s, c)
+}
+
+// addSlicedQuad draws the corners at their size in pixels, stretches the edges along their side and stretches the center.
+// Margins are made smaller when the rect is too small to fit them
+func (b *Batch) addSlicedQuad(r Rect, s *Sprite, c *color.Color) {
+
+	m := &s.Margins
+
+	scaleX := float32(1)
+	if m.Left+m.Right > r.W {
+		scaleX = r.W / (m.Left + m.Right)
+	}
+
+	scaleY := float32(1)
+	if m.Top+m.Bottom > r.H {
+		scaleY = r.H / (m.Top + m.Bottom)
+	}
+
+	xs := [4]float32{r.X, r.X + m.Left*scaleX, r.X + r.W - m.Right*scaleX, r.X + r.W}
+	ys := [4]float32{r.Y, r.Y + m.Top*scaleY, r.Y + r.H - m.Bottom*scaleY, r.Y + r.H}
+
+	u0, v0 := s.UVMin.X(), s.UVMin.Y()
+	u1, v1 := s.UVMax.X(), s.UVMax.Y()
+	uPerPixel := (u1 - u0) / s.Width
+	vPerPixel := (v1 - v0) / s.Height
+
+	us := [4]float32{u0, u0 + m.Left*uPerPixel, u1 - m.Right*uPerPixel, u1}
+	vs := [4]float32{v0, v0 + m.Top*vPerPixel, v1 - m.Bottom*vPerPixel, v1}
+
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			b.AddQuadUV(
+				Rect{X: xs[col], Y: ys[row], W: xs[col+1] - xs[col], H: ys[row+1] - ys[row]},
+				s.TexID,
+				gglm.NewVec2(us[col], vs[row]),
+				gglm.NewVec2(us[col+1], vs[row+1]),
+				c,
+			)
+		}
+	}
+}
+
+// AddQuadUV adds a quad covering r, where uvMin is the uv of the top left corner and uvMax of the bottom right corner.
+// A texture id of zero draws a solid color
+func (b *Batch) AddQuadUV(r Rect, texId uint32, uvMin, uvMax gglm.Vec2, c *color.Color) {
+
+	if r.W <= 0 || r.H <= 0 || c.A() <= 0 {
+		return
+	}
+
+	if texId == 0 {
+		texId = b.whiteTex
+	}
+
+	b.useTexture(texId)
+
+	x0, y0 := r.X, r.Y
+	x1, y1 := r.X+r.W, r.Y+r.H
+	u0, v0 := uvMin.X(), uvMin.Y()
+	u1, v1 := uvMax.X(), uvMax.Y()
+	cr, cg, cb, ca := c.Data[0], c.Data[1], c.Data[2], c.Data[3]
+
+	b.vertices = append(b.vertices,
+		x0, y0, u0, v0, cr, cg, cb, ca,
+		x0, y1, u0, v1, cr, cg, cb, ca,
+		x1, y1, u1, v1, cr, cg, cb, ca,
+
+		x0, y0, u0, v0, cr, cg, cb, ca,
+		x1, y1, u1, v1, cr, cg, cb, ca,
+		x1, y0, u1, v0, cr, cg, cb, ca,
+	)
+
+	b.cmds[len(b.cmds)-1].count += 6
+}
+
+// PushClip makes quads added until PopClip only draw inside r, which is also limited to the current clip rect
+func (b *Batch) PushClip(r Rect) {
+
+	if len(b.clipStack) > 0 {
+		r = r.Intersect(b.clipStack[len(b.clipStack)-1])
+	}
+
+	b.clipStack = append(b.clipStack, r)
+	b.newCmd(b.currTexture())
+}
+
+func (b *Batch) PopClip() {
+	b.clipStack = b.clipStack[:len(b.clipStack)-1]
+	b.newCmd(b.currTexture())
+}
+
+func (b *Batch) currTexture() uint32 {
+
+	if len(b.cmds) == 0 {
+		return b.whiteTex
+	}
+
+	return b.cmds[len(b.cmds)-1].texId
+}
+
+func (b *Batch) useTexture(texId uint32) {
+
+	if len(b.cmds) > 0 && b.cmds[len(b.cmds)-1].texId == texId {
+		return
+	}
+
+	b.newCmd(texId)
+}
+
+func (b *Batch) newCmd(texId uint32) {
+
+	cmd := batchCmd{
+		texId: texId,
+		first: int32(len(b.vertices) / floatsPerVertex),
+	}
+
+	if len(b.clipStack) > 0 {
+		cmd.clip = b.clipStack[len(b.clipStack)-1]
+		cmd.hasClip = true
+	}
+
+	// An empty command can be reused instead of adding another one
+	if len(b.cmds) > 0 && b.cmds[len(b.cmds)-1].count == 0 {
+		b.cmds[len(b.cmds)-1] = cmd
+		return
+	}
+
+	b.cmds = append(b.cmds, cmd)
+}
+
+// Flush draws everything added since the last flush to the bound framebuffer, which is uiWidth by uiHeight UI pixels and fbWidth by fbHeight pixels
+func (b *Batch) Flush(uiWidth, uiHeight float32, fbWidth, fbHeight int32) {
+
+	if len(b.vertices) == 0 || fbWidth <= 0 || fbHeight <= 0 {
+		b.reset()
+		return
+	}
+
+	gl.Enable(gl.BLEND)
+	gl.BlendEquation(gl.FUNC_ADD)
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+	gl.Disable(gl.CULL_FACE)
+	gl.Disable(gl.DEPTH_TEST)
+
+	// Y goes down in UI pixels
+	projMat := gglm.Ortho(0, uiWidth, 0, uiHeight, -1, 1)
+
+	b.mat.Bind()
+	b.mat.SetUnifMat4("projMat", &projMat.Mat4)
+	b.mat.SetUnifInt32("diffTex", 0)
+
+	b.vao.Bind()
+	b.vao.Vbos[0].OrphanAndSet(b.vertices)
+
+	scaleX := float32(fbWidth) / uiWidth
+	scaleY := float32(fbHeight) / uiHeight
+
+	gl.ActiveTexture(gl.TEXTURE0)
+	for i := 0; i < len(b.cmds); i++ {
+
+		cmd := &b.cmds[i]
+		if cmd.count == 0 {
+			continue
+		}
+
+		if cmd.hasClip {
+
+			if cmd.clip.W <= 0 || cmd.clip.H <= 0 {
+				continue
+			}
+
+			gl.Enable(gl.SCISSOR_TEST)
+			gl.Scissor(int32(cmd.clip.X*scaleX), fbHeight-int32((cmd.clip.Y+cmd.clip.H)*scaleY), int32(cmd.clip.W*scaleX), int32(cmd.clip.H*scaleY))
+		} else {
+			gl.Disable(gl.SCISSOR_TEST)
+		}
+
+		gl.BindTexture(gl.TEXTURE_2D, cmd.texId)
+		gl.DrawArrays(gl.TRIANGLES, cmd.first, cmd.count)
+	}
+
+	gl.Disable(gl.SCISSOR_TEST)
+	gl.Enable(gl.CULL_FACE)
+	gl.Enable(gl.DEPTH_TEST)
+
+	b.reset()
+}
+
+func (b *Batch) reset() {
+	b.vertices = b.vertices[:0]
+	b.cmds = b.cmds[:0]
+	b.clipStack = b.clipStack[:0]
+}
+
+func (b *Batch) Delete() {
+
+	b.mat.Delete()
+	b.vao.Delete()
+
+	leakcheck.Untrack(leakcheck.ResourceType_Texture, b.whiteTex)
+	gl.DeleteTextures(1, &b.whiteTex)
+	b.whiteTex = 0
+}
+
+func NewBatch() Batch {
+
+	b := Batch{
+		mat:      assert.MustGet(materials.NewMaterialSrc("Game UI Mat", []byte(batchShader))),
+		vao:      buffers.NewVertexArray(),
+		vertices: make([]float32, 0, 6*floatsPerVertex*64),
+	}
+
+	vbo := buffers.NewVertexBuffer(
+		buffers.Element{ElementType: buffers.DataTypeVec2},
+		buffers.Element{ElementType: buffers.DataTypeVec2},
+		buffers.Element{ElementType: buffers.DataTypeVec4},
+	)
+	vbo.Usage = buffers.BufUsage_Stream_Draw
+	b.vao.AddVertexBuffer(vbo)
+
+	// Solid quads sample a white texture so all quads can use the same shader
+	white := [4]uint8{255, 255, 255, 255}
+	gl.GenTextures(1, &b.whiteTex)
+	leakcheck.Track(leakcheck.ResourceType_Texture, b.whiteTex)
+
+	gl.BindTexture(gl.TEXTURE_2D, b.whiteTex)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA8, 1, 1, 0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(&white[0]))
+
+	return b
+}
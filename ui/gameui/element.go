@@ -0,0 +1,232 @@
+package gameui
+
+import (
+	"slices"
+
+	"github.com/bloeys/gglm/gglm"
+)
+
+// Widget is anything that can be part of the UI tree. Widgets embed an Element, which provides Elem
+type Widget interface {
+	Elem() *Element
+
+	// Draw adds the widget to the batch. Children are drawn after it, on top of it
+	Draw(b *Batch)
+}
+
+// PointerHandler is implemented by widgets that react to the mouse. Widgets that don't implement it are ignored by the mouse,
+// so clicks go to what's under them
+type PointerHandler interface {
+	Widget
+	HandlePointer(e *PointerEvent)
+}
+
+type PointerEventType int32
+
+const (
+	PointerEventType_Unknown PointerEventType = iota
+	PointerEventType_Enter
+	PointerEventType_Exit
+	PointerEventType_Down
+	PointerEventType_Up
+
+	// PointerEventType_Click is sent after Up when the mouse was released over the widget it was pressed on
+	PointerEventType_Click
+
+	// PointerEventType_Drag is sent every frame the mouse moves while pressed, even when outside the widget
+	PointerEventType_Drag
+)
+
+func (pt PointerEventType) String() string {
+	switch pt {
+	case PointerEventType_Enter:
+		return "Enter"
+	case PointerEventType_Exit:
+		return "Exit"
+	case PointerEventType_Down:
+		return "Down"
+	case PointerEventType_Up:
+		return "Up"
+	case PointerEventType_Click:
+		return "Click"
+	case PointerEventType_Drag:
+		return "Drag"
+	default:
+		return "Unknown"
+	}
+}
+
+type PointerEvent struct {
+	Type PointerEventType
+
+	// Pos is the mouse position in UI pixels
+	Pos gglm.Vec2
+}
+
+// Element is the part shared by all widgets: where the widget is, its children and its state
+type Element struct {
+	Name   string
+	Layout Layout
+
+	// Hidden elements and their children aren't drawn and don't get pointer events
+	Hidden bool
+
+	// Disabled elements and their children are drawn with their disabled skin and don't get pointer events
+	Disabled bool
+
+	// PointerPassThrough lets the mouse go through the element to what's under it, while its children still get pointer events
+	PointerPassThrough bool
+
+	// ClipChildren cuts children that go outside the element, like in scroll areas
+	ClipChildren bool
+
+	Stack        StackDirection
+	StackSpacing float32
+
+	// Padding is the space between the element and its children on every side
+	Padding float32
+
+	// Rect is where the element was placed by the last UI.Update
+	Rect Rect
+
+	parent   *Element
+	children []Widget
+
+	isHovered  bool
+	isPressed  bool
+	isDisabled bool
+}
+
+func (e *Element) Elem() *Element {
+	return e
+}
+
+// Add adds widgets as the last children of the element, removing them from their old parents
+func (e *Element) Add(children ...Widget) {
+
+	for _, c := range children {
+
+		ce := c.Elem()
+		if ce.parent != nil {
+			ce.parent.Remove(c)
+		}
+
+		ce.parent = e
+		e.children = append(e.children, c)
+	}
+}
+
+func (e *Element) Remove(child Widget) {
+
+	index := slices.Index(e.children, child)
+	if index == -1 {
+		return
+	}
+
+	e.children = slices.Delete(e.children, index, index+1)
+	child.Elem().parent = nil
+}
+
+func (e *Element) Children() []Widget {
+	return e.children
+}
+
+// Parent returns the element of the parent widget, or nil for the root and widgets that weren't added to the UI
+func (e *Element) Parent() *Element {
+	return e.parent
+}
+
+func (e *Element) IsHovered() bool {
+	return e.isHovered
+}
+
+func (e *Element) IsPressed() bool {
+	return e.isPressed
+}
+
+// IsDisabled returns true if the element or any of its parents is disabled
+func (e *Element) IsDisabled() bool {
+	return e.isDisabled
+}
+
+// layoutChildren places the children inside the element's rect, and updates the disabled state that children inherit
+func (e *Element) layoutChildren(parentDisabled bool) {
+
+	e.isDisabled = parentDisabled || e.Disabled
+
+	content := e.Rect.Shrink(e.Padding)
+	cursor := gglm.NewVec2(content.X, content.Y)
+
+	for _, c := range e.children {
+
+		ce := c.Elem()
+		if ce.Hidden {
+			continue
+		}
+
+		ce.Rect = ce.Layout.Place(content)
+
+		switch e.Stack {
+		case StackDirection_Vertical:
+			ce.Rect.Y = cursor.Y() + ce.Layout.Offset.Y()
+			ce.Rect.H = max(ce.Layout.Size.Y(), 0)
+			cursor.SetY(cursor.Y() + ce.Rect.H + e.StackSpacing)
+
+		case StackDirection_Horizontal:
+			ce.Rect.X = cursor.X() + ce.Layout.Offset.X()
+			ce.Rect.W = max(ce.Layout.Size.X(), 0)
+			cursor.SetX(cursor.X() + ce.Rect.W + e.StackSpacing)
+		}
+
+		ce.layoutChildren(e.isDisabled)
+	}
+}
+
+// draw draws the widget and then its children
+func draw(w Widget, b *Batch) {
+
+	e := w.Elem()
+	if e.Hidden {
+		return
+	}
+
+	w.Draw(b)
+
+	if e.ClipChildren {
+		b.PushClip(e.Rect)
+	}
+
+	for _, c := range e.children {
+		draw(c, b)
+	}
+
+	if e.ClipChildren {
+		b.PopClip()
+	}
+}
+
+// hitTest returns the top most pointer handler under (x, y), which is the last drawn one
+func hitTest(w Widget, x, y float32) PointerHandler {
+
+	e := w.Elem()
+	if e.Hidden || e.isDisabled {
+		return nil
+	}
+
+	// Clipped children can't be clicked where they aren't visible
+	if e.ClipChildren && !e.Rect.Contains(x, y) {
+		return nil
+	}
+
+	for i := len(e.children) - 1; i >= 0; i-- {
+		if ph := hitTest(e.children[i], x, y); ph != nil {
+			return ph
+		}
+	}
+
+	if ph, ok := w.(PointerHandler); ok && !e.PointerPassThrough && e.Rect.Contains(x, y) {
+		return ph
+	}
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package gameui
+
+import "github.com/bloeys/gglm/gglm"
+
+// Rect is an area in UI pixels, where X and Y are the top left corner and Y goes down
+type Rect struct {
+	X, Y float32
+	W, H float32
+}
+
+func (r Rect) Contains(x, y float32) bool {
+	return x >= r.X && x < r.X+r.W && y >= r.Y && y < r.Y+r.H
+}
+
+// Intersect returns the area inside both rects, which has a zero size if they don't overlap
+func (r Rect) Intersect(r2 Rect) Rect {
+
+	x0 := max(r.X, r2.X)
+	y0 := max(r.Y, r2.Y)
+	x1 := min(r.X+r.W, r2.X+r2.W)
+	y1 := min(r.Y+r.H, r2.Y+r2.H)
+
+	return Rect{X: x0, Y: y0, W: max(x1-x0, 0), H: max(y1-y0, 0)}
+}
+
+// Shrink returns the rect made smaller by padding on every side
+func (r Rect) Shrink(padding float32) Rect {
+	return Rect{X: r.X + padding, Y: r.Y + padding, W: max(r.W-padding*2, 0), H: max(r.H-padding*2, 0)}
+}
+
+// Layout places an element inside its parent.
+//
+// Each axis is either fixed or stretched. On a fixed axis (AnchorMin equals AnchorMax) the element is Size big and its pivot is
+// placed at the anchor. On a stretched axis the element spans from AnchorMin to AnchorMax of the parent, and Size is added to that,
+// so a stretched element with a Size of -20 and a pivot of 0.5 has a margin of 10 pixels on both sides
+type Layout struct {
+	// AnchorMin and AnchorMax are points in the parent from (0,0) at the top left to (1,1) at the bottom right
+	AnchorMin gglm.Vec2
+	AnchorMax gglm.Vec2
+
+	// Pivot is the point of the element, from (0,0) at the top left to (1,1) at the bottom right, that is placed at the anchor
+	Pivot gglm.Vec2
+
+	// Offset moves the element in UI pixels
+	Offset gglm.Vec2
+	Size   gglm.Vec2
+}
+
+// Place returns the rect of the element inside the parent rect
+func (l *Layout) Place(parent Rect) Rect {
+
+	x, w := placeAxis(parent.X, parent.W, l.AnchorMin.X(), l.AnchorMax.X(), l.Pivot.X(), l.Offset.X(), l.Size.X())
+	y, h := placeAxis(parent.Y, parent.H, l.AnchorMin.Y(), l.AnchorMax.Y(), l.Pivot.Y(), l.Offset.Y(), l.Size.Y())
+	return Rect{X: x, Y: y, W: w, H: h}
+}
+
+func placeAxis(parentPos, parentSize, anchorMin, anchorMax, pivot, offset, size float32) (pos, finalSize float32) {
+
+	// On fixed axes this places the pivot at the anchor, while on stretched axes it grows the element around its pivot by size
+	finalSize = max((anchorMax-anchorMin)*parentSize+size, 0)
+	pos = parentPos + anchorMin*parentSize + offset - pivot*size
+	return pos, finalSize
+}
+
+type Anchor int32
+
+const (
+	Anchor_TopLeft Anchor = iota
+	Anchor_Top
+	Anchor_TopRight
+	Anchor_Left
+	Anchor_Center
+	Anchor_Right
+	Anchor_BottomLeft
+	Anchor_Bottom
+	Anchor_BottomRight
+
+	// Anchor_Stretch fills the parent
+	Anchor_Stretch
+	// Anchor_StretchTop fills the width of the parent at its top
+	Anchor_StretchTop
+	// Anchor_StretchBottom fills the width of the parent at its bottom
+	Anchor_StretchBottom
+)
+
+func (a Anchor) String() string {
+	switch a {
+	case Anchor_TopLeft:
+		return "TopLeft"
+	case Anchor_Top:
+		return "Top"
+	case Anchor_TopRight:
+		return "TopRight"
+	case Anchor_Left:
+		return "Left"
+	case Anchor_Center:
+		return "Center"
+	case Anchor_Right:
+		return "Right"
+	case Anchor_BottomLeft:
+		return "BottomLeft"
+	case Anchor_Bottom:
+		return "Bottom"
+	case Anchor_BottomRight:
+		return "BottomRight"
+	case Anchor_Stretch:
+		return "Stretch"
+	case Anchor_StretchTop:
+		return "StretchTop"
+	case Anchor_StretchBottom:
+		return "StretchBottom"
+	default:
+		return "Unknown"
+	}
+}
+
+// Layout returns a layout anchored and pivoted at the anchor point, so an offset moves the element inwards
+// from the corner or edge it is anchored to
+func (a Anchor) Layout(offset, size gglm.Vec2) Layout {
+
+	l := Layout{
+		Offset: offset,
+		Size:   size,
+	}
+
+	switch a {
+	case Anchor_Stretch:
+		l.AnchorMin = gglm.NewVec2(0, 0)
+		l.AnchorMax = gglm.NewVec2(1, 1)
+		l.Pivot = gglm.NewVec2(0.5, 0.5)
+	case Anchor_StretchTop:
+		l.AnchorMin = gglm.NewVec2(0, 0)
+		l.AnchorMax = gglm.NewVec2(1, 0)
+		l.Pivot = gglm.NewVec2(0.5, 0)
+	case Anchor_StretchBottom:
+		l.AnchorMin = gglm.NewVec2(0, 1)
+		l.AnchorMax = gglm.NewVec2(1, 1)
+		l.Pivot = gglm.NewVec2(0.5, 1)
+	default:
+
+		// The 3x3 anchors are numbered row by row
+		point := gglm.NewVec2(float32(a%3)*0.5, float32(a/3)*0.5)
+		l.AnchorMin = point
+		l.AnchorMax = point
+		l.Pivot = point
+	}
+
+	// Offsets point away from the anchored edge so positive values move the element inwards
+	if l.Pivot.X() == 1 {
+		l.Offset.SetX(-offset.X())
+	}
+	if l.Pivot.Y() == 1 {
+		l.Offset.SetY(-offset.Y())
+	}
+
+	return l
+}
+
+// StackDirection is how an element places its children. Stacked children keep their size on the stack axis
+// and are placed one after another, while the other axis uses their layout
+type StackDirection int32
+
+const (
+	StackDirection_None StackDirection = iota
+	StackDirection_Vertical
+	StackDirection_Horizontal
+)
+
+func (sd StackDirection) String() string {
+	switch sd {
+	case StackDirection_None:
+		return "None"
+	case StackDirection_Vertical:
+		return "Vertical"
+	case StackDirection_Horizontal:
+		return "Horizontal"
+	default:
+		return "Unknown"
+	}
+}
@@ -0,0 +1,222 @@
+package gameui
+
+import (
+	"unicode/utf8"
+
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/assets"
+	"github.com/bloeys/nmage/color"
+)
+
+// SpriteMargins are the sizes in pixels of the edges of a 9-slice sprite
+type SpriteMargins struct {
+	Left   float32
+	Top    float32
+	Right  float32
+	Bottom float32
+}
+
+func (sm *SpriteMargins) IsZero() bool {
+	return sm.Left == 0 && sm.Top == 0 && sm.Right == 0 && sm.Bottom == 0
+}
+
+// Sprite is a region of a texture. The zero sprite has no texture and draws a solid color
+type Sprite struct {
+	TexID uint32
+
+	// UVMin is the uv of the top left corner and UVMax of the bottom right corner
+	UVMin gglm.Vec2
+	UVMax gglm.Vec2
+
+	// Width and Height are the size of the region in texture pixels
+	Width  float32
+	Height float32
+
+	// Margins make the sprite 9-sliced: the corners keep their size, the edges only stretch along their side
+	// and the center stretches to fill the rest, so borders don't get stretched when the sprite is drawn bigger.
+	// Zero margins stretch the whole sprite
+	Margins SpriteMargins
+}
+
+// NewSprite returns a sprite showing the whole texture
+func NewSprite(tex *assets.Texture) Sprite {
+	return NewSpriteRegion(tex, 0, 0, tex.Width, tex.Height)
+}
+
+// NewSpriteRegion returns a sprite showing the width by height pixels starting at (x, y), where (0, 0) is the top left of the image.
+// Textures are stored bottom up, so the uvs are flipped
+func NewSpriteRegion(tex *assets.Texture, x, y, width, height int32) Sprite {
+
+	texW := float32(tex.Width)
+	texH := float32(tex.Height)
+
+	return Sprite{
+		TexID:  tex.TexID,
+		UVMin:  gglm.NewVec2(float32(x)/texW, 1-float32(y)/texH),
+		UVMax:  gglm.NewVec2(float32(x+width)/texW, 1-float32(y+height)/texH),
+		Width:  float32(width),
+		Height: float32(height),
+	}
+}
+
+// NewSlicedSprite returns a 9-slice sprite of the region, where margins are the sizes of the edges in texture pixels
+func NewSlicedSprite(tex *assets.Texture, x, y, width, height int32, margins SpriteMargins) Sprite {
+	s := NewSpriteRegion(tex, x, y, width, height)
+	s.Margins = margins
+	return s
+}
+
+// SkinState is how a widget looks in one state. The sprite is tinted by the color
+type SkinState struct {
+	Sprite Sprite
+	Color  color.Color
+}
+
+// Skin is how a widget looks in each of its states
+type Skin struct {
+	Normal   SkinState
+	Hovered  SkinState
+	Pressed  SkinState
+	Disabled SkinState
+}
+
+// State returns the skin state matching the element's state
+func (s *Skin) State(e *Element) *SkinState {
+
+	switch {
+	case e.IsDisabled():
+		return &s.Disabled
+	case e.IsPressed():
+		return &s.Pressed
+	case e.IsHovered():
+		return &s.Hovered
+	default:
+		return &s.Normal
+	}
+}
+
+// NewSpriteSkin returns a skin that uses the same sprite in all states, tinted like NewColorSkin with a white color
+func NewSpriteSkin(s Sprite) Skin {
+	return NewColorSkin(s, color.NewLinear(1, 1, 1))
+}
+
+// NewColorSkin returns a skin that uses the same sprite in all states with the color made brighter when hovered,
+// darker when pressed and transparent when disabled
+func NewColorSkin(s Sprite, c color.Color) Skin {
+
+	hovered := c
+	hovered.Scale(1.25)
+
+	pressed := c
+	pressed.Scale(0.7)
+
+	disabled := c
+	disabled.SetA(c.A() * 0.4)
+
+	return Skin{
+		Normal:   SkinState{Sprite: s, Color: c},
+		Hovered:  SkinState{Sprite: s, Color: hovered},
+		Pressed:  SkinState{Sprite: s, Color: pressed},
+		Disabled: SkinState{Sprite: s, Color: disabled},
+	}
+}
+
+type Glyph struct {
+	Sprite Sprite
+
+	// Width and Height are the size of the glyph quad in pixels at the font's size
+	Width  float32
+	Height float32
+
+	// Advance is how far the next glyph is placed in pixels
+	Advance float32
+}
+
+// BitmapFont draws text using glyphs from sprite sheets, so fonts can be styled in an image editor like the rest of the UI
+type BitmapFont struct {
+	Glyphs map[rune]Glyph
+
+	// Size is the height of a line in pixels when text is drawn at this size
+	Size float32
+
+	// Fallback is drawn for characters without a glyph. Characters without a glyph are skipped if it has no glyph either
+	Fallback rune
+}
+
+func (f *BitmapFont) glyph(r rune) (Glyph, bool) {
+
+	g, ok := f.Glyphs[r]
+	if !ok {
+		g, ok = f.Glyphs[f.Fallback]
+	}
+
+	return g, ok
+}
+
+// Measure returns the size of the text drawn at size. Text is a single line
+func (f *BitmapFont) Measure(text string, size float32) (width, height float32) {
+
+	scale := size / f.Size
+	for _, r := range text {
+		if g, ok := f.glyph(r); ok {
+			width += g.Advance * scale
+		}
+	}
+
+	return width, size
+}
+
+// Draw adds the text to the batch with its top left corner at (x, y)
+func (f *BitmapFont) Draw(b *Batch, text string, x, y, size float32, c *color.Color) {
+
+	scale := size / f.Size
+	for _, r := range text {
+
+		g, ok := f.glyph(r)
+		if !ok {
+			continue
+		}
+
+		if r != ' ' {
+			b.AddQuad(Rect{X: x, Y: y, W: g.Width * scale, H: g.Height * scale}, &g.Sprite, c)
+		}
+
+		x += g.Advance * scale
+	}
+}
+
+// NewGridFont returns a monospace font from a texture where glyphs are cells of a grid, starting at the top left and going row by row.
+// The first cell is firstChar, and the rest are the characters after it in order, like an ASCII sheet starting at ' '
+func NewGridFont(tex *assets.Texture, firstChar rune, cellWidth, cellHeight int32) BitmapFont {
+
+	font := BitmapFont{
+		Glyphs:   map[rune]Glyph{},
+		Size:     float32(cellHeight),
+		Fallback: '?',
+	}
+
+	if cellWidth <= 0 || cellHeight <= 0 {
+		return font
+	}
+
+	cols := tex.Width / cellWidth
+	rows := tex.Height / cellHeight
+	for row := int32(0); row < rows; row++ {
+		for col := int32(0); col < cols; col++ {
+
+			r := firstChar + rune(row*cols+col)
+			if !utf8.ValidRune(r) {
+				continue
+			}
+
+			font.Glyphs[r] = Glyph{
+				Sprite:  NewSpriteRegion(tex, col*cellWidth, row*cellHeight, cellWidth, cellHeight),
+				Width:   float32(cellWidth),
+				Height:  float32(cellHeight),
+				Advance: float32(cellWidth),
+			}
+		}
+	}
+
+	return font
+}
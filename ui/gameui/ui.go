@@ -0,0 +1,146 @@
+// The gameui package is a retained mode UI for things shipped with the game, like HUDs and menus, while imgui is meant for debug tools.
+//
+// The UI is a tree of widgets placed by anchors (see Layout) or stacked by their parent (see StackDirection),
+// and skinned with sprites. Widgets are created once and changed when needed, instead of being submitted every frame.
+//
+// The mouse is read from the input package, and is captured while over a widget so game code using the
+// non-captured input functions doesn't react to clicks on the UI. Imgui windows are on top of the game UI
+package gameui
+
+import (
+	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/color"
+	"github.com/bloeys/nmage/input"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+var zeroVec2 = gglm.NewVec2(0, 0)
+
+type UI struct {
+	// Root fills the window and lets the mouse through, so only its children block the mouse
+	Root Panel
+
+	// Scale multiplies the size of everything, like the DPI scaling of the display. Layouts are in UI pixels,
+	// which are Scale window pixels big
+	Scale float32
+
+	batch Batch
+
+	hovered PointerHandler
+	pressed PointerHandler
+
+	lastMousePos gglm.Vec2
+	width        float32
+	height       float32
+}
+
+// Add adds widgets to the root
+func (ui *UI) Add(children ...Widget) {
+	ui.Root.Add(children...)
+}
+
+// Update places the widgets in a window of the given size and sends pointer events. Should be called in Game.Update
+// before code that reads the mouse, so clicks on the UI are captured first
+func (ui *UI) Update(winWidth, winHeight int32) {
+
+	if ui.Scale <= 0 {
+		ui.Scale = 1
+	}
+
+	ui.width = float32(winWidth) / ui.Scale
+	ui.height = float32(winHeight) / ui.Scale
+
+	root := &ui.Root.Element
+	root.Rect = Rect{W: ui.width, H: ui.height}
+	root.layoutChildren(false)
+
+	mouseX, mouseY := input.GetMousePos()
+	mousePos := gglm.NewVec2(float32(mouseX)/ui.Scale, float32(mouseY)/ui.Scale)
+
+	// The mouse is captured by imgui when over one of its windows
+	var hovered PointerHandler
+	if !input.IsMouseCaptured() {
+		hovered = hitTest(&ui.Root, mousePos.X(), mousePos.Y())
+	}
+
+	if hovered != ui.hovered {
+
+		if ui.hovered != nil {
+			ui.hovered.Elem().isHovered = false
+			ui.hovered.HandlePointer(&PointerEvent{Type: PointerEventType_Exit, Pos: mousePos})
+		}
+
+		if hovered != nil {
+			hovered.Elem().isHovered = true
+			hovered.HandlePointer(&PointerEvent{Type: PointerEventType_Enter, Pos: mousePos})
+		}
+
+		ui.hovered = hovered
+	}
+
+	if ui.pressed == nil && ui.hovered != nil && input.MouseClicked(sdl.BUTTON_LEFT) {
+		ui.pressed = ui.hovered
+		ui.pressed.Elem().isPressed = true
+		ui.pressed.HandlePointer(&PointerEvent{Type: PointerEventType_Down, Pos: mousePos})
+	}
+
+	if ui.pressed != nil {
+
+		// The captured functions are used so a press isn't lost if the mouse moves over an imgui window
+		isDown := input.MouseDownCaptued(sdl.BUTTON_LEFT)
+		if isDown && !mousePos.Eq(&ui.lastMousePos) {
+			ui.pressed.HandlePointer(&PointerEvent{Type: PointerEventType_Drag, Pos: mousePos})
+		}
+
+		if !isDown {
+
+			pressed := ui.pressed
+			ui.pressed = nil
+
+			pressed.Elem().isPressed = false
+			pressed.HandlePointer(&PointerEvent{Type: PointerEventType_Up, Pos: mousePos})
+			if pressed == ui.hovered {
+				pressed.HandlePointer(&PointerEvent{Type: PointerEventType_Click, Pos: mousePos})
+			}
+		}
+	}
+
+	ui.lastMousePos = mousePos
+
+	if ui.WantsMouse() {
+		input.CaptureMouse()
+	}
+}
+
+// WantsMouse returns true if the mouse is over a widget or pressing one
+func (ui *UI) WantsMouse() bool {
+	return ui.hovered != nil || ui.pressed != nil
+}
+
+// Render draws the UI to the bound framebuffer, which should be the size of the window passed to Update
+func (ui *UI) Render(fbWidth, fbHeight int32) {
+
+	if ui.width <= 0 || ui.height <= 0 {
+		return
+	}
+
+	draw(&ui.Root, &ui.batch)
+	ui.batch.Flush(ui.width, ui.height, fbWidth, fbHeight)
+}
+
+func (ui *UI) Delete() {
+	ui.batch.Delete()
+}
+
+func NewUI() UI {
+
+	ui := UI{
+		Root:  Panel{Background: SkinState{Color: color.NewLinearA(0, 0, 0, 0)}},
+		Scale: 1,
+		batch: NewBatch(),
+	}
+
+	ui.Root.Name = "Root"
+	ui.Root.PointerPassThrough = true
+	return ui
+}
@@ -0,0 +1,237 @@
+package gameui
+
+import (
+	"math"
+
+	"github.com/bloeys/nmage/color"
+)
+
+// Panel draws a sprite behind its children. Panels stop the mouse from reaching the game unless PointerPassThrough is set
+type Panel struct {
+	Element
+	Background SkinState
+}
+
+var _ PointerHandler = &Panel{}
+
+func (p *Panel) Draw(b *Batch) {
+	b.AddQuad(p.Rect, &p.Background.Sprite, &p.Background.Color)
+}
+
+func (p *Panel) HandlePointer(e *PointerEvent) {
+}
+
+func NewPanel(layout Layout, background SkinState) *Panel {
+	return &Panel{
+		Element:    Element{Layout: layout},
+		Background: background,
+	}
+}
+
+type TextAlign int32
+
+const (
+	TextAlign_Left TextAlign = iota
+	TextAlign_Center
+	TextAlign_Right
+)
+
+func (ta TextAlign) String() string {
+	switch ta {
+	case TextAlign_Left:
+		return "Left"
+	case TextAlign_Center:
+		return "Center"
+	case TextAlign_Right:
+		return "Right"
+	default:
+		return "Unknown"
+	}
+}
+
+// Label draws a line of text, vertically centered in its rect
+type Label struct {
+	Element
+
+	Text string
+	Font *BitmapFont
+
+	// FontSize is the height of the text in UI pixels. Zero uses the size of the font
+	FontSize float32
+	Color    color.Color
+	Align    TextAlign
+}
+
+var _ Widget = &Label{}
+
+func (l *Label) Draw(b *Batch) {
+
+	if l.Font == nil || l.Text == "" {
+		return
+	}
+
+	size := l.FontSize
+	if size <= 0 {
+		size = l.Font.Size
+	}
+
+	w, h := l.Font.Measure(l.Text, size)
+
+	x := l.Rect.X
+	switch l.Align {
+	case TextAlign_Center:
+		x += (l.Rect.W - w) * 0.5
+	case TextAlign_Right:
+		x += l.Rect.W - w
+	}
+
+	// Whole pixels keep pixel art fonts sharp
+	x = float32(math.Round(float64(x)))
+	y := float32(math.Round(float64(l.Rect.Y + (l.Rect.H-h)*0.5)))
+
+	c := l.Color
+	if l.IsDisabled() {
+		c.SetA(c.A() * 0.4)
+	}
+
+	l.Font.Draw(b, l.Text, x, y, size, &c)
+}
+
+func NewLabel(layout Layout, text string, font *BitmapFont, c color.Color) *Label {
+	return &Label{
+		Element: Element{Layout: layout},
+		Text:    text,
+		Font:    font,
+		Color:   c,
+	}
+}
+
+// Button calls OnClick when clicked. Its text is a child label that fills the button
+type Button struct {
+	Element
+
+	Skin    Skin
+	Label   *Label
+	OnClick func()
+}
+
+var _ PointerHandler = &Button{}
+
+func (bt *Button) Draw(b *Batch) {
+	s := bt.Skin.State(&bt.Element)
+	b.AddQuad(bt.Rect, &s.Sprite, &s.Color)
+}
+
+func (bt *Button) HandlePointer(e *PointerEvent) {
+	if e.Type == PointerEventType_Click && bt.OnClick != nil {
+		bt.OnClick()
+	}
+}
+
+func NewButton(layout Layout, skin Skin, text string, font *BitmapFont, onClick func()) *Button {
+
+	bt := &Button{
+		Element: Element{Layout: layout},
+		Skin:    skin,
+		OnClick: onClick,
+	}
+
+	bt.Label = NewLabel(Anchor_Stretch.Layout(zeroVec2, zeroVec2), text, font, color.NewLinear(1, 1, 1))
+	bt.Label.Align = TextAlign_Center
+	bt.Add(bt.Label)
+
+	return bt
+}
+
+// Slider picks a value between Min and Max by dragging a handle along a horizontal track
+type Slider struct {
+	Element
+
+	Track  Skin
+	Handle Skin
+
+	// HandleWidth is the width of the handle in UI pixels. The handle is as tall as the slider
+	HandleWidth float32
+
+	Min   float32
+	Max   float32
+	Value float32
+
+	// Step rounds values to multiples of it when above zero
+	Step float32
+
+	// OnChanged is called when the value is changed by the mouse or SetValue
+	OnChanged func(v float32)
+}
+
+var _ PointerHandler = &Slider{}
+
+func (s *Slider) Draw(b *Batch) {
+
+	track := s.Track.State(&s.Element)
+	b.AddQuad(s.Rect, &track.Sprite, &track.Color)
+
+	handle := s.Handle.State(&s.Element)
+	b.AddQuad(s.handleRect(), &handle.Sprite, &handle.Color)
+}
+
+func (s *Slider) handleRect() Rect {
+
+	t := float32(0)
+	if s.Max != s.Min {
+		t = (s.Value - s.Min) / (s.Max - s.Min)
+	}
+
+	return Rect{
+		X: s.Rect.X + t*max(s.Rect.W-s.HandleWidth, 0),
+		Y: s.Rect.Y,
+		W: s.HandleWidth,
+		H: s.Rect.H,
+	}
+}
+
+func (s *Slider) HandlePointer(e *PointerEvent) {
+
+	if e.Type != PointerEventType_Down && e.Type != PointerEventType_Drag {
+		return
+	}
+
+	// The handle is centered on the mouse
+	usableWidth := s.Rect.W - s.HandleWidth
+	if usableWidth <= 0 {
+		return
+	}
+
+	t := (e.Pos.X() - s.Rect.X - s.HandleWidth*0.5) / usableWidth
+	s.SetValue(s.Min + min(max(t, 0), 1)*(s.Max-s.Min))
+}
+
+// SetValue clamps the value to the range of the slider, rounds it to Step and calls OnChanged if it changed
+func (s *Slider) SetValue(v float32) {
+
+	if s.Step > 0 {
+		v = s.Min + float32(math.Round(float64((v-s.Min)/s.Step)))*s.Step
+	}
+
+	v = min(max(v, min(s.Min, s.Max)), max(s.Min, s.Max))
+	if v == s.Value {
+		return
+	}
+
+	s.Value = v
+	if s.OnChanged != nil {
+		s.OnChanged(v)
+	}
+}
+
+func NewSlider(layout Layout, track, handle Skin, minVal, maxVal, value float32) *Slider {
+	return &Slider{
+		Element:     Element{Layout: layout},
+		Track:       track,
+		Handle:      handle,
+		HandleWidth: max(layout.Size.Y(), 8),
+		Min:         minVal,
+		Max:         maxVal,
+		Value:       value,
+	}
+}
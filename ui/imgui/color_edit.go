@@ -0,0 +1,38 @@
+package nmageimgui
+
+import (
+	imgui "github.com/AllenDang/cimgui-go"
+	"github.com/bloeys/nmage/color"
+)
+
+// ColorEdit3 shows a color picker for the RGB of a linear color. The picker shows and edits sRGB values,
+// which is what people expect from color pickers, and converts back to linear on change
+func ColorEdit3(label string, c *color.Color) bool {
+
+	r, g, b := c.Srgb()
+	srgb := [3]float32{r, g, b}
+	if !imgui.ColorEdit3V(label, &srgb, imgui.ColorEditFlagsHDR|imgui.ColorEditFlagsFloat) {
+		return false
+	}
+
+	c.Data[0] = color.SrgbToLinear(srgb[0])
+	c.Data[1] = color.SrgbToLinear(srgb[1])
+	c.Data[2] = color.SrgbToLinear(srgb[2])
+	return true
+}
+
+// ColorEdit4 is like ColorEdit3 but also edits alpha, which is the same in both spaces
+func ColorEdit4(label string, c *color.Color) bool {
+
+	r, g, b := c.Srgb()
+	srgba := [4]float32{r, g, b, c.Data[3]}
+	if !imgui.ColorEdit4V(label, &srgba, imgui.ColorEditFlagsHDR|imgui.ColorEditFlagsFloat) {
+		return false
+	}
+
+	c.Data[0] = color.SrgbToLinear(srgba[0])
+	c.Data[1] = color.SrgbToLinear(srgba[1])
+	c.Data[2] = color.SrgbToLinear(srgba[2])
+	c.Data[3] = srgba[3]
+	return true
+}
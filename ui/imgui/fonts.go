@@ -0,0 +1,206 @@
+package nmageimgui
+
+import (
+	"os"
+	"unsafe"
+
+	imgui "github.com/AllenDang/cimgui-go"
+	"github.com/bloeys/nmage/logging"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// DefaultFontSize is the pixel size of the font built into imgui, which is used when no fonts are passed to NewImGui
+const DefaultFontSize = 13
+
+// FontSource describes a font that is loaded into the imgui font atlas. Fonts are rasterized at SizePixels*UIScale,
+// so text stays sharp when the UI scale changes
+type FontSource struct {
+	// Path is a TTF or OTF file. Empty uses the font built into imgui
+	Path string
+
+	// SizePixels is the size at a UI scale of 1. Zero uses DefaultFontSize
+	SizePixels float32
+
+	// MergeIntoPrevious adds the glyphs of this font to the font before it instead of creating a new font,
+	// which is how icon fonts are used along a text font
+	MergeIntoPrevious bool
+
+	// GlyphRanges are pairs of first and last (inclusive) characters to load, like {0xe000, 0xf8ff} for icons.
+	// Empty loads the basic latin and latin supplement characters
+	GlyphRanges []imgui.Wchar
+
+	// GlyphMinAdvanceX makes glyphs at least this wide at a UI scale of 1, which keeps icons monospaced
+	GlyphMinAdvanceX float32
+}
+
+// fontState is shared by all copies of an ImguiInfo, since the engine gets a copy of the one the game keeps
+type fontState struct {
+	sources []FontSource
+
+	// fonts has the font of every source, where merged sources have the font they were merged into
+	fonts []*imgui.Font
+
+	// glyphRanges are in C memory since imgui reads them when building the atlas, long after the fonts were added
+	glyphRanges []imgui.GlyphRange
+
+	scale        float32
+	appliedScale float32
+}
+
+// Font returns the font loaded from fonts[index] of NewImGui, which can be used with imgui.PushFont.
+// Fonts are recreated when the UI scale changes, so the returned font should not be kept between frames
+func (i *ImguiInfo) Font(index int) *imgui.Font {
+	return i.fonts.fonts[index]
+}
+
+func (i *ImguiInfo) FontCount() int {
+	return len(i.fonts.fonts)
+}
+
+// SetUIScale sets how big imgui is drawn, where 1 is the normal size. Fonts are rasterized again at the new size
+// and the sizes of the style (padding, rounding etc.) are scaled.
+//
+// The font atlas can't change during a frame, so the change happens on the next FrameStart
+func (i *ImguiInfo) SetUIScale(scale float32) {
+
+	if scale <= 0 {
+		logging.ErrLog.Printf("Ignoring invalid imgui UI scale of %f\n", scale)
+		return
+	}
+
+	i.fonts.scale = scale
+}
+
+func (i *ImguiInfo) UIScale() float32 {
+	return i.fonts.scale
+}
+
+// applyUIScale rebuilds the font atlas and scales the style if the UI scale changed since the last frame
+func (i *ImguiInfo) applyUIScale() {
+
+	fs := i.fonts
+	if fs.scale == fs.appliedScale {
+		return
+	}
+
+	imgui.CurrentIO().Fonts().Clear()
+	i.addFonts()
+	i.uploadFontTexture()
+
+	// Scaling by the ratio can drift by a pixel after many changes, since imgui truncates the scaled sizes
+	imgui.CurrentStyle().ScaleAllSizes(fs.scale / fs.appliedScale)
+	fs.appliedScale = fs.scale
+}
+
+// addFonts adds the font sources to the atlas at the current UI scale
+func (i *ImguiInfo) addFonts() {
+
+	fs := i.fonts
+	for j := 0; j < len(fs.glyphRanges); j++ {
+		fs.glyphRanges[j].Destroy()
+	}
+	fs.glyphRanges = fs.glyphRanges[:0]
+	fs.fonts = fs.fonts[:0]
+
+	io := imgui.CurrentIO()
+	atlas := io.Fonts()
+
+	// A font default set by the game belonged to the old atlas, so imgui goes back to using the first font
+	io.SetFontDefault(&imgui.Font{})
+
+	sources := fs.sources
+	if len(sources) == 0 {
+		sources = []FontSource{{}}
+	}
+
+	for j := 0; j < len(sources); j++ {
+
+		src := &sources[j]
+
+		size := src.SizePixels
+		if size <= 0 {
+			size = DefaultFontSize
+		}
+
+		cfg := imgui.NewFontConfig()
+		cfg.SetSizePixels(size * fs.scale)
+		cfg.SetGlyphMinAdvanceX(src.GlyphMinAdvanceX * fs.scale)
+
+		// Merging into nothing would crash imgui
+		isMerged := src.MergeIntoPrevious && len(fs.fonts) > 0
+		cfg.SetMergeMode(isMerged)
+		if isMerged {
+			cfg.SetPixelSnapH(true)
+		}
+
+		var ranges *imgui.Wchar
+		if len(src.GlyphRanges) > 0 {
+			ranges = fs.buildGlyphRanges(src.GlyphRanges)
+		}
+
+		path := src.Path
+		if path != "" {
+
+			// Imgui asserts on missing files instead of returning an error
+			if _, err := os.Stat(path); err != nil {
+				logging.ErrLog.Printf("Failed to load imgui font '%s'. Using the default font instead. Err: %v\n", path, err)
+				path = ""
+			}
+		}
+
+		var f *imgui.Font
+		if path == "" {
+			if ranges != nil {
+				cfg.SetGlyphRanges(ranges)
+			}
+			f = atlas.AddFontDefaultV(cfg)
+		} else {
+			f = atlas.AddFontFromFileTTFV(path, size*fs.scale, cfg, ranges)
+		}
+
+		// The atlas keeps a copy of the config
+		cfg.Destroy()
+
+		if isMerged {
+			f = fs.fonts[len(fs.fonts)-1]
+		}
+
+		fs.fonts = append(fs.fonts, f)
+	}
+}
+
+// buildGlyphRanges copies ranges into C memory that stays alive until the fonts are added again
+func (fs *fontState) buildGlyphRanges(ranges []imgui.Wchar) *imgui.Wchar {
+
+	// Imgui wants the ranges zero terminated
+	terminated := make([]imgui.Wchar, len(ranges)+1)
+	copy(terminated, ranges)
+
+	builder := imgui.NewFontGlyphRangesBuilder()
+	builder.AddRanges(&terminated[0])
+
+	gr := imgui.NewGlyphRange()
+	builder.BuildRanges(gr)
+	builder.Destroy()
+
+	fs.glyphRanges = append(fs.glyphRanges, gr)
+	return gr.Data()
+}
+
+// uploadFontTexture builds the font atlas if needed and uploads it to the font texture
+func (i *ImguiInfo) uploadFontTexture() {
+
+	atlas := imgui.CurrentIO().Fonts()
+
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, *i.TexID)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.PixelStorei(gl.UNPACK_ROW_LENGTH, 0)
+
+	pixels, width, height, _ := atlas.TextureDataAsAlpha8()
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RED, int32(width), int32(height), 0, gl.RED, gl.UNSIGNED_BYTE, pixels)
+
+	// Store our identifier
+	atlas.SetTexID(imgui.TextureID{Data: uintptr(unsafe.Pointer(i.TexID))})
+}
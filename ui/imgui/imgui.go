@@ -1,6 +1,7 @@
 package nmageimgui
 
 import (
+	"slices"
 	"unsafe"
 
 	// The following is included just so we can get
@@ -14,6 +15,8 @@ import (
 
 	imgui "github.com/AllenDang/cimgui-go"
 	"github.com/bloeys/gglm/gglm"
+	"github.com/bloeys/nmage/assert"
+	"github.com/bloeys/nmage/leakcheck"
 	"github.com/bloeys/nmage/materials"
 	"github.com/bloeys/nmage/timing"
 	"github.com/go-gl/gl/v4.1-core/gl"
@@ -29,6 +32,21 @@ type ImguiInfo struct {
 	IndexBufID uint32
 	// This is a pointer so we can send a stable pointer to C code
 	TexID *uint32
+
+	shaderPath string
+
+	viewportsEnabled bool
+
+	fonts  *fontState
+	layers *layerState
+
+	// isFontTexLoc is -1 for custom shaders that don't have the IsFontTexture uniform, which then can only draw the font texture
+	isFontTexLoc int32
+}
+
+// TextureId returns the imgui id of a GL texture, so engine textures can be drawn with imgui.Image
+func TextureId(glTexId uint32) imgui.TextureID {
+	return imgui.TextureID{Data: uintptr(glTexId)}
 }
 
 func (i *ImguiInfo) FrameStart(winWidth, winHeight float32) {
@@ -37,31 +55,51 @@ func (i *ImguiInfo) FrameStart(winWidth, winHeight float32) {
 	// 	assert.T(false, "Setting imgui ctx as current failed. Err: "+err.Error())
 	// }
 
+	i.applyUIScale()
+
+	if i.viewportsEnabled {
+		i.viewportsNewFrame()
+	}
+
 	imIO := imgui.CurrentIO()
 	imIO.SetDisplaySize(imgui.Vec2{X: float32(winWidth), Y: float32(winHeight)})
-	imIO.SetDeltaTime(timing.DT())
+	imIO.SetDeltaTime(timing.UnscaledDT())
 
 	imgui.NewFrame()
+	i.layers.isFrameEnded = false
 }
 
+// Render ends the imgui frame and draws it to the bound framebuffer, ignoring the layer (see RenderLayer).
+// The window size is taken from the draw data, so winWidth and winHeight are only kept for existing callers
 func (i *ImguiInfo) Render(winWidth, winHeight float32, fbWidth, fbHeight int32) {
 
 	// if err := i.ImCtx.SetCurrent(); err != nil {
 	// 	assert.T(false, "Setting imgui ctx as current failed. Err: "+err.Error())
 	// }
 
-	imgui.Render()
+	i.endFrame()
 
 	// Avoid rendering when minimized, scale coordinates for retina displays (screen coordinates != framebuffer coordinates)
 	if fbWidth <= 0 || fbHeight <= 0 {
 		return
 	}
 
-	drawData := imgui.CurrentDrawData()
-	drawData.ScaleClipRects(imgui.Vec2{
-		X: float32(fbWidth) / float32(winWidth),
-		Y: float32(fbHeight) / float32(winHeight),
-	})
+	i.renderDrawData(imgui.CurrentDrawData(), fbWidth, fbHeight)
+}
+
+// renderDrawData draws to the current framebuffer, which is the main window or a viewport window (see RenderViewports)
+func (i *ImguiInfo) renderDrawData(drawData *imgui.DrawData, fbWidth, fbHeight int32) {
+
+	// With viewports enabled positions are in OS screen coordinates, so everything is offset by the position of the viewport.
+	// Scale coordinates for retina displays (screen coordinates != framebuffer coordinates)
+	displayPos := drawData.DisplayPos()
+	displaySize := drawData.DisplaySize()
+	if displaySize.X <= 0 || displaySize.Y <= 0 {
+		return
+	}
+
+	fbScaleX := float32(fbWidth) / displaySize.X
+	fbScaleY := float32(fbHeight) / displaySize.Y
 
 	// Setup render state: alpha-blending enabled, no face culling, no depth testing, scissor enabled, polygon fill
 	gl.Enable(gl.BLEND)
@@ -80,7 +118,7 @@ func (i *ImguiInfo) Render(winWidth, winHeight float32, fbWidth, fbHeight int32)
 	i.Mat.SetUnifInt32("Texture", 0)
 
 	// @PERF: only update the ortho matrix on window resize
-	orthoMat := gglm.Ortho(0, float32(winWidth), 0, float32(winHeight), 0, 20)
+	orthoMat := gglm.Ortho(displayPos.X, displayPos.X+displaySize.X, displayPos.Y, displayPos.Y+displaySize.Y, 0, 20)
 	i.Mat.SetUnifMat4("ProjMtx", &orthoMat.Mat4)
 	gl.BindSampler(0, 0) // Rely on combined texture/sampler state.
 
@@ -104,6 +142,12 @@ func (i *ImguiInfo) Render(winWidth, winHeight float32, fbWidth, fbHeight int32)
 		drawType = gl.UNSIGNED_INT
 	}
 
+	fontTexId := imgui.TextureID{Data: uintptr(unsafe.Pointer(i.TexID))}
+	lastIsFontTex := true
+	if i.isFontTexLoc != -1 {
+		gl.ProgramUniform1i(i.Mat.ShaderProg.Id, i.isFontTexLoc, 1)
+	}
+
 	// Draw
 	for _, list := range drawData.CommandLists() {
 
@@ -120,10 +164,32 @@ func (i *ImguiInfo) Render(winWidth, winHeight float32, fbWidth, fbHeight int32)
 				cmd.CallUserCallback(list)
 			} else {
 
+				// The font texture id is a pointer to TexID, while other ids are GL texture ids (see TextureId)
+				texId := cmd.TexID()
+				isFontTex := texId == fontTexId || i.isFontTexLoc == -1
+
 				gl.ActiveTexture(gl.TEXTURE0)
-				gl.BindTexture(gl.TEXTURE_2D, *i.TexID)
+				if isFontTex {
+					gl.BindTexture(gl.TEXTURE_2D, *i.TexID)
+				} else {
+					gl.BindTexture(gl.TEXTURE_2D, uint32(texId.Data))
+				}
+
+				if i.isFontTexLoc != -1 && isFontTex != lastIsFontTex {
+					lastIsFontTex = isFontTex
+					gl.ProgramUniform1i(i.Mat.ShaderProg.Id, i.isFontTexLoc, boolToInt32(isFontTex))
+				}
+
 				clipRect := cmd.ClipRect()
-				gl.Scissor(int32(clipRect.X), int32(fbHeight)-int32(clipRect.W), int32(clipRect.Z-clipRect.X), int32(clipRect.W-clipRect.Y))
+				clipMinX := (clipRect.X - displayPos.X) * fbScaleX
+				clipMinY := (clipRect.Y - displayPos.Y) * fbScaleY
+				clipMaxX := (clipRect.Z - displayPos.X) * fbScaleX
+				clipMaxY := (clipRect.W - displayPos.Y) * fbScaleY
+				if clipMaxX <= clipMinX || clipMaxY <= clipMinY {
+					continue
+				}
+
+				gl.Scissor(int32(clipMinX), fbHeight-int32(clipMaxY), int32(clipMaxX-clipMinX), int32(clipMaxY-clipMinY))
 
 				gl.DrawElementsBaseVertexWithOffset(gl.TRIANGLES, int32(cmd.ElemCount()), uint32(drawType), uintptr(int(cmd.IdxOffset())*indexSize), int32(cmd.VtxOffset()))
 			}
@@ -136,6 +202,8 @@ func (i *ImguiInfo) Render(winWidth, winHeight float32, fbWidth, fbHeight int32)
 	gl.Enable(gl.DEPTH_TEST)
 }
 
+// AddFontTTF adds a font to the atlas right away. Fonts added this way are lost when the UI scale changes,
+// so fonts that should follow the UI scale should be passed to NewImGui instead
 func (i *ImguiInfo) AddFontTTF(fontPath string, fontSize float32, fontConfig *imgui.FontConfig, glyphRanges *imgui.GlyphRange) imgui.Font {
 
 	fontConfigToUse := imgui.NewFontConfig()
@@ -200,6 +268,9 @@ void main()
 
 uniform sampler2D Texture;
 
+// The font texture only has a red channel used as alpha, while other textures (e.g. asset thumbnails) are drawn as they are
+uniform int IsFontTexture;
+
 in vec2 Frag_UV;
 in vec4 Frag_Color;
 
@@ -207,58 +278,72 @@ out vec4 Out_Color;
 
 void main()
 {
-    Out_Color = vec4(Frag_Color.rgb, Frag_Color.a * texture(Texture, Frag_UV.st).r);
+    if (IsFontTexture == 1)
+        Out_Color = vec4(Frag_Color.rgb, Frag_Color.a * texture(Texture, Frag_UV.st).r);
+    else
+        Out_Color = Frag_Color * texture(Texture, Frag_UV.st);
 }
 `
 
-// NewImGui setups imgui using the passed shader.
-// If the path is empty a default nMage shader is used
-func NewImGui(shaderPath string) ImguiInfo {
-
-	var imguiMat materials.Material
-	if shaderPath == "" {
-		imguiMat = materials.NewMaterialSrc("ImGUI Mat", []byte(DefaultImguiShader))
-	} else {
-		imguiMat = materials.NewMaterial("ImGUI Mat", shaderPath)
-	}
+// NewImGui setups imgui using the passed shader and fonts, where the first font is the default one.
+// If the path is empty a default nMage shader is used, and if no fonts are passed the font built into imgui is used
+func NewImGui(shaderPath string, fonts ...FontSource) ImguiInfo {
 
 	imguiInfo := ImguiInfo{
-		ImCtx: *imgui.CreateContext(),
-		Mat:   imguiMat,
-		TexID: new(uint32),
+		ImCtx:      *imgui.CreateContext(),
+		TexID:      new(uint32),
+		shaderPath: shaderPath,
+		fonts: &fontState{
+			sources:      slices.Clone(fonts),
+			scale:        1,
+			appliedScale: 1,
+		},
+		layers: &layerState{},
 	}
 
 	io := imgui.CurrentIO()
 	io.SetConfigFlags(io.ConfigFlags() | imgui.ConfigFlagsDockingEnable)
 	io.SetBackendFlags(io.BackendFlags() | imgui.BackendFlagsRendererHasVtxOffset)
 
-	gl.GenVertexArrays(1, &imguiInfo.VaoID)
-	gl.GenBuffers(1, &imguiInfo.VboID)
-	gl.GenBuffers(1, &imguiInfo.IndexBufID)
-	gl.GenTextures(1, imguiInfo.TexID)
+	imguiInfo.addFonts()
+	imguiInfo.createDeviceObjects()
+	return imguiInfo
+}
 
-	// Upload font to gpu
-	gl.ActiveTexture(gl.TEXTURE0)
-	gl.BindTexture(gl.TEXTURE_2D, *imguiInfo.TexID)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
-	gl.PixelStorei(gl.UNPACK_ROW_LENGTH, 0)
+// RecreateDeviceObjects recreates the material, buffers and font texture of imgui after the GL context was lost.
+// The old objects went away with the old context, so they aren't deleted
+func (i *ImguiInfo) RecreateDeviceObjects() {
+	i.createDeviceObjects()
+}
 
-	pixels, width, height, _ := io.Fonts().TextureDataAsAlpha8()
-	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RED, int32(width), int32(height), 0, gl.RED, gl.UNSIGNED_BYTE, pixels)
+func (i *ImguiInfo) createDeviceObjects() {
 
-	// Store our identifier
+	if i.shaderPath == "" {
+		i.Mat = assert.MustGet(materials.NewMaterialSrc("ImGUI Mat", []byte(DefaultImguiShader)))
+	} else {
+		i.Mat = assert.MustGet(materials.NewMaterial("ImGUI Mat", i.shaderPath))
+	}
 
-	io.Fonts().SetTexID(imgui.TextureID{Data: uintptr(unsafe.Pointer(imguiInfo.TexID))})
+	i.isFontTexLoc = gl.GetUniformLocation(i.Mat.ShaderProg.Id, gl.Str("IsFontTexture\x00"))
 
-	//Shader attributes
-	imguiInfo.Mat.Bind()
-	imguiInfo.Mat.EnableAttribute("Position")
-	imguiInfo.Mat.EnableAttribute("UV")
-	imguiInfo.Mat.EnableAttribute("Color")
-	imguiInfo.Mat.UnBind()
+	gl.GenVertexArrays(1, &i.VaoID)
+	leakcheck.Track(leakcheck.ResourceType_VertexArray, i.VaoID)
+	gl.GenBuffers(1, &i.VboID)
+	leakcheck.Track(leakcheck.ResourceType_Buffer, i.VboID)
+	gl.GenBuffers(1, &i.IndexBufID)
+	leakcheck.Track(leakcheck.ResourceType_Buffer, i.IndexBufID)
+	gl.GenTextures(1, i.TexID)
+	leakcheck.Track(leakcheck.ResourceType_Texture, *i.TexID)
 
-	return imguiInfo
+	// Upload font to gpu
+	i.uploadFontTexture()
+
+	//Shader attributes
+	i.Mat.Bind()
+	i.Mat.EnableAttribute("Position")
+	i.Mat.EnableAttribute("UV")
+	i.Mat.EnableAttribute("Color")
+	i.Mat.UnBind()
 }
 
 func SdlScancodeToImGuiKey(scancode sdl.Scancode) imgui.Key {
@@ -478,3 +563,10 @@ func SdlScancodeToImGuiKey(scancode sdl.Scancode) imgui.Key {
 		return imgui.KeyNone
 	}
 }
+
+func boolToInt32(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,110 @@
+package nmageimgui
+
+import (
+	imgui "github.com/AllenDang/cimgui-go"
+	"github.com/bloeys/nmage/buffers"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// UILayer is the point of the frame imgui is drawn at
+type UILayer int32
+
+const (
+	// UILayer_Overlay draws imgui after Game.Render, on top of everything, to the target framebuffer (see SetTarget)
+	UILayer_Overlay UILayer = iota
+
+	// UILayer_Manual lets the game draw imgui with RenderToFramebuffer at any point of Game.Render, like before post processing
+	// or into a texture shown in the world. Imgui isn't drawn if the game doesn't render it
+	UILayer_Manual
+)
+
+func (l UILayer) String() string {
+	switch l {
+	case UILayer_Overlay:
+		return "Overlay"
+	case UILayer_Manual:
+		return "Manual"
+	default:
+		return "Unknown"
+	}
+}
+
+// layerState is shared by all copies of an ImguiInfo, so the engine sees layer changes and renders done by the game
+type layerState struct {
+	layer UILayer
+
+	// target is nil when drawing to the window
+	target *buffers.Framebuffer
+
+	isFrameEnded bool
+}
+
+func (i *ImguiInfo) SetLayer(l UILayer) {
+	i.layers.layer = l
+}
+
+func (i *ImguiInfo) Layer() UILayer {
+	return i.layers.layer
+}
+
+// SetTarget sets the framebuffer UILayer_Overlay draws to. Nil draws to the window
+func (i *ImguiInfo) SetTarget(fbo *buffers.Framebuffer) {
+	i.layers.target = fbo
+}
+
+func (i *ImguiInfo) Target() *buffers.Framebuffer {
+	return i.layers.target
+}
+
+// RenderToFramebuffer ends the imgui frame and draws it to fbo, then binds the framebuffer and viewport that were bound before.
+//
+// Windows submitted after the first render of a frame aren't drawn until the next frame, so this should be called after
+// all imgui windows of the frame were submitted. Calling it again in the same frame draws the same UI again, e.g. to another framebuffer
+func (i *ImguiInfo) RenderToFramebuffer(fbo *buffers.Framebuffer) {
+
+	i.endFrame()
+
+	var oldFbo int32
+	var oldViewport [4]int32
+	gl.GetIntegerv(gl.DRAW_FRAMEBUFFER_BINDING, &oldFbo)
+	gl.GetIntegerv(gl.VIEWPORT, &oldViewport[0])
+
+	fbo.BindWithViewport()
+	i.renderDrawData(imgui.CurrentDrawData(), int32(fbo.Width), int32(fbo.Height))
+
+	gl.BindFramebuffer(gl.FRAMEBUFFER, uint32(oldFbo))
+	gl.Viewport(oldViewport[0], oldViewport[1], oldViewport[2], oldViewport[3])
+}
+
+// RenderLayer is called by the engine after Game.Render. It draws imgui to the target for UILayer_Overlay,
+// and ends the imgui frame without drawing for UILayer_Manual
+func (i *ImguiInfo) RenderLayer(fbWidth, fbHeight int32) {
+
+	switch i.layers.layer {
+	case UILayer_Overlay:
+
+		if i.layers.target != nil {
+			i.RenderToFramebuffer(i.layers.target)
+			return
+		}
+
+		i.endFrame()
+		if fbWidth > 0 && fbHeight > 0 {
+			i.renderDrawData(imgui.CurrentDrawData(), fbWidth, fbHeight)
+		}
+
+	case UILayer_Manual:
+		i.endFrame()
+	}
+}
+
+// endFrame ends the imgui frame if it wasn't already ended this frame
+func (i *ImguiInfo) endFrame() {
+
+	if i.layers.isFrameEnded {
+		return
+	}
+
+	imgui.Render()
+	i.layers.isFrameEnded = true
+}
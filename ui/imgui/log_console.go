@@ -0,0 +1,78 @@
+package nmageimgui
+
+import (
+	imgui "github.com/AllenDang/cimgui-go"
+	"github.com/bloeys/nmage/logging"
+)
+
+// LogConsole is an in-game window that shows the entries of a logging.MemorySink
+type LogConsole struct {
+	Sink       *logging.MemorySink
+	AutoScroll bool
+
+	entries     []logging.Entry
+	lines       []string
+	lastVersion uint64
+}
+
+// Draw shows the console window. Must be called between FrameStart and Render
+func (c *LogConsole) Draw(title string) {
+
+	// Only copy and format entries when something new was logged
+	version := c.Sink.Version()
+	if version != c.lastVersion {
+
+		c.lastVersion = version
+		c.entries = c.Sink.Entries()
+
+		c.lines = c.lines[:0]
+		for i := 0; i < len(c.entries); i++ {
+			c.lines = append(c.lines, c.entries[i].String())
+		}
+	}
+
+	imgui.Begin(title)
+
+	if imgui.Button("Clear") {
+		c.Sink.Clear()
+	}
+	imgui.SameLine()
+	imgui.Checkbox("Auto-scroll", &c.AutoScroll)
+	imgui.Separator()
+
+	imgui.BeginChildStr("log_console_scroll")
+	for i := 0; i < len(c.lines); i++ {
+
+		imgui.PushStyleColorVec4(imgui.ColText, logLevelColor(c.entries[i].Level))
+		imgui.TextUnformatted(c.lines[i])
+		imgui.PopStyleColor()
+	}
+
+	if c.AutoScroll && imgui.ScrollY() >= imgui.ScrollMaxY() {
+		imgui.SetScrollHereYV(1)
+	}
+	imgui.EndChild()
+
+	imgui.End()
+}
+
+func logLevelColor(l logging.Level) imgui.Vec4 {
+
+	switch l {
+	case logging.Level_Debug:
+		return imgui.Vec4{X: 0.6, Y: 0.6, Z: 0.6, W: 1}
+	case logging.Level_Warn:
+		return imgui.Vec4{X: 1, Y: 0.8, Z: 0.2, W: 1}
+	case logging.Level_Err:
+		return imgui.Vec4{X: 1, Y: 0.3, Z: 0.3, W: 1}
+	default:
+		return imgui.Vec4{X: 1, Y: 1, Z: 1, W: 1}
+	}
+}
+
+func NewLogConsole(sink *logging.MemorySink) LogConsole {
+	return LogConsole{
+		Sink:       sink,
+		AutoScroll: true,
+	}
+}
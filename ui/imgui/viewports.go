@@ -0,0 +1,178 @@
+package nmageimgui
+
+/*
+#include <stdbool.h>
+#include <stdint.h>
+
+// These are implemented by cimgui-go (the sdl2 platform backend and struct accessors of cimgui), which is linked into the final binary.
+// They are weak so this package links on its own, since cgo links every package separately before the final link
+typedef struct ImGuiPlatformIO ImGuiPlatformIO;
+typedef struct ImGuiViewport ImGuiViewport;
+typedef struct { int Size; int Capacity; ImGuiViewport **Data; } nmageViewportVector;
+
+extern bool ImGui_ImplSDL2_InitForOpenGL(void *window, void *sdlGlContext) __attribute__((weak));
+extern void ImGui_ImplSDL2_Shutdown(void) __attribute__((weak));
+extern void ImGui_ImplSDL2_NewFrame(void) __attribute__((weak));
+extern ImGuiPlatformIO *igGetPlatformIO(void) __attribute__((weak));
+extern nmageViewportVector wrap_ImGuiPlatformIO_GetViewports(ImGuiPlatformIO *self) __attribute__((weak));
+extern unsigned int wrap_ImGuiViewport_GetID(ImGuiViewport *self) __attribute__((weak));
+
+// Platform handles of the sdl2 backend are SDL_Window pointers stored as integers
+static void *nmagePlatformHandleToWindow(uintptr_t handle) { return (void *)handle; }
+
+static bool nmageHasSdl2Backend() { return ImGui_ImplSDL2_InitForOpenGL != NULL && wrap_ImGuiPlatformIO_GetViewports != NULL; }
+
+// nmageGetViewportIds writes the ids of up to maxIds platform viewports, skipping the main viewport, and returns how many were written
+static int nmageGetViewportIds(unsigned int *ids, int maxIds)
+{
+    nmageViewportVector viewports = wrap_ImGuiPlatformIO_GetViewports(igGetPlatformIO());
+
+    int count = 0;
+    for (int i = 1; i < viewports.Size && count < maxIds; i++)
+        ids[count++] = wrap_ImGuiViewport_GetID(viewports.Data[i]);
+
+    return count;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+
+	imgui "github.com/AllenDang/cimgui-go"
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// maxViewports is how many imgui windows can be outside the main window at once
+const maxViewports = 64
+
+var clearColor = [4]float32{0, 0, 0, 1}
+
+// EnableViewports lets imgui windows be dragged out of the main window into their own OS windows, which can be on other displays.
+//
+// The imgui sdl2 backend creates and moves the OS windows, while the windows are drawn by RenderViewports using the main GL context.
+// Calling it again (e.g. after the GL context was recreated) restarts the backend with the new context
+func (i *ImguiInfo) EnableViewports(win *sdl.Window, glCtx sdl.GLContext) error {
+
+	if !C.nmageHasSdl2Backend() {
+		return fmt.Errorf("failed to enable imgui viewports because the imgui sdl2 backend isn't linked")
+	}
+
+	if i.viewportsEnabled {
+		imgui.DestroyPlatformWindows()
+		C.ImGui_ImplSDL2_Shutdown()
+	}
+
+	if !C.ImGui_ImplSDL2_InitForOpenGL(unsafe.Pointer(win), unsafe.Pointer(glCtx)) {
+		return fmt.Errorf("failed to init imgui sdl2 backend for viewports")
+	}
+
+	io := imgui.CurrentIO()
+	io.SetConfigFlags(io.ConfigFlags() | imgui.ConfigFlagsViewportsEnable)
+	io.SetBackendFlags(io.BackendFlags() | imgui.BackendFlagsRendererHasViewports)
+
+	i.viewportsEnabled = true
+	return nil
+}
+
+// DisableViewports closes the OS windows of imgui windows and moves them back into the main window
+func (i *ImguiInfo) DisableViewports() {
+
+	if !i.viewportsEnabled {
+		return
+	}
+
+	imgui.DestroyPlatformWindows()
+	C.ImGui_ImplSDL2_Shutdown()
+
+	io := imgui.CurrentIO()
+	io.SetConfigFlags(io.ConfigFlags() &^ imgui.ConfigFlagsViewportsEnable)
+	io.SetBackendFlags(io.BackendFlags() &^ imgui.BackendFlagsRendererHasViewports)
+
+	i.viewportsEnabled = false
+}
+
+func (i *ImguiInfo) ViewportsEnabled() bool {
+	return i.viewportsEnabled
+}
+
+// RenderViewports creates, moves and draws the OS windows of imgui windows that are outside the main window. Must be called after Render
+// and before swapping the main window, and leaves the main window current.
+//
+// Viewport windows are drawn with the main context, since VAOs aren't shared between contexts
+func (i *ImguiInfo) RenderViewports(mainWin *sdl.Window, glCtx sdl.GLContext) {
+
+	if !i.viewportsEnabled {
+		return
+	}
+
+	imgui.UpdatePlatformWindows()
+
+	var ids [maxViewports]C.uint
+	count := int(C.nmageGetViewportIds(&ids[0], maxViewports))
+	if count == 0 {
+		return
+	}
+
+	for j := 0; j < count; j++ {
+
+		vp := imgui.FindViewportByID(imgui.ID(ids[j]))
+		if vp.Flags()&imgui.ViewportFlagsIsMinimized != 0 || vp.PlatformHandle() == 0 {
+			continue
+		}
+
+		win := (*sdl.Window)(C.nmagePlatformHandleToWindow(C.uintptr_t(vp.PlatformHandle())))
+		if err := win.GLMakeCurrent(glCtx); err != nil {
+			continue
+		}
+
+		fbWidth, fbHeight := win.GLGetDrawableSize()
+		gl.Viewport(0, 0, fbWidth, fbHeight)
+
+		// ClearBuffer doesn't change the clear color of the game
+		if vp.Flags()&imgui.ViewportFlagsNoRendererClear == 0 {
+			gl.ClearBufferfv(gl.COLOR, 0, &clearColor[0])
+		}
+
+		i.renderDrawData(vp.DrawData(), fbWidth, fbHeight)
+		win.GLSwap()
+	}
+
+	mainWin.GLMakeCurrent(glCtx)
+
+	fbWidth, fbHeight := mainWin.GLGetDrawableSize()
+	gl.Viewport(0, 0, fbWidth, fbHeight)
+}
+
+// HandleViewportWindowEvent passes OS requests like closing or moving a viewport window to imgui, and returns false if the event
+// isn't for a viewport window
+func HandleViewportWindowEvent(e *sdl.WindowEvent) bool {
+
+	win, err := sdl.GetWindowFromID(e.WindowID)
+	if err != nil {
+		return false
+	}
+
+	vp := imgui.FindViewportByPlatformHandle(uintptr(unsafe.Pointer(win)))
+	if vp.CData == nil || vp.ID() == imgui.MainViewport().ID() {
+		return false
+	}
+
+	switch e.Event {
+	case sdl.WINDOWEVENT_CLOSE:
+		vp.SetPlatformRequestClose(true)
+	case sdl.WINDOWEVENT_MOVED:
+		vp.SetPlatformRequestMove(true)
+	case sdl.WINDOWEVENT_RESIZED:
+		vp.SetPlatformRequestResize(true)
+	}
+
+	return true
+}
+
+// viewportsNewFrame lets the sdl2 backend update the mouse (in global coordinates), monitors and display size
+func (i *ImguiInfo) viewportsNewFrame() {
+	C.ImGui_ImplSDL2_NewFrame()
+}